@@ -30,16 +30,28 @@ type Config struct {
 	Dir         string
 	MaxClients  int
 	MaxMemory   uint64
-	AppendFsync bool
+	AppendFsync string // always、everysec 或 no
 	AppendOnly  bool
 	GoPool      bool
 	GoPoolSize  int
 	GoPoolSpawn int
 	RDBFile     string
 
+	// AOF 重写配置
+	AutoAOFRewritePercentage int
+	AutoAOFRewriteMinSize    int64
+
 	// 集群配置
 	ClusterEnable bool
 	ClusterName   string
+	// publish 命令是否广播到集群中的所有节点
+	ClusterPublishBroadcast bool
+	// 集群信息的发布方式，etcd 或者 gossip
+	ClusterBus string
+	// gossip 模式下集群配置文件的路径，etcd 模式下配置保存在 etcd 中
+	ClusterConfigFile string
+	// 节点超过该时间（毫秒）没有回复时被视为疑似下线
+	ClusterNodeTimeout int
 
 	// 键置换配置
 	Eviction string
@@ -48,6 +60,24 @@ type Config struct {
 	SlowLogSlowerThan int64
 
 	ACLFile string
+
+	// 键空间通知配置，格式与 redis 一致
+	NotifyKeyspaceEvents string
+
+	// 是否以哨兵模式运行，由启动参数 --sentinel 指定
+	Sentinel bool
+	// 哨兵模式下监控的主节点
+	SentinelMasters []SentinelMaster
+}
+
+// SentinelMaster 是哨兵模式下监控的一个主节点，对应配置文件中的 sentinel monitor 等选项
+type SentinelMaster struct {
+	Name            string
+	Host            string
+	Port            int
+	Quorum          int // 判定主节点客观下线所需的哨兵数量
+	DownAfter       int // 实例超过该时间（毫秒）没有回复时被视为主观下线
+	FailoverTimeout int // 故障转移的超时时间（毫秒）
 }
 
 // Conf 变量存储从配置文件读取到的配置，如果配置不存在则使用默认配置
@@ -140,7 +170,9 @@ func (cfg *Config) parseFile() error {
 
 			} else if cfgName == "loglevel" {
 
-				cfg.LogLevel = strings.ToLower(fields[1])
+				if err := cfg.setLogLevel(fields[1]); err != nil {
+					return err
+				}
 
 			} else if cfgName == "databases" {
 
@@ -151,11 +183,10 @@ func (cfg *Config) parseFile() error {
 				cfg.DataBases = databases
 
 			} else if cfgName == "timeout" {
-				timeout, err := strconv.Atoi(fields[1])
-				if err != nil {
+
+				if err := cfg.setTimeout(fields[1]); err != nil {
 					return err
 				}
-				cfg.Timeout = timeout
 
 			} else if cfgName == "daemonize" {
 
@@ -178,17 +209,17 @@ func (cfg *Config) parseFile() error {
 				cfg.MaxClients = maxclients
 
 			} else if cfgName == "maxmemory" {
-				maxmemory, err := strconv.Atoi(fields[1])
-				if err != nil {
+
+				if err := cfg.setMaxMemory(fields[1]); err != nil {
 					return err
 				}
-				if maxmemory <= 0 {
-					return &Error{"maxmemory < 0"}
-				}
-				cfg.MaxMemory = uint64(maxmemory)
 
 			} else if cfgName == "appendfsync" {
 
+				if err := cfg.setAppendFsync(fields[1]); err != nil {
+					return err
+				}
+
 			} else if cfgName == "appendonly" {
 
 				appendonly, err := strconv.ParseBool(fields[1])
@@ -197,6 +228,28 @@ func (cfg *Config) parseFile() error {
 				}
 				cfg.AppendOnly = appendonly
 
+			} else if cfgName == "auto-aof-rewrite-percentage" {
+
+				percentage, err := strconv.Atoi(fields[1])
+				if err != nil {
+					return err
+				}
+				if percentage < 0 {
+					return &Error{"auto-aof-rewrite-percentage < 0"}
+				}
+				cfg.AutoAOFRewritePercentage = percentage
+
+			} else if cfgName == "auto-aof-rewrite-min-size" {
+
+				minSize, err := strconv.ParseInt(fields[1], 10, 64)
+				if err != nil {
+					return err
+				}
+				if minSize < 0 {
+					return &Error{"auto-aof-rewrite-min-size < 0"}
+				}
+				cfg.AutoAOFRewriteMinSize = minSize
+
 			} else if cfgName == "gopool" {
 
 				gopool, err := strconv.ParseBool(fields[1])
@@ -253,28 +306,67 @@ func (cfg *Config) parseFile() error {
 
 				cfg.ClusterName = strings.ToLower(fields[1])
 
+			} else if cfgName == "cluster-publish-broadcast" {
+
+				broadcast, err := strconv.ParseBool(fields[1])
+				if err != nil {
+					return err
+				}
+				cfg.ClusterPublishBroadcast = broadcast
+
+			} else if cfgName == "cluster-bus" {
+
+				bus := strings.ToLower(fields[1])
+				if bus != "etcd" && bus != "gossip" {
+					return &Error{"cluster-bus should be one of etcd, gossip"}
+				}
+				cfg.ClusterBus = bus
+
+			} else if cfgName == "cluster-config-file" {
+
+				cfg.ClusterConfigFile = fields[1]
+
+			} else if cfgName == "cluster-node-timeout" {
+
+				timeout, err := strconv.Atoi(fields[1])
+				if err != nil {
+					return err
+				}
+				if timeout <= 0 {
+					return &Error{"cluster-node-timeout <= 0"}
+				}
+				cfg.ClusterNodeTimeout = timeout
+
 			} else if cfgName == "eviction" {
 
-				cfg.ClusterName = strings.ToLower(fields[1])
+				if err := cfg.setEviction(fields[1]); err != nil {
+					return err
+				}
 
 			} else if cfgName == "slowlog-log-slower-than" {
 
-				slow, err := strconv.Atoi(fields[1])
-				if err != nil {
+				if err := cfg.setSlowLogSlowerThan(fields[1]); err != nil {
 					return err
 				}
-				cfg.SlowLogSlowerThan = int64(slow)
 
 			} else if cfgName == "slowlog-max-len" {
 
-				max, err := strconv.Atoi(fields[1])
-				if err != nil {
+				if err := cfg.setSlowLogMaxLen(fields[1]); err != nil {
 					return err
 				}
-				cfg.SlowLogMaxLen = max
 			} else if cfgName == "aclfile" {
 
 				cfg.ACLFile = fields[1]
+
+			} else if cfgName == "notify-keyspace-events" {
+
+				cfg.NotifyKeyspaceEvents = strings.Trim(fields[1], "\"")
+
+			} else if cfgName == "sentinel" {
+
+				if err := cfg.parseSentinel(fields[1:]); err != nil {
+					return err
+				}
 			}
 
 		}
@@ -285,6 +377,71 @@ func (cfg *Config) parseFile() error {
 	return nil
 }
 
+// parseSentinel 解析哨兵选项：sentinel monitor <name> <ip> <port> <quorum>、
+// sentinel down-after-milliseconds <name> <ms> 以及 sentinel failover-timeout <name> <ms>
+func (cfg *Config) parseSentinel(fields []string) error {
+
+	option := strings.ToLower(fields[0])
+
+	if option == "monitor" {
+		if len(fields) != 5 {
+			return &Error{"sentinel monitor <name> <ip> <port> <quorum>"}
+		}
+		port, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return err
+		}
+		quorum, err := strconv.Atoi(fields[4])
+		if err != nil {
+			return err
+		}
+		if quorum <= 0 {
+			return &Error{"Quorum must be 1 or greater."}
+		}
+		master := SentinelMaster{Name: fields[1], Host: fields[2], Port: port, Quorum: quorum,
+			DownAfter: defaultSentinelDownAfter, FailoverTimeout: defaultSentinelFailoverTimeout}
+
+		// 配置文件重新载入时 cfg 与旧配置共享底层数组，需要复制后再修改
+		masters := make([]SentinelMaster, 0, len(cfg.SentinelMasters)+1)
+		for _, m := range cfg.SentinelMasters {
+			if m.Name != master.Name {
+				masters = append(masters, m)
+			}
+		}
+		cfg.SentinelMasters = append(masters, master)
+		return nil
+	}
+
+	if option != "down-after-milliseconds" && option != "failover-timeout" {
+		return &Error{fmt.Sprintf("Unknown sentinel option '%s'", fields[0])}
+	}
+	if len(fields) != 3 {
+		return &Error{fmt.Sprintf("sentinel %s <name> <milliseconds>", option)}
+	}
+	ms, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return err
+	}
+	if ms <= 0 {
+		return &Error{fmt.Sprintf("sentinel %s <= 0", option)}
+	}
+
+	masters := append([]SentinelMaster{}, cfg.SentinelMasters...)
+	for i := range masters {
+		if masters[i].Name != fields[1] {
+			continue
+		}
+		if option == "down-after-milliseconds" {
+			masters[i].DownAfter = ms
+		} else {
+			masters[i].FailoverTimeout = ms
+		}
+		cfg.SentinelMasters = masters
+		return nil
+	}
+	return &Error{"No such master with specified name."}
+}
+
 func (cfg *Config) parseFlags() {
 
 	for i := 0; i < len(os.Args); i++ {
@@ -327,11 +484,21 @@ func (cfg *Config) parseFlags() {
 		} else if os.Args[i] == "--watch-config" {
 
 			confWatcherEnabled = true
+
+		} else if os.Args[i] == "--sentinel" {
+
+			Conf.Sentinel = true
 		}
 
 	}
 }
 
+// 哨兵选项的默认值，与 redis 相同
+const (
+	defaultSentinelDownAfter       = 30000
+	defaultSentinelFailoverTimeout = 180000
+)
+
 // defaultConf 是默认配置
 var defaultConf = Config{
 	ConfFile:    "",
@@ -345,8 +512,8 @@ var defaultConf = Config{
 	Timeout:     300,
 	Daemonize:   false,
 	Dir:         "./",
-	MaxMemory:   1<<64 - 1,
-	AppendFsync: true,
+	MaxMemory:   unlimitedMemory,
+	AppendFsync: "everysec",
 	AppendOnly:  true,
 	GoPool:      true,
 	GoPoolSize:  10000,
@@ -354,8 +521,14 @@ var defaultConf = Config{
 	RDBFile:     "dump.rdb",
 	MaxClients:  -1,
 
-	ClusterEnable: false,
-	ClusterName:   "",
+	AutoAOFRewritePercentage: 100,
+	AutoAOFRewriteMinSize:    64 << 20, // 64 MB
+
+	ClusterEnable:      false,
+	ClusterName:        "",
+	ClusterBus:         "etcd",
+	ClusterConfigFile:  "conf/cluster_config.json",
+	ClusterNodeTimeout: 15000,
 
 	Eviction: "no",
 
@@ -389,6 +562,18 @@ func init() {
 		panic(fmt.Sprintf("Err empty clustername"))
 	}
 
+	// check sentinel options
+	if Conf.Sentinel && Conf.ClusterEnable {
+		panic(fmt.Sprintf("Err sentinel mode can't be used with cluster"))
+	}
+	if Conf.Sentinel && len(Conf.SentinelMasters) == 0 {
+		panic(fmt.Sprintf("Err sentinel mode requires at least one 'sentinel monitor'"))
+	}
+	// 哨兵不保存任何数据
+	if Conf.Sentinel {
+		Conf.AppendOnly = false
+	}
+
 	// check go pool
 	if Conf.GoPoolSize < Conf.GoPoolSpawn {
 		panic(fmt.Sprintf("Err gopoolsize < gopoolspawn"))
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestParseSentinel(t *testing.T) {
+
+	cfg := defaultConf
+	cfg.ConfFile = path.Join(t.TempDir(), "sentinel.conf")
+	assert.Nil(t, os.WriteFile(cfg.ConfFile, []byte("port 26379\n"+
+		"sentinel monitor mymaster 127.0.0.1 6379 2\n"+
+		"sentinel down-after-milliseconds mymaster 5000\n"+
+		"sentinel monitor other 127.0.0.1 6479 1\n"+
+		"sentinel failover-timeout other 10000\n"), 0644))
+
+	assert.Nil(t, cfg.parseFile())
+	assert.Equal(t, []SentinelMaster{
+		{Name: "mymaster", Host: "127.0.0.1", Port: 6379, Quorum: 2, DownAfter: 5000, FailoverTimeout: defaultSentinelFailoverTimeout},
+		{Name: "other", Host: "127.0.0.1", Port: 6479, Quorum: 1, DownAfter: defaultSentinelDownAfter, FailoverTimeout: 10000},
+	}, cfg.SentinelMasters)
+
+	// 重新载入配置文件时不会修改旧配置
+	old := cfg
+	assert.Nil(t, cfg.parseFile())
+	assert.Equal(t, old.SentinelMasters, cfg.SentinelMasters)
+
+	invalid := []string{
+		"sentinel monitor mymaster 127.0.0.1 6379\n",
+		"sentinel monitor mymaster 127.0.0.1 6379 0\n",
+		"sentinel down-after-milliseconds none 100\n",
+		"sentinel down-after-milliseconds mymaster -1\n",
+		"sentinel parallel-syncs mymaster 1\n",
+	}
+	for _, content := range invalid {
+		assert.Nil(t, os.WriteFile(cfg.ConfFile, []byte("sentinel monitor mymaster 127.0.0.1 6379 2\n"+content), 0644))
+		assert.NotNil(t, cfg.parseFile(), content)
+	}
+}
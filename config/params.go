@@ -0,0 +1,243 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/tangrc99/MemTable/utils"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// param 描述了配置文件中的一个选项，以及它与 Config 字段之间的对应关系
+type param struct {
+	name  string // 配置文件中的选项名
+	field string // Config 中对应的字段名，与 ReviseEvent 中的字段名一致
+	get   func(cfg *Config) string
+	set   func(cfg *Config, value string) error // 为 nil 代表该选项不能在运行时修改
+}
+
+// params 按照配置文件中的顺序记录了所有选项
+var params = []param{
+	{name: "host", field: "Host", get: func(cfg *Config) string { return cfg.Host }},
+	{name: "port", field: "Port", get: func(cfg *Config) string { return strconv.Itoa(cfg.Port) }},
+	{name: "tls-port", field: "TLSPort", get: func(cfg *Config) string { return strconv.Itoa(cfg.TLSPort) }},
+	{name: "tls-auth-clients", field: "AuthClient", get: func(cfg *Config) string { return strconv.FormatBool(cfg.AuthClient) }},
+	{name: "tls-key-file", field: "KeyFile", get: func(cfg *Config) string { return cfg.KeyFile }},
+	{name: "tls-cert-file", field: "CertFile", get: func(cfg *Config) string { return cfg.CertFile }},
+	{name: "tls-ca-cert-file", field: "CaCertFile", get: func(cfg *Config) string { return cfg.CaCertFile }},
+	{name: "logdir", field: "LogDir", get: func(cfg *Config) string { return cfg.LogDir }},
+	{name: "loglevel", field: "LogLevel", get: func(cfg *Config) string { return cfg.LogLevel }, set: (*Config).setLogLevel},
+	{name: "databases", field: "DataBases", get: func(cfg *Config) string { return strconv.Itoa(cfg.DataBases) }},
+	{name: "timeout", field: "Timeout", get: func(cfg *Config) string { return strconv.Itoa(cfg.Timeout) }, set: (*Config).setTimeout},
+	{name: "dir", field: "Dir", get: func(cfg *Config) string { return cfg.Dir }},
+	{name: "maxclients", field: "MaxClients", get: func(cfg *Config) string { return strconv.Itoa(cfg.MaxClients) }},
+	{name: "maxmemory", field: "MaxMemory", get: func(cfg *Config) string {
+		if cfg.MaxMemory == unlimitedMemory {
+			return "0"
+		}
+		return strconv.FormatUint(cfg.MaxMemory, 10)
+	}, set: (*Config).setMaxMemory},
+	{name: "eviction", field: "Eviction", get: func(cfg *Config) string { return cfg.Eviction }, set: (*Config).setEviction},
+	{name: "appendonly", field: "AppendOnly", get: func(cfg *Config) string { return strconv.FormatBool(cfg.AppendOnly) }},
+	{name: "appendfsync", field: "AppendFsync", get: func(cfg *Config) string { return cfg.AppendFsync }, set: (*Config).setAppendFsync},
+	{name: "auto-aof-rewrite-percentage", field: "AutoAOFRewritePercentage", get: func(cfg *Config) string {
+		return strconv.Itoa(cfg.AutoAOFRewritePercentage)
+	}},
+	{name: "auto-aof-rewrite-min-size", field: "AutoAOFRewriteMinSize", get: func(cfg *Config) string {
+		return strconv.FormatInt(cfg.AutoAOFRewriteMinSize, 10)
+	}},
+	{name: "gopool", field: "GoPool", get: func(cfg *Config) string { return strconv.FormatBool(cfg.GoPool) }},
+	{name: "gopoolsize", field: "GoPoolSize", get: func(cfg *Config) string { return strconv.Itoa(cfg.GoPoolSize) }},
+	{name: "gopoolspawn", field: "GoPoolSpawn", get: func(cfg *Config) string { return strconv.Itoa(cfg.GoPoolSpawn) }},
+	{name: "dbfilename", field: "RDBFile", get: func(cfg *Config) string { return cfg.RDBFile }},
+	{name: "clusterenable", field: "ClusterEnable", get: func(cfg *Config) string { return strconv.FormatBool(cfg.ClusterEnable) }},
+	{name: "clustername", field: "ClusterName", get: func(cfg *Config) string { return cfg.ClusterName }},
+	{name: "cluster-publish-broadcast", field: "ClusterPublishBroadcast", get: func(cfg *Config) string {
+		return strconv.FormatBool(cfg.ClusterPublishBroadcast)
+	}},
+	{name: "cluster-bus", field: "ClusterBus", get: func(cfg *Config) string { return cfg.ClusterBus }},
+	{name: "cluster-config-file", field: "ClusterConfigFile", get: func(cfg *Config) string { return cfg.ClusterConfigFile }},
+	{name: "cluster-node-timeout", field: "ClusterNodeTimeout", get: func(cfg *Config) string {
+		return strconv.Itoa(cfg.ClusterNodeTimeout)
+	}},
+	{name: "daemonize", field: "Daemonize", get: func(cfg *Config) string { return strconv.FormatBool(cfg.Daemonize) }},
+	{name: "slowlog-log-slower-than", field: "SlowLogSlowerThan", get: func(cfg *Config) string {
+		return strconv.FormatInt(cfg.SlowLogSlowerThan, 10)
+	}, set: (*Config).setSlowLogSlowerThan},
+	{name: "slowlog-max-len", field: "SlowLogMaxLen", get: func(cfg *Config) string { return strconv.Itoa(cfg.SlowLogMaxLen) },
+		set: (*Config).setSlowLogMaxLen},
+	{name: "aclfile", field: "ACLFile", get: func(cfg *Config) string { return cfg.ACLFile }},
+	{name: "notify-keyspace-events", field: "NotifyKeyspaceEvents", get: func(cfg *Config) string {
+		return cfg.NotifyKeyspaceEvents
+	}},
+}
+
+func findParam(name string) *param {
+	for i := range params {
+		if params[i].name == name {
+			return &params[i]
+		}
+	}
+	return nil
+}
+
+// unlimitedMemory 代表不限制内存使用
+const unlimitedMemory = 1<<64 - 1
+
+func (cfg *Config) setLogLevel(value string) error {
+	switch level := strings.ToLower(value); level {
+	case "debug", "info", "warning", "error", "panic":
+		cfg.LogLevel = level
+		return nil
+	}
+	return &Error{"loglevel should be one of debug, info, warning, error, panic"}
+}
+
+func (cfg *Config) setTimeout(value string) error {
+	timeout, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	cfg.Timeout = timeout
+	return nil
+}
+
+func (cfg *Config) setMaxMemory(value string) error {
+	maxmemory, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	if maxmemory < 0 {
+		return &Error{"maxmemory < 0"}
+	}
+	// 0 代表不限制内存
+	if maxmemory == 0 {
+		cfg.MaxMemory = unlimitedMemory
+	} else {
+		cfg.MaxMemory = uint64(maxmemory)
+	}
+	return nil
+}
+
+func (cfg *Config) setEviction(value string) error {
+	switch eviction := strings.ToLower(value); eviction {
+	case "no", "lru", "lfu":
+		cfg.Eviction = eviction
+		return nil
+	}
+	return &Error{"eviction should be one of no, lru, lfu"}
+}
+
+func (cfg *Config) setAppendFsync(value string) error {
+	switch fsync := strings.ToLower(value); fsync {
+	case "always", "everysec", "no":
+		cfg.AppendFsync = fsync
+		return nil
+	}
+	return &Error{"appendfsync should be one of always, everysec, no"}
+}
+
+func (cfg *Config) setSlowLogSlowerThan(value string) error {
+	slow, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	cfg.SlowLogSlowerThan = slow
+	return nil
+}
+
+func (cfg *Config) setSlowLogMaxLen(value string) error {
+	max, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if max <= 0 {
+		return &Error{"slowlog-max-len <= 0"}
+	}
+	cfg.SlowLogMaxLen = max
+	return nil
+}
+
+// Get 返回所有名称匹配 glob 风格 pattern 的选项，结果按照选项名、选项值依次排列
+func (cfg *Config) Get(pattern string) []string {
+	pattern = strings.ToLower(pattern)
+
+	res := make([]string, 0)
+	for i := range params {
+		if utils.GlobMatch(pattern, params[i].name) {
+			res = append(res, params[i].name, params[i].get(cfg))
+		}
+	}
+	return res
+}
+
+// Set 在运行时修改名称为 name 的选项，成功时返回选项对应的 Config 字段名
+func (cfg *Config) Set(name, value string) (string, error) {
+	p := findParam(strings.ToLower(name))
+	if p == nil {
+		return "", &Error{fmt.Sprintf("Unknown option '%s'", name)}
+	}
+	if p.set == nil {
+		return "", &Error{fmt.Sprintf("Option '%s' can't be set at runtime", p.name)}
+	}
+	if err := p.set(cfg, value); err != nil {
+		return "", &Error{fmt.Sprintf("Invalid argument '%s' for option '%s' - %s", value, p.name, err.Error())}
+	}
+	return p.field, nil
+}
+
+// line 生成选项在配置文件中的一行，空值需要使用引号包裹
+func (p *param) line(cfg *Config) string {
+	value := p.get(cfg)
+	if value == "" {
+		value = "\"\""
+	}
+	return p.name + " " + value
+}
+
+// Rewrite 将当前配置写回配置文件。文件中的注释与空行会被保留，已有的选项会替换为当前值，
+// 文件中不存在且与默认配置不同的选项会追加到文件末尾
+func (cfg *Config) Rewrite() error {
+	if cfg.ConfFile == "" {
+		return &Error{"The server is running without a config file"}
+	}
+
+	content, err := os.ReadFile(cfg.ConfFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	lines := make([]string, 0)
+	written := make(map[string]bool)
+
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 || line[0] == '#' {
+			lines = append(lines, line)
+			continue
+		}
+		p := findParam(strings.ToLower(fields[0]))
+		if p == nil {
+			lines = append(lines, line)
+			continue
+		}
+		// 重复出现的选项只保留第一个
+		if !written[p.name] {
+			lines = append(lines, p.line(cfg))
+			written[p.name] = true
+		}
+	}
+
+	for i := range params {
+		p := &params[i]
+		if !written[p.name] && p.get(cfg) != p.get(&defaultConf) {
+			lines = append(lines, p.line(cfg))
+		}
+	}
+
+	// 原地写入文件，保证配置文件监听仍然有效
+	return os.WriteFile(cfg.ConfFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
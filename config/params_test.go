@@ -0,0 +1,88 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestConfigGetSet(t *testing.T) {
+
+	cfg := defaultConf
+
+	assert.Equal(t, []string{"maxclients", "-1", "maxmemory", "0"}, cfg.Get("max*"))
+	assert.Equal(t, []string{"slowlog-max-len", "100"}, cfg.Get("SLOWLOG-MAX-LEN"))
+	assert.Empty(t, cfg.Get("none"))
+	assert.Len(t, cfg.Get("*"), 2*len(params))
+
+	tests := []struct {
+		name  string
+		value string
+		field string
+		get   string
+	}{
+		{"maxmemory", "1024", "MaxMemory", "1024"},
+		{"maxmemory", "0", "MaxMemory", "0"},
+		{"eviction", "LRU", "Eviction", "lru"},
+		{"slowlog-log-slower-than", "-1", "SlowLogSlowerThan", "-1"},
+		{"slowlog-max-len", "10", "SlowLogMaxLen", "10"},
+		{"timeout", "0", "Timeout", "0"},
+		{"appendfsync", "always", "AppendFsync", "always"},
+		{"LogLevel", "debug", "LogLevel", "debug"},
+	}
+
+	for _, test := range tests {
+		field, err := cfg.Set(test.name, test.value)
+		assert.Nil(t, err)
+		assert.Equal(t, test.field, field)
+		assert.Equal(t, test.get, cfg.Get(test.name)[1])
+	}
+
+	assert.Equal(t, "lru", cfg.Eviction)
+	assert.Equal(t, uint64(unlimitedMemory), cfg.MaxMemory)
+
+	invalid := [][2]string{
+		{"maxmemory", "-1"},
+		{"maxmemory", "a"},
+		{"eviction", "random"},
+		{"slowlog-max-len", "0"},
+		{"appendfsync", "never"},
+		{"loglevel", "trace"},
+		{"port", "6379"},
+		{"none", "1"},
+	}
+
+	for _, test := range invalid {
+		_, err := cfg.Set(test[0], test[1])
+		assert.NotNil(t, err, test[0])
+	}
+	assert.Equal(t, "lru", cfg.Eviction)
+}
+
+func TestConfigRewrite(t *testing.T) {
+
+	cfg := defaultConf
+	assert.NotNil(t, cfg.Rewrite())
+
+	cfg.ConfFile = path.Join(t.TempDir(), "test.conf")
+	assert.Nil(t, os.WriteFile(cfg.ConfFile, []byte("# 最大内存\n# maxmemory <bytes>\n\n"+
+		"maxmemory 100\nport 6380\n# 驱逐策略\neviction no\neviction lfu\nunknown option\n"), 0644))
+
+	_, _ = cfg.Set("maxmemory", "2048")
+	_, _ = cfg.Set("eviction", "lru")
+	_, _ = cfg.Set("slowlog-max-len", "10")
+	_, _ = cfg.Set("timeout", "0")
+	assert.Nil(t, cfg.Rewrite())
+
+	content, err := os.ReadFile(cfg.ConfFile)
+	assert.Nil(t, err)
+	assert.Equal(t, "# 最大内存\n# maxmemory <bytes>\n\nmaxmemory 2048\nport 6380\n# 驱逐策略\neviction lru\n"+
+		"unknown option\ntimeout 0\nslowlog-max-len 10\n", string(content))
+
+	// 重写后的文件可以被重新解析
+	parsed := defaultConf
+	parsed.ConfFile = cfg.ConfFile
+	assert.Nil(t, parsed.parseFile())
+	assert.Equal(t, cfg, parsed)
+}
@@ -105,6 +105,8 @@ func initWatcher() {
 								if newVal.Field(i).Uint() != oldVal.Field(i).Uint() {
 									equal = false
 								}
+							case reflect.Slice:
+								equal = reflect.DeepEqual(newVal.Field(i).Interface(), oldVal.Field(i).Interface())
 							default:
 								panic(fmt.Sprintf("unexpected type %s: %d", t.Field(i).Name, newVal.Field(i).Kind()))
 							}
@@ -0,0 +1,250 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tangrc99/MemTable/db/eviction"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"io"
+	"strconv"
+)
+
+// aofRewriteItemsPerCmd 是 AOF 重写时单条命令中最多包含的元素数量
+const aofRewriteItemsPerCmd = 64
+
+// aofRewriter 负责将命令以 resp 格式写入到 writer 中
+type aofRewriter struct {
+	writer    io.Writer
+	selectCmd []byte // 非 0 号数据库需要在每一条命令前写入 select
+}
+
+func (rw *aofRewriter) write(cmd ...[]byte) error {
+	if rw.selectCmd != nil {
+		if _, err := rw.writer.Write(rw.selectCmd); err != nil {
+			return err
+		}
+	}
+	_, err := rw.writer.Write(resp.PlainDataToResp(cmd).ToBytes())
+	return err
+}
+
+// writeBatch 将 args 按照 aofRewriteItemsPerCmd 分批写入，每一条命令都会以 prefix 开头，step 代表一个元素占用的参数个数
+func (rw *aofRewriter) writeBatch(prefix [][]byte, args [][]byte, step int) error {
+
+	batch := aofRewriteItemsPerCmd * step
+
+	for start := 0; start < len(args); start += batch {
+		end := start + batch
+		if end > len(args) {
+			end = len(args)
+		}
+		cmd := make([][]byte, 0, len(prefix)+end-start)
+		cmd = append(cmd, prefix...)
+		cmd = append(cmd, args[start:end]...)
+		if err := rw.write(cmd...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStream 将 stream 中的消息、最后写入的 ID 以及消费组状态写入到 aof 中
+func (rw *aofRewriter) writeStream(key []byte, stream *structure.Stream) error {
+
+	lastID := []byte(stream.LastID().String())
+
+	for _, entry := range stream.Range(structure.MinStreamID, structure.MaxStreamID, 0) {
+		cmd := make([][]byte, 0, 3+len(entry.Fields))
+		cmd = append(cmd, []byte("xadd"), key, []byte(entry.ID.String()))
+		if err := rw.write(append(cmd, entry.Fields...)...); err != nil {
+			return err
+		}
+	}
+
+	var err error
+
+	if stream.Len() > 0 {
+		err = rw.write([]byte("xsetid"), key, lastID)
+	} else if stream.LastID() != structure.MinStreamID {
+		// 空 stream 通过写入后立即裁剪的方式创建
+		err = rw.write([]byte("xadd"), key, []byte("maxlen"), []byte("0"), lastID, []byte("x"), []byte("y"))
+	} else if len(stream.Groups()) == 0 {
+		// 没有消费组的空 stream 只能通过临时的消费组创建
+		tmp := []byte("__rewrite__")
+		if err = rw.write([]byte("xgroup"), []byte("create"), key, tmp, lastID, []byte("mkstream")); err == nil {
+			err = rw.write([]byte("xgroup"), []byte("destroy"), key, tmp)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, group := range stream.Groups() {
+
+		name := []byte(group.Name)
+		err = rw.write([]byte("xgroup"), []byte("create"), key, name, []byte(group.LastID.String()), []byte("mkstream"))
+		if err != nil {
+			return err
+		}
+
+		// 待确认的消息通过 XCLAIM 恢复投递时间与投递次数
+		pending := group.PendingRange(structure.MinStreamID, structure.MaxStreamID, 0, "", 0, 0)
+		for _, pe := range pending {
+			err = rw.write([]byte("xclaim"), key, name, []byte(pe.Consumer.Name), []byte("0"), []byte(pe.ID.String()),
+				[]byte("time"), []byte(strconv.FormatInt(pe.DeliveryTime, 10)),
+				[]byte("retrycount"), []byte(strconv.FormatInt(pe.DeliveryCount, 10)),
+				[]byte("force"), []byte("justid"))
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, consumer := range group.Consumers() {
+			if consumer.PendingSize() > 0 {
+				continue
+			}
+			err = rw.write([]byte("xgroup"), []byte("createconsumer"), key, name, []byte(consumer.Name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// newAOFRewriter 创建一个在 dbSeq 号数据库中写入命令的 aofRewriter
+func newAOFRewriter(writer io.Writer, dbSeq int) *aofRewriter {
+	rw := &aofRewriter{writer: writer}
+	if dbSeq != 0 {
+		seq := strconv.Itoa(dbSeq)
+		rw.selectCmd = resp.PlainDataToResp([][]byte{[]byte("select"), []byte(seq)}).ToBytes()
+	}
+	return rw
+}
+
+// writeObject 将一个键值对以最少的命令写入，ttl 为毫秒级的 unix 时间戳，0 代表没有过期时间
+func (rw *aofRewriter) writeObject(key []byte, v Object, ttl int64) error {
+
+	var err error
+
+	if str, ok := v.(structure.Slice); ok {
+
+		err = rw.write([]byte("set"), key, str)
+
+	} else if list, ok := v.(*structure.List); ok {
+
+		values, n := list.Range(0, -1)
+		args := make([][]byte, n)
+		for i, value := range values {
+			args[i] = value.(structure.Slice)
+		}
+		err = rw.writeBatch([][]byte{[]byte("rpush"), key}, args, 1)
+
+	} else if set, ok := v.(*structure.Set); ok {
+
+		members, _ := set.KeysByte("")
+		err = rw.writeBatch([][]byte{[]byte("sadd"), key}, members, 1)
+
+	} else if zset, ok := v.(*structure.ZSet); ok {
+
+		members, n := zset.Pos(0, -1)
+		args := make([][]byte, 0, 2*n)
+		for _, member := range members {
+			m := string(member.(structure.String))
+			score, _ := zset.GetScoreByKey(m)
+			args = append(args, []byte(strconv.FormatFloat(float64(score), 'g', -1, 64)), []byte(m))
+		}
+		err = rw.writeBatch([][]byte{[]byte("zadd"), key}, args, 2)
+
+	} else if hash, ok := v.(*structure.Dict); ok {
+
+		kvs, _ := hash.GetAll()
+		args := make([][]byte, 0)
+		for _, kv := range kvs {
+			for field, value := range kv {
+				args = append(args, []byte(field), value.(structure.Slice))
+			}
+		}
+		err = rw.writeBatch([][]byte{[]byte("hset"), key}, args, 2)
+
+	} else if bloom, ok := v.(*structure.Bloom); ok {
+
+		err = rw.write([]byte("bf.loadchunk"), key, []byte("1"), bloom.MarshalBinary())
+
+	} else if stream, ok := v.(*structure.Stream); ok {
+
+		err = rw.writeStream(key, stream)
+
+	} else {
+
+		err = errors.New(fmt.Sprintf("Unexpected type %T", v))
+
+	}
+
+	if err == nil && ttl > 0 {
+		err = rw.write([]byte("pexpireat"), key, []byte(strconv.FormatInt(ttl, 10)))
+	}
+
+	return err
+}
+
+// AOFWriter 将快照中的键值对以最少的命令写入到 writer 中，写入时已经过期的键会被跳过
+type AOFWriter struct {
+	writer io.Writer
+	dbs    map[int]*aofRewriter
+}
+
+// NewAOFWriter 创建一个 AOFWriter 并返回指针
+func NewAOFWriter(writer io.Writer) *AOFWriter {
+	return &AOFWriter{
+		writer: writer,
+		dbs:    make(map[int]*aofRewriter),
+	}
+}
+
+// WriteKey 将 dbSeq 号数据库中的一个键值对写入，expireAt 为毫秒级的 unix 时间戳，0 代表没有过期时间
+func (w *AOFWriter) WriteKey(dbSeq int, key string, value Object, expireAt int64) error {
+
+	if expireAt > 0 && expireAt <= global.Now.UnixMilli() {
+		return nil
+	}
+
+	rw, ok := w.dbs[dbSeq]
+	if !ok {
+		rw = newAOFRewriter(w.writer, dbSeq)
+		w.dbs[dbSeq] = rw
+	}
+
+	return rw.writeObject([]byte(key), value, expireAt)
+}
+
+// RewriteAOF 将 DataBase 中的全部键值对以最少的命令写入到 writer 中，dbSeq 为当前数据库的编号。
+// 已经过期的键不会被写入，如果写入过程发生错误将返回 error
+func (db_ *DataBase) RewriteAOF(writer io.Writer, dbSeq int) error {
+
+	rw := newAOFRewriter(writer, dbSeq)
+
+	dicts, _ := db_.dict.GetAll()
+
+	for _, dict := range dicts {
+		for k, v := range dict {
+
+			var ttl int64 = 0
+			if expiredAt, ok := db_.ttlKeys.Get(k); ok {
+				ttl = int64(expiredAt.(Int64))
+				if ttl <= global.Now.UnixMilli() {
+					continue
+				}
+			}
+
+			if err := rw.writeObject([]byte(k), v.(*eviction.Item).Value, ttl); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
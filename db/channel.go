@@ -2,6 +2,9 @@ package db
 
 import (
 	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/utils"
+	"sort"
 	"strings"
 	"unsafe"
 )
@@ -37,10 +40,14 @@ func (ch *channel) unSubscribe(owner string) int {
 	return len(ch.subscriber)
 }
 
-// publish 将信息发布到所有的订阅者频道
-func (ch *channel) publish(msg []byte) int {
-	for _, sub := range ch.subscriber {
-		*sub <- msg
+// publish 将信息发布到所有的订阅者频道，发送是非阻塞的，缓冲区已满的订阅者会被记录到 slow 中
+func (ch *channel) publish(msg []byte, slow map[string]struct{}) int {
+	for owner, sub := range ch.subscriber {
+		select {
+		case *sub <- msg:
+		default:
+			slow[owner] = struct{}{}
+		}
 	}
 	return len(ch.subscriber)
 }
@@ -53,25 +60,38 @@ const channelsBasicCost = int64(unsafe.Sizeof(Channels{}))
 
 // Channels 维护所有的订阅频道信息，内部有哈希表和前缀树两种数据结构，分别用于
 // 处理单一频道和路径频道两种模式的发布和订阅。
+//
+// glob 模式的订阅按照模式中第一个通配符之前的字面前缀进行索引，发布时只需要按照已有的前缀长度
+// 截取频道名进行查找，只有前缀相同的模式才需要进行匹配，避免每次发布都遍历所有模式。
 type Channels struct {
 	channels map[string]*channel
 	paths    *structure.TrieTree
 	cost     int64
+
+	patterns   map[string]*channel            // 模式到订阅者的映射
+	prefixes   map[string]map[string]struct{} // 字面前缀到模式集合的映射
+	prefixLens map[int]int                    // 前缀长度到模式数量的映射
+
+	slow map[string]struct{} // 消息缓冲区已满的订阅者，需要由上层断开连接
 }
 
 // NewChannels 创建一个 Channel 实例并返回指针
 func NewChannels() *Channels {
 	return &Channels{
-		channels: make(map[string]*channel),
-		paths:    structure.NewTrieTree(),
-		cost:     channelsBasicCost,
+		channels:   make(map[string]*channel),
+		paths:      structure.NewTrieTree(),
+		cost:       channelsBasicCost,
+		patterns:   make(map[string]*channel),
+		prefixes:   make(map[string]map[string]struct{}),
+		prefixLens: make(map[int]int),
+		slow:       make(map[string]struct{}),
 	}
 }
 
 // Publish 发布消息到指定的频道上，如果频道是一个路径，消息将会被发送到该路径以及路径下的一级子目录频道
 func (chs *Channels) Publish(channel string, msg []byte) int {
 
-	if channel[0] == '/' {
+	if len(channel) > 0 && channel[0] == '/' {
 		return chs.publishPath(channel, msg)
 	}
 
@@ -80,7 +100,7 @@ func (chs *Channels) Publish(channel string, msg []byte) int {
 		return 0
 	}
 
-	return ch.publish(msg)
+	return ch.publish(msg, chs.slow)
 }
 
 func (chs *Channels) publishPath(ch string, msg []byte) int {
@@ -91,7 +111,7 @@ func (chs *Channels) publishPath(ch string, msg []byte) int {
 	pubs := 0
 	for _, node := range nodes {
 		c := node.Value.(*channel)
-		pubs += c.publish(msg)
+		pubs += c.publish(msg, chs.slow)
 	}
 	return pubs
 }
@@ -99,7 +119,7 @@ func (chs *Channels) publishPath(ch string, msg []byte) int {
 // Subscribe 订阅指定的频道，如果频道是一个路径，那么同时也会接收上一级父目录发布的消息
 func (chs *Channels) Subscribe(channel string, owner string, notify *chan []byte) {
 
-	if channel[0] == '/' {
+	if len(channel) > 0 && channel[0] == '/' {
 		chs.subscribePath(channel, owner, notify)
 		return
 	}
@@ -129,7 +149,7 @@ func (chs *Channels) subscribePath(ch string, owner string, notify *chan []byte)
 
 // UnSubscribe 取消指定频道的订阅
 func (chs *Channels) UnSubscribe(channel string, owner string) bool {
-	if channel[0] == '/' {
+	if len(channel) > 0 && channel[0] == '/' {
 		return chs.unSubscribePath(channel, owner)
 	}
 	ch, ok := chs.channels[channel]
@@ -159,6 +179,164 @@ func (chs *Channels) unSubscribePath(ch string, owner string) bool {
 	return true
 }
 
+// SlowSubscribers 返回并清空发布时缓冲区已满的订阅者。订阅者消费消息的速度跟不上发布速度时，
+// 消息会被丢弃，与 redis 的 client-output-buffer-limit pubsub 一致，上层应当断开这些订阅者的连接
+func (chs *Channels) SlowSubscribers() []string {
+	if len(chs.slow) == 0 {
+		return nil
+	}
+	owners := make([]string, 0, len(chs.slow))
+	for owner := range chs.slow {
+		owners = append(owners, owner)
+	}
+	chs.slow = make(map[string]struct{})
+	return owners
+}
+
+// PublishMessage 以 redis 的消息格式发布 payload，频道的订阅者收到 message 消息，
+// 匹配该频道的模式订阅者收到 pmessage 消息，返回接收到消息的订阅者数量
+func (chs *Channels) PublishMessage(channel string, payload []byte) int {
+
+	pubs := chs.Publish(channel, makeMessage("message", channel, payload))
+
+	for _, pattern := range chs.matchPatterns(channel) {
+		pmsg := resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte("pmessage")),
+			resp.MakeBulkData([]byte(pattern)),
+			resp.MakeBulkData([]byte(channel)),
+			resp.MakeBulkData(payload),
+		})
+		pubs += chs.patterns[pattern].publish(pmsg.ToBytes(), chs.slow)
+	}
+	return pubs
+}
+
+// PublishShardMessage 以 smessage 格式发布 payload，分片频道不会被模式订阅匹配
+func (chs *Channels) PublishShardMessage(channel string, payload []byte) int {
+	return chs.Publish(channel, makeMessage("smessage", channel, payload))
+}
+
+func makeMessage(kind, channel string, payload []byte) []byte {
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte(kind)),
+		resp.MakeBulkData([]byte(channel)),
+		resp.MakeBulkData(payload),
+	}).ToBytes()
+}
+
+// patternPrefix 返回模式中第一个通配符或转义符之前的字面前缀
+func patternPrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "*?[\\"); i >= 0 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// matchPatterns 返回所有匹配频道名的模式
+func (chs *Channels) matchPatterns(channel string) []string {
+
+	var matched []string
+	for l := range chs.prefixLens {
+		if l > len(channel) {
+			continue
+		}
+		for pattern := range chs.prefixes[channel[:l]] {
+			if utils.GlobMatch(pattern, channel) {
+				matched = append(matched, pattern)
+			}
+		}
+	}
+	return matched
+}
+
+// PSubscribe 订阅 glob 风格的模式，语法与 KEYS 命令一致
+func (chs *Channels) PSubscribe(pattern string, owner string, notify *chan []byte) {
+
+	ch, ok := chs.patterns[pattern]
+	if !ok {
+		ch = newChannel()
+		chs.patterns[pattern] = ch
+
+		prefix := patternPrefix(pattern)
+		set, ok := chs.prefixes[prefix]
+		if !ok {
+			set = make(map[string]struct{})
+			chs.prefixes[prefix] = set
+		}
+		set[pattern] = struct{}{}
+		chs.prefixLens[len(prefix)]++
+		chs.cost += int64(len(pattern))
+	} else {
+		chs.cost -= ch.Cost()
+	}
+	ch.subscribe(owner, notify)
+	chs.cost += ch.Cost()
+}
+
+// PUnSubscribe 取消指定模式的订阅
+func (chs *Channels) PUnSubscribe(pattern string, owner string) bool {
+
+	ch, ok := chs.patterns[pattern]
+	if !ok {
+		return false
+	}
+
+	chs.cost -= ch.Cost()
+	if ch.unSubscribe(owner) > 0 {
+		chs.cost += ch.Cost()
+		return true
+	}
+
+	// 没有订阅者时删除模式以及前缀索引
+	delete(chs.patterns, pattern)
+	chs.cost -= int64(len(pattern))
+
+	prefix := patternPrefix(pattern)
+	delete(chs.prefixes[prefix], pattern)
+	if len(chs.prefixes[prefix]) == 0 {
+		delete(chs.prefixes, prefix)
+	}
+	if chs.prefixLens[len(prefix)]--; chs.prefixLens[len(prefix)] == 0 {
+		delete(chs.prefixLens, len(prefix))
+	}
+	return true
+}
+
+// ActiveChannels 返回至少有一个订阅者的单一频道，pattern 为空时返回所有频道，结果按字典序排列
+func (chs *Channels) ActiveChannels(pattern string) []string {
+	names := make([]string, 0, len(chs.channels))
+	for name := range chs.channels {
+		if pattern == "" || utils.GlobMatch(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NumSub 返回频道的订阅者数量，不包括模式订阅者
+func (chs *Channels) NumSub(name string) int {
+
+	if len(name) > 0 && name[0] == '/' {
+		node, ok := chs.paths.GetLeafNode(strings.Split(name, "/")[1:])
+		if !ok {
+			return 0
+		}
+		return len(node.Value.(*channel).subscriber)
+	}
+
+	ch, ok := chs.channels[name]
+	if !ok {
+		return 0
+	}
+	return len(ch.subscriber)
+}
+
+// NumPat 返回被订阅的模式数量
+func (chs *Channels) NumPat() int {
+	return len(chs.patterns)
+}
+
 func (chs *Channels) Cost() int64 {
 	return chs.cost + chs.paths.Cost()
 }
@@ -2,6 +2,7 @@ package db
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/resp"
 	"testing"
 )
 
@@ -10,11 +11,13 @@ func TestChannel(t *testing.T) {
 	ch := newChannel()
 	receiver := make(chan []byte, 1)
 	ch.subscribe("u1", &receiver)
-	assert.Equal(t, 1, ch.publish([]byte("msg")))
+	slow := make(map[string]struct{})
+	assert.Equal(t, 1, ch.publish([]byte("msg"), slow))
 	assert.Equal(t, []byte("msg"), <-receiver)
+	assert.Empty(t, slow)
 
 	ch.unSubscribe("u1")
-	assert.Equal(t, 0, ch.publish([]byte("msg")))
+	assert.Equal(t, 0, ch.publish([]byte("msg"), slow))
 
 }
 
@@ -27,6 +30,13 @@ func TestChannelsSingle(t *testing.T) {
 	assert.Equal(t, 1, ch.Publish("ch1", []byte("msg")))
 	assert.Equal(t, []byte("msg"), <-receiver)
 
+	// 缓冲区已满时发布不会阻塞，订阅者被记录为慢订阅者
+	assert.Equal(t, 1, ch.Publish("ch1", []byte("msg1")))
+	assert.Equal(t, 1, ch.Publish("ch1", []byte("msg2")))
+	assert.Equal(t, []string{"u1"}, ch.SlowSubscribers())
+	assert.Nil(t, ch.SlowSubscribers())
+	assert.Equal(t, []byte("msg1"), <-receiver)
+
 	ch.UnSubscribe("ch1", "u1")
 	assert.Equal(t, 0, ch.Publish("ch1", []byte("msg")))
 
@@ -49,3 +59,49 @@ func TestChannelsPath(t *testing.T) {
 	assert.Equal(t, 0, ch.Publish("/a", []byte("msg")))
 
 }
+
+func TestChannelsPattern(t *testing.T) {
+
+	ch := NewChannels()
+	r1 := make(chan []byte, 10)
+	r2 := make(chan []byte, 10)
+
+	ch.Subscribe("news.tech", "u1", &r1)
+	ch.PSubscribe("news.*", "u2", &r2)
+	ch.PSubscribe("*tech", "u2", &r2)
+	ch.PSubscribe("sport.*", "u2", &r2)
+	assert.Equal(t, 3, ch.NumPat())
+
+	// 频道订阅者收到 message，匹配的每一个模式都会收到一次 pmessage
+	assert.Equal(t, 3, ch.PublishMessage("news.tech", []byte("msg")))
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("message")),
+		resp.MakeBulkData([]byte("news.tech")),
+		resp.MakeBulkData([]byte("msg")),
+	}).ToBytes(), <-r1)
+	assert.Len(t, r2, 2)
+	<-r2
+	<-r2
+
+	assert.Equal(t, 1, ch.PublishMessage("news.art", []byte("msg")))
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("pmessage")),
+		resp.MakeBulkData([]byte("news.*")),
+		resp.MakeBulkData([]byte("news.art")),
+		resp.MakeBulkData([]byte("msg")),
+	}).ToBytes(), <-r2)
+	assert.Equal(t, 0, ch.PublishMessage("new", []byte("msg")))
+
+	assert.Equal(t, []string{"news.tech"}, ch.ActiveChannels(""))
+	assert.Equal(t, []string{}, ch.ActiveChannels("sport.*"))
+	assert.Equal(t, 1, ch.NumSub("news.tech"))
+	assert.Equal(t, 0, ch.NumSub("news.art"))
+
+	assert.True(t, ch.PUnSubscribe("news.*", "u2"))
+	assert.False(t, ch.PUnSubscribe("news.*", "u2"))
+	assert.True(t, ch.PUnSubscribe("*tech", "u2"))
+	assert.Equal(t, 1, ch.NumPat())
+	assert.Equal(t, 1, ch.PublishMessage("news.tech", []byte("msg")))
+	assert.Len(t, ch.prefixes, 1)
+	assert.Len(t, ch.prefixLens, 1)
+}
@@ -44,6 +44,7 @@ func setbit(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	old := bm.GetSet(pos, byte(bitVal))
 	db.SetKey(string(cmd[1]), (structure.Slice)(*bm))
+	db.NotifyKeyspaceEvent(notifyString, "setbit", string(cmd[1]))
 
 	return resp.MakeIntData(int64(old))
 }
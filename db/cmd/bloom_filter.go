@@ -8,6 +8,15 @@ import (
 	"strconv"
 )
 
+// bloomHashSeed 是 bloom filter 使用的哈希种子
+const bloomHashSeed = 0x5bd1e995
+
+// bloomHash 计算元素在 bloom filter 中的哈希值。bloom filter 的位图会被持久化或迁移到其他实例，
+// 因此必须使用与进程无关的哈希函数
+func bloomHash(element []byte) uint64 {
+	return utils.MurmurHash64A(element, bloomHashSeed)
+}
+
 func bfAdd(base *db.DataBase, cmd [][]byte) resp.RedisData {
 	// 进行输入类型检查
 	e, ok := checkCommandAndLength(&cmd, "bf.add", 3)
@@ -30,11 +39,17 @@ func bfAdd(base *db.DataBase, cmd [][]byte) resp.RedisData {
 	}
 
 	ret := 0
+	oldCost := bloom.Cost()
 
-	if bloom.AddIfNotHas(utils.MemHash(cmd[2])) {
+	if bloom.AddIfNotHas(bloomHash(cmd[2])) {
 		ret++
 	}
 
+	if ret > 0 {
+		base.ReviseNotify(string(cmd[1]), oldCost, bloom.Cost())
+		base.NotifyKeyspaceEvent(notifyGeneric, "bf.add", string(cmd[1]))
+	}
+
 	return resp.MakeIntData(int64(ret))
 }
 
@@ -61,12 +76,18 @@ func bfMAdd(base *db.DataBase, cmd [][]byte) resp.RedisData {
 	}
 
 	ret := 0
+	oldCost := bloom.Cost()
 	for _, ele := range cmd[2:] {
-		if bloom.AddIfNotHas(utils.MemHash(ele)) {
+		if bloom.AddIfNotHas(bloomHash(ele)) {
 			ret++
 		}
 	}
 
+	if ret > 0 {
+		base.ReviseNotify(string(cmd[1]), oldCost, bloom.Cost())
+		base.NotifyKeyspaceEvent(notifyGeneric, "bf.madd", string(cmd[1]))
+	}
+
 	return resp.MakeIntData(int64(ret))
 
 }
@@ -90,7 +111,7 @@ func bfExists(base *db.DataBase, cmd [][]byte) resp.RedisData {
 		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
 
-	if exist := bloom.Has(utils.MemHash(cmd[2])); !exist {
+	if exist := bloom.Has(bloomHash(cmd[2])); !exist {
 		return resp.MakeIntData(0)
 	}
 
@@ -120,7 +141,7 @@ func bfMExists(base *db.DataBase, cmd [][]byte) resp.RedisData {
 	ret := int64(0)
 
 	for _, ele := range cmd[2:] {
-		if exist := bloom.Has(utils.MemHash(ele)); exist {
+		if exist := bloom.Has(bloomHash(ele)); exist {
 			ret++
 		}
 	}
@@ -193,6 +214,31 @@ func bfReserve(base *db.DataBase, cmd [][]byte) resp.RedisData {
 	}
 
 	base.SetKey(string(cmd[1]), structure.NewBloomFilter(capacity, error_rate))
+	base.NotifyKeyspaceEvent(notifyGeneric, "bf.reserve", string(cmd[1]))
+
+	return resp.MakeStringData("OK")
+}
+
+// bfLoadChunk 使用 bf.loadchunk key iterator data 的格式恢复一个 bloom filter，主要用于 AOF 重写后的恢复
+func bfLoadChunk(base *db.DataBase, cmd [][]byte) resp.RedisData {
+
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "bf.loadchunk", 4)
+	if !ok {
+		return e
+	}
+
+	if _, err := strconv.ParseInt(string(cmd[2]), 10, 64); err != nil {
+		return resp.MakeErrorData("ERR invalid iterator")
+	}
+
+	bloom, err := structure.NewBloomFilterFromBytes(cmd[3])
+	if err != nil {
+		return resp.MakeErrorData("ERR invalid chunk")
+	}
+
+	base.SetKey(string(cmd[1]), bloom)
+	base.NotifyKeyspaceEvent(notifyGeneric, "bf.loadchunk", string(cmd[1]))
 
 	return resp.MakeStringData("OK")
 }
@@ -205,5 +251,6 @@ func registerBloomFilterCommands() {
 	registerCommand("bf.mexists", bfMExists, RD)
 	registerCommand("bf.info", bfInfo, RD)
 	registerCommand("bf.reserve", bfReserve, WR)
+	registerCommand("bf.loadchunk", bfLoadChunk, WR)
 
 }
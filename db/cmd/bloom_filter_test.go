@@ -3,6 +3,7 @@ package cmd
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
 	"testing"
@@ -70,3 +71,46 @@ func TestCmdBloomFilter(t *testing.T) {
 		assert.Equal(t, test.expected, ret)
 	}
 }
+
+func TestCmdBloomFilterPersistentHash(t *testing.T) {
+
+	// 位图会被持久化，哈希值不能随进程变化
+	assert.Equal(t, uint64(0x5ee88d55b5af6787), bloomHash([]byte("k1")))
+
+	// 从位图恢复的 bloom filter 中仍然能找到已经添加的元素
+	database := db.NewDataBase(1)
+	exec := func(args ...[]byte) resp.RedisData {
+		cmd, _ := global.FindCommand(string(args[0]))
+		return cmd.Function().(command)(database, args)
+	}
+	exec([]byte("bf.add"), []byte("bf"), []byte("k1"))
+	value, _ := database.GetKey("bf")
+	chunk := value.(*structure.Bloom).MarshalBinary()
+
+	assert.Equal(t, resp.MakeStringData("OK"), exec([]byte("bf.loadchunk"), []byte("copy"), []byte("1"), chunk))
+	assert.Equal(t, resp.MakeIntData(1), exec([]byte("bf.exists"), []byte("copy"), []byte("k1")))
+}
+
+func TestCmdBloomFilterNotify(t *testing.T) {
+	chs := db.NewChannels()
+	receiver := make(chan []byte, 10)
+	chs.Subscribe("__keyevent@0__:bf.add", "u1", &receiver)
+	chs.Subscribe("__keyevent@0__:bf.reserve", "u1", &receiver)
+	database := db.NewDataBase(1, db.WithKeyspaceNotification(0, chs, db.NotifyGeneric|db.NotifyKeyevent))
+
+	cmd, _ := global.FindCommand("bf.add")
+	c := cmd.Function().(command)
+
+	assert.Equal(t, resp.MakeIntData(1), c(database, [][]byte{[]byte("bf.add"), []byte("test"), []byte("k1")}))
+	assert.Len(t, receiver, 1)
+	<-receiver
+
+	// 没有新增元素时不发布事件
+	assert.Equal(t, resp.MakeIntData(0), c(database, [][]byte{[]byte("bf.add"), []byte("test"), []byte("k1")}))
+	assert.Len(t, receiver, 0)
+
+	cmd, _ = global.FindCommand("bf.reserve")
+	c = cmd.Function().(command)
+	assert.Equal(t, resp.MakeStringData("OK"), c(database, [][]byte{[]byte("bf.reserve"), []byte("b"), []byte("0.01"), []byte("1000")}))
+	assert.Len(t, receiver, 1)
+}
@@ -26,4 +26,7 @@ func init() {
 	registerZSetCommands()
 	registerBitMapCommands()
 	registerBloomFilterCommands()
+	registerStreamCommands()
+	registerHyperLogLogCommands()
+	registerGeoCommands()
 }
@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/tangrc99/MemTable/db"
 	"github.com/tangrc99/MemTable/db/structure"
 	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/utils"
+	"strconv"
 	"strings"
 )
 
@@ -15,6 +18,18 @@ const (
 	SET
 	ZSET
 	LIST
+	STREAM
+)
+
+// 键空间通知的类型，命令函数的参数 db 会遮蔽 db 包，所以在这里重新声明
+const (
+	notifyGeneric = db.NotifyGeneric
+	notifyString  = db.NotifyString
+	notifyList    = db.NotifyList
+	notifySet     = db.NotifySet
+	notifyHash    = db.NotifyHash
+	notifyZSet    = db.NotifyZSet
+	notifyStream  = db.NotifyStream
 )
 
 func checkType(value any, vt valueType) resp.RedisData {
@@ -43,6 +58,10 @@ func checkType(value any, vt valueType) resp.RedisData {
 		case ZSET:
 			// 复杂数据类型全部为指针
 			_, typeOk = value.(*structure.ZSet)
+
+		case STREAM:
+			// 复杂数据类型全部为指针
+			_, typeOk = value.(*structure.Stream)
 		}
 
 		if !typeOk {
@@ -70,3 +89,70 @@ func checkCommandAndLength(cmd *[][]byte, name string, minLength int) (resp.Redi
 
 	return nil, true
 }
+
+// scanOptions 是 scan 系列命令的可选参数
+type scanOptions struct {
+	pattern string // glob 风格的匹配模式，空字符串代表不进行过滤
+	count   int    // 每一次遍历的数量提示
+	typ     string // 只返回指定类型的键，只有 scan 命令支持
+}
+
+// parseScanCursor 解析 scan 系列命令的游标
+func parseScanCursor(arg []byte) (int, resp.RedisData) {
+	cursor, err := strconv.Atoi(string(arg))
+	if err != nil || cursor < 0 {
+		return 0, resp.MakeErrorData("ERR invalid cursor")
+	}
+	return cursor, nil
+}
+
+// parseScanOptions 解析 scan 系列命令中游标之后的 MATCH、COUNT 以及 TYPE 参数，allowType 代表是否支持 TYPE 参数
+func parseScanOptions(args [][]byte, allowType bool) (*scanOptions, resp.RedisData) {
+
+	opts := &scanOptions{count: 10}
+
+	if len(args)%2 != 0 {
+		return nil, resp.MakeErrorData("ERR syntax error")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			opts.pattern = string(args[i+1])
+			if opts.pattern == "*" {
+				opts.pattern = ""
+			}
+		case "count":
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, resp.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			if count < 1 {
+				return nil, resp.MakeErrorData("ERR syntax error")
+			}
+			opts.count = count
+		case "type":
+			if !allowType {
+				return nil, resp.MakeErrorData("ERR syntax error")
+			}
+			opts.typ = strings.ToLower(string(args[i+1]))
+		default:
+			return nil, resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	return opts, nil
+}
+
+// matched 判断键是否满足 MATCH 参数
+func (opts *scanOptions) matched(key string) bool {
+	return opts.pattern == "" || utils.GlobMatch(opts.pattern, key)
+}
+
+// makeScanResult 生成 scan 系列命令的返回值，第一项为下一次遍历的游标，第二项为遍历结果
+func makeScanResult(cursor int, items []resp.RedisData) resp.RedisData {
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte(strconv.Itoa(cursor))),
+		resp.MakeArrayData(items),
+	})
+}
@@ -0,0 +1,566 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/resp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseGeoUnit 返回距离单位对应的米数
+func parseGeoUnit(arg []byte) (float64, resp.RedisData) {
+	switch strings.ToLower(string(arg)) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, resp.MakeErrorData("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// parseGeoFloat 解析经纬度、半径等浮点数参数
+func parseGeoFloat(arg []byte) (float64, resp.RedisData) {
+	f, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil {
+		return 0, resp.MakeErrorData("ERR value is not a valid float")
+	}
+	return f, nil
+}
+
+// makeGeoCoordinate 以 17 位小数的精度返回坐标，并去除末尾多余的 0
+func makeGeoCoordinate(v float64) resp.RedisData {
+	s := strconv.FormatFloat(v, 'f', 17, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return resp.MakeBulkData([]byte(s))
+}
+
+func makeGeoDistance(distance, unit float64) resp.RedisData {
+	return resp.MakeBulkData([]byte(fmt.Sprintf("%.4f", distance/unit)))
+}
+
+// getGeoSet 获取 key 对应的 ZSet，如果 key 不存在将返回 nil
+func getGeoSet(db *db.DataBase, key []byte) (*structure.ZSet, resp.RedisData) {
+
+	value, ok := db.GetKey(string(key))
+	if !ok {
+		return nil, nil
+	}
+
+	// 进行类型检查，会自动检查过期选项
+	if err := checkType(value, ZSET); err != nil {
+		return nil, err
+	}
+
+	return value.(*structure.ZSet), nil
+}
+
+// geoMemberPosition 返回成员的经纬度
+func geoMemberPosition(zset *structure.ZSet, member string) (float64, float64, bool) {
+	if zset == nil {
+		return 0, 0, false
+	}
+	score, ok := zset.GetScoreByKey(member)
+	if !ok {
+		return 0, 0, false
+	}
+	longitude, latitude := structure.GeoDecode(score)
+	return longitude, latitude, true
+}
+
+func geoAdd(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "geoadd", 5)
+	if !ok {
+		return e
+	}
+
+	nx, xx, ch := false, false, false
+
+	i := 2
+	for ; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "nx":
+			nx = true
+			continue
+		case "xx":
+			xx = true
+			continue
+		case "ch":
+			ch = true
+			continue
+		}
+		break
+	}
+
+	if nx && xx {
+		return resp.MakeErrorData("ERR XX and NX options at the same time are not compatible")
+	}
+
+	if i >= len(cmd) || (len(cmd)-i)%3 != 0 {
+		return resp.MakeErrorData("ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... ")
+	}
+
+	// 先解析所有坐标，避免写入到一半时出错
+	scores := make([]structure.Float64, 0, (len(cmd)-i)/3)
+	members := make([]string, 0, (len(cmd)-i)/3)
+
+	for ; i < len(cmd); i += 3 {
+		longitude, err := parseGeoFloat(cmd[i])
+		if err != nil {
+			return err
+		}
+		latitude, err := parseGeoFloat(cmd[i+1])
+		if err != nil {
+			return err
+		}
+		if !structure.GeoValid(longitude, latitude) {
+			return resp.MakeErrorData(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+		}
+		scores = append(scores, structure.GeoScore(longitude, latitude))
+		members = append(members, string(cmd[i+2]))
+	}
+
+	zset, err := getGeoSet(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	created := zset == nil
+	if created {
+		zset = structure.NewZSet()
+	}
+
+	oldCost := zset.Cost()
+	added, changed := 0, 0
+
+	for j, member := range members {
+		old, exist := zset.GetScoreByKey(member)
+		if (nx && exist) || (xx && !exist) {
+			continue
+		}
+		if !exist {
+			zset.AddIfNotExist(scores[j], member)
+			added++
+		} else if old != scores[j] {
+			zset.Add(scores[j], member)
+			changed++
+		}
+	}
+
+	if created {
+		if zset.Size() > 0 {
+			db.SetKey(string(cmd[1]), zset)
+		}
+	} else {
+		db.ReviseNotify(string(cmd[1]), oldCost, zset.Cost())
+	}
+	if added+changed > 0 {
+		db.NotifyKeyspaceEvent(notifyZSet, "zadd", string(cmd[1]))
+	}
+
+	if ch {
+		return resp.MakeIntData(int64(added + changed))
+	}
+	return resp.MakeIntData(int64(added))
+}
+
+func geoPos(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "geopos", 2)
+	if !ok {
+		return e
+	}
+
+	zset, err := getGeoSet(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	res := make([]resp.RedisData, len(cmd)-2)
+	for i, member := range cmd[2:] {
+		longitude, latitude, exist := geoMemberPosition(zset, string(member))
+		if !exist {
+			res[i] = resp.MakeNullData()
+			continue
+		}
+		res[i] = resp.MakeArrayData([]resp.RedisData{makeGeoCoordinate(longitude), makeGeoCoordinate(latitude)})
+	}
+
+	return resp.MakeArrayData(res)
+}
+
+func geoDist(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "geodist", 4)
+	if !ok {
+		return e
+	}
+
+	unit := 1.0
+	if len(cmd) == 5 {
+		var err resp.RedisData
+		if unit, err = parseGeoUnit(cmd[4]); err != nil {
+			return err
+		}
+	} else if len(cmd) > 5 {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	zset, err := getGeoSet(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	long1, lat1, ok1 := geoMemberPosition(zset, string(cmd[2]))
+	long2, lat2, ok2 := geoMemberPosition(zset, string(cmd[3]))
+	if !ok1 || !ok2 {
+		return resp.MakeNullData()
+	}
+
+	return makeGeoDistance(structure.GeoDistance(long1, lat1, long2, lat2), unit)
+}
+
+func geoHash(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "geohash", 2)
+	if !ok {
+		return e
+	}
+
+	zset, err := getGeoSet(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	res := make([]resp.RedisData, len(cmd)-2)
+	for i, member := range cmd[2:] {
+		if zset == nil {
+			res[i] = resp.MakeNullData()
+			continue
+		}
+		score, exist := zset.GetScoreByKey(string(member))
+		if !exist {
+			res[i] = resp.MakeNullData()
+			continue
+		}
+		res[i] = resp.MakeBulkData([]byte(structure.GeoHashString(score)))
+	}
+
+	return resp.MakeArrayData(res)
+}
+
+// geoSearchOptions 是 GEOSEARCH 与 GEOSEARCHSTORE 命令的参数
+type geoSearchOptions struct {
+	fromMember []byte // FROMMEMBER 参数，为 nil 时使用 FROMLONLAT
+	shape      structure.GeoShape
+	unit       float64 // 返回距离时使用的单位
+	sort       int     // 0 不排序，1 升序，-1 降序
+	count      int     // 0 代表不限制
+	any        bool
+	withCoord  bool
+	withDist   bool
+	withHash   bool
+	storeDist  bool
+}
+
+// parseGeoSearchOptions 解析 GEOSEARCH 系列命令的参数，store 代表是否为 GEOSEARCHSTORE
+func parseGeoSearchOptions(args [][]byte, store bool) (*geoSearchOptions, resp.RedisData) {
+
+	opts := &geoSearchOptions{}
+	fromLonLat, byRadius, byBox := false, false, false
+
+	for i := 0; i < len(args); i++ {
+
+		option := strings.ToLower(string(args[i]))
+		remain := len(args) - i - 1
+
+		switch {
+		case option == "frommember" && remain >= 1:
+			if opts.fromMember != nil || fromLonLat {
+				return nil, resp.MakeErrorData("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+			opts.fromMember = args[i+1]
+			i++
+
+		case option == "fromlonlat" && remain >= 2:
+			if opts.fromMember != nil || fromLonLat {
+				return nil, resp.MakeErrorData("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+			}
+			longitude, err := parseGeoFloat(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			latitude, err := parseGeoFloat(args[i+2])
+			if err != nil {
+				return nil, err
+			}
+			if !structure.GeoValid(longitude, latitude) {
+				return nil, resp.MakeErrorData(fmt.Sprintf("ERR invalid longitude,latitude pair %f,%f", longitude, latitude))
+			}
+			opts.shape.Longitude, opts.shape.Latitude = longitude, latitude
+			fromLonLat = true
+			i += 2
+
+		case option == "byradius" && remain >= 2:
+			if byRadius || byBox {
+				return nil, resp.MakeErrorData("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+			radius, err := parseGeoFloat(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if radius < 0 {
+				return nil, resp.MakeErrorData("ERR radius cannot be negative")
+			}
+			if opts.unit, err = parseGeoUnit(args[i+2]); err != nil {
+				return nil, err
+			}
+			opts.shape.Radius = radius * opts.unit
+			byRadius = true
+			i += 2
+
+		case option == "bybox" && remain >= 3:
+			if byRadius || byBox {
+				return nil, resp.MakeErrorData("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+			}
+			width, err := parseGeoFloat(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			height, err := parseGeoFloat(args[i+2])
+			if err != nil {
+				return nil, err
+			}
+			if width < 0 || height < 0 {
+				return nil, resp.MakeErrorData("ERR height or width cannot be negative")
+			}
+			if opts.unit, err = parseGeoUnit(args[i+3]); err != nil {
+				return nil, err
+			}
+			opts.shape.Width, opts.shape.Height = width*opts.unit, height*opts.unit
+			byBox = true
+			i += 3
+
+		case option == "asc":
+			opts.sort = 1
+
+		case option == "desc":
+			opts.sort = -1
+
+		case option == "count" && remain >= 1:
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil || count <= 0 {
+				return nil, resp.MakeErrorData("ERR COUNT must be > 0")
+			}
+			opts.count = count
+			i++
+			if i+1 < len(args) && strings.ToLower(string(args[i+1])) == "any" {
+				opts.any = true
+				i++
+			}
+
+		case option == "any":
+			return nil, resp.MakeErrorData("ERR the ANY argument requires COUNT argument")
+
+		case option == "withcoord" && !store:
+			opts.withCoord = true
+
+		case option == "withdist" && !store:
+			opts.withDist = true
+
+		case option == "withhash" && !store:
+			opts.withHash = true
+
+		case option == "storedist" && store:
+			opts.storeDist = true
+
+		default:
+			return nil, resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	if opts.fromMember == nil && !fromLonLat {
+		return nil, resp.MakeErrorData("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+	}
+	if !byRadius && !byBox {
+		return nil, resp.MakeErrorData("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+	}
+
+	// 限制数量时默认按照距离升序返回
+	if opts.count > 0 && opts.sort == 0 && !opts.any {
+		opts.sort = 1
+	}
+
+	return opts, nil
+}
+
+// geoPoint 是搜索结果中的一个点
+type geoPoint struct {
+	member    string
+	score     structure.Float64
+	distance  float64
+	longitude float64
+	latitude  float64
+}
+
+// geoSearch 在 zset 中搜索位于范围内的点，并按照参数排序与截断
+func geoSearch(zset *structure.ZSet, opts *geoSearchOptions) ([]geoPoint, resp.RedisData) {
+
+	if opts.fromMember != nil {
+		longitude, latitude, exist := geoMemberPosition(zset, string(opts.fromMember))
+		if !exist {
+			return nil, resp.MakeErrorData("ERR could not decode requested zset member")
+		}
+		opts.shape.Longitude, opts.shape.Latitude = longitude, latitude
+	}
+
+	points := make([]geoPoint, 0)
+
+	// 只需要检查中心区域以及相邻的 8 个区域
+	for _, r := range opts.shape.ScoreRanges() {
+		members, _ := zset.GetKeysByRange(structure.ScoreRange{Min: r[0], Max: r[1], MaxEx: true})
+		for _, member := range members {
+			score, _ := zset.GetScoreByKey(member)
+			longitude, latitude := structure.GeoDecode(score)
+			distance, inside := opts.shape.Contains(longitude, latitude)
+			if !inside {
+				continue
+			}
+			points = append(points, geoPoint{member, score, distance, longitude, latitude})
+			if opts.any && len(points) >= opts.count {
+				break
+			}
+		}
+		if opts.any && len(points) >= opts.count {
+			break
+		}
+	}
+
+	switch opts.sort {
+	case 1:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].distance < points[j].distance })
+	case -1:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].distance > points[j].distance })
+	}
+
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+
+	return points, nil
+}
+
+func geoSearchCommand(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "geosearch", 7)
+	if !ok {
+		return e
+	}
+
+	opts, err := parseGeoSearchOptions(cmd[2:], false)
+	if err != nil {
+		return err
+	}
+
+	zset, err := getGeoSet(db, cmd[1])
+	if err != nil {
+		return err
+	}
+	if zset == nil {
+		return resp.MakeEmptyArrayData()
+	}
+
+	points, err := geoSearch(zset, opts)
+	if err != nil {
+		return err
+	}
+
+	res := make([]resp.RedisData, len(points))
+	for i, point := range points {
+
+		if !opts.withDist && !opts.withHash && !opts.withCoord {
+			res[i] = resp.MakeBulkData([]byte(point.member))
+			continue
+		}
+
+		item := []resp.RedisData{resp.MakeBulkData([]byte(point.member))}
+		if opts.withDist {
+			item = append(item, makeGeoDistance(point.distance, opts.unit))
+		}
+		if opts.withHash {
+			item = append(item, resp.MakeIntData(int64(point.score)))
+		}
+		if opts.withCoord {
+			item = append(item, resp.MakeArrayData([]resp.RedisData{
+				makeGeoCoordinate(point.longitude), makeGeoCoordinate(point.latitude),
+			}))
+		}
+		res[i] = resp.MakeArrayData(item)
+	}
+
+	return resp.MakeArrayData(res)
+}
+
+func geoSearchStore(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "geosearchstore", 8)
+	if !ok {
+		return e
+	}
+
+	opts, err := parseGeoSearchOptions(cmd[3:], true)
+	if err != nil {
+		return err
+	}
+
+	zset, err := getGeoSet(db, cmd[2])
+	if err != nil {
+		return err
+	}
+
+	var points []geoPoint
+	if zset != nil {
+		if points, err = geoSearch(zset, opts); err != nil {
+			return err
+		}
+	}
+
+	// 没有结果时删除目标键
+	if len(points) == 0 {
+		if db.DeleteKey(string(cmd[1])) {
+			db.NotifyKeyspaceEvent(notifyGeneric, "del", string(cmd[1]))
+		}
+		return resp.MakeIntData(0)
+	}
+
+	dest := structure.NewZSet()
+	for _, point := range points {
+		score := point.score
+		if opts.storeDist {
+			score = structure.Float64(point.distance / opts.unit)
+		}
+		dest.Add(score, point.member)
+	}
+
+	db.SetKey(string(cmd[1]), dest)
+	db.RemoveTTL(string(cmd[1]))
+	db.NotifyKeyspaceEvent(notifyZSet, "geosearchstore", string(cmd[1]))
+
+	return resp.MakeIntData(int64(len(points)))
+}
+
+func registerGeoCommands() {
+	registerCommand("geoadd", geoAdd, WR)
+	registerCommand("geopos", geoPos, RD)
+	registerCommand("geodist", geoDist, RD)
+	registerCommand("geohash", geoHash, RD)
+	registerCommand("geosearch", geoSearchCommand, RD)
+	registerCommand("geosearchstore", geoSearchStore, WR)
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"strings"
+	"testing"
+)
+
+func TestCmdGeo(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	global.UpdateGlobalClock()
+	database.SetKey("str", structure.Slice("v"))
+
+	bulks := func(values ...string) resp.RedisData {
+		res := make([]resp.RedisData, len(values))
+		for i, v := range values {
+			if v == "" {
+				res[i] = resp.MakeNullData()
+			} else {
+				res[i] = resp.MakeBulkData([]byte(v))
+			}
+		}
+		return resp.MakeArrayData(res)
+	}
+
+	withDist := func(pairs ...string) resp.RedisData {
+		res := make([]resp.RedisData, 0, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			res = append(res, bulks(pairs[i], pairs[i+1]))
+		}
+		return resp.MakeArrayData(res)
+	}
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+	}{
+		{"geoadd Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania", resp.MakeIntData(2)},
+		{"geoadd Sicily NX 13.361389 38.115556 Palermo", resp.MakeIntData(0)},
+		{"geoadd Sicily XX CH 13.361389 38.115556 Palermo", resp.MakeIntData(0)},
+		{"geoadd Sicily 13.361389 38.115556 x 14", resp.MakeErrorData("ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... ")},
+		{"geoadd Sicily 181 38 x", resp.MakeErrorData("ERR invalid longitude,latitude pair 181.000000,38.000000")},
+		{"geoadd Sicily NX XX 13 38 x", resp.MakeErrorData("ERR XX and NX options at the same time are not compatible")},
+		{"geoadd none XX 13 38 x", resp.MakeIntData(0)},
+		{"exists none", resp.MakeIntData(0)},
+		{"geoadd str 13 38 x", resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"zscore Sicily Palermo", resp.MakeDoubleData(3479099956230698)},
+
+		{"geodist Sicily Palermo Catania", resp.MakeBulkData([]byte("166274.1516"))},
+		{"geodist Sicily Palermo Catania km", resp.MakeBulkData([]byte("166.2742"))},
+		{"geodist Sicily Palermo Catania mi", resp.MakeBulkData([]byte("103.3182"))},
+		{"geodist Sicily Palermo none", resp.MakeNullData()},
+		{"geodist Sicily Palermo Catania yd", resp.MakeErrorData("ERR unsupported unit provided. please use M, KM, FT, MI")},
+
+		{"geohash Sicily Palermo Catania none", bulks("sqc8b49rny0", "sqdtr74hyu0", "")},
+		{"geopos Sicily Palermo none", resp.MakeArrayData([]resp.RedisData{
+			bulks("13.36138933897018433", "38.11555639549629859"), resp.MakeNullData(),
+		})},
+
+		{"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 200 km ASC", bulks("Catania", "Palermo")},
+		{"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 100 km", bulks("Catania")},
+		{"geosearch Sicily FROMMEMBER Palermo BYRADIUS 200 km DESC", bulks("Catania", "Palermo")},
+		{"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 200 km COUNT 1", bulks("Catania")},
+		{"geosearch Sicily FROMMEMBER none BYRADIUS 200 km", resp.MakeErrorData("ERR could not decode requested zset member")},
+		{"geosearch Sicily BYRADIUS 200 km ASC WITHDIST", resp.MakeErrorData("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")},
+		{"geosearch Sicily FROMLONLAT 15 37 FROMMEMBER Palermo", resp.MakeErrorData("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")},
+		{"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 200 km BYBOX 1 1 km", resp.MakeErrorData("ERR exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")},
+		{"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 200 km COUNT 0", resp.MakeErrorData("ERR COUNT must be > 0")},
+		{"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 200 km ANY", resp.MakeErrorData("ERR the ANY argument requires COUNT argument")},
+		{"geosearch none FROMLONLAT 15 37 BYRADIUS 200 km", resp.MakeEmptyArrayData()},
+
+		{"geoadd Sicily 12.758489 38.788135 edge1 17.241510 38.788135 edge2", resp.MakeIntData(2)},
+		{"geosearch Sicily FROMLONLAT 15 37 BYBOX 400 400 km ASC WITHDIST", withDist(
+			"Catania", "56.4413", "Palermo", "190.4424", "edge2", "279.7403", "edge1", "279.7405")},
+		{"geosearch Sicily FROMLONLAT 15 37 BYBOX 200 200 km ASC", bulks("Catania")},
+		{"geosearch Sicily FROMLONLAT 15 37 BYRADIUS 200 km ASC WITHHASH WITHCOORD", resp.MakeArrayData([]resp.RedisData{
+			resp.MakeArrayData([]resp.RedisData{
+				resp.MakeBulkData([]byte("Catania")), resp.MakeIntData(3479447370796909),
+				bulks("15.08726745843887329", "37.50266842333162032"),
+			}),
+			resp.MakeArrayData([]resp.RedisData{
+				resp.MakeBulkData([]byte("Palermo")), resp.MakeIntData(3479099956230698),
+				bulks("13.36138933897018433", "38.11555639549629859"),
+			}),
+		})},
+
+		{"geosearchstore dest Sicily FROMLONLAT 15 37 BYRADIUS 200 km", resp.MakeIntData(2)},
+		{"zscore dest Palermo", resp.MakeDoubleData(3479099956230698)},
+		{"geosearchstore dest Sicily FROMLONLAT 15 37 BYRADIUS 200 km ASC COUNT 1 STOREDIST", resp.MakeIntData(1)},
+		{"zcard dest", resp.MakeIntData(1)},
+		{"geosearchstore dest Sicily FROMLONLAT 15 37 BYRADIUS 200 km WITHDIST", resp.MakeErrorData("ERR syntax error")},
+		{"geosearchstore dest Sicily FROMLONLAT 0 0 BYRADIUS 1 km", resp.MakeIntData(0)},
+		{"exists dest", resp.MakeIntData(0)},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		cmd, exist := global.FindCommand(string(input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(command)
+
+		ret := c(database, input)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+}
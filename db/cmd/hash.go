@@ -41,6 +41,7 @@ func hSet(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	}
 
 	db.ReviseNotify(string(cmd[1]), oldCost, hashVal.Cost())
+	db.NotifyKeyspaceEvent(notifyHash, "hset", string(cmd[1]))
 
 	return resp.MakeIntData(int64(l/2 - 1))
 }
@@ -81,6 +82,7 @@ func hMSet(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	}
 
 	db.ReviseNotify(string(cmd[1]), oldCost, hashVal.Cost())
+	db.NotifyKeyspaceEvent(notifyHash, "hset", string(cmd[1]))
 
 	return resp.MakeStringData("OK")
 }
@@ -194,6 +196,9 @@ func hDel(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		}
 	}
 	db.ReviseNotify(string(cmd[1]), oldCost, hashVal.Cost())
+	if deleted > 0 {
+		db.NotifyKeyspaceEvent(notifyHash, "hdel", string(cmd[1]))
+	}
 
 	return resp.MakeIntData(int64(deleted))
 }
@@ -206,7 +211,7 @@ func hGetAll(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	value, ok := db.GetKey(string(cmd[1]))
 	if !ok {
-		return resp.MakeArrayData(nil)
+		return resp.MakeMapData([]resp.RedisData{})
 	}
 
 	e = checkType(value, HASH)
@@ -228,7 +233,7 @@ func hGetAll(db *db.DataBase, cmd [][]byte) resp.RedisData {
 			i += 2
 		}
 	}
-	return resp.MakeArrayData(res)
+	return resp.MakeMapData(res)
 }
 
 func hKeys(db *db.DataBase, cmd [][]byte) resp.RedisData {
@@ -268,7 +273,7 @@ func hVals(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	value, ok := db.GetKey(string(cmd[1]))
 	if !ok {
-		return resp.MakeArrayData(nil)
+		return resp.MakeEmptyArrayData()
 	}
 
 	e = checkType(value, HASH)
@@ -320,6 +325,7 @@ func hIncrBy(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	if !ok {
 		val = structure.Slice(cmd[3])
 		hashVal.Set(string(cmd[2]), val)
+		db.NotifyKeyspaceEvent(notifyHash, "hincrby", string(cmd[1]))
 		return resp.MakeIntData(int64(increment))
 	}
 
@@ -332,6 +338,7 @@ func hIncrBy(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	hashVal.Set(string(cmd[2]), structure.Slice(strconv.Itoa(intVal)))
 
 	db.ReviseNotify(string(cmd[1]), 0, 0)
+	db.NotifyKeyspaceEvent(notifyHash, "hincrby", string(cmd[1]))
 
 	return resp.MakeIntData(int64(intVal))
 }
@@ -427,6 +434,43 @@ func hRandField(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	return resp.MakeArrayData(res)
 }
 
+func hScan(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "hscan", 3)
+	if !ok {
+		return e
+	}
+
+	cursor, e := parseScanCursor(cmd[2])
+	if e != nil {
+		return e
+	}
+
+	opts, e := parseScanOptions(cmd[3:], false)
+	if e != nil {
+		return e
+	}
+
+	value, ok := db.GetKey(string(cmd[1]))
+	if !ok {
+		return makeScanResult(0, []resp.RedisData{})
+	}
+
+	if err := checkType(value, HASH); err != nil {
+		return err
+	}
+
+	fields := make([]resp.RedisData, 0, 2*opts.count)
+
+	next := value.(*structure.Dict).Scan(cursor, opts.count, func(key string, value structure.Object) {
+		if opts.matched(key) {
+			fields = append(fields, resp.MakeBulkData([]byte(key)), resp.MakeBulkData(value.(structure.Slice)))
+		}
+	})
+
+	return makeScanResult(next, fields)
+}
+
 func registerHashCommands() {
 	registerCommand("hset", hSet, WR)
 	registerCommand("hget", hGet, RD)
@@ -441,4 +485,5 @@ func registerHashCommands() {
 	registerCommand("hlen", hLen, RD)
 	registerCommand("hstrlen", hStrLen, RD)
 	registerCommand("hrandfield", hRandField, RD)
+	registerCommand("hscan", hScan, RD)
 }
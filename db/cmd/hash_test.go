@@ -6,6 +6,7 @@ import (
 	"github.com/tangrc99/MemTable/db/structure"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
 	"testing"
 )
 
@@ -116,8 +117,13 @@ func TestCmdHashRand(t *testing.T) {
 		assert.True(t, exist)
 		c := cmd.Function().(command)
 
-		ret := c(database, test.input)
-		d := ret.(*resp.ArrayData).Data()
+		var d []resp.RedisData
+		switch ret := c(database, test.input).(type) {
+		case *resp.ArrayData:
+			d = ret.Data()
+		case *resp.MapData:
+			d = ret.Data()
+		}
 		assert.Subset(t, test.expected, d)
 	}
 }
@@ -151,10 +157,59 @@ func TestCmdHashAll(t *testing.T) {
 		assert.True(t, exist)
 		c := cmd.Function().(command)
 
-		ret := c(database, test.input)
-		d := ret.(*resp.ArrayData).Data()
+		var d []resp.RedisData
+		switch ret := c(database, test.input).(type) {
+		case *resp.ArrayData:
+			d = ret.Data()
+		case *resp.MapData:
+			d = ret.Data()
+		}
 		assert.Subset(t, test.expected, d)
 		assert.Equal(t, len(test.expected), len(d))
 	}
 
+	c, _ := global.FindCommand("hvals")
+	// 不存在的 key 返回空数组而不是 null 数组
+	assert.Equal(t, []byte("*0\r\n"), c.Function().(command)(database, [][]byte{[]byte("hvals"), []byte("none")}).ToBytes())
+}
+
+func TestCmdHashScan(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	hash := structure.NewDict(1)
+	hash.Set("f1", Slice("v1"))
+	hash.Set("f2", Slice("v2"))
+	hash.Set("g1", Slice("v3"))
+	database.SetKey("hash", hash)
+	database.SetKey("str", Slice("v"))
+
+	fields := scanAll(t, database, [][]byte{[]byte("hscan"), []byte("hash"), nil, []byte("MATCH"), []byte("f*")}, 2)
+	assert.ElementsMatch(t, []resp.RedisData{
+		resp.MakeBulkData([]byte("f1")), resp.MakeBulkData([]byte("v1")),
+		resp.MakeBulkData([]byte("f2")), resp.MakeBulkData([]byte("v2")),
+	}, fields)
+
+	assert.Empty(t, scanAll(t, database, [][]byte{[]byte("hscan"), []byte("none"), nil}, 2))
+
+	ret := hScan(database, [][]byte{[]byte("hscan"), []byte("str"), []byte("0")})
+	assert.Equal(t, resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value"), ret)
+
+	ret = hScan(database, [][]byte{[]byte("hscan"), []byte("hash"), []byte("0"), []byte("TYPE"), []byte("hash")})
+	assert.Equal(t, resp.MakeErrorData("ERR syntax error"), ret)
+
+	// 大哈希表的分片会随着字段数量增长，每一次 HSCAN 只返回与 COUNT 相近数量的字段
+	for i := 0; i < 1000; i++ {
+		hSet(database, [][]byte{[]byte("hset"), []byte("big"), []byte(strconv.Itoa(i)), []byte("v")})
+	}
+	ret = hScan(database, [][]byte{[]byte("hscan"), []byte("big"), []byte("0"), []byte("COUNT"), []byte("10")})
+	page := ret.(*resp.ArrayData).Data()
+	assert.NotEqual(t, []byte("0"), page[0].(*resp.BulkData).Data())
+	assert.Less(t, len(page[1].(*resp.ArrayData).Data()), 2*100)
+
+	fields = scanAll(t, database, [][]byte{[]byte("hscan"), []byte("big"), nil, []byte("COUNT"), []byte("10")}, 2)
+	seen := make(map[string]struct{})
+	for i := 0; i < len(fields); i += 2 {
+		seen[string(fields[i].(*resp.BulkData).Data())] = struct{}{}
+	}
+	assert.Equal(t, 1000, len(seen))
 }
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/resp"
+)
+
+// getHyperLogLog 获取 key 对应的 HyperLogLog，如果 key 不存在将返回 nil。HyperLogLog 以字符串的形式存储。
+// 返回的是存储值的拷贝，修改后需要重新写入，避免原地修改被快照或其他引用观察到
+func getHyperLogLog(db *db.DataBase, key []byte) (*structure.HyperLogLog, resp.RedisData) {
+
+	value, ok := db.GetKey(string(key))
+	if !ok {
+		return nil, nil
+	}
+
+	// 进行类型检查，会自动检查过期选项
+	if err := checkType(value, STRING); err != nil {
+		return nil, err
+	}
+
+	hll, err := structure.NewHyperLogLogFromBytes(append([]byte(nil), value.(structure.Slice)...))
+	if err != nil {
+		return nil, resp.MakeErrorData(err.Error())
+	}
+
+	return hll, nil
+}
+
+func pfAdd(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "pfadd", 2)
+	if !ok {
+		return e
+	}
+
+	hll, err := getHyperLogLog(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	created := hll == nil
+	if created {
+		hll = structure.NewHyperLogLog()
+	}
+
+	updated, e2 := hll.Add(cmd[2:]...)
+	if e2 != nil {
+		return resp.MakeErrorData(e2.Error())
+	}
+
+	if !created && !updated {
+		return resp.MakeIntData(0)
+	}
+
+	// sparse 编码修改后可能会重新分配内存，需要重新写入
+	db.SetKey(string(cmd[1]), structure.Slice(*hll))
+	db.NotifyKeyspaceEvent(notifyString, "pfadd", string(cmd[1]))
+
+	return resp.MakeIntData(1)
+}
+
+func pfCount(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "pfcount", 2)
+	if !ok {
+		return e
+	}
+
+	// 单个 key 的基数可以直接使用 HyperLogLog 头部的缓存
+	if len(cmd) == 2 {
+		hll, err := getHyperLogLog(db, cmd[1])
+		if err != nil {
+			return err
+		}
+		if hll == nil {
+			return resp.MakeIntData(0)
+		}
+		count, e2 := hll.Count()
+		if e2 != nil {
+			return resp.MakeErrorData(e2.Error())
+		}
+		return resp.MakeIntData(int64(count))
+	}
+
+	hlls := make([]*structure.HyperLogLog, 0, len(cmd)-1)
+	for _, key := range cmd[1:] {
+		hll, err := getHyperLogLog(db, key)
+		if err != nil {
+			return err
+		}
+		if hll != nil {
+			hlls = append(hlls, hll)
+		}
+	}
+
+	count, err := structure.CountUnion(hlls...)
+	if err != nil {
+		return resp.MakeErrorData(err.Error())
+	}
+
+	return resp.MakeIntData(int64(count))
+}
+
+func pfMerge(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "pfmerge", 2)
+	if !ok {
+		return e
+	}
+
+	dest, err := getHyperLogLog(db, cmd[1])
+	if err != nil {
+		return err
+	}
+	if dest == nil {
+		dest = structure.NewHyperLogLog()
+	}
+
+	sources := make([]*structure.HyperLogLog, 0, len(cmd)-2)
+	for _, key := range cmd[2:] {
+		hll, err := getHyperLogLog(db, key)
+		if err != nil {
+			return err
+		}
+		if hll != nil {
+			sources = append(sources, hll)
+		}
+	}
+
+	if e2 := dest.Merge(sources...); e2 != nil {
+		return resp.MakeErrorData(e2.Error())
+	}
+
+	db.SetKey(string(cmd[1]), structure.Slice(*dest))
+	db.NotifyKeyspaceEvent(notifyString, "pfadd", string(cmd[1]))
+
+	return resp.MakeStringData("OK")
+}
+
+func registerHyperLogLogCommands() {
+	registerCommand("pfadd", pfAdd, WR)
+	registerCommand("pfcount", pfCount, RD)
+	registerCommand("pfmerge", pfMerge, WR)
+}
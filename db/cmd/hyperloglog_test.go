@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestCmdHyperLogLog(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	global.UpdateGlobalClock()
+	database.SetKey("str", structure.Slice("v"))
+	database.SetKey("list", structure.NewList())
+
+	// 所有寄存器均为 63 的 dense 编码，计数时不能越界，估计值为无穷大时返回最大值
+	dense := append([]byte("HYLL\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x80"), []byte(strings.Repeat("\xff", 12288))...)
+	database.SetKey("dense", structure.Slice(dense))
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+	}{
+		{"pfadd h1", resp.MakeIntData(1)},
+		{"pfadd h1", resp.MakeIntData(0)},
+		{"pfcount h1", resp.MakeIntData(0)},
+		{"pfadd h1 a b c", resp.MakeIntData(1)},
+		{"pfadd h1 a b", resp.MakeIntData(0)},
+		{"pfcount h1", resp.MakeIntData(3)},
+		{"pfadd h2 c d e f", resp.MakeIntData(1)},
+		{"pfcount h1 h2 none", resp.MakeIntData(6)},
+		{"pfcount none", resp.MakeIntData(0)},
+		{"pfmerge h3 h1 h2 none", resp.MakeStringData("OK")},
+		{"pfcount h3", resp.MakeIntData(6)},
+		{"pfmerge h1 h2", resp.MakeStringData("OK")},
+		{"pfcount h1", resp.MakeIntData(6)},
+		{"pfadd str a", resp.MakeErrorData("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+		{"pfcount h1 str", resp.MakeErrorData("WRONGTYPE Key is not a valid HyperLogLog string value.")},
+		{"pfmerge h1 list", resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"type h1", resp.MakeStringData("string")},
+		{"pfcount dense", resp.MakeIntData(math.MaxInt64)},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		cmd, exist := global.FindCommand(string(input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(command)
+
+		ret := c(database, input)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+
+	// HyperLogLog 以 redis 兼容的字符串格式存储
+	value, _ := database.GetKey("h3")
+	assert.Equal(t, "HYLL", string(value.(structure.Slice)[:4]))
+
+	// pfadd 和 pfcount 不能原地修改已经存储的字符串
+	stored := value.(structure.Slice)
+	before := string(stored)
+	assert.Equal(t, resp.MakeIntData(1), pfAdd(database, [][]byte{[]byte("pfadd"), []byte("h3"), []byte("x")}))
+	assert.Equal(t, before, string(stored))
+	stored2, _ := database.GetKey("h3")
+	before = string(stored2.(structure.Slice))
+	assert.Equal(t, resp.MakeIntData(7), pfCount(database, [][]byte{[]byte("pfcount"), []byte("h3")}))
+	assert.Equal(t, before, string(stored2.(structure.Slice)))
+}
@@ -6,7 +6,9 @@ import (
 	"github.com/tangrc99/MemTable/db/structure"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
+	"math"
 	"strconv"
+	"strings"
 )
 
 // del 删除多个键，并返回删除数量
@@ -22,6 +24,7 @@ func del(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	for _, key := range cmd[1:] {
 		if db.DeleteKey(string(key)) {
+			db.NotifyKeyspaceEvent(notifyGeneric, "del", string(key))
 			deleted++
 		}
 	}
@@ -50,6 +53,26 @@ func exists(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	return resp.MakeIntData(int64(exist))
 }
 
+// expireAtMillis 将 value 个 unit 毫秒转换为毫秒级的过期时间戳，relative 代表 value 是相对于当前时间的时长。
+// 计算结果溢出时返回 false
+func expireAtMillis(value, unit int64, relative bool) (int64, bool) {
+
+	if value > math.MaxInt64/unit || value < math.MinInt64/unit {
+		return 0, false
+	}
+	ms := value * unit
+
+	if relative {
+		now := global.Now.UnixMilli()
+		if ms > math.MaxInt64-now {
+			return 0, false
+		}
+		ms += now
+	}
+
+	return ms, true
+}
+
 func expire(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	// 进行输入类型检查
@@ -63,38 +86,47 @@ func expire(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		return resp.MakeErrorData(fmt.Sprintf("error: %s is not int", string(cmd[2])))
 	}
 
-	tp := global.Now.Unix() + period
+	tp, ok := expireAtMillis(period, 1000, true)
+	if !ok {
+		return resp.MakeErrorData("ERR invalid expire time in 'expire' command")
+	}
 
 	ok = db.SetTTL(string(cmd[1]), tp)
 
 	if ok {
+		db.NotifyKeyspaceEvent(notifyGeneric, "expire", string(cmd[1]))
 		return resp.MakeIntData(1)
 	}
 	return resp.MakeIntData(0)
 }
 
-/*
 func expireAt(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
-		// 进行输入类型检查
-		e, ok := checkCommandAndLength(&cmd, "expireat", 3)
-		if !ok {
-			return e
-		}
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "expireat", 3)
+	if !ok {
+		return e
+	}
 
-		tp, err := strconv.ParseInt(string(cmd[2]), 10, 64)
-		if err != nil {
-			return resp.MakeErrorData(fmt.Sprintf("error: %s is not int", string(cmd[2])))
-		}
+	tp, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorData(fmt.Sprintf("error: %s is not int", string(cmd[2])))
+	}
 
-		ok = db.SetTTL(string(cmd[1]), tp)
+	tp, ok = expireAtMillis(tp, 1000, false)
+	if !ok {
+		return resp.MakeErrorData("ERR invalid expire time in 'expireat' command")
+	}
 
-		if ok {
-			return resp.MakeIntData(1)
-		}
-		return resp.MakeIntData(0)
+	ok = db.SetTTL(string(cmd[1]), tp)
+
+	if ok {
+		db.NotifyKeyspaceEvent(notifyGeneric, "expire", string(cmd[1]))
+		return resp.MakeIntData(1)
 	}
-*/
+	return resp.MakeIntData(0)
+}
+
 func pExpire(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	// 进行输入类型检查
@@ -108,17 +140,20 @@ func pExpire(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		return resp.MakeErrorData(fmt.Sprintf("error: %s is not int", string(cmd[2])))
 	}
 
-	tp := global.Now.Unix() + period/1000
+	tp, ok := expireAtMillis(period, 1, true)
+	if !ok {
+		return resp.MakeErrorData("ERR invalid expire time in 'pexpire' command")
+	}
 
 	ok = db.SetTTL(string(cmd[1]), tp)
 
 	if ok {
+		db.NotifyKeyspaceEvent(notifyGeneric, "expire", string(cmd[1]))
 		return resp.MakeIntData(1)
 	}
 	return resp.MakeIntData(0)
 }
 
-/*
 func pExpireAt(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	// 进行输入类型检查
@@ -135,11 +170,11 @@ func pExpireAt(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	ok = db.SetTTL(string(cmd[1]), tp)
 
 	if ok {
+		db.NotifyKeyspaceEvent(notifyGeneric, "expire", string(cmd[1]))
 		return resp.MakeIntData(1)
 	}
 	return resp.MakeIntData(0)
 }
-*/
 
 // keys 返回所有键，首行为个数
 func keys(db *db.DataBase, cmd [][]byte) resp.RedisData {
@@ -175,11 +210,72 @@ func ttl(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		return err
 	}
 
+	tp := db.GetTTL(string(cmd[1]))
+	if tp < 0 {
+		return resp.MakeIntData(tp)
+	}
+
+	// 精度为秒，需要四舍五入
+	return resp.MakeIntData((tp + 500) / 1000)
+}
+
+func pTTL(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	err, ok := checkCommandAndLength(&cmd, "pttl", 2)
+	if !ok {
+		return err
+	}
+
 	tp := db.GetTTL(string(cmd[1]))
 
 	return resp.MakeIntData(tp)
 }
 
+func expireTime(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	err, ok := checkCommandAndLength(&cmd, "expiretime", 2)
+	if !ok {
+		return err
+	}
+
+	tp := db.GetExpireAt(string(cmd[1]))
+	if tp < 0 {
+		return resp.MakeIntData(tp)
+	}
+
+	return resp.MakeIntData(tp / 1000)
+}
+
+func pExpireTime(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	err, ok := checkCommandAndLength(&cmd, "pexpiretime", 2)
+	if !ok {
+		return err
+	}
+
+	tp := db.GetExpireAt(string(cmd[1]))
+
+	return resp.MakeIntData(tp)
+}
+
+func persist(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	err, ok := checkCommandAndLength(&cmd, "persist", 2)
+	if !ok {
+		return err
+	}
+
+	// 键不存在或没有设置过期时间
+	if db.GetExpireAt(string(cmd[1])) < 0 {
+		return resp.MakeIntData(0)
+	}
+
+	db.RemoveTTL(string(cmd[1]))
+	db.NotifyKeyspaceEvent(notifyGeneric, "persist", string(cmd[1]))
+
+	return resp.MakeIntData(1)
+}
+
 func randomKey(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	// 进行输入类型检查
 	err, ok := checkCommandAndLength(&cmd, "randomkey", 1)
@@ -210,9 +306,31 @@ func rename(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		return resp.MakeErrorData("error: no such key")
 	}
 
+	db.NotifyKeyspaceEvent(notifyGeneric, "rename_from", oldKey)
+	db.NotifyKeyspaceEvent(notifyGeneric, "rename_to", newKey)
+
 	return resp.MakeStringData("OK")
 }
 
+// typeName 返回值对应的类型名称
+func typeName(value structure.Object) string {
+
+	if _, ok := value.(structure.Slice); ok {
+		return "string"
+	} else if _, ok := value.(*structure.List); ok {
+		return "list"
+	} else if _, ok := value.(*structure.Dict); ok {
+		return "hash"
+	} else if _, ok := value.(*structure.Set); ok {
+		return "set"
+	} else if _, ok := value.(*structure.ZSet); ok {
+		return "zset"
+	} else if _, ok := value.(*structure.Stream); ok {
+		return "stream"
+	}
+	return ""
+}
+
 func typeKey(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	// 进行输入类型检查
 	err, ok := checkCommandAndLength(&cmd, "type", 2)
@@ -222,26 +340,178 @@ func typeKey(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	value, ok := db.GetKey(string(cmd[1]))
 
-	typeName := ""
+	if !ok {
+		return resp.MakeStringData("none")
+	}
 
+	return resp.MakeStringData(typeName(value))
+}
+
+// object 用于查看键值对的内部信息，命令格式： object encoding|freq|idletime|refcount key
+func object(base *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "object", 2)
 	if !ok {
-		typeName = "none"
-	} else {
+		return e
+	}
+
+	subcommand := strings.ToLower(string(cmd[1]))
+	if len(cmd) != 3 || (subcommand != "encoding" && subcommand != "freq" && subcommand != "idletime" && subcommand != "refcount") {
+		return resp.MakeErrorData(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try OBJECT HELP.", cmd[1]))
+	}
+
+	key := string(cmd[2])
+
+	// 查看信息时不能更新键的访问记录
+	value, ok := base.PeekKey(key)
+	if !ok {
+		return resp.MakeNullData()
+	}
+
+	switch subcommand {
+	case "encoding":
+		return resp.MakeBulkData([]byte(db.ObjectEncoding(value)))
+
+	case "freq":
+		if base.EvictPolicy() != db.EvictLFU {
+			return resp.MakeErrorData("ERR An LFU maxmemory policy is not selected, access frequency not tracked.")
+		}
+		return resp.MakeIntData(base.KeyFrequency(key))
+
+	case "idletime":
+		if base.EvictPolicy() == db.EvictLFU {
+			return resp.MakeErrorData("ERR An LFU maxmemory policy is selected, idle time not tracked.")
+		}
+		return resp.MakeIntData(base.KeyIdleTime(key))
+	}
+
+	// 值对象不会在键之间共享，引用计数总是 1
+	return resp.MakeIntData(1)
+}
+
+// dump 将键对应的值序列化为 redis 兼容的格式，序列化结果可以通过 restore 命令恢复
+func dump(base *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "dump", 2)
+	if !ok {
+		return e
+	}
+
+	value, ok := base.GetKey(string(cmd[1]))
+	if !ok {
+		return resp.MakeNullData()
+	}
+
+	payload, err := db.DumpValue(value)
+	if err != nil {
+		return resp.MakeErrorData(err.Error())
+	}
+
+	return resp.MakeBulkData(payload)
+}
+
+// restore 使用 dump 命令的序列化结果创建键，命令格式： restore key ttl serialized-value [REPLACE] [ABSTTL]
+func restore(base *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "restore", 4)
+	if !ok {
+		return e
+	}
+
+	key := string(cmd[1])
+	replace, absTTL := false, false
+
+	for _, opt := range cmd[4:] {
+		switch strings.ToLower(string(opt)) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		default:
+			return resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	ttl, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorData("ERR value is not an integer or out of range")
+	} else if ttl < 0 {
+		return resp.MakeErrorData("ERR Invalid TTL value, must be >= 0")
+	}
+
+	if !replace && base.ExistKey(key) {
+		return resp.MakeErrorData("BUSYKEY Target key name already exists.")
+	}
+
+	value, err := db.RestoreValue(cmd[3])
+	if err != nil {
+		return resp.MakeErrorData(err.Error())
+	}
+
+	if ttl > 0 && !absTTL {
+		ttl += global.Now.UnixMilli()
+	}
+
+	deleted := replace && base.DeleteKey(key)
 
-		if _, ok := value.(structure.Slice); ok {
-			typeName = "string"
-		} else if _, ok := value.(*structure.List); ok {
-			typeName = "list"
-		} else if _, ok := value.(*structure.Dict); ok {
-			typeName = "hash"
-		} else if _, ok := value.(*structure.Set); ok {
-			typeName = "set"
-		} else if _, ok := value.(*structure.ZSet); ok {
-			typeName = "zset"
+	// 已经过期的键不需要创建，被替换的旧键视为被删除
+	if ttl > 0 && ttl <= global.Now.UnixMilli() {
+		if deleted {
+			base.NotifyKeyspaceEvent(notifyGeneric, "del", key)
 		}
+		return resp.MakeStringData("OK")
+	}
+
+	if ttl > 0 {
+		base.SetKeyWithTTL(key, value, ttl)
+	} else {
+		base.SetKey(key, value)
 	}
+	base.NotifyKeyspaceEvent(notifyGeneric, "restore", key)
+
+	return resp.MakeStringData("OK")
+}
+
+// restoreAsking 与 restore 相同，用于集群中的槽迁移。目标节点在槽迁移完成前并不负责该槽，
+// 该命令可以跳过正在迁入的槽的重定向检查，命令格式： restore-asking key ttl serialized-value [REPLACE] [ABSTTL]
+func restoreAsking(base *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "restore-asking", 4)
+	if !ok {
+		return e
+	}
+
+	return restore(base, append([][]byte{[]byte("restore")}, cmd[1:]...))
+}
+
+// scan 使用游标遍历数据库中的键，与 keys 不同，每一次调用只会遍历一部分键
+func scan(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "scan", 2)
+	if !ok {
+		return e
+	}
+
+	cursor, e := parseScanCursor(cmd[1])
+	if e != nil {
+		return e
+	}
+
+	opts, e := parseScanOptions(cmd[2:], true)
+	if e != nil {
+		return e
+	}
+
+	keys := make([]resp.RedisData, 0, opts.count)
+
+	next := db.Scan(cursor, opts.count, func(key string, value structure.Object) {
+		if !opts.matched(key) || (opts.typ != "" && typeName(value) != opts.typ) {
+			return
+		}
+		keys = append(keys, resp.MakeBulkData([]byte(key)))
+	})
 
-	return resp.MakeStringData(typeName)
+	return makeScanResult(next, keys)
 }
 
 func registerKeyCommands() {
@@ -249,12 +519,21 @@ func registerKeyCommands() {
 	registerCommand("del", del, WR)
 	registerCommand("exists", exists, RD)
 	registerCommand("keys", keys, RD)
+	registerCommand("scan", scan, RD)
 	registerCommand("ttl", ttl, RD)
-	registerCommand("expire", expire, RD)
-	//registerCommand("expireat", expireAt)
-	registerCommand("pexpire", pExpire, RD)
-	//registerCommand("pexpireat", pExpireAt)
+	registerCommand("pttl", pTTL, RD)
+	registerCommand("expiretime", expireTime, RD)
+	registerCommand("pexpiretime", pExpireTime, RD)
+	registerCommand("persist", persist, WR)
+	registerCommand("expire", expire, WR)
+	registerCommand("expireat", expireAt, WR)
+	registerCommand("pexpire", pExpire, WR)
+	registerCommand("pexpireat", pExpireAt, WR)
 	registerCommand("rename", rename, WR)
 	registerCommand("type", typeKey, RD)
+	registerCommand("object", object, RD)
+	registerCommand("dump", dump, RD)
+	registerCommand("restore", restore, WR)
+	registerCommand("restore-asking", restoreAsking, WR)
 	registerCommand("randomkey", randomKey, RD)
 }
@@ -6,6 +6,8 @@ import (
 	"github.com/tangrc99/MemTable/db/structure"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -44,6 +46,18 @@ func TestCmdKey(t *testing.T) {
 		{[][]byte{[]byte("expire"), []byte("k1"), []byte("k3")},
 			resp.MakeErrorData("error: k3 is not int")},
 
+		{[][]byte{[]byte("expire"), []byte("k1"), []byte("9223372036854775807")},
+			resp.MakeErrorData("ERR invalid expire time in 'expire' command")},
+
+		{[][]byte{[]byte("expireat"), []byte("k1"), []byte("9223372036854775807")},
+			resp.MakeErrorData("ERR invalid expire time in 'expireat' command")},
+
+		{[][]byte{[]byte("pexpire"), []byte("k1"), []byte("9223372036854775807")},
+			resp.MakeErrorData("ERR invalid expire time in 'pexpire' command")},
+
+		{[][]byte{[]byte("exists"), []byte("k1")},
+			resp.MakeIntData(1)},
+
 		{[][]byte{[]byte("expire"), []byte("k1"), []byte("10")},
 			resp.MakeIntData(1)},
 
@@ -70,6 +84,45 @@ func TestCmdKey(t *testing.T) {
 
 		{[][]byte{[]byte("pexpire"), []byte("k1"), []byte("ff")},
 			resp.MakeErrorData("error: ff is not int")},
+
+		{[][]byte{[]byte("pexpire"), []byte("k1"), []byte("1500")},
+			resp.MakeIntData(1)},
+
+		{[][]byte{[]byte("pttl"), []byte("k1")},
+			resp.MakeIntData(1500)},
+
+		{[][]byte{[]byte("ttl"), []byte("k1")},
+			resp.MakeIntData(2)},
+
+		{[][]byte{[]byte("pexpiretime"), []byte("k1")},
+			resp.MakeIntData(global.Now.UnixMilli() + 1500)},
+
+		{[][]byte{[]byte("expiretime"), []byte("k1")},
+			resp.MakeIntData((global.Now.UnixMilli() + 1500) / 1000)},
+
+		{[][]byte{[]byte("pexpireat"), []byte("k1"), []byte(strconv.FormatInt(global.Now.UnixMilli()+300, 10))},
+			resp.MakeIntData(1)},
+
+		{[][]byte{[]byte("pttl"), []byte("k1")},
+			resp.MakeIntData(300)},
+
+		{[][]byte{[]byte("persist"), []byte("k1")},
+			resp.MakeIntData(1)},
+
+		{[][]byte{[]byte("persist"), []byte("k1")},
+			resp.MakeIntData(0)},
+
+		{[][]byte{[]byte("pttl"), []byte("k1")},
+			resp.MakeIntData(-1)},
+
+		{[][]byte{[]byte("expiretime"), []byte("k1")},
+			resp.MakeIntData(-1)},
+
+		{[][]byte{[]byte("pttl"), []byte("k45")},
+			resp.MakeIntData(-2)},
+
+		{[][]byte{[]byte("pexpiretime"), []byte("k45")},
+			resp.MakeIntData(-2)},
 	}
 
 	for _, test := range tests {
@@ -81,3 +134,148 @@ func TestCmdKey(t *testing.T) {
 		assert.Equal(t, test.expected, ret)
 	}
 }
+
+// scanAll 使用 scan 系列命令完成一次完整的遍历并返回全部结果，cursorPos 为命令中游标的位置
+func scanAll(t *testing.T, database *db.DataBase, input [][]byte, cursorPos int) []resp.RedisData {
+
+	cmd, exist := global.FindCommand(string(input[0]))
+	assert.True(t, exist)
+	c := cmd.Function().(command)
+
+	items := make([]resp.RedisData, 0)
+	input[cursorPos] = []byte("0")
+
+	for {
+		ret, ok := c(database, input).(*resp.ArrayData)
+		assert.True(t, ok)
+		if !ok {
+			return items
+		}
+		items = append(items, ret.Data()[1].(*resp.ArrayData).Data()...)
+
+		cursor := ret.Data()[0].(*resp.BulkData).Data()
+		if string(cursor) == "0" {
+			return items
+		}
+		input[cursorPos] = cursor
+	}
+}
+
+func TestCmdScan(t *testing.T) {
+	database := db.NewDataBase(64)
+
+	global.UpdateGlobalClock()
+
+	for i := 0; i < 100; i++ {
+		database.SetKey("str"+strconv.Itoa(i), Slice("v"))
+	}
+	database.SetKey("list", structure.NewList())
+	database.SetKeyWithTTL("expired", Slice("v"), global.Now.UnixMilli()-1)
+
+	keys := scanAll(t, database, [][]byte{[]byte("scan"), nil}, 1)
+	assert.Equal(t, 101, len(keys))
+	assert.NotContains(t, keys, resp.MakeBulkData([]byte("expired")))
+
+	keys = scanAll(t, database, [][]byte{[]byte("scan"), nil, []byte("MATCH"), []byte("str1*"), []byte("COUNT"), []byte("5")}, 1)
+	assert.Equal(t, 11, len(keys))
+
+	keys = scanAll(t, database, [][]byte{[]byte("scan"), nil, []byte("TYPE"), []byte("list")}, 1)
+	assert.Equal(t, []resp.RedisData{resp.MakeBulkData([]byte("list"))}, keys)
+
+	tests := []struct {
+		input    [][]byte
+		expected resp.RedisData
+	}{
+		{[][]byte{[]byte("scan"), []byte("-1")},
+			resp.MakeErrorData("ERR invalid cursor")},
+
+		{[][]byte{[]byte("scan"), []byte("0"), []byte("COUNT")},
+			resp.MakeErrorData("ERR syntax error")},
+
+		{[][]byte{[]byte("scan"), []byte("0"), []byte("COUNT"), []byte("0")},
+			resp.MakeErrorData("ERR syntax error")},
+
+		{[][]byte{[]byte("scan"), []byte("0"), []byte("COUNT"), []byte("ff")},
+			resp.MakeErrorData("ERR value is not an integer or out of range")},
+
+		{[][]byte{[]byte("scan"), []byte("1000"), []byte("COUNT"), []byte("10")},
+			resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte("0")), resp.MakeArrayData([]resp.RedisData{})})},
+	}
+
+	for _, test := range tests {
+		cmd, exist := global.FindCommand(string(test.input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(command)
+
+		ret := c(database, test.input)
+		assert.Equal(t, test.expected, ret)
+	}
+}
+
+func TestCmdDumpRestore(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	global.UpdateGlobalClock()
+
+	database.SetKey("k", Slice("v"))
+
+	exec := func(args ...[]byte) resp.RedisData {
+		cmd, exist := global.FindCommand(string(args[0]))
+		assert.True(t, exist)
+		return cmd.Function().(command)(database, args)
+	}
+
+	ret := exec([]byte("dump"), []byte("k"))
+	assert.IsType(t, &resp.BulkData{}, ret)
+	payload := ret.(*resp.BulkData).Data()
+
+	assert.Equal(t, resp.MakeNullData(), exec([]byte("dump"), []byte("none")))
+
+	assert.Equal(t, resp.MakeErrorData("BUSYKEY Target key name already exists."),
+		exec([]byte("restore"), []byte("k"), []byte("0"), payload))
+	assert.Equal(t, resp.MakeErrorData("ERR Invalid TTL value, must be >= 0"),
+		exec([]byte("restore"), []byte("k1"), []byte("-1"), payload))
+	assert.Equal(t, resp.MakeErrorData("ERR syntax error"),
+		exec([]byte("restore"), []byte("k1"), []byte("0"), payload, []byte("none")))
+	assert.Equal(t, resp.MakeErrorData(db.ErrDumpPayload.Error()),
+		exec([]byte("restore"), []byte("k1"), []byte("0"), []byte("invalid payload")))
+
+	assert.Equal(t, resp.MakeStringData("OK"), exec([]byte("restore"), []byte("k1"), []byte("0"), payload))
+	assert.Equal(t, resp.MakeBulkData([]byte("v")), exec([]byte("get"), []byte("k1")))
+	assert.Equal(t, resp.MakeIntData(-1), exec([]byte("ttl"), []byte("k1")))
+
+	assert.Equal(t, resp.MakeStringData("OK"), exec([]byte("restore"), []byte("k1"), []byte("10000"), payload, []byte("REPLACE")))
+	assert.Equal(t, resp.MakeIntData(10), exec([]byte("ttl"), []byte("k1")))
+
+	// 绝对过期时间已经到达时，旧键被删除并且不会创建新键
+	assert.Equal(t, resp.MakeStringData("OK"), exec([]byte("restore"), []byte("k1"), []byte("1"), payload, []byte("replace"), []byte("absttl")))
+	assert.Equal(t, resp.MakeIntData(0), exec([]byte("exists"), []byte("k1")))
+
+	// rdb 格式不支持的 stream 与 bloom filter 同样可以被序列化
+	split := func(input string) [][]byte {
+		args := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			args = append(args, []byte(arg))
+		}
+		return args
+	}
+	exec(split("xadd s 1-1 f v")...)
+	exec(split("xadd s 2-1 f v")...)
+	exec(split("xgroup create s g 0")...)
+	exec(split("xclaim s g c1 0 1-1 force justid")...)
+	exec(split("bf.add b a")...)
+
+	for _, key := range []string{"s", "b"} {
+		ret = exec([]byte("dump"), []byte(key))
+		assert.IsType(t, &resp.BulkData{}, ret)
+		payload = ret.(*resp.BulkData).Data()
+		assert.Equal(t, resp.MakeStringData("OK"), exec([]byte("restore"), []byte(key+"1"), []byte("0"), payload))
+	}
+
+	assert.Equal(t, resp.MakeIntData(2), exec(split("xlen s1")...))
+	value, _ := database.GetKey("s1")
+	group, ok := value.(*structure.Stream).Group("g")
+	assert.True(t, ok)
+	assert.Equal(t, 1, group.PendingSize())
+	assert.Equal(t, resp.MakeIntData(1), exec(split("bf.exists b1 a")...))
+}
@@ -63,6 +63,7 @@ func lPush(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	}
 
 	db.ReviseNotify(string(cmd[1]), oldCost, listVal.Cost())
+	db.NotifyKeyspaceEvent(notifyList, "lpush", string(cmd[1]))
 
 	return resp.MakeIntData(int64(n))
 }
@@ -97,6 +98,7 @@ func rPush(db *db.DataBase, cmd [][]byte) resp.RedisData { // 进行输入类型
 		listVal.PushBack(structure.Slice(ele))
 	}
 	db.ReviseNotify(string(cmd[1]), oldCost, listVal.Cost())
+	db.NotifyKeyspaceEvent(notifyList, "rpush", string(cmd[1]))
 
 	return resp.MakeIntData(int64(n))
 }
@@ -107,16 +109,6 @@ func lPop(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		return e
 	}
 
-	value, ok := db.GetKey(string(cmd[1]))
-	if !ok {
-		return resp.MakeStringData("nil")
-	}
-
-	e = checkType(value, LIST)
-	if e != nil {
-		return e
-	}
-
 	count := 1
 
 	if len(cmd) == 3 {
@@ -125,25 +117,24 @@ func lPop(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		if w != nil {
 			return resp.MakeErrorData("ERR value is not an integer or out of range")
 		}
+		if count < 0 {
+			return resp.MakeErrorData("ERR value is out of range, must be positive")
+		}
 	}
-	listVal := value.(*structure.List)
-	oldCost := listVal.Cost()
 
-	if count >= listVal.Size() {
-		count = listVal.Size()
-		// 全部取出元素，需要删除
-		db.DeleteKey(string(cmd[1]))
+	values, e := PopList(db, string(cmd[1]), true, count)
+	if e != nil {
+		return e
+	}
+	if values == nil {
+		return resp.MakeStringData("nil")
 	}
 
-	res := make([]resp.RedisData, count)
-
-	for i := 0; i < count; i++ {
-		v, _ := listVal.PopFront().(structure.Slice)
+	res := make([]resp.RedisData, len(values))
+	for i, v := range values {
 		res[i] = resp.MakeBulkData(v)
 	}
 
-	db.ReviseNotify(string(cmd[1]), oldCost, listVal.Cost())
-
 	return resp.MakeArrayData(res)
 }
 
@@ -153,16 +144,6 @@ func rPop(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		return e
 	}
 
-	value, ok := db.GetKey(string(cmd[1]))
-	if !ok {
-		return resp.MakeStringData("nil")
-	}
-
-	e = checkType(value, LIST)
-	if e != nil {
-		return e
-	}
-
 	count := 1
 
 	if len(cmd) == 3 {
@@ -171,26 +152,24 @@ func rPop(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		if w != nil {
 			return resp.MakeErrorData("ERR value is not an integer or out of range")
 		}
+		if count < 0 {
+			return resp.MakeErrorData("ERR value is out of range, must be positive")
+		}
 	}
 
-	listVal := value.(*structure.List)
-	oldCost := listVal.Cost()
-
-	if count >= listVal.Size() {
-		count = listVal.Size()
-		// 全部取出元素，需要删除
-		db.DeleteKey(string(cmd[1]))
+	values, e := PopList(db, string(cmd[1]), false, count)
+	if e != nil {
+		return e
+	}
+	if values == nil {
+		return resp.MakeStringData("nil")
 	}
 
-	res := make([]resp.RedisData, count)
-
-	for i := 0; i < count; i++ {
-		v, _ := listVal.PopBack().(structure.Slice)
+	res := make([]resp.RedisData, len(values))
+	for i, v := range values {
 		res[i] = resp.MakeBulkData(v)
 	}
 
-	db.ReviseNotify(string(cmd[1]), oldCost, listVal.Cost())
-
 	return resp.MakeArrayData(res)
 }
 
@@ -287,6 +266,7 @@ func lSet(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	}
 
 	db.ReviseNotify(string(cmd[1]), oldCost, listVal.Cost())
+	db.NotifyKeyspaceEvent(notifyList, "lset", string(cmd[1]))
 
 	return resp.MakeStringData("OK")
 }
@@ -333,6 +313,9 @@ func lRem(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	}
 	db.ReviseNotify(string(cmd[1]), oldCost, listVal.Cost())
+	if deleted > 0 {
+		db.NotifyKeyspaceEvent(notifyList, "lrem", string(cmd[1]))
+	}
 
 	return resp.MakeIntData(int64(deleted))
 }
@@ -410,81 +393,234 @@ func lTrim(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	listVal.Trim(start, end)
 
 	db.ReviseNotify(string(cmd[1]), oldCost, listVal.Cost())
+	db.NotifyKeyspaceEvent(notifyList, "ltrim", string(cmd[1]))
 
 	return resp.MakeStringData("OK")
 }
 
-func lMove(db *db.DataBase, cmd [][]byte) resp.RedisData {
-	e, ok := checkCommandAndLength(&cmd, "lmove", 5)
-	if !ok {
-		return e
+// ParseListDirection 解析 LEFT 或 RIGHT 参数
+func ParseListDirection(arg []byte) (left bool, ok bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
 	}
+	return false, false
+}
 
-	value1, ok := db.GetKey(string(cmd[1]))
+// listDirection 返回方向对应的命令前缀
+func listDirection(left bool) string {
+	if left {
+		return "l"
+	}
+	return "r"
+}
+
+// PopList 从列表 key 的左侧或右侧弹出至多 count 个元素，列表被取空后会删除键，键不存在时返回空结果
+func PopList(db *db.DataBase, key string, left bool, count int) ([][]byte, resp.RedisData) {
+
+	value, ok := db.GetKey(key)
 	if !ok {
-		return resp.MakeStringData("nil")
+		return nil, nil
 	}
 
-	e = checkType(value1, LIST)
-	if e != nil {
-		return e
+	if e := checkType(value, LIST); e != nil {
+		return nil, e
 	}
 
-	listVal1 := value1.(*structure.List)
+	listVal := value.(*structure.List)
+	oldCost := listVal.Cost()
 
-	value2, ok := db.GetKey(string(cmd[2]))
-	if !ok {
-		value2 = structure.NewList()
-		db.SetKey(string(cmd[2]), value2)
+	deleted := count >= listVal.Size()
+	if deleted {
+		count = listVal.Size()
+		// 全部取出元素，需要删除
+		db.DeleteKey(key)
 	}
 
-	e = checkType(value2, LIST)
-	if e != nil {
-		return e
+	values := make([][]byte, count)
+	for i := range values {
+		if left {
+			values[i], _ = listVal.PopFront().(structure.Slice)
+		} else {
+			values[i], _ = listVal.PopBack().(structure.Slice)
+		}
 	}
 
-	listVal2 := value2.(*structure.List)
-
-	// 这里目的为每一个 cmd 只比较一次
-	if strings.ToUpper(string(cmd[3])) == "LEFT" {
+	db.ReviseNotify(key, oldCost, listVal.Cost())
+	if count > 0 {
+		db.NotifyKeyspaceEvent(notifyList, listDirection(left)+"pop", key)
+	}
+	if deleted {
+		db.NotifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
 
-		if strings.ToUpper(string(cmd[4])) == "LEFT" {
+	return values, nil
+}
 
-			listVal2.PushFront(listVal1.PopFront())
+// MoveList 从列表 src 的一侧弹出一个元素并插入到列表 dst 的一侧，src 不存在时返回 nil
+func MoveList(db *db.DataBase, src, dst string, fromLeft, toLeft bool) ([]byte, resp.RedisData) {
 
-		} else if strings.ToUpper(string(cmd[4])) == "RIGHT" {
+	value, ok := db.GetKey(src)
+	if !ok {
+		return nil, nil
+	}
 
-			listVal2.PushBack(listVal1.PopFront())
+	if e := checkType(value, LIST); e != nil {
+		return nil, e
+	}
 
-		} else {
+	srcList := value.(*structure.List)
+	if srcList.Empty() {
+		return nil, nil
+	}
 
-			return resp.MakeErrorData("ERR syntax error")
+	// 写入前检查目标的类型，防止弹出的元素丢失
+	var dstList *structure.List
+	if value, ok = db.GetKey(dst); ok {
+		if e := checkType(value, LIST); e != nil {
+			return nil, e
 		}
+		dstList = value.(*structure.List)
+	}
 
-	} else if strings.ToUpper(string(cmd[3])) == "RIGHT" {
+	var element structure.Object
+	if fromLeft {
+		element = srcList.PopFront()
+	} else {
+		element = srcList.PopBack()
+	}
 
-		if strings.ToUpper(string(cmd[4])) == "LEFT" {
+	if dstList == nil {
+		dstList = structure.NewList()
+		db.SetKey(dst, dstList)
+	}
 
-			listVal2.PushFront(listVal1.PopBack())
+	if toLeft {
+		dstList.PushFront(element)
+	} else {
+		dstList.PushBack(element)
+	}
 
-		} else if strings.ToUpper(string(cmd[4])) == "RIGHT" {
+	deleted := srcList.Empty()
+	if deleted {
+		db.DeleteKey(src)
+	}
 
-			listVal2.PushBack(listVal1.PopBack())
+	db.ReviseNotify(src, 0, 0)
+	db.ReviseNotify(dst, 0, 0)
+	db.NotifyKeyspaceEvent(notifyList, listDirection(fromLeft)+"pop", src)
+	db.NotifyKeyspaceEvent(notifyList, listDirection(toLeft)+"push", dst)
+	if deleted {
+		db.NotifyKeyspaceEvent(notifyGeneric, "del", src)
+	}
 
-		} else {
+	v, _ := element.(structure.Slice)
+	return v, nil
+}
 
-			return resp.MakeErrorData("ERR syntax error")
-		}
+func lMove(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	e, ok := checkCommandAndLength(&cmd, "lmove", 5)
+	if !ok {
+		return e
+	}
 
-	} else {
+	fromLeft, ok1 := ParseListDirection(cmd[3])
+	toLeft, ok2 := ParseListDirection(cmd[4])
+	if !ok1 || !ok2 {
 		return resp.MakeErrorData("ERR syntax error")
 	}
 
-	db.ReviseNotify(string(cmd[1]), 0, 0)
-	db.ReviseNotify(string(cmd[2]), 0, 0)
+	v, e := MoveList(db, string(cmd[1]), string(cmd[2]), fromLeft, toLeft)
+	if e != nil {
+		return e
+	}
+	if v == nil {
+		return resp.MakeStringData("nil")
+	}
 
 	return resp.MakeStringData("OK")
+}
+
+// MPopArgs 是 LMPOP、ZMPOP 以及对应阻塞命令的参数
+type MPopArgs struct {
+	Keys  []string
+	First bool // 为 true 时代表 LEFT 或 MIN
+	Count int
+}
+
+// ParseMPopArgs 解析 numkeys key [key ...] <first>|<second> [COUNT count] 形式的参数，args 从 numkeys 开始
+func ParseMPopArgs(args [][]byte, first, second string) (*MPopArgs, resp.RedisData) {
+
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 {
+		return nil, resp.MakeErrorData("ERR numkeys should be greater than 0")
+	}
+	if numKeys+2 > len(args) {
+		return nil, resp.MakeErrorData("ERR syntax error")
+	}
+
+	res := &MPopArgs{Count: 1}
+	for _, key := range args[1 : numKeys+1] {
+		res.Keys = append(res.Keys, string(key))
+	}
+
+	switch strings.ToUpper(string(args[numKeys+1])) {
+	case first:
+		res.First = true
+	case second:
+		res.First = false
+	default:
+		return nil, resp.MakeErrorData("ERR syntax error")
+	}
+
+	options := args[numKeys+2:]
+	if len(options) == 0 {
+		return res, nil
+	}
+	if len(options) != 2 || strings.ToUpper(string(options[0])) != "COUNT" {
+		return nil, resp.MakeErrorData("ERR syntax error")
+	}
+	res.Count, err = strconv.Atoi(string(options[1]))
+	if err != nil || res.Count <= 0 {
+		return nil, resp.MakeErrorData("ERR count should be greater than 0")
+	}
+
+	return res, nil
+}
+
+// MakeListMPopResult 生成 LMPOP 的回包
+func MakeListMPopResult(key string, values [][]byte) resp.RedisData {
+	res := make([]resp.RedisData, len(values))
+	for i, v := range values {
+		res[i] = resp.MakeBulkData(v)
+	}
+	return resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte(key)), resp.MakeArrayData(res)})
+}
+
+func lMPop(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	e, ok := checkCommandAndLength(&cmd, "lmpop", 4)
+	if !ok {
+		return e
+	}
+
+	args, e := ParseMPopArgs(cmd[1:], "LEFT", "RIGHT")
+	if e != nil {
+		return e
+	}
+
+	for _, key := range args.Keys {
+		values, e := PopList(db, key, args.First, args.Count)
+		if e != nil {
+			return e
+		}
+		if len(values) > 0 {
+			return MakeListMPopResult(key, values)
+		}
+	}
 
+	return resp.MakeNullData()
 }
 
 func registerListCommands() {
@@ -500,4 +636,5 @@ func registerListCommands() {
 	registerCommand("lrange", lRange, RD)
 	registerCommand("ltrim", lTrim, WR)
 	registerCommand("lmove", lMove, WR)
+	registerCommand("lmpop", lMPop, WR)
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/tangrc99/MemTable/db"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
+	"strings"
 	"testing"
 )
 
@@ -149,3 +150,51 @@ func TestCmdList(t *testing.T) {
 		}
 	}
 }
+
+func TestCmdListMPop(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	bulk := func(s string) resp.RedisData {
+		return resp.MakeBulkData([]byte(s))
+	}
+	array := func(values ...resp.RedisData) resp.RedisData {
+		return resp.MakeArrayData(values)
+	}
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+	}{
+		{"lmpop 1 l LEFT", resp.MakeNullData()},
+		{"rpush l 1 2 3", resp.MakeIntData(3)},
+		{"rpush l2 4", resp.MakeIntData(1)},
+		{"lmpop 2 none l LEFT", array(bulk("l"), array(bulk("1")))},
+		{"lmpop 2 l l2 RIGHT COUNT 5", array(bulk("l"), array(bulk("3"), bulk("2")))},
+		{"lmpop 2 l l2 RIGHT COUNT 5", array(bulk("l2"), array(bulk("4")))},
+		{"lmpop 1 l UP", resp.MakeErrorData("ERR syntax error")},
+		{"lmpop 1 l LEFT COUNT", resp.MakeErrorData("ERR syntax error")},
+		{"lmpop -1 l LEFT", resp.MakeErrorData("ERR numkeys should be greater than 0")},
+		{"lmove none l LEFT LEFT", resp.MakeStringData("nil")},
+		{"rpush l 1", resp.MakeIntData(1)},
+		{"set s v", resp.MakeStringData("OK")},
+		{"lmove l s LEFT LEFT", resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"llen l", resp.MakeIntData(1)},
+		{"lpop l -1", resp.MakeErrorData("ERR value is out of range, must be positive")},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		cmd, exist := global.FindCommand(string(input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(command)
+
+		ret := c(database, input)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+
+	assert.False(t, database.ExistKey("l2"))
+}
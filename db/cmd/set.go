@@ -28,6 +28,7 @@ func sadd(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		}
 
 		db.SetKey(string(cmd[1]), set)
+		db.NotifyKeyspaceEvent(notifySet, "sadd", string(cmd[1]))
 		return resp.MakeIntData(int64(added))
 	}
 
@@ -49,6 +50,9 @@ func sadd(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	db.RemoveTTL(string(cmd[1]))
 
 	db.ReviseNotify(string(cmd[1]), oldCost, value.Cost())
+	if added > 0 {
+		db.NotifyKeyspaceEvent(notifySet, "sadd", string(cmd[1]))
+	}
 
 	return resp.MakeIntData(int64(added))
 }
@@ -82,6 +86,9 @@ func sRem(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		}
 	}
 	db.ReviseNotify(string(cmd[1]), oldCost, value.Cost())
+	if deleted > 0 {
+		db.NotifyKeyspaceEvent(notifySet, "srem", string(cmd[1]))
+	}
 
 	return resp.MakeIntData(int64(deleted))
 }
@@ -190,6 +197,9 @@ func sPop(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	}
 
 	ks := setVal.RandomPop(num)
+	if len(ks) > 0 {
+		db.NotifyKeyspaceEvent(notifySet, "spop", string(cmd[1]))
+	}
 
 	res := make([]resp.RedisData, len(ks))
 
@@ -270,6 +280,8 @@ func sMove(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	if setVal1.Delete(string(cmd[3])) {
 		setVal2.Add(string(cmd[3]))
+		db.NotifyKeyspaceEvent(notifySet, "srem", string(cmd[1]))
+		db.NotifyKeyspaceEvent(notifySet, "sadd", string(cmd[2]))
 		return resp.MakeIntData(1)
 	}
 
@@ -384,6 +396,7 @@ func sDiffStore(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	db.SetKey(string(cmd[1]), dstSet)
 	db.RemoveTTL(string(cmd[1]))
 	db.ReviseNotify(string(cmd[1]), 0, 0)
+	db.NotifyKeyspaceEvent(notifySet, "sdiffstore", string(cmd[1]))
 
 	return resp.MakeIntData(int64(dstSet.Size()))
 }
@@ -460,6 +473,7 @@ func sInterStore(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		}
 		db.SetKey(string(cmd[1]), dstSet)
 		db.RemoveTTL(string(cmd[1]))
+		db.NotifyKeyspaceEvent(notifySet, "sinterstore", string(cmd[1]))
 		return resp.MakeIntData(int64(n))
 	}
 
@@ -497,6 +511,7 @@ func sInterStore(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	db.SetKey(string(cmd[1]), dstSet)
 	db.RemoveTTL(string(cmd[1]))
 	db.ReviseNotify(string(cmd[1]), 0, 0)
+	db.NotifyKeyspaceEvent(notifySet, "sinterstore", string(cmd[1]))
 
 	return resp.MakeIntData(int64(dstSet.Size()))
 }
@@ -591,16 +606,47 @@ func sUnionStore(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	db.SetKey(string(cmd[1]), dstSet)
 	db.RemoveTTL(string(cmd[1]))
 	db.ReviseNotify(string(cmd[1]), 0, 0)
+	db.NotifyKeyspaceEvent(notifySet, "sunionstore", string(cmd[1]))
 
 	return resp.MakeIntData(int64(dstSet.Size()))
 }
 
-/*
+func sScan(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "sscan", 3)
+	if !ok {
+		return e
+	}
 
+	cursor, e := parseScanCursor(cmd[2])
+	if e != nil {
+		return e
+	}
 
-func sScan(db *db.DataBase, cmd [][]byte) resp.RedisData {}
+	opts, e := parseScanOptions(cmd[3:], false)
+	if e != nil {
+		return e
+	}
 
-*/
+	value, ok := db.GetKey(string(cmd[1]))
+	if !ok {
+		return makeScanResult(0, []resp.RedisData{})
+	}
+
+	if err := checkType(value, SET); err != nil {
+		return err
+	}
+
+	members := make([]resp.RedisData, 0, opts.count)
+
+	next := value.(*structure.Set).Scan(cursor, opts.count, func(key string) {
+		if opts.matched(key) {
+			members = append(members, resp.MakeBulkData([]byte(key)))
+		}
+	})
+
+	return makeScanResult(next, members)
+}
 
 func registerSetCommands() {
 	registerCommand("sadd", sadd, WR)
@@ -611,6 +657,7 @@ func registerSetCommands() {
 	registerCommand("spop", sPop, RD)
 	registerCommand("srandmember", sRandMember, RD)
 	registerCommand("smove", sMove, WR)
+	registerCommand("sscan", sScan, RD)
 
 	registerCommand("sdiff", sDiff, RD)
 	registerCommand("sdiffstore", sDiffStore, WR)
@@ -6,6 +6,7 @@ import (
 	"github.com/tangrc99/MemTable/db/structure"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
 	"testing"
 )
 
@@ -191,3 +192,19 @@ func TestCmdMultiSetStore(t *testing.T) {
 		database.DeleteKey("set3")
 	}
 }
+
+func TestCmdSetScan(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	set := structure.NewSet()
+	for i := 0; i < 50; i++ {
+		set.Add(strconv.Itoa(i))
+	}
+	database.SetKey("set", set)
+
+	members := scanAll(t, database, [][]byte{[]byte("sscan"), []byte("set"), nil, []byte("COUNT"), []byte("3")}, 2)
+	assert.Equal(t, 50, len(members))
+
+	members = scanAll(t, database, [][]byte{[]byte("sscan"), []byte("set"), nil, []byte("MATCH"), []byte("4?")}, 2)
+	assert.Equal(t, 10, len(members))
+}
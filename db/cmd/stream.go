@@ -0,0 +1,906 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
+	"strings"
+)
+
+type StreamID = structure.StreamID
+
+// streamTrimOptions 是 XADD 和 XTRIM 命令中的裁剪参数
+type streamTrimOptions struct {
+	byLen  bool     // 按照 MAXLEN 还是 MINID 裁剪
+	maxLen int      // MAXLEN 参数
+	minID  StreamID // MINID 参数
+	limit  int      // 最多裁剪的消息数量，0 代表不限制
+}
+
+// parseStreamTrim 从 args[i] 开始解析 MAXLEN|MINID [=|~] threshold [LIMIT count]，返回下一个未解析参数的位置
+func parseStreamTrim(args [][]byte, i int) (*streamTrimOptions, int, resp.RedisData) {
+
+	opts := &streamTrimOptions{byLen: strings.ToLower(string(args[i])) == "maxlen"}
+	i++
+
+	approx := false
+	if i < len(args) && (string(args[i]) == "=" || string(args[i]) == "~") {
+		approx = string(args[i]) == "~"
+		i++
+	}
+	if i >= len(args) {
+		return nil, 0, resp.MakeErrorData("ERR syntax error")
+	}
+
+	if opts.byLen {
+		maxLen, err := strconv.Atoi(string(args[i]))
+		if err != nil {
+			return nil, 0, resp.MakeErrorData("ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return nil, 0, resp.MakeErrorData("ERR The MAXLEN argument must be >= 0.")
+		}
+		opts.maxLen = maxLen
+	} else {
+		minID, err := structure.ParseStreamID(string(args[i]), 0)
+		if err != nil {
+			return nil, 0, resp.MakeErrorData(err.Error())
+		}
+		opts.minID = minID
+	}
+	i++
+
+	if i+1 < len(args) && strings.ToLower(string(args[i])) == "limit" {
+		// 精确裁剪时不允许限制数量
+		if !approx {
+			return nil, 0, resp.MakeErrorData("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		limit, err := strconv.Atoi(string(args[i+1]))
+		if err != nil || limit < 0 {
+			return nil, 0, resp.MakeErrorData("ERR The LIMIT argument must be >= 0.")
+		}
+		opts.limit = limit
+		i += 2
+	}
+
+	return opts, i, nil
+}
+
+// trim 按照裁剪参数裁剪 stream，近似裁剪同样会精确地裁剪到阈值
+func (opts *streamTrimOptions) trim(stream *structure.Stream) int {
+	if opts.byLen {
+		return stream.TrimByLen(opts.maxLen, opts.limit)
+	}
+	return stream.TrimByMinID(opts.minID, opts.limit)
+}
+
+// parseStreamRangeID 解析 XRANGE 系列命令中的区间边界，支持 -、+ 以及 ( 开头的开区间，
+// 只有时间戳部分的 ID 在作为起点时序列号为 0，作为终点时序列号为最大值
+func parseStreamRangeID(arg []byte, isStart bool) (StreamID, resp.RedisData) {
+
+	s := string(arg)
+
+	switch s {
+	case "-":
+		return structure.MinStreamID, nil
+	case "+":
+		return structure.MaxStreamID, nil
+	}
+
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+
+	defaultSeq := uint64(0)
+	if !isStart {
+		defaultSeq = structure.MaxStreamID.Seq
+	}
+
+	id, err := structure.ParseStreamID(s, defaultSeq)
+	if err != nil {
+		return id, resp.MakeErrorData(err.Error())
+	}
+
+	if exclusive {
+		var ok bool
+		if isStart {
+			id, ok = id.Incr()
+		} else {
+			id, ok = id.Decr()
+		}
+		if !ok {
+			return id, resp.MakeErrorData("ERR invalid start or end ID for the interval")
+		}
+	}
+
+	return id, nil
+}
+
+// parseStreamCount 解析 COUNT 参数
+func parseStreamCount(arg []byte) (int, resp.RedisData) {
+	count, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, resp.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	if count < 0 {
+		count = 0
+	}
+	return count, nil
+}
+
+// MakeStreamEntries 将消息转化为 [id, [field, value ...]] 格式的数组，已经删除的消息字段为 nil
+func MakeStreamEntries(entries []*structure.StreamEntry) resp.RedisData {
+	res := make([]resp.RedisData, len(entries))
+	for i, entry := range entries {
+		var fields resp.RedisData = resp.MakeArrayData(nil)
+		if entry.Fields != nil {
+			values := make([]resp.RedisData, len(entry.Fields))
+			for j := range entry.Fields {
+				values[j] = resp.MakeBulkData(entry.Fields[j])
+			}
+			fields = resp.MakeArrayData(values)
+		}
+		res[i] = resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte(entry.ID.String())), fields})
+	}
+	return resp.MakeArrayData(res)
+}
+
+// getStream 获取 key 对应的 stream，如果 key 不存在将返回 nil
+func getStream(db *db.DataBase, key []byte) (*structure.Stream, resp.RedisData) {
+
+	value, ok := db.GetKey(string(key))
+	if !ok {
+		return nil, nil
+	}
+
+	if err := checkType(value, STREAM); err != nil {
+		return nil, err
+	}
+
+	return value.(*structure.Stream), nil
+}
+
+// getStreamGroup 获取 key 对应 stream 中的消费组，如果 key 或消费组不存在将返回 NOGROUP 错误
+func getStreamGroup(db *db.DataBase, key, name []byte) (*structure.Stream, *structure.StreamGroup, resp.RedisData) {
+
+	stream, err := getStream(db, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if stream != nil {
+		if group, ok := stream.Group(string(name)); ok {
+			return stream, group, nil
+		}
+	}
+
+	return nil, nil, resp.MakeErrorData(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, name))
+}
+
+func xAdd(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xadd", 5)
+	if !ok {
+		return e
+	}
+
+	noMkStream := false
+	var trimOpts *streamTrimOptions
+
+	i := 2
+	for ; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "nomkstream":
+			noMkStream = true
+			continue
+		case "maxlen", "minid":
+			opts, next, err := parseStreamTrim(cmd, i)
+			if err != nil {
+				return err
+			}
+			trimOpts, i = opts, next-1
+			continue
+		}
+		break
+	}
+
+	// 剩余参数为 id field value [field value ...]
+	if i >= len(cmd) || (len(cmd)-i-1) == 0 || (len(cmd)-i-1)%2 != 0 {
+		return resp.MakeErrorData("ERR wrong number of arguments for 'xadd' command")
+	}
+
+	stream, err := getStream(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	if stream == nil && noMkStream {
+		return resp.MakeNullData()
+	}
+
+	created := stream == nil
+	if created {
+		stream = structure.NewStream()
+	}
+
+	id, err := parseXAddID(stream, string(cmd[i]))
+	if err != nil {
+		return err
+	}
+
+	fields := make([][]byte, len(cmd)-i-1)
+	copy(fields, cmd[i+1:])
+
+	oldCost := stream.Cost()
+	stream.Add(id, fields)
+	if trimOpts != nil {
+		trimOpts.trim(stream)
+	}
+
+	if created {
+		db.SetKey(string(cmd[1]), stream)
+	} else {
+		db.ReviseNotify(string(cmd[1]), oldCost, stream.Cost())
+	}
+
+	db.NotifyKeyspaceEvent(notifyStream, "xadd", string(cmd[1]))
+
+	return resp.MakeBulkData([]byte(id.String()))
+}
+
+// parseXAddID 解析 XADD 中的 ID 参数，支持 *、ms-* 和完整 ID 三种格式，ID 必须大于 stream 中最后添加的 ID
+func parseXAddID(stream *structure.Stream, arg string) (StreamID, resp.RedisData) {
+
+	var id StreamID
+	var ok bool
+
+	if arg == "*" {
+		id, ok = stream.NextID(uint64(global.Now.UnixMilli()))
+
+	} else if strings.HasSuffix(arg, "-*") {
+
+		ms, err := strconv.ParseUint(strings.TrimSuffix(arg, "-*"), 10, 64)
+		if err != nil {
+			return id, resp.MakeErrorData(structure.ErrInvalidStreamID.Error())
+		}
+		last := stream.LastID()
+		switch {
+		case ms > last.Ms:
+			id, ok = StreamID{Ms: ms}, true
+		case ms == last.Ms:
+			id, ok = last.Incr()
+			ok = ok && id.Ms == ms
+		}
+
+	} else {
+
+		parsed, err := structure.ParseStreamID(arg, 0)
+		if err != nil {
+			return id, resp.MakeErrorData(err.Error())
+		}
+		id, ok = parsed, stream.LastID().Less(parsed)
+	}
+
+	if id == structure.MinStreamID {
+		return id, resp.MakeErrorData("ERR The ID specified in XADD must be greater than 0-0")
+	}
+
+	if !ok {
+		return id, resp.MakeErrorData("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+
+	return id, nil
+}
+
+func xRangeGeneric(db *db.DataBase, cmd [][]byte, reverse bool) resp.RedisData {
+
+	startArg, endArg := cmd[2], cmd[3]
+	if reverse {
+		startArg, endArg = cmd[3], cmd[2]
+	}
+
+	start, err := parseStreamRangeID(startArg, true)
+	if err != nil {
+		return err
+	}
+	end, err := parseStreamRangeID(endArg, false)
+	if err != nil {
+		return err
+	}
+
+	count := -1
+	if len(cmd) > 4 {
+		if len(cmd) != 6 || strings.ToLower(string(cmd[4])) != "count" {
+			return resp.MakeErrorData("ERR syntax error")
+		}
+		if count, err = parseStreamCount(cmd[5]); err != nil {
+			return err
+		}
+	}
+
+	stream, err := getStream(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	if stream == nil || count == 0 {
+		return resp.MakeEmptyArrayData()
+	}
+
+	if reverse {
+		return MakeStreamEntries(stream.RevRange(end, start, count))
+	}
+	return MakeStreamEntries(stream.Range(start, end, count))
+}
+
+func xRange(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xrange", 4)
+	if !ok {
+		return e
+	}
+
+	return xRangeGeneric(db, cmd, false)
+}
+
+func xRevRange(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xrevrange", 4)
+	if !ok {
+		return e
+	}
+
+	return xRangeGeneric(db, cmd, true)
+}
+
+func xLen(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xlen", 2)
+	if !ok {
+		return e
+	}
+
+	stream, err := getStream(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	if stream == nil {
+		return resp.MakeIntData(0)
+	}
+
+	return resp.MakeIntData(int64(stream.Len()))
+}
+
+func xTrim(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xtrim", 4)
+	if !ok {
+		return e
+	}
+
+	if s := strings.ToLower(string(cmd[2])); s != "maxlen" && s != "minid" {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	opts, next, err := parseStreamTrim(cmd, 2)
+	if err != nil {
+		return err
+	}
+	if next != len(cmd) {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	stream, err := getStream(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	if stream == nil {
+		return resp.MakeIntData(0)
+	}
+
+	oldCost := stream.Cost()
+	trimmed := opts.trim(stream)
+	db.ReviseNotify(string(cmd[1]), oldCost, stream.Cost())
+	if trimmed > 0 {
+		db.NotifyKeyspaceEvent(notifyStream, "xtrim", string(cmd[1]))
+	}
+
+	return resp.MakeIntData(int64(trimmed))
+}
+
+func xDel(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xdel", 3)
+	if !ok {
+		return e
+	}
+
+	ids := make([]StreamID, len(cmd)-2)
+	for i, arg := range cmd[2:] {
+		id, err := structure.ParseStreamID(string(arg), 0)
+		if err != nil {
+			return resp.MakeErrorData(err.Error())
+		}
+		ids[i] = id
+	}
+
+	stream, err := getStream(db, cmd[1])
+	if err != nil {
+		return err
+	}
+
+	if stream == nil {
+		return resp.MakeIntData(0)
+	}
+
+	oldCost := stream.Cost()
+	deleted := 0
+	for _, id := range ids {
+		if stream.Delete(id) {
+			deleted++
+		}
+	}
+	db.ReviseNotify(string(cmd[1]), oldCost, stream.Cost())
+	if deleted > 0 {
+		db.NotifyKeyspaceEvent(notifyStream, "xdel", string(cmd[1]))
+	}
+
+	return resp.MakeIntData(int64(deleted))
+}
+
+func xSetID(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xsetid", 3)
+	if !ok {
+		return e
+	}
+
+	if len(cmd) != 3 {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	id, err := structure.ParseStreamID(string(cmd[2]), 0)
+	if err != nil {
+		return resp.MakeErrorData(err.Error())
+	}
+
+	stream, e := getStream(db, cmd[1])
+	if e != nil {
+		return e
+	}
+
+	if stream == nil {
+		return resp.MakeErrorData("ERR no such key")
+	}
+
+	if !stream.SetLastID(id) {
+		return resp.MakeErrorData("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	db.NotifyKeyspaceEvent(notifyStream, "xsetid", string(cmd[1]))
+
+	return resp.MakeStringData("OK")
+}
+
+func xGroup(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xgroup", 2)
+	if !ok {
+		return e
+	}
+
+	subcommand := strings.ToLower(string(cmd[1]))
+
+	// 每个子命令的参数数量
+	arity := map[string]int{
+		"create":         5,
+		"setid":          5,
+		"destroy":        4,
+		"createconsumer": 5,
+		"delconsumer":    5,
+	}
+
+	n, exist := arity[subcommand]
+	if !exist {
+		return resp.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s'. Try XGROUP HELP.", cmd[1]))
+	}
+	if len(cmd) < n || (len(cmd) > n && subcommand != "create") {
+		return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for 'xgroup|%s' command", subcommand))
+	}
+
+	key := string(cmd[2])
+
+	if subcommand == "create" {
+		return xGroupCreate(db, cmd)
+	}
+
+	stream, group, err := getStreamGroup(db, cmd[2], cmd[3])
+
+	switch subcommand {
+
+	case "setid":
+		if err != nil {
+			return err
+		}
+		id, e := parseGroupID(stream, cmd[4])
+		if e != nil {
+			return e
+		}
+		group.LastID = id
+		db.NotifyKeyspaceEvent(notifyStream, "xgroup-setid", key)
+		return resp.MakeStringData("OK")
+
+	case "destroy":
+		if stream == nil {
+			if _, e := getStream(db, cmd[2]); e != nil {
+				return e
+			}
+			return resp.MakeIntData(0)
+		}
+		oldCost := stream.Cost()
+		stream.DestroyGroup(string(cmd[3]))
+		db.ReviseNotify(key, oldCost, stream.Cost())
+		db.NotifyKeyspaceEvent(notifyStream, "xgroup-destroy", key)
+		return resp.MakeIntData(1)
+
+	case "createconsumer":
+		if err != nil {
+			return err
+		}
+		oldCost := stream.Cost()
+		created := group.CreateConsumer(string(cmd[4]), global.Now.UnixMilli())
+		db.ReviseNotify(key, oldCost, stream.Cost())
+		if created {
+			db.NotifyKeyspaceEvent(notifyStream, "xgroup-createconsumer", key)
+			return resp.MakeIntData(1)
+		}
+		return resp.MakeIntData(0)
+
+	case "delconsumer":
+		if err != nil {
+			return err
+		}
+		oldCost := stream.Cost()
+		deleted := group.DeleteConsumer(string(cmd[4]))
+		db.ReviseNotify(key, oldCost, stream.Cost())
+		db.NotifyKeyspaceEvent(notifyStream, "xgroup-delconsumer", key)
+		if deleted < 0 {
+			deleted = 0
+		}
+		return resp.MakeIntData(int64(deleted))
+	}
+
+	return resp.MakeErrorData("ERR syntax error")
+}
+
+// xGroupCreate 处理 XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]
+func xGroupCreate(db *db.DataBase, cmd [][]byte) resp.RedisData {
+
+	mkStream := false
+	for i := 5; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "mkstream":
+			mkStream = true
+		case "entriesread":
+			// 不记录已读数量，只检查参数格式
+			if i+1 >= len(cmd) {
+				return resp.MakeErrorData("ERR syntax error")
+			}
+			if _, err := strconv.ParseInt(string(cmd[i+1]), 10, 64); err != nil {
+				return resp.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			i++
+		default:
+			return resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	stream, err := getStream(db, cmd[2])
+	if err != nil {
+		return err
+	}
+
+	created := false
+	if stream == nil {
+		if !mkStream {
+			return resp.MakeErrorData("ERR The XGROUP subcommand requires the key to exist. " +
+				"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+		}
+		stream, created = structure.NewStream(), true
+	}
+
+	id, err := parseGroupID(stream, cmd[4])
+	if err != nil {
+		return err
+	}
+
+	oldCost := stream.Cost()
+	if !stream.CreateGroup(string(cmd[3]), id) {
+		return resp.MakeErrorData("BUSYGROUP Consumer Group name already exists")
+	}
+
+	if created {
+		db.SetKey(string(cmd[2]), stream)
+	} else {
+		db.ReviseNotify(string(cmd[2]), oldCost, stream.Cost())
+	}
+	db.NotifyKeyspaceEvent(notifyStream, "xgroup-create", string(cmd[2]))
+
+	return resp.MakeStringData("OK")
+}
+
+// parseGroupID 解析消费组的 ID 参数，$ 代表 stream 中最后添加的 ID
+func parseGroupID(stream *structure.Stream, arg []byte) (StreamID, resp.RedisData) {
+	if string(arg) == "$" {
+		return stream.LastID(), nil
+	}
+	id, err := structure.ParseStreamID(string(arg), 0)
+	if err != nil {
+		return id, resp.MakeErrorData(err.Error())
+	}
+	return id, nil
+}
+
+func xAck(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xack", 4)
+	if !ok {
+		return e
+	}
+
+	ids := make([]StreamID, len(cmd)-3)
+	for i, arg := range cmd[3:] {
+		id, err := structure.ParseStreamID(string(arg), 0)
+		if err != nil {
+			return resp.MakeErrorData(err.Error())
+		}
+		ids[i] = id
+	}
+
+	stream, err := getStream(db, cmd[1])
+	if err != nil {
+		return err
+	}
+	if stream == nil {
+		return resp.MakeIntData(0)
+	}
+	group, ok := stream.Group(string(cmd[2]))
+	if !ok {
+		return resp.MakeIntData(0)
+	}
+
+	oldCost := stream.Cost()
+	acked := 0
+	for _, id := range ids {
+		if group.Ack(id) {
+			acked++
+		}
+	}
+	db.ReviseNotify(string(cmd[1]), oldCost, stream.Cost())
+
+	return resp.MakeIntData(int64(acked))
+}
+
+func xPending(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xpending", 3)
+	if !ok {
+		return e
+	}
+
+	// 解析扩展格式 [[IDLE min-idle-time] start end count [consumer]]
+	extended := len(cmd) > 3
+	var minIdle int64
+	var start, end StreamID
+	var count int
+	var consumer string
+
+	if extended {
+		args := cmd[3:]
+		if strings.ToLower(string(args[0])) == "idle" {
+			if len(args) < 2 {
+				return resp.MakeErrorData("ERR syntax error")
+			}
+			idle, err := strconv.ParseInt(string(args[1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			minIdle = idle
+			args = args[2:]
+		}
+		if len(args) < 3 || len(args) > 4 {
+			return resp.MakeErrorData("ERR syntax error")
+		}
+
+		var err resp.RedisData
+		if start, err = parseStreamRangeID(args[0], true); err != nil {
+			return err
+		}
+		if end, err = parseStreamRangeID(args[1], false); err != nil {
+			return err
+		}
+		if count, err = parseStreamCount(args[2]); err != nil {
+			return err
+		}
+		if len(args) == 4 {
+			consumer = string(args[3])
+		}
+	}
+
+	_, group, err := getStreamGroup(db, cmd[1], cmd[2])
+	if err != nil {
+		return err
+	}
+
+	now := global.Now.UnixMilli()
+
+	if extended {
+		if count == 0 {
+			return resp.MakeEmptyArrayData()
+		}
+		pending := group.PendingRange(start, end, count, consumer, minIdle, now)
+		res := make([]resp.RedisData, len(pending))
+		for i, pe := range pending {
+			res[i] = resp.MakeArrayData([]resp.RedisData{
+				resp.MakeBulkData([]byte(pe.ID.String())),
+				resp.MakeBulkData([]byte(pe.Consumer.Name)),
+				resp.MakeIntData(now - pe.DeliveryTime),
+				resp.MakeIntData(pe.DeliveryCount),
+			})
+		}
+		return resp.MakeArrayData(res)
+	}
+
+	// 概要格式
+	pending := group.PendingRange(structure.MinStreamID, structure.MaxStreamID, 0, "", 0, now)
+	if len(pending) == 0 {
+		return resp.MakeArrayData([]resp.RedisData{
+			resp.MakeIntData(0), resp.MakeNullData(), resp.MakeNullData(), resp.MakeArrayData(nil),
+		})
+	}
+
+	consumers := make([]resp.RedisData, 0)
+	for _, c := range group.Consumers() {
+		if c.PendingSize() == 0 {
+			continue
+		}
+		consumers = append(consumers, resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte(c.Name)),
+			resp.MakeBulkData([]byte(strconv.Itoa(c.PendingSize()))),
+		}))
+	}
+
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeIntData(int64(len(pending))),
+		resp.MakeBulkData([]byte(pending[0].ID.String())),
+		resp.MakeBulkData([]byte(pending[len(pending)-1].ID.String())),
+		resp.MakeArrayData(consumers),
+	})
+}
+
+func xClaim(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "xclaim", 6)
+	if !ok {
+		return e
+	}
+
+	minIdle, err := strconv.ParseInt(string(cmd[4]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorData("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+
+	now := global.Now.UnixMilli()
+
+	// ID 列表之后为可选参数
+	ids := make([]StreamID, 0)
+	i := 5
+	for ; i < len(cmd); i++ {
+		id, err := structure.ParseStreamID(string(cmd[i]), 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	deliveryTime := now
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID *StreamID
+
+	for ; i < len(cmd); i++ {
+		option := strings.ToLower(string(cmd[i]))
+		switch option {
+		case "force":
+			force = true
+			continue
+		case "justid":
+			justID = true
+			continue
+		}
+
+		if i+1 >= len(cmd) {
+			return resp.MakeErrorData(fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", cmd[i]))
+		}
+
+		switch option {
+		case "idle", "time", "retrycount":
+			v, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrorData(fmt.Sprintf("ERR Invalid %s option argument for XCLAIM", strings.ToUpper(option)))
+			}
+			switch option {
+			case "idle":
+				deliveryTime = now - v
+			case "time":
+				deliveryTime = v
+			case "retrycount":
+				retryCount = v
+			}
+		case "lastid":
+			id, err := structure.ParseStreamID(string(cmd[i+1]), 0)
+			if err != nil {
+				return resp.MakeErrorData(err.Error())
+			}
+			lastID = &id
+		default:
+			return resp.MakeErrorData(fmt.Sprintf("ERR Unrecognized XCLAIM option '%s'", cmd[i]))
+		}
+		i++
+	}
+
+	stream, group, e := getStreamGroup(db, cmd[1], cmd[2])
+	if e != nil {
+		return e
+	}
+
+	if lastID != nil && group.LastID.Less(*lastID) {
+		group.LastID = *lastID
+	}
+
+	oldCost := stream.Cost()
+
+	res := make([]resp.RedisData, 0, len(ids))
+	for _, id := range ids {
+		pe, claimed := group.Claim(id, string(cmd[3]), minIdle, force, now)
+		if !claimed {
+			continue
+		}
+		pe.DeliveryTime = deliveryTime
+		if retryCount >= 0 {
+			pe.DeliveryCount = retryCount
+		} else if !justID {
+			pe.DeliveryCount++
+		}
+
+		if justID {
+			res = append(res, resp.MakeBulkData([]byte(id.String())))
+		} else {
+			entries := stream.Range(id, id, 1)
+			res = append(res, MakeStreamEntries(entries).(*resp.ArrayData).Data()...)
+		}
+	}
+
+	db.ReviseNotify(string(cmd[1]), oldCost, stream.Cost())
+
+	return resp.MakeArrayData(res)
+}
+
+func registerStreamCommands() {
+	registerCommand("xadd", xAdd, WR)
+	registerCommand("xrange", xRange, RD)
+	registerCommand("xrevrange", xRevRange, RD)
+	registerCommand("xlen", xLen, RD)
+	registerCommand("xtrim", xTrim, WR)
+	registerCommand("xdel", xDel, WR)
+	registerCommand("xsetid", xSetID, WR)
+	registerCommand("xgroup", xGroup, WR)
+	registerCommand("xack", xAck, WR)
+	registerCommand("xpending", xPending, RD)
+	registerCommand("xclaim", xClaim, WR)
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"strings"
+	"testing"
+)
+
+func TestCmdStream(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	global.UpdateGlobalClock()
+	database.SetKey("str", structure.Slice("v"))
+
+	bulk := func(s string) resp.RedisData {
+		return resp.MakeBulkData([]byte(s))
+	}
+	entry := func(id string, fields ...string) resp.RedisData {
+		values := make([]resp.RedisData, len(fields))
+		for i := range fields {
+			values[i] = bulk(fields[i])
+		}
+		return resp.MakeArrayData([]resp.RedisData{bulk(id), resp.MakeArrayData(values)})
+	}
+	array := func(data ...resp.RedisData) resp.RedisData {
+		return resp.MakeArrayData(data)
+	}
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+	}{
+		{"xadd s 1-1 a 1", bulk("1-1")},
+		{"xadd s 1-* b 2", bulk("1-2")},
+		{"xadd s 1-1 c 3", resp.MakeErrorData("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+		{"xadd s 0-0 c 3", resp.MakeErrorData("ERR The ID specified in XADD must be greater than 0-0")},
+		{"xadd s 2-1 c", resp.MakeErrorData("ERR wrong number of arguments for 'xadd' command")},
+		{"xadd s x-1 c 3", resp.MakeErrorData("ERR Invalid stream ID specified as stream command argument")},
+		{"xadd str 2-1 c 3", resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")},
+		{"xadd none NOMKSTREAM * c 3", resp.MakeNullData()},
+		{"xadd s 2-1 c 3", bulk("2-1")},
+		{"xadd s MAXLEN 5 3-1 d 4", bulk("3-1")},
+		{"xlen s", resp.MakeIntData(4)},
+		{"xlen none", resp.MakeIntData(0)},
+
+		{"xrange s - +", array(entry("1-1", "a", "1"), entry("1-2", "b", "2"), entry("2-1", "c", "3"), entry("3-1", "d", "4"))},
+		{"xrange s 1 1 COUNT 1", array(entry("1-1", "a", "1"))},
+		{"xrange s (1-1 2", array(entry("1-2", "b", "2"), entry("2-1", "c", "3"))},
+		{"xrange s - + COUNT 0", resp.MakeEmptyArrayData()},
+		{"xrange s - + LIMIT 1", resp.MakeErrorData("ERR syntax error")},
+		{"xrevrange s + - COUNT 2", array(entry("3-1", "d", "4"), entry("2-1", "c", "3"))},
+		{"xrevrange s (3-1 (1-2", array(entry("2-1", "c", "3"))},
+		{"xrange none - +", resp.MakeEmptyArrayData()},
+
+		{"xdel s 1-1 9-9", resp.MakeIntData(1)},
+		{"xtrim s MAXLEN 2", resp.MakeIntData(1)},
+		{"xtrim s MAXLEN 2 LIMIT 1", resp.MakeErrorData("ERR syntax error, LIMIT cannot be used without the special ~ option")},
+		{"xtrim s MINID ~ 3 LIMIT 10", resp.MakeIntData(1)},
+		{"xtrim s COUNT 3", resp.MakeErrorData("ERR syntax error")},
+		{"xrange s - +", array(entry("3-1", "d", "4"))},
+
+		{"xsetid s 2-1", resp.MakeErrorData("ERR The ID specified in XSETID is smaller than the target stream top item")},
+		{"xsetid s 5-0", resp.MakeStringData("OK")},
+		{"xadd s 4-1 e 5", resp.MakeErrorData("ERR The ID specified in XADD is equal or smaller than the target stream top item")},
+		{"xadd s 5-* e 5", bulk("5-1")},
+
+		{"xgroup create s g 0", resp.MakeStringData("OK")},
+		{"xgroup create s g 0", resp.MakeErrorData("BUSYGROUP Consumer Group name already exists")},
+		{"xgroup create none g 0", resp.MakeErrorData("ERR The XGROUP subcommand requires the key to exist. " +
+			"Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")},
+		{"xgroup create none g $ MKSTREAM", resp.MakeStringData("OK")},
+		{"xlen none", resp.MakeIntData(0)},
+		{"xgroup createconsumer s g c1", resp.MakeIntData(1)},
+		{"xgroup createconsumer s g c1", resp.MakeIntData(0)},
+		{"xgroup createconsumer s g2 c1", resp.MakeErrorData("NOGROUP No such key 's' or consumer group 'g2'")},
+		{"xgroup help s", resp.MakeErrorData("ERR unknown subcommand 'help'. Try XGROUP HELP.")},
+
+		{"xpending s g", array(resp.MakeIntData(0), resp.MakeNullData(), resp.MakeNullData(), resp.MakeArrayData(nil))},
+		{"xclaim s g c1 0 3-1 FORCE JUSTID", array(bulk("3-1"))},
+		{"xclaim s g c2 0 5-1 FORCE RETRYCOUNT 3", array(entry("5-1", "e", "5"))},
+		{"xpending s g", array(resp.MakeIntData(2), bulk("3-1"), bulk("5-1"),
+			array(array(bulk("c1"), bulk("1")), array(bulk("c2"), bulk("1"))))},
+		{"xpending s g - + 10 c2", array(array(bulk("5-1"), bulk("c2"), resp.MakeIntData(0), resp.MakeIntData(3)))},
+		{"xpending s g IDLE 1000 - + 10", resp.MakeEmptyArrayData()},
+		{"xclaim s g c2 1000 3-1", resp.MakeEmptyArrayData()},
+		{"xclaim s g c2 0 3-1 JUSTID", array(bulk("3-1"))},
+		{"xack s g 3-1 5-1 9-9", resp.MakeIntData(2)},
+		{"xack s none 3-1", resp.MakeIntData(0)},
+
+		{"xgroup setid s g $", resp.MakeStringData("OK")},
+		{"xgroup delconsumer s g c1", resp.MakeIntData(0)},
+		{"xgroup destroy s g", resp.MakeIntData(1)},
+		{"xgroup destroy s g", resp.MakeIntData(0)},
+		{"type s", resp.MakeStringData("stream")},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		cmd, exist := global.FindCommand(string(input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(command)
+
+		ret := c(database, input)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+}
@@ -1,14 +1,95 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/tangrc99/MemTable/db"
 	"github.com/tangrc99/MemTable/db/structure"
 	"github.com/tangrc99/MemTable/resp"
 	"strconv"
+	"strings"
 )
 
 type Slice = structure.Slice
 
+// setOptions 是 set 命令的可选参数
+type setOptions struct {
+	nx       bool  // 只在键不存在时设置
+	xx       bool  // 只在键存在时设置
+	get      bool  // 返回旧值
+	keepTTL  bool  // 保留原有的 ttl
+	expireAt int64 // 毫秒级的过期时间戳，0 代表不设置过期时间
+}
+
+// parseExpireOption 解析 EX、PX、EXAT、PXAT 选项，返回毫秒级的过期时间戳
+func parseExpireOption(name, option string, arg []byte) (int64, resp.RedisData) {
+
+	value, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, resp.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	ok := value > 0
+	if !ok {
+		return 0, resp.MakeErrorData(fmt.Sprintf("ERR invalid expire time in '%s' command", name))
+	}
+
+	var expireAt int64
+	switch option {
+	case "ex":
+		expireAt, ok = expireAtMillis(value, 1000, true)
+	case "px":
+		expireAt, ok = expireAtMillis(value, 1, true)
+	case "exat":
+		expireAt, ok = expireAtMillis(value, 1000, false)
+	default:
+		expireAt, ok = expireAtMillis(value, 1, false)
+	}
+	if !ok {
+		return 0, resp.MakeErrorData(fmt.Sprintf("ERR invalid expire time in '%s' command", name))
+	}
+
+	return expireAt, nil
+}
+
+// parseSetOptions 解析 set 命令中 value 之后的可选参数
+func parseSetOptions(args [][]byte) (*setOptions, resp.RedisData) {
+
+	opts := &setOptions{}
+	expireSet := false
+
+	for i := 0; i < len(args); i++ {
+
+		switch option := strings.ToLower(string(args[i])); option {
+		case "nx":
+			opts.nx = true
+		case "xx":
+			opts.xx = true
+		case "get":
+			opts.get = true
+		case "keepttl":
+			opts.keepTTL = true
+		case "ex", "px", "exat", "pxat":
+			if expireSet || i+1 >= len(args) {
+				return nil, resp.MakeErrorData("ERR syntax error")
+			}
+			expireAt, e := parseExpireOption("set", option, args[i+1])
+			if e != nil {
+				return nil, e
+			}
+			opts.expireAt = expireAt
+			expireSet = true
+			i++
+		default:
+			return nil, resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	if (opts.nx && opts.xx) || (opts.keepTTL && expireSet) {
+		return nil, resp.MakeErrorData("ERR syntax error")
+	}
+
+	return opts, nil
+}
+
 func set(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	// 进行输入类型检查
 	e, ok := checkCommandAndLength(&cmd, "set", 3)
@@ -16,22 +97,117 @@ func set(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		return e
 	}
 
-	value, ok := db.GetKey(string(cmd[1]))
+	opts, e := parseSetOptions(cmd[3:])
+	if e != nil {
+		return e
+	}
+
+	key := string(cmd[1])
+
+	value, exist := db.GetKey(key)
 
 	// 进行类型检查，会自动检查过期选项
 	if err := checkType(value, STRING); err != nil {
 		return err
 	}
 
+	// 未设置 GET 时返回 OK，否则返回旧值
+	var ret resp.RedisData = resp.MakeStringData("OK")
+	if opts.get {
+		if exist {
+			ret = resp.MakeBulkData(value.(Slice))
+		} else {
+			ret = resp.MakeStringData("nil")
+		}
+	}
+
+	if (opts.nx && exist) || (opts.xx && !exist) {
+		if opts.get {
+			return ret
+		}
+		return resp.MakeStringData("nil")
+	}
+
 	// 键值对设置
-	db.SetKey(string(cmd[1]), Slice(cmd[2]))
+	if opts.expireAt > 0 {
+		db.SetKeyWithTTL(key, Slice(cmd[2]), opts.expireAt)
+	} else {
+		db.SetKey(key, Slice(cmd[2]))
+		if !opts.keepTTL {
+			// 重置 TTL
+			db.RemoveTTL(key)
+		}
+	}
 
-	// 重置 TTL
-	db.RemoveTTL(string(cmd[1]))
+	db.NotifyKeyspaceEvent(notifyString, "set", key)
+	if opts.expireAt > 0 {
+		db.NotifyKeyspaceEvent(notifyGeneric, "expire", key)
+	}
+
+	return ret
+}
+
+func setnx(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "setnx", 3)
+	if !ok {
+		return e
+	}
+
+	if len(cmd) != 3 {
+		return resp.MakeErrorData("ERR wrong number of arguments for 'setnx' command")
+	}
+
+	key := string(cmd[1])
+
+	if _, exist := db.GetKey(key); exist {
+		return resp.MakeIntData(0)
+	}
+
+	db.SetKey(key, Slice(cmd[2]))
+	db.RemoveTTL(key)
+	db.NotifyKeyspaceEvent(notifyString, "set", key)
+
+	return resp.MakeIntData(1)
+}
+
+// setWithExpire 是 setex 和 psetex 命令的实现，option 代表过期时间的单位
+func setWithExpire(db *db.DataBase, cmd [][]byte, name, option string) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, name, 4)
+	if !ok {
+		return e
+	}
+
+	if len(cmd) != 4 {
+		return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+	}
+
+	expireAt, e := parseExpireOption(name, option, cmd[2])
+	if e != nil {
+		return e
+	}
+
+	value, _ := db.GetKey(string(cmd[1]))
+	if err := checkType(value, STRING); err != nil {
+		return err
+	}
+
+	db.SetKeyWithTTL(string(cmd[1]), Slice(cmd[3]), expireAt)
+	db.NotifyKeyspaceEvent(notifyString, "set", string(cmd[1]))
+	db.NotifyKeyspaceEvent(notifyGeneric, "expire", string(cmd[1]))
 
 	return resp.MakeStringData("OK")
 }
 
+func setex(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	return setWithExpire(db, cmd, "setex", "ex")
+}
+
+func psetex(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	return setWithExpire(db, cmd, "psetex", "px")
+}
+
 func get(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	// 进行输入类型检查
 	e, ok := checkCommandAndLength(&cmd, "get", 2)
@@ -72,9 +248,86 @@ func getset(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	// 重置 TTL
 	db.SetKey(string(cmd[1]), Slice(cmd[2]))
 	db.RemoveTTL(string(cmd[1]))
+	db.NotifyKeyspaceEvent(notifyString, "set", string(cmd[1]))
 	return resp.MakeStringData("OK")
 }
 
+func getex(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "getex", 2)
+	if !ok {
+		return e
+	}
+
+	persist := false
+	var expireAt int64 = 0
+
+	switch {
+	case len(cmd) == 2:
+	case len(cmd) == 3 && strings.ToLower(string(cmd[2])) == "persist":
+		persist = true
+	case len(cmd) == 4:
+		option := strings.ToLower(string(cmd[2]))
+		if option != "ex" && option != "px" && option != "exat" && option != "pxat" {
+			return resp.MakeErrorData("ERR syntax error")
+		}
+		expireAt, e = parseExpireOption("getex", option, cmd[3])
+		if e != nil {
+			return e
+		}
+	default:
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	value, ok := db.GetKey(string(cmd[1]))
+	if !ok {
+		return resp.MakeStringData("nil")
+	}
+
+	byteVal, ok := value.(Slice)
+	if !ok {
+		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	if persist {
+		if db.RemoveTTL(string(cmd[1])) {
+			db.NotifyKeyspaceEvent(notifyGeneric, "persist", string(cmd[1]))
+		}
+	} else if expireAt > 0 {
+		db.SetTTL(string(cmd[1]), expireAt)
+		db.NotifyKeyspaceEvent(notifyGeneric, "expire", string(cmd[1]))
+	}
+
+	return resp.MakeBulkData(byteVal)
+}
+
+func getdel(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "getdel", 2)
+	if !ok {
+		return e
+	}
+
+	if len(cmd) != 2 {
+		return resp.MakeErrorData("ERR wrong number of arguments for 'getdel' command")
+	}
+
+	value, ok := db.GetKey(string(cmd[1]))
+	if !ok {
+		return resp.MakeStringData("nil")
+	}
+
+	byteVal, ok := value.(Slice)
+	if !ok {
+		return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	db.DeleteKey(string(cmd[1]))
+	db.NotifyKeyspaceEvent(notifyGeneric, "del", string(cmd[1]))
+
+	return resp.MakeBulkData(byteVal)
+}
+
 func strlen(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	// 进行输入类型检查
 	e, ok := checkCommandAndLength(&cmd, "strlen", 2)
@@ -177,6 +430,7 @@ func setRange(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	}
 
 	db.SetKey(string(cmd[1]), Slice(newVal))
+	db.NotifyKeyspaceEvent(notifyString, "setrange", string(cmd[1]))
 
 	return resp.MakeIntData(int64(l))
 }
@@ -228,6 +482,7 @@ func mset(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		db.SetKey(string(cmd[i]), Slice(cmd[i+1]))
 		// 重置 TTL
 		db.RemoveTTL(string(cmd[i]))
+		db.NotifyKeyspaceEvent(notifyString, "set", string(cmd[i]))
 	}
 
 	return resp.MakeStringData("OK")
@@ -256,6 +511,7 @@ func incr(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	intVal++
 	db.SetKey(string(cmd[1]), Slice(strconv.Itoa(intVal)))
+	db.NotifyKeyspaceEvent(notifyString, "incrby", string(cmd[1]))
 
 	return resp.MakeIntData(int64(intVal))
 }
@@ -288,6 +544,7 @@ func incrby(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	intVal += increment
 	db.SetKey(string(cmd[1]), Slice(strconv.Itoa(intVal)))
+	db.NotifyKeyspaceEvent(notifyString, "incrby", string(cmd[1]))
 
 	return resp.MakeIntData(int64(intVal))
 }
@@ -315,6 +572,7 @@ func decr(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	intVal--
 	db.SetKey(string(cmd[1]), Slice(strconv.Itoa(intVal)))
+	db.NotifyKeyspaceEvent(notifyString, "incrby", string(cmd[1]))
 
 	return resp.MakeIntData(int64(intVal))
 
@@ -348,6 +606,7 @@ func decrby(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	intVal -= decrement
 	db.SetKey(string(cmd[1]), Slice(strconv.Itoa(intVal)))
+	db.NotifyKeyspaceEvent(notifyString, "incrby", string(cmd[1]))
 
 	return resp.MakeIntData(int64(intVal))
 
@@ -374,6 +633,7 @@ func appendStr(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	byteVal = append(byteVal, cmd[2]...)
 
 	db.SetKey(string(cmd[1]), byteVal)
+	db.NotifyKeyspaceEvent(notifyString, "append", string(cmd[1]))
 
 	return resp.MakeIntData(int64(len(byteVal)))
 }
@@ -381,8 +641,13 @@ func appendStr(db *db.DataBase, cmd [][]byte) resp.RedisData {
 func registerStringCommands() {
 
 	registerCommand("set", set, WR)
+	registerCommand("setnx", setnx, WR)
+	registerCommand("setex", setex, WR)
+	registerCommand("psetex", psetex, WR)
 	registerCommand("get", get, RD)
 	registerCommand("getset", getset, WR)
+	registerCommand("getex", getex, WR)
+	registerCommand("getdel", getdel, WR)
 	registerCommand("strlen", strlen, RD)
 	registerCommand("getrange", getRange, RD)
 	registerCommand("setrange", setRange, WR)
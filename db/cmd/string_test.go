@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
 	"github.com/tangrc99/MemTable/db"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
 	"testing"
 )
 
@@ -96,3 +98,139 @@ func TestCmdString(t *testing.T) {
 		assert.Equal(t, test.expected, ret)
 	}
 }
+
+func TestCmdStringSetOptions(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	global.UpdateGlobalClock()
+
+	tests := []struct {
+		input    [][]byte
+		expected resp.RedisData
+	}{
+		{[][]byte{[]byte("set"), []byte("k1"), []byte("v1"), []byte("NX"), []byte("PX"), []byte("30000")},
+			resp.MakeStringData("OK")},
+
+		{[][]byte{[]byte("set"), []byte("k1"), []byte("v2"), []byte("NX")},
+			resp.MakeStringData("nil")},
+
+		{[][]byte{[]byte("ttl"), []byte("k1")},
+			resp.MakeIntData(30)},
+
+		{[][]byte{[]byte("set"), []byte("k1"), []byte("v2"), []byte("XX"), []byte("KEEPTTL"), []byte("GET")},
+			resp.MakeBulkData([]byte("v1"))},
+
+		{[][]byte{[]byte("ttl"), []byte("k1")},
+			resp.MakeIntData(30)},
+
+		{[][]byte{[]byte("set"), []byte("k1"), []byte("v3"), []byte("GET")},
+			resp.MakeBulkData([]byte("v2"))},
+
+		{[][]byte{[]byte("ttl"), []byte("k1")},
+			resp.MakeIntData(-1)},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("XX"), []byte("GET")},
+			resp.MakeStringData("nil")},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("PXAT"),
+			[]byte(strconv.FormatInt(global.Now.UnixMilli()+100000, 10))},
+			resp.MakeStringData("OK")},
+
+		{[][]byte{[]byte("ttl"), []byte("k2")},
+			resp.MakeIntData(100)},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("EXAT"),
+			[]byte(strconv.FormatInt(global.Now.Unix()+100, 10))},
+			resp.MakeStringData("OK")},
+
+		{[][]byte{[]byte("expiretime"), []byte("k2")},
+			resp.MakeIntData(global.Now.Unix() + 100)},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("NX"), []byte("XX")},
+			resp.MakeErrorData("ERR syntax error")},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("EX"), []byte("10"), []byte("KEEPTTL")},
+			resp.MakeErrorData("ERR syntax error")},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("EX"), []byte("0")},
+			resp.MakeErrorData("ERR invalid expire time in 'set' command")},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("EX")},
+			resp.MakeErrorData("ERR syntax error")},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("EX"), []byte("9223372036854775807")},
+			resp.MakeErrorData("ERR invalid expire time in 'set' command")},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("PX"), []byte("9223372036854775807")},
+			resp.MakeErrorData("ERR invalid expire time in 'set' command")},
+
+		{[][]byte{[]byte("set"), []byte("k2"), []byte("v"), []byte("EXAT"), []byte("9223372036854775807")},
+			resp.MakeErrorData("ERR invalid expire time in 'set' command")},
+
+		{[][]byte{[]byte("exists"), []byte("k2")},
+			resp.MakeIntData(1)},
+
+		{[][]byte{[]byte("setnx"), []byte("k3"), []byte("v")},
+			resp.MakeIntData(1)},
+
+		{[][]byte{[]byte("setnx"), []byte("k3"), []byte("v")},
+			resp.MakeIntData(0)},
+
+		{[][]byte{[]byte("setex"), []byte("k4"), []byte("10"), []byte("v")},
+			resp.MakeStringData("OK")},
+
+		{[][]byte{[]byte("ttl"), []byte("k4")},
+			resp.MakeIntData(10)},
+
+		{[][]byte{[]byte("setex"), []byte("k4"), []byte("-1"), []byte("v")},
+			resp.MakeErrorData("ERR invalid expire time in 'setex' command")},
+
+		{[][]byte{[]byte("setex"), []byte("k4"), []byte("9223372036854775807"), []byte("v")},
+			resp.MakeErrorData("ERR invalid expire time in 'setex' command")},
+
+		{[][]byte{[]byte("psetex"), []byte("k4"), []byte("9223372036854775807"), []byte("v")},
+			resp.MakeErrorData("ERR invalid expire time in 'psetex' command")},
+
+		{[][]byte{[]byte("psetex"), []byte("k5"), []byte("20000"), []byte("v")},
+			resp.MakeStringData("OK")},
+
+		{[][]byte{[]byte("ttl"), []byte("k5")},
+			resp.MakeIntData(20)},
+
+		{[][]byte{[]byte("getex"), []byte("k5"), []byte("PERSIST")},
+			resp.MakeBulkData([]byte("v"))},
+
+		{[][]byte{[]byte("ttl"), []byte("k5")},
+			resp.MakeIntData(-1)},
+
+		{[][]byte{[]byte("getex"), []byte("k5"), []byte("EX"), []byte("50")},
+			resp.MakeBulkData([]byte("v"))},
+
+		{[][]byte{[]byte("ttl"), []byte("k5")},
+			resp.MakeIntData(50)},
+
+		{[][]byte{[]byte("getex"), []byte("k5"), []byte("KEEPTTL"), []byte("50")},
+			resp.MakeErrorData("ERR syntax error")},
+
+		{[][]byte{[]byte("getex"), []byte("k5"), []byte("EX"), []byte("9223372036854775807")},
+			resp.MakeErrorData("ERR invalid expire time in 'getex' command")},
+
+		{[][]byte{[]byte("getex"), []byte("k6")},
+			resp.MakeStringData("nil")},
+
+		{[][]byte{[]byte("getdel"), []byte("k5")},
+			resp.MakeBulkData([]byte("v"))},
+
+		{[][]byte{[]byte("getdel"), []byte("k5")},
+			resp.MakeStringData("nil")},
+	}
+
+	for _, test := range tests {
+		cmd, exist := global.FindCommand(string(test.input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(command)
+
+		ret := c(database, test.input)
+		assert.Equal(t, test.expected, ret, string(bytes.Join(test.input, []byte(" "))))
+	}
+}
@@ -1,15 +1,63 @@
 package cmd
 
 import (
-	"fmt"
 	"github.com/tangrc99/MemTable/db"
 	"github.com/tangrc99/MemTable/db/structure"
 	"github.com/tangrc99/MemTable/resp"
+	"math"
 	"strconv"
 )
 
 type String = structure.String
 
+// parseScoreBound 解析权重范围的边界，支持 -inf、+inf 以及使用 ( 表示的开区间
+func parseScoreBound(arg []byte) (structure.Float64, bool, resp.RedisData) {
+
+	exclusive := len(arg) > 0 && arg[0] == '('
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	bound, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(bound) {
+		return 0, false, resp.MakeErrorData("ERR value is not a valid float")
+	}
+
+	return structure.Float64(bound), exclusive, nil
+}
+
+// parseScore 解析权重，NaN 不是合法的权重
+func parseScore(arg []byte) (structure.Float64, resp.RedisData) {
+
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, resp.MakeErrorData("ERR value is not a valid float")
+	}
+
+	return structure.Float64(score), nil
+}
+
+// formatScore 使用最短的表示格式化权重，与其他 zset 回复相同
+func formatScore(score structure.Float64) []byte {
+	return []byte(resp.MakeDoubleData(float64(score)).String())
+}
+
+// parseScoreRange 解析 min max 形式的权重范围
+func parseScoreRange(min, max []byte) (structure.ScoreRange, resp.RedisData) {
+
+	r := structure.ScoreRange{}
+
+	var err resp.RedisData
+	if r.Min, r.MinEx, err = parseScoreBound(min); err != nil {
+		return r, err
+	}
+	if r.Max, r.MaxEx, err = parseScoreBound(max); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
 func zADD(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	// 进行输入类型检查
 	e, ok := checkCommandAndLength(&cmd, "zadd", 4)
@@ -32,18 +80,19 @@ func zADD(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 		for i := 2; i < l; i += 2 {
 
-			score, err := strconv.ParseFloat(string(cmd[i]), 32)
+			score, err := parseScore(cmd[i])
 			if err != nil {
-				return resp.MakeErrorData("ERR value is not a valid float")
+				return err
 			}
 
-			if zset.AddIfNotExist(structure.Float32(score), string(cmd[i+1])) {
+			if zset.AddIfNotExist(score, string(cmd[i+1])) {
 				added++
 			}
 		}
 
 		db.SetKey(string(cmd[1]), zset)
 		db.ReviseNotify(string(cmd[1]), 0, zset.Cost())
+		db.NotifyKeyspaceEvent(notifyZSet, "zadd", string(cmd[1]))
 
 		return resp.MakeIntData(int64(added))
 	}
@@ -53,16 +102,16 @@ func zADD(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		return err
 	}
 
-	scores := make([]structure.Float32, l/2-1)
+	scores := make([]structure.Float64, l/2-1)
 	members := make([][]byte, l/2-1)
 
 	for i := 2; i < l; i += 2 {
 
-		score, err := strconv.ParseFloat(string(cmd[i]), 32)
+		score, err := parseScore(cmd[i])
 		if err != nil {
-			return resp.MakeErrorData("ERR value is not a valid float")
+			return err
 		}
-		scores[i/2-1] = structure.Float32(score)
+		scores[i/2-1] = score
 		members[i/2-1] = cmd[i+1]
 	}
 
@@ -82,6 +131,9 @@ func zADD(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	// 重置 TTL
 	db.RemoveTTL(string(cmd[1]))
 	db.ReviseNotify(string(cmd[1]), oldCost, zsetVal.Cost())
+	if added > 0 {
+		db.NotifyKeyspaceEvent(notifyZSet, "zadd", string(cmd[1]))
+	}
 
 	return resp.MakeIntData(int64(added))
 }
@@ -106,16 +158,12 @@ func zCount(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	zsetVal := value.(*structure.ZSet)
 
-	min, err := strconv.ParseFloat(string(cmd[2]), 32)
+	r, err := parseScoreRange(cmd[2], cmd[3])
 	if err != nil {
-		return resp.MakeErrorData("ERR value is not a valid float")
-	}
-	max, err := strconv.ParseFloat(string(cmd[3]), 32)
-	if err != nil {
-		return resp.MakeErrorData("ERR value is not a valid float")
+		return err
 	}
 
-	count := zsetVal.CountByRange(structure.Float32(min), structure.Float32(max))
+	count := zsetVal.CountByRange(r)
 	return resp.MakeIntData(int64(count))
 }
 
@@ -172,6 +220,9 @@ func zRem(db *db.DataBase, cmd [][]byte) resp.RedisData {
 		}
 	}
 	db.ReviseNotify(string(cmd[1]), oldCost, zsetVal.Cost())
+	if deleted > 0 {
+		db.NotifyKeyspaceEvent(notifyZSet, "zrem", string(cmd[1]))
+	}
 	return resp.MakeIntData(int64(deleted))
 }
 
@@ -188,16 +239,17 @@ func zIncrBy(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 		zset := structure.NewZSet()
 
-		increment, err := strconv.ParseFloat(string(cmd[2]), 32)
+		increment, err := parseScore(cmd[2])
 		if err != nil {
-			return resp.MakeErrorData("ERR value is not a valid float")
+			return err
 		}
 
-		zset.Add(structure.Float32(increment), string(cmd[3]))
+		zset.Add(increment, string(cmd[3]))
 
 		db.SetKey(string(cmd[1]), zset)
+		db.NotifyKeyspaceEvent(notifyZSet, "zincr", string(cmd[1]))
 
-		return resp.MakeBulkData(cmd[2])
+		return resp.MakeBulkData(formatScore(increment))
 	}
 
 	// 进行类型检查，会自动检查过期选项
@@ -207,21 +259,28 @@ func zIncrBy(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	zsetVal := value.(*structure.ZSet)
 
-	increment, err := strconv.ParseFloat(string(cmd[2]), 32)
+	increment, err := parseScore(cmd[2])
 	if err != nil {
-		return resp.MakeErrorData("ERR value is not a valid float")
+		return err
+	}
+
+	// inf 与 -inf 相加得到的 NaN 不能写入跳表
+	if old, exist := zsetVal.GetScoreByKey(string(cmd[3])); exist && math.IsNaN(float64(old+increment)) {
+		return resp.MakeErrorData("ERR resulting score is not a number (NaN)")
 	}
 
-	score, ok := zsetVal.IncrScore(string(cmd[3]), structure.Float32(increment))
+	score, ok := zsetVal.IncrScore(string(cmd[3]), increment)
 	if !ok {
 
-		zsetVal.Add(structure.Float32(increment), string(cmd[3]))
-		return resp.MakeBulkData(cmd[2])
+		zsetVal.Add(increment, string(cmd[3]))
+		db.NotifyKeyspaceEvent(notifyZSet, "zincr", string(cmd[1]))
+		return resp.MakeBulkData(formatScore(increment))
 	}
 
 	db.ReviseNotify(string(cmd[1]), 0, 0)
+	db.NotifyKeyspaceEvent(notifyZSet, "zincr", string(cmd[1]))
 
-	return resp.MakeBulkData([]byte(fmt.Sprintf("%f", score)))
+	return resp.MakeBulkData(formatScore(score))
 }
 
 //func zLEXCount(db *db.DataBase, cmd [][]byte) resp.RedisData        {}
@@ -325,13 +384,11 @@ func zRank(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	zsetVal := value.(*structure.ZSet)
 
-	score, ok := zsetVal.GetScoreByKey(string(cmd[2]))
-	if !ok {
+	rank := zsetVal.Rank(string(cmd[2]))
+	if rank < 0 {
 		return resp.MakeStringData("nil")
 	}
 
-	rank := zsetVal.PosByScore(score)
-
 	return resp.MakeIntData(int64(rank))
 }
 
@@ -356,12 +413,11 @@ func zRevRank(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	zsetVal := value.(*structure.ZSet)
 
-	score, ok := zsetVal.GetScoreByKey(string(cmd[2]))
-	if !ok {
+	rank := zsetVal.Rank(string(cmd[2]))
+	if rank < 0 {
 		return resp.MakeStringData("nil")
 	}
-
-	rank := zsetVal.Size() - zsetVal.PosByScore(score) - 1
+	rank = zsetVal.Size() - rank - 1
 
 	return resp.MakeIntData(int64(rank))
 }
@@ -390,7 +446,7 @@ func zScore(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	if !ok {
 		return resp.MakeStringData("nil")
 	}
-	return resp.MakeStringData(fmt.Sprintf("%f", score))
+	return resp.MakeDoubleData(float64(score))
 }
 
 func zRemRangeByRank(db *db.DataBase, cmd [][]byte) resp.RedisData {
@@ -428,6 +484,9 @@ func zRemRangeByRank(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	deleted := zsetVal.DeleteRange(start, end)
 
 	db.ReviseNotify(string(cmd[1]), oldCost, zsetVal.Cost())
+	if deleted > 0 {
+		db.NotifyKeyspaceEvent(notifyZSet, "zremrangebyrank", string(cmd[1]))
+	}
 
 	return resp.MakeIntData(int64(deleted))
 }
@@ -452,20 +511,19 @@ func zRemRangeByScore(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	zsetVal := value.(*structure.ZSet)
 
-	min, err := strconv.ParseFloat(string(cmd[2]), 32)
+	r, err := parseScoreRange(cmd[2], cmd[3])
 	if err != nil {
-		return resp.MakeErrorData("ERR value is not a valid float")
-	}
-	max, err := strconv.ParseFloat(string(cmd[3]), 32)
-	if err != nil {
-		return resp.MakeErrorData("ERR value is not a valid float")
+		return err
 	}
 
 	oldCost := zsetVal.Cost()
 
-	deleted := zsetVal.DeleteRangeByScore(structure.Float32(min), structure.Float32(max))
+	deleted := zsetVal.DeleteRangeByScore(r)
 
 	db.ReviseNotify(string(cmd[1]), oldCost, zsetVal.Cost())
+	if deleted > 0 {
+		db.NotifyKeyspaceEvent(notifyZSet, "zremrangebyscore", string(cmd[1]))
+	}
 
 	return resp.MakeIntData(int64(deleted))
 }
@@ -490,16 +548,12 @@ func zRangeByScore(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	zsetVal := value.(*structure.ZSet)
 
-	min, err := strconv.ParseFloat(string(cmd[2]), 32)
-	if err != nil {
-		return resp.MakeErrorData("ERR value is not a valid float")
-	}
-	max, err := strconv.ParseFloat(string(cmd[3]), 32)
+	r, err := parseScoreRange(cmd[2], cmd[3])
 	if err != nil {
-		return resp.MakeErrorData("ERR value is not a valid float")
+		return err
 	}
 
-	keys, n := zsetVal.GetKeysByRange(structure.Float32(min), structure.Float32(max))
+	keys, n := zsetVal.GetKeysByRange(r)
 
 	res := make([]resp.RedisData, n)
 	for i, key := range keys {
@@ -529,16 +583,13 @@ func zRevRangeByScore(db *db.DataBase, cmd [][]byte) resp.RedisData {
 
 	zsetVal := value.(*structure.ZSet)
 
-	min, err := strconv.ParseFloat(string(cmd[2]), 32)
+	// 与 redis 一致，参数的顺序为 max min
+	r, err := parseScoreRange(cmd[3], cmd[2])
 	if err != nil {
-		return resp.MakeErrorData("ERR value is not a valid float")
-	}
-	max, err := strconv.ParseFloat(string(cmd[3]), 32)
-	if err != nil {
-		return resp.MakeErrorData("ERR value is not a valid float")
+		return err
 	}
 
-	keys, n := zsetVal.GetKeysByRange(structure.Float32(min), structure.Float32(max))
+	keys, n := zsetVal.GetKeysByRange(r)
 
 	res := make([]resp.RedisData, n)
 	for i, key := range keys {
@@ -548,11 +599,182 @@ func zRevRangeByScore(db *db.DataBase, cmd [][]byte) resp.RedisData {
 	return resp.MakeArrayData(res)
 }
 
+func zScan(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "zscan", 3)
+	if !ok {
+		return e
+	}
+
+	cursor, e := parseScanCursor(cmd[2])
+	if e != nil {
+		return e
+	}
+
+	opts, e := parseScanOptions(cmd[3:], false)
+	if e != nil {
+		return e
+	}
+
+	value, ok := db.GetKey(string(cmd[1]))
+	if !ok {
+		return makeScanResult(0, []resp.RedisData{})
+	}
+
+	if err := checkType(value, ZSET); err != nil {
+		return err
+	}
+
+	members := make([]resp.RedisData, 0, 2*opts.count)
+
+	next := value.(*structure.ZSet).Scan(cursor, opts.count, func(key string, score structure.Float64) {
+		if opts.matched(key) {
+			members = append(members, resp.MakeBulkData([]byte(key)), resp.MakeBulkData(formatScore(score)))
+		}
+	})
+
+	return makeScanResult(next, members)
+}
+
 //func zRemRangeByLEX(db *db.DataBase, cmd [][]byte) resp.RedisData   {}
 //func zRevRangeByLEX(db *db.DataBase, cmd [][]byte) resp.RedisData   {}
 //func zUnion(db *db.DataBase, cmd [][]byte) resp.RedisData             {}
 //func zUnionStore(db *db.DataBase, cmd [][]byte) resp.RedisData             {}
 
+// zsetDirection 返回方向对应的命令名称
+func zsetDirection(min bool) string {
+	if min {
+		return "zpopmin"
+	}
+	return "zpopmax"
+}
+
+// PopZSet 从有序集合 key 中弹出至多 count 个权重最小或最大的成员，有序集合被取空后会删除键，键不存在时返回空结果
+func PopZSet(db *db.DataBase, key string, min bool, count int) ([]string, []structure.Float64, resp.RedisData) {
+
+	value, ok := db.GetKey(key)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	if err := checkType(value, ZSET); err != nil {
+		return nil, nil, err
+	}
+
+	zsetVal := value.(*structure.ZSet)
+	oldCost := zsetVal.Cost()
+
+	var members []string
+	var scores []structure.Float64
+	if min {
+		members, scores = zsetVal.PopMin(count)
+	} else {
+		members, scores = zsetVal.PopMax(count)
+	}
+
+	if len(members) == 0 {
+		return nil, nil, nil
+	}
+
+	deleted := zsetVal.Size() == 0
+	if deleted {
+		db.DeleteKey(key)
+	}
+
+	db.ReviseNotify(key, oldCost, zsetVal.Cost())
+	db.NotifyKeyspaceEvent(notifyZSet, zsetDirection(min), key)
+	if deleted {
+		db.NotifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+
+	return members, scores, nil
+}
+
+// MakeZSetPopResult 生成 member score 交替排列的回包
+func MakeZSetPopResult(members []string, scores []structure.Float64) []resp.RedisData {
+	res := make([]resp.RedisData, 0, len(members)*2)
+	for i := range members {
+		res = append(res, resp.MakeBulkData([]byte(members[i])), resp.MakeDoubleData(float64(scores[i])))
+	}
+	return res
+}
+
+// MakeZSetMPopResult 生成 ZMPOP 的回包，每一个成员都以 [member, score] 的形式返回
+func MakeZSetMPopResult(key string, members []string, scores []structure.Float64) resp.RedisData {
+	res := make([]resp.RedisData, len(members))
+	for i := range members {
+		res[i] = resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte(members[i])),
+			resp.MakeDoubleData(float64(scores[i])),
+		})
+	}
+	return resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte(key)), resp.MakeArrayData(res)})
+}
+
+func zPopGeneric(db *db.DataBase, cmd [][]byte, min bool) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, zsetDirection(min), 2)
+	if !ok {
+		return e
+	}
+
+	if len(cmd) > 3 {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	count := 1
+	if len(cmd) == 3 {
+		var err error
+		count, err = strconv.Atoi(string(cmd[2]))
+		if err != nil {
+			return resp.MakeErrorData("ERR value is not an integer or out of range")
+		}
+		if count < 0 {
+			return resp.MakeErrorData("ERR value is out of range, must be positive")
+		}
+	}
+
+	members, scores, e := PopZSet(db, string(cmd[1]), min, count)
+	if e != nil {
+		return e
+	}
+
+	return resp.MakeArrayData(MakeZSetPopResult(members, scores))
+}
+
+func zPopMin(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	return zPopGeneric(db, cmd, true)
+}
+
+func zPopMax(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	return zPopGeneric(db, cmd, false)
+}
+
+func zMPop(db *db.DataBase, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := checkCommandAndLength(&cmd, "zmpop", 4)
+	if !ok {
+		return e
+	}
+
+	args, e := ParseMPopArgs(cmd[1:], "MIN", "MAX")
+	if e != nil {
+		return e
+	}
+
+	for _, key := range args.Keys {
+		members, scores, e := PopZSet(db, key, args.First, args.Count)
+		if e != nil {
+			return e
+		}
+		if len(members) > 0 {
+			return MakeZSetMPopResult(key, members, scores)
+		}
+	}
+
+	return resp.MakeNullData()
+}
+
 func registerZSetCommands() {
 	registerCommand("zadd", zADD, WR)
 	registerCommand("zcount", zCount, RD)
@@ -568,5 +790,9 @@ func registerZSetCommands() {
 	registerCommand("zrevrange", zRevRange, RD)
 	registerCommand("zrangebyscore", zRangeByScore, RD)
 	registerCommand("zrevrangebyscore", zRevRangeByScore, RD)
+	registerCommand("zscan", zScan, RD)
+	registerCommand("zpopmin", zPopMin, WR)
+	registerCommand("zpopmax", zPopMax, WR)
+	registerCommand("zmpop", zMPop, WR)
 
 }
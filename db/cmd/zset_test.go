@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
+	"strings"
 	"testing"
 )
 
@@ -43,7 +45,7 @@ func TestCmdZSet(t *testing.T) {
 			resp.MakeIntData(1)},
 
 		{[][]byte{[]byte("zincrby"), []byte("test"), []byte("1.0"), []byte("k2")},
-			resp.MakeBulkData([]byte(fmt.Sprintf("%f", 2.1)))},
+			resp.MakeBulkData([]byte("2.1"))},
 
 		{[][]byte{[]byte("zincrby"), []byte("test"), []byte("dsfsdf"), []byte("k2")},
 			resp.MakeErrorData("ERR value is not a valid float")},
@@ -82,7 +84,7 @@ func TestCmdZSet(t *testing.T) {
 			resp.MakeStringData("nil")},
 
 		{[][]byte{[]byte("zscore"), []byte("test"), []byte("k2")},
-			resp.MakeStringData(fmt.Sprintf("%f", 2.1))},
+			resp.MakeDoubleData(2.1)},
 
 		{[][]byte{[]byte("zrangebyscore"), []byte("test"), []byte("1.0"), []byte("2.5")},
 			resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte("k1")), resp.MakeBulkData([]byte("k2"))})},
@@ -93,7 +95,7 @@ func TestCmdZSet(t *testing.T) {
 		{[][]byte{[]byte("zrangebyscore"), []byte("test"), []byte("2"), []byte("f")},
 			resp.MakeErrorData("ERR value is not a valid float")},
 
-		{[][]byte{[]byte("zrevrangebyscore"), []byte("test"), []byte("1.0"), []byte("2.5")},
+		{[][]byte{[]byte("zrevrangebyscore"), []byte("test"), []byte("2.5"), []byte("1.0")},
 			resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte("k2")), resp.MakeBulkData([]byte("k1"))})},
 
 		{[][]byte{[]byte("zrevrangebyscore"), []byte("test"), []byte("f"), []byte("2")},
@@ -132,3 +134,144 @@ func TestCmdZSet(t *testing.T) {
 		}
 	}
 }
+
+func TestCmdZSetScoreRange(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	global.UpdateGlobalClock()
+
+	bulks := func(values ...string) resp.RedisData {
+		res := make([]resp.RedisData, len(values))
+		for i, v := range values {
+			res[i] = resp.MakeBulkData([]byte(v))
+		}
+		return resp.MakeArrayData(res)
+	}
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+	}{
+		{"zadd z 1 c 1 a 1 b -inf min +inf max 16777217 big 16777216 small", resp.MakeIntData(7)},
+		{"zrange z 0 -1", bulks("min", "a", "b", "c", "small", "big", "max")},
+		{"zrangebyscore z -inf +inf", bulks("min", "a", "b", "c", "small", "big", "max")},
+		{"zrangebyscore z (1 +inf", bulks("small", "big", "max")},
+		{"zrangebyscore z -inf (1", bulks("min")},
+		{"zrangebyscore z (1 (1", bulks()},
+		{"zrangebyscore z (16777216 16777217", bulks("big")},
+		{"zrevrangebyscore z 1 (-inf", bulks("c", "b", "a")},
+		{"zcount z (-inf (+inf", resp.MakeIntData(5)},
+		{"zcount z 1 1", resp.MakeIntData(3)},
+		{"zcount z (1 nan", resp.MakeErrorData("ERR value is not a valid float")},
+		{"zcount z ( 1", resp.MakeErrorData("ERR value is not a valid float")},
+		{"zrank z b", resp.MakeIntData(2)},
+		{"zrevrank z b", resp.MakeIntData(4)},
+		{"zrem z b", resp.MakeIntData(1)},
+		{"zrange z 0 -1", bulks("min", "a", "c", "small", "big", "max")},
+		{"zincrby z 1 a", resp.MakeBulkData([]byte("2"))},
+		{"zincrby z 0.1 a", resp.MakeBulkData([]byte("2.1"))},
+		{"zincrby z -0.1 a", resp.MakeBulkData([]byte("2"))},
+		{"zadd z 1 a nan b", resp.MakeErrorData("ERR value is not a valid float")},
+		{"zadd nan nan a", resp.MakeErrorData("ERR value is not a valid float")},
+		{"zincrby z nan a", resp.MakeErrorData("ERR value is not a valid float")},
+		{"zincrby z -inf max", resp.MakeErrorData("ERR resulting score is not a number (NaN)")},
+		{"zincrby z 0.5 new", resp.MakeBulkData([]byte("0.5"))},
+		{"zrem z new", resp.MakeIntData(1)},
+		{"zincrby z 1234566 fixed", resp.MakeBulkData([]byte("1234566"))},
+		{"zincrby z 1 fixed", resp.MakeBulkData([]byte("1234567"))},
+		{"zincrby z 1e17 fixed", resp.MakeBulkData([]byte("1.0000000000123456e+17"))},
+		{"zrem z fixed", resp.MakeIntData(1)},
+		{"zrangebyscore z 1 2", bulks("c", "a")},
+		{"zremrangebyscore z (1 (16777217", resp.MakeIntData(2)},
+		{"zremrangebyrank z 0 1", resp.MakeIntData(2)},
+		{"zrange z 0 -1", bulks("big", "max")},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		cmd, exist := global.FindCommand(string(input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(command)
+
+		ret := c(database, input)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+
+	// 小于 1e17 的权重使用定点表示
+	zset := structure.NewZSet()
+	zset.Add(1234567, "a")
+	database.SetKey("fixed", zset)
+	assert.Equal(t, []byte(",16777217\r\n"), zScore(database, [][]byte{[]byte("zscore"), []byte("z"), []byte("big")}).ToBytes())
+	assert.Equal(t, []byte(",1234567\r\n"), zScore(database, [][]byte{[]byte("zscore"), []byte("fixed"), []byte("a")}).ToBytes())
+}
+
+func TestCmdZSetScan(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	zset := structure.NewZSet()
+	zset.Add(1, "a")
+	zset.Add(2, "b")
+	database.SetKey("zset", zset)
+
+	members := scanAll(t, database, [][]byte{[]byte("zscan"), []byte("zset"), nil}, 2)
+	assert.ElementsMatch(t, []resp.RedisData{
+		resp.MakeBulkData([]byte("a")), resp.MakeBulkData([]byte("1")),
+		resp.MakeBulkData([]byte("b")), resp.MakeBulkData([]byte("2")),
+	}, members)
+}
+
+func TestCmdZSetPop(t *testing.T) {
+	database := db.NewDataBase(1)
+
+	double := func(f float64) resp.RedisData {
+		return resp.MakeDoubleData(f)
+	}
+	bulk := func(s string) resp.RedisData {
+		return resp.MakeBulkData([]byte(s))
+	}
+	array := func(values ...resp.RedisData) resp.RedisData {
+		return resp.MakeArrayData(values)
+	}
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+	}{
+		{"zpopmin z", resp.MakeEmptyArrayData()},
+		{"zmpop 1 z MIN", resp.MakeNullData()},
+		{"zadd z 1 a 2 b 3 c 4 d", resp.MakeIntData(4)},
+		{"zpopmin z", array(bulk("a"), double(1))},
+		{"zpopmax z 2", array(bulk("d"), double(4), bulk("c"), double(3))},
+		{"zpopmax z -1", resp.MakeErrorData("ERR value is out of range, must be positive")},
+		{"zadd z2 5 e 6 f", resp.MakeIntData(2)},
+		{"zmpop 2 none z2 MAX COUNT 5", array(bulk("z2"), array(array(bulk("f"), double(6)), array(bulk("e"), double(5))))},
+		{"zmpop 2 z z2 MIN", array(bulk("z"), array(array(bulk("b"), double(2))))},
+		{"zcard z", resp.MakeIntData(0)},
+		{"zmpop 0 z MIN", resp.MakeErrorData("ERR numkeys should be greater than 0")},
+		{"zmpop 2 z MIN", resp.MakeErrorData("ERR syntax error")},
+		{"zmpop 1 z LEFT", resp.MakeErrorData("ERR syntax error")},
+		{"zmpop 1 z MIN COUNT 0", resp.MakeErrorData("ERR count should be greater than 0")},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		cmd, exist := global.FindCommand(string(input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(command)
+
+		ret := c(database, input)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+
+	// 弹出所有成员后删除键
+	assert.False(t, database.ExistKey("z"))
+	assert.False(t, database.ExistKey("z2"))
+}
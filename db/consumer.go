@@ -7,98 +7,183 @@ import (
 	"unsafe"
 )
 
-// consumer 是一个消费者，它包含了一个序列号与一个用于接受消息的 channel；可以认为这是数据库视角下的客户端
-type consumer struct {
-	id       uuid.UUID
-	notifier chan<- []byte
-	deadline int64 // 有效期
+// Waiter 是阻塞在键上等待数据的对象，Waiter 需要自行根据键的状态生成回包
+type Waiter interface {
+	// Serve 会在阻塞的键被修改后调用，返回 true 代表 Waiter 已经被唤醒，需要在所有键上移除
+	Serve(key string) bool
+	// Timeout 会在等待超时后调用，调用前 Waiter 已经在所有键上移除
+	Timeout()
 }
 
-// newConsumer 创建一个 consumer 对象，id 是对应客户端的 id，n 是客户端的通知 channel，ddl 是 consumer 存活时间，-1 代表无存活时间
-func newConsumer(id uuid.UUID, n chan<- []byte, ddl int64) *consumer {
-	return &consumer{
-		id:       id,
-		notifier: n,
-		deadline: ddl,
-	}
+// consumer 是一个消费者，它包含了客户端的序列号以及阻塞的所有键；可以认为这是数据库视角下的客户端
+type consumer struct {
+	id       uuid.UUID
+	keys     []string
+	waiter   Waiter
+	deadline int64 // 毫秒级的有效期，-1 代表永不超时
 }
 
 func (c *consumer) Cost() int64 {
-	return 48 // 24 + 16 + 8
+	return 64 // 16 + 24 + 16 + 8
 }
 
 const blockMapBasicCost = int64(unsafe.Sizeof(blockMap{}))
 
-// blockMap 存储因 blpop 或 brpop 命令而阻塞的客户端信息
+// blockMap 存储因 blpop、blmove、bzpopmin、xread 等命令而阻塞的客户端信息。
+// 每一个键上的消费者按照阻塞的先后顺序排列，键被修改后会按照先进先出的顺序尝试唤醒
 type blockMap struct {
-	consumers map[string]*structure.List
+	consumers map[string]*structure.List // 键 -> 阻塞在键上的消费者
+	clients   map[uuid.UUID]*consumer    // 客户端 -> 消费者
+	ready     []string                   // 被修改过且存在消费者的键，按照修改顺序排列
+	readySet  map[string]struct{}
 	keyCost   int64
 }
 
 func newBlockMap() *blockMap {
 	return &blockMap{
 		consumers: make(map[string]*structure.List),
+		clients:   make(map[uuid.UUID]*consumer),
+		readySet:  make(map[string]struct{}),
 		keyCost:   0,
 	}
 }
 
-func (c *blockMap) register(key string, id uuid.UUID, n chan<- []byte, ddl int64) {
-	l, exist := c.consumers[key]
-	if !exist {
-		l = structure.NewList()
-		c.consumers[key] = l
-		c.keyCost += int64(len(key))
+// register 将客户端 id 阻塞在 keys 上，重复注册会覆盖客户端之前的阻塞
+func (c *blockMap) register(keys []string, id uuid.UUID, w Waiter, ddl int64) {
+
+	c.unregister(id)
+
+	cs := &consumer{id: id, waiter: w, deadline: ddl}
+
+	for _, key := range keys {
+		l, exist := c.consumers[key]
+		if !exist {
+			l = structure.NewList()
+			c.consumers[key] = l
+			c.keyCost += int64(len(key))
+		} else if containsConsumer(l, id) {
+			// 同一个键在命令中出现多次
+			continue
+		}
+		l.PushBack(cs)
+		cs.keys = append(cs.keys, key)
 	}
 
-	// 检查是否有重复注册
+	c.clients[id] = cs
+}
+
+func containsConsumer(l *structure.List, id uuid.UUID) bool {
 	for node := l.FrontNode(); node != nil; node = node.Next() {
 		if node.Value.(*consumer).id == id {
-			node.Value = newConsumer(id, n, ddl)
-			return
+			return true
 		}
 	}
+	return false
+}
+
+// unregister 取消客户端 id 在所有键上的阻塞
+func (c *blockMap) unregister(id uuid.UUID) {
+
+	cs, exist := c.clients[id]
+	if !exist {
+		return
+	}
+	delete(c.clients, id)
 
-	l.PushBack(newConsumer(id, n, ddl))
+	for _, key := range cs.keys {
+		l, exist := c.consumers[key]
+		if !exist {
+			continue
+		}
+		for node := l.FrontNode(); node != nil; node = node.Next() {
+			if node.Value.(*consumer) == cs {
+				l.RemoveNode(node)
+				break
+			}
+		}
+		c.removeIfEmpty(key)
+	}
 }
 
-func (c *blockMap) unregister(key string, id uuid.UUID) {
-	l, exist := c.consumers[key]
+// unblock 取消客户端 id 的阻塞并返回对应的 Waiter，由调用者决定如何通知客户端
+func (c *blockMap) unblock(id uuid.UUID) (Waiter, bool) {
+	cs, exist := c.clients[id]
 	if !exist {
+		return nil, false
+	}
+	c.unregister(id)
+	return cs.waiter, true
+}
+
+// blocked 判断客户端 id 是否处于阻塞状态
+func (c *blockMap) blocked(id uuid.UUID) bool {
+	_, exist := c.clients[id]
+	return exist
+}
+
+// signalReady 标记 key 已经被修改，只有存在消费者的键会被记录
+func (c *blockMap) signalReady(key string) {
+	if _, exist := c.consumers[key]; !exist {
 		return
 	}
-	for n := l.FrontNode(); n != nil; n = n.Next() {
-		if n.Value.(*consumer).id == id {
-			l.RemoveNode(n)
-			return
+	if _, exist := c.readySet[key]; exist {
+		return
+	}
+	c.readySet[key] = struct{}{}
+	c.ready = append(c.ready, key)
+}
+
+// serveReady 唤醒阻塞在已修改键上的消费者。唤醒过程中可能产生新的修改，例如 blmove 写入了目标键，
+// 因此需要循环直到没有新的键被修改
+func (c *blockMap) serveReady() {
+	for len(c.ready) > 0 {
+		ready := c.ready
+		c.ready = nil
+		c.readySet = make(map[string]struct{})
+
+		for _, key := range ready {
+			c.serve(key)
 		}
 	}
 }
 
-func (c *blockMap) tryConsume(key string, message []byte) bool {
+// serve 按照注册顺序尝试唤醒阻塞在 key 上的 Waiter，被唤醒的 Waiter 将在所有键上移除
+func (c *blockMap) serve(key string) {
 	l, exist := c.consumers[key]
-	if !exist || l.Empty() {
-		return false
+	if !exist {
+		return
 	}
 
-	for {
-		v := l.PopFront()
-		if v == nil {
-			delete(c.consumers, key)
-			return false
+	for node := l.FrontNode(); node != nil; {
+		next := node.Next()
+		cs := node.Value.(*consumer)
+		if cs.waiter.Serve(key) {
+			c.unregister(cs.id)
+			if _, exist = c.consumers[key]; !exist {
+				return
+			}
 		}
-		n := v.(*consumer)
-		if n.deadline < 0 || n.deadline > global.Now.Unix() {
-			n.notifier <- message
-			break
+		node = next
+	}
+}
+
+// cleanTimeout 移除所有超时的消费者，超时的 Waiter 会收到通知
+func (c *blockMap) cleanTimeout() {
+	now := global.Now.UnixMilli()
+
+	for id, cs := range c.clients {
+		if cs.deadline >= 0 && cs.deadline <= now {
+			c.unregister(id)
+			cs.waiter.Timeout()
 		}
 	}
+}
 
-	if l.Empty() {
+func (c *blockMap) removeIfEmpty(key string) {
+	if l, exist := c.consumers[key]; exist && l.Empty() {
 		delete(c.consumers, key)
 		c.keyCost -= int64(len(key))
 	}
-
-	return true
 }
 
 // Cost is O(n)
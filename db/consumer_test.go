@@ -7,84 +7,132 @@ import (
 	"testing"
 )
 
-func TestConsumerMap(t *testing.T) {
-
-	c := newBlockMap()
-
-	// basic
-	notifier1 := make(chan []byte, 1)
-	notifier2 := make(chan []byte, 1)
-
-	c.register("123", uuid.Must(uuid.NewV1()), notifier1, -1)
-	c.tryConsume("123", []byte("1"))
-	assert.Equal(t, []byte("1"), <-notifier1)
+type testWaiter struct {
+	ready    bool
+	served   []string
+	timeouts int
+}
 
-	assert.False(t, c.tryConsume("123", []byte("2")))
+func (w *testWaiter) Serve(key string) bool {
+	if w.ready {
+		w.served = append(w.served, key)
+	}
+	return w.ready
+}
 
-	// seq
-	c.register("123", uuid.Must(uuid.NewV1()), notifier1, -1)
-	c.register("123", uuid.Must(uuid.NewV1()), notifier2, -1)
-	c.tryConsume("123", []byte("3"))
-	c.tryConsume("123", []byte("4"))
-	assert.Equal(t, []byte("3"), <-notifier1)
-	assert.Equal(t, []byte("4"), <-notifier2)
+func (w *testWaiter) Timeout() {
+	w.timeouts++
+}
 
-	// unregister
-	id := uuid.Must(uuid.NewV1())
-	c.register("123", uuid.Must(uuid.NewV1()), notifier1, -1)
-	c.unregister("123", id)
+// popWaiter 模拟 blpop，每次唤醒会消耗一个元素
+type popWaiter struct {
+	items  map[string]int
+	served []string
+}
 
+func (w *popWaiter) Serve(key string) bool {
+	if w.items[key] == 0 {
+		return false
+	}
+	w.items[key]--
+	w.served = append(w.served, key)
+	return true
 }
 
-// TestConsumerMapRepeatable 多次注册在同一个键上，应该覆盖之前的值
-func TestConsumerMapRepeatable(t *testing.T) {
-	c := newBlockMap()
+func (w *popWaiter) Timeout() {}
 
-	id := uuid.Must(uuid.NewV1())
-	notifier1 := make(chan []byte, 1)
-	notifier2 := make(chan []byte, 1)
-	c.register("123", id, notifier1, -1)
-	c.register("123", id, notifier2, -1)
-	assert.Equal(t, 1, c.consumers["123"].Size())
+func TestBlockMapWaiter(t *testing.T) {
+	c := newBlockMap()
 
-	c.tryConsume("123", []byte("1"))
+	w1 := &testWaiter{}
+	w2 := &testWaiter{ready: true}
+	c.register([]string{"k"}, uuid.Must(uuid.NewV1()), w1, -1)
+	c.register([]string{"k"}, uuid.Must(uuid.NewV1()), w2, -1)
+
+	// 只有满足条件的 Waiter 会被唤醒并移除
+	c.serve("k")
+	assert.Equal(t, []string{"k"}, w2.served)
+	assert.Equal(t, 1, c.consumers["k"].Size())
+
+	w1.ready = true
+	c.serve("k")
+	assert.Equal(t, []string{"k"}, w1.served)
+	assert.Nil(t, c.consumers["k"])
+	assert.Empty(t, c.clients)
+	assert.Zero(t, c.keyCost)
+
+	// 超时
+	w3 := &testWaiter{}
+	w4 := &testWaiter{}
+	c.register([]string{"k", "k2"}, uuid.Must(uuid.NewV1()), w3, global.Now.UnixMilli()-1)
+	c.register([]string{"k2"}, uuid.Must(uuid.NewV1()), w4, global.Now.UnixMilli()+1000)
+	c.cleanTimeout()
+	assert.Equal(t, 1, w3.timeouts)
+	assert.Zero(t, w4.timeouts)
+	assert.Nil(t, c.consumers["k"])
+	assert.Equal(t, 1, c.consumers["k2"].Size())
 
-	assert.Nil(t, c.consumers["123"])
-	assert.Equal(t, []byte("1"), <-notifier2)
+	id := uuid.Must(uuid.NewV1())
+	c.register([]string{"k3", "k4"}, id, w3, -1)
+	assert.True(t, c.blocked(id))
+	c.unregister(id)
+	assert.False(t, c.blocked(id))
+	assert.Nil(t, c.consumers["k3"])
+	assert.Nil(t, c.consumers["k4"])
 }
 
-func TestConsumerMapDeadLine(t *testing.T) {
+// TestBlockMapRepeatable 同一个客户端多次注册，应该覆盖之前的阻塞
+func TestBlockMapRepeatable(t *testing.T) {
 	c := newBlockMap()
 
 	id := uuid.Must(uuid.NewV1())
-	notifier1 := make(chan []byte, 1)
-	c.register("123", id, notifier1, 1)
-
-	assert.False(t, c.tryConsume("123", []byte("1")))
-
-	c.register("123", id, notifier1, global.Now.Unix()+1)
-	assert.True(t, c.tryConsume("123", []byte("2")))
-
+	w1 := &testWaiter{ready: true}
+	w2 := &testWaiter{ready: true}
+	c.register([]string{"k1", "k1"}, id, w1, -1)
+	assert.Equal(t, 1, c.consumers["k1"].Size())
+
+	c.register([]string{"k2"}, id, w2, -1)
+	assert.Nil(t, c.consumers["k1"])
+	assert.Equal(t, 1, c.consumers["k2"].Size())
+
+	c.serve("k2")
+	assert.Empty(t, w1.served)
+	assert.Equal(t, []string{"k2"}, w2.served)
 }
 
-func TestConsumerMapDeadLine2(t *testing.T) {
+// TestBlockMapFIFO 多个客户端阻塞在同一个键上时，先阻塞的客户端先被唤醒，并且唤醒后会在所有键上移除
+func TestBlockMapFIFO(t *testing.T) {
 	c := newBlockMap()
 
-	notifier1 := make(chan []byte, 1)
-	notifier2 := make(chan []byte, 1)
-	notifier3 := make(chan []byte, 1)
-	notifier4 := make(chan []byte, 1)
-
-	c.register("123", uuid.Must(uuid.NewV1()), notifier1, 1)
-	c.register("123", uuid.Must(uuid.NewV1()), notifier2, 1)
-	c.register("123", uuid.Must(uuid.NewV1()), notifier3, -1)
-	c.register("123", uuid.Must(uuid.NewV1()), notifier4, global.Now.Unix()+1)
-
-	assert.True(t, c.tryConsume("123", []byte("1")))
-	assert.True(t, c.tryConsume("123", []byte("2")))
-	assert.False(t, c.tryConsume("123", []byte("3")))
-
-	assert.Equal(t, []byte("1"), <-notifier3)
-	assert.Equal(t, []byte("2"), <-notifier4)
-
+	items := map[string]int{}
+	w1 := &popWaiter{items: items}
+	w2 := &popWaiter{items: items}
+	w3 := &popWaiter{items: items}
+	c.register([]string{"a", "b"}, uuid.Must(uuid.NewV1()), w1, -1)
+	c.register([]string{"b"}, uuid.Must(uuid.NewV1()), w2, -1)
+	c.register([]string{"b", "a"}, uuid.Must(uuid.NewV1()), w3, -1)
+
+	// 未阻塞的键不会被记录
+	c.signalReady("none")
+	assert.Empty(t, c.ready)
+
+	items["b"] = 2
+	c.signalReady("b")
+	c.signalReady("b")
+	assert.Equal(t, []string{"b"}, c.ready)
+	c.serveReady()
+	assert.Empty(t, c.ready)
+
+	assert.Equal(t, []string{"b"}, w1.served)
+	assert.Equal(t, []string{"b"}, w2.served)
+	assert.Empty(t, w3.served)
+	assert.Equal(t, 1, c.consumers["a"].Size())
+	assert.Equal(t, 1, c.consumers["b"].Size())
+
+	items["a"] = 1
+	c.signalReady("a")
+	c.serveReady()
+	assert.Equal(t, []string{"a"}, w3.served)
+	assert.Empty(t, c.consumers)
+	assert.Empty(t, c.clients)
 }
@@ -19,25 +19,33 @@ const databaseBasicCost = int64(unsafe.Sizeof(DataBase{}))
 // 不同的实例键值可以重复。
 type DataBase struct {
 	dict    *structure.Dict // 存储键值对
-	ttlKeys *structure.Dict // 存储过期键
+	ttlKeys *structure.Dict // 存储过期键，值为毫秒级的 unix 时间戳
 	watches *watcher        // 存储监视键
 	blocked *blockMap       // 阻塞命令
 
 	rookies     *eviction.RookieList // 预备表，优先从预备表中淘汰
 	evict       eviction.Eviction
-	enableEvict bool // 是否开启
+	policy      EvictPolicy // 驱逐策略
+	enableEvict bool        // 是否开启
 
 	notifies           chan<- string // 通知服务层发送驱逐命令
 	enableNotification bool          // 是否开启了服务层通知
+
+	keyspace keyspaceNotifier // 键空间通知
+	tracking *TrackingTable   // 客户端缓存追踪
+
+	slots     slotIndex   // 集群模式下哈希槽中的键
+	snapshots []*Snapshot // 正在生成的快照
 }
 
-// NewDataBase 创建一个新 DataBase 实例，并返回指针
-func NewDataBase(slot int, ops ...Option) *DataBase {
+// NewDataBase 创建一个新 DataBase 实例，并返回指针。shards 为存储键值对的初始分片数量，分片数量会随着键值对数量增长
+func NewDataBase(shards int, ops ...Option) *DataBase {
 	db := &DataBase{
-		dict:        structure.NewDict(slot),
+		dict:        structure.NewDict(shards),
 		ttlKeys:     structure.NewDict(1),
 		watches:     newWatcher(),
 		evict:       eviction.NewNoEviction(),
+		policy:      NoEviction,
 		blocked:     newBlockMap(),
 		enableEvict: false,
 	}
@@ -50,12 +58,14 @@ func NewDataBase(slot int, ops ...Option) *DataBase {
 // checkNotExpired 检查键是否过期，如果过期则会自动删除键值对并返回 false
 func (db_ *DataBase) checkNotExpired(key string) bool {
 
+	db_.beforeAccess(key)
+
 	ttl, exist := db_.ttlKeys.Get(key)
 	if !exist {
 		return true
 	}
 
-	if ttl.(structure.Int64).Value() > global.Now.Unix() {
+	if ttl.(structure.Int64).Value() > global.Now.UnixMilli() {
 		// 如果没有过期
 		return true
 	}
@@ -66,9 +76,15 @@ func (db_ *DataBase) checkNotExpired(key string) bool {
 		// 这里不会发生阻塞，因为每一次事务循环只会清除最多
 		db_.notifies <- key
 	}
+	db_.keyspace.notify(NotifyExpired, "expired", key)
 	return false
 }
 
+// SetEvictPolicy 修改数据库的驱逐策略，已有键值对的淘汰信息会在下一次访问时按照新的策略更新
+func (db_ *DataBase) SetEvictPolicy(policy EvictPolicy) {
+	WithEviction(policy)(db_)
+}
+
 // StartEvictNotification 当数据库发送键驱逐时，通知server，用于主从之间的 oplog 复制。当节点转换为 Master 时，会调用该函数。
 func (db_ *DataBase) StartEvictNotification(ch chan string) {
 	db_.enableNotification = true
@@ -82,28 +98,44 @@ func (db_ *DataBase) StopEvictNotification() {
 
 // RemoveTTL 删除键的 TTL 信息，如果 TTL 则返回 false
 func (db_ *DataBase) RemoveTTL(key string) bool {
+	db_.beforeAccess(key)
 	return db_.ttlKeys.Delete(key)
 }
 
-// GetTTL 得到一个键的 TTL 信息，如果 TTL 存在会返回一个 timestamp；如果 TTL 不存在则会返回-1；
+// GetTTL 得到一个键的 TTL 信息，如果 TTL 存在会返回剩余的毫秒数；如果 TTL 不存在则会返回-1；
 // 如果 TTL 已经过期则会删除 TTL 信息并返回-2
 func (db_ *DataBase) GetTTL(key string) int64 {
 
+	expireAt := db_.GetExpireAt(key)
+	if expireAt < 0 {
+		return expireAt
+	}
+
+	return expireAt - global.Now.UnixMilli()
+}
+
+// GetExpireAt 得到一个键的过期时间，如果 TTL 存在会返回一个毫秒级的 unix 时间戳；如果 TTL 不存在则会返回-1；
+// 如果键不存在或 TTL 已经过期则会删除 TTL 信息并返回-2
+func (db_ *DataBase) GetExpireAt(key string) int64 {
+
+	db_.beforeAccess(key)
+
 	ttl, exist := db_.ttlKeys.Get(key)
 	if exist {
 		// 如果存在 ttl，检查过期时间
-		now := global.Now.Unix()
-		r := ttl.(Int64).Value() - now
-		if r < 0 {
+		if ttl.(Int64).Value() < global.Now.UnixMilli() {
 			db_.ttlKeys.Delete(key)
 			db_.dict.Delete(key)
+			db_.slots.remove(key)
 			if db_.enableNotification {
 				// 这里不会发生阻塞，因为每一次事务循环只会清除最多
 				db_.notifies <- key
 			}
+			db_.keyspace.notify(NotifyExpired, "expired", key)
+			db_.tracking.keyRevised(key)
 			return -2
 		}
-		return r
+		return ttl.(Int64).Value()
 	}
 
 	_, exist = db_.dict.Get(key)
@@ -116,6 +148,8 @@ func (db_ *DataBase) GetTTL(key string) int64 {
 
 // GetKey 查询数据库中是否存在该键值，如果键值存在且为过期，返回键对应的值；若键已经过期，将会删除该键值对，并返回 nil
 func (db_ *DataBase) GetKey(key string) (Object, bool) {
+	// 读取不存在的键同样需要记录，客户端可能缓存了键不存在的结果
+	db_.tracking.remember(key)
 	ok := db_.checkNotExpired(key)
 	if !ok {
 		return nil, false
@@ -133,8 +167,11 @@ func (db_ *DataBase) GetKey(key string) (Object, bool) {
 
 // SetKey 将键值对插入到 DataBase 中，该操作可能会覆盖旧键。
 func (db_ *DataBase) SetKey(key string, value Object) bool {
+	db_.beforeAccess(key)
 	item := &eviction.Item{Value: value}
+	db_.notifyIfNew(key)
 	db_.dict.Set(key, item)
+	db_.slots.add(key)
 	db_.evict.KeyUsed(key, item)
 	if db_.rookies != nil {
 		db_.rookies.NewOne(key)
@@ -143,8 +180,9 @@ func (db_ *DataBase) SetKey(key string, value Object) bool {
 	return true
 }
 
-// SetTTL 设置键值对的 TTL 信息，ttl 为 unix 时间戳。若键值对不存在，将会返回 false
+// SetTTL 设置键值对的 TTL 信息，ttl 为毫秒级的 unix 时间戳。若键值对不存在，将会返回 false
 func (db_ *DataBase) SetTTL(key string, ttl int64) bool {
+	db_.beforeAccess(key)
 	if !db_.dict.Exist(key) {
 		return false
 	}
@@ -152,10 +190,13 @@ func (db_ *DataBase) SetTTL(key string, ttl int64) bool {
 	return true
 }
 
-// SetKeyWithTTL 将键值对插入到 DataBase 中，并设置 TTL 信息，该操作可能会覆盖旧键。
+// SetKeyWithTTL 将键值对插入到 DataBase 中，并设置 TTL 信息，ttl 为毫秒级的 unix 时间戳，该操作可能会覆盖旧键。
 func (db_ *DataBase) SetKeyWithTTL(key string, value Object, ttl int64) bool {
+	db_.beforeAccess(key)
 	item := &eviction.Item{Value: value}
+	db_.notifyIfNew(key)
 	db_.dict.Set(key, item)
+	db_.slots.add(key)
 	db_.ttlKeys.Set(key, Int64(ttl))
 	db_.evict.KeyUsed(key, item)
 	if db_.rookies != nil {
@@ -168,12 +209,14 @@ func (db_ *DataBase) SetKeyWithTTL(key string, value Object, ttl int64) bool {
 // DeleteKey 将会删除 DataBase 中对应的键值对，若键不存在，返回 false
 func (db_ *DataBase) DeleteKey(key string) bool {
 
+	db_.beforeAccess(key)
 	db_.ttlKeys.Delete(key)
 	if db_.rookies != nil {
 		db_.rookies.RemoveOne(key)
 	}
 	exist := db_.dict.Delete(key)
 	if exist {
+		db_.slots.remove(key)
 		db_.ReviseNotify(key, 0, 0)
 	}
 	return exist
@@ -187,12 +230,15 @@ func (db_ *DataBase) RenameKey(old, new string) bool {
 	if !ok {
 		return false
 	}
+	db_.beforeAccess(new)
 
 	ttl, ok := db_.ttlKeys.Get(old)
 	db_.ttlKeys.Delete(old)
 	db_.dict.Delete(old)
+	db_.slots.remove(old)
 
 	db_.dict.Set(new, &eviction.Item{Value: value})
+	db_.slots.add(new)
 	if ttl != nil {
 		db_.ttlKeys.Set(new, ttl)
 	}
@@ -206,6 +252,7 @@ func (db_ *DataBase) RenameKey(old, new string) bool {
 // ExistKey 用于判断键是否存在
 func (db_ *DataBase) ExistKey(key string) bool {
 
+	db_.tracking.remember(key)
 	ok := db_.checkNotExpired(key)
 	if !ok {
 		return false
@@ -224,6 +271,19 @@ func (db_ *DataBase) KeysByte(pattern string) (keys [][]byte, nums int) {
 	return db_.dict.KeysWithTTLByte(db_.ttlKeys, pattern)
 }
 
+// Scan 使用游标遍历 DataBase 中未过期的键值对，用法与 structure.Dict.Scan 相同，已经过期的键不会被返回
+func (db_ *DataBase) Scan(cursor, count int, fn func(key string, value Object)) int {
+
+	now := global.Now.UnixMilli()
+
+	return db_.dict.Scan(cursor, count, func(key string, value Object) {
+		if ttl, ok := db_.ttlKeys.Get(key); ok && ttl.(Int64).Value() <= now {
+			return
+		}
+		fn(key, value.(*eviction.Item).Value)
+	})
+}
+
 // RandomKey 随机返回一个键，如果 DataBase 不存在键值对，将会返回空字符串
 func (db_ *DataBase) RandomKey() (string, bool) {
 	keys := db_.dict.Random(1)
@@ -236,19 +296,23 @@ func (db_ *DataBase) RandomKey() (string, bool) {
 // CleanExpiredKeys 在 db 中随机抽取 samples 个数的 ttl key，如果过期则删除，并返回删除掉的个数
 func (db_ *DataBase) CleanExpiredKeys(samples int) int {
 
-	now := global.Now.Unix()
+	now := global.Now.UnixMilli()
 
 	ttls := db_.ttlKeys.Random(samples)
 	deleted := 0
 	for key, expire := range ttls {
 		if expire.(Int64).Value() < now {
 			deleted++
+			db_.beforeAccess(key)
 			db_.ttlKeys.Delete(key)
 			db_.dict.Delete(key)
+			db_.slots.remove(key)
 			if db_.enableNotification {
 				// 这里不会发生阻塞，因为每一次事务循环只会清除最多
 				db_.notifies <- key
 			}
+			db_.keyspace.notify(NotifyExpired, "expired", key)
+			db_.tracking.keyRevised(key)
 		}
 	}
 	return deleted
@@ -256,8 +320,10 @@ func (db_ *DataBase) CleanExpiredKeys(samples int) int {
 
 // Clear 用于情况 DataBase 中的所有信息
 func (db_ *DataBase) Clear() {
-	db_.dict = structure.NewDict(db_.dict.ShardNum())
-	db_.ttlKeys = structure.NewDict(db_.ttlKeys.ShardNum())
+	db_.beforeClear()
+	db_.dict.Clear()
+	db_.ttlKeys.Clear()
+	db_.slots.clear()
 }
 
 // Size 返回数据库中键值对数量，函数不会检查键值对的过期情况。
@@ -285,11 +351,31 @@ func (db_ *DataBase) UnWatch(key string, flag *bool) {
 func (db_ *DataBase) ReviseNotify(key string, oldCost, newCost int64) {
 	db_.dict.UpdateCost(db_.dict.Cost() + newCost - oldCost)
 	db_.watches.reviseNotify(key)
+	db_.blocked.signalReady(key)
+	db_.tracking.keyRevised(key)
 }
 
 // ReviseNotifyAll 通知所有被 watch 的键修改，用于 flushdb 和 flushall 命令
 func (db_ *DataBase) ReviseNotifyAll() {
 	db_.watches.reviseNotifyAll()
+	db_.tracking.flushed()
+}
+
+// NotifyKeyspaceEvent 发布键空间通知，class 为事件的类型，写命令需要在修改键之后调用
+func (db_ *DataBase) NotifyKeyspaceEvent(class int, event, key string) {
+	db_.keyspace.notify(class, event, key)
+}
+
+// SetKeyspaceEvents 修改开启的键空间通知类型，flags 可以通过 ParseKeyspaceEvents 得到
+func (db_ *DataBase) SetKeyspaceEvents(flags int) {
+	db_.keyspace.flags = flags
+}
+
+// notifyIfNew 在键不存在时发布 new 事件
+func (db_ *DataBase) notifyIfNew(key string) {
+	if db_.keyspace.flags&NotifyNew != 0 && !db_.dict.Exist(key) {
+		db_.keyspace.notify(NotifyNew, "new", key)
+	}
 }
 
 // WatchSize 返回数据库中被监控的键值对数目
@@ -297,16 +383,60 @@ func (db_ *DataBase) WatchSize() int {
 	return db_.watches.Size()
 }
 
-func (db_ *DataBase) RegisterBlocked(key string, id uuid.UUID, n chan<- []byte, ddl int64) {
-	db_.blocked.register(key, id, n, ddl)
+// RegisterWaiter 将 w 注册为阻塞在 keys 上的等待者，ddl 为毫秒级的超时时间戳，-1 代表永不超时。
+// 同一个客户端重复注册会覆盖之前的阻塞
+func (db_ *DataBase) RegisterWaiter(keys []string, id uuid.UUID, w Waiter, ddl int64) {
+	db_.blocked.register(keys, id, w, ddl)
+}
+
+// UnregisterBlocked 取消客户端 id 在所有键上的阻塞
+func (db_ *DataBase) UnregisterBlocked(id uuid.UUID) {
+	db_.blocked.unregister(id)
+}
+
+// Unblock 取消客户端 id 的阻塞并返回对应的等待者，客户端没有阻塞时返回 false
+func (db_ *DataBase) Unblock(id uuid.UUID) (Waiter, bool) {
+	return db_.blocked.unblock(id)
+}
+
+// IsBlocked 判断客户端 id 是否阻塞在当前数据库的键上
+func (db_ *DataBase) IsBlocked(id uuid.UUID) bool {
+	return db_.blocked.blocked(id)
+}
+
+// ServeBlocked 唤醒阻塞在已修改键上的等待者，需要在命令执行完毕后调用
+func (db_ *DataBase) ServeBlocked() {
+	db_.blocked.serveReady()
+}
+
+// CleanTimeoutBlocked 移除所有等待超时的阻塞客户端
+func (db_ *DataBase) CleanTimeoutBlocked() {
+	db_.blocked.cleanTimeout()
+}
+
+// ActiveRehash 对存储键值对的 Dict 进行最多 steps 步 rehash，用于在空闲时推进渐进式 rehash
+func (db_ *DataBase) ActiveRehash(steps int) {
+	db_.dict.Rehash(steps)
+	db_.ttlKeys.Rehash(steps)
+}
+
+// slotIndex 返回哈希槽索引，索引不存在时会使用现有的键创建
+func (db_ *DataBase) slotIndex() *slotIndex {
+	if !db_.slots.enabled() {
+		keys, n := db_.dict.Keys("")
+		db_.slots.build(keys[:n])
+	}
+	return &db_.slots
 }
 
+// SlotCount 返回哈希槽中的键数量，函数不会检查键值对的过期情况
 func (db_ *DataBase) SlotCount(slotSeq int) int {
-	return db_.dict.ShardCount(slotSeq)
+	return db_.slotIndex().count(slotSeq)
 }
 
+// KeysInSlot 返回哈希槽中最多 count 个键，函数不会检查键值对的过期情况
 func (db_ *DataBase) KeysInSlot(slotSeq, count int) ([]string, int) {
-	return db_.dict.KeysInShard(slotSeq, count)
+	return db_.slotIndex().keys(slotSeq, count)
 }
 
 // IsKeyPermitted 检查键是否允许被写入，如果不允许返回 -1，否则返回权重值
@@ -377,7 +507,7 @@ func (db_ *DataBase) evictTTLKeys(access, roomNeeded int64) (evicted []string, a
 		// 选择一个价值最小的键或一个过期的键
 		for k, ttl := range db_.ttlKeys.Random(10) {
 
-			if ttl.(Int64).Value() < global.Now.Unix() {
+			if ttl.(Int64).Value() < global.Now.UnixMilli() {
 				minKey = k
 				break
 			}
@@ -423,11 +553,13 @@ func (db_ *DataBase) Evict(access, roomNeeded int64) (evicted []string, accepted
 	// 驱逐通知
 	for i := range evicted {
 		db_.notifies <- evicted[i]
+		db_.keyspace.notify(NotifyEvicted, "evicted", evicted[i])
+		db_.tracking.keyRevised(evicted[i])
 	}
 
 	return evicted, accepted
 }
 
 func (db_ *DataBase) Cost() int64 {
-	return db_.dict.Cost() + db_.ttlKeys.Cost() + db_.watches.Cost() + databaseBasicCost
+	return db_.dict.Cost() + db_.ttlKeys.Cost() + db_.watches.Cost() + db_.slots.cost + databaseBasicCost
 }
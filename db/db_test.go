@@ -4,6 +4,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/tangrc99/MemTable/db/eviction"
 	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
 	"testing"
 	"time"
 )
@@ -39,11 +40,12 @@ func TestDataBaseTTL(t *testing.T) {
 
 	db := NewDataBase(1)
 
-	assert.True(t, db.SetKeyWithTTL("key", Int64(1), global.Now.Unix()+1))
+	assert.True(t, db.SetKeyWithTTL("key", Int64(1), global.Now.UnixMilli()+1000))
 	assert.True(t, db.SetKey("k1", Int64(1)))
-	assert.True(t, db.SetTTL("k1", global.Now.Unix()+2))
+	assert.True(t, db.SetTTL("k1", global.Now.UnixMilli()+2000))
 
-	assert.Equal(t, int64(2), db.GetTTL("k1"))
+	assert.Equal(t, int64(2000), db.GetTTL("k1"))
+	assert.Equal(t, global.Now.UnixMilli()+2000, db.GetExpireAt("k1"))
 
 	global.Now = global.Now.Add(time.Second)
 	assert.Equal(t, int64(0), db.GetTTL("key"))
@@ -58,7 +60,7 @@ func TestDataBaseTTL(t *testing.T) {
 	assert.False(t, db.ExistKey("k1"))
 
 	assert.False(t, db.RemoveTTL("k1"))
-	assert.True(t, db.SetKeyWithTTL("key", Int64(1), global.Now.Unix()+1))
+	assert.True(t, db.SetKeyWithTTL("key", Int64(1), global.Now.UnixMilli()+1000))
 	assert.True(t, db.RemoveTTL("key"))
 
 }
@@ -82,10 +84,10 @@ func TestDataBaseWatch(t *testing.T) {
 func TestDataBaseRandom(t *testing.T) {
 
 	db := NewDataBase(1)
-	db.SetKeyWithTTL("k1", Int64(1), global.Now.Unix()+1)
-	db.SetKeyWithTTL("k2", Int64(1), global.Now.Unix()+1)
-	db.SetKeyWithTTL("k3", Int64(1), global.Now.Unix()+1)
-	db.SetKeyWithTTL("k4", Int64(1), global.Now.Unix()+1)
+	db.SetKeyWithTTL("k1", Int64(1), global.Now.UnixMilli()+1000)
+	db.SetKeyWithTTL("k2", Int64(1), global.Now.UnixMilli()+1000)
+	db.SetKeyWithTTL("k3", Int64(1), global.Now.UnixMilli()+1000)
+	db.SetKeyWithTTL("k4", Int64(1), global.Now.UnixMilli()+1000)
 
 	keys := []string{"k1", "k2", "k3", "k4"}
 
@@ -121,4 +123,98 @@ func TestDataBaseOptions(t *testing.T) {
 
 	db5 := NewDataBase(1, WithRookies())
 	assert.NotNil(t, db5.rookies)
+
+	db5.SetEvictPolicy(EvictLFU)
+	assert.IsType(t, &eviction.TinyLFU{}, db5.evict)
+	assert.True(t, db5.enableEvict)
+
+	policy, ok := ParseEvictPolicy("lru")
+	assert.True(t, ok)
+	assert.Equal(t, EvictLRU, policy)
+	_, ok = ParseEvictPolicy("random")
+	assert.False(t, ok)
+}
+
+func TestDataBaseSlots(t *testing.T) {
+
+	global.UpdateGlobalClock()
+
+	db := NewDataBase(1)
+
+	// 索引创建前写入的键同样会被统计
+	db.SetKey("{user}.1", Int64(1))
+	slot := KeySlot("user")
+	assert.Equal(t, 1, db.SlotCount(slot))
+
+	db.SetKey("{user}.2", Int64(2))
+	db.SetKeyWithTTL("{user}.3", Int64(3), global.Now.UnixMilli()-1)
+	assert.Equal(t, 3, db.SlotCount(slot))
+
+	// 过期键被删除后从索引中移除
+	assert.Equal(t, int64(-2), db.GetExpireAt("{user}.3"))
+	assert.Equal(t, 2, db.SlotCount(slot))
+
+	assert.True(t, db.RenameKey("{user}.2", "other"))
+	assert.Equal(t, 1, db.SlotCount(slot))
+	assert.Equal(t, 1, db.SlotCount(KeySlot("other")))
+
+	keys, n := db.KeysInSlot(slot, 10)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []string{"{user}.1"}, keys)
+
+	// 大量写入使 Dict 扩容后，索引仍然准确
+	for i := 0; i < 1000; i++ {
+		db.SetKey("{user}."+strconv.Itoa(i), Int64(i))
+	}
+	assert.Equal(t, 1000, db.SlotCount(slot))
+
+	db.DeleteKey("{user}.1")
+	assert.Equal(t, 999, db.SlotCount(slot))
+
+	db.Clear()
+	assert.Equal(t, 0, db.SlotCount(slot))
+	assert.Equal(t, 0, db.SlotCount(KeySlot("other")))
+}
+
+type snapshotRecorder map[string]Object
+
+func (r snapshotRecorder) WriteKey(_ int, key string, value Object, _ int64) error {
+	r[key] = value
+	return nil
+}
+
+func TestDataBaseSnapshot(t *testing.T) {
+
+	db := NewDataBase(1)
+	for i := 0; i < 100; i++ {
+		db.SetKey(strconv.Itoa(i), Int64(i))
+	}
+
+	recorder := snapshotRecorder{}
+	snap := db.BeginSnapshot(0, recorder)
+	assert.False(t, snap.Step(10))
+
+	// 快照开始之后的修改不会出现在快照中
+	db.SetKey("0", Int64(-1))
+	db.DeleteKey("1")
+	assert.True(t, db.RenameKey("2", "renamed"))
+	db.SetKey("new", Int64(0))
+
+	for !snap.Step(10) {
+	}
+	assert.Nil(t, snap.Err())
+	assert.Equal(t, 100, len(recorder))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, Int64(i), recorder[strconv.Itoa(i)])
+	}
+
+	// 快照结束之后不再记录
+	db.SetKey("3", Int64(-1))
+	assert.Equal(t, Int64(3), recorder["3"])
+
+	// 清空数据库前会完成快照
+	recorder = snapshotRecorder{}
+	db.BeginSnapshot(0, recorder)
+	db.Clear()
+	assert.Equal(t, 100, len(recorder))
 }
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"github.com/tangrc99/MemTable/utils"
+	"io"
+	"strings"
+)
+
+const (
+	dumpRDBVersion    = 9  // DUMP 负载中写入的 rdb 版本
+	dumpRDBMaxVersion = 11 // RESTORE 能够接受的最大 rdb 版本
+	dumpFooterSize    = 10 // 2 字节的 rdb 版本以及 8 字节的 crc64 校验和
+
+	// dumpTypeCommands 是 rdb 格式不支持的值（stream 与 bloom filter）在负载中使用的对象类型，redis 不会使用该类型。
+	// 对象的内容是在空键上重建该值的 aof 命令，RESTORE 时这些命令会在临时的数据库中执行
+	dumpTypeCommands = 0xf0
+)
+
+// dumpCommands 是 dumpTypeCommands 类型的对象中允许出现的命令
+var dumpCommands = map[string]struct{}{
+	"xadd": {}, "xsetid": {}, "xgroup": {}, "xclaim": {}, "bf.loadchunk": {},
+}
+
+var (
+	// ErrDumpPayload 代表 DUMP 负载的版本或校验和错误
+	ErrDumpPayload = errors.New("ERR DUMP payload version or checksum are wrong")
+	// ErrDumpFormat 代表 DUMP 负载无法被解析
+	ErrDumpFormat = errors.New("ERR Bad data format")
+)
+
+// DumpValue 将值序列化为与 redis DUMP 命令兼容的格式：rdb 对象类型、rdb 对象编码、2 字节的 rdb 版本以及 8 字节的 crc64 校验和。
+// rdb 格式不支持的值会使用 dumpTypeCommands 类型序列化，这样的负载只能被 MemTable 恢复
+func DumpValue(value Object) ([]byte, error) {
+
+	// 编码器只能在 rdb 文件中写入对象，对象会以空键的形式写入到一个临时的 rdb 文件中，然后截取出来
+	buf := &bytes.Buffer{}
+	enc := core.NewEncoder(buf)
+	if err := enc.WriteHeader(); err != nil {
+		return nil, err
+	}
+	if err := enc.WriteDBHeader(0, 1, 0); err != nil {
+		return nil, err
+	}
+
+	start := buf.Len()
+	if err := encodeObject(enc, "", value); err == errRDBUnsupported {
+		return dumpCommandsPayload(value)
+	} else if err != nil {
+		return nil, err
+	}
+	object := buf.Bytes()[start:]
+
+	// 空键只占用一个字节的长度编码，位于类型之后
+	payload := make([]byte, 0, len(object)-1+dumpFooterSize)
+	payload = append(payload, object[0])
+	payload = append(payload, object[2:]...)
+
+	return appendDumpFooter(payload), nil
+}
+
+// dumpCommandsPayload 将值序列化为在空键上重建该值的 aof 命令
+func dumpCommandsPayload(value Object) ([]byte, error) {
+
+	buf := bytes.NewBuffer([]byte{dumpTypeCommands})
+	if err := newAOFRewriter(buf, 0).writeObject([]byte{}, value, 0); err != nil {
+		return nil, err
+	}
+
+	return appendDumpFooter(buf.Bytes()), nil
+}
+
+// appendDumpFooter 在负载的末尾写入 rdb 版本以及校验和
+func appendDumpFooter(payload []byte) []byte {
+	payload = binary.LittleEndian.AppendUint16(payload, dumpRDBVersion)
+	return binary.LittleEndian.AppendUint64(payload, utils.CRC64(0, payload))
+}
+
+// RestoreValue 将 DumpValue 生成的负载反序列化为值，负载的版本以及校验和会被检查
+func RestoreValue(payload []byte) (Object, error) {
+
+	if len(payload) < dumpFooterSize+1 {
+		return nil, ErrDumpPayload
+	}
+
+	n := len(payload)
+	version := binary.LittleEndian.Uint16(payload[n-dumpFooterSize:])
+	checksum := binary.LittleEndian.Uint64(payload[n-8:])
+
+	if version > dumpRDBMaxVersion || utils.CRC64(0, payload[:n-8]) != checksum {
+		return nil, ErrDumpPayload
+	}
+
+	body := payload[:n-dumpFooterSize]
+	if body[0] == dumpTypeCommands {
+		return restoreCommands(body[1:])
+	}
+
+	// 将对象包装为只包含一个空键的 rdb 文件交给解码器解析
+	rdb := bytes.NewBufferString("REDIS0009")
+	rdb.Write([]byte{0xfe, 0x00, body[0], 0x00})
+	rdb.Write(body[1:])
+	rdb.WriteByte(0xff)
+
+	var object model.RedisObject
+	err := core.NewDecoder(rdb).Parse(func(o model.RedisObject) bool {
+		object = o
+		return false
+	})
+	if err != nil || object == nil {
+		return nil, ErrDumpFormat
+	}
+
+	value, err := decodeObject(object)
+	if err != nil {
+		return nil, ErrDumpFormat
+	}
+
+	return value, nil
+}
+
+// restoreCommands 在临时的数据库中执行 dumpCommandsPayload 生成的命令，返回空键上重建的值
+func restoreCommands(body []byte) (Object, error) {
+
+	base := NewDataBase(1)
+	parser := resp.NewParser(bytes.NewReader(body))
+
+	for {
+
+		parsed := parser.Parse()
+		if parsed.Err == io.EOF {
+			break
+		} else if parsed.Err != nil {
+			return nil, ErrDumpFormat
+		}
+
+		array, ok := parsed.Data.(*resp.ArrayData)
+		if !ok {
+			return nil, ErrDumpFormat
+		}
+		cmd := array.ToCommand()
+		if len(cmd) == 0 {
+			return nil, ErrDumpFormat
+		}
+
+		name := strings.ToLower(string(cmd[0]))
+		if _, ok = dumpCommands[name]; !ok {
+			return nil, ErrDumpFormat
+		}
+
+		command, exist := global.FindCommand(name)
+		if !exist {
+			return nil, ErrDumpFormat
+		}
+		f, ok := command.Function().(func(base *DataBase, cmd [][]byte) resp.RedisData)
+		if !ok {
+			return nil, ErrDumpFormat
+		}
+		if _, isErr := f(base, cmd).(*resp.ErrorData); isErr {
+			return nil, ErrDumpFormat
+		}
+	}
+
+	// 命令只能创建空键
+	value, ok := base.GetKey("")
+	if !ok || base.Size() != 1 {
+		return nil, ErrDumpFormat
+	}
+
+	return value, nil
+}
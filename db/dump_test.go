@@ -0,0 +1,76 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/db/structure"
+	"strings"
+	"testing"
+)
+
+func TestDumpAndRestore(t *testing.T) {
+
+	list := structure.NewList()
+	list.PushBack(structure.Slice("a"))
+	list.PushBack(structure.Slice("b"))
+
+	set := structure.NewSet()
+	set.Add("m1")
+	set.Add("m2")
+
+	zset := structure.NewZSet()
+	zset.Add(1.5, "m1")
+	zset.Add(-2, "m2")
+
+	hash := structure.NewDict(1)
+	hash.Set("f", structure.Slice("v"))
+
+	values := []Object{
+		structure.Slice("hello"), structure.Slice("12345"), structure.Slice(strings.Repeat("a", 1000)),
+		list, set, zset, hash,
+	}
+
+	for _, value := range values {
+		payload, err := DumpValue(value)
+		assert.Nil(t, err)
+
+		restored, err := RestoreValue(payload)
+		assert.Nil(t, err)
+		assert.IsType(t, value, restored)
+
+		switch v := value.(type) {
+		case structure.Slice:
+			assert.Equal(t, v, restored)
+		case *structure.List:
+			values, _ := restored.(*structure.List).Range(0, -1)
+			assert.Equal(t, []Object{structure.Slice("a"), structure.Slice("b")}, values)
+		case *structure.Set:
+			assert.Equal(t, 2, restored.(*structure.Set).Size())
+			assert.True(t, restored.(*structure.Set).Exist("m2"))
+		case *structure.ZSet:
+			score, _ := restored.(*structure.ZSet).GetScoreByKey("m1")
+			assert.Equal(t, structure.Float64(1.5), score)
+			score, _ = restored.(*structure.ZSet).GetScoreByKey("m2")
+			assert.Equal(t, structure.Float64(-2), score)
+		case *structure.Dict:
+			field, _ := restored.(*structure.Dict).Get("f")
+			assert.Equal(t, structure.Slice("v"), field)
+		}
+	}
+
+	// redis 文档中 DUMP 10 的结果
+	payload, err := DumpValue(structure.Slice("10"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("\x00\xc0\n\t\x00\xbem\x06\x89Z(\x00\n"), payload)
+
+	// 负载被修改后校验和不匹配
+	payload[2] = 'H'
+	_, err = RestoreValue(payload)
+	assert.Equal(t, ErrDumpPayload, err)
+	_, err = RestoreValue([]byte("short"))
+	assert.Equal(t, ErrDumpPayload, err)
+
+	// stream 以重建命令的形式序列化
+	payload, err = DumpValue(structure.NewStream())
+	assert.Nil(t, err)
+	assert.Equal(t, byte(dumpTypeCommands), payload[0])
+}
@@ -1,6 +1,7 @@
 package eviction
 
 import (
+	"github.com/tangrc99/MemTable/server/global"
 	"math"
 )
 
@@ -11,8 +12,9 @@ func NewNoEviction() *NoEviction {
 	return &NoEviction{}
 }
 
-// KeyUsed 表示该键值被调用一次
+// KeyUsed 表示该键值被调用一次，不进行驱逐时同样记录访问时间，用于 OBJECT IDLETIME 命令
 func (*NoEviction) KeyUsed(_ string, item *Item) {
+	item.Evict = global.Now.Unix()
 }
 
 // Estimate 评估键值对的键值
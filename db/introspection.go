@@ -0,0 +1,132 @@
+package db
+
+import (
+	"fmt"
+	"github.com/tangrc99/MemTable/db/eviction"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
+	"strings"
+)
+
+// embstrSizeLimit 是 redis 中 embstr 编码的最大长度，这里只用于 OBJECT ENCODING 的展示
+const embstrSizeLimit = 44
+
+// ObjectEncoding 返回值的底层编码名称，编码名称与实际使用的数据结构对应
+func ObjectEncoding(value Object) string {
+	switch v := value.(type) {
+	case structure.Slice:
+		if len(v) <= 20 {
+			if _, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+				return "int"
+			}
+		}
+		if len(v) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case *structure.List:
+		return "linkedlist"
+	case *structure.Dict, *structure.Set:
+		return "hashtable"
+	case *structure.ZSet:
+		return "skiplist"
+	case *structure.Stream:
+		return "stream"
+	case *structure.Bloom:
+		return "bloomfilter"
+	}
+	return "unknown"
+}
+
+// PeekKey 返回键对应的值，与 GetKey 不同，该函数不会更新键的访问记录，也不会被客户端缓存追踪
+func (db_ *DataBase) PeekKey(key string) (Object, bool) {
+	item, ok := db_.peekItem(key)
+	if !ok {
+		return nil, false
+	}
+	return item.Value, true
+}
+
+func (db_ *DataBase) peekItem(key string) (*eviction.Item, bool) {
+	if !db_.checkNotExpired(key) {
+		return nil, false
+	}
+	item, exist := db_.dict.Get(key)
+	if !exist {
+		return nil, false
+	}
+	return item.(*eviction.Item), true
+}
+
+// EvictPolicy 返回数据库当前的驱逐策略
+func (db_ *DataBase) EvictPolicy() EvictPolicy {
+	return db_.policy
+}
+
+// KeyIdleTime 返回键未被访问的秒数，键不存在时返回 -1。LFU 策略下不记录访问时间，结果没有意义
+func (db_ *DataBase) KeyIdleTime(key string) int64 {
+	item, ok := db_.peekItem(key)
+	if !ok {
+		return -1
+	}
+	return global.Now.Unix() - item.Evict
+}
+
+// KeyFrequency 返回 LFU 策略估计的键访问频率，键不存在时返回 -1
+func (db_ *DataBase) KeyFrequency(key string) int64 {
+	if _, ok := db_.peekItem(key); !ok {
+		return -1
+	}
+	return db_.evict.Estimate(key)
+}
+
+// MemoryUsage 返回键值对占用的内存，包括键、值、淘汰信息以及 TTL 信息，键不存在时返回 -1
+func (db_ *DataBase) MemoryUsage(key string) int64 {
+	item, ok := db_.peekItem(key)
+	if !ok {
+		return -1
+	}
+	usage := int64(len(key)) + item.Cost()
+	if ttl, exist := db_.ttlKeys.Get(key); exist {
+		usage += int64(len(key)) + ttl.Cost()
+	}
+	return usage
+}
+
+// HashTableStats 返回键空间与过期字典中各个分片的统计信息，用于诊断分片是否均匀
+func (db_ *DataBase) HashTableStats() string {
+	b := strings.Builder{}
+	b.WriteString("[Dictionary HT]\n")
+	writeDictStats(&b, db_.dict)
+	b.WriteString("[Expires HT]\n")
+	writeDictStats(&b, db_.ttlKeys)
+	return b.String()
+}
+
+func writeDictStats(b *strings.Builder, dict *structure.Dict) {
+
+	shards := dict.ShardNum()
+	minSize, maxSize, empty := -1, 0, 0
+
+	for i := 0; i < shards; i++ {
+		n := dict.ShardCount(i)
+		if minSize < 0 || n < minSize {
+			minSize = n
+		}
+		if n > maxSize {
+			maxSize = n
+		}
+		if n == 0 {
+			empty++
+		}
+	}
+
+	b.WriteString(fmt.Sprintf("shards: %d\n", shards))
+	b.WriteString(fmt.Sprintf("keys: %d\n", dict.Size()))
+	b.WriteString(fmt.Sprintf("cost: %d\n", dict.Cost()))
+	b.WriteString(fmt.Sprintf("empty shards: %d\n", empty))
+	b.WriteString(fmt.Sprintf("min shard size: %d\n", minSize))
+	b.WriteString(fmt.Sprintf("max shard size: %d\n", maxSize))
+	b.WriteString(fmt.Sprintf("avg shard size: %.2f\n", float64(dict.Size())/float64(shards)))
+}
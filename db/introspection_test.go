@@ -0,0 +1,52 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/server/global"
+	"strings"
+	"testing"
+)
+
+func TestIntrospection(t *testing.T) {
+
+	global.UpdateGlobalClock()
+
+	db := NewDataBase(1)
+	db.SetKey("int", structure.Slice("12345"))
+	db.SetKey("str", structure.Slice("hello"))
+	db.SetKey("raw", structure.Slice(strings.Repeat("a", 64)))
+	db.SetKey("list", structure.NewList())
+	db.SetKeyWithTTL("ttl", structure.Slice("v"), global.Now.UnixMilli()+10000)
+
+	assert.Equal(t, NoEviction, db.EvictPolicy())
+
+	encodings := map[string]string{"int": "int", "str": "embstr", "raw": "raw", "list": "linkedlist"}
+	for key, encoding := range encodings {
+		value, ok := db.PeekKey(key)
+		assert.True(t, ok)
+		assert.Equal(t, encoding, ObjectEncoding(value))
+	}
+
+	_, ok := db.PeekKey("none")
+	assert.False(t, ok)
+	assert.Equal(t, int64(-1), db.KeyIdleTime("none"))
+	assert.Equal(t, int64(-1), db.MemoryUsage("none"))
+	assert.Equal(t, int64(0), db.KeyIdleTime("str"))
+
+	// 带有 TTL 的键需要额外计算过期字典中的占用
+	assert.Equal(t, int64(len("str")+8+5), db.MemoryUsage("str"))
+	assert.Equal(t, int64(len("ttl")+8+1+len("ttl")+8), db.MemoryUsage("ttl"))
+
+	db.SetEvictPolicy(EvictLFU)
+	assert.Equal(t, EvictLFU, db.EvictPolicy())
+	db.GetKey("str")
+	db.GetKey("str")
+	assert.Greater(t, db.KeyFrequency("str"), int64(0))
+
+	stats := db.HashTableStats()
+	assert.Contains(t, stats, "[Dictionary HT]")
+	assert.Contains(t, stats, "keys: 5")
+	assert.Contains(t, stats, "[Expires HT]")
+	assert.Contains(t, stats, "keys: 1")
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// 键空间通知的类型，与 redis 的 notify-keyspace-events 配置一致
+const (
+	NotifyKeyspace = 1 << iota // K，发布到 __keyspace@<db>__:<key> 频道
+	NotifyKeyevent             // E，发布到 __keyevent@<db>__:<event> 频道
+	NotifyGeneric              // g，del、expire、rename 等与类型无关的命令
+	NotifyString               // $
+	NotifyList                 // l
+	NotifySet                  // s
+	NotifyHash                 // h
+	NotifyZSet                 // z
+	NotifyExpired              // x，键过期
+	NotifyEvicted              // e，键因内存不足被逐出
+	NotifyStream               // t
+	NotifyNew                  // n，新建键
+
+	// NotifyAll 是配置中 A 的别名，不包括 n
+	NotifyAll = NotifyGeneric | NotifyString | NotifyList | NotifySet | NotifyHash | NotifyZSet |
+		NotifyExpired | NotifyEvicted | NotifyStream
+)
+
+// ErrInvalidKeyspaceEvents 代表 notify-keyspace-events 配置中包含不支持的字符
+var ErrInvalidKeyspaceEvents = errors.New("invalid notify-keyspace-events flags")
+
+// ParseKeyspaceEvents 将 notify-keyspace-events 配置转换为通知类型的掩码
+func ParseKeyspaceEvents(s string) (int, error) {
+	flags := 0
+	for _, c := range s {
+		switch c {
+		case 'A':
+			flags |= NotifyAll
+		case 'g':
+			flags |= NotifyGeneric
+		case '$':
+			flags |= NotifyString
+		case 'l':
+			flags |= NotifyList
+		case 's':
+			flags |= NotifySet
+		case 'h':
+			flags |= NotifyHash
+		case 'z':
+			flags |= NotifyZSet
+		case 'x':
+			flags |= NotifyExpired
+		case 'e':
+			flags |= NotifyEvicted
+		case 't':
+			flags |= NotifyStream
+		case 'n':
+			flags |= NotifyNew
+		case 'K':
+			flags |= NotifyKeyspace
+		case 'E':
+			flags |= NotifyKeyevent
+		default:
+			return 0, ErrInvalidKeyspaceEvents
+		}
+	}
+	return flags, nil
+}
+
+// KeyspaceEventsString 是 ParseKeyspaceEvents 的逆操作
+func KeyspaceEventsString(flags int) string {
+	var b strings.Builder
+	if flags&NotifyAll == NotifyAll {
+		b.WriteByte('A')
+	} else {
+		for _, f := range []struct {
+			flag int
+			c    byte
+		}{
+			{NotifyGeneric, 'g'}, {NotifyString, '$'}, {NotifyList, 'l'}, {NotifySet, 's'},
+			{NotifyHash, 'h'}, {NotifyZSet, 'z'}, {NotifyExpired, 'x'}, {NotifyEvicted, 'e'},
+			{NotifyStream, 't'},
+		} {
+			if flags&f.flag != 0 {
+				b.WriteByte(f.c)
+			}
+		}
+	}
+	if flags&NotifyNew != 0 {
+		b.WriteByte('n')
+	}
+	if flags&NotifyKeyspace != 0 {
+		b.WriteByte('K')
+	}
+	if flags&NotifyKeyevent != 0 {
+		b.WriteByte('E')
+	}
+	return b.String()
+}
+
+// keyspaceNotifier 负责将键空间通知发布到订阅频道中
+type keyspaceNotifier struct {
+	id    int       // 数据库的序号
+	chs   *Channels // 订阅发布频道
+	flags int       // 开启的通知类型
+}
+
+// notify 发布一个类型为 class 的事件，只有该类型以及 K、E 中至少一项开启时才会发布
+func (n *keyspaceNotifier) notify(class int, event, key string) {
+
+	if n.chs == nil || n.flags&class == 0 || n.flags&(NotifyKeyspace|NotifyKeyevent) == 0 {
+		return
+	}
+
+	db := strconv.Itoa(n.id)
+
+	if n.flags&NotifyKeyspace != 0 {
+		n.publish("__keyspace@"+db+"__:"+key, event)
+	}
+	if n.flags&NotifyKeyevent != 0 {
+		n.publish("__keyevent@"+db+"__:"+event, key)
+	}
+}
+
+func (n *keyspaceNotifier) publish(channel, payload string) {
+	n.chs.PublishMessage(channel, []byte(payload))
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"testing"
+	"time"
+)
+
+func TestParseKeyspaceEvents(t *testing.T) {
+
+	tests := []struct {
+		input    string
+		flags    int
+		expected string
+	}{
+		{"", 0, ""},
+		{"KEA", NotifyKeyspace | NotifyKeyevent | NotifyAll, "AKE"},
+		{"Kg$", NotifyKeyspace | NotifyGeneric | NotifyString, "g$K"},
+		{"Exn", NotifyKeyevent | NotifyExpired | NotifyNew, "xnE"},
+		{"Elshzet", NotifyKeyevent | NotifyList | NotifySet | NotifyHash | NotifyZSet | NotifyEvicted | NotifyStream, "lshzetE"},
+	}
+
+	for _, test := range tests {
+		flags, err := ParseKeyspaceEvents(test.input)
+		assert.Nil(t, err)
+		assert.Equal(t, test.flags, flags, test.input)
+		assert.Equal(t, test.expected, KeyspaceEventsString(flags), test.input)
+	}
+
+	_, err := ParseKeyspaceEvents("KEm")
+	assert.Equal(t, ErrInvalidKeyspaceEvents, err)
+}
+
+func message(channel, payload string) []byte {
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("message")),
+		resp.MakeBulkData([]byte(channel)),
+		resp.MakeBulkData([]byte(payload)),
+	}).ToBytes()
+}
+
+func TestKeyspaceNotification(t *testing.T) {
+
+	chs := NewChannels()
+	db := NewDataBase(1, WithKeyspaceNotification(0, chs, NotifyKeyspace|NotifyKeyevent|NotifyGeneric|NotifyExpired))
+
+	keyspace := make(chan []byte, 10)
+	keyevent := make(chan []byte, 10)
+	chs.Subscribe("__keyspace@0__:k", "u1", &keyspace)
+	chs.Subscribe("__keyevent@0__:expired", "u1", &keyevent)
+
+	// 未开启的类型不会发布
+	db.NotifyKeyspaceEvent(NotifyString, "set", "k")
+	assert.Len(t, keyspace, 0)
+
+	db.NotifyKeyspaceEvent(NotifyGeneric, "del", "k")
+	assert.Equal(t, message("__keyspace@0__:k", "del"), <-keyspace)
+
+	global.UpdateGlobalClock()
+	db.SetKeyWithTTL("k", Int64(1), global.Now.UnixMilli()+1)
+	time.Sleep(5 * time.Millisecond)
+	global.UpdateGlobalClock()
+
+	_, ok := db.GetKey("k")
+	assert.False(t, ok)
+	assert.Equal(t, message("__keyspace@0__:k", "expired"), <-keyspace)
+	assert.Equal(t, message("__keyevent@0__:expired", "k"), <-keyevent)
+
+	// 开启 n 后新建键会发布 new 事件
+	db.SetKeyspaceEvents(NotifyKeyspace | NotifyNew)
+	db.SetKey("k", Int64(1))
+	assert.Equal(t, message("__keyspace@0__:k", "new"), <-keyspace)
+	db.SetKey("k", Int64(2))
+	assert.Len(t, keyspace, 0)
+
+	// 关闭后不再发布
+	db.SetKeyspaceEvents(0)
+	db.DeleteKey("k")
+	db.NotifyKeyspaceEvent(NotifyGeneric, "del", "k")
+	assert.Len(t, keyspace, 0)
+}
@@ -12,23 +12,39 @@ const (
 	NoEviction
 )
 
+// ParseEvictPolicy 解析配置文件中的驱逐策略，可选值为 lru、lfu、no
+func ParseEvictPolicy(name string) (EvictPolicy, bool) {
+	switch name {
+	case "lru":
+		return EvictLRU, true
+	case "lfu":
+		return EvictLFU, true
+	case "no":
+		return NoEviction, true
+	}
+	return NoEviction, false
+}
+
 func WithEviction(policy EvictPolicy) Option {
 	switch policy {
 	case EvictLRU:
 		return func(db *DataBase) {
 			db.enableEvict = true
 			db.evict = eviction.NewSampleLRU()
+			db.policy = EvictLRU
 		}
 	case EvictLFU:
 		return func(db *DataBase) {
 			db.enableEvict = true
 			db.evict = eviction.NewTinyLFU(100)
+			db.policy = EvictLFU
 		}
 	}
 
 	return func(db *DataBase) {
 		db.enableEvict = false
 		db.evict = eviction.NewNoEviction()
+		db.policy = NoEviction
 	}
 }
 
@@ -46,6 +62,20 @@ func WithEvictNotification(evictNotification chan string) Option {
 	}
 }
 
+// WithKeyspaceNotification 开启键空间通知，id 为数据库的序号，通知会发布到 chs 中
+func WithKeyspaceNotification(id int, chs *Channels, flags int) Option {
+	return func(db *DataBase) {
+		db.keyspace = keyspaceNotifier{id: id, chs: chs, flags: flags}
+	}
+}
+
+// WithTracking 开启客户端缓存的失效通知，所有数据库需要共享同一个 TrackingTable
+func WithTracking(t *TrackingTable) Option {
+	return func(db *DataBase) {
+		db.tracking = t
+	}
+}
+
 //func WithMemoryLimit(max uint64) Option {
 //	return func(db *DataBase) {
 //
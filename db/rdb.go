@@ -1,116 +1,314 @@
 package db
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/hdt3213/rdb/core"
 	"github.com/hdt3213/rdb/encoder"
 	"github.com/hdt3213/rdb/model"
-	"github.com/tangrc99/MemTable/db/eviction"
 	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/server/global"
+	"hash"
+	"hash/crc64"
+	"io"
 )
 
-// Encode 将阻塞地将 DataBase 中的全部键值对写入到 rdb 文件中，如果写入过程发生错误将返回 error
-func (db_ *DataBase) Encode(enc *core.Encoder) error {
+// errRDBUnsupported 代表值的类型无法使用 rdb 格式编码
+var errRDBUnsupported = errors.New("type not supported by rdb")
 
-	var err error = nil
+// RDBAuxAOF 是保存 rdb 格式不支持的键值对的辅助字段，值为重建这些键值对的 aof 命令。
+// 其他读取 rdb 文件的程序会忽略该字段，只会丢失这部分键值对
+const RDBAuxAOF = "memtable-aof"
 
-	dicts, _ := db_.dict.GetAll()
+const rdbOpCodeEOF = 0xff
 
-	keys := 0
-	ttls := 0
+// RDBWriter 将键值对编码为 rdb 格式。每一个数据库的键值对会被编码到各自的缓冲区中，由 Save 组装为完整的 rdb 文件，
+// 因此键值对可以按照任意的数据库顺序写入。rdb 格式不支持 stream 与 bloom filter，它们会被转换为 aof 命令保存在辅助字段中
+type RDBWriter struct {
+	dbs []*rdbDBWriter
+	aof bytes.Buffer // rdb 格式不支持的键值对对应的 aof 命令
+}
 
-	for _, dict := range dicts {
-		for k, v := range dict {
+// rdbDBWriter 保存一个数据库中编码完成的键值对
+type rdbDBWriter struct {
+	enc   *core.Encoder
+	buf   bytes.Buffer
+	start int // 编码器只能在文件头以及数据库头之后写入对象，start 为第一个对象的位置
+	keys  uint64
+	ttls  uint64
+}
 
-			v = v.(*eviction.Item).Value
+// NewRDBWriter 创建一个 RDBWriter 并返回指针
+func NewRDBWriter() *RDBWriter {
+	return &RDBWriter{}
+}
 
-			keys++
-			var ttl uint64 = 0
+// database 返回 dbSeq 号数据库的缓冲区，缓冲区不存在时会创建
+func (w *RDBWriter) database(dbSeq int) (*rdbDBWriter, error) {
 
-			if expiredAt, ok := db_.ttlKeys.Get(k); ok {
+	for len(w.dbs) <= dbSeq {
+		w.dbs = append(w.dbs, nil)
+	}
+	if w.dbs[dbSeq] != nil {
+		return w.dbs[dbSeq], nil
+	}
 
-				ttl = uint64(expiredAt.(Int64) * 1000)
-				ttls++
-			}
+	d := &rdbDBWriter{}
+	d.enc = core.NewEncoder(&d.buf).EnableCompress()
+	if err := d.enc.WriteHeader(); err != nil {
+		return nil, err
+	}
+	if err := d.enc.WriteDBHeader(uint(dbSeq), 0, 0); err != nil {
+		return nil, err
+	}
+	d.start = d.buf.Len()
+	w.dbs[dbSeq] = d
+	return d, nil
+}
+
+// WriteKey 将 dbSeq 号数据库中的一个键值对编码，expireAt 为毫秒级的 unix 时间戳，0 代表没有过期时间
+func (w *RDBWriter) WriteKey(dbSeq int, key string, value Object, expireAt int64) error {
 
-			if str, ok := v.(structure.Slice); ok {
-
-				if ttl > 0 {
-					err = enc.WriteStringObject(k, str, encoder.WithTTL(ttl))
-				} else {
-					err = enc.WriteStringObject(k, str)
-				}
-
-			} else if list, ok := v.(*structure.List); ok {
-
-				values, n := list.Range(0, -1)
-				listVal := make([][]byte, n)
-				for i, value := range values {
-					listVal[i] = value.(structure.Slice)
-				}
-				if ttl > 0 {
-					err = enc.WriteListObject(k, listVal, encoder.WithTTL(ttl))
-				} else {
-					err = enc.WriteListObject(k, listVal)
-				}
-
-			} else if set, ok := v.(*structure.Set); ok {
-
-				members, _ := set.KeysByte("")
-				if ttl > 0 {
-					err = enc.WriteSetObject(k, members, encoder.WithTTL(ttl))
-				} else {
-					err = enc.WriteSetObject(k, members)
-				}
-
-			} else if zset, ok := v.(*structure.ZSet); ok {
-
-				members, n := zset.Pos(0, -1)
-				entrys := make([]*model.ZSetEntry, n)
-				for i, member := range members {
-					score, _ := zset.GetScoreByKey(string(member.(structure.String)))
-					entrys[i] = &model.ZSetEntry{
-						Score:  float64(score),
-						Member: string(member.(structure.String)),
-					}
-				}
-				if ttl > 0 {
-					err = enc.WriteZSetObject(k, entrys, encoder.WithTTL(ttl))
-				} else {
-					err = enc.WriteZSetObject(k, entrys)
-				}
-
-			} else if hash, ok := v.(*structure.Dict); ok {
-
-				kvs, _ := hash.GetAll()
-				entrys := make(map[string][]byte)
-				for key, value := range (kvs)[0] {
-					entrys[key] = value.(structure.Slice)
-				}
-
-				if ttl > 0 {
-					err = enc.WriteHashMapObject(k, entrys, encoder.WithTTL(ttl))
-				} else {
-					err = enc.WriteHashMapObject(k, entrys)
-				}
-			} else {
-
-				panic(fmt.Sprintf("Unexpected type %T", v))
+	d, err := w.database(dbSeq)
+	if err != nil {
+		return err
+	}
+
+	options := make([]interface{}, 0, 1)
+	if expireAt > 0 {
+		options = append(options, encoder.WithTTL(uint64(expireAt)))
+	}
+
+	err = encodeObject(d.enc, key, value, options...)
+	if err == errRDBUnsupported {
+		return newAOFRewriter(&w.aof, dbSeq).writeObject([]byte(key), value, expireAt)
+	}
+	if err != nil {
+		return err
+	}
+
+	d.keys++
+	if expireAt > 0 {
+		d.ttls++
+	}
+	return nil
+}
+
+// Save 将完整的 rdb 文件写入到 writer 中，aux 为额外写入的辅助字段。该函数不会访问数据库，可以在后台协程中调用
+func (w *RDBWriter) Save(writer io.Writer, aux map[string]string) error {
+
+	// 与编码器相同，校验和覆盖文件中除校验和以外的全部内容
+	out := &rdbChecksumWriter{writer: writer, crc: crc64.New(crc64.MakeTable(crc64.ISO))}
+
+	enc := core.NewEncoder(out).EnableCompress()
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+	for k, v := range aux {
+		if err := enc.WriteAux(k, v); err != nil {
+			return err
+		}
+	}
+	if w.aof.Len() > 0 {
+		if err := enc.WriteAux(RDBAuxAOF, w.aof.String()); err != nil {
+			return err
+		}
+	}
+
+	for seq, d := range w.dbs {
+
+		if d == nil || d.keys == 0 {
+			continue
+		}
+
+		header, err := rdbDBHeader(seq, d.keys, d.ttls)
+		if err != nil {
+			return err
+		}
+		if _, err = out.Write(header); err != nil {
+			return err
+		}
+		if _, err = out.Write(d.buf.Bytes()[d.start:]); err != nil {
+			return err
+		}
+	}
+
+	if _, err := out.Write([]byte{rdbOpCodeEOF}); err != nil {
+		return err
+	}
+	if _, err := writer.Write(out.crc.Sum(nil)); err != nil {
+		return err
+	}
+	_, err := writer.Write([]byte{'\n'})
+	return err
+}
+
+// rdbDBHeader 返回选择数据库以及数据库大小的操作码。编码器只能在文件头之后写入数据库头，这里使用临时的编码器生成后截取出来
+func rdbDBHeader(dbSeq int, keys, ttls uint64) ([]byte, error) {
+
+	buf := &bytes.Buffer{}
+	enc := core.NewEncoder(buf)
+	if err := enc.WriteHeader(); err != nil {
+		return nil, err
+	}
+	start := buf.Len()
+	if err := enc.WriteDBHeader(uint(dbSeq), keys, ttls); err != nil {
+		return nil, err
+	}
+	return buf.Bytes()[start:], nil
+}
+
+// rdbChecksumWriter 在写入的同时计算校验和
+type rdbChecksumWriter struct {
+	writer io.Writer
+	crc    hash.Hash64
+}
+
+func (w *rdbChecksumWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	_, _ = w.crc.Write(p[:n])
+	return n, err
+}
 
+// LoadRDB 解码 reader 中的 rdb 文件，每一个键值对都会交给 fn 处理，expireAt 为毫秒级的 unix 时间戳，0 代表没有过期时间；
+// 辅助字段 RDBAuxAOF 中的命令会交给 aof 执行。已经过期的键会被跳过，rdb 文件中存在无法识别的对象时将返回 error
+func LoadRDB(reader io.Reader, fn func(dbSeq int, key string, value Object, expireAt int64) error, aof func(commands string) error) error {
+
+	now := global.Now.UnixMilli()
+
+	var err error
+	parseErr := core.NewDecoder(reader).WithSpecialOpCode().Parse(func(obj model.RedisObject) bool {
+
+		switch o := obj.(type) {
+		case *model.AuxObject:
+			if o.Key == RDBAuxAOF {
+				err = aof(o.Value)
 			}
+			return err == nil
+		case *model.DBSizeObject:
+			return true
+		}
 
-			if err != nil {
-				return err
+		var expireAt int64 = 0
+		if expiration := obj.GetExpiration(); expiration != nil {
+			if expireAt = expiration.UnixMilli(); expireAt <= now {
+				return true
 			}
 		}
+
+		var value Object
+		if value, err = decodeObject(obj); err != nil {
+			return false
+		}
+		err = fn(obj.GetDBIndex(), obj.GetKey(), value, expireAt)
+		return err == nil
+	})
+
+	if parseErr != nil {
+		return parseErr
 	}
+	return err
+}
+
+// encodeObject 将一个键值对以 rdb 格式写入到 enc 中，options 用于设置 TTL 信息。如果值的类型不被 rdb 支持，将返回 errRDBUnsupported
+func encodeObject(enc *core.Encoder, k string, v Object, options ...interface{}) error {
+
+	if str, ok := v.(structure.Slice); ok {
+
+		return enc.WriteStringObject(k, str, options...)
+
+	} else if list, ok := v.(*structure.List); ok {
+
+		values, n := list.Range(0, -1)
+		listVal := make([][]byte, n)
+		for i, value := range values {
+			listVal[i] = value.(structure.Slice)
+		}
+		return enc.WriteListObject(k, listVal, options...)
+
+	} else if set, ok := v.(*structure.Set); ok {
+
+		members, _ := set.KeysByte("")
+		return enc.WriteSetObject(k, members, options...)
+
+	} else if zset, ok := v.(*structure.ZSet); ok {
+
+		members, n := zset.Pos(0, -1)
+		entrys := make([]*model.ZSetEntry, n)
+		for i, member := range members {
+			score, _ := zset.GetScoreByKey(string(member.(structure.String)))
+			entrys[i] = &model.ZSetEntry{
+				Score:  float64(score),
+				Member: string(member.(structure.String)),
+			}
+		}
+		return enc.WriteZSetObject(k, entrys, options...)
+
+	} else if hash, ok := v.(*structure.Dict); ok {
+
+		kvs, _ := hash.GetAll()
+		entrys := make(map[string][]byte)
+		for _, kv := range kvs {
+			for key, value := range kv {
+				entrys[key] = value.(structure.Slice)
+			}
+		}
+		return enc.WriteHashMapObject(k, entrys, options...)
+
+	} else if _, ok := v.(*structure.Bloom); ok {
+
+		return errRDBUnsupported
+
+	} else if _, ok := v.(*structure.Stream); ok {
+
+		return errRDBUnsupported
 
-	if ttls != db_.TTLSize() {
-		return errors.New(fmt.Sprintf("DB TTL Size Not Matched, Expected %d But %d", db_.TTLSize(), ttls))
 	}
-	if keys != db_.Size() {
-		return errors.New(fmt.Sprintf("DB Size Not Matched, Expected %d But %d", db_.Size(), keys))
+
+	panic(fmt.Sprintf("Unexpected type %T", v))
+}
+
+// decodeObject 将 rdb 解码得到的对象转换为数据库中存储的值
+func decodeObject(obj model.RedisObject) (Object, error) {
+
+	switch o := obj.(type) {
+	case *model.StringObject:
+
+		return structure.Slice(o.Value), nil
+
+	case *model.ListObject:
+
+		list := structure.NewList()
+		for _, value := range o.Values {
+			list.PushBack(structure.Slice(value))
+		}
+		return list, nil
+
+	case *model.SetObject:
+
+		set := structure.NewSet()
+		for _, member := range o.Members {
+			set.Add(string(member))
+		}
+		return set, nil
+
+	case *model.ZSetObject:
+
+		zset := structure.NewZSet()
+		for _, entry := range o.Entries {
+			zset.Add(structure.Float64(entry.Score), entry.Member)
+		}
+		return zset, nil
+
+	case *model.HashObject:
+
+		hash := structure.NewDict(1)
+		for field, value := range o.Hash {
+			hash.Set(field, structure.Slice(value))
+		}
+		return hash, nil
 	}
-	return err
+
+	return nil, fmt.Errorf("unexpected rdb object type %T", obj)
 }
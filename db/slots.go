@@ -0,0 +1,93 @@
+package db
+
+import (
+	"github.com/tangrc99/MemTable/utils"
+	"unsafe"
+)
+
+// SlotNum 是集群模式下哈希槽的数量，与 redis 相同
+const SlotNum = 16384
+
+const slotIndexBasicCost = int64(unsafe.Sizeof(map[string]struct{}{}))
+
+// KeySlot 使用 CRC16 计算键所在的哈希槽，与 redis 相同，支持 hashtag
+func KeySlot(key string) int {
+	return utils.HashKey(key) % SlotNum
+}
+
+// slotIndex 记录每一个哈希槽中的键，用于集群模式下统计以及迁移哈希槽中的键。
+// 哈希槽与存储键值对的 Dict 分片相互独立，索引在第一次被使用时才会创建，非集群模式下不会产生额外的开销
+type slotIndex struct {
+	slots []map[string]struct{} // 每一个哈希槽中的键，nil 代表索引未创建
+	cost  int64                 // 消耗的内存
+}
+
+// enabled 判断索引是否已经创建
+func (idx *slotIndex) enabled() bool {
+	return idx.slots != nil
+}
+
+// build 使用已有的键创建索引
+func (idx *slotIndex) build(keys []string) {
+	idx.slots = make([]map[string]struct{}, SlotNum)
+	idx.cost = slotIndexBasicCost * SlotNum
+	for _, key := range keys {
+		idx.add(key)
+	}
+}
+
+// add 向索引中添加键，索引未创建时不做任何操作
+func (idx *slotIndex) add(key string) {
+	if !idx.enabled() {
+		return
+	}
+	slot := KeySlot(key)
+	if idx.slots[slot] == nil {
+		idx.slots[slot] = make(map[string]struct{})
+	}
+	if _, exist := idx.slots[slot][key]; !exist {
+		idx.slots[slot][key] = struct{}{}
+		idx.cost += int64(len(key))
+	}
+}
+
+// remove 从索引中删除键，索引未创建时不做任何操作
+func (idx *slotIndex) remove(key string) {
+	if !idx.enabled() {
+		return
+	}
+	slot := KeySlot(key)
+	if _, exist := idx.slots[slot][key]; exist {
+		delete(idx.slots[slot], key)
+		idx.cost -= int64(len(key))
+	}
+}
+
+// clear 删除索引中的所有键，已经创建的索引仍然保持可用
+func (idx *slotIndex) clear() {
+	if idx.enabled() {
+		idx.build(nil)
+	}
+}
+
+// count 返回哈希槽中键的数量
+func (idx *slotIndex) count(slot int) int {
+	return len(idx.slots[slot])
+}
+
+// keys 返回哈希槽中最多 count 个键
+func (idx *slotIndex) keys(slot, count int) ([]string, int) {
+	if n := len(idx.slots[slot]); count > n {
+		count = n
+	}
+	keys := make([]string, count)
+	i := 0
+	for key := range idx.slots[slot] {
+		if i == count {
+			break
+		}
+		keys[i] = key
+		i++
+	}
+	return keys, i
+}
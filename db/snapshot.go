@@ -0,0 +1,115 @@
+package db
+
+import (
+	"github.com/tangrc99/MemTable/db/eviction"
+)
+
+// SnapshotWriter 负责序列化快照中的键值对，expireAt 为毫秒级的 unix 时间戳，0 代表没有过期时间
+type SnapshotWriter interface {
+	WriteKey(dbSeq int, key string, value Object, expireAt int64) error
+}
+
+// Snapshot 代表 DataBase 在某一时刻的快照。快照通过 Step 在事件循环中增量地生成，每一次只会序列化一部分键；
+// 在快照完成之前，键被访问或修改前会先将当前值写入快照（写时复制），因此快照与开始时的 DataBase 完全一致，
+// 而快照开始之后创建的键不会出现在快照中。
+type Snapshot struct {
+	db     *DataBase
+	seq    int // 数据库编号
+	writer SnapshotWriter
+	cursor int                 // 遍历 Dict 的游标
+	saved  map[string]struct{} // 已经写入快照的键，以及快照开始之后才创建的键
+	done   bool
+	err    error
+}
+
+// BeginSnapshot 开始为 DataBase 生成快照，dbSeq 为数据库的编号，快照中的键值对会通过 writer 序列化
+func (db_ *DataBase) BeginSnapshot(dbSeq int, writer SnapshotWriter) *Snapshot {
+	snap := &Snapshot{
+		db:     db_,
+		seq:    dbSeq,
+		writer: writer,
+		saved:  make(map[string]struct{}),
+	}
+	db_.snapshots = append(db_.snapshots, snap)
+	return snap
+}
+
+// Step 将最多 count 个尚未写入的键写入快照，返回快照是否已经结束。快照出现错误时同样会结束
+func (snap *Snapshot) Step(count int) bool {
+
+	if snap.done {
+		return true
+	}
+
+	snap.cursor = snap.db.dict.Scan(snap.cursor, count, func(key string, _ Object) {
+		snap.save(key)
+	})
+
+	if snap.cursor == 0 || snap.err != nil {
+		snap.finish()
+	}
+	return snap.done
+}
+
+// Err 返回快照生成过程中出现的错误
+func (snap *Snapshot) Err() error {
+	return snap.err
+}
+
+// Abort 放弃生成快照
+func (snap *Snapshot) Abort() {
+	snap.finish()
+}
+
+// finish 结束快照，之后对键的访问不会再写入快照
+func (snap *Snapshot) finish() {
+
+	if snap.done {
+		return
+	}
+	snap.done = true
+	snap.saved = nil
+
+	snaps := snap.db.snapshots[:0]
+	for _, s := range snap.db.snapshots {
+		if s != snap {
+			snaps = append(snaps, s)
+		}
+	}
+	snap.db.snapshots = snaps
+}
+
+// save 将键的当前值写入快照，每一个键只会被写入一次。键不存在时说明键是在快照开始之后创建的，之后也不会再写入
+func (snap *Snapshot) save(key string) {
+
+	if _, ok := snap.saved[key]; ok || snap.err != nil {
+		return
+	}
+	snap.saved[key] = struct{}{}
+
+	item, ok := snap.db.dict.Get(key)
+	if !ok {
+		return
+	}
+
+	var expireAt int64 = 0
+	if ttl, ok := snap.db.ttlKeys.Get(key); ok {
+		expireAt = int64(ttl.(Int64))
+	}
+
+	snap.err = snap.writer.WriteKey(snap.seq, key, item.(*eviction.Item).Value, expireAt)
+}
+
+// beforeAccess 在访问或修改键之前，将键的当前值写入所有正在生成的快照
+func (db_ *DataBase) beforeAccess(key string) {
+	for _, snap := range db_.snapshots {
+		snap.save(key)
+	}
+}
+
+// beforeClear 在清空 DataBase 之前，完成所有正在生成的快照
+func (db_ *DataBase) beforeClear() {
+	for len(db_.snapshots) > 0 {
+		db_.snapshots[0].Step(db_.dict.Size() + 1)
+	}
+}
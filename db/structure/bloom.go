@@ -1,6 +1,8 @@
 package structure
 
 import (
+	"encoding/binary"
+	"errors"
 	"math"
 	"unsafe"
 )
@@ -145,3 +147,44 @@ func (bl *Bloom) FilterNum() int64 {
 func (bl *Bloom) Items() int {
 	return bl.items
 }
+
+// bloomHeaderSize 是序列化后 Bloom 头部的长度，依次为 ElemNum, sizeExp, size, setLocs, shift, items
+const bloomHeaderSize = 6 * 8
+
+// MarshalBinary 将 Bloom 序列化为字节数组，用于 AOF 重写等持久化场景
+func (bl *Bloom) MarshalBinary() []byte {
+	buf := make([]byte, bloomHeaderSize+len(bl.bitset)*8)
+	binary.LittleEndian.PutUint64(buf[0:], bl.ElemNum)
+	binary.LittleEndian.PutUint64(buf[8:], bl.sizeExp)
+	binary.LittleEndian.PutUint64(buf[16:], bl.size)
+	binary.LittleEndian.PutUint64(buf[24:], bl.setLocs)
+	binary.LittleEndian.PutUint64(buf[32:], bl.shift)
+	binary.LittleEndian.PutUint64(buf[40:], uint64(bl.items))
+	for i, v := range bl.bitset {
+		binary.LittleEndian.PutUint64(buf[bloomHeaderSize+i*8:], v)
+	}
+	return buf
+}
+
+// NewBloomFilterFromBytes 从 MarshalBinary 的结果中恢复 Bloom，如果格式错误将返回 error
+func NewBloomFilterFromBytes(buf []byte) (*Bloom, error) {
+	if len(buf) < bloomHeaderSize || (len(buf)-bloomHeaderSize)%8 != 0 {
+		return nil, errors.New("invalid bloom filter payload")
+	}
+	bl := &Bloom{
+		ElemNum: binary.LittleEndian.Uint64(buf[0:]),
+		sizeExp: binary.LittleEndian.Uint64(buf[8:]),
+		size:    binary.LittleEndian.Uint64(buf[16:]),
+		setLocs: binary.LittleEndian.Uint64(buf[24:]),
+		shift:   binary.LittleEndian.Uint64(buf[32:]),
+		items:   int(binary.LittleEndian.Uint64(buf[40:])),
+		bitset:  make([]uint64, (len(buf)-bloomHeaderSize)/8),
+	}
+	if uint64(len(bl.bitset)) != (bl.size+1)>>6 {
+		return nil, errors.New("invalid bloom filter payload")
+	}
+	for i := range bl.bitset {
+		bl.bitset[i] = binary.LittleEndian.Uint64(buf[bloomHeaderSize+i*8:])
+	}
+	return bl, nil
+}
@@ -36,3 +36,18 @@ func TestBloom(t *testing.T) {
 
 	assert.Equal(t, int64(1064), bloom.Cost())
 }
+
+func TestBloomMarshal(t *testing.T) {
+
+	bloom := NewBloomFilter(1000, 0.03)
+	for _, d := range []uint64{654365346, 54365346, 645234432, 12123432} {
+		bloom.AddIfNotHas(d)
+	}
+
+	restored, err := NewBloomFilterFromBytes(bloom.MarshalBinary())
+	assert.Nil(t, err)
+	assert.Equal(t, bloom, restored)
+
+	_, err = NewBloomFilterFromBytes([]byte("invalid"))
+	assert.NotNil(t, err)
+}
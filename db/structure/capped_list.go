@@ -97,6 +97,22 @@ func (cl *CappedList) Clear() {
 	cl.cost = cappedListBasicCost
 }
 
+// SetMax 修改链表的最大节点数量，节点数量超出上限时只保留最新的节点
+func (cl *CappedList) SetMax(max int) {
+
+	values := cl.GetN(cl.size)
+	if len(values) > max {
+		values = values[len(values)-max:]
+	}
+
+	cl.Clear()
+	cl.max = max
+
+	for _, value := range values {
+		cl.Append(value)
+	}
+}
+
 func (cl *CappedList) Cost() int64 {
 	return cl.cost
 }
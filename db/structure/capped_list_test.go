@@ -37,6 +37,24 @@ func TestCappedList(t *testing.T) {
 	assert.Equal(t, []Object{Slice("2"), Slice("3"), Slice("4")}, objs)
 }
 
+func TestCappedListSetMax(t *testing.T) {
+
+	cl := NewCappedList(3)
+	for _, v := range []string{"1", "2", "3", "4"} {
+		cl.Append(Slice(v))
+	}
+
+	cl.SetMax(2)
+	assert.Equal(t, 2, cl.Size())
+	assert.Equal(t, []Object{Slice("3"), Slice("4")}, cl.GetN(3))
+
+	cl.SetMax(3)
+	cl.Append(Slice("5"))
+	cl.Append(Slice("6"))
+	assert.Equal(t, []Object{Slice("4"), Slice("5"), Slice("6")}, cl.GetN(3))
+	assert.Equal(t, cappedListBasicCost+3*(cappedListNodeBasicCost+Slice("6").Cost()), cl.Cost())
+}
+
 func TestCappedListCost(t *testing.T) {
 
 	cl := NewCappedList(3)
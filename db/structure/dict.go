@@ -5,6 +5,7 @@ import (
 	"github.com/tangrc99/MemTable/logger"
 	"github.com/tangrc99/MemTable/server/global"
 	"github.com/tangrc99/MemTable/utils"
+	"math/bits"
 	"regexp"
 	"unsafe"
 )
@@ -13,42 +14,152 @@ const MaxConSize = int(1<<31 - 1)
 const shardBasicCost = int64(unsafe.Sizeof(Shard{}))
 const dictBasicCost = int64(unsafe.Sizeof(Dict{}))
 
+// dictShardLoad 是每个分片中键值对的平均数量上限，超过后分片数量会扩大一倍
+const dictShardLoad = 8
+
+// dictMaxShards 是分片数量的上限
+const dictMaxShards = 1 << 30
+
+// dictRehashEmptyVisits 是一次 rehash 最多跳过的空分片数量，防止单次操作耗时过长
+const dictRehashEmptyVisits = 10
+
 // Shard 是 Dict 中的一个分片
 type Shard = map[string]Object
 
-// Dict 包含了不同的分片，每一个分片包含一个哈希表
+// Dict 包含了不同的分片，每一个分片包含一个哈希表。分片数量为 2 的幂，键值对数量增长时分片数量会扩大一倍，
+// 与 redis 的字典相同，扩容时使用渐进式 rehash，每一次写入操作只会迁移一个旧分片，避免一次性迁移全部键值对。
 type Dict struct {
-	shards []Shard // 存储键值对
-	size   int     // table 分区数量
-	count  int     // 键值对数量
-	cost   int64   // 消耗的内存
+	shards    []Shard // 存储键值对，rehash 期间为扩容后的新分片，分片在第一次写入时创建
+	old       []Shard // rehash 期间的旧分片，nil 代表没有进行 rehash
+	rehashIdx int     // 下一个需要迁移的旧分片，序号小于该值的旧分片已经迁移完毕
+	size      int     // 初始分片数量
+	count     int     // 键值对数量
+	cost      int64   // 消耗的内存
 }
 
-// NewDict 创建指定分片数量的 Dict 并返回指针
+// NewDict 创建指定初始分片数量的 Dict 并返回指针，分片数量会被向上取整为 2 的幂
 func NewDict(size int) *Dict {
-	if size <= 0 || size > MaxConSize {
-		size = MaxConSize
+	if size <= 0 || size > dictMaxShards {
+		size = dictMaxShards
 	}
-	dict := Dict{
-		shards: make([]Shard, size),
-		size:   size,
+	n := 1
+	for n < size && n < dictMaxShards {
+		n <<= 1
+	}
+	return &Dict{
+		shards: make([]Shard, n),
+		size:   n,
 		count:  0,
-		cost:   dictBasicCost + shardBasicCost*int64(size),
+		cost:   dictBasicCost + shardBasicCost*int64(n),
 	}
-	for i := 0; i < size; i++ {
-		dict.shards[i] = make(map[string]Object)
+}
+
+// hash 计算键的哈希值，分片的序号为哈希值的低位
+func hash(key string) uint64 {
+	return utils.MemHashString(key)
+}
+
+// locate 返回键所在的分片表以及分片序号，rehash 期间尚未迁移的旧分片中的键仍然位于旧分片中
+func (dict *Dict) locate(key string) ([]Shard, int) {
+	h := hash(key)
+	if dict.old != nil {
+		if i := int(h & uint64(len(dict.old)-1)); i >= dict.rehashIdx {
+			return dict.old, i
+		}
 	}
-	return &dict
+	return dict.shards, int(h & uint64(len(dict.shards)-1))
 }
 
-// countShard 返回键值对应的 *Shard
+// countShard 返回键值对应的 Shard，分片不存在时返回 nil，只能用于读取
 func (dict *Dict) countShard(key string) Shard {
-	pos := utils.HashKey(key) % dict.size
-	return dict.shards[pos]
+	shards, i := dict.locate(key)
+	return shards[i]
+}
+
+// writableShard 返回键值对应的 Shard，分片不存在时会创建分片
+func (dict *Dict) writableShard(key string) Shard {
+	shards, i := dict.locate(key)
+	if shards[i] == nil {
+		shards[i] = make(Shard)
+	}
+	return shards[i]
 }
 
+// ShardNum 返回当前的分片数量，rehash 期间返回新分片的数量
 func (dict *Dict) ShardNum() int {
-	return dict.size
+	return len(dict.shards)
+}
+
+// Rehashing 判断 Dict 是否正在进行 rehash
+func (dict *Dict) Rehashing() bool {
+	return dict.old != nil
+}
+
+// expandIfNeeded 在平均每个分片的键值对数量超过 dictShardLoad 时开始扩容
+func (dict *Dict) expandIfNeeded() {
+	if dict.old != nil || dict.count <= len(dict.shards)*dictShardLoad || len(dict.shards) >= dictMaxShards {
+		return
+	}
+	dict.old = dict.shards
+	dict.shards = make([]Shard, 2*len(dict.old))
+	dict.rehashIdx = 0
+	dict.cost += shardBasicCost * int64(len(dict.shards))
+}
+
+// Rehash 迁移最多 steps 个非空的旧分片，返回 rehash 是否仍在进行
+func (dict *Dict) Rehash(steps int) bool {
+	for i := 0; i < steps && dict.old != nil; i++ {
+		dict.rehashStep()
+	}
+	return dict.old != nil
+}
+
+// rehashStep 迁移一个非空的旧分片，最多跳过 dictRehashEmptyVisits 个空分片
+func (dict *Dict) rehashStep() {
+
+	if dict.old == nil {
+		return
+	}
+
+	mask := uint64(len(dict.shards) - 1)
+	for empty := 0; dict.rehashIdx < len(dict.old); {
+
+		shard := dict.old[dict.rehashIdx]
+		dict.old[dict.rehashIdx] = nil
+		dict.rehashIdx++
+
+		if len(shard) == 0 {
+			if empty++; empty >= dictRehashEmptyVisits {
+				break
+			}
+			continue
+		}
+
+		for key, value := range shard {
+			i := hash(key) & mask
+			if dict.shards[i] == nil {
+				dict.shards[i] = make(Shard)
+			}
+			dict.shards[i][key] = value
+		}
+		break
+	}
+
+	if dict.rehashIdx >= len(dict.old) {
+		dict.cost -= shardBasicCost * int64(len(dict.old))
+		dict.old = nil
+		dict.rehashIdx = 0
+	}
+}
+
+// allShards 返回所有可能包含键值对的分片，rehash 期间包括尚未迁移的旧分片
+func (dict *Dict) allShards() []Shard {
+	if dict.old == nil {
+		return dict.shards
+	}
+	shards := make([]Shard, 0, len(dict.old)-dict.rehashIdx+len(dict.shards))
+	shards = append(shards, dict.old[dict.rehashIdx:]...)
+	return append(shards, dict.shards...)
 }
 
 // Get 从 Dict 中查找键值对并返回值，如果不存在将会返回 nil
@@ -62,7 +173,8 @@ func (dict *Dict) Get(key string) (Object, bool) {
 // Set 将键值对插入 Dict 对象中，该操作会覆盖原有键值对
 func (dict *Dict) Set(key string, value Object) bool {
 
-	shard := dict.countShard(key)
+	dict.rehashStep()
+	shard := dict.writableShard(key)
 
 	if v, exist := shard[key]; !exist {
 		dict.count++
@@ -72,13 +184,15 @@ func (dict *Dict) Set(key string, value Object) bool {
 
 	shard[key] = value
 	dict.cost += value.Cost() + int64(len(key))
+	dict.expandIfNeeded()
 	return true
 }
 
 // SetIfNotExist 将键值对插入 Dict 对象中，若键值对已存在将会返回 false
 func (dict *Dict) SetIfNotExist(key string, value Object) bool {
 
-	shard := dict.countShard(key)
+	dict.rehashStep()
+	shard := dict.writableShard(key)
 
 	if _, exist := shard[key]; exist {
 		return false
@@ -87,6 +201,7 @@ func (dict *Dict) SetIfNotExist(key string, value Object) bool {
 	shard[key] = value
 	dict.count++
 	dict.cost += value.Cost() + int64(len(key))
+	dict.expandIfNeeded()
 
 	return true
 }
@@ -113,6 +228,7 @@ func (dict *Dict) Update(key string, value Object) bool {
 
 // Delete 删除指定键值对，成功删除返回 true，无元素返回 false
 func (dict *Dict) Delete(key string) bool {
+	dict.rehashStep()
 	shard := dict.countShard(key)
 
 	if v, exist := shard[key]; exist {
@@ -127,6 +243,7 @@ func (dict *Dict) Delete(key string) bool {
 
 // DeleteGet 删除键值对并返回删除前的值，若键值对不存在则返回 nil
 func (dict *Dict) DeleteGet(key string) Object {
+	dict.rehashStep()
 	shard := dict.countShard(key)
 
 	if value, exist := shard[key]; exist {
@@ -150,17 +267,16 @@ func (dict *Dict) Empty() bool {
 	return dict.count == 0
 }
 
-// Clear 删除 Dict 中的所有键值对
+// Clear 删除 Dict 中的所有键值对，分片数量恢复为初始值
 func (dict *Dict) Clear() {
 	*dict = *NewDict(dict.size)
-	dict.cost = dictBasicCost + shardBasicCost*int64(dict.size)
 }
 
 // Keys 返回匹配正则表达式全部键以及数量
 func (dict *Dict) Keys(pattern string) ([]string, int) {
 	keys := make([]string, dict.count)
 	i := 0
-	for _, shard := range dict.shards {
+	for _, shard := range dict.allShards() {
 		for key := range shard {
 
 			ok := true
@@ -186,7 +302,7 @@ func (dict *Dict) Keys(pattern string) ([]string, int) {
 func (dict *Dict) KeysByte(pattern string) ([][]byte, int) {
 	keys := make([][]byte, dict.count)
 	i := 0
-	for _, shard := range dict.shards {
+	for _, shard := range dict.allShards() {
 		for key := range shard {
 
 			ok := true
@@ -208,40 +324,34 @@ func (dict *Dict) KeysByte(pattern string) ([][]byte, int) {
 	return keys, i
 }
 
-// KeysWithTTL 返回全部未过期键，ttl 为记录过期时间的字典
+// KeysWithTTL 返回全部未过期键，ttl 为记录过期时间的字典。已经过期的键会被跳过，由调用者负责删除
 func (dict *Dict) KeysWithTTL(ttl *Dict, pattern string) ([]string, int) {
 
-	now := global.Now.Unix()
+	now := global.Now.UnixMilli()
 
 	keys := make([]string, 0, dict.count)
 	i := 0
-	for _, shard := range dict.shards {
+	for _, shard := range dict.allShards() {
 
 		for key := range shard {
 
-			tp, exist := ttl.Get(key)
-			if exist && tp.(Int64).Value() < now {
-				// 如果过期需要删除
-				v, _ := shard[key]
-				dict.cost -= v.Cost() + int64(len(key))
-				delete(shard, key)
-				ttl.Delete(key)
-			} else {
-
-				ok := true
-				var err error
-				if pattern != "" {
-					ok, err = regexp.MatchString(pattern, key)
-					if err != nil {
-						logger.Error(err)
-						continue
-					}
-				}
-				if ok {
-					keys = append(keys, key)
-					i++
+			if tp, exist := ttl.Get(key); exist && tp.(Int64).Value() < now {
+				continue
+			}
+
+			ok := true
+			var err error
+			if pattern != "" {
+				ok, err = regexp.MatchString(pattern, key)
+				if err != nil {
+					logger.Error(err)
+					continue
 				}
 			}
+			if ok {
+				keys = append(keys, key)
+				i++
+			}
 		}
 	}
 
@@ -249,40 +359,34 @@ func (dict *Dict) KeysWithTTL(ttl *Dict, pattern string) ([]string, int) {
 
 }
 
-// KeysWithTTLByte 返回全部未过期键，ttl 为记录过期时间的字典，键值以[]byte形式返回
+// KeysWithTTLByte 返回全部未过期键，ttl 为记录过期时间的字典，键值以[]byte形式返回。已经过期的键会被跳过，由调用者负责删除
 func (dict *Dict) KeysWithTTLByte(ttl *Dict, pattern string) ([][]byte, int) {
 
-	now := global.Now.Unix()
+	now := global.Now.UnixMilli()
 
 	keys := make([][]byte, dict.count)
 	i := 0
-	for _, shard := range dict.shards {
+	for _, shard := range dict.allShards() {
 
 		for key := range shard {
 
-			tp, exist := ttl.Get(key)
-			if exist && tp.(Int64).Value() < now {
-				// 如果过期需要删除
-				v, _ := shard[key]
-				dict.cost -= v.Cost() + int64(len(key))
-				delete(shard, key)
-				ttl.Delete(key)
-			} else {
-
-				ok := true
-				var err error
-				if pattern != "" {
-					ok, err = regexp.MatchString(pattern, key)
-					if err != nil {
-						logger.Error(err)
-						continue
-					}
-				}
-				if ok {
-					keys[i] = []byte(key)
-					i++
+			if tp, exist := ttl.Get(key); exist && tp.(Int64).Value() < now {
+				continue
+			}
+
+			ok := true
+			var err error
+			if pattern != "" {
+				ok, err = regexp.MatchString(pattern, key)
+				if err != nil {
+					logger.Error(err)
+					continue
 				}
 			}
+			if ok {
+				keys[i] = []byte(key)
+				i++
+			}
 		}
 	}
 
@@ -302,10 +406,11 @@ func (dict *Dict) Exist(key string) bool {
 func (dict *Dict) Random(num int) map[string]Object {
 
 	selected := make(map[string]Object)
+	shards := dict.allShards()
 
 	// 这里优化为直接遍历
 	if num >= dict.count {
-		for _, shard := range dict.shards {
+		for _, shard := range shards {
 			for key, value := range shard {
 				selected[key] = value
 			}
@@ -315,8 +420,8 @@ func (dict *Dict) Random(num int) map[string]Object {
 
 	// TODO: 概率不均衡
 	for len(selected) < num {
-		for i := 0; i < dict.size && len(selected) < num; i++ {
-			for k, v := range dict.shards[i] {
+		for i := 0; i < len(shards) && len(selected) < num; i++ {
+			for k, v := range shards[i] {
 				if len(selected) == num {
 					break
 				}
@@ -331,10 +436,11 @@ func (dict *Dict) Random(num int) map[string]Object {
 // RandomKeys 随机返回 Dict 中指定数量的键，不返回值
 func (dict *Dict) RandomKeys(num int) map[string]struct{} {
 	selected := make(map[string]struct{})
+	shards := dict.allShards()
 
 	// 这里优化为直接遍历
 	if num >= dict.count {
-		for _, shard := range dict.shards {
+		for _, shard := range shards {
 			for key := range shard {
 				selected[key] = struct{}{}
 			}
@@ -345,8 +451,8 @@ func (dict *Dict) RandomKeys(num int) map[string]struct{} {
 	// TODO: 概率不均衡
 
 	for len(selected) < num {
-		for i := 0; i < dict.size && len(selected) < num; i++ {
-			for k := range dict.shards[i] {
+		for i := 0; i < len(shards) && len(selected) < num; i++ {
+			for k := range shards[i] {
 				if len(selected) == num {
 					break
 				}
@@ -358,17 +464,79 @@ func (dict *Dict) RandomKeys(num int) map[string]struct{} {
 	return selected
 }
 
+// nextCursor 使用与 redis 相同的反向二进制递增计算下一个游标，mask 为分片数量减一。
+// 扩容后，旧游标之前的分片在新分片表中对应的分片仍然位于新游标之前，因此不会遗漏键值对。
+func nextCursor(v, mask uint64) uint64 {
+	v |= ^mask
+	v = bits.Reverse64(v)
+	v++
+	return bits.Reverse64(v)
+}
+
+// Scan 从游标 cursor 对应的分片开始遍历 Dict，每一次调用至少会遍历一个分片，直到遍历的键值对数量达到 count，
+// 或者遍历的分片数量达到 count 的十倍。fn 会在每一个遍历到的键值对上调用，fn 中不能修改 Dict。
+// 返回值为下一次遍历的游标，返回 0 代表遍历结束。游标采用反向二进制递增，在整个遍历期间一直存在的键值对
+// 即使发生了扩容也至少会被返回一次。
+func (dict *Dict) Scan(cursor, count int, fn func(key string, value Object)) int {
+
+	if cursor < 0 || cursor >= len(dict.shards) {
+		return 0
+	}
+	if count < 1 {
+		count = 1
+	}
+
+	visit := func(shard Shard) int {
+		for key, value := range shard {
+			fn(key, value)
+		}
+		return len(shard)
+	}
+
+	v := uint64(cursor)
+	visited := 0
+	for steps := 0; ; steps++ {
+
+		if dict.old == nil {
+			mask := uint64(len(dict.shards) - 1)
+			visited += visit(dict.shards[v&mask])
+			v = nextCursor(v, mask)
+		} else {
+			// 先遍历旧分片，再遍历旧分片扩展后在新分片表中对应的所有分片
+			m0, m1 := uint64(len(dict.old)-1), uint64(len(dict.shards)-1)
+			visited += visit(dict.old[v&m0])
+			for {
+				visited += visit(dict.shards[v&m1])
+				v = nextCursor(v, m1)
+				if v&(m0^m1) == 0 {
+					break
+				}
+			}
+		}
+
+		if v == 0 || visited >= count || steps >= count*10 {
+			break
+		}
+	}
+
+	return int(v)
+}
+
+// GetAll 返回存储键值对的全部哈希表以及键值对数量，rehash 期间包括尚未迁移的旧分片
 func (dict *Dict) GetAll() ([]map[string]Object, int) {
-	return dict.shards, dict.count
+	return dict.allShards(), dict.count
 }
 
-// ShardCount 返回指定分片中的键值对数量
+// ShardCount 返回指定分片中的键值对数量，rehash 期间序号对应新分片表
 func (dict *Dict) ShardCount(shardSeq int) int {
 	return len(dict.shards[shardSeq])
 }
 
-// KeysInShard 返回指定分片中的键值对
+// KeysInShard 返回指定分片中的键值对，rehash 期间序号对应新分片表
 func (dict *Dict) KeysInShard(shardSeq, count int) ([]string, int) {
+	if n := len(dict.shards[shardSeq]); count > n {
+		count = n
+	}
 	keys := make([]string, count)
 	i := 0
 	for key := range dict.shards[shardSeq] {
@@ -3,6 +3,7 @@ package structure
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
 	"testing"
 )
 
@@ -56,38 +57,38 @@ func TestDict(t *testing.T) {
 func TestDictCost(t *testing.T) {
 	dict := NewDict(1)
 
-	assert.Equal(t, int64(56), dict.Cost())
+	assert.Equal(t, int64(88), dict.Cost())
 
 	dict.Set("12345", Slice("12345"))
-	assert.Equal(t, int64(66), dict.Cost())
+	assert.Equal(t, int64(98), dict.Cost())
 
 	dict.SetIfExist("12345", Slice("1234567890"))
-	assert.Equal(t, int64(71), dict.Cost())
+	assert.Equal(t, int64(103), dict.Cost())
 
 	dict.SetIfNotExist("12345", Slice("1234567890"))
-	assert.Equal(t, int64(71), dict.Cost())
+	assert.Equal(t, int64(103), dict.Cost())
 
 	dict.Delete("12345")
-	assert.Equal(t, int64(56), dict.Cost())
+	assert.Equal(t, int64(88), dict.Cost())
 }
 
 func TestDictCostWithType(t *testing.T) {
 	dict := NewDict(1)
 
-	assert.Equal(t, int64(56), dict.Cost())
+	assert.Equal(t, int64(88), dict.Cost())
 
 	dict.Set("12345", Slice("12345"))
-	assert.Equal(t, int64(66), dict.Cost())
+	assert.Equal(t, int64(98), dict.Cost())
 	list := NewList()
 	dict.Set("list", list)
-	assert.Equal(t, int64(66+4+list.Cost()), dict.Cost())
+	assert.Equal(t, int64(98+4+list.Cost()), dict.Cost())
 
 	hash := NewDict(1)
 	dict.Set("hash", hash)
-	assert.Equal(t, int64(74+56+list.Cost()), dict.Cost())
+	assert.Equal(t, int64(106+88+list.Cost()), dict.Cost())
 
 	dict.Clear()
-	assert.Equal(t, int64(56), dict.Cost())
+	assert.Equal(t, int64(88), dict.Cost())
 
 }
 
@@ -102,7 +103,7 @@ func TestDictTTL(t *testing.T) {
 	ttl.Set("k1", Int64(0))
 
 	dict.Set("k2", Int64(2))
-	ttl.Set("k2", Int64(global.Now.Unix()+10))
+	ttl.Set("k2", Int64(global.Now.UnixMilli()+10000))
 
 	dict.Set("k3", Int64(3))
 
@@ -143,3 +144,111 @@ func TestDictRandom(t *testing.T) {
 	}, []map[string]Object{dict.Random(100)})
 
 }
+
+func TestDictScan(t *testing.T) {
+
+	dict := NewDict(16)
+	for i := 0; i < 100; i++ {
+		dict.Set(strconv.Itoa(i), Int64(i))
+	}
+
+	visited := make(map[string]int)
+	cursor, calls := 0, 0
+	for {
+		cursor = dict.Scan(cursor, 10, func(key string, value Object) {
+			visited[key]++
+		})
+		calls++
+		if cursor == 0 {
+			break
+		}
+	}
+
+	assert.Equal(t, 100, len(visited))
+	for _, n := range visited {
+		assert.Equal(t, 1, n)
+	}
+	assert.Greater(t, calls, 1)
+
+	assert.Equal(t, 0, dict.Scan(16, 10, func(key string, value Object) {
+		t.Fail()
+	}))
+}
+
+func TestDictGrow(t *testing.T) {
+
+	dict := NewDict(1)
+	for i := 0; i < 1000; i++ {
+		dict.Set(strconv.Itoa(i), Int64(i))
+	}
+	assert.Greater(t, dict.ShardNum(), 1)
+
+	// 扩容或 rehash 期间所有键值对都可以被找到
+	for i := 0; i < 1000; i++ {
+		v, ok := dict.Get(strconv.Itoa(i))
+		assert.True(t, ok)
+		assert.Equal(t, Int64(i), v)
+	}
+	keys, n := dict.Keys("")
+	assert.Equal(t, 1000, n)
+	assert.Equal(t, 1000, len(keys))
+
+	for dict.Rehash(100) {
+	}
+	assert.False(t, dict.Rehashing())
+
+	for i := 0; i < 1000; i++ {
+		assert.True(t, dict.Delete(strconv.Itoa(i)))
+	}
+	assert.True(t, dict.Empty())
+	assert.Equal(t, dictBasicCost+shardBasicCost*int64(dict.ShardNum()), dict.Cost())
+
+	dict.Clear()
+	assert.Equal(t, 1, dict.ShardNum())
+}
+
+func TestDictScanWhileGrowing(t *testing.T) {
+
+	dict := NewDict(1)
+	for i := 0; i < 100; i++ {
+		dict.Set(strconv.Itoa(i), Int64(i))
+	}
+
+	// 遍历期间持续插入新键值对，触发扩容与 rehash，原有的键值对至少会被返回一次
+	visited := make(map[string]int)
+	cursor, next := 0, 100
+	for {
+		cursor = dict.Scan(cursor, 5, func(key string, value Object) {
+			visited[key]++
+		})
+		for i := 0; i < 20; i++ {
+			dict.Set(strconv.Itoa(next), Int64(next))
+			next++
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		assert.GreaterOrEqual(t, visited[strconv.Itoa(i)], 1, i)
+	}
+}
+
+func TestDictScanCount(t *testing.T) {
+
+	dict := NewDict(1)
+	for i := 0; i < 10000; i++ {
+		dict.Set(strconv.Itoa(i), Int64(i))
+	}
+	for dict.Rehash(100) {
+	}
+
+	// 每一次遍历的键值对数量与 count 相近，不会一次性返回整个 Dict
+	visited := 0
+	cursor := dict.Scan(0, 10, func(key string, value Object) {
+		visited++
+	})
+	assert.NotEqual(t, 0, cursor)
+	assert.Less(t, visited, 100)
+}
@@ -0,0 +1,232 @@
+package structure
+
+import (
+	"math"
+)
+
+// 地理位置以 52 bit 的 geohash 作为权重存储在 ZSet 中，编码方式与 redis 一致。
+// 经度与纬度各使用 26 bit，纬度的范围受到 EPSG:3857 的限制
+const (
+	GeoStepMax = 26
+
+	GeoLatMin  = -85.05112878
+	GeoLatMax  = 85.05112878
+	GeoLongMin = -180.0
+	GeoLongMax = 180.0
+
+	geoEarthRadius = 6372797.560856
+	geoMercatorMax = 20037726.37
+)
+
+const geoAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// GeoHashBits 是指定精度下的 geohash 值
+type GeoHashBits struct {
+	Bits uint64
+	Step uint
+}
+
+// GeoValid 判断经纬度是否在可以编码的范围内
+func GeoValid(longitude, latitude float64) bool {
+	return longitude >= GeoLongMin && longitude <= GeoLongMax && latitude >= GeoLatMin && latitude <= GeoLatMax
+}
+
+// interleave64 将 x 的各个 bit 放在偶数位，y 的各个 bit 放在奇数位
+func interleave64(x, y uint32) uint64 {
+	spread := func(v uint64) uint64 {
+		v = (v | v<<16) & 0x0000FFFF0000FFFF
+		v = (v | v<<8) & 0x00FF00FF00FF00FF
+		v = (v | v<<4) & 0x0F0F0F0F0F0F0F0F
+		v = (v | v<<2) & 0x3333333333333333
+		v = (v | v<<1) & 0x5555555555555555
+		return v
+	}
+	return spread(uint64(x)) | spread(uint64(y))<<1
+}
+
+// deinterleave64 是 interleave64 的逆操作
+func deinterleave64(v uint64) (uint32, uint32) {
+	squash := func(v uint64) uint32 {
+		v &= 0x5555555555555555
+		v = (v | v>>1) & 0x3333333333333333
+		v = (v | v>>2) & 0x0F0F0F0F0F0F0F0F
+		v = (v | v>>4) & 0x00FF00FF00FF00FF
+		v = (v | v>>8) & 0x0000FFFF0000FFFF
+		v = (v | v>>16) & 0x00000000FFFFFFFF
+		return uint32(v)
+	}
+	return squash(v), squash(v >> 1)
+}
+
+func geoEncode(longitude, latitude, longMin, longMax, latMin, latMax float64, step uint) GeoHashBits {
+	latOffset := (latitude - latMin) / (latMax - latMin) * float64(uint64(1)<<step)
+	longOffset := (longitude - longMin) / (longMax - longMin) * float64(uint64(1)<<step)
+	return GeoHashBits{Bits: interleave64(uint32(latOffset), uint32(longOffset)), Step: step}
+}
+
+// GeoEncode 使用指定精度对经纬度进行编码
+func GeoEncode(longitude, latitude float64, step uint) GeoHashBits {
+	return geoEncode(longitude, latitude, GeoLongMin, GeoLongMax, GeoLatMin, GeoLatMax, step)
+}
+
+// GeoScore 返回经纬度在 ZSet 中的权重
+func GeoScore(longitude, latitude float64) Float64 {
+	return Float64(GeoEncode(longitude, latitude, GeoStepMax).Bits)
+}
+
+// area 返回 geohash 所代表区域的经纬度范围
+func (hash GeoHashBits) area() (longMin, longMax, latMin, latMax float64) {
+	latIndex, longIndex := deinterleave64(hash.Bits)
+	scale := float64(uint64(1) << hash.Step)
+	latMin = GeoLatMin + float64(latIndex)/scale*(GeoLatMax-GeoLatMin)
+	latMax = GeoLatMin + float64(latIndex+1)/scale*(GeoLatMax-GeoLatMin)
+	longMin = GeoLongMin + float64(longIndex)/scale*(GeoLongMax-GeoLongMin)
+	longMax = GeoLongMin + float64(longIndex+1)/scale*(GeoLongMax-GeoLongMin)
+	return
+}
+
+// GeoDecode 将 ZSet 中的权重解码为经纬度，返回值为 geohash 区域的中心点
+func GeoDecode(score Float64) (longitude, latitude float64) {
+	longMin, longMax, latMin, latMax := GeoHashBits{Bits: uint64(score), Step: GeoStepMax}.area()
+	longitude = math.Max(GeoLongMin, math.Min(GeoLongMax, (longMin+longMax)/2))
+	latitude = math.Max(GeoLatMin, math.Min(GeoLatMax, (latMin+latMax)/2))
+	return
+}
+
+// GeoHashString 返回 11 位的标准 geohash 字符串，标准 geohash 的纬度范围为 [-90, 90]
+func GeoHashString(score Float64) string {
+	longitude, latitude := GeoDecode(score)
+	bits := geoEncode(longitude, latitude, -180, 180, -90, 90, GeoStepMax).Bits
+
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := uint64(0)
+		// 只有 52 bit，最后一位补 0
+		if i < 10 {
+			idx = (bits >> (52 - (i+1)*5)) & 0x1f
+		}
+		buf[i] = geoAlphabet[idx]
+	}
+	return string(buf)
+}
+
+func degRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// GeoDistance 使用 haversine 公式计算两点之间的距离，单位为米
+func GeoDistance(long1, lat1, long2, lat2 float64) float64 {
+	lat1r, lat2r := degRad(lat1), degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin(degRad(long2-long1) / 2)
+	return 2 * geoEarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// GeoShape 是 GEOSEARCH 的搜索范围，Width 与 Height 为 0 时代表按照 Radius 搜索，长度单位均为米
+type GeoShape struct {
+	Longitude float64
+	Latitude  float64
+	Radius    float64
+	Width     float64
+	Height    float64
+}
+
+// Contains 判断点是否位于搜索范围内，并返回该点到中心的距离
+func (shape *GeoShape) Contains(longitude, latitude float64) (float64, bool) {
+
+	if shape.Width == 0 && shape.Height == 0 {
+		distance := GeoDistance(shape.Longitude, shape.Latitude, longitude, latitude)
+		return distance, distance <= shape.Radius
+	}
+
+	// 矩形范围需要分别判断纬度方向与经度方向的距离
+	if geoEarthRadius*math.Abs(degRad(latitude)-degRad(shape.Latitude)) > shape.Height/2 {
+		return 0, false
+	}
+	if GeoDistance(longitude, latitude, shape.Longitude, latitude) > shape.Width/2 {
+		return 0, false
+	}
+
+	return GeoDistance(shape.Longitude, shape.Latitude, longitude, latitude), true
+}
+
+// boundingRadius 返回能够覆盖搜索范围的最小半径
+func (shape *GeoShape) boundingRadius() float64 {
+	if shape.Width == 0 && shape.Height == 0 {
+		return shape.Radius
+	}
+	return math.Sqrt(shape.Width*shape.Width/4 + shape.Height*shape.Height/4)
+}
+
+// estimateStep 估计能够使中心区域与 8 个相邻区域覆盖搜索范围的 geohash 精度
+func (shape *GeoShape) estimateStep() uint {
+
+	radius := shape.boundingRadius()
+	if radius == 0 {
+		return GeoStepMax
+	}
+
+	step := 1
+	for r := radius; r < geoMercatorMax; r *= 2 {
+		step++
+	}
+	step -= 2
+	if shape.Latitude > 66 || shape.Latitude < -66 {
+		step--
+		if shape.Latitude > 80 || shape.Latitude < -80 {
+			step--
+		}
+	}
+
+	// 纬度越高，区域在经度方向上越窄，需要保证区域的宽高均不小于搜索半径
+	farthest := math.Min(90, math.Abs(shape.Latitude)+radius/geoEarthRadius*180/math.Pi)
+	for ; step > 1; step-- {
+		scale := float64(uint64(1) << step)
+		height := degRad((GeoLatMax-GeoLatMin)/scale) * geoEarthRadius
+		width := degRad((GeoLongMax-GeoLongMin)/scale) * geoEarthRadius * math.Cos(degRad(farthest))
+		if height >= radius && width >= radius {
+			break
+		}
+	}
+
+	if step < 1 {
+		step = 1
+	}
+	if step > GeoStepMax {
+		step = GeoStepMax
+	}
+	return uint(step)
+}
+
+// ScoreRanges 返回可能包含搜索范围内所有点的权重区间，每个区间为 [min, max)
+func (shape *GeoShape) ScoreRanges() [][2]Float64 {
+
+	step := shape.estimateStep()
+	center := GeoEncode(shape.Longitude, shape.Latitude, step)
+	latIndex, longIndex := deinterleave64(center.Bits)
+	cells := uint64(1) << step
+
+	ranges := make([][2]Float64, 0, 9)
+	seen := make(map[uint64]struct{}, 9)
+
+	for dLat := -1; dLat <= 1; dLat++ {
+		lat := int64(latIndex) + int64(dLat)
+		if lat < 0 || uint64(lat) >= cells {
+			continue
+		}
+		for dLong := -1; dLong <= 1; dLong++ {
+			// 经度方向需要环绕
+			long := (int64(longIndex) + int64(dLong) + int64(cells)) % int64(cells)
+			bits := interleave64(uint32(lat), uint32(long))
+			if _, ok := seen[bits]; ok {
+				continue
+			}
+			seen[bits] = struct{}{}
+
+			shift := 2 * (GeoStepMax - step)
+			ranges = append(ranges, [2]Float64{Float64(bits << shift), Float64((bits + 1) << shift)})
+		}
+	}
+
+	return ranges
+}
@@ -0,0 +1,70 @@
+package structure
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestGeoHash(t *testing.T) {
+
+	for _, v := range [][2]uint32{{0, 0}, {1, 2}, {0x3ffffff, 0}, {12345, 0x3ffffff}} {
+		x, y := deinterleave64(interleave64(v[0], v[1]))
+		assert.Equal(t, v[0], x)
+		assert.Equal(t, v[1], y)
+	}
+
+	// 与 redis 的编码结果一致
+	score := GeoScore(13.361389, 38.115556)
+	assert.Equal(t, Float64(3479099956230698), score)
+	assert.Equal(t, "sqc8b49rny0", GeoHashString(score))
+
+	longitude, latitude := GeoDecode(score)
+	assert.InDelta(t, 13.361389, longitude, 1e-5)
+	assert.InDelta(t, 38.115556, latitude, 1e-5)
+
+	assert.True(t, GeoValid(180, 85.05112878))
+	assert.False(t, GeoValid(180.1, 0))
+	assert.False(t, GeoValid(0, 86))
+}
+
+func TestGeoShape(t *testing.T) {
+
+	palermo := [2]float64{13.361389, 38.115556}
+	catania := [2]float64{15.087269, 37.502669}
+
+	assert.InDelta(t, 166274.1516, GeoDistance(palermo[0], palermo[1], catania[0], catania[1]), 1)
+
+	tests := []struct {
+		shape  GeoShape
+		point  [2]float64
+		inside bool
+	}{
+		{GeoShape{Longitude: 15, Latitude: 37, Radius: 200000}, palermo, true},
+		{GeoShape{Longitude: 15, Latitude: 37, Radius: 100000}, palermo, false},
+		{GeoShape{Longitude: 15, Latitude: 37, Width: 400000, Height: 400000}, palermo, true},
+		{GeoShape{Longitude: 15, Latitude: 37, Width: 200000, Height: 400000}, palermo, false},
+		{GeoShape{Longitude: 179.9, Latitude: 0, Radius: 50000}, [2]float64{-179.9, 0}, true},
+	}
+
+	for _, test := range tests {
+		_, inside := test.shape.Contains(test.point[0], test.point[1])
+		assert.Equal(t, test.inside, inside)
+
+		// 范围内的点一定位于候选的权重区间中
+		if inside {
+			score := GeoScore(test.point[0], test.point[1])
+			found := false
+			for _, r := range test.shape.ScoreRanges() {
+				found = found || (score >= r[0] && score < r[1])
+			}
+			assert.True(t, found)
+		}
+	}
+
+	// 半径为 0 时使用最高精度
+	shape := GeoShape{Longitude: 0, Latitude: 0}
+	assert.Equal(t, uint(GeoStepMax), shape.estimateStep())
+	// 半径过大时使用最低精度，4 个区域覆盖全球
+	assert.Len(t, (&GeoShape{Longitude: 0, Latitude: 0, Radius: math.MaxFloat32}).ScoreRanges(), 4)
+}
@@ -0,0 +1,518 @@
+package structure
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/tangrc99/MemTable/utils"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLog 的内存布局与 redis 完全一致，以字符串的形式存储在数据库中，因此可以直接通过 rdb 的字符串编码与 redis 互通。
+// 头部共 16 字节：4 字节魔数 "HYLL"，1 字节编码方式，3 字节保留，8 字节小端序的基数缓存，缓存最高位为 1 代表缓存失效。
+// dense 编码使用 16384 个 6 bit 的寄存器；sparse 编码使用以下三种操作码对寄存器进行游程编码：
+//
+//	ZERO  00xxxxxx           连续 xxxxxx+1 个寄存器为 0
+//	XZERO 01xxxxxx yyyyyyyy  连续 xxxxxxyyyyyyyy+1 个寄存器为 0
+//	VAL   1vvvvvxx           连续 xx+1 个寄存器的值为 vvvvv+1
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllPMask     = hllRegisters - 1
+	hllBits      = 6
+	hllRegMax    = 1<<hllBits - 1
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+	hllAlphaInf  = 0.721347520444481703680
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllSparseValMax    = 32
+	hllSparseValMaxLen = 4
+	hllSparseZeroMax   = 64
+	hllSparseXZeroMax  = 16384
+
+	// hllSparseMaxBytes 是 sparse 编码的最大字节数，超过后将转换为 dense 编码
+	hllSparseMaxBytes = 3000
+)
+
+var hllMagic = []byte("HYLL")
+
+var (
+	ErrInvalidHLL   = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrCorruptedHLL = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// HyperLogLog 是用于基数统计的概率数据结构，标准误差为 0.81%
+type HyperLogLog []byte
+
+// hllRegisterSet 是解码后的寄存器数组
+type hllRegisterSet [hllRegisters]uint8
+
+// NewHyperLogLog 创建一个使用 sparse 编码的空 HyperLogLog
+func NewHyperLogLog() *HyperLogLog {
+	h := make(HyperLogLog, hllHdrSize, hllHdrSize+2)
+	copy(h, hllMagic)
+	h[4] = hllSparse
+	// 所有寄存器均为 0，使用一个 XZERO 操作码表示
+	h = append(h, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+	return &h
+}
+
+// NewHyperLogLogFromBytes 使用字符串创建 HyperLogLog，如果字符串不是合法的 HyperLogLog 将返回 ErrInvalidHLL。
+// 返回的 HyperLogLog 与 bytes 共享内存
+func NewHyperLogLogFromBytes(bytes []byte) (*HyperLogLog, error) {
+
+	if len(bytes) < hllHdrSize || string(bytes[:4]) != string(hllMagic) {
+		return nil, ErrInvalidHLL
+	}
+
+	switch bytes[4] {
+	case hllDense:
+		if len(bytes) != hllDenseSize {
+			return nil, ErrInvalidHLL
+		}
+	case hllSparse:
+	default:
+		return nil, ErrInvalidHLL
+	}
+
+	h := HyperLogLog(bytes)
+	return &h, nil
+}
+
+// IsSparse 返回 HyperLogLog 是否使用 sparse 编码
+func (h *HyperLogLog) IsSparse() bool {
+	return (*h)[4] == hllSparse
+}
+
+func (h *HyperLogLog) invalidateCache() {
+	(*h)[15] |= 1 << 7
+}
+
+func (h *HyperLogLog) cachedCount() (uint64, bool) {
+	if (*h)[15]&(1<<7) != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64((*h)[8:16]), true
+}
+
+func (h *HyperLogLog) setCache(count uint64) {
+	binary.LittleEndian.PutUint64((*h)[8:16], count)
+}
+
+func denseGetRegister(registers []byte, index int) uint8 {
+	pos := index * hllBits / 8
+	fb := uint(index*hllBits) & 7
+	b0 := uint(registers[pos])
+	b1 := uint(0)
+	if pos+1 < len(registers) {
+		b1 = uint(registers[pos+1])
+	}
+	return uint8(((b0 >> fb) | (b1 << (8 - fb))) & hllRegMax)
+}
+
+func denseSetRegister(registers []byte, index int, value uint8) {
+	pos := index * hllBits / 8
+	fb := uint(index*hllBits) & 7
+	v := uint(value)
+	registers[pos] &^= byte(hllRegMax << fb)
+	registers[pos] |= byte(v << fb)
+	if pos+1 < len(registers) {
+		registers[pos+1] &^= byte(hllRegMax >> (8 - fb))
+		registers[pos+1] |= byte(v >> (8 - fb))
+	}
+}
+
+// mergeRegisters 将 HyperLogLog 中的寄存器按照最大值合并到 set 中，sparse 编码损坏时将返回 ErrCorruptedHLL
+func (h *HyperLogLog) mergeRegisters(set *hllRegisterSet) error {
+
+	if !h.IsSparse() {
+		registers := (*h)[hllHdrSize:]
+		for i := 0; i < hllRegisters; i++ {
+			if v := denseGetRegister(registers, i); v > set[i] {
+				set[i] = v
+			}
+		}
+		return nil
+	}
+
+	index := 0
+	data := (*h)[hllHdrSize:]
+
+	for i := 0; i < len(data); i++ {
+		op := data[i]
+		switch {
+		case op&0xc0 == 0x00:
+			index += int(op&0x3f) + 1
+		case op&0xc0 == 0x40:
+			if i+1 >= len(data) {
+				return ErrCorruptedHLL
+			}
+			index += (int(op&0x3f)<<8 | int(data[i+1])) + 1
+			i++
+		default:
+			value := (op>>2)&0x1f + 1
+			runLen := int(op&0x03) + 1
+			if index+runLen > hllRegisters {
+				return ErrCorruptedHLL
+			}
+			for j := index; j < index+runLen; j++ {
+				if value > set[j] {
+					set[j] = value
+				}
+			}
+			index += runLen
+		}
+	}
+
+	if index != hllRegisters {
+		return ErrCorruptedHLL
+	}
+
+	return nil
+}
+
+// encodeSparse 将寄存器编码为 sparse 格式，如果寄存器的值超过 sparse 编码的上限将返回 false
+func encodeSparse(set *hllRegisterSet) ([]byte, bool) {
+
+	data := make([]byte, 0, 64)
+
+	for i := 0; i < hllRegisters; {
+
+		value := set[i]
+		runLen := 1
+		for i+runLen < hllRegisters && set[i+runLen] == value {
+			runLen++
+		}
+		i += runLen
+
+		if value > hllSparseValMax {
+			return nil, false
+		}
+		data = appendSparseRun(data, value, runLen)
+	}
+
+	return data, true
+}
+
+// storeDense 将寄存器以 dense 编码写回 HyperLogLog
+func (h *HyperLogLog) storeDense(set *hllRegisterSet) {
+
+	dense := make(HyperLogLog, hllDenseSize)
+	copy(dense, (*h)[:hllHdrSize])
+	dense[4] = hllDense
+	registers := dense[hllHdrSize:]
+	for i := 0; i < hllRegisters; i++ {
+		if set[i] != 0 {
+			denseSetRegister(registers, i, set[i])
+		}
+	}
+	*h = dense
+}
+
+// denseSet 在寄存器的值小于 count 时将其修改为 count，返回寄存器是否被修改
+func (h *HyperLogLog) denseSet(index int, count uint8) bool {
+	registers := (*h)[hllHdrSize:]
+	if count > denseGetRegister(registers, index) {
+		denseSetRegister(registers, index, count)
+		return true
+	}
+	return false
+}
+
+// toDense 将 sparse 编码转换为 dense 编码
+func (h *HyperLogLog) toDense() error {
+	set := &hllRegisterSet{}
+	if err := h.mergeRegisters(set); err != nil {
+		return err
+	}
+	h.storeDense(set)
+	return nil
+}
+
+// appendSparseRun 将连续 n 个值为 value 的寄存器编码后追加到 seq 中
+func appendSparseRun(seq []byte, value uint8, n int) []byte {
+	for n > 0 {
+		switch {
+		case value != 0:
+			l := n
+			if l > hllSparseValMaxLen {
+				l = hllSparseValMaxLen
+			}
+			seq = append(seq, 0x80|(value-1)<<2|byte(l-1))
+			n -= l
+		case n > hllSparseZeroMax:
+			l := n
+			if l > hllSparseXZeroMax {
+				l = hllSparseXZeroMax
+			}
+			seq = append(seq, 0x40|byte((l-1)>>8), byte((l-1)&0xff))
+			n -= l
+		default:
+			seq = append(seq, byte(n-1))
+			n = 0
+		}
+	}
+	return seq
+}
+
+// sparseOpcode 解析 data[pos] 处的操作码，返回操作码的字节数、覆盖的寄存器数量以及寄存器的值
+func sparseOpcode(data []byte, pos int) (opLen int, runLen int, value uint8, err error) {
+	op := data[pos]
+	switch {
+	case op&0xc0 == 0x00:
+		return 1, int(op&0x3f) + 1, 0, nil
+	case op&0xc0 == 0x40:
+		if pos+1 >= len(data) {
+			return 0, 0, 0, ErrCorruptedHLL
+		}
+		return 2, (int(op&0x3f)<<8 | int(data[pos+1])) + 1, 0, nil
+	default:
+		return 1, int(op&0x03) + 1, (op>>2)&0x1f + 1, nil
+	}
+}
+
+// sparseSet 原地修改 sparse 编码中的一个寄存器，实现与 redis 的 hllSparseSet 相同：
+// 找到覆盖该寄存器的操作码，将其拆分为最多三个操作码，然后合并相邻的相同值。
+// 寄存器的值超过 sparse 编码的上限，或者编码长度超过 hllSparseMaxBytes 时会转换为 dense 编码
+func (h *HyperLogLog) sparseSet(index int, count uint8) (bool, error) {
+
+	if count > hllSparseValMax {
+		if err := h.toDense(); err != nil {
+			return false, err
+		}
+		return h.denseSet(index, count), nil
+	}
+
+	data := (*h)[hllHdrSize:]
+
+	// 找到覆盖 index 的操作码
+	first, pos, prev := 0, 0, -1
+	opLen, runLen, value := 0, 0, uint8(0)
+	for {
+		if pos >= len(data) {
+			return false, ErrCorruptedHLL
+		}
+		var err error
+		if opLen, runLen, value, err = sparseOpcode(data, pos); err != nil {
+			return false, err
+		}
+		if index < first+runLen {
+			break
+		}
+		first += runLen
+		prev = pos
+		pos += opLen
+	}
+
+	if value >= count {
+		return false, nil
+	}
+
+	// 拆分为 index 之前、index 以及 index 之后的三个部分
+	seq := make([]byte, 0, 5)
+	seq = appendSparseRun(seq, value, index-first)
+	seq = appendSparseRun(seq, count, 1)
+	seq = appendSparseRun(seq, value, first+runLen-index-1)
+
+	size := len(*h) + len(seq) - opLen
+	if size > hllSparseMaxBytes {
+		if err := h.toDense(); err != nil {
+			return false, err
+		}
+		return h.denseSet(index, count), nil
+	}
+
+	start := hllHdrSize + pos
+	if size > len(*h) {
+		*h = append(*h, make([]byte, size-len(*h))...)
+	}
+	copy((*h)[start+len(seq):], (*h)[start+opLen:])
+	copy((*h)[start:], seq)
+	*h = (*h)[:size]
+
+	// 从前一个操作码开始，合并最多五个操作码中相邻的相同值
+	if prev < 0 {
+		prev = 0
+	}
+	h.sparseMergeValues(hllHdrSize + prev)
+
+	return true, nil
+}
+
+// sparseMergeValues 从 start 开始扫描最多五个操作码，将值相同并且长度之和不超过上限的相邻 VAL 操作码合并
+func (h *HyperLogLog) sparseMergeValues(start int) {
+
+	p := start
+	for scan := 0; scan < 5 && p < len(*h); scan++ {
+		op := (*h)[p]
+		switch {
+		case op&0xc0 == 0x40:
+			p += 2
+			continue
+		case op&0xc0 == 0x00:
+			p++
+			continue
+		}
+
+		if p+1 < len(*h) && (*h)[p+1]&0x80 != 0 {
+			next := (*h)[p+1]
+			v1, v2 := (op>>2)&0x1f, (next>>2)&0x1f
+			l := int(op&0x03) + int(next&0x03) + 2
+			if v1 == v2 && l <= hllSparseValMaxLen {
+				(*h)[p+1] = 0x80 | v1<<2 | byte(l-1)
+				*h = append((*h)[:p], (*h)[p+1:]...)
+				// 再次处理合并后的操作码
+				continue
+			}
+		}
+		p++
+	}
+}
+
+// hllPatLen 返回元素对应的寄存器以及哈希值中第一个 1 出现的位置
+func hllPatLen(element []byte) (int, uint8) {
+	hash := utils.MurmurHash64A(element, 0xadc83b19)
+	index := int(hash & hllPMask)
+	hash >>= hllP
+	hash |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// Add 将元素加入到 HyperLogLog 中，如果有寄存器被修改将返回 true
+func (h *HyperLogLog) Add(elements ...[]byte) (bool, error) {
+
+	updated := false
+
+	if !h.IsSparse() {
+		for _, element := range elements {
+			index, count := hllPatLen(element)
+			updated = h.denseSet(index, count) || updated
+		}
+	} else {
+		// sparse 编码原地修改操作码，必要时会转换为 dense 编码
+		for _, element := range elements {
+			index, count := hllPatLen(element)
+			ok, err := h.sparseSet(index, count)
+			if err != nil {
+				return false, err
+			}
+			updated = updated || ok
+		}
+	}
+
+	if updated {
+		h.invalidateCache()
+	}
+
+	return updated, nil
+}
+
+// Count 返回 HyperLogLog 的基数估计值，计算结果会被缓存在头部
+func (h *HyperLogLog) Count() (uint64, error) {
+
+	if count, ok := h.cachedCount(); ok {
+		return count, nil
+	}
+
+	set := &hllRegisterSet{}
+	if err := h.mergeRegisters(set); err != nil {
+		return 0, err
+	}
+
+	count := set.count()
+	h.setCache(count)
+
+	return count, nil
+}
+
+// Merge 将 others 合并到 HyperLogLog 中，合并后将使用 dense 编码
+func (h *HyperLogLog) Merge(others ...*HyperLogLog) error {
+
+	set := &hllRegisterSet{}
+	for _, hll := range append([]*HyperLogLog{h}, others...) {
+		if err := hll.mergeRegisters(set); err != nil {
+			return err
+		}
+	}
+
+	h.storeDense(set)
+	h.invalidateCache()
+
+	return nil
+}
+
+// CountUnion 返回多个 HyperLogLog 并集的基数估计值
+func CountUnion(hlls ...*HyperLogLog) (uint64, error) {
+
+	set := &hllRegisterSet{}
+	for _, hll := range hlls {
+		if err := hll.mergeRegisters(set); err != nil {
+			return 0, err
+		}
+	}
+
+	return set.count(), nil
+}
+
+// count 使用 Otmar Ertl 提出的改进估计算法计算基数，与 redis 的结果一致
+func (set *hllRegisterSet) count() uint64 {
+
+	// dense 寄存器的值最大为 63，与 redis 相同使用 64 个桶，超过 hllQ+1 的值不参与估计
+	histogram := [hllRegMax + 1]int{}
+	for _, v := range set {
+		histogram[v]++
+	}
+
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+
+	// 损坏的寄存器可能使估计值为无穷大，此时返回最大值
+	estimate := math.Round(hllAlphaInf * m * m / z)
+	if estimate >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return uint64(estimate)
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package structure
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestHyperLogLogRegister(t *testing.T) {
+
+	registers := make([]byte, hllDenseSize-hllHdrSize)
+
+	for i := 0; i < hllRegisters; i++ {
+		denseSetRegister(registers, i, uint8(i%(hllRegMax+1)))
+	}
+	for i := 0; i < hllRegisters; i++ {
+		assert.Equal(t, uint8(i%(hllRegMax+1)), denseGetRegister(registers, i))
+	}
+
+	set := &hllRegisterSet{}
+	set[0], set[1], set[2], set[100], set[hllRegisters-1] = 1, 1, 32, 5, 7
+	data, ok := encodeSparse(set)
+	assert.True(t, ok)
+
+	decoded := &hllRegisterSet{}
+	h := append(HyperLogLog(make([]byte, hllHdrSize)), data...)
+	h[4] = hllSparse
+	assert.Nil(t, h.mergeRegisters(decoded))
+	assert.Equal(t, set, decoded)
+
+	set[5] = 33
+	_, ok = encodeSparse(set)
+	assert.False(t, ok)
+}
+
+func TestHyperLogLog(t *testing.T) {
+
+	h := NewHyperLogLog()
+	assert.True(t, h.IsSparse())
+
+	count, err := h.Count()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), count)
+
+	updated, err := h.Add([]byte("a"), []byte("b"), []byte("c"))
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	updated, _ = h.Add([]byte("a"))
+	assert.False(t, updated)
+
+	count, _ = h.Count()
+	assert.Equal(t, uint64(3), count)
+	cached, ok := h.cachedCount()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), cached)
+
+	// 元素增多后转换为 dense 编码，误差保持在 2% 以内
+	for i := 0; i < 100000; i++ {
+		_, err = h.Add([]byte(strconv.Itoa(i)))
+		assert.Nil(t, err)
+	}
+	assert.False(t, h.IsSparse())
+	assert.Len(t, *h, hllDenseSize)
+
+	count, _ = h.Count()
+	assert.Less(t, math.Abs(float64(count)-100003)/100003, 0.02)
+
+	// 合并与并集
+	other := NewHyperLogLog()
+	for i := 50000; i < 150000; i++ {
+		_, _ = other.Add([]byte(strconv.Itoa(i)))
+	}
+	union, err := CountUnion(h, other)
+	assert.Nil(t, err)
+	assert.Less(t, math.Abs(float64(union)-150003)/150003, 0.02)
+
+	assert.Nil(t, other.Merge(h))
+	count, _ = other.Count()
+	assert.Equal(t, union, count)
+
+	loaded, err := NewHyperLogLogFromBytes(*other)
+	assert.Nil(t, err)
+	count, _ = loaded.Count()
+	assert.Equal(t, union, count)
+}
+
+func TestHyperLogLogInvalid(t *testing.T) {
+
+	_, err := NewHyperLogLogFromBytes([]byte("HYLL"))
+	assert.Equal(t, ErrInvalidHLL, err)
+
+	_, err = NewHyperLogLogFromBytes([]byte("abcdabcdabcdabcdabcd"))
+	assert.Equal(t, ErrInvalidHLL, err)
+
+	// dense 编码的长度必须固定
+	h := NewHyperLogLog()
+	(*h)[4] = hllDense
+	_, err = NewHyperLogLogFromBytes(*h)
+	assert.Equal(t, ErrInvalidHLL, err)
+
+	// 寄存器数量不正确的 sparse 编码
+	h = NewHyperLogLog()
+	(*h)[hllHdrSize+1] = 0
+	h.invalidateCache()
+	_, err = h.Count()
+	assert.Equal(t, ErrCorruptedHLL, err)
+
+	// 原地修改 sparse 编码时只检查被修改的操作码，截断的 XZERO 操作码同样会被发现
+	h = NewHyperLogLog()
+	*h = (*h)[:hllHdrSize+1]
+	_, err = h.Add([]byte("a"))
+	assert.Equal(t, ErrCorruptedHLL, err)
+
+	// dense 寄存器的值可以达到 63，计数时不能越界
+	h = NewHyperLogLog()
+	(*h)[4] = hllDense
+	*h = append(*h, make([]byte, hllDenseSize-len(*h))...)
+	for i := hllHdrSize; i < hllDenseSize; i++ {
+		(*h)[i] = 0xff
+	}
+	h.invalidateCache()
+	loaded, err := NewHyperLogLogFromBytes(*h)
+	assert.Nil(t, err)
+	assert.NotPanics(t, func() {
+		_, err = loaded.Count()
+	})
+	assert.Nil(t, err)
+	_, err = CountUnion(loaded, NewHyperLogLog())
+	assert.Nil(t, err)
+}
+
+func TestHyperLogLogSparseSet(t *testing.T) {
+
+	// 原地修改 sparse 编码的结果必须与解码后修改再重新编码的结果相同
+	h := NewHyperLogLog()
+	set := &hllRegisterSet{}
+	for i := 0; i < 3000 && h.IsSparse(); i++ {
+		index, count := hllPatLen([]byte(strconv.Itoa(i)))
+		if count > set[index] {
+			set[index] = count
+		}
+		_, err := h.Add([]byte(strconv.Itoa(i)))
+		assert.Nil(t, err)
+
+		decoded := &hllRegisterSet{}
+		assert.Nil(t, h.mergeRegisters(decoded))
+		assert.Equal(t, set, decoded)
+	}
+
+	// 连续的寄存器会被合并为一个 VAL 操作码
+	h = NewHyperLogLog()
+	for i := 0; i < 4; i++ {
+		_, _ = h.sparseSet(i, 3)
+	}
+	assert.Equal(t, []byte{0x80 | 2<<2 | 3, 0x40 | byte((hllRegisters-5)>>8), byte((hllRegisters - 5) & 0xff)}, []byte((*h)[hllHdrSize:]))
+
+	// 超过 sparse 编码上限的值会转换为 dense 编码
+	updated, err := h.sparseSet(10, hllSparseValMax+1)
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.False(t, h.IsSparse())
+	assert.Equal(t, uint8(3), denseGetRegister((*h)[hllHdrSize:], 0))
+	assert.Equal(t, uint8(hllSparseValMax+1), denseGetRegister((*h)[hllHdrSize:], 10))
+}
@@ -88,6 +88,13 @@ func (set *Set) KeysByte(pattern string) ([][]byte, int) {
 	return set.dict.KeysByte(pattern)
 }
 
+// Scan 使用游标遍历集合中的键，用法与 Dict.Scan 相同
+func (set *Set) Scan(cursor, count int, fn func(key string)) int {
+	return set.dict.Scan(cursor, count, func(key string, _ Object) {
+		fn(key)
+	})
+}
+
 func (set *Set) Cost() int64 {
 	return setBasicCost + set.dict.Cost()
 }
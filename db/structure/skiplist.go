@@ -10,11 +10,11 @@ const skipListNodeBasicCost = int64(unsafe.Sizeof(skipListNode{}))
 type skipListNode struct {
 	next   []*skipListNode
 	height int
-	key    Float32
-	value  Object
+	key    Float64
+	value  String
 }
 
-func newSkipListNode(key Float32, value Object, height int) *skipListNode {
+func newSkipListNode(key Float64, value String, height int) *skipListNode {
 	return &skipListNode{
 		next:   make([]*skipListNode, height),
 		height: height,
@@ -42,7 +42,7 @@ func (node *skipListNode) Cost() int64 {
 	return skipListNodeBasicCost + node.value.Cost() + int64(node.height*8)
 }
 
-// SkipList 是一个跳跃表容器
+// SkipList 是一个跳跃表容器，节点按照键排序，键相同时按照值的字典序排序
 type SkipList struct {
 	size  int
 	level int
@@ -57,7 +57,7 @@ func NewSkipList(level int) *SkipList {
 	return &SkipList{
 		size:  0,
 		level: level,
-		head:  newSkipListNode(-1, Slice(""), level),
+		head:  newSkipListNode(-1, "", level),
 		cost:  skipListBasicCost,
 	}
 }
@@ -73,57 +73,58 @@ func randomHeight(max int) int {
 	return level
 }
 
-// Insert 将键值对插入到跳跃表中
-func (sl *SkipList) Insert(key Float32, value Object) {
-
-	// 需要找到每一个层次的前驱
-	prevs := make([]*skipListNode, sl.level)
-	cur := sl.head
+// ScoreRange 是跳跃表中键的范围，MinEx 与 MaxEx 为 true 时代表对应的边界为开区间
+type ScoreRange struct {
+	Min   Float64
+	Max   Float64
+	MinEx bool
+	MaxEx bool
+}
 
-	// 每一个 prev 的 key 小于等于需要插入的 key
-	for i := sl.level - 1; i >= 0; i-- {
-		for nxt := cur.getNextNode(i); nxt != nil && nxt.key <= key; nxt = cur.getNextNode(i) {
-			cur = nxt
-		}
-		prevs[i] = cur
+// gteMin 判断键是否满足范围的下界
+func (r *ScoreRange) gteMin(key Float64) bool {
+	if r.MinEx {
+		return key > r.Min
 	}
+	return key >= r.Min
+}
 
-	// 允许重复，所以不加这一段
-	//if prevs[0].key == key {
-	//	prevs[0].value = value
-	//	return
-	//}
+// lteMax 判断键是否满足范围的上界
+func (r *ScoreRange) lteMax(key Float64) bool {
+	if r.MaxEx {
+		return key < r.Max
+	}
+	return key <= r.Max
+}
 
-	// 随机生成高度节点
-	height := randomHeight(sl.level)
-	node := newSkipListNode(key, value, height)
+// Empty 判断范围内是否不可能包含任何键
+func (r *ScoreRange) Empty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinEx || r.MaxEx))
+}
 
-	// 从底层到高层依次插入
-	for i := 0; i < height; i++ {
-		prevs[i].changeNextNode(i, node)
-	}
-	sl.size++
-	sl.cost += node.Cost()
+// less 判断节点是否排在给定的键值对之前，键相同时按照值的字典序排序
+func (node *skipListNode) less(key Float64, value String) bool {
+	return node.key < key || (node.key == key && node.value < value)
 }
 
-// InsertIfNotExist 将键值对插入到跳跃表中，若键已存在，返回 false
-func (sl *SkipList) InsertIfNotExist(key Float32, value Object) bool {
+// findPrevs 返回每一个层次中最后一个排在给定键值对之前的节点
+func (sl *SkipList) findPrevs(key Float64, value String) []*skipListNode {
 
-	// 需要找到每一个层次的前驱
 	prevs := make([]*skipListNode, sl.level)
 	cur := sl.head
-	// 每一个 prev 的 key 小于等于需要插入的 key
+
 	for i := sl.level - 1; i >= 0; i-- {
-		for nxt := cur.getNextNode(i); nxt != nil && nxt.key <= key; nxt = cur.getNextNode(i) {
+		for nxt := cur.getNextNode(i); nxt != nil && nxt.less(key, value); nxt = cur.getNextNode(i) {
 			cur = nxt
 		}
 		prevs[i] = cur
 	}
 
-	// 如果前驱 key 相同则判断插入失败
-	if prevs[0].key == key {
-		return false
-	}
+	return prevs
+}
+
+// insertAfter 在每一个层次的前驱之后插入节点
+func (sl *SkipList) insertAfter(prevs []*skipListNode, key Float64, value String) {
 
 	// 随机生成高度节点
 	height := randomHeight(sl.level)
@@ -135,92 +136,72 @@ func (sl *SkipList) InsertIfNotExist(key Float32, value Object) bool {
 	}
 	sl.size++
 	sl.cost += node.Cost()
-	return true
 }
 
-// Update 更新给定键的值，若键不存在，返回 false
-func (sl *SkipList) Update(key Float32, value Object) bool {
-	// 需要找到每一个层次的前驱
-	cur := sl.head
-
-	// 每一个 prev 的 key 小于等于需要插入的 key
-	for i := sl.level - 1; i >= 0 && cur.key < key; i-- {
-		for nxt := cur.getNextNode(i); nxt != nil && nxt.key <= key; nxt = cur.getNextNode(i) {
-			cur = nxt
-		}
-	}
-
-	if cur.key == key {
-		sl.cost -= cur.Cost()
-		cur.value = value
-		sl.cost += cur.Cost()
-		return true
-	}
-	return false
+// Insert 将键值对插入到跳跃表中，键相同的节点按照值的字典序排列
+func (sl *SkipList) Insert(key Float64, value String) {
+	sl.insertAfter(sl.findPrevs(key, value), key, value)
 }
 
-// Get 返回键的值，如果键不存在返回 nil,false
-func (sl *SkipList) Get(key Float32) (Object, bool) {
-	// 需要找到每一个层次的前驱
-	cur := sl.head
+// InsertIfNotExist 将键值对插入到跳跃表中，若键值对已存在，返回 false
+func (sl *SkipList) InsertIfNotExist(key Float64, value String) bool {
 
-	// 每一个 prev 的 key 小于等于需要插入的 key
-	for i := sl.level - 1; i >= 0 && cur.key < key; i-- {
-		for nxt := cur.getNextNode(i); nxt != nil && nxt.key <= key; nxt = cur.getNextNode(i) {
-			cur = nxt
-		}
-	}
+	prevs := sl.findPrevs(key, value)
 
-	if cur.key == key {
-		return cur.value, true
+	// 如果后继的键值对相同则判断插入失败
+	if nxt := prevs[0].getNextNode(0); nxt != nil && nxt.key == key && nxt.value == value {
+		return false
 	}
-	return nil, false
+
+	sl.insertAfter(prevs, key, value)
+	return true
 }
 
-// Delete 删除键值对，若键值不存在，返回 false
-func (sl *SkipList) Delete(key Float32) bool {
+// first 返回第一个键大于等于 key 的节点
+func (sl *SkipList) first(key Float64) *skipListNode {
 
-	// 需要找到每一个层次的前驱
-	prevs := make([]*skipListNode, sl.level)
 	cur := sl.head
-
-	// 每一个 prev 的 key 小于需要插入的 key，然后判断下一个键
 	for i := sl.level - 1; i >= 0; i-- {
 		for nxt := cur.getNextNode(i); nxt != nil && nxt.key < key; nxt = cur.getNextNode(i) {
 			cur = nxt
 		}
-		prevs[i] = cur
 	}
 
-	height := 0
-	if prevs[0].getNextNode(0) != nil && prevs[0].getNextNode(0).key == key {
-		height = prevs[0].getNextNode(0).height
-		node := prevs[0].getNextNode(0)
-		// 从底层到高层依次插入
-		for i := 0; i < height; i++ {
-			prevs[i].removeNextNode(i)
-		}
-		sl.size--
-		sl.cost -= node.Cost()
-		return true
+	return cur.getNextNode(0)
+}
+
+// Get 返回第一个键为 key 的节点值，如果键不存在返回 nil,false
+func (sl *SkipList) Get(key Float64) (Object, bool) {
 
+	if node := sl.first(key); node != nil && node.key == key {
+		return node.value, true
 	}
-	return false
+	return nil, false
 }
 
-// Exist 判断键值对是否存在于跳跃表中
-func (sl *SkipList) Exist(key Float32) bool {
-	// 需要找到每一个层次的前驱
-	cur := sl.head
+// Delete 删除键值对，若键值对不存在，返回 false
+func (sl *SkipList) Delete(key Float64, value String) bool {
 
-	// 每一个 prev 的 key 小于等于需要插入的 key
-	for i := sl.level - 1; i >= 0 && cur.key < key; i-- {
-		for nxt := cur.getNextNode(i); nxt != nil && nxt.key <= key; nxt = cur.getNextNode(i) {
-			cur = nxt
-		}
+	prevs := sl.findPrevs(key, value)
+
+	node := prevs[0].getNextNode(0)
+	if node == nil || node.key != key || node.value != value {
+		return false
+	}
+
+	// 从底层到高层依次删除
+	for i := 0; i < node.height; i++ {
+		prevs[i].removeNextNode(i)
 	}
+	sl.size--
+	sl.cost -= node.Cost()
+	return true
+}
 
-	return cur.key == key
+// Exist 判断键是否存在于跳跃表中
+func (sl *SkipList) Exist(key Float64) bool {
+	node := sl.first(key)
+	return node != nil && node.key == key
 }
 
 // Size 返回跳跃表的节点数量
@@ -228,28 +209,34 @@ func (sl *SkipList) Size() int {
 	return sl.size
 }
 
-// Range 返回给定键范围的所有节点值以及数量
-func (sl *SkipList) Range(min, max Float32) ([]Object, int) {
+// firstInRange 返回第一个位于范围内的节点，若不存在返回 nil
+func (sl *SkipList) firstInRange(r *ScoreRange) *skipListNode {
 
-	// 需要找到每一个层次的前驱
-	cur := sl.head
+	if r.Empty() {
+		return nil
+	}
 
-	// 每一个 prev 的 key 小于等于需要插入的 key
-	for i := sl.level - 1; i >= 0 && cur.key < min; i-- {
-		for nxt := cur.getNextNode(i); nxt != nil && nxt.key <= min; nxt = cur.getNextNode(i) {
+	cur := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+		for nxt := cur.getNextNode(i); nxt != nil && !r.gteMin(nxt.key); nxt = cur.getNextNode(i) {
 			cur = nxt
 		}
-
 	}
 
-	for ; cur != nil && cur.key < min; cur = cur.getNextNode(0) {
+	if node := cur.getNextNode(0); node != nil && r.lteMax(node.key) {
+		return node
 	}
+	return nil
+}
+
+// Range 返回给定键范围的所有节点值以及数量
+func (sl *SkipList) Range(r ScoreRange) ([]Object, int) {
 
 	values := make([]Object, 0)
 	size := 0
 
-	for ; cur != nil && cur.key <= max; cur = cur.getNextNode(0) {
-		values = append(values, cur.value.(String))
+	for cur := sl.firstInRange(&r); cur != nil && r.lteMax(cur.key); cur = cur.getNextNode(0) {
+		values = append(values, cur.value)
 		size++
 	}
 
@@ -257,68 +244,55 @@ func (sl *SkipList) Range(min, max Float32) ([]Object, int) {
 }
 
 // CountByRange 返回给定键范围的节点数量
-func (sl *SkipList) CountByRange(min, max Float32) int {
-	// 需要找到每一个层次的前驱
-	cur := sl.head
-
-	// 每一个 prev 的 key 小于等于需要插入的 key
-	for i := sl.level - 1; i >= 0 && cur.key < min; i-- {
-		for nxt := cur.getNextNode(i); nxt != nil && nxt.key <= min; nxt = cur.getNextNode(i) {
-			cur = nxt
-		}
-
-	}
-
-	for ; cur != nil && cur.key < min; cur = cur.getNextNode(0) {
-	}
+func (sl *SkipList) CountByRange(r ScoreRange) int {
 
 	size := 0
-
-	for ; cur != nil && cur.key <= max; cur = cur.getNextNode(0) {
+	for cur := sl.firstInRange(&r); cur != nil && r.lteMax(cur.key); cur = cur.getNextNode(0) {
 		size++
 	}
 
 	return size
 }
 
-// GetPosByKey 返回键值对在跳跃表中的位置
-func (sl *SkipList) GetPosByKey(key Float32) int {
-	pos := -1
-	cur := sl.head
-	for ; cur != nil && cur.key < key; cur = cur.getNextNode(0) {
+// Rank 返回键值对在跳跃表中的位置，若键值对不存在，返回 -1
+func (sl *SkipList) Rank(key Float64, value String) int {
+	pos := 0
+	cur := sl.head.getNextNode(0)
+	for ; cur != nil && cur.less(key, value); cur = cur.getNextNode(0) {
 		pos++
 	}
-	if cur == nil || cur.key != key {
+	if cur == nil || cur.key != key || cur.value != value {
 		return -1
 	}
 	return pos
 }
 
-// DeleteRange 删除跳跃表中指定返回的键值对，返回值和数量
-func (sl *SkipList) DeleteRange(min, max Float32) ([]Object, int) {
-	// 需要找到每一个层次的前驱
-	cur := sl.head
+// DeleteRange 删除跳跃表中指定范围的键值对，返回值和数量
+func (sl *SkipList) DeleteRange(r ScoreRange) ([]Object, int) {
 
 	deleted := 0
 	values := make([]Object, 0)
 
-	// 每一个 prev 的 key 小于等于需要插入的 key
-	for i := sl.level - 1; i >= 0 && cur.key < min; i-- {
+	if r.Empty() {
+		return values, 0
+	}
 
-		for nxt := cur.getNextNode(i); nxt != nil && nxt.key < min; nxt = cur.getNextNode(i) {
+	cur := sl.head
+	for i := sl.level - 1; i >= 0; i-- {
+
+		for nxt := cur.getNextNode(i); nxt != nil && !r.gteMin(nxt.key); nxt = cur.getNextNode(i) {
 			cur = nxt
 		}
-		// 这里 cur 的值正好是 < min 的
+		// 这里 cur 是该层最后一个小于下界的节点
 		n := cur.getNextNode(i)
-		for ; n != nil && n.key <= max; n = n.getNextNode(i) {
+		for ; n != nil && r.lteMax(n.key); n = n.getNextNode(i) {
 			if i == 0 {
 				values = append(values, n.value)
 				deleted++
 				sl.cost -= n.Cost()
 			}
-
 		}
-		// 这里 n 的值 > max
+		// 这里 n 的值超出上界
 		cur.next[i] = n
 	}
 	sl.size -= deleted
@@ -328,40 +302,30 @@ func (sl *SkipList) DeleteRange(min, max Float32) ([]Object, int) {
 // DeletePos 删除跳跃表中指定位置的键值对，返回值和数量
 func (sl *SkipList) DeletePos(start, end int) ([]Object, int) {
 
-	// 判别位置
-	if start < 0 {
-		start += sl.size
-	}
-	if end < 0 {
-		end += sl.size
-	}
-	if start > end || end < 0 || start >= sl.size {
+	start, end, ok := sl.posRange(start, end)
+	if !ok {
 		return nil, 0
 	}
-	if start < 0 {
-		start = 0
-	}
-	if end >= sl.size {
-		end = sl.size - 1
-	}
 
 	cur := sl.head
-
-	for i := 0; i < start; i++ {
+	for i := 0; i <= start; i++ {
 		cur = cur.getNextNode(0)
 	}
 
-	min := cur.getNextNode(0).key
-	for i := start; i < end; i++ {
+	// 键可能重复，所以需要按照键值对逐个删除
+	values := make([]Object, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		node := cur
 		cur = cur.getNextNode(0)
+		values = append(values, node.value)
+		sl.Delete(node.key, node.value)
 	}
-	max := cur.getNextNode(0).key
-	return sl.DeleteRange(min, max)
 
+	return values, end - start + 1
 }
 
-// Pos 返回跳跃表中指定位置键值对的值和数量
-func (sl *SkipList) Pos(start, end int) ([]Object, int) {
+// posRange 将位置范围转换为非负的闭区间，若范围内没有节点，返回 false
+func (sl *SkipList) posRange(start, end int) (int, int, bool) {
 
 	// 判别位置
 	if start < 0 {
@@ -371,7 +335,7 @@ func (sl *SkipList) Pos(start, end int) ([]Object, int) {
 		end += sl.size
 	}
 	if start > end || end < 0 || start >= sl.size {
-		return nil, 0
+		return 0, 0, false
 	}
 	if start < 0 {
 		start = 0
@@ -379,6 +343,16 @@ func (sl *SkipList) Pos(start, end int) ([]Object, int) {
 	if end >= sl.size {
 		end = sl.size - 1
 	}
+	return start, end, true
+}
+
+// Pos 返回跳跃表中指定位置键值对的值和数量
+func (sl *SkipList) Pos(start, end int) ([]Object, int) {
+
+	start, end, ok := sl.posRange(start, end)
+	if !ok {
+		return nil, 0
+	}
 
 	cur := sl.head
 
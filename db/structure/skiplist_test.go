@@ -1,26 +1,79 @@
 package structure
 
-import "testing"
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
 
 func TestSkipList(t *testing.T) {
 
 	skipList := NewSkipList(3)
-	skipList.Insert(0.0, Slice("1"))
-	skipList.InsertIfNotExist(1.1, Int64(1))
-	skipList.Insert(1.2, Slice("222"))
+	skipList.Insert(0.0, String("1"))
+	skipList.InsertIfNotExist(1.1, String("1"))
+	skipList.Insert(1.2, String("222"))
 
-	skipList.Delete(1.1)
+	skipList.Delete(1.1, String("1"))
 
 	if _, ok := skipList.Get(3.6); ok {
 		t.Error("Get Failed")
 	}
 
-	if _, size := skipList.Range(99, 100); size != 0 {
+	if _, size := skipList.Range(ScoreRange{Min: 99, Max: 100}); size != 0 {
 		t.Error("Range Failed")
 	}
 
-	if skipList.GetPosByKey(35) != -1 {
-		t.Error("GetPosByKey Failed")
+	if skipList.Rank(35, String("1")) != -1 {
+		t.Error("Rank Failed")
 	}
 
 }
+
+func TestSkipListSameKey(t *testing.T) {
+
+	skipList := NewSkipList(8)
+	for _, v := range []string{"c", "a", "d", "b"} {
+		skipList.Insert(1, String(v))
+	}
+	skipList.Insert(-2, String("neg"))
+	skipList.Insert(Float64(math.Inf(1)), String("inf"))
+	assert.False(t, skipList.InsertIfNotExist(1, String("a")))
+
+	values, n := skipList.Pos(0, -1)
+	assert.Equal(t, 6, n)
+	assert.Equal(t, []Object{String("neg"), String("a"), String("b"), String("c"), String("d"), String("inf")}, values)
+	assert.Equal(t, 3, skipList.Rank(1, String("c")))
+
+	// 删除时只删除键值对都相同的节点
+	assert.False(t, skipList.Delete(1, String("e")))
+	assert.True(t, skipList.Delete(1, String("b")))
+	assert.Equal(t, -1, skipList.Rank(1, String("b")))
+	assert.Equal(t, 2, skipList.Rank(1, String("c")))
+
+	tests := []struct {
+		r      ScoreRange
+		values []Object
+	}{
+		{ScoreRange{Min: -3, Max: 1}, []Object{String("neg"), String("a"), String("c"), String("d")}},
+		{ScoreRange{Min: -3, Max: 1, MaxEx: true}, []Object{String("neg")}},
+		{ScoreRange{Min: 1, Max: Float64(math.Inf(1)), MinEx: true}, []Object{String("inf")}},
+		{ScoreRange{Min: Float64(math.Inf(-1)), Max: Float64(math.Inf(1))}, []Object{String("neg"), String("a"), String("c"), String("d"), String("inf")}},
+		{ScoreRange{Min: 1, Max: 1, MinEx: true}, []Object{}},
+		{ScoreRange{Min: 2, Max: 1}, []Object{}},
+	}
+
+	for _, test := range tests {
+		values, n := skipList.Range(test.r)
+		assert.Equal(t, test.values, values)
+		assert.Equal(t, len(test.values), skipList.CountByRange(test.r))
+		assert.Equal(t, len(test.values), n)
+	}
+
+	values, n = skipList.DeletePos(1, 2)
+	assert.Equal(t, []Object{String("a"), String("c")}, values)
+	assert.Equal(t, 2, n)
+
+	values, n = skipList.DeleteRange(ScoreRange{Min: -2, Max: 1, MinEx: true})
+	assert.Equal(t, []Object{String("d")}, values)
+	assert.Equal(t, 2, skipList.Size())
+}
@@ -0,0 +1,524 @@
+package structure
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/tidwall/btree"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// StreamID 是 stream 中消息的唯一标识，由毫秒时间戳和序列号组成
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinStreamID = StreamID{Ms: 0, Seq: 0}
+	MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+var ErrInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// ParseStreamID 解析 ms-seq 格式的 ID，如果缺少序列号部分将使用 defaultSeq
+func ParseStreamID(s string, defaultSeq uint64) (StreamID, error) {
+
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, nil
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less 判断 id 是否小于 other
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// Incr 返回比 id 大的最小 ID，如果 id 已经是最大值将返回 false
+func (id StreamID) Incr() (StreamID, bool) {
+	if id == MaxStreamID {
+		return id, false
+	}
+	if id.Seq == math.MaxUint64 {
+		return StreamID{Ms: id.Ms + 1, Seq: 0}, true
+	}
+	return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+}
+
+// Decr 返回比 id 小的最大 ID，如果 id 已经是最小值将返回 false
+func (id StreamID) Decr() (StreamID, bool) {
+	if id == MinStreamID {
+		return id, false
+	}
+	if id.Seq == 0 {
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+}
+
+// radixKey 将 ID 编码为 16 字节的大端序字符串，字符串的字典序与 ID 的大小顺序一致，
+// 这与 redis 在基数树中存储 ID 的方式相同
+func (id StreamID) radixKey() string {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], id.Ms)
+	binary.BigEndian.PutUint64(buf[8:], id.Seq)
+	return string(buf[:])
+}
+
+const streamEntryBasicCost = int64(unsafe.Sizeof(StreamEntry{})) + 16
+
+// StreamEntry 是 stream 中的一条消息，Fields 按照 field1, value1, field2, value2 ... 的顺序排列。
+// 如果消息已经被删除，Fields 为 nil
+type StreamEntry struct {
+	ID     StreamID
+	Fields [][]byte
+}
+
+func (e *StreamEntry) Cost() int64 {
+	cost := streamEntryBasicCost
+	for _, field := range e.Fields {
+		cost += int64(len(field)) + 24
+	}
+	return cost
+}
+
+const streamPendingEntryBasicCost = int64(unsafe.Sizeof(StreamPendingEntry{})) + 16
+
+// StreamPendingEntry 是消费组中已经投递但是尚未确认的消息
+type StreamPendingEntry struct {
+	ID            StreamID
+	Consumer      *StreamConsumer // 消息当前的所有者
+	DeliveryTime  int64           // 最后一次投递的毫秒时间戳
+	DeliveryCount int64           // 投递次数
+}
+
+const streamConsumerBasicCost = int64(unsafe.Sizeof(StreamConsumer{}))
+
+// StreamConsumer 是消费组中的消费者，它维护了投递给自己但尚未确认的消息
+type StreamConsumer struct {
+	Name     string
+	SeenTime int64 // 最后一次活跃的毫秒时间戳
+	pending  *btree.Map[string, *StreamPendingEntry]
+}
+
+func newStreamConsumer(name string, now int64) *StreamConsumer {
+	return &StreamConsumer{
+		Name:     name,
+		SeenTime: now,
+		pending:  btree.NewMap[string, *StreamPendingEntry](32),
+	}
+}
+
+// PendingSize 返回消费者尚未确认的消息数量
+func (c *StreamConsumer) PendingSize() int {
+	return c.pending.Len()
+}
+
+const streamGroupBasicCost = int64(unsafe.Sizeof(StreamGroup{}))
+
+// StreamGroup 是 stream 的消费组，组内的消费者共同消费 stream 中的消息，每条消息只会投递给一个消费者
+type StreamGroup struct {
+	Name      string
+	LastID    StreamID // 最后投递的消息 ID
+	stream    *Stream
+	pending   *btree.Map[string, *StreamPendingEntry] // 消费组的 PEL（pending entries list）
+	consumers map[string]*StreamConsumer
+}
+
+func newStreamGroup(name string, lastID StreamID, stream *Stream) *StreamGroup {
+	return &StreamGroup{
+		Name:      name,
+		LastID:    lastID,
+		stream:    stream,
+		pending:   btree.NewMap[string, *StreamPendingEntry](32),
+		consumers: make(map[string]*StreamConsumer),
+	}
+}
+
+// Consumer 返回指定名称的消费者，如果不存在将返回 false
+func (g *StreamGroup) Consumer(name string) (*StreamConsumer, bool) {
+	c, ok := g.consumers[name]
+	return c, ok
+}
+
+// CreateConsumer 创建一个消费者，如果消费者已经存在将返回 false
+func (g *StreamGroup) CreateConsumer(name string, now int64) bool {
+	if _, ok := g.consumers[name]; ok {
+		return false
+	}
+	g.consumers[name] = newStreamConsumer(name, now)
+	g.stream.cost += streamConsumerBasicCost + int64(len(name))
+	return true
+}
+
+// lookupConsumer 返回指定名称的消费者，不存在时将会创建，并更新其活跃时间
+func (g *StreamGroup) lookupConsumer(name string, now int64) *StreamConsumer {
+	g.CreateConsumer(name, now)
+	c := g.consumers[name]
+	c.SeenTime = now
+	return c
+}
+
+// DeleteConsumer 删除消费者以及其尚未确认的消息，返回被删除的消息数量。如果消费者不存在将返回 -1
+func (g *StreamGroup) DeleteConsumer(name string) int {
+	c, ok := g.consumers[name]
+	if !ok {
+		return -1
+	}
+	deleted := c.pending.Len()
+	c.pending.Scan(func(key string, _ *StreamPendingEntry) bool {
+		g.pending.Delete(key)
+		return true
+	})
+	delete(g.consumers, name)
+	g.stream.cost -= streamConsumerBasicCost + int64(len(name)) + int64(deleted)*streamPendingEntryBasicCost
+	return deleted
+}
+
+// Consumers 返回按照名称排序的所有消费者
+func (g *StreamGroup) Consumers() []*StreamConsumer {
+	consumers := make([]*StreamConsumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+	sort.Slice(consumers, func(i, j int) bool {
+		return consumers[i].Name < consumers[j].Name
+	})
+	return consumers
+}
+
+// PendingSize 返回消费组中尚未确认的消息数量
+func (g *StreamGroup) PendingSize() int {
+	return g.pending.Len()
+}
+
+// deliver 将消息投递给消费者，如果消息已经在 PEL 中将转移所有权
+func (g *StreamGroup) deliver(id StreamID, c *StreamConsumer, now int64) *StreamPendingEntry {
+	key := id.radixKey()
+	pe, ok := g.pending.Get(key)
+	if !ok {
+		pe = &StreamPendingEntry{ID: id}
+		g.pending.Set(key, pe)
+		g.stream.cost += streamPendingEntryBasicCost
+	} else {
+		pe.Consumer.pending.Delete(key)
+	}
+	pe.Consumer = c
+	pe.DeliveryTime = now
+	c.pending.Set(key, pe)
+	return pe
+}
+
+// ReadNew 将 LastID 之后的最多 count 条消息投递给消费者 consumer，count 小于等于 0 时不限制数量。
+// 如果 noAck 为 true，消息不会被加入到 PEL 中
+func (g *StreamGroup) ReadNew(consumer string, count int, noAck bool, now int64) []*StreamEntry {
+
+	c := g.lookupConsumer(consumer, now)
+
+	start, ok := g.LastID.Incr()
+	if !ok {
+		return []*StreamEntry{}
+	}
+
+	entries := g.stream.Range(start, MaxStreamID, count)
+	for _, entry := range entries {
+		g.LastID = entry.ID
+		if !noAck {
+			g.deliver(entry.ID, c, now).DeliveryCount++
+		}
+	}
+	return entries
+}
+
+// ReadHistory 返回投递给消费者 consumer 且 ID 大于 start 的未确认消息，已经被删除的消息 Fields 为 nil
+func (g *StreamGroup) ReadHistory(consumer string, start StreamID, count int, now int64) []*StreamEntry {
+
+	c := g.lookupConsumer(consumer, now)
+
+	entries := make([]*StreamEntry, 0)
+	pivot, ok := start.Incr()
+	if !ok {
+		return entries
+	}
+
+	c.pending.Ascend(pivot.radixKey(), func(key string, pe *StreamPendingEntry) bool {
+		if count > 0 && len(entries) >= count {
+			return false
+		}
+		if entry, exist := g.stream.entries.Get(key); exist {
+			entries = append(entries, entry)
+		} else {
+			entries = append(entries, &StreamEntry{ID: pe.ID})
+		}
+		return true
+	})
+	return entries
+}
+
+// Ack 确认消息，将其从 PEL 中移除，如果消息不在 PEL 中将返回 false
+func (g *StreamGroup) Ack(id StreamID) bool {
+	pe, ok := g.pending.Delete(id.radixKey())
+	if !ok {
+		return false
+	}
+	pe.Consumer.pending.Delete(id.radixKey())
+	g.stream.cost -= streamPendingEntryBasicCost
+	return true
+}
+
+// PendingRange 返回 ID 在 [start, end] 范围内最多 count 条未确认消息，consumer 不为空时只返回属于该消费者的消息，
+// minIdle 大于 0 时只返回空闲时间不小于 minIdle 毫秒的消息
+func (g *StreamGroup) PendingRange(start, end StreamID, count int, consumer string, minIdle, now int64) []*StreamPendingEntry {
+
+	res := make([]*StreamPendingEntry, 0)
+
+	pel := g.pending
+	if consumer != "" {
+		c, ok := g.consumers[consumer]
+		if !ok {
+			return res
+		}
+		pel = c.pending
+	}
+
+	pel.Ascend(start.radixKey(), func(_ string, pe *StreamPendingEntry) bool {
+		if end.Less(pe.ID) || (count > 0 && len(res) >= count) {
+			return false
+		}
+		if minIdle <= 0 || now-pe.DeliveryTime >= minIdle {
+			res = append(res, pe)
+		}
+		return true
+	})
+	return res
+}
+
+// Claim 将消息的所有权转移给消费者 consumer，只有空闲时间不小于 minIdle 毫秒的消息才会被转移。
+// 如果 force 为 true，不在 PEL 中但存在于 stream 中的消息也会被加入 PEL；已经从 stream 中删除的消息将从 PEL 中移除。
+// 该函数不会修改消息的投递时间和投递次数，由调用者根据命令选项设置
+func (g *StreamGroup) Claim(id StreamID, consumer string, minIdle int64, force bool, now int64) (*StreamPendingEntry, bool) {
+
+	key := id.radixKey()
+	pe, inPEL := g.pending.Get(key)
+
+	if _, exist := g.stream.entries.Get(key); !exist {
+		if inPEL {
+			g.Ack(id)
+		}
+		return nil, false
+	}
+
+	if !inPEL {
+		if !force {
+			return nil, false
+		}
+		c := g.lookupConsumer(consumer, now)
+		pe = g.deliver(id, c, now)
+		return pe, true
+	}
+
+	if minIdle > 0 && now-pe.DeliveryTime < minIdle {
+		return nil, false
+	}
+
+	c := g.lookupConsumer(consumer, now)
+	deliveryTime := pe.DeliveryTime
+	pe = g.deliver(id, c, now)
+	pe.DeliveryTime = deliveryTime
+	return pe, true
+}
+
+const streamBasicCost = int64(unsafe.Sizeof(Stream{}))
+
+// Stream 是一个只允许追加的消息日志，消息按照 ID 递增的顺序存储在 B 树中
+type Stream struct {
+	entries *btree.Map[string, *StreamEntry]
+	lastID  StreamID // 最后添加的消息 ID，删除消息后也不会减小
+	groups  map[string]*StreamGroup
+	cost    int64
+}
+
+// NewStream 创建一个 Stream 并返回指针
+func NewStream() *Stream {
+	return &Stream{
+		entries: btree.NewMap[string, *StreamEntry](32),
+		groups:  make(map[string]*StreamGroup),
+		cost:    streamBasicCost,
+	}
+}
+
+// Len 返回 stream 中的消息数量
+func (s *Stream) Len() int {
+	return s.entries.Len()
+}
+
+// LastID 返回最后添加的消息 ID
+func (s *Stream) LastID() StreamID {
+	return s.lastID
+}
+
+// SetLastID 修改最后添加的消息 ID，id 不能小于 stream 中最大的消息 ID
+func (s *Stream) SetLastID(id StreamID) bool {
+	if _, last, ok := s.entries.Max(); ok && id.Less(last.ID) {
+		return false
+	}
+	s.lastID = id
+	return true
+}
+
+// NextID 根据毫秒时间戳 ms 生成下一个自增 ID，如果无法生成更大的 ID 将返回 false
+func (s *Stream) NextID(ms uint64) (StreamID, bool) {
+	if ms > s.lastID.Ms {
+		return StreamID{Ms: ms, Seq: 0}, true
+	}
+	return s.lastID.Incr()
+}
+
+// Add 将消息追加到 stream 中，id 必须大于最后添加的消息 ID，否则返回 false
+func (s *Stream) Add(id StreamID, fields [][]byte) bool {
+	if !s.lastID.Less(id) {
+		return false
+	}
+	entry := &StreamEntry{ID: id, Fields: fields}
+	s.entries.Set(id.radixKey(), entry)
+	s.lastID = id
+	s.cost += entry.Cost()
+	return true
+}
+
+// Delete 删除指定 ID 的消息，如果消息不存在将返回 false
+func (s *Stream) Delete(id StreamID) bool {
+	entry, ok := s.entries.Delete(id.radixKey())
+	if !ok {
+		return false
+	}
+	s.cost -= entry.Cost()
+	return true
+}
+
+// Range 按照升序返回 ID 在 [start, end] 范围内的最多 count 条消息，count 小于等于 0 时不限制数量
+func (s *Stream) Range(start, end StreamID, count int) []*StreamEntry {
+	res := make([]*StreamEntry, 0)
+	if end.Less(start) {
+		return res
+	}
+	s.entries.Ascend(start.radixKey(), func(_ string, entry *StreamEntry) bool {
+		if end.Less(entry.ID) || (count > 0 && len(res) >= count) {
+			return false
+		}
+		res = append(res, entry)
+		return true
+	})
+	return res
+}
+
+// RevRange 按照降序返回 ID 在 [start, end] 范围内的最多 count 条消息，count 小于等于 0 时不限制数量
+func (s *Stream) RevRange(end, start StreamID, count int) []*StreamEntry {
+	res := make([]*StreamEntry, 0)
+	if end.Less(start) {
+		return res
+	}
+	s.entries.Descend(end.radixKey(), func(_ string, entry *StreamEntry) bool {
+		if entry.ID.Less(start) || (count > 0 && len(res) >= count) {
+			return false
+		}
+		res = append(res, entry)
+		return true
+	})
+	return res
+}
+
+// trim 从最小的消息开始删除，直到 stop 返回 true 或删除了 limit 条消息，limit 小于等于 0 时不限制数量
+func (s *Stream) trim(limit int, stop func(entry *StreamEntry) bool) int {
+	trimmed := 0
+	for limit <= 0 || trimmed < limit {
+		_, entry, ok := s.entries.Min()
+		if !ok || stop(entry) {
+			break
+		}
+		s.Delete(entry.ID)
+		trimmed++
+	}
+	return trimmed
+}
+
+// TrimByLen 删除最旧的消息直到消息数量不超过 maxLen，返回被删除的消息数量
+func (s *Stream) TrimByLen(maxLen, limit int) int {
+	return s.trim(limit, func(_ *StreamEntry) bool {
+		return s.entries.Len() <= maxLen
+	})
+}
+
+// TrimByMinID 删除所有 ID 小于 minID 的消息，返回被删除的消息数量
+func (s *Stream) TrimByMinID(minID StreamID, limit int) int {
+	return s.trim(limit, func(entry *StreamEntry) bool {
+		return !entry.ID.Less(minID)
+	})
+}
+
+// CreateGroup 创建一个消费组，如果消费组已经存在将返回 false
+func (s *Stream) CreateGroup(name string, lastID StreamID) bool {
+	if _, ok := s.groups[name]; ok {
+		return false
+	}
+	s.groups[name] = newStreamGroup(name, lastID, s)
+	s.cost += streamGroupBasicCost + int64(len(name))
+	return true
+}
+
+// DestroyGroup 删除一个消费组，如果消费组不存在将返回 false
+func (s *Stream) DestroyGroup(name string) bool {
+	g, ok := s.groups[name]
+	if !ok {
+		return false
+	}
+	for _, c := range g.consumers {
+		g.DeleteConsumer(c.Name)
+	}
+	delete(s.groups, name)
+	s.cost -= streamGroupBasicCost + int64(len(name))
+	return true
+}
+
+// Group 返回指定名称的消费组，如果不存在将返回 false
+func (s *Stream) Group(name string) (*StreamGroup, bool) {
+	g, ok := s.groups[name]
+	return g, ok
+}
+
+// Groups 返回按照名称排序的所有消费组
+func (s *Stream) Groups() []*StreamGroup {
+	groups := make([]*StreamGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+func (s *Stream) Cost() int64 {
+	return s.cost
+}
@@ -0,0 +1,199 @@
+package structure
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestStreamID(t *testing.T) {
+
+	tests := []struct {
+		input      string
+		defaultSeq uint64
+		expected   StreamID
+		err        error
+	}{
+		{"1-2", 0, StreamID{1, 2}, nil},
+		{"1", 0, StreamID{1, 0}, nil},
+		{"1", math.MaxUint64, StreamID{1, math.MaxUint64}, nil},
+		{"18446744073709551615-18446744073709551615", 0, MaxStreamID, nil},
+		{"a-1", 0, StreamID{}, ErrInvalidStreamID},
+		{"1-a", 0, StreamID{}, ErrInvalidStreamID},
+		{"-1", 0, StreamID{}, ErrInvalidStreamID},
+	}
+
+	for _, test := range tests {
+		id, err := ParseStreamID(test.input, test.defaultSeq)
+		assert.Equal(t, test.err, err, test.input)
+		assert.Equal(t, test.expected, id, test.input)
+	}
+
+	assert.Equal(t, "1-2", StreamID{1, 2}.String())
+	assert.True(t, StreamID{1, 2}.Less(StreamID{2, 0}))
+	assert.True(t, StreamID{1, 2}.Less(StreamID{1, 3}))
+	assert.False(t, StreamID{1, 2}.Less(StreamID{1, 2}))
+
+	// 基数树中的键顺序需要与 ID 的大小顺序一致
+	assert.Less(t, StreamID{1, math.MaxUint64}.radixKey(), StreamID{2, 0}.radixKey())
+	assert.Less(t, StreamID{255, 0}.radixKey(), StreamID{256, 0}.radixKey())
+
+	next, ok := StreamID{1, math.MaxUint64}.Incr()
+	assert.True(t, ok)
+	assert.Equal(t, StreamID{2, 0}, next)
+	_, ok = MaxStreamID.Incr()
+	assert.False(t, ok)
+
+	prev, ok := StreamID{2, 0}.Decr()
+	assert.True(t, ok)
+	assert.Equal(t, StreamID{1, math.MaxUint64}, prev)
+	_, ok = MinStreamID.Decr()
+	assert.False(t, ok)
+}
+
+func TestStream(t *testing.T) {
+
+	s := NewStream()
+	basic := s.Cost()
+
+	id, ok := s.NextID(5)
+	assert.True(t, ok)
+	assert.Equal(t, StreamID{5, 0}, id)
+	assert.True(t, s.Add(id, [][]byte{[]byte("f"), []byte("v")}))
+
+	// 时间回拨时序列号自增
+	id, ok = s.NextID(3)
+	assert.True(t, ok)
+	assert.Equal(t, StreamID{5, 1}, id)
+	assert.True(t, s.Add(id, [][]byte{[]byte("f"), []byte("v")}))
+
+	assert.False(t, s.Add(StreamID{5, 1}, nil))
+	assert.False(t, s.Add(StreamID{4, 0}, nil))
+
+	for i := uint64(6); i <= 10; i++ {
+		assert.True(t, s.Add(StreamID{i, 0}, [][]byte{[]byte("f"), []byte("v")}))
+	}
+	assert.Equal(t, 7, s.Len())
+	assert.Equal(t, StreamID{10, 0}, s.LastID())
+
+	ids := func(entries []*StreamEntry) []StreamID {
+		res := make([]StreamID, len(entries))
+		for i := range entries {
+			res[i] = entries[i].ID
+		}
+		return res
+	}
+
+	assert.Equal(t, []StreamID{{5, 0}, {5, 1}, {6, 0}}, ids(s.Range(MinStreamID, MaxStreamID, 3)))
+	assert.Equal(t, []StreamID{{6, 0}, {7, 0}}, ids(s.Range(StreamID{5, 2}, StreamID{7, 0}, 0)))
+	assert.Equal(t, []StreamID{{10, 0}, {9, 0}}, ids(s.RevRange(MaxStreamID, MinStreamID, 2)))
+	assert.Equal(t, []StreamID{{7, 0}, {6, 0}}, ids(s.RevRange(StreamID{7, 5}, StreamID{6, 0}, 0)))
+	assert.Empty(t, s.Range(StreamID{8, 0}, StreamID{7, 0}, 0))
+
+	assert.True(t, s.Delete(StreamID{10, 0}))
+	assert.False(t, s.Delete(StreamID{10, 0}))
+	// 删除消息后 LastID 不会减小
+	assert.Equal(t, StreamID{10, 0}, s.LastID())
+	assert.False(t, s.SetLastID(StreamID{8, 0}))
+	assert.True(t, s.SetLastID(StreamID{20, 0}))
+
+	assert.Equal(t, 1, s.TrimByLen(5, 0))
+	assert.Equal(t, []StreamID{{5, 1}, {6, 0}, {7, 0}, {8, 0}, {9, 0}}, ids(s.Range(MinStreamID, MaxStreamID, 0)))
+	assert.Equal(t, 1, s.TrimByMinID(StreamID{7, 0}, 1))
+	assert.Equal(t, 1, s.TrimByMinID(StreamID{7, 0}, 0))
+	assert.Equal(t, 3, s.Len())
+
+	for _, entry := range s.Range(MinStreamID, MaxStreamID, 0) {
+		s.Delete(entry.ID)
+	}
+	assert.Equal(t, basic, s.Cost())
+}
+
+func TestStreamGroup(t *testing.T) {
+
+	s := NewStream()
+	for i := uint64(1); i <= 5; i++ {
+		s.Add(StreamID{i, 0}, [][]byte{[]byte("f"), []byte("v")})
+	}
+	basic := s.Cost()
+
+	assert.True(t, s.CreateGroup("g", MinStreamID))
+	assert.False(t, s.CreateGroup("g", MinStreamID))
+	g, ok := s.Group("g")
+	assert.True(t, ok)
+
+	// 新消息只会投递一次
+	entries := g.ReadNew("c1", 2, false, 100)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, StreamID{2, 0}, g.LastID)
+	entries = g.ReadNew("c2", 0, false, 200)
+	assert.Len(t, entries, 3)
+	assert.Empty(t, g.ReadNew("c2", 0, false, 200))
+	assert.Equal(t, 5, g.PendingSize())
+
+	c1, ok := g.Consumer("c1")
+	assert.True(t, ok)
+	assert.Equal(t, 2, c1.PendingSize())
+
+	// 历史消息
+	entries = g.ReadHistory("c1", MinStreamID, 0, 300)
+	assert.Len(t, entries, 2)
+	s.Delete(StreamID{1, 0})
+	entries = g.ReadHistory("c1", MinStreamID, 0, 300)
+	assert.Nil(t, entries[0].Fields)
+	assert.Len(t, g.ReadHistory("c1", StreamID{1, 0}, 0, 300), 1)
+
+	// PEL 查询
+	assert.Len(t, g.PendingRange(MinStreamID, MaxStreamID, 0, "", 0, 300), 5)
+	assert.Len(t, g.PendingRange(MinStreamID, MaxStreamID, 2, "", 0, 300), 2)
+	assert.Len(t, g.PendingRange(MinStreamID, MaxStreamID, 0, "c2", 0, 300), 3)
+	assert.Len(t, g.PendingRange(MinStreamID, MaxStreamID, 0, "", 150, 300), 2)
+	assert.Empty(t, g.PendingRange(MinStreamID, MaxStreamID, 0, "c3", 0, 300))
+
+	// 转移所有权
+	_, ok = g.Claim(StreamID{2, 0}, "c2", 1000, false, 300)
+	assert.False(t, ok)
+	pe, ok := g.Claim(StreamID{2, 0}, "c2", 100, false, 300)
+	assert.True(t, ok)
+	assert.Equal(t, "c2", pe.Consumer.Name)
+	assert.Equal(t, int64(100), pe.DeliveryTime)
+	assert.Equal(t, 1, c1.PendingSize())
+
+	// 已经删除的消息将从 PEL 中移除
+	_, ok = g.Claim(StreamID{1, 0}, "c2", 0, false, 300)
+	assert.False(t, ok)
+	assert.Equal(t, 4, g.PendingSize())
+	assert.Equal(t, 0, c1.PendingSize())
+
+	assert.True(t, g.Ack(StreamID{2, 0}))
+	assert.False(t, g.Ack(StreamID{2, 0}))
+	assert.Equal(t, 3, g.PendingSize())
+
+	_, ok = g.Claim(StreamID{2, 0}, "c1", 0, false, 400)
+	assert.False(t, ok)
+	pe, ok = g.Claim(StreamID{2, 0}, "c1", 0, true, 400)
+	assert.True(t, ok)
+	assert.Equal(t, int64(400), pe.DeliveryTime)
+
+	// NOACK 不会加入 PEL
+	s.Add(StreamID{6, 0}, nil)
+	assert.Len(t, g.ReadNew("c3", 0, true, 500), 1)
+	assert.Equal(t, 4, g.PendingSize())
+
+	assert.Equal(t, []string{"c1", "c2", "c3"}, func() []string {
+		var names []string
+		for _, c := range g.Consumers() {
+			names = append(names, c.Name)
+		}
+		return names
+	}())
+
+	assert.Equal(t, 3, g.DeleteConsumer("c2"))
+	assert.Equal(t, -1, g.DeleteConsumer("c2"))
+	assert.Equal(t, 1, g.PendingSize())
+
+	assert.True(t, s.DestroyGroup("g"))
+	assert.False(t, s.DestroyGroup("g"))
+	s.Delete(StreamID{6, 0})
+	assert.Equal(t, basic-(&StreamEntry{ID: StreamID{1, 0}, Fields: [][]byte{[]byte("f"), []byte("v")}}).Cost(), s.Cost())
+}
@@ -35,6 +35,17 @@ func (i Float32) Value() float32 {
 	return float32(i)
 }
 
+// Float64 是对 float64 的封装
+type Float64 float64
+
+func (Float64) Cost() int64 {
+	return 8
+}
+
+func (i Float64) Value() float64 {
+	return float64(i)
+}
+
 // Slice 是对 []byte 的封装
 type Slice []byte
 
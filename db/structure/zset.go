@@ -15,15 +15,19 @@ func NewZSet() *ZSet {
 }
 
 // Add 插入一个键并设置权重，若键已存在，覆盖原有的权重
-func (zset *ZSet) Add(score Float32, key string) {
+func (zset *ZSet) Add(score Float64, key string) {
 
 	old, exist := zset.dict.Get(key)
 
 	if exist {
 
+		if old.(Float64) == score {
+			return
+		}
+
 		// 如果存在则需要先删除跳跃表中原来的键值对
 		zset.dict.Set(key, score)
-		zset.skipList.Delete(old.(Float32))
+		zset.skipList.Delete(old.(Float64), String(key))
 		zset.skipList.Insert(score, String(key))
 
 	} else {
@@ -33,7 +37,7 @@ func (zset *ZSet) Add(score Float32, key string) {
 }
 
 // AddIfNotExist 插入一个键并设置权重，若键已存在，返回 false
-func (zset *ZSet) AddIfNotExist(score Float32, key string) bool {
+func (zset *ZSet) AddIfNotExist(score Float64, key string) bool {
 
 	_, exist := zset.dict.Get(key)
 
@@ -56,7 +60,7 @@ func (zset *ZSet) Delete(key string) bool {
 		return false
 	}
 
-	zset.skipList.Delete(score.(Float32))
+	zset.skipList.Delete(score.(Float64), String(key))
 	return true
 }
 
@@ -66,19 +70,19 @@ func (zset *ZSet) Size() int {
 }
 
 // GetScoreByKey 返回键的权重，若键不存在，返回 -1,false
-func (zset *ZSet) GetScoreByKey(key string) (Float32, bool) {
+func (zset *ZSet) GetScoreByKey(key string) (Float64, bool) {
 
 	score, ok := zset.dict.Get(key)
 	if !ok {
 		return -1, false
 	}
-	return score.(Float32), ok
+	return score.(Float64), ok
 }
 
-// GetKeysByRange 返回权重范围内的所有键以及数量
-func (zset *ZSet) GetKeysByRange(min, max Float32) ([]string, int) {
+// GetKeysByRange 返回权重范围内的所有键以及数量，权重相同的键按照字典序排列
+func (zset *ZSet) GetKeysByRange(r ScoreRange) ([]string, int) {
 
-	values, size := zset.skipList.Range(min, max)
+	values, size := zset.skipList.Range(r)
 	keys := make([]string, size)
 	for i := 0; i < size; i++ {
 		keys[i] = string(values[i].(String))
@@ -88,17 +92,21 @@ func (zset *ZSet) GetKeysByRange(min, max Float32) ([]string, int) {
 }
 
 // CountByRange 返回权重范围内所有键的数量
-func (zset *ZSet) CountByRange(min, max Float32) int {
-	return zset.skipList.CountByRange(min, max)
+func (zset *ZSet) CountByRange(r ScoreRange) int {
+	return zset.skipList.CountByRange(r)
 }
 
-// PosByScore 获取权重值的排序位置，若权重不存在，返回-1
-func (zset *ZSet) PosByScore(score Float32) int {
-	return zset.skipList.GetPosByKey(score)
+// Rank 获取键的排序位置，若键不存在，返回 -1
+func (zset *ZSet) Rank(key string) int {
+	score, ok := zset.dict.Get(key)
+	if !ok {
+		return -1
+	}
+	return zset.skipList.Rank(score.(Float64), String(key))
 }
 
 // ReviseScore 修改键的权重值，若键不存在，返回 false
-func (zset *ZSet) ReviseScore(key string, score Float32) bool {
+func (zset *ZSet) ReviseScore(key string, score Float64) bool {
 	old, exist := zset.dict.Get(key)
 
 	if !exist {
@@ -107,17 +115,18 @@ func (zset *ZSet) ReviseScore(key string, score Float32) bool {
 
 	}
 
-	if old.(Float32) == score {
+	if old.(Float64) == score {
 		return true
 	}
 
-	zset.skipList.Delete(old.(Float32))
+	zset.dict.Set(key, score)
+	zset.skipList.Delete(old.(Float64), String(key))
 	zset.skipList.Insert(score, String(key))
 	return true
 }
 
 // IncrScore 将键的权重值增值指定的 increment，若键不存在，返回 false
-func (zset *ZSet) IncrScore(key string, increment Float32) (Float32, bool) {
+func (zset *ZSet) IncrScore(key string, increment Float64) (Float64, bool) {
 	old, exist := zset.dict.Get(key)
 
 	if !exist {
@@ -125,13 +134,13 @@ func (zset *ZSet) IncrScore(key string, increment Float32) (Float32, bool) {
 	}
 
 	if increment == 0 {
-		return old.(Float32), true
+		return old.(Float64), true
 	}
 
-	zset.dict.Set(key, old.(Float32)+increment)
-	zset.skipList.Delete(old.(Float32))
-	zset.skipList.Insert(increment+old.(Float32), String(key))
-	return increment + old.(Float32), true
+	zset.dict.Set(key, old.(Float64)+increment)
+	zset.skipList.Delete(old.(Float64), String(key))
+	zset.skipList.Insert(increment+old.(Float64), String(key))
+	return increment + old.(Float64), true
 }
 
 // DeleteRange 删除指定位置范围内的所有键，并返回删除数量
@@ -146,8 +155,8 @@ func (zset *ZSet) DeleteRange(start, end int) int {
 }
 
 // DeleteRangeByScore 删除权重范围内的所有键，返回删除数量
-func (zset *ZSet) DeleteRangeByScore(min, max Float32) int {
-	keys, deleted := zset.skipList.DeleteRange(min, max)
+func (zset *ZSet) DeleteRangeByScore(r ScoreRange) int {
+	keys, deleted := zset.skipList.DeleteRange(r)
 
 	for _, key := range keys {
 		zset.dict.Delete(string(key.(String)))
@@ -156,11 +165,51 @@ func (zset *ZSet) DeleteRangeByScore(min, max Float32) int {
 	return deleted
 }
 
+// PopMin 删除并返回权重最小的 count 个键以及对应的权重，结果按照权重从小到大排列
+func (zset *ZSet) PopMin(count int) ([]string, []Float64) {
+	return zset.popRange(0, count-1, false)
+}
+
+// PopMax 删除并返回权重最大的 count 个键以及对应的权重，结果按照权重从大到小排列
+func (zset *ZSet) PopMax(count int) ([]string, []Float64) {
+	return zset.popRange(-count, -1, true)
+}
+
+func (zset *ZSet) popRange(start, end int, reverse bool) ([]string, []Float64) {
+	if end-start < 0 {
+		return nil, nil
+	}
+	objs, n := zset.skipList.Pos(start, end)
+	keys := make([]string, n)
+	scores := make([]Float64, n)
+
+	for i, obj := range objs {
+		pos := i
+		if reverse {
+			pos = n - i - 1
+		}
+		keys[pos] = string(obj.(String))
+		score, _ := zset.dict.Get(keys[pos])
+		scores[pos] = score.(Float64)
+	}
+
+	zset.DeleteRange(start, end)
+
+	return keys, scores
+}
+
 // Pos 返回指定位置范围内的所有键
 func (zset *ZSet) Pos(start, end int) ([]Object, int) {
 	return zset.skipList.Pos(start, end)
 }
 
+// Scan 使用游标遍历 ZSet 中的键以及权重，用法与 Dict.Scan 相同
+func (zset *ZSet) Scan(cursor, count int, fn func(key string, score Float64)) int {
+	return zset.dict.Scan(cursor, count, func(key string, value Object) {
+		fn(key, value.(Float64))
+	})
+}
+
 func (zset *ZSet) Cost() int64 {
 	return zset.skipList.Cost() + zset.dict.Cost()
 }
@@ -10,7 +10,7 @@ func TestZSet(t *testing.T) {
 	zset := NewZSet()
 
 	zset.Add(1.1, "k1")
-	assert.Equal(t, 0, zset.PosByScore(1.1))
+	assert.Equal(t, 0, zset.Rank("k1"))
 	assert.False(t, zset.AddIfNotExist(1.2, "k1"))
 	assert.True(t, zset.AddIfNotExist(1.2, "k2"))
 
@@ -20,11 +20,11 @@ func TestZSet(t *testing.T) {
 
 	assert.False(t, zset.Delete("k3"))
 
-	keys, n := zset.GetKeysByRange(0, 1.1)
+	keys, n := zset.GetKeysByRange(ScoreRange{Min: 0, Max: 1.1})
 	assert.Equal(t, 1, n)
 	assert.Equal(t, []string{"k1"}, keys)
 
-	assert.Equal(t, 2, zset.CountByRange(0, 100))
+	assert.Equal(t, 2, zset.CountByRange(ScoreRange{Min: 0, Max: 100}))
 
 	assert.True(t, zset.ReviseScore("k1", 1.3))
 	assert.False(t, zset.ReviseScore("k43", 1.3))
@@ -34,24 +34,49 @@ func TestZSet(t *testing.T) {
 	assert.Equal(t, 0, zset.Size())
 
 	zset.Add(1.1, "k1")
-	assert.Zero(t, zset.DeleteRangeByScore(1.2, 100))
+	assert.Zero(t, zset.DeleteRangeByScore(ScoreRange{Min: 1.2, Max: 100}))
 
-	assert.Equal(t, 1, zset.DeleteRangeByScore(1, 1.1))
+	assert.Equal(t, 1, zset.DeleteRangeByScore(ScoreRange{Min: 1, Max: 1.1}))
 
 	zset.Add(1.1, "k1")
-	zset.Add(1.3, "k1")
+	zset.Add(1.5, "k1")
 	score, ok := zset.GetScoreByKey("k5")
 	assert.False(t, ok)
 	score, ok = zset.GetScoreByKey("k1")
 	assert.True(t, ok)
-	assert.Equal(t, Float32(1.3), score)
+	assert.Equal(t, Float64(1.5), score)
 
 	score, ok = zset.IncrScore("k5", 1)
 	assert.False(t, ok)
 
 	score, ok = zset.IncrScore("k1", 1)
 	assert.True(t, ok)
-	assert.Equal(t, Float32(2.3), score)
+	assert.Equal(t, Float64(2.5), score)
 
 	assert.True(t, zset.Delete("k1"))
+
+	// 权重相同的键按照字典序排列，删除与修改不会影响其他键
+	zset.Add(1, "b")
+	zset.Add(1, "a")
+	zset.Add(1, "c")
+	assert.Equal(t, 2, zset.Rank("c"))
+	assert.True(t, zset.ReviseScore("a", 2))
+	score, _ = zset.GetScoreByKey("a")
+	assert.Equal(t, Float64(2), score)
+	assert.True(t, zset.Delete("b"))
+	objs, n = zset.Pos(0, -1)
+	assert.Equal(t, []Object{String("c"), String("a")}, objs)
+
+	// pop 按照权重顺序删除并返回键
+	zset.Add(3, "d")
+	zset.Add(0, "e")
+	keys, scores := zset.PopMin(2)
+	assert.Equal(t, []string{"e", "c"}, keys)
+	assert.Equal(t, []Float64{0, 1}, scores)
+	keys, scores = zset.PopMax(5)
+	assert.Equal(t, []string{"d", "a"}, keys)
+	assert.Equal(t, []Float64{3, 2}, scores)
+	assert.Equal(t, 0, zset.Size())
+	keys, _ = zset.PopMin(0)
+	assert.Empty(t, keys)
 }
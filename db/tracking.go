@@ -0,0 +1,130 @@
+package db
+
+import (
+	"github.com/gofrs/uuid"
+	"strings"
+)
+
+// Invalidator 用于向客户端发送缓存失效通知，key 为 nil 代表客户端需要清空所有缓存
+type Invalidator func(id uuid.UUID, key []byte)
+
+// TrackingTable 记录客户端缓存所依赖的键，用于实现客户端缓存。所有数据库共享同一个 TrackingTable。
+//
+// 默认模式下记录客户端在只读命令中读取过的键，键被修改后只会通知一次，客户端再次读取后才会重新记录；
+// 广播模式下客户端注册一组前缀，任意匹配前缀的键被修改时都会通知客户端
+type TrackingTable struct {
+	keys       map[string]map[uuid.UUID]struct{} // 默认模式下被客户端读取过的键
+	prefixes   map[string]map[uuid.UUID]struct{} // 广播模式下注册的前缀，空前缀匹配所有键
+	reader     uuid.UUID                         // 当前正在执行只读命令的客户端，uuid.Nil 代表不需要记录
+	invalidate Invalidator
+}
+
+func NewTrackingTable() *TrackingTable {
+	return &TrackingTable{
+		keys:     make(map[string]map[uuid.UUID]struct{}),
+		prefixes: make(map[string]map[uuid.UUID]struct{}),
+	}
+}
+
+// SetInvalidator 设置发送失效通知的函数
+func (t *TrackingTable) SetInvalidator(fn Invalidator) {
+	t.invalidate = fn
+}
+
+// SetReader 设置当前正在执行只读命令的客户端，之后读取的键都会被记录到该客户端下，返回之前的客户端。
+// id 为 uuid.Nil 时停止记录
+func (t *TrackingTable) SetReader(id uuid.UUID) uuid.UUID {
+	prev := t.reader
+	t.reader = id
+	return prev
+}
+
+// TrackPrefixes 以广播模式追踪匹配 prefixes 的所有键
+func (t *TrackingTable) TrackPrefixes(id uuid.UUID, prefixes []string) {
+	for _, prefix := range prefixes {
+		ids, exist := t.prefixes[prefix]
+		if !exist {
+			ids = make(map[uuid.UUID]struct{})
+			t.prefixes[prefix] = ids
+		}
+		ids[id] = struct{}{}
+	}
+}
+
+// Untrack 取消客户端在 prefixes 上的广播追踪。默认模式下记录的键不会立即删除，它们会在键被修改时清理
+func (t *TrackingTable) Untrack(id uuid.UUID, prefixes []string) {
+	for _, prefix := range prefixes {
+		ids, exist := t.prefixes[prefix]
+		if !exist {
+			continue
+		}
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+}
+
+// TrackedKeys 返回默认模式下记录的键数量
+func (t *TrackingTable) TrackedKeys() int {
+	return len(t.keys)
+}
+
+// remember 记录当前客户端读取了 key
+func (t *TrackingTable) remember(key string) {
+	if t == nil || t.reader == uuid.Nil {
+		return
+	}
+	ids, exist := t.keys[key]
+	if !exist {
+		ids = make(map[uuid.UUID]struct{})
+		t.keys[key] = ids
+	}
+	ids[t.reader] = struct{}{}
+}
+
+// keyRevised 通知所有缓存了 key 的客户端
+func (t *TrackingTable) keyRevised(key string) {
+	if t == nil || t.invalidate == nil {
+		return
+	}
+
+	if ids, exist := t.keys[key]; exist {
+		delete(t.keys, key)
+		for id := range ids {
+			t.invalidate(id, []byte(key))
+		}
+	}
+
+	for prefix, ids := range t.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			for id := range ids {
+				t.invalidate(id, []byte(key))
+			}
+		}
+	}
+}
+
+// flushed 通知所有开启追踪的客户端清空缓存，用于 flushdb 以及 flushall 命令
+func (t *TrackingTable) flushed() {
+	if t == nil || t.invalidate == nil {
+		return
+	}
+
+	notified := make(map[uuid.UUID]struct{})
+	for _, ids := range t.keys {
+		for id := range ids {
+			notified[id] = struct{}{}
+		}
+	}
+	for _, ids := range t.prefixes {
+		for id := range ids {
+			notified[id] = struct{}{}
+		}
+	}
+	t.keys = make(map[string]map[uuid.UUID]struct{})
+
+	for id := range notified {
+		t.invalidate(id, nil)
+	}
+}
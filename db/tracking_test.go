@@ -0,0 +1,79 @@
+package db
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/db/structure"
+	"testing"
+)
+
+func TestTrackingTable(t *testing.T) {
+
+	table := NewTrackingTable()
+	db := NewDataBase(1, WithTracking(table))
+
+	id1, id2 := uuid.Must(uuid.NewV1()), uuid.Must(uuid.NewV1())
+
+	type invalidation struct {
+		id  uuid.UUID
+		key []byte
+	}
+	received := make([]invalidation, 0)
+	table.SetInvalidator(func(id uuid.UUID, key []byte) {
+		received = append(received, invalidation{id, key})
+	})
+
+	db.SetKey("k1", structure.Slice("v"))
+	db.SetKey("k2", structure.Slice("v"))
+	assert.Empty(t, received)
+
+	// 只有设置了 reader 之后的读取会被记录
+	db.GetKey("k1")
+	assert.Equal(t, 0, table.TrackedKeys())
+	prev := table.SetReader(id1)
+	assert.Equal(t, uuid.Nil, prev)
+	db.GetKey("k1")
+	db.ExistKey("k2")
+	table.SetReader(prev)
+	assert.Equal(t, 2, table.TrackedKeys())
+
+	// 默认模式下只通知一次
+	db.SetKey("k1", structure.Slice("v2"))
+	assert.Equal(t, []invalidation{{id1, []byte("k1")}}, received)
+	db.SetKey("k1", structure.Slice("v3"))
+	assert.Len(t, received, 1)
+
+	// 读取不存在的键同样会被记录，键被创建时需要通知
+	table.SetReader(id1)
+	_, ok := db.GetKey("missing")
+	assert.False(t, ok)
+	table.SetReader(uuid.Nil)
+	received = received[:0]
+	db.SetKey("missing", structure.Slice("v"))
+	assert.Equal(t, []invalidation{{id1, []byte("missing")}}, received)
+	received = received[:0]
+
+	// 广播模式
+	table.TrackPrefixes(id2, []string{"k", "user:"})
+	received = received[:0]
+	db.DeleteKey("k2")
+	assert.ElementsMatch(t, []invalidation{{id1, []byte("k2")}, {id2, []byte("k2")}}, received)
+
+	received = received[:0]
+	db.SetKey("user:1", structure.Slice("v"))
+	db.SetKey("other", structure.Slice("v"))
+	assert.Equal(t, []invalidation{{id2, []byte("user:1")}}, received)
+
+	received = received[:0]
+	table.SetReader(id1)
+	db.GetKey("other")
+	table.SetReader(uuid.Nil)
+	db.ReviseNotifyAll()
+	assert.ElementsMatch(t, []invalidation{{id1, nil}, {id2, nil}}, received)
+	assert.Equal(t, 0, table.TrackedKeys())
+
+	table.Untrack(id2, []string{"k", "user:"})
+	received = received[:0]
+	db.SetKey("user:2", structure.Slice("v"))
+	assert.Empty(t, received)
+}
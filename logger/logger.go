@@ -105,6 +105,11 @@ func ChangeConfig(dir string, filename string, level LogLevel) error {
 	return nil
 }
 
+// SetLevel 只修改日志等级，不改变日志的输出位置
+func SetLevel(level LogLevel) {
+	logcfg.Level = level
+}
+
 // Disable 用于禁止日志输出
 func Disable() {
 	logger.SetOutput(io.Discard)
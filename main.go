@@ -5,6 +5,7 @@ import (
 	"github.com/tangrc99/MemTable/config"
 	"github.com/tangrc99/MemTable/logger"
 	"github.com/tangrc99/MemTable/server"
+	"github.com/tangrc99/MemTable/server/global"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -33,6 +34,7 @@ func Help() {
 	fmt.Printf(format, "log-level <level>", "Start server with log level debug, info, warning, error or panic.")
 	fmt.Printf(format, "pprof <host:port>", "Run pprof tool with host:port.")
 	fmt.Printf(format, "watch-config", "Watch change of config file.")
+	fmt.Printf(format, "sentinel", "Start server in sentinel mode.")
 	fmt.Printf(format, "it", "Run in interactive mode.")
 	fmt.Printf(format, "help", "Output this help and exit.")
 	fmt.Printf(format, "version", "Output version.")
@@ -147,8 +149,12 @@ func main() {
 		panic(err.Error())
 	}
 	PrintRunInformation()
+	global.Version = Version
 	s := server.NewServer()
 	s.InitModules()
-	s.TryRecover()
+	// 哨兵不保存任何数据，不需要恢复
+	if !config.Conf.Sentinel {
+		s.TryRecover()
+	}
 	s.Start()
 }
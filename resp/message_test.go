@@ -3,6 +3,8 @@ package resp
 import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math"
+	"math/big"
 	"testing"
 )
 
@@ -105,3 +107,58 @@ func TestToReadableString(t *testing.T) {
 	fmt.Printf("%s\n", ToReadableString(adata2, ""))
 
 }
+
+func TestRESP3Data(t *testing.T) {
+
+	n, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+
+	tests := []struct {
+		data     RedisData
+		expected string
+		resp2    RedisData
+	}{
+		{MakeMapData([]RedisData{MakeBulkData([]byte("k")), MakeIntData(1)}),
+			"%1\r\n$1\r\nk\r\n:1\r\n",
+			MakeArrayData([]RedisData{MakeBulkData([]byte("k")), MakeIntData(1)})},
+
+		{MakeSetData([]RedisData{MakeBulkData([]byte("a")), MakeBooleanData(true)}),
+			"~2\r\n$1\r\na\r\n#t\r\n",
+			MakeArrayData([]RedisData{MakeBulkData([]byte("a")), MakeIntData(1)})},
+
+		{MakePushData([]RedisData{MakeBulkData([]byte("message")), MakeNullData()}),
+			">2\r\n$7\r\nmessage\r\n_\r\n",
+			MakeArrayData([]RedisData{MakeBulkData([]byte("message")), MakeBulkData(nil)})},
+
+		{MakeMultiData([]RedisData{MakePushData([]RedisData{MakeIntData(1)}), MakePushData([]RedisData{MakeNullData()})}),
+			">1\r\n:1\r\n>1\r\n_\r\n",
+			MakeMultiData([]RedisData{MakeArrayData([]RedisData{MakeIntData(1)}), MakeArrayData([]RedisData{MakeBulkData(nil)})})},
+
+		{MakeDoubleData(1.5), ",1.5\r\n", MakeBulkData([]byte("1.5"))},
+		{MakeDoubleData(16777217), ",16777217\r\n", MakeBulkData([]byte("16777217"))},
+		{MakeDoubleData(1234567), ",1234567\r\n", MakeBulkData([]byte("1234567"))},
+		{MakeDoubleData(1e17), ",1e+17\r\n", MakeBulkData([]byte("1e+17"))},
+		{MakeDoubleData(math.Inf(1)), ",inf\r\n", MakeBulkData([]byte("inf"))},
+		{MakeDoubleData(math.Inf(-1)), ",-inf\r\n", MakeBulkData([]byte("-inf"))},
+		{MakeNullData(), "_\r\n", MakeBulkData(nil)},
+		{MakeBooleanData(false), "#f\r\n", MakeIntData(0)},
+		{MakeVerbatimData("txt", []byte("hello")), "=9\r\ntxt:hello\r\n", MakeBulkData([]byte("hello"))},
+		{MakeBigNumberData(n), "(3492890328409238509324850943850943825024385\r\n",
+			MakeBulkData([]byte("3492890328409238509324850943850943825024385"))},
+
+		// 嵌套在数组中的数据同样需要降级
+		{MakeArrayData([]RedisData{MakeIntData(1), MakeDoubleData(2)}),
+			"*2\r\n:1\r\n,2\r\n",
+			MakeArrayData([]RedisData{MakeIntData(1), MakeBulkData([]byte("2"))})},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, []byte(test.expected), test.data.ToBytes())
+		assert.Equal(t, []byte(test.expected), Encode(test.data, RESP3))
+		assert.Equal(t, test.resp2, ToRESP2(test.data))
+		assert.Equal(t, test.resp2.ToBytes(), Encode(test.data, RESP2))
+	}
+
+	// 不需要降级的数据不会被拷贝
+	array := MakeArrayData([]RedisData{MakeIntData(1)})
+	assert.Same(t, array, ToRESP2(array))
+}
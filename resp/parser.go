@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/tangrc99/MemTable/logger"
 	"io"
+	"math/big"
 	"reflect"
 	"strconv"
 )
@@ -19,12 +20,45 @@ type ParsedRes struct {
 	Abort bool // 解析中发生无法恢复的错误
 }
 
+// aggregate 记录正在解析的聚合类型数据
+type aggregate struct {
+	typ  byte // 类型标识：'*'、'%'、'~'、'>'
+	size int  // 需要的元素数量，map 类型为键值对数量的两倍
+	data []RedisData
+}
+
+// build 使用已经解析的元素构造聚合类型数据
+func (agg *aggregate) build() RedisData {
+	switch agg.typ {
+	case '%':
+		return MakeMapData(agg.data)
+	case '~':
+		return MakeSetData(agg.data)
+	case '>':
+		return MakePushData(agg.data)
+	}
+	return MakeArrayData(agg.data)
+}
+
 type readState struct {
 	bulkLen   int64
-	arrayLen  int
+	bulkType  byte // 类型标识：'$'、'='
 	multiLine bool
-	arrayData *ArrayData
-	inArray   bool
+	stack     []*aggregate // 嵌套的聚合类型，栈顶为最内层
+}
+
+// collect 将解析出的数据加入到最内层的聚合类型中，当最外层的数据解析完成时返回该数据，否则返回 nil
+func (state *readState) collect(data RedisData) RedisData {
+	for len(state.stack) > 0 {
+		top := state.stack[len(state.stack)-1]
+		top.data = append(top.data, data)
+		if len(top.data) < top.size {
+			return nil
+		}
+		state.stack = state.stack[:len(state.stack)-1]
+		data = top.build()
+	}
+	return data
 }
 
 type Parser struct {
@@ -93,78 +127,38 @@ func (parser *Parser) Parse() *ParsedRes {
 			}
 		}
 		// parse the read messages
-		// if msg is an array or a bulk string, then parse their header first.
+		// if msg is an aggregate or a bulk string, then parse their header first.
 		// if msg is a normal line, parse it directly.
 		if !parser.state.multiLine {
 			// parse single line: no bulk string
 
-			if msg[0] == '*' {
-				err := parseArrayHeader(msg, parser.state)
-				if err != nil {
-					logger.Error(err)
-					*parser.state = readState{}
-
-					return &ParsedRes{
-						Err: err,
-					}
-				} else {
-					if parser.state.arrayLen == -1 {
-						// null array
-						*parser.state = readState{}
-						return &ParsedRes{
-							Data: MakeArrayData(nil),
-						}
-					} else if parser.state.arrayLen == 0 {
-						// empty array
-						*parser.state = readState{}
-						return &ParsedRes{
-							Data: MakeArrayData([]RedisData{}),
-						}
-					}
+			switch msg[0] {
+			case '*', '%', '~', '>':
+				res, err = parseAggregateHeader(msg, parser.state)
+				if err == nil && res == nil {
+					// 需要继续读取聚合类型中的元素
+					continue
 				}
-				continue
-			}
-
-			if msg[0] == '$' {
-				err := parseBulkHeader(msg, parser.state)
+			case '$', '=':
+				err = parseBulkHeader(msg, parser.state)
 				if err != nil {
-					logger.Error(err)
-					*parser.state = readState{}
-
-					return &ParsedRes{
-						Err: err,
-					}
-				} else {
-					if parser.state.bulkLen == -1 {
-						// null bulk string
-						parser.state.multiLine = false
-						parser.state.bulkLen = 0
-						res = MakeBulkData(nil)
-						if parser.state.inArray {
-							parser.state.arrayData.data = append(parser.state.arrayData.data, res)
-							if len(parser.state.arrayData.data) == parser.state.arrayLen {
-
-								return &ParsedRes{
-									Data: parser.state.arrayData,
-									Err:  nil,
-								}
-							}
-						} else {
-							return &ParsedRes{
-								Data: res,
-							}
-						}
-					}
+					break
+				}
+				if parser.state.bulkLen != -1 {
+					continue
 				}
-				continue
+				// null bulk string
+				parser.state.multiLine = false
+				parser.state.bulkLen = 0
+				res = MakeBulkData(nil)
+			default:
+				res, err = parseSingleLine(msg)
 			}
-
-			res, err = parseSingleLine(msg)
 		} else {
 			// parse multiple lines: bulk string (binary safe)
 			parser.state.multiLine = false
 			parser.state.bulkLen = 0
-			res, err = parseMultiLine(msg)
+			res, err = parseMultiLine(msg, parser.state.bulkType)
 		}
 
 		if err != nil {
@@ -177,19 +171,10 @@ func (parser *Parser) Parse() *ParsedRes {
 
 		}
 
-		// Struct parsed data as an array or a single data, and put it into channel.
-		if parser.state.inArray {
-			parser.state.arrayData.data = append(parser.state.arrayData.data, res)
-			if len(parser.state.arrayData.data) == parser.state.arrayLen {
-				return &ParsedRes{
-					Data: parser.state.arrayData,
-					Err:  nil,
-				}
-			}
-		} else {
+		// Struct parsed data as an aggregate or a single data, and return it when the outermost data is completed.
+		if data := parser.state.collect(res); data != nil {
 			return &ParsedRes{
-				Data: res,
-				Err:  err,
+				Data: data,
 			}
 		}
 	}
@@ -252,6 +237,32 @@ func parseSingleLine(msg []byte) (RedisData, error) {
 			return nil, err
 		}
 		res = MakeIntData(data)
+	case '_':
+		// null
+		if msgData != "" {
+			return nil, errors.New("Protocol error: " + string(msg))
+		}
+		res = MakeNullData()
+	case '#':
+		// boolean
+		if msgData != "t" && msgData != "f" {
+			return nil, errors.New("Protocol error: " + string(msg))
+		}
+		res = MakeBooleanData(msgData == "t")
+	case ',':
+		// double, strconv 能够解析 inf、-inf 和 nan
+		data, err := strconv.ParseFloat(msgData, 64)
+		if err != nil {
+			return nil, errors.New("Protocol error: " + string(msg))
+		}
+		res = MakeDoubleData(data)
+	case '(':
+		// big number
+		data, ok := new(big.Int).SetString(msgData, 10)
+		if !ok {
+			return nil, errors.New("Protocol error: " + string(msg))
+		}
+		res = MakeBigNumberData(data)
 	default:
 		// plain string
 		res = MakePlainData(string(msg[0 : len(msg)-2]))
@@ -263,33 +274,60 @@ func parseSingleLine(msg []byte) (RedisData, error) {
 	return res, nil
 }
 
-func parseMultiLine(msg []byte) (RedisData, error) {
+func parseMultiLine(msg []byte, bulkType byte) (RedisData, error) {
 	// discard "\r\n"
 	if len(msg) < 2 {
 		return nil, errors.New("protocol error: invalid bulk string")
 	}
 	msgData := msg[:len(msg)-2]
+
+	if bulkType == '=' {
+		// verbatim string 的格式为 xxx:data
+		if len(msgData) < 4 || msgData[3] != ':' {
+			return nil, errors.New("protocol error: invalid verbatim string")
+		}
+		return MakeVerbatimData(string(msgData[:3]), msgData[4:]), nil
+	}
+
 	res := MakeBulkData(msgData)
 	return res, nil
 }
 
-func parseArrayHeader(msg []byte, state *readState) error {
-	arrayLen, err := strconv.Atoi(string(msg[1 : len(msg)-2]))
-	if err != nil || arrayLen < -1 {
-		return errors.New("Protocol error: " + string(msg))
+// parseAggregateHeader 解析聚合类型的头部，如果聚合类型为空将直接返回对应的数据，否则将其压入栈中并返回 nil
+func parseAggregateHeader(msg []byte, state *readState) (RedisData, error) {
+	size, err := strconv.Atoi(string(msg[1 : len(msg)-2]))
+	if err != nil || size < -1 || (size == -1 && msg[0] != '*') {
+		return nil, errors.New("Protocol error: " + string(msg))
 	}
-	state.arrayLen = arrayLen
-	state.inArray = true
-	state.arrayData = MakeArrayData([]RedisData{})
-	return nil
+
+	agg := &aggregate{
+		typ:  msg[0],
+		size: size,
+		data: []RedisData{},
+	}
+
+	if size == -1 {
+		// null array
+		return MakeArrayData(nil), nil
+	}
+	if agg.typ == '%' {
+		agg.size *= 2
+	}
+	if agg.size == 0 {
+		return agg.build(), nil
+	}
+
+	state.stack = append(state.stack, agg)
+	return nil, nil
 }
 
 func parseBulkHeader(msg []byte, state *readState) error {
 	bulkLen, err := strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
-	if err != nil || bulkLen < -1 {
+	if err != nil || bulkLen < -1 || (bulkLen == -1 && msg[0] != '$') {
 		return errors.New("Protocol error: " + string(msg))
 	}
 	state.bulkLen = bulkLen
-	state.multiLine = true
+	state.bulkType = msg[0]
+	state.multiLine = bulkLen != -1
 	return nil
 }
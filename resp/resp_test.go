@@ -3,6 +3,8 @@ package resp
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/tangrc99/MemTable/logger"
+	"math"
+	"math/big"
 	"os"
 	"testing"
 )
@@ -303,3 +305,71 @@ func TestRespError9(t *testing.T) {
 	assert.False(t, ret1.Abort)
 
 }
+
+func TestRespRESP3(t *testing.T) {
+	rd, wr, err := os.Pipe()
+	assert.Nil(t, err)
+
+	parser := NewParser(rd)
+
+	tests := []struct {
+		msg      string
+		expected RedisData
+	}{
+		{"_\r\n", MakeNullData()},
+		{"#t\r\n", MakeBooleanData(true)},
+		{",3.14\r\n", MakeDoubleData(3.14)},
+		{",-inf\r\n", MakeDoubleData(math.Inf(-1))},
+		{"(12345678901234567890\r\n", MakeBigNumberData(big.NewInt(0).SetUint64(12345678901234567890))},
+		{"=9\r\ntxt:hello\r\n", MakeVerbatimData("txt", []byte("hello"))},
+		{"%0\r\n", MakeMapData([]RedisData{})},
+		{"%2\r\n+k1\r\n:1\r\n$2\r\nk2\r\n*2\r\n:2\r\n:3\r\n",
+			MakeMapData([]RedisData{
+				MakeStringData("k1"), MakeIntData(1),
+				MakeBulkData([]byte("k2")), MakeArrayData([]RedisData{MakeIntData(2), MakeIntData(3)}),
+			})},
+		{"~2\r\n:1\r\n~1\r\n_\r\n", MakeSetData([]RedisData{MakeIntData(1), MakeSetData([]RedisData{MakeNullData()})})},
+		{">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$-1\r\n",
+			MakePushData([]RedisData{MakeBulkData([]byte("message")), MakeBulkData([]byte("ch")), MakeBulkData(nil)})},
+		{"*2\r\n*-1\r\n*0\r\n", MakeArrayData([]RedisData{MakeArrayData(nil), MakeArrayData([]RedisData{})})},
+	}
+
+	for _, test := range tests {
+		n, err := wr.WriteString(test.msg)
+		assert.Nil(t, err)
+		assert.Equal(t, len(test.msg), n)
+
+		ret := parser.Parse()
+		assert.Nil(t, ret.Err, test.msg)
+		assert.Equal(t, test.expected, ret.Data, test.msg)
+		assert.Equal(t, []byte(test.msg), ret.Data.ToBytes(), test.msg)
+	}
+}
+
+func TestRespRESP3Error(t *testing.T) {
+
+	_ = logger.Init("", "", logger.PANIC)
+
+	rd, wr, err := os.Pipe()
+	assert.Nil(t, err)
+
+	parser := NewParser(rd)
+
+	msgs := []string{"#x\r\n", ",abc\r\n", "(12a\r\n", "=5\r\nhello\r\n", "%-1\r\n", "_x\r\n"}
+
+	for _, msg := range msgs {
+		_, err = wr.WriteString(msg)
+		assert.Nil(t, err)
+
+		ret := parser.Parse()
+		assert.NotNil(t, ret.Err, msg)
+		assert.False(t, ret.Abort)
+	}
+
+	// 出错后应该能够继续解析
+	_, err = wr.WriteString("#f\r\n")
+	assert.Nil(t, err)
+	ret := parser.Parse()
+	assert.Nil(t, ret.Err)
+	assert.Equal(t, MakeBooleanData(false), ret.Data)
+}
@@ -1,6 +1,8 @@
 package resp
 
 import (
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
 )
@@ -209,3 +211,330 @@ func (r *PlainData) ToArray() RedisData {
 
 	return MakeArrayData(lines)
 }
+
+// 以下为 RESP3 协议中新增的数据类型，使用 RESP2 协议的客户端会收到由 ToRESP2 降级后的数据
+
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// MapData 中的键值对按照 k1, v1, k2, v2 ... 的顺序保存
+type MapData struct {
+	data []RedisData
+}
+
+type SetData struct {
+	data []RedisData
+}
+
+type PushData struct {
+	data []RedisData
+}
+
+// MultiData 由多个依次发送的回包组成，本身没有类型标识
+type MultiData struct {
+	data []RedisData
+}
+
+type DoubleData struct {
+	data float64
+}
+
+type NullData struct{}
+
+type BooleanData struct {
+	data bool
+}
+
+// VerbatimData 是带有格式的字符串，格式固定为三个字符，如 txt、mkd
+type VerbatimData struct {
+	format string
+	data   []byte
+}
+
+type BigNumberData struct {
+	data *big.Int
+}
+
+// aggregateToBytes 使用类型标识 prefix 和元素数量 n 编码聚合类型
+func aggregateToBytes(prefix byte, n int, data []RedisData) []byte {
+	res := []byte(string(prefix) + strconv.Itoa(n) + CRLF)
+	for _, v := range data {
+		res = append(res, v.ToBytes()...)
+	}
+	return res
+}
+
+func aggregateByteData(data []RedisData) []byte {
+	res := make([]byte, 0)
+	for _, v := range data {
+		res = append(res, v.ByteData()...)
+	}
+	return res
+}
+
+// MakeMapData 创建一个 map 类型数据，pairs 中的元素需要按照 k1, v1, k2, v2 ... 的顺序排列
+func MakeMapData(pairs []RedisData) *MapData {
+	return &MapData{
+		data: pairs,
+	}
+}
+
+func (r *MapData) ToBytes() []byte {
+	return aggregateToBytes('%', len(r.data)/2, r.data)
+}
+
+// Data 返回按照 k1, v1, k2, v2 ... 顺序排列的键值对
+func (r *MapData) Data() []RedisData {
+	return r.data
+}
+
+func (r *MapData) ByteData() []byte {
+	return aggregateByteData(r.data)
+}
+
+func MakeSetData(data []RedisData) *SetData {
+	return &SetData{
+		data: data,
+	}
+}
+
+func (r *SetData) ToBytes() []byte {
+	return aggregateToBytes('~', len(r.data), r.data)
+}
+
+func (r *SetData) Data() []RedisData {
+	return r.data
+}
+
+func (r *SetData) ByteData() []byte {
+	return aggregateByteData(r.data)
+}
+
+// MakePushData 创建一个 push 类型数据，用于服务端主动推送的消息
+func MakePushData(data []RedisData) *PushData {
+	return &PushData{
+		data: data,
+	}
+}
+
+func (r *PushData) ToBytes() []byte {
+	return aggregateToBytes('>', len(r.data), r.data)
+}
+
+func (r *PushData) Data() []RedisData {
+	return r.data
+}
+
+func (r *PushData) ByteData() []byte {
+	return aggregateByteData(r.data)
+}
+
+// MakeMultiData 创建多个连续的回包，用于 psubscribe、ssubscribe 等需要对每个参数单独回复的命令
+func MakeMultiData(data []RedisData) *MultiData {
+	return &MultiData{
+		data: data,
+	}
+}
+
+func (r *MultiData) ToBytes() []byte {
+	res := make([]byte, 0)
+	for _, v := range r.data {
+		res = append(res, v.ToBytes()...)
+	}
+	return res
+}
+
+func (r *MultiData) Data() []RedisData {
+	return r.data
+}
+
+func (r *MultiData) ByteData() []byte {
+	return aggregateByteData(r.data)
+}
+
+// MakeDoubleData 返回值在客户端中具有 (double) 标识
+func MakeDoubleData(data float64) *DoubleData {
+	return &DoubleData{
+		data: data,
+	}
+}
+
+func (r *DoubleData) ToBytes() []byte {
+	return []byte("," + r.String() + CRLF)
+}
+
+func (r *DoubleData) Data() float64 {
+	return r.data
+}
+
+func (r *DoubleData) ByteData() []byte {
+	return []byte(r.String())
+}
+
+// String 返回 RESP3 协议中的浮点数格式，无穷和非数字分别使用 inf、-inf、nan 表示
+func (r *DoubleData) String() string {
+	switch {
+	case math.IsInf(r.data, 1):
+		return "inf"
+	case math.IsInf(r.data, -1):
+		return "-inf"
+	case math.IsNaN(r.data):
+		return "nan"
+	}
+	// 与 redis 一致，小于 1e17 的数值使用定点表示，超出范围时才使用指数形式
+	if abs := math.Abs(r.data); abs >= 1e17 || (abs != 0 && abs < 1e-4) {
+		return strconv.FormatFloat(r.data, 'g', -1, 64)
+	}
+	return strconv.FormatFloat(r.data, 'f', -1, 64)
+}
+
+func MakeNullData() *NullData {
+	return &NullData{}
+}
+
+func (r *NullData) ToBytes() []byte {
+	return []byte("_" + CRLF)
+}
+
+func (r *NullData) ByteData() []byte {
+	return nil
+}
+
+func MakeBooleanData(data bool) *BooleanData {
+	return &BooleanData{
+		data: data,
+	}
+}
+
+func (r *BooleanData) ToBytes() []byte {
+	if r.data {
+		return []byte("#t" + CRLF)
+	}
+	return []byte("#f" + CRLF)
+}
+
+func (r *BooleanData) Data() bool {
+	return r.data
+}
+
+func (r *BooleanData) ByteData() []byte {
+	if r.data {
+		return []byte("1")
+	}
+	return []byte("0")
+}
+
+// MakeVerbatimData 创建一个带格式的字符串，format 必须为三个字符
+func MakeVerbatimData(format string, data []byte) *VerbatimData {
+	return &VerbatimData{
+		format: format,
+		data:   data,
+	}
+}
+
+func (r *VerbatimData) ToBytes() []byte {
+	return []byte("=" + strconv.Itoa(len(r.data)+4) + CRLF + r.format + ":" + string(r.data) + CRLF)
+}
+
+func (r *VerbatimData) Format() string {
+	return r.format
+}
+
+func (r *VerbatimData) Data() []byte {
+	return r.data
+}
+
+func (r *VerbatimData) ByteData() []byte {
+	return r.data
+}
+
+func MakeBigNumberData(data *big.Int) *BigNumberData {
+	return &BigNumberData{
+		data: data,
+	}
+}
+
+func (r *BigNumberData) ToBytes() []byte {
+	return []byte("(" + r.data.String() + CRLF)
+}
+
+func (r *BigNumberData) Data() *big.Int {
+	return r.data
+}
+
+func (r *BigNumberData) ByteData() []byte {
+	return []byte(r.data.String())
+}
+
+// ToRESP2 将 RESP3 中新增的类型降级为 RESP2 中对应的类型，聚合类型中的元素会被递归地降级。
+// map、set、push 降级为数组，double、verbatim、big number 降级为 bulk string，
+// null 降级为空 bulk string，boolean 降级为整数 1 或 0
+func ToRESP2(data RedisData) RedisData {
+
+	switch d := data.(type) {
+
+	case *ArrayData:
+		if d.data == nil {
+			return d
+		}
+		for i := range d.data {
+			if ToRESP2(d.data[i]) != d.data[i] {
+				// 存在需要降级的元素时才需要拷贝
+				return MakeArrayData(toRESP2Slice(d.data))
+			}
+		}
+		return d
+	case *MapData:
+		return MakeArrayData(toRESP2Slice(d.data))
+	case *SetData:
+		return MakeArrayData(toRESP2Slice(d.data))
+	case *PushData:
+		return MakeArrayData(toRESP2Slice(d.data))
+	case *MultiData:
+		return MakeMultiData(toRESP2Slice(d.data))
+	case *DoubleData:
+		return MakeBulkData([]byte(d.String()))
+	case *NullData:
+		return MakeBulkData(nil)
+	case *BooleanData:
+		if d.data {
+			return MakeIntData(1)
+		}
+		return MakeIntData(0)
+	case *VerbatimData:
+		return MakeBulkData(d.data)
+	case *BigNumberData:
+		return MakeBulkData([]byte(d.data.String()))
+	}
+
+	return data
+}
+
+func toRESP2Slice(data []RedisData) []RedisData {
+	res := make([]RedisData, len(data))
+	for i := range data {
+		res[i] = ToRESP2(data[i])
+	}
+	return res
+}
+
+// Encode 根据协议版本 protocol 编码数据，RESP2 协议下会先对数据进行降级
+func Encode(data RedisData, protocol int) []byte {
+	if protocol != RESP3 {
+		data = ToRESP2(data)
+	}
+	return data.ToBytes()
+}
+
+// ArrayToPush 将编码后的数组修改为 push 类型，两者只有类型标识不同。用于将预先编码的发布订阅消息发送给 RESP3 客户端
+func ArrayToPush(msg []byte) []byte {
+	if len(msg) == 0 || msg[0] != '*' {
+		return msg
+	}
+	res := make([]byte, len(msg))
+	copy(res, msg)
+	res[0] = '>'
+	return res
+}
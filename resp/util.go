@@ -33,22 +33,65 @@ func ToReadableString(data RedisData, prefix string) string {
 		}
 		return fmt.Sprintf("\"%s\"", d)
 	case "*resp.ArrayData":
-		d := data.(*ArrayData)
+		return aggregateToReadableString(data.(*ArrayData).data, prefix)
+	case "*resp.SetData":
+		return aggregateToReadableString(data.(*SetData).data, prefix)
+	case "*resp.PushData":
+		return aggregateToReadableString(data.(*PushData).data, prefix)
+	case "*resp.MapData":
+		d := data.(*MapData).data
+		if len(d) == 0 {
+			return "(empty map)"
+		}
 		p := 0
-		for i := len(d.data); i != 0; i /= 10 {
+		for i := len(d) / 2; i != 0; i /= 10 {
 			p++
 		}
 		ret := ""
-		for i := range d.data {
-			if i == 0 {
-				ret += fmt.Sprintf("%d) %s\n", i, ToReadableString(d.data[i], prefix+strings.Repeat(" ", p+2)))
-			} else {
-				ret += fmt.Sprintf("%s%d) %s\n", prefix, i, ToReadableString(d.data[i], prefix+strings.Repeat(" ", p+2)))
+		for i := 0; i+1 < len(d); i += 2 {
+			line := fmt.Sprintf("%d# %s => %s\n", i/2+1, ToReadableString(d[i], prefix+strings.Repeat(" ", p+2)),
+				ToReadableString(d[i+1], prefix+strings.Repeat(" ", p+2)))
+			if i > 0 {
+				line = prefix + line
 			}
+			ret += line
 		}
 		return ret[:len(ret)-1]
+	case "*resp.DoubleData":
+		return "(double) " + data.(*DoubleData).String()
+	case "*resp.NullData":
+		return "(nil)"
+	case "*resp.BooleanData":
+		if data.(*BooleanData).Data() {
+			return "(true)"
+		}
+		return "(false)"
+	case "*resp.VerbatimData":
+		return string(data.(*VerbatimData).Data())
+	case "*resp.BigNumberData":
+		return "(big number) " + data.(*BigNumberData).Data().String()
 	case "*resp.PlainData":
 		return data.(*PlainData).Data()
 	}
 	return ""
 }
+
+// aggregateToReadableString 将数组类型的元素转化为可读的字符串，嵌套的元素会使用 prefix 进行缩进
+func aggregateToReadableString(data []RedisData, prefix string) string {
+	if len(data) == 0 {
+		return "(empty array)"
+	}
+	p := 0
+	for i := len(data); i != 0; i /= 10 {
+		p++
+	}
+	ret := ""
+	for i := range data {
+		if i == 0 {
+			ret += fmt.Sprintf("%d) %s\n", i, ToReadableString(data[i], prefix+strings.Repeat(" ", p+2)))
+		} else {
+			ret += fmt.Sprintf("%s%d) %s\n", prefix, i, ToReadableString(data[i], prefix+strings.Repeat(" ", p+2)))
+		}
+	}
+	return ret[:len(ret)-1]
+}
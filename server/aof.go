@@ -1,11 +1,14 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/tangrc99/MemTable/logger"
 	"github.com/tangrc99/MemTable/resp"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 )
 
 func (s *Server) appendAOF(event *Event) {
@@ -29,6 +32,75 @@ func (s *Server) appendAOF(event *Event) {
 	s.aof.append(event.raw)
 }
 
+// propagateLater 记录一条需要在当前命令执行完毕后传播的命令，用于阻塞命令被其他客户端唤醒的场景
+func (s *Server) propagateLater(cli *Client, cmd [][]byte) {
+	s.deferred = append(s.deferred, &Event{cmd: cmd, raw: resp.PlainDataToResp(cmd).ToBytes(), cli: cli})
+}
+
+// propagateDeferred 传播所有通过 propagateLater 记录的命令
+func (s *Server) propagateDeferred() {
+	for _, event := range s.deferred {
+		s.appendAOF(event)
+		s.updateReplicaStatus(event)
+		s.dirty++
+	}
+	s.deferred = s.deferred[:0]
+}
+
+const (
+	txMultiCommand = "*1\r\n$5\r\nmulti\r\n"
+	txExecCommand  = "*1\r\n$4\r\nexec\r\n"
+)
+
+// txPropagation 记录事务中需要传播的写命令，这些命令会被包装为一个 multi ... exec 块，
+// 保证 aof 恢复以及从节点只会应用完整的事务
+type txPropagation struct {
+	buf    bytes.Buffer
+	dbSeq  int // 块中最后一次选择的数据库
+	writes int // 块中写命令的数量
+}
+
+func newTxPropagation() *txPropagation {
+	return &txPropagation{dbSeq: -1}
+}
+
+// append 添加一条在 dbSeq 中执行的写命令，数据库发生变化时会插入 select 语句
+func (tx *txPropagation) append(dbSeq int, raw []byte) {
+	if tx.writes == 0 {
+		tx.buf.WriteString(txMultiCommand)
+	}
+	if dbSeq != tx.dbSeq {
+		dbStr := strconv.Itoa(dbSeq)
+		tx.buf.WriteString(fmt.Sprintf("*2\r\n$6\r\nselect\r\n$%d\r\n%s\r\n", len(dbStr), dbStr))
+		tx.dbSeq = dbSeq
+	}
+	tx.buf.Write(raw)
+	tx.writes++
+}
+
+// bytes 返回完整的事务块，事务中没有写命令时返回 nil
+func (tx *txPropagation) bytes() []byte {
+	if tx.writes == 0 {
+		return nil
+	}
+	return append(tx.buf.Bytes(), txExecCommand...)
+}
+
+// propagateTransaction 将事务块作为一个整体写入 aof 以及 backlog，从 aof 中恢复时不会重复写入
+func (s *Server) propagateTransaction(tx *txPropagation) {
+
+	block := tx.bytes()
+	if block == nil || s.loading {
+		return
+	}
+
+	if s.aof != nil && s.aofEnabled {
+		s.aof.append(block)
+	}
+	s.appendBackLogRaw(block)
+	s.dirty += tx.writes
+}
+
 func (s *Server) recoverFromAOF(filename string) {
 
 	reader, err := os.OpenFile(filename, os.O_RDONLY, 777)
@@ -36,6 +108,13 @@ func (s *Server) recoverFromAOF(filename string) {
 		logger.Warning("AOF: File Not Exists")
 		return
 	}
+	defer reader.Close()
+
+	s.loadAOF(reader)
+}
+
+// loadAOF 读取 reader 中 aof 格式的命令并依次执行
+func (s *Server) loadAOF(reader io.Reader) {
 
 	client := NewFakeClient()
 
@@ -43,6 +122,9 @@ func (s *Server) recoverFromAOF(filename string) {
 
 	selected := false
 
+	s.loading = true
+	defer func() { s.loading = false }()
+
 	for {
 
 		parsedRes := parser.Parse()
@@ -52,6 +134,10 @@ func (s *Server) recoverFromAOF(filename string) {
 			if e := parsedRes.Err.Error(); e != "EOF" {
 				logger.Error("Client", client.id, "Read Error:", e)
 			}
+			// 文件末尾不完整的事务不会被执行
+			if client.inTx {
+				logger.Warningf("AOF: Discard incomplete transaction with %d commands", len(client.tx))
+			}
 			break
 		}
 
@@ -67,7 +153,12 @@ func (s *Server) recoverFromAOF(filename string) {
 		// 执行服务命令
 		_, _ = ExecCommand(s, client, client.cmd, client.raw)
 
-		if !selected && client.dbSeq > 0 {
+		if client.inTx {
+			// 事务块中的 select 会在 exec 时执行，exec 之后重新使用默认数据库
+			continue
+		}
+
+		if !selected && client.dbSeq > 0 && strings.ToLower(string(client.cmd[0])) != "exec" {
 			selected = true
 		} else {
 			selected = false
@@ -104,8 +104,14 @@ type aofBuffer struct {
 	pageSize  int64
 
 	writing      int32         // 是否正在写入
+	noSync       int32         // 刷盘时是否跳过 fsync，由操作系统决定何时写入硬盘
 	notification chan struct{} // 刷盘通知标志
 	quitFlag     chan struct{}
+	swap         chan *aofSwapRequest // 替换 aof 文件的请求，由刷盘协程处理
+
+	size       int64    // aof 文件大小，包含缓冲区中尚未刷盘的内容
+	rewriting  bool     // 是否正在进行 aof 重写
+	rewriteBuf [][]byte // aof 重写期间追加的内容，重写完成后需要写入新文件
 }
 
 // newAOFBuffer 会创建一个 AOF 缓冲区，缓冲区的将会采取一定策略写入到 filename 文件中
@@ -115,8 +121,16 @@ func newAOFBuffer(filename string) *aofBuffer {
 		logger.Error("Aof:", err.Error())
 	}
 
+	var size int64 = 0
+	if file != nil {
+		if stat, err := file.Stat(); err == nil {
+			size = stat.Size()
+		}
+	}
+
 	buffers := &aofBuffer{
 		writer:       file,
+		size:         size,
 		pages:        make([]*bufferPage, bufferPageSize),
 		flushSeq:     0,
 		appendSeq:    0,
@@ -124,6 +138,7 @@ func newAOFBuffer(filename string) *aofBuffer {
 		writing:      0,
 		notification: make(chan struct{}),
 		quitFlag:     make(chan struct{}),
+		swap:         make(chan *aofSwapRequest),
 	}
 
 	for i := range buffers.pages {
@@ -151,10 +166,17 @@ func (buff *aofBuffer) asyncTask() {
 
 			// os 缓冲区写入硬盘
 			atomic.StoreInt32(&buff.writing, 2)
-			buff.syncToDisk()
+			if atomic.LoadInt32(&buff.noSync) == 0 {
+				buff.syncToDisk()
+			}
 
 			// 完成刷盘工作
 			atomic.StoreInt32(&buff.writing, 0)
+		// 替换 aof 文件，事件循环会阻塞等待替换完成，不会同时写入缓冲区
+		case req := <-buff.swap:
+			atomic.StoreInt32(&buff.writing, 1)
+			req.done <- buff.doSwapFile(req.file, req.filename)
+			atomic.StoreInt32(&buff.writing, 0)
 		// 控制退出
 		case <-buff.quitFlag:
 			q = true
@@ -183,6 +205,15 @@ func (buff *aofBuffer) syncToDisk() {
 	}
 }
 
+// setFsync 设置刷盘策略，policy 为 no 时只将缓冲区写入文件，不主动调用 fsync
+func (buff *aofBuffer) setFsync(policy string) {
+	if policy == "no" {
+		atomic.StoreInt32(&buff.noSync, 1)
+	} else {
+		atomic.StoreInt32(&buff.noSync, 0)
+	}
+}
+
 // quit 会阻塞直至清空所有的缓冲区
 func (buff *aofBuffer) quit() {
 
@@ -211,6 +242,13 @@ func (buff *aofBuffer) flush() {
 // append 将内容写入到 AOF 缓冲区中，如果当前缓冲区已满，函数会阻塞直到刷盘清理出一部分可写入的缓冲区
 func (buff *aofBuffer) append(bytes []byte) {
 
+	buff.size += int64(len(bytes))
+
+	if buff.rewriting {
+		// 重写期间的内容需要额外保存一份，bytes 可能会被复用，这里需要拷贝
+		buff.rewriteBuf = append(buff.rewriteBuf, append([]byte(nil), bytes...))
+	}
+
 	result := buff.pages[buff.appendSeq%buff.pageSize].append(bytes)
 
 	if result != appendSuccess {
@@ -239,3 +277,68 @@ func (buff *aofBuffer) append(bytes []byte) {
 		}
 	}
 }
+
+// startRewrite 开始记录重写期间追加的内容
+func (buff *aofBuffer) startRewrite() {
+	buff.rewriting = true
+	buff.rewriteBuf = make([][]byte, 0)
+}
+
+// stopRewrite 停止记录重写期间追加的内容，用于重写失败的情况
+func (buff *aofBuffer) stopRewrite() {
+	buff.rewriting = false
+	buff.rewriteBuf = nil
+}
+
+// aofSwapRequest 是事件循环向刷盘协程发送的替换 aof 文件的请求
+type aofSwapRequest struct {
+	file     *os.File
+	filename string
+	done     chan error
+}
+
+// swapFile 会将缓冲区中的内容全部写入到旧文件中，然后将重写期间追加的内容写入 file，并使用 file 原子地替换 filename 文件。
+// file 必须是以 O_APPEND 模式打开的重写临时文件。替换由刷盘协程完成，函数会阻塞到替换完成，该函数只能在事件循环中调用。
+func (buff *aofBuffer) swapFile(file *os.File, filename string) error {
+	req := &aofSwapRequest{file: file, filename: filename, done: make(chan error)}
+	buff.swap <- req
+	return <-req.done
+}
+
+// doSwapFile 在刷盘协程中替换 aof 文件
+func (buff *aofBuffer) doSwapFile(file *os.File, filename string) error {
+
+	// 旧文件需要保证完整，防止替换失败时丢失数据
+	for buff.flushSeq < buff.appendSeq {
+		buff.flushBuffer()
+	}
+	buff.flushBuffer()
+
+	for _, bytes := range buff.rewriteBuf {
+		if _, err := file.Write(bytes); err != nil {
+			return err
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(file.Name(), filename); err != nil {
+		return err
+	}
+
+	if buff.writer != nil {
+		_ = buff.writer.Close()
+	}
+	buff.writer = file
+
+	if stat, err := file.Stat(); err == nil {
+		buff.size = stat.Size()
+	}
+
+	buff.rewriting = false
+	buff.rewriteBuf = nil
+
+	return nil
+}
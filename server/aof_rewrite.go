@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"github.com/tangrc99/MemTable/config"
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/logger"
+	"os"
+	"path"
+	"sync"
+)
+
+// aofRewriteChunkSize 是重写内容在事件循环中累积后交给后台协程写入的大小
+const aofRewriteChunkSize = 64 * 1024
+
+// aofRewriteResult 是后台重写协程的执行结果
+type aofRewriteResult struct {
+	file *os.File // 写入完成的临时文件
+	err  error
+}
+
+// aofRewriteStatus 记录了 aof 重写的状态
+type aofRewriteStatus struct {
+	aofRewriting   bool                  // 是否正在进行 aof 重写
+	aofRewriteDone chan aofRewriteResult // 后台协程完成写入后通知事件循环
+	aofBaseSize    int64                 // 上一次重写完成后的 aof 文件大小，用于自动重写
+}
+
+// BGRewriteAOF 会在后台重写 aof 文件，如果已经有重写正在进行，将返回 false。
+// 数据库快照会在事件循环中增量地序列化，快照是开始时刻的一致视图，序列化的内容由后台协程写入硬盘。
+// 重写期间新追加的命令会被 aofBuffer 额外保存，并在替换文件前写入到新文件中。
+func (s *Server) BGRewriteAOF() bool {
+
+	if s.aofRewriting {
+		logger.Warning("AOF: Try Rewrite AOF When Another Rewrite Process Executing")
+		return false
+	}
+
+	s.aofRewriting = true
+	s.aofRewriteDone = make(chan aofRewriteResult, 1)
+
+	// 快照开始之后追加的命令都需要写入新文件
+	if s.aof != nil {
+		s.aof.startRewrite()
+	}
+
+	tmp := path.Join(s.dir, fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+	pipe := newAOFRewritePipe()
+
+	go func() {
+		s.aofRewriteDone <- pipe.writeFile(tmp)
+	}()
+
+	s.beginSnapshot(db.NewAOFWriter(pipe), pipe.close)
+
+	logger.Info("AOF: Background append only file rewriting started")
+
+	return true
+}
+
+// aofRewritePipe 将事件循环中生成的重写内容交给后台协程写入文件，事件循环中的写入不会阻塞
+type aofRewritePipe struct {
+	buf []byte // 事件循环中正在累积的内容
+
+	mu     sync.Mutex
+	chunks [][]byte      // 等待后台协程写入的内容
+	closed bool          // 快照是否已经结束
+	err    error         // 快照生成失败的原因
+	notify chan struct{} // 通知后台协程有新的内容
+}
+
+func newAOFRewritePipe() *aofRewritePipe {
+	return &aofRewritePipe{notify: make(chan struct{}, 1)}
+}
+
+// Write 在事件循环中写入重写内容，内容累积到 aofRewriteChunkSize 后交给后台协程，写入总是成功
+func (pipe *aofRewritePipe) Write(p []byte) (int, error) {
+	pipe.buf = append(pipe.buf, p...)
+	if len(pipe.buf) >= aofRewriteChunkSize {
+		pipe.push(false, nil)
+	}
+	return len(p), nil
+}
+
+// close 在快照结束时调用，err 不为 nil 代表快照生成失败
+func (pipe *aofRewritePipe) close(err error) {
+	pipe.push(true, err)
+}
+
+// push 将累积的内容交给后台协程
+func (pipe *aofRewritePipe) push(closed bool, err error) {
+
+	pipe.mu.Lock()
+	if len(pipe.buf) > 0 {
+		pipe.chunks = append(pipe.chunks, pipe.buf)
+	}
+	pipe.closed = closed
+	pipe.err = err
+	pipe.mu.Unlock()
+
+	pipe.buf = nil
+
+	select {
+	case pipe.notify <- struct{}{}:
+	default:
+	}
+}
+
+// writeFile 在后台协程中将重写内容写入到 filename 中并刷盘，返回的文件以 O_APPEND 模式打开
+func (pipe *aofRewritePipe) writeFile(filename string) aofRewriteResult {
+
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0666)
+
+	// 写入失败后仍然需要取走剩余的内容，直到快照结束
+	for closed := false; !closed; {
+
+		<-pipe.notify
+
+		pipe.mu.Lock()
+		chunks, snapErr := pipe.chunks, pipe.err
+		closed = pipe.closed
+		pipe.chunks = nil
+		pipe.mu.Unlock()
+
+		for _, chunk := range chunks {
+			if err == nil {
+				_, err = file.Write(chunk)
+			}
+		}
+		if closed && err == nil {
+			err = snapErr
+		}
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if err != nil {
+		if file != nil {
+			_ = file.Close()
+			_ = os.Remove(filename)
+		}
+		return aofRewriteResult{err: err}
+	}
+
+	return aofRewriteResult{file: file}
+}
+
+// finishAOFRewrite 使用重写完成的文件替换旧的 aof 文件，该函数只能在事件循环中调用
+func (s *Server) finishAOFRewrite(result aofRewriteResult) {
+
+	s.aofRewriting = false
+
+	if result.err != nil {
+		logger.Error("AOF: Rewrite Failed,", result.err.Error())
+		if s.aof != nil {
+			s.aof.stopRewrite()
+		}
+		return
+	}
+
+	filename := path.Join(s.dir, s.aofFile)
+
+	var err error
+	if s.aof != nil {
+		err = s.aof.swapFile(result.file, filename)
+	} else {
+		// 没有开启 aof 时只需要生成文件
+		if err = os.Rename(result.file.Name(), filename); err == nil {
+			_ = result.file.Close()
+		}
+	}
+
+	if err != nil {
+		logger.Error("AOF: Rewrite Failed,", err.Error())
+		if s.aof != nil {
+			s.aof.stopRewrite()
+		}
+		_ = result.file.Close()
+		_ = os.Remove(result.file.Name())
+		return
+	}
+
+	if s.aof != nil {
+		s.aofBaseSize = s.aof.size
+	}
+
+	logger.Info("AOF: Background AOF rewrite finished successfully")
+}
+
+// checkAOFRewrite 检查后台重写是否完成，并根据配置判断是否需要自动触发重写
+func (s *Server) checkAOFRewrite() {
+
+	if s.aofRewriting {
+		select {
+		case result := <-s.aofRewriteDone:
+			s.finishAOFRewrite(result)
+		default:
+		}
+		return
+	}
+
+	if !s.aofEnabled || s.aof == nil || config.Conf.AutoAOFRewritePercentage <= 0 {
+		return
+	}
+
+	size := s.aof.size
+	if size < config.Conf.AutoAOFRewriteMinSize {
+		return
+	}
+
+	base := s.aofBaseSize
+	if base <= 0 {
+		base = 1
+	}
+
+	if growth := (size - base) * 100 / base; growth >= int64(config.Conf.AutoAOFRewritePercentage) {
+		logger.Infof("AOF: Starting automatic rewriting of AOF on %d%% growth", growth)
+		s.BGRewriteAOF()
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/logger"
+	"github.com/tangrc99/MemTable/resp"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestAOFRewrite 测试重写后的 aof 文件能否恢复出相同的数据，并且重写期间追加的命令不会丢失
+func TestAOFRewrite(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	s := NewServer()
+	s.dir = t.TempDir()
+	s.aofEnabled = true
+	s.aof = newAOFBuffer(path.Join(s.dir, s.aofFile))
+
+	cli := NewFakeClient()
+
+	exec := func(args ...string) {
+		cmd := make([][]byte, len(args))
+		for i := range args {
+			cmd[i] = []byte(args[i])
+		}
+		raw := resp.PlainDataToResp(cmd).ToBytes()
+		_, isWrite := ExecCommand(s, cli, cmd, raw)
+		if isWrite {
+			s.appendAOF(&Event{cmd: cmd, raw: raw, cli: cli})
+		}
+	}
+
+	exec("set", "str", "v1")
+	exec("set", "str", "v2")
+	exec("rpush", "list", "a", "b", "c")
+	exec("lpop", "list")
+	exec("sadd", "set", "a", "b")
+	exec("zadd", "zset", "1.5", "a", "2", "b")
+	exec("hset", "hash", "f1", "v1", "f2", "v2")
+	exec("bf.add", "bloom", "a")
+	exec("xadd", "stream", "1-1", "f", "v")
+	exec("xadd", "stream", "2-1", "f", "v")
+	exec("xadd", "stream", "3-1", "f", "v")
+	exec("xdel", "stream", "3-1")
+	exec("xgroup", "create", "stream", "g", "0")
+	exec("xreadgroup", "group", "g", "c1", "count", "1", "streams", "stream", ">")
+	exec("xgroup", "createconsumer", "stream", "g", "c2")
+	exec("xgroup", "create", "empty", "g", "$", "mkstream")
+	exec("set", "ttl", "v")
+	exec("expire", "ttl", "100")
+	exec("select", "1")
+	exec("set", "db1", "v")
+	exec("select", "0")
+	// 重写内容会分多次交给后台协程写入
+	for i := 0; i < 1000; i++ {
+		exec("set", "key"+strconv.Itoa(i), strings.Repeat("v", 100))
+	}
+
+	assert.True(t, s.BGRewriteAOF())
+	assert.False(t, s.BGRewriteAOF())
+
+	// 快照在事件循环中增量生成，重写期间写入的命令
+	s.handleSnapshots()
+	exec("set", "during", "v")
+	exec("del", "str")
+	exec("rpush", "list", "d")
+	exec("lpop", "list")
+
+	s.completeSnapshots()
+	s.finishAOFRewrite(<-s.aofRewriteDone)
+	assert.False(t, s.aofRewriting)
+
+	// 重写完成后写入的命令
+	exec("set", "after", "v")
+
+	s.aof.quit()
+
+	_, err := os.Stat(path.Join(s.dir, fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid())))
+	assert.True(t, os.IsNotExist(err))
+
+	recovered := NewServer()
+	recovered.recoverFromAOF(path.Join(s.dir, s.aofFile))
+
+	for i := range s.dbs {
+		assert.Equal(t, s.dbs[i].Size(), recovered.dbs[i].Size())
+		assert.Equal(t, s.dbs[i].TTLSize(), recovered.dbs[i].TTLSize())
+	}
+
+	tests := []struct {
+		db    int
+		key   string
+		exist bool
+	}{
+		{0, "str", false},
+		{0, "list", true},
+		{0, "set", true},
+		{0, "zset", true},
+		{0, "hash", true},
+		{0, "bloom", true},
+		{0, "stream", true},
+		{0, "empty", true},
+		{0, "ttl", true},
+		{0, "during", true},
+		{0, "after", true},
+		{1, "db1", true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.exist, recovered.dbs[test.db].ExistKey(test.key), test.key)
+	}
+
+	list, _ := recovered.dbs[0].GetKey("list")
+	values, _ := list.(*structure.List).Range(0, -1)
+	assert.Equal(t, []structure.Object{structure.Slice("c"), structure.Slice("d")}, values)
+
+	value, _ := recovered.dbs[0].GetKey("stream")
+	stream := value.(*structure.Stream)
+	assert.Equal(t, 2, stream.Len())
+	assert.Equal(t, structure.StreamID{Ms: 3, Seq: 1}, stream.LastID())
+	group, ok := stream.Group("g")
+	assert.True(t, ok)
+	assert.Equal(t, structure.StreamID{Ms: 1, Seq: 1}, group.LastID)
+	assert.Equal(t, 1, group.PendingSize())
+	assert.Len(t, group.Consumers(), 2)
+
+	value, _ = recovered.dbs[0].GetKey("empty")
+	_, ok = value.(*structure.Stream).Group("g")
+	assert.True(t, ok)
+}
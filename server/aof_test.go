@@ -0,0 +1,115 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/logger"
+	"github.com/tangrc99/MemTable/resp"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// TestAOFTransaction 测试事务以 multi ... exec 块写入 aof，并且恢复时只会应用完整的事务
+func TestAOFTransaction(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	s := NewServer()
+	s.dir = t.TempDir()
+	s.aofEnabled = true
+	s.aof = newAOFBuffer(path.Join(s.dir, s.aofFile))
+
+	cli := NewFakeClient()
+
+	exec := func(input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		raw := resp.PlainDataToResp(cmd).ToBytes()
+		ret, isWrite := ExecCommand(s, cli, cmd, raw)
+		if isWrite {
+			s.appendAOF(&Event{cmd: cmd, raw: raw, cli: cli})
+		}
+		return ret
+	}
+
+	exec("set before v")
+	exec("multi")
+	exec("set k1 v")
+	exec("lpush k1 a")
+	exec("get k1")
+	exec("select 1")
+	exec("set k2 v")
+	exec("expire k2 100")
+	exec("select 0")
+	exec("set k3 v")
+	exec("exec")
+
+	// 只读事务不会写入 aof
+	exec("multi")
+	exec("get k1")
+	exec("exec")
+
+	exec("set after v")
+
+	s.aof.quit()
+
+	content, err := os.ReadFile(path.Join(s.dir, s.aofFile))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "multi"))
+	assert.Equal(t, 1, strings.Count(string(content), "exec"))
+	// 执行失败的命令以及读命令不会写入
+	assert.NotContains(t, string(content), "lpush")
+	assert.NotContains(t, string(content), "get")
+	// 依赖执行时刻的命令被改写
+	assert.Contains(t, string(content), "pexpireat")
+
+	// 模拟写入事务时崩溃，文件末尾只有一半的事务
+	f, err := os.OpenFile(path.Join(s.dir, s.aofFile), os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, _ = f.WriteString("*1\r\n$5\r\nmulti\r\n*3\r\n$3\r\nset\r\n$7\r\npartial\r\n$1\r\nv\r\n")
+	_ = f.Close()
+
+	recovered := NewServer()
+	recovered.recoverFromAOF(path.Join(s.dir, s.aofFile))
+	assert.False(t, recovered.loading)
+
+	tests := []struct {
+		db    int
+		key   string
+		exist bool
+	}{
+		{0, "before", true},
+		{0, "k1", true},
+		{1, "k2", true},
+		{0, "k3", true},
+		{0, "after", true},
+		{0, "partial", false},
+		{1, "k3", false},
+		{1, "after", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.exist, recovered.dbs[test.db].ExistKey(test.key), test.key)
+	}
+	assert.Equal(t, 1, recovered.dbs[1].TTLSize())
+}
+
+func TestTxPropagation(t *testing.T) {
+
+	tx := newTxPropagation()
+	assert.Nil(t, tx.bytes())
+
+	tx.append(0, []byte("a"))
+	tx.append(0, []byte("b"))
+	tx.append(2, []byte("c"))
+
+	expected := txMultiCommand +
+		"*2\r\n$6\r\nselect\r\n$1\r\n0\r\n" + "a" + "b" +
+		"*2\r\n$6\r\nselect\r\n$1\r\n2\r\n" + "c" +
+		txExecCommand
+	assert.Equal(t, expected, string(tx.bytes()))
+	assert.Equal(t, 3, tx.writes)
+}
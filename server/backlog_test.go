@@ -3,7 +3,10 @@ package server
 import (
 	"github.com/stretchr/testify/assert"
 	"github.com/tangrc99/MemTable/logger"
+	"github.com/tangrc99/MemTable/resp"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestBackLog(t *testing.T) {
@@ -27,3 +30,69 @@ func TestBackLog(t *testing.T) {
 
 	assert.Equal(t, []byte("*2\r\n$6\r\nselect\r\n$1\r\n0\r\nsdfsdfsdfds"), rd)
 }
+
+func TestBackLogAfterFailover(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	s := NewServer()
+	s.role = Slave
+	s.runID = "r1"
+	s.offset = 100
+
+	// 从节点提升为主节点后保留 offset，旧的复制 id 仍然可以在切换时的 offset 之前继续增量复制
+	s.slaveToMaster()
+	assert.Equal(t, Master, s.Role())
+	assert.Equal(t, "r1", s.prevRunID)
+	assert.NotEqual(t, "r1", s.runID)
+	assert.Equal(t, uint64(100), s.backLog.LowWaterLevel())
+
+	s.appendBackLog(&Event{raw: []byte("sdfsdfsdfds"), cli: NewClient(nil)})
+	assert.Equal(t, uint64(134), s.offset)
+
+	assert.True(t, s.canContinue("r1", 100))
+	assert.False(t, s.canContinue("r1", 101))
+	assert.False(t, s.canContinue("r1", 99))
+	assert.True(t, s.canContinue(s.runID, 134))
+	assert.False(t, s.canContinue(s.runID, 135))
+	assert.False(t, s.canContinue("?", -1))
+
+	assert.Equal(t, []byte("*2\r\n$6\r\nselect\r\n$1\r\n0\r\nsdfsdfsdfds"), s.backLog.ReadSince(100))
+}
+
+func TestPSyncContinueWithPendingCommands(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	// 主节点在 +CONTINUE 之后立即发送命令，两者会在同一次读取中到达
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 1024)
+		_, _ = conn.Read(buf)
+		_, _ = conn.Write([]byte("+PONG\r\n"))
+		_, _ = conn.Read(buf)
+		_, _ = conn.Write([]byte("+CONTINUE r2\r\n*2\r\n$3\r\ndel\r\n$1\r\na\r\n"))
+		_, _ = conn.Read(buf)
+	}()
+
+	s := NewServer()
+	s.runID = "r1"
+	assert.True(t, s.sendPSyncToMaster(listener.Addr().String()))
+	assert.Equal(t, "r2", s.runID)
+
+	select {
+	case event := <-s.events:
+		assert.Equal(t, [][]byte{[]byte("del"), []byte("a")}, event.cli.cmd)
+		var ret resp.RedisData = resp.MakeIntData(0)
+		event.cli.res <- &ret
+	case <-time.After(time.Second):
+		t.Fatal("pending command is not parsed")
+	}
+}
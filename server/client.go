@@ -9,12 +9,17 @@ import (
 	"github.com/tangrc99/MemTable/server/acl"
 	"github.com/tangrc99/MemTable/server/global"
 	"net"
+	"sort"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
 
 type ClientStatus int
 
+// clientMsgBufferSize 是客户端推送消息的缓冲区大小，缓冲区已满的客户端会被断开连接
+const clientMsgBufferSize = 128
+
 const (
 	WAIT ClientStatus = iota
 	CONNECTED
@@ -31,22 +36,30 @@ type Client struct {
 
 	cnn   net.Conn  // 连接实例
 	id    uuid.UUID // Cli 编号
+	seq   int64     // 自增的客户端编号，用于 CLIENT 命令
 	tp    time.Time // 通信时间戳
+	ctime time.Time // 连接建立的时间
 	dbSeq int
 
 	status ClientStatus // 状态 0 等待连接 1 正常 -1 退出 -2 异常
 
 	pipelined bool
+	protocol  int    // 客户端使用的协议版本，默认为 RESP2，可以通过 hello 命令协商
+	name      string // 客户端名称
+	lastCmd   string // 最后执行的命令
 
 	user *acl.User // 当前客户端的登录用户，默认为 default
 	auth bool      // 当前用户是否完成了授权
 
 	// 发布订阅
-	chs map[string]struct{} //订阅频道
-	msg chan []byte         // 用于订阅通知
+	chs      map[string]struct{} //订阅频道
+	patterns map[string]struct{} // 订阅模式
+	shardChs map[string]struct{} // 订阅的分片频道
+	msg      chan []byte         // 用于订阅通知
 
 	// 事务
 	inTx    bool             // 是否处于事务中
+	txDirty bool             // 事务入队时是否出现错误，出现错误时 exec 会放弃整个事务
 	tx      [][][]byte       // 用于解析后的命令
 	txRaw   [][]byte         // 解析前的命令
 	watched map[int][]string //记录监控的键值
@@ -56,34 +69,63 @@ type Client struct {
 	blocked   bool // 客户端是否执行阻塞等待的命令
 	monitored bool
 
+	// 客户端管理
+	noEvict   bool // 是否允许因为长时间不活跃而被移除
+	replyOff  bool // 是否关闭回包
+	replySkip bool // 是否跳过下一条命令的回包
+	postponed int  // 因为 CLIENT PAUSE 而延后执行的命令数量
+
+	// 客户端缓存
+	tracking         bool     // 是否开启了 CLIENT TRACKING
+	trackingBcast    bool     // 是否为广播模式
+	trackingPrefixes []string // 广播模式下追踪的键前缀
+	trackingRedirect *Client  // 失效通知的接收者，nil 代表发送给客户端自身
+
+	// 集群
+	asking bool // 是否执行了 ASKING，只对下一条命令有效
+
 	// 主从复制
 	SlaveStatus
 }
 
+// clientSeq 用于生成自增的客户端编号
+var clientSeq int64
+
+func nextClientSeq() int64 {
+	return atomic.AddInt64(&clientSeq, 1)
+}
+
 func NewClient(conn net.Conn) *Client {
 	return &Client{
-		parser:  resp.NewParser(conn),
-		cnn:     conn,
-		id:      uuid.Must(uuid.NewV1()),
-		tp:      global.Now,
-		status:  WAIT,
-		dbSeq:   0,
-		res:     make(chan *resp.RedisData, 10),
-		user:    acl.DefaultUser(),
-		auth:    false,
-		blocked: false,
+		parser:   resp.NewParser(conn),
+		cnn:      conn,
+		id:       uuid.Must(uuid.NewV1()),
+		seq:      nextClientSeq(),
+		tp:       global.Now,
+		ctime:    global.Now,
+		status:   WAIT,
+		dbSeq:    0,
+		res:      make(chan *resp.RedisData, 10),
+		protocol: resp.RESP2,
+		user:     acl.DefaultUser(),
+		auth:     false,
+		blocked:  false,
 	}
 }
 
 // NewFakeClient 创建一个无连接的，具有最高权限的客户端
 func NewFakeClient() *Client {
 	return &Client{
-		id:     uuid.Must(uuid.NewV1()),
-		status: CONNECTED,
-		dbSeq:  0,
-		res:    make(chan *resp.RedisData, 10),
-		auth:   true,
-		user:   acl.ManageUser(),
+		id:       uuid.Must(uuid.NewV1()),
+		seq:      nextClientSeq(),
+		tp:       global.Now,
+		ctime:    global.Now,
+		status:   CONNECTED,
+		dbSeq:    0,
+		res:      make(chan *resp.RedisData, 10),
+		protocol: resp.RESP2,
+		auth:     true,
+		user:     acl.ManageUser(),
 	}
 }
 
@@ -91,41 +133,137 @@ func (cli *Client) ParseStream() *resp.ParsedRes {
 	return cli.parser.Parse()
 }
 
+// encode 根据客户端协商的协议版本编码回包
+func (cli *Client) encode(data resp.RedisData) []byte {
+	return resp.Encode(data, cli.protocol)
+}
+
+// encodeMessage 编码发布订阅消息，RESP3 客户端会以 push 类型接收消息
+func (cli *Client) encodeMessage(msg []byte) []byte {
+	if cli.protocol == resp.RESP3 {
+		return resp.ArrayToPush(msg)
+	}
+	return msg
+}
+
 func (cli *Client) UpdateTimestamp(tp time.Time) {
 	cli.tp = tp
 }
 
-func (cli *Client) Subscribe(chs *db.Channels, channel string) int {
+// subscriptions 返回客户端订阅的频道与模式总数
+func (cli *Client) subscriptions() int {
+	return len(cli.chs) + len(cli.patterns)
+}
 
+func (cli *Client) initSubscription() {
 	if cli.chs == nil {
 		cli.chs = make(map[string]struct{})
-		cli.msg = make(chan []byte, 10)
+		cli.patterns = make(map[string]struct{})
+		cli.shardChs = make(map[string]struct{})
+		cli.msg = make(chan []byte, clientMsgBufferSize)
 	}
+}
+
+// closeSlowConsumer 断开推送消息缓冲区已满的客户端，与 redis 超出 client-output-buffer-limit 的处理一致。
+// 关闭连接后客户端协程会通知事件循环完成清理工作
+func (cli *Client) closeSlowConsumer() {
+	if cli.cnn == nil || cli.status == EXIT || cli.status == ERROR {
+		return
+	}
+	logger.Warningf("Client %s message buffer is full, closing connection", cli.cnn.RemoteAddr().String())
+	_ = cli.cnn.Close()
+}
+
+func (cli *Client) Subscribe(chs *db.Channels, channel string) int {
+
+	cli.initSubscription()
 
 	chs.Subscribe(channel, cli.id.String(), &cli.msg)
 	cli.chs[channel] = struct{}{}
-	return len(cli.chs)
+	return cli.subscriptions()
 }
 
 func (cli *Client) UnSubscribe(chs *db.Channels, channel string) int {
 	chs.UnSubscribe(channel, cli.id.String())
 	delete(cli.chs, channel)
-	return len(cli.chs)
+	return cli.subscriptions()
+}
+
+// PSubscribe 订阅 glob 风格的模式
+func (cli *Client) PSubscribe(chs *db.Channels, pattern string) int {
+
+	cli.initSubscription()
+
+	chs.PSubscribe(pattern, cli.id.String(), &cli.msg)
+	cli.patterns[pattern] = struct{}{}
+	return cli.subscriptions()
+}
+
+// PUnSubscribe 取消模式的订阅
+func (cli *Client) PUnSubscribe(chs *db.Channels, pattern string) int {
+	chs.PUnSubscribe(pattern, cli.id.String())
+	delete(cli.patterns, pattern)
+	return cli.subscriptions()
+}
+
+// SSubscribe 订阅分片频道，返回值为订阅的分片频道数量
+func (cli *Client) SSubscribe(chs *db.Channels, channel string) int {
+
+	cli.initSubscription()
+
+	chs.Subscribe(channel, cli.id.String(), &cli.msg)
+	cli.shardChs[channel] = struct{}{}
+	return len(cli.shardChs)
+}
+
+// SUnSubscribe 取消分片频道的订阅
+func (cli *Client) SUnSubscribe(chs *db.Channels, channel string) int {
+	chs.UnSubscribe(channel, cli.id.String())
+	delete(cli.shardChs, channel)
+	return len(cli.shardChs)
+}
+
+// SUnSubscribeAll 取消所有分片频道的订阅
+func (cli *Client) SUnSubscribeAll(chs *db.Channels) {
+	for channel := range cli.shardChs {
+		chs.UnSubscribe(channel, cli.id.String())
+	}
+	cli.shardChs = make(map[string]struct{})
 }
 
 func (cli *Client) UnSubscribeAll(chs *db.Channels) {
 	for channel := range cli.chs {
 		chs.UnSubscribe(channel, cli.id.String())
 	}
+	for pattern := range cli.patterns {
+		chs.PUnSubscribe(pattern, cli.id.String())
+	}
 	cli.chs = make(map[string]struct{})
+	cli.patterns = make(map[string]struct{})
 }
 
 func (cli *Client) InitTX() {
 	cli.inTx = true
+	cli.txDirty = false
 	cli.tx = make([][][]byte, 0, 20)
 	cli.txRaw = make([][]byte, 0, 20)
 }
 
+// ResetTX 退出事务状态并清空已经入队的命令
+func (cli *Client) ResetTX() {
+	cli.inTx = false
+	cli.txDirty = false
+	cli.tx = make([][][]byte, 0)
+	cli.txRaw = make([][]byte, 0)
+}
+
+// flagTxDirty 在事务入队阶段拒绝命令时调用，之后的 exec 会返回 EXECABORT
+func (cli *Client) flagTxDirty(commandName string) {
+	if cli.inTx && NotTxCommand(commandName) {
+		cli.txDirty = true
+	}
+}
+
 func (cli *Client) InitWatchers() {
 	if cli.watched == nil {
 		cli.watched = make(map[int][]string)
@@ -138,9 +276,9 @@ func (cli *Client) ClearWatchers() {
 	cli.revised = false
 }
 
-// IsRemovable 用于判断当前客户端是否能够被驱逐。当客户端处于事务、监控、主从复制状态下是无法驱逐的。
+// IsRemovable 用于判断当前客户端是否能够被驱逐。当客户端处于事务、监控、主从复制状态或者开启了 no-evict 时是无法驱逐的。
 func (cli *Client) IsRemovable() bool {
-	return !cli.inTx && !cli.monitored && cli.slaveStatus == slaveNot
+	return !cli.inTx && !cli.monitored && !cli.noEvict && cli.slaveStatus == slaveNot
 }
 
 func (cli *Client) Cost() int64 {
@@ -182,10 +320,14 @@ func (clients *ClientList) AddClientIfNotExist(cli *Client) bool {
 func (clients *ClientList) removeClientWithPosition(cli *Client, node *structure.ListNode) {
 	logger.Debug("ClientList: Remove Client", cli.id)
 	cli.status = EXIT
-	cli.parser.Stop()
+	if cli.parser != nil {
+		cli.parser.Stop()
+	}
 	clients.list.RemoveNode(node)
 	delete(clients.UUIDSet, cli.id)
-	_ = cli.cnn.Close()
+	if cli.cnn != nil {
+		_ = cli.cnn.Close()
+	}
 }
 
 // RemoveClient 不知道具体位置时，需要遍历
@@ -234,6 +376,20 @@ func (clients *ClientList) RemoveLongNotUsed(maxRemove, maxTraverse int, d time.
 	}
 }
 
+// Clients 返回所有的客户端，按照客户端编号排序
+func (clients *ClientList) Clients() []*Client {
+	res := make([]*Client, 0, clients.Size())
+	for node := clients.list.FrontNode(); node != nil; node = node.Next() {
+		if cli, ok := node.Value.(*Client); ok {
+			res = append(res, cli)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].seq < res[j].seq
+	})
+	return res
+}
+
 func (clients *ClientList) Size() int {
 	return clients.list.Size()
 }
@@ -4,28 +4,49 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/tangrc99/MemTable/config"
+	"github.com/tangrc99/MemTable/db"
 	"github.com/tangrc99/MemTable/logger"
+	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
 	"github.com/tangrc99/MemTable/utils"
+	"io"
 	"net"
 	"time"
 )
 
-const slotNum = 1024 //1 << 14
+// slotNum 与 redis 相同，键所在的槽为 crc16(key) % slotNum
+const slotNum = db.SlotNum
+
+// clusterBusPortOffset 是集群总线端口与服务端口之差，与 redis 相同
+const clusterBusPortOffset = 10000
+
+// slotMigrateBatch 是每一次时间事件中，每个正在迁出的槽最多迁移的键数量
+const slotMigrateBatch = 100
+
+// slotMigrateResults 是后台迁移结果通道的容量
+const slotMigrateResults = 16
+
+// clusterOutboxSize 是发送给对端节点的消息队列长度，队列已满时新的消息会被丢弃
+const clusterOutboxSize = 1024
+
+// clusterWriteTimeout 是向对端节点写入一条消息的超时时间
+const clusterWriteTimeout = time.Second
 
 type clusterNode struct {
 	name     string
+	id       string // 节点 id，由 name 计算得到，使得所有节点无需握手即可得到相同的 id
 	alive    bool
-	peer     *Client   // 代表对端的 client
-	pingTime time.Time // 上次发送信息的时间
-	pongTime time.Time // 上次收到信息的时间
+	peer     *Client     // 代表对端的 client
+	outbox   chan []byte // 等待发送给对端的消息，由单独的协程写入连接
+	pingTime time.Time   // 上次发送信息的时间
+	pongTime time.Time   // 上次收到信息的时间
 	slaves   []*clusterNode
 	slaveOf  *clusterNode
 }
 
 func newSelfNode(name string) *clusterNode {
 	node :=
-		&clusterNode{name: name, alive: true}
+		&clusterNode{name: name, id: clusterNodeId(name), alive: true}
 	node.slaveOf = node
 	return node
 }
@@ -33,15 +54,46 @@ func newSelfNode(name string) *clusterNode {
 func acceptNewClusterNode(conn net.Conn) *clusterNode {
 	node := &clusterNode{
 		name:     conn.RemoteAddr().String(),
+		id:       clusterNodeId(conn.RemoteAddr().String()),
 		alive:    true,
 		peer:     NewClient(conn),
+		outbox:   make(chan []byte, clusterOutboxSize),
 		pingTime: global.Now,
 		pongTime: global.Now,
 		slaves:   make([]*clusterNode, 0),
 	}
+	// 对端对于转发命令的回复目前没有用处，直接丢弃，防止对端的写缓冲区被占满
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+	}()
+	// 写入操作可能因为对端缓慢而阻塞，不能在事件循环中进行
+	go func() {
+		for msg := range node.outbox {
+			_ = conn.SetWriteDeadline(time.Now().Add(clusterWriteTimeout))
+			if _, err := conn.Write(msg); err != nil {
+				logger.Warningf("Cluster: write to %s failed: %s", node.name, err.Error())
+				return
+			}
+		}
+	}()
 	return node
 }
 
+// send 将消息放入对端节点的发送队列，队列已满时返回 false
+func (n *clusterNode) send(msg []byte) bool {
+	select {
+	case n.outbox <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// clusterNodeId 根据节点名称计算出 40 个字符的节点 id，格式与 redis 相同
+func clusterNodeId(name string) string {
+	return utils.Sha1([]byte(name))
+}
+
 func (n *clusterNode) isMaster() bool {
 	return n.slaveOf == n
 }
@@ -59,6 +111,16 @@ func (n *clusterNode) slaveOfNode(master *clusterNode) {
 	master.slaves = append(master.slaves, n)
 }
 
+// removeSlave 将 slave 从当前节点的从节点列表中移除
+func (n *clusterNode) removeSlave(slave *clusterNode) {
+	for i, s := range n.slaves {
+		if s == slave {
+			n.slaves = append(n.slaves[:i], n.slaves[i+1:]...)
+			return
+		}
+	}
+}
+
 // slaveOfNone 修改当前节点中的状态，不会发送命令给对应的节点
 func (n *clusterNode) slaveOfNone() {
 	n.slaveOf = n
@@ -110,14 +172,21 @@ type clusterStatus struct {
 	state clusterState   // 当前集群状态
 	slots []*clusterNode // 集群中所有槽
 
+	importing map[int]*clusterNode // 正在从其他节点迁入的槽，值为迁出节点
+	migrating map[int]*clusterNode // 正在迁出到其他节点的槽，值为迁入节点
+
+	migrations map[int]*slotMigration // 正在迁出的槽的后台迁移状态
+	migrated   chan *slotMigration    // 后台协程完成一批键的发送后通知事件循环
+
 	nodes     map[string]*clusterNode // 集群中所有节点
 	downNodes map[string]*clusterNode // 集群中下线的节点
 
 	configNodeNum int // 配置中的节点数量
 
 	// 高可用相关
-	config  clusterConfig
-	watcher clusterWatcher
+	config   clusterConfig
+	watcher  clusterWatcher
+	failover clusterManualFailover // 正在进行的手动故障转移
 
 	msg <-chan clusterChangeMessage
 }
@@ -128,18 +197,27 @@ func (c *clusterStatus) initCluster(self *Server) {
 	c.self = newSelfNode(self.url)
 	c.nodes = make(map[string]*clusterNode)
 	c.nodes[c.server.url] = c.self
+	c.downNodes = make(map[string]*clusterNode)
 	c.state = ClusterInit
-	c.watcher = initEtcdWatcher(config.Conf.ClusterName, c.server.url)
+	if config.Conf.ClusterBus == "gossip" {
+		c.watcher = initGossipWatcher(config.Conf.ClusterName, c.server.url)
+	} else {
+		c.watcher = initEtcdWatcher(config.Conf.ClusterName, c.server.url)
+	}
 	c.config = c.watcher.getClusterConfig()
 
 	c.slots = make([]*clusterNode, slotNum)
+	c.importing = make(map[int]*clusterNode)
+	c.migrating = make(map[int]*clusterNode)
+	c.migrations = make(map[int]*slotMigration)
+	c.migrated = make(chan *slotMigration, slotMigrateResults)
 
 	c.countClusterNodeNum()
 }
 
 // getSlot 根据键值来计算出所在的哈希槽
 func (c *clusterStatus) getSlot(key string) int {
-	return utils.HashKey(key) % slotNum
+	return db.KeySlot(key)
 }
 
 // countClusterNodeNum 计算配置中一共有多少个节点
@@ -169,6 +247,9 @@ finished:
 	shardWidth := slotNum / c.config.ShardNum
 	start := c.selfShard * shardWidth
 	end := start + shardWidth
+	if c.selfShard == c.config.ShardNum-1 {
+		end = slotNum
+	}
 	for j := start; j < end; j++ {
 		c.slots[j] = c.self
 	}
@@ -181,10 +262,8 @@ func (c *clusterStatus) isKeyNeedMove(key string) (bool, int, *clusterNode) {
 		return false, -1, nil
 	}
 
-	slot := utils.HashKey(key) % slotNum
-	node := c.slots[utils.HashKey(key)%slotNum]
-
-	println(slot)
+	slot := c.getSlot(key)
+	node := c.slots[slot]
 
 	if node != c.self {
 		return true, slot, node
@@ -218,6 +297,10 @@ func (c *clusterStatus) handleClusterEvents() {
 
 		c.announce()
 
+		c.migrateSlots()
+
+		c.handleManualFailover()
+
 	case ClusterDown:
 		// 如果主从复制中发现主节点下线，那么集群状态会变更为 ClusterDown
 
@@ -237,20 +320,28 @@ func (c *clusterStatus) handleClusterEvents() {
 			return
 		}
 
+		// 与主节点的连接已经恢复，不需要进行故障转移
+		if c.server.role == Slave && c.server.masterAlive {
+			c.state = ClusterOK
+			return
+		}
+
+		// 手动故障转移不要求主节点已经被集群判定为下线
+		if c.failover.mode != failoverNone {
+			c.handleManualFailover()
+			return
+		}
+
 		// 如果没有通知，则尝试进行选举。
 		// 若选举成功，则切换自身为主节点，选举失败不需要做任何事情。
 		ok := c.watcher.campaign()
 		if ok {
 
-			updateShardMaster(c.self.slaveOf, c.self)
-
 			// 升级为主节点，等待其他节点的连接
-			c.server.slaveToStandAlone()
-			c.state = ClusterOK
+			c.promoteSelf()
 
 		} else {
-			// 竞选失败，不需要做任何事情
-			// 阻塞一段时间，防止频繁发起选举。
+			// 选举尚未完成或者已经失败，下一次时间事件中继续推进选举，选举的重试间隔由 watcher 控制
 
 		}
 
@@ -323,6 +414,13 @@ func (c *clusterStatus) initClusterConn() {
 		// 给自身节点分配 slot，这里会覆盖掉之前分配给主节点的slot保证 slave 可以处理读
 		c.initLocalShard()
 
+		// 发生过迁移的槽以集群中记录的负责节点为准
+		for slot, name := range c.watcher.getSlotOwners() {
+			if node, exist := c.nodes[name]; exist && slot >= 0 && slot < slotNum {
+				c.assignSlot(slot, node)
+			}
+		}
+
 		// 开始监视集群中是否发生变动
 		c.msg = c.watcher.watchClusterChanges()
 		c.state = ClusterOK
@@ -371,6 +469,32 @@ func (c *clusterStatus) announce() {
 	c.watcher.doSomething()
 }
 
+// broadcastPublish 将 publish 命令转发到集群中的其他节点，其他节点只会在本地发布消息
+func (c *clusterStatus) broadcastPublish(channel, message []byte) {
+
+	if c.state != ClusterOK {
+		return
+	}
+
+	msg := resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("cluster")),
+		resp.MakeBulkData([]byte("publish")),
+		resp.MakeBulkData(channel),
+		resp.MakeBulkData(message),
+	}).ToBytes()
+
+	for _, node := range c.nodes {
+		if node == c.self || node.peer == nil || !node.alive {
+			continue
+		}
+		if !node.send(msg) {
+			logger.Warningf("Cluster: broadcast publish to %s dropped, outbox is full", node.name)
+			continue
+		}
+		node.pingTime = global.Now
+	}
+}
+
 func (c *clusterStatus) upNodeAnnounce(node string) {
 	c.watcher.upNodeAnnounce(node)
 }
@@ -390,22 +514,42 @@ func (c *clusterStatus) handleClusterChangeMessage(msg *clusterChangeMessage) {
 		leader, exist := c.nodes[msg.Content]
 		if !exist {
 			logger.Error(fmt.Sprintf("Cluster nonexistent node become leader, shard %d node %s", msg.Shard, msg.Content))
+			return
 		}
 
-		// 更新自身视图
-		updateShardMaster(leader.slaveOf, leader)
+		// 主节点没有发生变化
+		old := leader.slaveOf
+		if old == leader {
+			return
+		}
+
+		// 异步进行的选举获胜，自身成为 shard 的主节点
+		if leader == c.self {
+			c.promoteSelf()
+			return
+		}
+
+		// 更新自身视图，旧主节点负责的槽全部转交给新主节点
+		updateShardMaster(old, leader)
 
 		if msg.Shard != c.selfShard {
 
-			c.assignShardToNode(msg.Shard, leader)
+			c.transferSlots(old, leader)
 
-		} else {
+		} else if msg.Content != c.self.name {
 
-			// 其他节点竞选成功，自身更换为上线状态
-			if msg.Content != c.self.name {
-				c.server.sendPSyncToMaster(msg.Content)
-				c.state = ClusterOK
+			// 其他节点竞选成功，自身作为从节点连接到新的主节点。复制 id 以及 offset 被保留，可以进行增量复制
+			c.failover = clusterManualFailover{}
+			switch c.server.role {
+			case Master:
+				c.server.masterToStandAlone()
+			case Slave:
+				c.server.slaveToStandAlone()
+			}
+			if !c.server.sendPSyncToMaster(msg.Content) {
+				logger.Error("Cluster: failed to replicate new master", msg.Content)
 			}
+			c.state = ClusterOK
 		}
 	case MNodeUp:
 
@@ -425,10 +569,10 @@ func (c *clusterStatus) handleClusterChangeMessage(msg *clusterChangeMessage) {
 
 		upNode.alive = true
 
-		// 通过 shard 序号计算出 master
-		shardWidth := slotNum / c.config.ShardNum
-		master := c.slots[msg.Shard*shardWidth]
-		upNode.slaveOfNode(master)
+		// 槽可能已经被迁移，需要通过 shard 中的节点找到 master
+		if master := c.shardMaster(msg.Shard); master != nil && master != upNode {
+			upNode.slaveOfNode(master)
+		}
 
 	case MNodeDown:
 
@@ -448,25 +592,231 @@ func (c *clusterStatus) handleClusterChangeMessage(msg *clusterChangeMessage) {
 		delete(c.nodes, msg.Content)
 
 		downNode.alive = false
-		downNode.slaveOfNone()
-		downNode.slaves = []*clusterNode{}
 
+		if downNode.isMaster() {
+			// 保留下线主节点的从节点以及负责的槽，故障转移完成后会全部转交给新的主节点
+			if downNode == c.self.slaveOf && c.state == ClusterOK {
+				logger.Warning("Cluster: master is down, start failover, shard:", c.selfShard)
+				c.state = ClusterDown
+			}
+		} else if downNode.slaveOf != nil {
+			downNode.slaveOf.removeSlave(downNode)
+			downNode.slaveOfNone()
+		}
+
+	case MSlotMoved:
+
+		node, exist := c.nodes[msg.Content]
+		if !exist {
+			logger.Error(fmt.Sprintf("Cluster slot %d moved to nonexistent node %s", msg.Slot, msg.Content))
+			return
+		}
+		if msg.Slot < 0 || msg.Slot >= slotNum {
+			logger.Error(fmt.Sprintf("Cluster moved slot %d out of range", msg.Slot))
+			return
+		}
+
+		c.assignSlot(msg.Slot, node)
+		delete(c.importing, msg.Slot)
+		delete(c.migrating, msg.Slot)
 	}
 }
 
-func (c *clusterStatus) assignShardToNode(shardNum int, node *clusterNode) {
-	// 更改 shard 上的所有 slot
-	shardWidth := slotNum / c.config.ShardNum
-	start := shardNum * shardWidth
-	end := start + shardWidth
-	if shardNum == c.config.ShardNum-1 {
-		end = slotNum
+// transferSlots 将 old 负责的所有槽转交给 new
+func (c *clusterStatus) transferSlots(old, new *clusterNode) {
+	if old == new {
+		return
 	}
-	for j := start; j < end; j++ {
-		c.slots[j] = node
+	for j := range c.slots {
+		if c.slots[j] == old {
+			c.slots[j] = new
+		}
 	}
 }
 
+// shardMaster 返回 shard 当前的主节点，如果 shard 中所有节点都已经下线，返回 nil
+func (c *clusterStatus) shardMaster(shard int) *clusterNode {
+	if shard < 0 || shard >= len(c.config.Shards) {
+		return nil
+	}
+	for _, name := range c.config.Shards[shard] {
+		if node, exist := c.nodes[name]; exist && node.slaveOf != nil && node.slaveOf.alive {
+			return node.slaveOf
+		}
+	}
+	return nil
+}
+
+// assignSlot 修改槽在当前节点视图中的负责节点。与 initLocalShard 相同，同一个 shard 中的从节点也会负责该槽；
+// 记录的负责节点在故障转移后可能已经成为从节点，此时槽交由其主节点负责
+func (c *clusterStatus) assignSlot(slot int, node *clusterNode) {
+	if node.slaveOf != nil && node.slaveOf == c.self.slaveOf {
+		node = c.self
+	} else if node.slaveOf != nil {
+		node = node.slaveOf
+	}
+	c.slots[slot] = node
+}
+
+// setSlotNode 将槽交由 node 负责，并向集群宣布该变更
+func (c *clusterStatus) setSlotNode(slot int, node *clusterNode) {
+	c.assignSlot(slot, node)
+	delete(c.importing, slot)
+	delete(c.migrating, slot)
+	c.watcher.slotAnnounce(slot, node.name)
+}
+
+// slotMigration 记录一个正在迁出的槽的后台迁移状态。每个槽同一时刻只有一批键在后台发送，
+// 发送期间被修改的键会通过 watch 发现，这些键不会在本地删除，之后会重新迁移
+type slotMigration struct {
+	slot    int
+	target  *clusterNode
+	running bool                // 是否有一批键正在后台发送
+	batch   *migrateBatch       // 正在发送的一批键
+	flags   []bool              // 键在发送期间是否被修改，与 batch.keys 一一对应
+	stale   map[string]struct{} // 已经迁移但在本地被删除的键，需要在迁入节点中删除
+}
+
+// migrateSlots 将正在迁出的槽中的键分批迁移到目标节点，槽中的键全部迁移完成后，向集群宣布槽的新负责节点。
+// 迁移是逐键进行的，迁移过程中访问已经迁出的键会收到 ASK 重定向。迁移请求在事件循环中生成，连接与读写在后台协程中进行
+func (c *clusterStatus) migrateSlots() {
+
+	// 处理后台已经完成发送的迁移
+	for finished := false; !finished; {
+		select {
+		case m := <-c.migrated:
+			c.finishSlotMigration(m)
+		default:
+			finished = true
+		}
+	}
+
+	// 迁移已经被取消的槽不再需要记录状态
+	for slot, m := range c.migrations {
+		if !m.running && c.migrating[slot] != m.target {
+			delete(c.migrations, slot)
+		}
+	}
+
+	if len(c.migrating) == 0 || !c.self.isMaster() {
+		return
+	}
+
+	base := c.server.dbs[0]
+
+	for slot, target := range c.migrating {
+
+		m, ok := c.migrations[slot]
+		if ok && m.running {
+			continue
+		} else if !ok {
+			m = &slotMigration{slot: slot, target: target, stale: make(map[string]struct{})}
+			c.migrations[slot] = m
+		}
+
+		keys, n := base.KeysInSlot(slot, slotMigrateBatch)
+		if n == 0 && len(m.stale) == 0 {
+			logger.Infof("Cluster: slot %d migrated to %s", slot, target.name)
+			delete(c.migrations, slot)
+			c.setSlotNode(slot, target)
+			continue
+		}
+
+		c.startSlotMigration(m, keys[:n])
+	}
+}
+
+// startSlotMigration 生成迁移请求，并在后台协程中发送到迁入节点
+func (c *clusterStatus) startSlotMigration(m *slotMigration, keys []string) {
+
+	base := c.server.dbs[0]
+
+	opts := &migrateOptions{
+		addr:    m.target.name,
+		timeout: migrateDefaultTimeout,
+		replace: true,
+		asking:  true,
+		keys:    make([][]byte, len(keys)),
+	}
+	for i := range keys {
+		opts.keys[i] = []byte(keys[i])
+	}
+	for key := range m.stale {
+		opts.deletes = append(opts.deletes, []byte(key))
+	}
+
+	batch := migrateRequest(base, opts)
+	for _, key := range batch.failed {
+		logger.Warningf("Cluster: can't migrate key %s in slot %d: %s", key, m.slot, batch.dumpErr.Error())
+	}
+	if len(batch.keys) == 0 && len(batch.deletes) == 0 {
+		return
+	}
+
+	m.batch = batch
+	m.flags = make([]bool, len(batch.keys))
+	for i, key := range batch.keys {
+		base.Watch(string(key), &m.flags[i])
+	}
+	m.running = true
+
+	go func() {
+		batch.send()
+		c.migrated <- m
+	}()
+}
+
+// finishSlotMigration 在事件循环中处理一批键的发送结果，删除已经迁移并且在发送期间没有被修改的键
+func (c *clusterStatus) finishSlotMigration(m *slotMigration) {
+
+	base := c.server.dbs[0]
+	batch := m.batch
+
+	m.running = false
+	m.batch = nil
+	for i, key := range batch.keys {
+		base.UnWatch(string(key), &m.flags[i])
+	}
+
+	if batch.err != nil {
+		logger.Warningf("Cluster: migrate slot %d to %s failed: %s", m.slot, m.target.name, batch.err.ByteData())
+	}
+
+	// 迁移已经被取消或者自身已经不是主节点时，本地的键需要保留
+	if c.migrating[m.slot] != m.target || !c.self.isMaster() {
+		return
+	}
+
+	if batch.headerDone {
+		for _, key := range batch.deletes {
+			delete(m.stale, string(key))
+		}
+	}
+
+	migrated := make([][]byte, 0, len(batch.keys))
+	for i, key := range batch.keys {
+
+		if !batch.done[i] {
+			continue
+		}
+		delete(m.stale, string(key))
+
+		// 发送期间被修改的键保留在本地，之后会重新迁移；已经被删除的键需要在迁入节点中删除
+		if m.flags[i] {
+			if !base.ExistKey(string(key)) {
+				m.stale[string(key)] = struct{}{}
+			}
+			continue
+		}
+		migrated = append(migrated, key)
+	}
+
+	c.server.removeMigratedKeys(NewFakeClient(), migrated)
+
+	// 迁出的键需要在本地的 aof 与从节点中删除
+	c.server.propagateDeferred()
+}
+
 func updateShardMaster(old, new *clusterNode) {
 	if old == new {
 		return
@@ -493,6 +843,40 @@ func updateShardMaster(old, new *clusterNode) {
 
 }
 
+// slotMaster 返回负责槽的主节点，当前节点视图中由从节点负责的槽会返回从节点的主节点
+func (c *clusterStatus) slotMaster(slot int) *clusterNode {
+	node := c.slots[slot]
+	if node == nil || node.slaveOf == nil {
+		return node
+	}
+	return node.slaveOf
+}
+
+// slotRange 是一段连续的、由同一个主节点负责的槽
+type slotRange struct {
+	start, end int
+	master     *clusterNode
+}
+
+// slotRanges 将所有已分配的槽按照负责的主节点合并为连续的区间
+func (c *clusterStatus) slotRanges() []slotRange {
+
+	ranges := make([]slotRange, 0)
+
+	for i := range c.slots {
+		master := c.slotMaster(i)
+		if master == nil {
+			continue
+		}
+		if n := len(ranges); n > 0 && ranges[n-1].master == master && ranges[n-1].end == i-1 {
+			ranges[n-1].end = i
+			continue
+		}
+		ranges = append(ranges, slotRange{start: i, end: i, master: master})
+	}
+	return ranges
+}
+
 func (c *clusterStatus) aliveNodesNum() int {
 	return len(c.nodes)
 }
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"errors"
+	"github.com/tangrc99/MemTable/logger"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"net"
+	"strconv"
+	"time"
+)
+
+// 手动故障转移的模式
+const (
+	failoverNone     = iota
+	failoverDefault  // 主节点暂停写入，从节点追上主节点的 offset 后发起选举
+	failoverForce    // 不与主节点握手，直接发起选举
+	failoverTakeover // 不进行选举，直接成为 shard 的主节点
+)
+
+// clusterManualFailoverTimeout 是手动故障转移的最长时间，与 redis 相同；主节点会暂停写入两倍的时间
+const clusterManualFailoverTimeout = 5 * time.Second
+
+// clusterManualFailover 记录正在进行中的手动故障转移
+type clusterManualFailover struct {
+	mode     int
+	offset   uint64    // 默认模式下需要追赶的主节点 offset
+	deadline time.Time // 超过截止时间后，手动故障转移会被放弃
+}
+
+// startManualFailover 开始一次手动故障转移，故障转移会在之后的时间事件中异步完成
+func (c *clusterStatus) startManualFailover(mode int) error {
+
+	c.failover = clusterManualFailover{mode: mode, deadline: global.Now.Add(clusterManualFailoverTimeout)}
+
+	if mode != failoverDefault {
+		return nil
+	}
+
+	offset, err := c.requestMasterPause()
+	if err != nil {
+		c.failover = clusterManualFailover{}
+		return err
+	}
+	c.failover.offset = offset
+
+	logger.Infof("Cluster: manual failover waiting for replication offset %d", offset)
+	return nil
+}
+
+// requestMasterPause 请求主节点暂停写入，并获取主节点当前的复制 offset
+func (c *clusterStatus) requestMasterPause() (uint64, error) {
+
+	conn, err := net.DialTimeout("tcp", c.self.slaveOf.name, time.Second)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	pause := strconv.FormatInt(int64(2*clusterManualFailoverTimeout/time.Millisecond), 10)
+	request := resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("cluster")),
+		resp.MakeBulkData([]byte("mfstart")),
+		resp.MakeBulkData([]byte(pause)),
+	}).ToBytes()
+
+	_ = conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = conn.Write(request); err != nil {
+		return 0, err
+	}
+
+	parsed := resp.NewParser(conn).Parse()
+	if parsed.Err != nil {
+		return 0, parsed.Err
+	}
+
+	switch reply := parsed.Data.(type) {
+	case *resp.IntData:
+		return uint64(reply.Data()), nil
+	case *resp.ErrorData:
+		return 0, errors.New(reply.Error())
+	}
+	return 0, errors.New("unexpected reply from master")
+}
+
+// handleManualFailover 推进正在进行的手动故障转移，在集群的时间事件中被调用
+func (c *clusterStatus) handleManualFailover() {
+
+	mf := c.failover
+	if mf.mode == failoverNone {
+		return
+	}
+
+	if c.self.isMaster() {
+		c.failover = clusterManualFailover{}
+		return
+	}
+
+	if global.Now.After(mf.deadline) {
+		logger.Warning("Cluster: manual failover timed out")
+		c.failover = clusterManualFailover{}
+		return
+	}
+
+	// 默认模式下需要等待复制追上主节点，保证不会丢失任何写入
+	if mf.mode == failoverDefault && c.server.offset < mf.offset {
+		return
+	}
+
+	// 选举可能是异步进行的，没有完成时在下一次时间事件中继续推进，直到超过截止时间
+	if !c.watcher.manualFailover(mf.mode == failoverTakeover) {
+		return
+	}
+
+	c.promoteSelf()
+}
+
+// promoteSelf 将自身提升为 shard 的主节点：接管旧主节点负责的槽，并且保留复制 offset，
+// shard 中的其他从节点收到新主节点的通知后可以通过 psync 继续增量复制
+func (c *clusterStatus) promoteSelf() {
+
+	old := c.self.slaveOf
+	updateShardMaster(old, c.self)
+	c.transferSlots(old, c.self)
+
+	c.server.slaveToMaster()
+	c.server.StartEvictionNotification()
+
+	c.failover = clusterManualFailover{}
+	c.state = ClusterOK
+
+	logger.Info("Cluster: promoted to master, shard:", c.selfShard)
+}
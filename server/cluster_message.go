@@ -53,6 +53,7 @@ const (
 	MAnnounce
 	MNodeUp
 	MNodeDown
+	MSlotMoved
 )
 
 type clusterChangeMessage struct {
@@ -60,6 +61,7 @@ type clusterChangeMessage struct {
 	Shard     int    `json:"shard"`               // 事件发生的 shard
 	EType     int    `json:"type"`                // 事件类型
 	Content   string `json:"content"`             // 事件内容
+	Slot      int    `json:"slot,omitempty"`      // 负责节点发生变更的槽，只用于 MSlotMoved 事件
 }
 
 func generateNewLeaderMessage(shard int, newLeader string) string {
@@ -120,6 +122,21 @@ func generateNodeUpMessage(shard int, content string) string {
 	return string(marshal)
 }
 
+func generateSlotMovedMessage(slot int, node string) string {
+
+	msg := clusterChangeMessage{
+		EType:   MSlotMoved,
+		Content: node,
+		Slot:    slot,
+	}
+
+	marshal, err := json.Marshal(msg)
+	if err != nil {
+		return ""
+	}
+	return string(marshal)
+}
+
 /* ---------------------------------------------------------------------------
 * 配置
 * ------------------------------------------------------------------------- */
@@ -140,6 +157,9 @@ func (config *clusterConfig) isValid() (valid bool, reason string) {
 	nodeMap := make(map[string]struct{})
 
 	for i := range config.Shards {
+		if len(config.Shards[i]) == 0 {
+			return false, "Empty shard"
+		}
 		for j := range config.Shards[i] {
 			if _, exist := nodeMap[config.Shards[i][j]]; exist {
 				return false, "Duplicate node: " + config.Shards[i][j]
@@ -148,7 +168,8 @@ func (config *clusterConfig) isValid() (valid bool, reason string) {
 		}
 	}
 
-	if len(nodeMap) != config.ShardNum {
+	// 每个 shard 可以包含多个节点，shard_num 只需要与 shard 的数量一致
+	if len(config.Shards) != config.ShardNum {
 		return false, "Invalid shard_num"
 	}
 	return true, ""
@@ -9,6 +9,11 @@ func TestClusterMessage(t *testing.T) {
 		t.Error("Message Generate Failed")
 	}
 
+	if generateSlotMovedMessage(12, "0.0.0.0:6380") != ""+
+		"{\"shard\":0,\"type\":4,\"content\":\"0.0.0.0:6380\",\"slot\":12}" {
+		t.Error("Message Generate Failed")
+	}
+
 }
 
 func TestClusterJson(t *testing.T) {
@@ -54,3 +59,23 @@ func TestClusterJson(t *testing.T) {
 		println(string(sts.toJson()))
 	}
 }
+
+func TestClusterConfigValid(t *testing.T) {
+
+	tests := []struct {
+		config clusterConfig
+		valid  bool
+	}{
+		{clusterConfig{ClusterName: "c", ShardNum: 2, Shards: [][]string{{"a:1", "b:1"}, {"c:1"}}}, true},
+		{clusterConfig{ClusterName: "c", ShardNum: 3, Shards: [][]string{{"a:1", "b:1"}, {"c:1"}}}, false},
+		{clusterConfig{ClusterName: "c", ShardNum: 2, Shards: [][]string{{"a:1"}, {"a:1"}}}, false},
+		{clusterConfig{ClusterName: "c", ShardNum: 2, Shards: [][]string{{"a:1"}, {}}}, false},
+		{clusterConfig{ShardNum: 1, Shards: [][]string{{"a:1"}}}, false},
+	}
+
+	for i, test := range tests {
+		if valid, reason := test.config.isValid(); valid != test.valid {
+			t.Errorf("config %d: expected %v, reason: %s", i, test.valid, reason)
+		}
+	}
+}
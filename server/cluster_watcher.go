@@ -16,9 +16,14 @@ type clusterWatcher interface {
 	// initCampaign 用来初始化选举的相关信息
 	initCampaign(shardNum int, isMaster bool) bool
 
-	// campaign 用于当 shard 内主节点下线时进行选举
+	// campaign 用于当 shard 内主节点下线时进行选举，返回 true 代表当前节点成为主节点。
+	// 选举可能是异步进行的，返回 false 时调用者需要在之后再次调用来推进选举
 	campaign() bool
 
+	// manualFailover 用于 CLUSTER FAILOVER 发起的故障转移，此时主节点可能仍然在线。
+	// takeover 为 true 时不需要获得其他节点的同意，直接成为 shard 的主节点。与 campaign 相同，选举可能是异步进行的
+	manualFailover(takeover bool) bool
+
 	// leaderAnnounce 周期性地向集群宣布自身是主节点，宣布已经下线的节点。这是为了防止刚刚上线的节点没有更新自身的视图；
 	// 只有主节点会宣布已下线节点，当主节点下线后，只有集群内部完成选举，新主节点才会向集群宣布旧主下线
 	leaderAnnounce(nodes []string)
@@ -27,6 +32,15 @@ type clusterWatcher interface {
 	// 也不会影响集群的工作状态
 	upNodeAnnounce(node string)
 
+	// slotAnnounce 宣布槽的新负责节点，并记录下来，使得所有节点（包括之后重启的节点）的槽视图保持一致
+	slotAnnounce(slot int, node string)
+
+	// getSlotOwners 获取所有发生过迁移的槽当前的负责节点，没有迁移过的槽仍然按照配置文件分配
+	getSlotOwners() map[int]string
+
+	// configEpoch 返回主节点 master 所在 shard 的配置纪元，用于 CLUSTER NODES 的输出。如果不使用纪元则返回 0
+	configEpoch(master string) uint64
+
 	// doSomething 将会被周期性地调用，如果集群需要额外做一些工作，可以在这里实现
 	doSomething()
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/tangrc99/MemTable/logger"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/concurrency"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -192,6 +193,24 @@ retry:
 	return true
 }
 
+// manualFailover 删除当前主节点在选举中持有的 key 后重新竞选。etcd 中的选举本身需要多数派的同意，
+// 因此 takeover 与普通模式相同
+func (e *etcdWatcher) manualFailover(_ bool) bool {
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+	defer cancel()
+
+	leader, err := e.ele.Leader(ctx)
+	if err == nil && len(leader.Kvs) > 0 && string(leader.Kvs[0].Value) != e.host {
+		if _, err = e.cli.Delete(ctx, string(leader.Kvs[0].Key)); err != nil {
+			logger.Error("Cluster etcd: remove old leader failed, info:", err.Error())
+			return false
+		}
+	}
+
+	return e.campaign()
+}
+
 func (e *etcdWatcher) leaderAnnounce(downNodes []string) {
 
 	if e.called%announceInterval != 0 {
@@ -242,6 +261,50 @@ func (e *etcdWatcher) upNodeAnnounce(node string) {
 	}
 }
 
+func (e *etcdWatcher) slotAnnounce(slot int, node string) {
+
+	// 槽的负责节点需要持久化，否则重启的节点会按照配置文件重新分配槽
+	tx := e.cli.Txn(context.TODO())
+	tx.Then(clientv3.OpPut(e.slotKey(slot), node), clientv3.OpPut(e.publishChannel(), generateSlotMovedMessage(slot, node)))
+
+	ret, err := tx.Commit()
+	if err != nil {
+		logger.Error("Cluster Publish Message Error, Info", err.Error())
+	} else if !ret.Succeeded {
+		logger.Error("Cluster Publish Message Error, slot:", slot)
+	}
+}
+
+func (e *etcdWatcher) getSlotOwners() map[int]string {
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+	defer cancel()
+
+	prefix := e.slotPrefix()
+
+	res, err := e.cli.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		logger.Error("Cluster etcd pull slot owners error, info:", err.Error())
+		return nil
+	}
+
+	owners := make(map[int]string, len(res.Kvs))
+	for _, kv := range res.Kvs {
+		slot, err := strconv.Atoi(strings.TrimPrefix(string(kv.Key), prefix))
+		if err != nil {
+			logger.Error("Cluster etcd wrong slot key:", string(kv.Key))
+			continue
+		}
+		owners[slot] = string(kv.Value)
+	}
+	return owners
+}
+
+// configEpoch 始终返回 0，etcd 中的选举由 etcd 自身保证一致性，不使用配置纪元
+func (e *etcdWatcher) configEpoch(_ string) uint64 {
+	return 0
+}
+
 /* ---------------------------------------------------------------------------
 * utils 函数
 * ------------------------------------------------------------------------- */
@@ -250,6 +313,14 @@ func (e *etcdWatcher) publishChannel() string {
 	return fmt.Sprintf("/%s/channel", e.clusterName)
 }
 
+func (e *etcdWatcher) slotPrefix() string {
+	return fmt.Sprintf("/%s/slots/", e.clusterName)
+}
+
+func (e *etcdWatcher) slotKey(slot int) string {
+	return e.slotPrefix() + strconv.Itoa(slot)
+}
+
 func (e *etcdWatcher) electionChannel() string {
 	return fmt.Sprintf("/%s/election/%s", e.clusterName, e.shardName)
 }
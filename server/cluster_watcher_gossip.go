@@ -0,0 +1,876 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/tangrc99/MemTable/config"
+	"github.com/tangrc99/MemTable/logger"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// gossip 总线中的消息类型
+const (
+	gossipPing = iota
+	gossipPong
+	gossipFail
+	gossipPublish
+	gossipAuthRequest
+	gossipAuthAck
+)
+
+const (
+	// gossipDialTimeout 是连接其他节点以及发送消息的超时时间
+	gossipDialTimeout = time.Second
+	// gossipAuthTimeout 是从节点等待选票的最短时间，实际的等待时间为该值与两倍节点超时时间中的较大值，与 redis 相同
+	gossipAuthTimeout = 2 * time.Second
+	// gossipOutboxSize 是每个节点的发送队列长度，队列已满时新的消息会被丢弃，gossip 消息会周期性地重新发送
+	gossipOutboxSize = 64
+	// gossipReportValidity 是疑似下线报告的有效期与节点超时时间的比值
+	gossipReportValidity = 2
+)
+
+// gossipShardMaster 记录 shard 的主节点以及对应的配置纪元，纪元更大的视图会覆盖纪元更小的视图
+type gossipShardMaster struct {
+	Name  string `json:"name"`
+	Epoch uint64 `json:"epoch"`
+}
+
+// gossipSlotOwner 记录迁移过的槽的负责节点以及对应的配置纪元
+type gossipSlotOwner struct {
+	Node  string `json:"node"`
+	Epoch uint64 `json:"epoch"`
+}
+
+// gossipNodeState 是发送者对其他节点状态的判断，消息中只会携带疑似下线或者已经下线的节点
+type gossipNodeState struct {
+	Name  string `json:"name"`
+	PFail bool   `json:"pfail,omitempty"`
+	Fail  bool   `json:"fail,omitempty"`
+}
+
+// gossipMessage 是 gossip 总线中传输的消息，每一条消息都携带发送者的集群视图
+type gossipMessage struct {
+	Type         int                     `json:"type"`
+	Sender       string                  `json:"sender"`
+	CurrentEpoch uint64                  `json:"current_epoch"`
+	Masters      []gossipShardMaster     `json:"masters,omitempty"` // 发送者视图中每个 shard 的主节点
+	Slots        map[int]gossipSlotOwner `json:"slots,omitempty"`   // 发送者视图中迁移过的槽
+	Gossip       []gossipNodeState       `json:"gossip,omitempty"`
+	Shard        int                     `json:"shard,omitempty"`  // 选举请求中的 shard
+	Manual       bool                    `json:"manual,omitempty"` // 选举请求是否由手动故障转移发起，此时主节点可能仍然在线
+	Failed       string                  `json:"failed,omitempty"` // FAIL 消息中被判定下线的节点
+	Change       *clusterChangeMessage   `json:"change,omitempty"` // PUBLISH 消息中的集群事件
+}
+
+// gossipPeer 记录了集群中其他节点的状态
+type gossipPeer struct {
+	name  string
+	shard int
+
+	outbox chan gossipOutbound // 等待发送的消息，由 sendLoop 负责建立连接以及写入
+
+	pingSent time.Time // 尚未收到回复的 ping 的发送时间，零值代表没有等待中的 ping
+	lastPing time.Time // 上一次发送 ping 的时间
+	pongRecv time.Time // 上一次收到消息的时间
+
+	pfail   bool                 // 当前节点认为该节点疑似下线
+	fail    bool                 // 集群中的多数主节点认为该节点已经下线
+	reports map[string]time.Time // 其他主节点报告该节点疑似下线的时间
+}
+
+// gossipOutbound 是发送队列中的一条消息，reconnect 为 true 时会在发送前重新建立连接
+type gossipOutbound struct {
+	data      []byte
+	reconnect bool
+}
+
+// gossipWatcher 使用节点之间的 gossip 总线实现 clusterWatcher，不需要部署 etcd。
+// 每个节点周期性地向其他节点发送 ping，超时未回复的节点会被标记为 PFAIL；当多数主节点都报告某个节点 PFAIL 时，
+// 该节点被标记为 FAIL 并广播到整个集群。shard 的主节点与迁移过的槽都带有配置纪元，纪元更大的视图会覆盖纪元更小的视图，
+// 从节点需要获得多数主节点的选票才能成为新的主节点
+type gossipWatcher struct {
+	mu sync.Mutex
+
+	config  clusterConfig
+	host    string // 当前节点名称
+	shard   int    // 当前节点所在的 shard
+	timeout time.Duration
+
+	currentEpoch  uint64
+	lastVoteEpoch uint64            // 上一次投票的纪元，每个纪元只能投出一票
+	lastVoteTime  map[int]time.Time // 每个 shard 上一次投票的时间，防止同一个 shard 的多个从节点先后当选
+	voteEpoch     uint64            // 当前发起的选举的纪元
+	votes         map[string]struct{}
+	voteDeadline  time.Time // 当前选举的截止时间，超过截止时间仍然没有获得足够的选票视为选举失败
+	voteRetry     time.Time // 下一次允许发起选举的时间
+
+	masters []gossipShardMaster
+	slots   map[int]gossipSlotOwner
+	peers   map[string]*gossipPeer
+
+	listener net.Listener
+	inbound  map[net.Conn]struct{}
+
+	queue  []clusterChangeMessage // 尚未投递的集群事件
+	signal chan struct{}
+	ntf    chan clusterChangeMessage
+	quit   chan struct{}
+	once   sync.Once
+
+	clusterWatcher
+}
+
+func initGossipWatcher(clusterName string, host string) *gossipWatcher {
+
+	ccfg := loadClusterConfig(clusterName, config.Conf.ClusterConfigFile)
+
+	listener, err := net.Listen("tcp", clusterBusAddr(host))
+	if err != nil {
+		logger.Panic("Cluster gossip bus listen error:", err.Error())
+	}
+
+	return newGossipWatcher(ccfg, host, time.Duration(config.Conf.ClusterNodeTimeout)*time.Millisecond, listener)
+}
+
+// loadClusterConfig 从本地文件中读取集群配置，格式与 etcd 中保存的配置相同
+func loadClusterConfig(clusterName string, file string) clusterConfig {
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		logger.Panic("Cluster read config error, info:", err.Error())
+	}
+
+	ccfg := clusterConfig{}
+	ccfg.ClusterName = clusterName
+
+	if err = json.Unmarshal(content, &ccfg); err != nil {
+		logger.Panic("Cluster parse config error, info:", err.Error())
+	}
+
+	if valid, reason := ccfg.isValid(); !valid {
+		logger.Panic("Cluster Invalid Config", reason)
+	}
+
+	return ccfg
+}
+
+// clusterBusAddr 返回节点的集群总线地址
+func clusterBusAddr(name string) string {
+	host, port, err := net.SplitHostPort(name)
+	if err != nil {
+		return name
+	}
+	p, _ := strconv.Atoi(port)
+	return net.JoinHostPort(host, strconv.Itoa(p+clusterBusPortOffset))
+}
+
+func newGossipWatcher(ccfg clusterConfig, host string, timeout time.Duration, listener net.Listener) *gossipWatcher {
+
+	g := &gossipWatcher{
+		config:       ccfg,
+		host:         host,
+		shard:        -1,
+		timeout:      timeout,
+		lastVoteTime: make(map[int]time.Time),
+		masters:      make([]gossipShardMaster, len(ccfg.Shards)),
+		slots:        make(map[int]gossipSlotOwner),
+		peers:        make(map[string]*gossipPeer),
+		listener:     listener,
+		inbound:      make(map[net.Conn]struct{}),
+		signal:       make(chan struct{}, 1),
+		ntf:          make(chan clusterChangeMessage, 20),
+		quit:         make(chan struct{}),
+	}
+
+	// 没有发生过故障转移时，配置中每个 shard 的第一个节点是主节点
+	for i, shard := range ccfg.Shards {
+		g.masters[i] = gossipShardMaster{Name: shard[0]}
+		for _, name := range shard {
+			if name == host {
+				g.shard = i
+				continue
+			}
+			g.peers[name] = &gossipPeer{
+				name: name, shard: i, outbox: make(chan gossipOutbound, gossipOutboxSize), reports: make(map[string]time.Time),
+			}
+		}
+	}
+
+	if g.shard < 0 {
+		logger.Error("Cluster gossip: node is not in config:", host)
+	}
+
+	for _, p := range g.peers {
+		go g.sendLoop(p)
+	}
+
+	go g.acceptLoop()
+	go g.cronLoop()
+	go g.deliverLoop()
+
+	return g
+}
+
+func (g *gossipWatcher) getClusterConfig() clusterConfig {
+	return g.config
+}
+
+func (g *gossipWatcher) watchClusterChanges() <-chan clusterChangeMessage {
+	return g.ntf
+}
+
+func (g *gossipWatcher) whoIsMaster() string {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.shard < 0 {
+		return ""
+	}
+
+	master := g.masters[g.shard].Name
+	if p, exist := g.peers[master]; exist && p.fail {
+		return ""
+	}
+	return master
+}
+
+// initCampaign 会等待一轮 ping，如果其他节点的视图中当前 shard 已经有了更新的主节点，说明该节点下线期间发生了故障转移，
+// 需要降级为从节点
+func (g *gossipWatcher) initCampaign(shardNum int, isMaster bool) bool {
+
+	deadline := time.Now().Add(g.timeout)
+	if deadline.After(time.Now().Add(time.Second)) {
+		deadline = time.Now().Add(time.Second)
+	}
+
+	for time.Now().Before(deadline) && !g.heardFromAll() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.shard = shardNum
+
+	if isMaster && g.masters[shardNum].Name != g.host {
+		logger.Errorf("Cluster gossip: shard %d already has master %s", shardNum, g.masters[shardNum].Name)
+		return false
+	}
+	return true
+}
+
+func (g *gossipWatcher) heardFromAll() bool {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, p := range g.peers {
+		if p.pongRecv.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// campaign 只有在主节点被集群判定为 FAIL 之后才会发起选举，获得多数主节点的选票后，当前节点成为 shard 的主节点。
+// 选举是异步进行的，调用者需要周期性地调用，直到返回 true
+func (g *gossipWatcher) campaign() bool {
+
+	g.mu.Lock()
+
+	if g.shard < 0 {
+		g.mu.Unlock()
+		return false
+	}
+	if g.masters[g.shard].Name == g.host {
+		g.mu.Unlock()
+		return true
+	}
+	master, exist := g.peers[g.masters[g.shard].Name]
+	if !exist || !master.fail {
+		g.mu.Unlock()
+		return false
+	}
+	g.mu.Unlock()
+
+	return g.requestVotes(false)
+}
+
+// manualFailover 不要求主节点已经下线。takeover 模式下直接使用更大的纪元宣布自身为主节点，其他节点会接受纪元更大的配置
+func (g *gossipWatcher) manualFailover(takeover bool) bool {
+
+	if !takeover {
+		return g.requestVotes(true)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.shard < 0 {
+		return false
+	}
+
+	g.currentEpoch++
+	g.masters[g.shard] = gossipShardMaster{Name: g.host, Epoch: g.currentEpoch}
+	g.broadcast(g.makeMessage(gossipPong))
+
+	logger.Info("Cluster Takeover Succeed, shard:", g.shard)
+	return true
+}
+
+// authTimeout 返回等待选票的最长时间
+func (g *gossipWatcher) authTimeout() time.Duration {
+	if 2*g.timeout > gossipAuthTimeout {
+		return 2 * g.timeout
+	}
+	return gossipAuthTimeout
+}
+
+// requestVotes 使用新的纪元向所有节点请求选票，并立即返回。选票在 handleMessage 中统计，获得多数主节点的选票后，
+// 当前节点成为 shard 的主节点，之后的调用会返回 true。
+//
+// 主节点在投票后的两倍节点超时时间内不会再为同一个 shard 投票，因此失败的选举需要等待两倍的选举超时时间才能重试，
+// 重试时间中加入随机的延迟，使得同时发起选举的多个从节点不会一直平分选票
+func (g *gossipWatcher) requestVotes(manual bool) bool {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.shard < 0 {
+		return false
+	}
+	if g.masters[g.shard].Name == g.host {
+		return true
+	}
+
+	now := time.Now()
+	if g.votes != nil {
+		if now.Before(g.voteDeadline) {
+			return false
+		}
+		g.votes = nil
+		logger.Info("Cluster Campaign Failed, epoch:", g.voteEpoch)
+	}
+	if now.Before(g.voteRetry) {
+		return false
+	}
+
+	timeout := g.authTimeout()
+	g.currentEpoch++
+	g.voteEpoch = g.currentEpoch
+	g.votes = make(map[string]struct{})
+	g.voteDeadline = now.Add(timeout)
+	g.voteRetry = now.Add(2*timeout + time.Duration(rand.Int63n(int64(timeout))))
+
+	request := g.makeMessage(gossipAuthRequest)
+	request.Shard = g.shard
+	request.Manual = manual
+	g.broadcast(request)
+
+	return false
+}
+
+// winElection 在获得多数选票后将自身设置为 shard 的主节点，并立即向集群宣布新的配置，需要在持有锁的情况下调用
+func (g *gossipWatcher) winElection() {
+
+	g.votes = nil
+	g.masters[g.shard] = gossipShardMaster{Name: g.host, Epoch: g.voteEpoch}
+	g.broadcast(g.makeMessage(gossipPong))
+	g.emit(clusterChangeMessage{Timestamp: int64(g.voteEpoch), Shard: g.shard, EType: MNewLeader, Content: g.host})
+
+	logger.Info("Cluster Campaign Succeed, shard:", g.shard)
+}
+
+// leaderAnnounce 不需要做任何事情，每一条 gossip 消息中都携带了主节点的视图
+func (g *gossipWatcher) leaderAnnounce(_ []string) {}
+
+func (g *gossipWatcher) upNodeAnnounce(node string) {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	change := clusterChangeMessage{Timestamp: int64(g.currentEpoch), Shard: g.shard, EType: MNodeUp, Content: node}
+	g.emit(change)
+
+	msg := g.makeMessage(gossipPublish)
+	msg.Change = &change
+	g.broadcast(msg)
+}
+
+func (g *gossipWatcher) slotAnnounce(slot int, node string) {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.currentEpoch++
+	g.slots[slot] = gossipSlotOwner{Node: node, Epoch: g.currentEpoch}
+	g.emit(clusterChangeMessage{Timestamp: int64(g.currentEpoch), EType: MSlotMoved, Content: node, Slot: slot})
+
+	g.broadcast(g.makeMessage(gossipPong))
+}
+
+func (g *gossipWatcher) getSlotOwners() map[int]string {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	owners := make(map[int]string, len(g.slots))
+	for slot, owner := range g.slots {
+		owners[slot] = owner.Node
+	}
+	return owners
+}
+
+// doSomething 不需要做任何事情，周期性的任务在 cronLoop 中完成
+// configEpoch 返回当前视图中 master 成为主节点时的纪元，如果 master 不是任何 shard 的主节点则返回 0
+func (g *gossipWatcher) configEpoch(master string) uint64 {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, m := range g.masters {
+		if m.Name == master {
+			return m.Epoch
+		}
+	}
+	return 0
+}
+
+func (g *gossipWatcher) doSomething() {}
+
+// stop 关闭 gossip 总线，当前节点对于其他节点来说相当于下线
+func (g *gossipWatcher) stop() {
+	g.once.Do(func() {
+		close(g.quit)
+		_ = g.listener.Close()
+
+		// 发送连接由 sendLoop 在退出时关闭
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		for conn := range g.inbound {
+			_ = conn.Close()
+		}
+	})
+}
+
+/* ---------------------------------------------------------------------------
+* 总线
+* ------------------------------------------------------------------------- */
+
+func (g *gossipWatcher) acceptLoop() {
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return
+		}
+		g.mu.Lock()
+		g.inbound[conn] = struct{}{}
+		g.mu.Unlock()
+
+		go g.readLoop(conn)
+	}
+}
+
+func (g *gossipWatcher) readLoop(conn net.Conn) {
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.inbound, conn)
+		g.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	dec := json.NewDecoder(conn)
+	for {
+		msg := gossipMessage{}
+		if err := dec.Decode(&msg); err != nil {
+			return
+		}
+		g.handleMessage(&msg)
+	}
+}
+
+// cronLoop 周期性地发送 ping，并检查节点是否下线
+func (g *gossipWatcher) cronLoop() {
+
+	ticker := time.NewTicker(g.pingInterval() / 5)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-g.quit:
+			return
+		case <-ticker.C:
+			g.cron()
+		}
+	}
+}
+
+// deliverLoop 将集群事件按顺序投递到 channel 中，gossip 总线不会因为事件循环没有及时处理而阻塞
+func (g *gossipWatcher) deliverLoop() {
+	for {
+		select {
+		case <-g.quit:
+			return
+		case <-g.signal:
+		}
+
+		for {
+			g.mu.Lock()
+			if len(g.queue) == 0 {
+				g.mu.Unlock()
+				break
+			}
+			m := g.queue[0]
+			g.queue = g.queue[1:]
+			g.mu.Unlock()
+
+			select {
+			case g.ntf <- m:
+			case <-g.quit:
+				return
+			}
+		}
+	}
+}
+
+func (g *gossipWatcher) pingInterval() time.Duration {
+	return g.timeout / 4
+}
+
+func (g *gossipWatcher) cron() {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+
+	for _, p := range g.peers {
+
+		if p.pingSent.IsZero() {
+			if now.Sub(p.lastPing) >= g.pingInterval() {
+				p.pingSent = now
+				g.ping(p, now, false)
+			}
+			continue
+		}
+
+		elapsed := now.Sub(p.pingSent)
+
+		// 超过一半的超时时间没有回复，连接可能已经失效，重连后再次发送 ping，但不会重置等待时间
+		if elapsed > g.timeout/2 && now.Sub(p.lastPing) >= g.pingInterval() {
+			g.ping(p, now, true)
+		}
+
+		if elapsed > g.timeout && !p.pfail {
+			p.pfail = true
+			logger.Warning("Cluster gossip: node is possibly failing:", p.name)
+		}
+	}
+
+	for _, p := range g.peers {
+		if p.pfail && !p.fail {
+			g.markFailIfNeeded(p, now)
+		}
+	}
+}
+
+func (g *gossipWatcher) ping(p *gossipPeer, now time.Time, reconnect bool) {
+	p.lastPing = now
+	g.enqueue(p, g.makeMessage(gossipPing), reconnect)
+}
+
+// send 将消息放入节点的发送队列，不会进行任何网络操作，因此可以在持有锁的情况下调用
+func (g *gossipWatcher) send(p *gossipPeer, msg *gossipMessage) {
+	g.enqueue(p, msg, false)
+}
+
+func (g *gossipWatcher) enqueue(p *gossipPeer, msg *gossipMessage, reconnect bool) {
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Error("Cluster gossip: encode message failed:", err.Error())
+		return
+	}
+
+	select {
+	case p.outbox <- gossipOutbound{data: append(data, '\n'), reconnect: reconnect}:
+	default:
+	}
+}
+
+// sendLoop 负责向节点建立连接并发送队列中的消息，发送失败时会断开连接，等待下一次发送时重连。
+// 连接以及写入都可能阻塞，因此不能在持有锁的情况下进行
+func (g *gossipWatcher) sendLoop(p *gossipPeer) {
+
+	var conn net.Conn
+	closeConn := func() {
+		if conn != nil {
+			_ = conn.Close()
+			conn = nil
+		}
+	}
+	defer closeConn()
+
+	for {
+		var out gossipOutbound
+		select {
+		case <-g.quit:
+			return
+		case out = <-p.outbox:
+		}
+
+		if out.reconnect {
+			closeConn()
+		}
+		if conn == nil {
+			c, err := net.DialTimeout("tcp", clusterBusAddr(p.name), gossipDialTimeout)
+			if err != nil {
+				continue
+			}
+			conn = c
+		}
+
+		_ = conn.SetWriteDeadline(time.Now().Add(gossipDialTimeout))
+		if _, err := conn.Write(out.data); err != nil {
+			closeConn()
+		}
+	}
+}
+
+func (g *gossipWatcher) broadcast(msg *gossipMessage) {
+	for _, p := range g.peers {
+		g.send(p, msg)
+	}
+}
+
+// makeMessage 生成携带当前节点视图的消息，需要在持有锁的情况下调用
+func (g *gossipWatcher) makeMessage(typ int) *gossipMessage {
+
+	msg := &gossipMessage{
+		Type:         typ,
+		Sender:       g.host,
+		CurrentEpoch: g.currentEpoch,
+		Masters:      append([]gossipShardMaster{}, g.masters...),
+		Slots:        make(map[int]gossipSlotOwner, len(g.slots)),
+	}
+	for slot, owner := range g.slots {
+		msg.Slots[slot] = owner
+	}
+
+	if typ == gossipPing || typ == gossipPong {
+		for _, p := range g.peers {
+			if p.pfail || p.fail {
+				msg.Gossip = append(msg.Gossip, gossipNodeState{Name: p.name, PFail: p.pfail, Fail: p.fail})
+			}
+		}
+	}
+	return msg
+}
+
+// emit 将集群事件放入投递队列中，需要在持有锁的情况下调用
+func (g *gossipWatcher) emit(m clusterChangeMessage) {
+	g.queue = append(g.queue, m)
+	select {
+	case g.signal <- struct{}{}:
+	default:
+	}
+}
+
+// isMaster 判断节点在当前视图中是否是一个正常工作的主节点
+func (g *gossipWatcher) isMaster(name string) bool {
+	for _, m := range g.masters {
+		if m.Name != name {
+			continue
+		}
+		p, exist := g.peers[name]
+		return !exist || !p.fail
+	}
+	return false
+}
+
+// quorum 返回判定节点下线以及赢得选举所需的主节点数量
+func (g *gossipWatcher) quorum() int {
+	return len(g.masters)/2 + 1
+}
+
+// markFailIfNeeded 统计有效的疑似下线报告，多数主节点认为节点下线时将其标记为 FAIL 并广播
+func (g *gossipWatcher) markFailIfNeeded(p *gossipPeer, now time.Time) {
+
+	count := 0
+	for reporter, t := range p.reports {
+		if now.Sub(t) > g.timeout*gossipReportValidity {
+			delete(p.reports, reporter)
+			continue
+		}
+		if g.isMaster(reporter) {
+			count++
+		}
+	}
+	if g.isMaster(g.host) {
+		count++
+	}
+
+	if count < g.quorum() {
+		return
+	}
+
+	g.setFail(p)
+
+	msg := g.makeMessage(gossipFail)
+	msg.Failed = p.name
+	g.broadcast(msg)
+}
+
+func (g *gossipWatcher) setFail(p *gossipPeer) {
+	p.fail = true
+	p.reports = make(map[string]time.Time)
+	logger.Warning("Cluster gossip: node is failed:", p.name)
+	g.emit(clusterChangeMessage{Timestamp: int64(g.currentEpoch), Shard: p.shard, EType: MNodeDown, Content: p.name})
+}
+
+func (g *gossipWatcher) handleMessage(msg *gossipMessage) {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	sender, exist := g.peers[msg.Sender]
+	if !exist {
+		logger.Warning("Cluster gossip: message from unknown node:", msg.Sender)
+		return
+	}
+
+	// 收到任何消息都说明节点仍然在线
+	now := time.Now()
+	sender.pongRecv = now
+	sender.pingSent = time.Time{}
+	sender.pfail = false
+	if sender.fail {
+		sender.fail = false
+		logger.Info("Cluster gossip: node is reachable again:", sender.name)
+		g.emit(clusterChangeMessage{Timestamp: int64(g.currentEpoch), Shard: sender.shard, EType: MNodeUp, Content: sender.name})
+	}
+
+	if msg.CurrentEpoch > g.currentEpoch {
+		g.currentEpoch = msg.CurrentEpoch
+	}
+
+	// 纪元更大的配置覆盖当前的配置。纪元相同但内容不同时发生了冲突，名称较小的配置优先，
+	// 并且由名称较小的主节点增加纪元重新宣布自身的配置，与 redis 的 configEpoch 冲突处理相同
+	collided := false
+	for i, m := range msg.Masters {
+		if i >= len(g.masters) {
+			continue
+		}
+		current := g.masters[i]
+		if m.Epoch == current.Epoch && m.Name != current.Name && current.Name == g.host && g.host < m.Name {
+			g.currentEpoch++
+			g.masters[i].Epoch = g.currentEpoch
+			collided = true
+			logger.Warningf("Cluster gossip: config epoch collision with %s, new epoch %d", m.Name, g.currentEpoch)
+			continue
+		}
+		if m.Epoch > current.Epoch || (m.Epoch == current.Epoch && m.Name < current.Name) {
+			g.masters[i] = m
+			g.emit(clusterChangeMessage{Timestamp: int64(m.Epoch), Shard: i, EType: MNewLeader, Content: m.Name})
+		}
+	}
+	if collided {
+		g.broadcast(g.makeMessage(gossipPong))
+	}
+	for slot, owner := range msg.Slots {
+		current, exist := g.slots[slot]
+		if !exist || owner.Epoch > current.Epoch || (owner.Epoch == current.Epoch && owner.Node < current.Node) {
+			g.slots[slot] = owner
+			g.emit(clusterChangeMessage{Timestamp: int64(owner.Epoch), EType: MSlotMoved, Content: owner.Node, Slot: slot})
+		}
+	}
+
+	switch msg.Type {
+	case gossipPing, gossipPong:
+
+		g.handleGossip(msg, now)
+		if msg.Type == gossipPing {
+			g.send(sender, g.makeMessage(gossipPong))
+		}
+
+	case gossipFail:
+
+		if failed, exist := g.peers[msg.Failed]; exist && !failed.fail {
+			g.setFail(failed)
+		}
+
+	case gossipPublish:
+
+		if msg.Change != nil {
+			g.emit(*msg.Change)
+		}
+
+	case gossipAuthRequest:
+
+		g.vote(sender, msg, now)
+
+	case gossipAuthAck:
+
+		if g.votes != nil && msg.CurrentEpoch == g.voteEpoch {
+			g.votes[msg.Sender] = struct{}{}
+			if len(g.votes) >= g.quorum() {
+				g.winElection()
+			}
+		}
+	}
+}
+
+// handleGossip 记录主节点的疑似下线报告，消息中没有出现的节点说明发送者认为其正常
+func (g *gossipWatcher) handleGossip(msg *gossipMessage, now time.Time) {
+
+	if !g.isMaster(msg.Sender) {
+		return
+	}
+
+	reported := make(map[string]struct{}, len(msg.Gossip))
+	for _, state := range msg.Gossip {
+		if p, exist := g.peers[state.Name]; exist && (state.PFail || state.Fail) {
+			p.reports[msg.Sender] = now
+			reported[state.Name] = struct{}{}
+		}
+	}
+	for name, p := range g.peers {
+		if _, exist := reported[name]; !exist {
+			delete(p.reports, msg.Sender)
+		}
+	}
+}
+
+// vote 处理从节点的选举请求，只有主节点可以投票。每个纪元只能投出一票，并且只有在候选者的主节点已经下线，
+// 或者选举由手动故障转移发起时才会投票
+func (g *gossipWatcher) vote(candidate *gossipPeer, msg *gossipMessage, now time.Time) {
+
+	if !g.isMaster(g.host) || msg.CurrentEpoch < g.currentEpoch || msg.CurrentEpoch <= g.lastVoteEpoch {
+		return
+	}
+	if msg.Shard < 0 || msg.Shard >= len(g.masters) || candidate.shard != msg.Shard {
+		return
+	}
+
+	if master, exist := g.peers[g.masters[msg.Shard].Name]; !msg.Manual && (!exist || !master.fail) {
+		return
+	}
+
+	// 同一个 shard 在一段时间内只会投票一次
+	if last, voted := g.lastVoteTime[msg.Shard]; voted && now.Sub(last) < g.timeout*2 {
+		return
+	}
+
+	g.lastVoteEpoch = msg.CurrentEpoch
+	g.lastVoteTime[msg.Shard] = now
+
+	logger.Info(fmt.Sprintf("Cluster gossip: vote for %s, epoch %d", candidate.name, msg.CurrentEpoch))
+	g.send(candidate, &gossipMessage{Type: gossipAuthAck, Sender: g.host, CurrentEpoch: msg.CurrentEpoch})
+}
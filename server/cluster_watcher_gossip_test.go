@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/logger"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitClusterMessage 等待指定类型的集群事件，其他类型的事件会被丢弃
+func waitClusterMessage(t *testing.T, w clusterWatcher, eType int) clusterChangeMessage {
+	timer := time.NewTimer(3 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case m := <-w.watchClusterChanges():
+			if m.EType == eType {
+				return m
+			}
+		case <-timer.C:
+			t.Fatalf("wait cluster message %d timeout", eType)
+			return clusterChangeMessage{}
+		}
+	}
+}
+
+func TestGossipWatcher(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	// 节点名称由总线端口计算得到，保证总线端口可用
+	listeners := make([]net.Listener, 4)
+	names := make([]string, 4)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		listeners[i] = l
+		names[i] = fmt.Sprintf("127.0.0.1:%d", l.Addr().(*net.TCPAddr).Port-clusterBusPortOffset)
+	}
+
+	// 三个 shard，第三个 shard 包含一个从节点
+	cfg := clusterConfig{
+		ClusterName: "gossip",
+		ShardNum:    3,
+		Shards:      [][]string{{names[0]}, {names[1]}, {names[2], names[3]}},
+	}
+	valid, _ := cfg.isValid()
+	assert.True(t, valid)
+
+	shards := []int{0, 1, 2, 2}
+	watchers := make([]*gossipWatcher, 4)
+	for i := range watchers {
+		watchers[i] = newGossipWatcher(cfg, names[i], 200*time.Millisecond, listeners[i])
+		defer watchers[i].stop()
+	}
+	for i, w := range watchers {
+		assert.True(t, w.initCampaign(shards[i], i != 3))
+	}
+	assert.Equal(t, names[2], watchers[3].whoIsMaster())
+	assert.Equal(t, cfg, watchers[0].getClusterConfig())
+
+	// 槽迁移的结果通过 gossip 传播到所有节点
+	watchers[0].slotAnnounce(100, names[1])
+	m := waitClusterMessage(t, watchers[1], MSlotMoved)
+	assert.Equal(t, 100, m.Slot)
+	assert.Equal(t, names[1], m.Content)
+	assert.Eventually(t, func() bool {
+		return watchers[3].getSlotOwners()[100] == names[1]
+	}, time.Second, 10*time.Millisecond)
+
+	// 主节点在线时不能发起选举
+	assert.False(t, watchers[3].campaign())
+
+	// 主节点下线后被多数主节点判定为 FAIL，从节点获得选票成为新的主节点
+	watchers[2].stop()
+
+	m = waitClusterMessage(t, watchers[0], MNodeDown)
+	assert.Equal(t, names[2], m.Content)
+	assert.Equal(t, 2, m.Shard)
+
+	assert.Eventually(t, func() bool {
+		return watchers[3].whoIsMaster() == ""
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// 选举是异步进行的，获得多数选票之前 campaign 返回 false
+	assert.Eventually(t, watchers[3].campaign, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, names[3], watchers[3].whoIsMaster())
+
+	m = waitClusterMessage(t, watchers[1], MNewLeader)
+	assert.Equal(t, 2, m.Shard)
+	assert.Equal(t, names[3], m.Content)
+
+	// 新主节点的配置纪元来自选举，大于初始配置中主节点的纪元
+	assert.Eventually(t, func() bool {
+		return watchers[1].configEpoch(names[3]) > watchers[1].configEpoch(names[0])
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(0), watchers[1].configEpoch(names[2]))
+
+	// 新主节点宣布的槽纪元更大，会覆盖之前的视图
+	watchers[3].slotAnnounce(100, names[0])
+	assert.Eventually(t, func() bool {
+		return watchers[1].getSlotOwners()[100] == names[0]
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestGossipManualFailover(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	listeners := make([]net.Listener, 3)
+	names := make([]string, 3)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		listeners[i] = l
+		names[i] = fmt.Sprintf("127.0.0.1:%d", l.Addr().(*net.TCPAddr).Port-clusterBusPortOffset)
+	}
+
+	cfg := clusterConfig{
+		ClusterName: "gossip",
+		ShardNum:    2,
+		Shards:      [][]string{{names[0]}, {names[1], names[2]}},
+	}
+
+	shards := []int{0, 1, 1}
+	watchers := make([]*gossipWatcher, 3)
+	for i := range watchers {
+		watchers[i] = newGossipWatcher(cfg, names[i], 200*time.Millisecond, listeners[i])
+		defer watchers[i].stop()
+	}
+	for i, w := range watchers {
+		assert.True(t, w.initCampaign(shards[i], i != 2))
+	}
+
+	// 主节点在线时，手动故障转移同样可以获得多数主节点的选票
+	assert.False(t, watchers[2].campaign())
+	assert.Eventually(t, func() bool {
+		return watchers[2].manualFailover(false)
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, names[2], watchers[2].whoIsMaster())
+
+	m := waitClusterMessage(t, watchers[0], MNewLeader)
+	assert.Equal(t, 1, m.Shard)
+	assert.Equal(t, names[2], m.Content)
+	m = waitClusterMessage(t, watchers[1], MNewLeader)
+	assert.Equal(t, names[2], m.Content)
+
+	// takeover 不需要选票，直接使用更大的纪元覆盖其他节点的视图
+	assert.True(t, watchers[1].manualFailover(true))
+	m = waitClusterMessage(t, watchers[0], MNewLeader)
+	assert.Equal(t, names[1], m.Content)
+	assert.Eventually(t, func() bool {
+		return watchers[2].whoIsMaster() == names[1]
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestGossipEpochCollision(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	listeners := make([]net.Listener, 3)
+	names := make([]string, 3)
+	for i := range listeners {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.Nil(t, err)
+		listeners[i] = l
+		names[i] = fmt.Sprintf("127.0.0.1:%d", l.Addr().(*net.TCPAddr).Port-clusterBusPortOffset)
+	}
+
+	cfg := clusterConfig{
+		ClusterName: "gossip",
+		ShardNum:    2,
+		Shards:      [][]string{{names[0]}, {names[1], names[2]}},
+	}
+
+	watchers := make([]*gossipWatcher, 3)
+	for i := range watchers {
+		watchers[i] = newGossipWatcher(cfg, names[i], 200*time.Millisecond, listeners[i])
+		defer watchers[i].stop()
+	}
+
+	// shard 1 中的两个节点使用相同的纪元宣布自身为主节点
+	for _, w := range watchers[1:] {
+		w.mu.Lock()
+		w.currentEpoch = 5
+		w.masters[1] = gossipShardMaster{Name: w.host, Epoch: 5}
+		w.mu.Unlock()
+	}
+
+	winner := names[1]
+	if names[2] < winner {
+		winner = names[2]
+	}
+
+	// 名称较小的节点增加纪元，所有节点最终采用相同的配置
+	assert.Eventually(t, func() bool {
+		for _, w := range watchers {
+			w.mu.Lock()
+			m := w.masters[1]
+			w.mu.Unlock()
+			if m.Name != winner || m.Epoch <= 5 {
+				return false
+			}
+		}
+		return true
+	}, 3*time.Second, 10*time.Millisecond)
+}
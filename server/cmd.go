@@ -30,13 +30,19 @@ func RegisterCommand(name string, cmd Command, status ExecStatus) {
 
 func init() {
 	registerPubSubCommands()
+	registerBlockingCommands()
 	registerConnectionCommands()
+	registerClientCommands()
 	registerServerCommand()
+	registerDebugCommands()
+	registerMigrateCommands()
 	registerTransactionCommand()
 	registerReplicationCommands()
 	registerScriptCommands()
 	registerClusterCommand()
+	registerSentinelCommand()
 	registerAuthCommands()
+	registerStreamCommands()
 }
 
 func execCommand(c global.Command, server *Server, cli *Client, cmds [][]byte) resp.RedisData {
@@ -72,8 +78,13 @@ func ExecCommand(server *Server, cli *Client, cmds [][]byte, raw []byte) (ret re
 		return resp.MakeErrorData("error: empty command"), false
 	}
 
-	// 判断是否需要转移错误
-	if allowed, err := checkCommandRunnableInCluster(server, cli, cmds); !allowed {
+	commandName := strings.ToLower(string(cmds[0]))
+
+	// 判断是否需要转移错误，ASKING 只对紧随其后的一条命令有效
+	allowed, err := checkCommandRunnableInCluster(server, cli, cmds)
+	cli.asking = false
+	if !allowed {
+		cli.flagTxDirty(commandName)
 		return err, false
 	}
 
@@ -82,29 +93,40 @@ func ExecCommand(server *Server, cli *Client, cmds [][]byte, raw []byte) (ret re
 		return resp.MakeErrorData("BUSY running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE"), false
 	}
 
-	commandName := strings.ToLower(string(cmds[0]))
+	// 哨兵模式下只能执行哨兵相关的命令
+	if _, allowed := sentinelCommandTable[commandName]; server.sentinel != nil && !allowed {
+		cli.flagTxDirty(commandName)
+		return resp.MakeErrorData(fmt.Sprintf("ERR unknown command '%s'", cmds[0])), false
+	}
 
 	// 判断命令是否存在
 	c, ok := global.FindCommand(commandName)
 
 	if !ok {
+		cli.flagTxDirty(commandName)
 		return resp.MakeErrorData("error: unsupported command"), false
 	}
 
 	// 判断是否有权限访问
 	passed := checkAuthority(cli, commandName)
 	if !passed {
+		cli.flagTxDirty(commandName)
 		return resp.MakeErrorData("ERR operation not permitted"), false
 	}
 
 	writeAllowed := !(server.role == Slave && cli != server.Master)
 
 	if c.IsWriteCommand() && !writeAllowed {
+		cli.flagTxDirty(commandName)
 		return resp.MakeErrorData("ERR READONLY You can't write against a read only slave"), false
 	}
 
-	// 如果正在事务中
-	if cli.inTx && NotTxCommand(strings.ToLower(string(cmds[0]))) {
+	// 如果正在事务中，入队前检查参数数量，参数错误的事务会在 exec 时被放弃
+	if cli.inTx && NotTxCommand(commandName) {
+		if !global.CheckArity(commandName, len(cmds)) {
+			cli.txDirty = true
+			return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmds[0])), false
+		}
 		cli.tx = append(cli.tx, cmds)
 		cli.txRaw = append(cli.txRaw, raw)
 		return resp.MakeStringData("QUEUED"), false
@@ -120,7 +142,19 @@ func ExecCommand(server *Server, cli *Client, cmds [][]byte, raw []byte) (ret re
 		}
 	}
 
+	// 开启客户端缓存的客户端需要记录只读命令读取的键
+	reader := server.tracking.SetReader(cli.trackingReader(c))
+
+	server.execDepth++
 	ret = execCommand(c, server, cli, cmds)
+	server.execDepth--
+
+	server.tracking.SetReader(reader)
+
+	// 最外层的命令执行完毕后才唤醒阻塞的客户端，保证事务与脚本的原子性
+	if server.execDepth == 0 {
+		server.serveBlockedClients()
+	}
 
 	// 更新 cost
 	server.collectCost()
@@ -145,7 +179,8 @@ func CheckCommandAndLength(cmd [][]byte, name string, minLength int) (resp.Redis
 }
 
 func checkAuthority(cli *Client, commandName string) bool {
-	if commandName == "auth" {
+	// hello 命令可以携带 AUTH 选项完成授权，授权检查在命令内部进行
+	if commandName == "auth" || commandName == "hello" {
 		return true
 	}
 
@@ -0,0 +1,333 @@
+package server
+
+import (
+	dbcmd "github.com/tangrc99/MemTable/db/cmd"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"math"
+	"strconv"
+)
+
+// parseBlockTimeout 解析阻塞命令中以秒为单位的超时时间，支持小数，返回毫秒级的超时时间戳，-1 代表永不超时
+func parseBlockTimeout(arg []byte) (int64, resp.RedisData) {
+
+	timeout, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(timeout) || math.IsInf(timeout, 0) {
+		return 0, resp.MakeErrorData("ERR timeout is not a float or out of range")
+	}
+	if timeout < 0 {
+		return 0, resp.MakeErrorData("ERR timeout is negative")
+	}
+	if timeout == 0 {
+		return -1, nil
+	}
+
+	// 不足一毫秒的超时时间向上取整，防止被当作永久阻塞
+	return global.Now.UnixMilli() + int64(math.Ceil(timeout*1000)), nil
+}
+
+// blockingDenied 判断客户端当前是否不允许阻塞，事务与脚本中的阻塞命令需要立即返回超时的结果
+func (s *Server) blockingDenied(cli *Client) bool {
+	return s.execDepth > 1 || cli == env.fakeCli
+}
+
+// serveBlockedClients 唤醒阻塞在已修改键上的客户端
+func (s *Server) serveBlockedClients() {
+	for _, dataBase := range s.dbs {
+		dataBase.ServeBlocked()
+	}
+}
+
+// keyWaiter 是阻塞在列表或有序集合上的命令，键被修改后会重新尝试执行 pop
+type keyWaiter struct {
+	cli  *Client
+	pop  func(key string) (resp.RedisData, bool)
+	done bool
+}
+
+// finish 解除客户端的阻塞状态并发送回包
+func (w *keyWaiter) finish(res resp.RedisData) {
+
+	w.done = true
+
+	if w.cli.status == EXIT || w.cli.status == ERROR {
+		return
+	}
+
+	w.cli.blocked = false
+	w.cli.res <- &res
+}
+
+func (w *keyWaiter) Serve(key string) bool {
+
+	if w.done {
+		return true
+	}
+
+	if w.cli.status == EXIT || w.cli.status == ERROR {
+		w.done = true
+		return true
+	}
+
+	res, ok := w.pop(key)
+	if !ok {
+		return false
+	}
+
+	w.finish(res)
+	return true
+}
+
+func (w *keyWaiter) Timeout() {
+
+	if w.done {
+		return
+	}
+
+	w.finish(resp.MakeNullData())
+}
+
+// blockingPop 按顺序尝试在 keys 上执行 pop，所有键都没有数据时阻塞客户端，直到有键被写入或者超时。
+// pop 返回 false 代表键中没有可以取出的数据，成功取出数据时 pop 需要记录等价的非阻塞命令用于传播
+func blockingPop(server *Server, cli *Client, keys []string, timeout []byte, pop func(key string) (resp.RedisData, bool)) resp.RedisData {
+
+	deadline, err := parseBlockTimeout(timeout)
+	if err != nil {
+		return err
+	}
+
+	// 阻塞命令会修改数据，从节点上只允许主节点执行
+	if server.role == Slave && cli != server.Master {
+		return resp.MakeErrorData("ERR READONLY You can't write against a read only slave")
+	}
+
+	for _, key := range keys {
+		if res, ok := pop(key); ok {
+			return res
+		}
+	}
+
+	// 事务与脚本中的命令不能阻塞
+	if server.blockingDenied(cli) {
+		return resp.MakeNullData()
+	}
+
+	w := &keyWaiter{cli: cli, pop: pop}
+	server.dbs[cli.dbSeq].RegisterWaiter(keys, cli.id, w, deadline)
+
+	cli.blocked = true
+	return nil
+}
+
+// listPopCommand 生成与阻塞弹出等价的 LPOP 或 RPOP 命令
+func listPopCommand(left bool, key string, count int) [][]byte {
+	cmd := [][]byte{[]byte("rpop"), []byte(key)}
+	if left {
+		cmd[0] = []byte("lpop")
+	}
+	if count > 1 {
+		cmd = append(cmd, []byte(strconv.Itoa(count)))
+	}
+	return cmd
+}
+
+// zsetPopCommand 生成与阻塞弹出等价的 ZPOPMIN 或 ZPOPMAX 命令
+func zsetPopCommand(min bool, key string, count int) [][]byte {
+	cmd := [][]byte{[]byte("zpopmax"), []byte(key)}
+	if min {
+		cmd[0] = []byte("zpopmin")
+	}
+	if count > 1 {
+		cmd = append(cmd, []byte(strconv.Itoa(count)))
+	}
+	return cmd
+}
+
+func bPopGeneric(server *Server, cli *Client, cmd [][]byte, name string, left bool) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, name, 3)
+	if !ok {
+		return e
+	}
+
+	dataBase := server.dbs[cli.dbSeq]
+
+	keys := make([]string, len(cmd)-2)
+	for i := range keys {
+		keys[i] = string(cmd[i+1])
+	}
+
+	return blockingPop(server, cli, keys, cmd[len(cmd)-1], func(key string) (resp.RedisData, bool) {
+		values, e := dbcmd.PopList(dataBase, key, left, 1)
+		if e != nil {
+			return e, true
+		}
+		if len(values) == 0 {
+			return nil, false
+		}
+		server.propagateLater(cli, listPopCommand(left, key, 1))
+		return resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte(key)), resp.MakeBulkData(values[0])}), true
+	})
+}
+
+func bLPop(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	return bPopGeneric(server, cli, cmd, "blpop", true)
+}
+
+func bRPop(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	return bPopGeneric(server, cli, cmd, "brpop", false)
+}
+
+// blockingMove 阻塞直到 src 中存在元素，然后将元素移动到 dst 中
+func blockingMove(server *Server, cli *Client, src, dst []byte, fromLeft, toLeft bool, timeout []byte) resp.RedisData {
+
+	dataBase := server.dbs[cli.dbSeq]
+
+	return blockingPop(server, cli, []string{string(src)}, timeout, func(key string) (resp.RedisData, bool) {
+		v, e := dbcmd.MoveList(dataBase, string(src), string(dst), fromLeft, toLeft)
+		if e != nil {
+			return e, true
+		}
+		if v == nil {
+			return nil, false
+		}
+		server.propagateLater(cli, [][]byte{[]byte("lmove"), src, dst,
+			[]byte(listDirectionName(fromLeft)), []byte(listDirectionName(toLeft))})
+		return resp.MakeBulkData(v), true
+	})
+}
+
+// listDirectionName 返回 LMOVE 命令中的方向参数
+func listDirectionName(left bool) string {
+	if left {
+		return "LEFT"
+	}
+	return "RIGHT"
+}
+
+func bLMove(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, "blmove", 6)
+	if !ok {
+		return e
+	}
+
+	fromLeft, ok1 := dbcmd.ParseListDirection(cmd[3])
+	toLeft, ok2 := dbcmd.ParseListDirection(cmd[4])
+	if !ok1 || !ok2 {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	return blockingMove(server, cli, cmd[1], cmd[2], fromLeft, toLeft, cmd[5])
+}
+
+func bRPopLPush(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, "brpoplpush", 4)
+	if !ok {
+		return e
+	}
+
+	return blockingMove(server, cli, cmd[1], cmd[2], false, true, cmd[3])
+}
+
+func bLMPop(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, "blmpop", 5)
+	if !ok {
+		return e
+	}
+
+	args, e := dbcmd.ParseMPopArgs(cmd[2:], "LEFT", "RIGHT")
+	if e != nil {
+		return e
+	}
+
+	dataBase := server.dbs[cli.dbSeq]
+
+	return blockingPop(server, cli, args.Keys, cmd[1], func(key string) (resp.RedisData, bool) {
+		values, e := dbcmd.PopList(dataBase, key, args.First, args.Count)
+		if e != nil {
+			return e, true
+		}
+		if len(values) == 0 {
+			return nil, false
+		}
+		server.propagateLater(cli, listPopCommand(args.First, key, len(values)))
+		return dbcmd.MakeListMPopResult(key, values), true
+	})
+}
+
+func bZPopGeneric(server *Server, cli *Client, cmd [][]byte, name string, min bool) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, name, 3)
+	if !ok {
+		return e
+	}
+
+	dataBase := server.dbs[cli.dbSeq]
+
+	keys := make([]string, len(cmd)-2)
+	for i := range keys {
+		keys[i] = string(cmd[i+1])
+	}
+
+	return blockingPop(server, cli, keys, cmd[len(cmd)-1], func(key string) (resp.RedisData, bool) {
+		members, scores, e := dbcmd.PopZSet(dataBase, key, min, 1)
+		if e != nil {
+			return e, true
+		}
+		if len(members) == 0 {
+			return nil, false
+		}
+		server.propagateLater(cli, zsetPopCommand(min, key, 1))
+		res := append([]resp.RedisData{resp.MakeBulkData([]byte(key))}, dbcmd.MakeZSetPopResult(members, scores)...)
+		return resp.MakeArrayData(res), true
+	})
+}
+
+func bZPopMin(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	return bZPopGeneric(server, cli, cmd, "bzpopmin", true)
+}
+
+func bZPopMax(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	return bZPopGeneric(server, cli, cmd, "bzpopmax", false)
+}
+
+func bZMPop(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, "bzmpop", 5)
+	if !ok {
+		return e
+	}
+
+	args, e := dbcmd.ParseMPopArgs(cmd[2:], "MIN", "MAX")
+	if e != nil {
+		return e
+	}
+
+	dataBase := server.dbs[cli.dbSeq]
+
+	return blockingPop(server, cli, args.Keys, cmd[1], func(key string) (resp.RedisData, bool) {
+		members, scores, e := dbcmd.PopZSet(dataBase, key, args.First, args.Count)
+		if e != nil {
+			return e, true
+		}
+		if len(members) == 0 {
+			return nil, false
+		}
+		server.propagateLater(cli, zsetPopCommand(args.First, key, len(members)))
+		return dbcmd.MakeZSetMPopResult(key, members, scores), true
+	})
+}
+
+func registerBlockingCommands() {
+	RegisterCommand("blpop", bLPop, RD)
+	RegisterCommand("brpop", bRPop, RD)
+	RegisterCommand("blmove", bLMove, RD)
+	RegisterCommand("brpoplpush", bRPopLPush, RD)
+	RegisterCommand("blmpop", bLMPop, RD)
+	RegisterCommand("bzpopmin", bZPopMin, RD)
+	RegisterCommand("bzpopmax", bZPopMax, RD)
+	RegisterCommand("bzmpop", bZMPop, RD)
+}
@@ -0,0 +1,606 @@
+package server
+
+import (
+	"fmt"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkClientName 检查客户端名称是否合法
+func checkClientName(name []byte) resp.RedisData {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return resp.MakeErrorData("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+	}
+	return nil
+}
+
+// addr 返回客户端的地址，无连接的客户端返回空字符串
+func (cli *Client) addr() string {
+	if cli.cnn == nil || cli.cnn.RemoteAddr() == nil {
+		return ""
+	}
+	return cli.cnn.RemoteAddr().String()
+}
+
+// laddr 返回客户端连接的本地地址
+func (cli *Client) laddr() string {
+	if cli.cnn == nil || cli.cnn.LocalAddr() == nil {
+		return ""
+	}
+	return cli.cnn.LocalAddr().String()
+}
+
+// userName 返回客户端认证的用户名，未认证的客户端使用默认用户
+func (cli *Client) userName() string {
+	if cli.user == nil {
+		return "default"
+	}
+	return cli.user.Name()
+}
+
+// clientType 返回客户端的类型，可能为 master、replica、pubsub 或 normal
+func (s *Server) clientType(cli *Client) string {
+	switch {
+	case cli == s.Master:
+		return "master"
+	case cli.slaveStatus != slaveNot:
+		return "replica"
+	case cli.subscriptions()+len(cli.shardChs) > 0:
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// clientFlags 返回 CLIENT LIST 中的客户端标志
+func (s *Server) clientFlags(cli *Client) string {
+	flags := ""
+	if cli == s.Master {
+		flags += "M"
+	}
+	if cli.slaveStatus != slaveNot {
+		flags += "S"
+	}
+	if cli.monitored {
+		flags += "O"
+	}
+	if cli.subscriptions()+len(cli.shardChs) > 0 {
+		flags += "P"
+	}
+	if cli.inTx {
+		flags += "x"
+	}
+	if cli.blocked {
+		flags += "b"
+	}
+	if cli.revised {
+		flags += "d"
+	}
+	if cli.noEvict {
+		flags += "e"
+	}
+	if cli.tracking {
+		flags += "t"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return flags
+}
+
+// clientInfo 生成 CLIENT LIST 以及 CLIENT INFO 中的一行客户端信息
+func (s *Server) clientInfo(cli *Client) string {
+
+	multi := -1
+	if cli.inTx {
+		multi = len(cli.tx)
+	}
+
+	watched := 0
+	for _, keys := range cli.watched {
+		watched += len(keys)
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d ssub=%d "+
+		"multi=%d watch=%d user=%s cmd=%s resp=%d\n",
+		cli.seq, cli.addr(), cli.laddr(), cli.name, int64(global.Now.Sub(cli.ctime).Seconds()),
+		int64(global.Now.Sub(cli.tp).Seconds()), s.clientFlags(cli), cli.dbSeq, len(cli.chs), len(cli.patterns),
+		len(cli.shardChs), multi, watched, cli.userName(), cli.lastCmd, cli.protocol)
+}
+
+// parseClientType 解析 CLIENT LIST 与 CLIENT KILL 中的客户端类型，slave 是 replica 的别名
+func parseClientType(arg []byte) (string, resp.RedisData) {
+	switch tp := strings.ToLower(string(arg)); tp {
+	case "normal", "master", "replica", "pubsub":
+		return tp, nil
+	case "slave":
+		return "replica", nil
+	}
+	return "", resp.MakeErrorData(fmt.Sprintf("ERR Unknown client type '%s'", arg))
+}
+
+// findClientBySeq 根据 CLIENT ID 返回的编号查找客户端
+func (s *Server) findClientBySeq(seq int64) *Client {
+	for _, cli := range s.clis.Clients() {
+		if cli.seq == seq {
+			return cli
+		}
+	}
+	return nil
+}
+
+// clientList 是 CLIENT LIST [TYPE type] [ID id [id ...]] 的实现
+func clientList(server *Server, cmd [][]byte) resp.RedisData {
+
+	tp := ""
+	var ids map[int64]struct{}
+
+	for i := 2; i < len(cmd); i++ {
+		switch option := strings.ToLower(string(cmd[i])); {
+		case option == "type" && i+1 < len(cmd):
+			var err resp.RedisData
+			if tp, err = parseClientType(cmd[i+1]); err != nil {
+				return err
+			}
+			i++
+		case option == "id" && i+1 < len(cmd):
+			ids = make(map[int64]struct{})
+			for i++; i < len(cmd); i++ {
+				id, err := strconv.ParseInt(string(cmd[i]), 10, 64)
+				if err != nil || id <= 0 {
+					return resp.MakeErrorData("ERR Invalid client ID")
+				}
+				ids[id] = struct{}{}
+			}
+		default:
+			return resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	builder := strings.Builder{}
+	for _, cli := range server.clis.Clients() {
+		if tp != "" && server.clientType(cli) != tp {
+			continue
+		}
+		if _, exist := ids[cli.seq]; ids != nil && !exist {
+			continue
+		}
+		builder.WriteString(server.clientInfo(cli))
+	}
+
+	return resp.MakeBulkData([]byte(builder.String()))
+}
+
+// clientKillFilter 是 CLIENT KILL 的过滤条件
+type clientKillFilter struct {
+	id     int64
+	addr   string
+	laddr  string
+	user   string
+	tp     string
+	skipMe bool
+}
+
+func (f *clientKillFilter) match(server *Server, self, cli *Client) bool {
+	return !(f.skipMe && cli == self) &&
+		(f.id == 0 || cli.seq == f.id) &&
+		(f.addr == "" || cli.addr() == f.addr) &&
+		(f.laddr == "" || cli.laddr() == f.laddr) &&
+		(f.user == "" || cli.userName() == f.user) &&
+		(f.tp == "" || server.clientType(cli) == f.tp)
+}
+
+// clientKill 是 CLIENT KILL addr 与 CLIENT KILL <filter> <value> ... 的实现
+func clientKill(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	// 旧格式只能通过地址关闭一个客户端
+	if len(cmd) == 3 {
+		for _, c := range server.clis.Clients() {
+			if c.addr() == string(cmd[2]) {
+				server.shutdownClient(c)
+				return resp.MakeStringData("OK")
+			}
+		}
+		return resp.MakeErrorData("ERR No such client")
+	}
+
+	if len(cmd)%2 != 0 {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	filter := &clientKillFilter{skipMe: true}
+
+	for i := 2; i < len(cmd); i += 2 {
+		value := cmd[i+1]
+		switch strings.ToLower(string(cmd[i])) {
+		case "id":
+			id, err := strconv.ParseInt(string(value), 10, 64)
+			if err != nil || id <= 0 {
+				return resp.MakeErrorData("ERR client-id should be greater than 0")
+			}
+			filter.id = id
+		case "addr":
+			filter.addr = string(value)
+		case "laddr":
+			filter.laddr = string(value)
+		case "user":
+			filter.user = string(value)
+		case "type":
+			var err resp.RedisData
+			if filter.tp, err = parseClientType(value); err != nil {
+				return err
+			}
+		case "skipme":
+			switch strings.ToLower(string(value)) {
+			case "yes":
+				filter.skipMe = true
+			case "no":
+				filter.skipMe = false
+			default:
+				return resp.MakeErrorData("ERR syntax error")
+			}
+		default:
+			return resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	killed := 0
+	for _, c := range server.clis.Clients() {
+		if filter.match(server, cli, c) {
+			server.shutdownClient(c)
+			killed++
+		}
+	}
+
+	return resp.MakeIntData(int64(killed))
+}
+
+// clientPause 是 CLIENT PAUSE timeout [WRITE|ALL] 的实现，timeout 的单位为毫秒
+func clientPause(server *Server, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) != 3 && len(cmd) != 4 {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	timeout, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorData("ERR timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return resp.MakeErrorData("ERR timeout is negative")
+	}
+
+	all := true
+	if len(cmd) == 4 {
+		switch strings.ToLower(string(cmd[3])) {
+		case "write":
+			all = false
+		case "all":
+		default:
+			return resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	end := global.Now.Add(time.Duration(timeout) * time.Millisecond)
+
+	// 已经处于暂停状态时，只会延长暂停时间或者扩大暂停范围
+	if server.paused() {
+		if end.Before(server.pauseEnd) {
+			end = server.pauseEnd
+		}
+		all = all || server.pauseAll
+	}
+
+	server.pauseEnd = end
+	server.pauseAll = all
+
+	return resp.MakeStringData("OK")
+}
+
+// unblockableWaiter 是可以通过 CLIENT UNBLOCK 以错误唤醒的阻塞命令
+type unblockableWaiter interface {
+	finish(res resp.RedisData)
+}
+
+// clientUnblock 是 CLIENT UNBLOCK id [TIMEOUT|ERROR] 的实现，只能唤醒阻塞在键上的客户端
+func clientUnblock(server *Server, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) != 3 && len(cmd) != 4 {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	id, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorData("ERR value is not an integer or out of range")
+	}
+
+	withError := false
+	if len(cmd) == 4 {
+		switch strings.ToLower(string(cmd[3])) {
+		case "timeout":
+		case "error":
+			withError = true
+		default:
+			return resp.MakeErrorData("ERR CLIENT UNBLOCK reason should be TIMEOUT or ERROR")
+		}
+	}
+
+	for _, cli := range server.clis.Clients() {
+		if cli.seq != id || !cli.blocked {
+			continue
+		}
+		for _, dataBase := range server.dbs {
+			w, ok := dataBase.Unblock(cli.id)
+			if !ok {
+				continue
+			}
+			if f, ok := w.(unblockableWaiter); ok && withError {
+				f.finish(resp.MakeErrorData("UNBLOCKED client unblocked via CLIENT UNBLOCK"))
+			} else {
+				w.Timeout()
+			}
+			return resp.MakeIntData(1)
+		}
+	}
+
+	return resp.MakeIntData(0)
+}
+
+// clientTracking 是 CLIENT TRACKING ON|OFF [REDIRECT id] [BCAST] [PREFIX prefix ...] 的实现
+func clientTracking(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	on := false
+	switch strings.ToLower(string(cmd[2])) {
+	case "on":
+		on = true
+	case "off":
+	default:
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	var redirect *Client
+	bcast := false
+	prefixes := make([]string, 0)
+
+	for i := 3; i < len(cmd); i++ {
+		switch option := strings.ToLower(string(cmd[i])); {
+		case option == "redirect" && i+1 < len(cmd):
+			id, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return resp.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			if id != cli.seq {
+				if redirect = server.findClientBySeq(id); redirect == nil {
+					return resp.MakeErrorData("ERR The client ID you want redirect to does not exist")
+				}
+			}
+			i++
+		case option == "bcast":
+			bcast = true
+		case option == "prefix" && i+1 < len(cmd):
+			prefixes = append(prefixes, string(cmd[i+1]))
+			i++
+		default:
+			return resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	if !on {
+		server.disableTracking(cli)
+		return resp.MakeStringData("OK")
+	}
+
+	if len(prefixes) > 0 && !bcast {
+		return resp.MakeErrorData("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if cli.tracking && cli.trackingBcast != bcast {
+		return resp.MakeErrorData("ERR You can't switch BCAST mode on/off before disabling tracking for this client, " +
+			"and then re-enabling it with a different mode.")
+	}
+	if redirect == nil && cli.protocol != resp.RESP3 {
+		return resp.MakeErrorData("ERR Client tracking without REDIRECT requires RESP3, use HELLO 3 first")
+	}
+
+	if bcast {
+		// 没有指定前缀时追踪所有键
+		if len(prefixes) == 0 {
+			prefixes = append(prefixes, "")
+		}
+		server.tracking.TrackPrefixes(cli.id, prefixes)
+		cli.trackingPrefixes = append(cli.trackingPrefixes, prefixes...)
+	}
+
+	// 失效通知通过发布订阅的消息通道发送，需要在连接协程等待下一条消息之前完成初始化
+	cli.initSubscription()
+	if redirect != nil {
+		redirect.initSubscription()
+	}
+
+	cli.tracking = true
+	cli.trackingBcast = bcast
+	cli.trackingRedirect = redirect
+
+	return resp.MakeStringData("OK")
+}
+
+func client(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "client", 2)
+	if !ok {
+		return e
+	}
+
+	switch sub := strings.ToLower(string(cmd[1])); {
+
+	case sub == "id" && len(cmd) == 2:
+		return resp.MakeIntData(cli.seq)
+
+	case sub == "info" && len(cmd) == 2:
+		return resp.MakeBulkData([]byte(server.clientInfo(cli)))
+
+	case sub == "list":
+		return clientList(server, cmd)
+
+	case sub == "kill" && len(cmd) >= 3:
+		return clientKill(server, cli, cmd)
+
+	case sub == "setname" && len(cmd) == 3:
+		if err := checkClientName(cmd[2]); err != nil {
+			return err
+		}
+		cli.name = string(cmd[2])
+		return resp.MakeStringData("OK")
+
+	case sub == "getname" && len(cmd) == 2:
+		if cli.name == "" {
+			return resp.MakeNullData()
+		}
+		return resp.MakeBulkData([]byte(cli.name))
+
+	case sub == "pause":
+		return clientPause(server, cmd)
+
+	case sub == "unpause" && len(cmd) == 2:
+		server.pauseEnd = time.Time{}
+		server.pauseAll = false
+		return resp.MakeStringData("OK")
+
+	case sub == "no-evict" && len(cmd) == 3:
+		switch strings.ToLower(string(cmd[2])) {
+		case "on":
+			cli.noEvict = true
+		case "off":
+			cli.noEvict = false
+		default:
+			return resp.MakeErrorData("ERR syntax error")
+		}
+		return resp.MakeStringData("OK")
+
+	case sub == "reply" && len(cmd) == 3:
+		// OFF 与 SKIP 不会返回回包
+		switch strings.ToLower(string(cmd[2])) {
+		case "on":
+			cli.replyOff = false
+			return resp.MakeStringData("OK")
+		case "off":
+			cli.replyOff = true
+			return nil
+		case "skip":
+			cli.replySkip = true
+			return nil
+		}
+		return resp.MakeErrorData("ERR syntax error")
+
+	case sub == "unblock":
+		return clientUnblock(server, cmd)
+
+	case sub == "tracking" && len(cmd) >= 3:
+		return clientTracking(server, cli, cmd)
+
+	case sub == "getredir" && len(cmd) == 2:
+		switch {
+		case !cli.tracking:
+			return resp.MakeIntData(-1)
+		case cli.trackingRedirect == nil:
+			return resp.MakeIntData(0)
+		}
+		return resp.MakeIntData(cli.trackingRedirect.seq)
+	}
+
+	return resp.MakeErrorData(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CLIENT HELP.", cmd[1]))
+}
+
+// paused 判断服务器是否处于 CLIENT PAUSE 的暂停状态
+func (s *Server) paused() bool {
+	return global.Now.Before(s.pauseEnd)
+}
+
+// mayWriteCommands 是没有被标记为写命令，但是会修改数据或者产生需要传播的命令
+var mayWriteCommands = map[string]struct{}{
+	"eval": {}, "evalsha": {}, "publish": {}, "spublish": {}, "blpop": {}, "brpop": {}, "blmove": {},
+	"brpoplpush": {}, "blmpop": {}, "bzpopmin": {}, "bzpopmax": {}, "bzmpop": {}, "xreadgroup": {},
+}
+
+// commandMayWrite 判断命令是否会修改数据
+func commandMayWrite(cmd [][]byte) bool {
+	if len(cmd) == 0 {
+		return false
+	}
+	name := strings.ToLower(string(cmd[0]))
+	if _, exist := mayWriteCommands[name]; exist {
+		return true
+	}
+	c, exist := global.FindCommand(name)
+	return exist && c.IsWriteCommand()
+}
+
+// isCommandPaused 判断命令是否需要因为 CLIENT PAUSE 而延后执行，主从复制的连接不会被暂停
+func (s *Server) isCommandPaused(cli *Client, cmd [][]byte) bool {
+
+	if !s.paused() || cli == s.Master || cli.slaveStatus != slaveNot {
+		return false
+	}
+	if s.pauseAll {
+		return true
+	}
+
+	if len(cmd) == 0 {
+		return false
+	}
+
+	// 事务中的命令只是入队，exec 中包含写命令时才需要暂停
+	name := strings.ToLower(string(cmd[0]))
+	if name == "exec" {
+		for _, c := range cli.tx {
+			if commandMayWrite(c) {
+				return true
+			}
+		}
+		return false
+	}
+	if cli.inTx && NotTxCommand(name) {
+		return false
+	}
+
+	return commandMayWrite(cmd)
+}
+
+// postponeIfPaused 在暂停期间延后执行命令，客户端之后的命令也需要排队以保证执行顺序
+func (s *Server) postponeIfPaused(event *Event) bool {
+
+	cli := event.cli
+	if cli.postponed == 0 && !s.isCommandPaused(cli, event.cmd) {
+		return false
+	}
+
+	cli.postponed++
+	s.postponed = append(s.postponed, event)
+	return true
+}
+
+// resumePostponed 在暂停结束后按照顺序执行被延后的命令
+func (s *Server) resumePostponed() {
+
+	if len(s.postponed) == 0 || s.paused() {
+		return
+	}
+
+	events := s.postponed
+	s.postponed = nil
+
+	// 执行过程中可能再次进入暂停状态，此时剩余的命令会重新排队
+	for _, event := range events {
+		event.cli.postponed = 0
+	}
+	for _, event := range events {
+		s.handleEvent(event)
+	}
+}
+
+func registerClientCommands() {
+	RegisterCommand("client", client, RD)
+}
@@ -4,11 +4,14 @@ import (
 	"fmt"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
-func cluster(s *Server, _ *Client, cmd [][]byte) resp.RedisData {
+func cluster(s *Server, cli *Client, cmd [][]byte) resp.RedisData {
 
 	// 进行输入类型检查
 	e, ok := CheckCommandAndLength(cmd, "cluster", 2)
@@ -36,14 +39,46 @@ func cluster(s *Server, _ *Client, cmd [][]byte) resp.RedisData {
 
 	case "nodes":
 		return clusterNodes(s, cmd)
+
+	case "slots":
+		return clusterSlots(s, cmd)
+
+	case "shards":
+		return clusterShards(s, cmd)
+
+	case "myid":
+		return resp.MakeBulkData([]byte(s.clusterStatus.self.id))
+
+	case "publish":
+		return clusterPublish(s, cmd)
+
+	case "setslot":
+		return clusterSetSlot(s, cmd)
+
+	case "failover":
+		return clusterFailover(s, cmd)
+
+	case "mfstart":
+		return clusterMFStart(s, cli, cmd)
 	}
 
-	return resp.MakeIntData(int64(s.getSlot(string(cmd[2]))))
+	return resp.MakeErrorData(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CLUSTER HELP.", cmd[1]))
+}
 
+// asking 使得客户端的下一条命令可以访问正在迁入当前节点的槽，用于处理 ASK 重定向
+func asking(s *Server, cli *Client, _ [][]byte) resp.RedisData {
+
+	if s.clusterStatus.state == ClusterNone {
+		return resp.MakeErrorData("ERR This instance has cluster support disabled")
+	}
+
+	cli.asking = true
+	return resp.MakeStringData("OK")
 }
 
 func registerClusterCommand() {
 	RegisterCommand("cluster", cluster, RD)
+	RegisterCommand("asking", asking, RD)
 }
 
 // clusterForbiddenTable 记录集群中不允许运行的命令
@@ -55,13 +90,212 @@ func clusterInfo(s *Server, _ [][]byte) resp.RedisData {
 	return resp.MakeBulkData(s.toJson())
 }
 
+// clusterNodeAddr 将节点名称拆分为 ip 与端口
+func clusterNodeAddr(n *clusterNode) (string, int) {
+	host, port, err := net.SplitHostPort(n.name)
+	if err != nil {
+		return n.name, 0
+	}
+	p, _ := strconv.Atoi(port)
+	return host, p
+}
+
+// sortedClusterNodes 返回集群中所有的节点（包括已经下线的节点），并按照名称排序
+func sortedClusterNodes(c *clusterStatus) []*clusterNode {
+
+	nodes := make([]*clusterNode, 0, len(c.nodes)+len(c.downNodes))
+	for _, n := range c.nodes {
+		nodes = append(nodes, n)
+	}
+	for _, n := range c.downNodes {
+		nodes = append(nodes, n)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].name < nodes[j].name })
+	return nodes
+}
+
+// clusterNodes 以 redis 的格式返回所有节点的信息，每一行的格式为：
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ... <slot>
 func clusterNodes(s *Server, _ [][]byte) resp.RedisData {
 
-	nodes := s.clusterStatus.nodes
+	c := &s.clusterStatus
+	ranges := c.slotRanges()
+
+	b := strings.Builder{}
+
+	for _, n := range sortedClusterNodes(c) {
+
+		ip, port := clusterNodeAddr(n)
+
+		flags := make([]string, 0, 3)
+		if n == c.self {
+			flags = append(flags, "myself")
+		}
+		master := "-"
+		if n.slaveOf == nil || n.isMaster() {
+			flags = append(flags, "master")
+		} else {
+			flags = append(flags, "slave")
+			master = n.slaveOf.id
+		}
+		linkState := "connected"
+		if !n.alive {
+			flags = append(flags, "fail")
+			linkState = "disconnected"
+		}
+
+		pingSent, pongRecv := int64(0), int64(0)
+		if n != c.self {
+			if !n.pingTime.IsZero() {
+				pingSent = n.pingTime.UnixMilli()
+			}
+			if !n.pongTime.IsZero() {
+				pongRecv = n.pongTime.UnixMilli()
+			}
+		}
+
+		// 从节点输出所属主节点的配置纪元，与 redis 相同
+		epochOf := n
+		if n.slaveOf != nil && !n.isMaster() {
+			epochOf = n.slaveOf
+		}
+		epoch := uint64(0)
+		if c.watcher != nil {
+			epoch = c.watcher.configEpoch(epochOf.name)
+		}
+
+		b.WriteString(fmt.Sprintf("%s %s:%d@%d %s %s %d %d %d %s", n.id, ip, port, port+clusterBusPortOffset,
+			strings.Join(flags, ","), master, pingSent, pongRecv, epoch, linkState))
+
+		for _, r := range ranges {
+			if r.master != n {
+				continue
+			}
+			if r.start == r.end {
+				b.WriteString(fmt.Sprintf(" %d", r.start))
+			} else {
+				b.WriteString(fmt.Sprintf(" %d-%d", r.start, r.end))
+			}
+		}
+
+		// 当前节点还需要输出正在迁移的槽
+		if n == c.self {
+			for _, slot := range sortedSlots(c.migrating) {
+				b.WriteString(fmt.Sprintf(" [%d->-%s]", slot, c.migrating[slot].id))
+			}
+			for _, slot := range sortedSlots(c.importing) {
+				b.WriteString(fmt.Sprintf(" [%d-<-%s]", slot, c.importing[slot].id))
+			}
+		}
+
+		b.WriteString("\n")
+	}
+
+	return resp.MakeBulkData([]byte(b.String()))
+}
 
-	ret := make([]resp.RedisData, 0, len(nodes))
-	for _, v := range nodes {
-		ret = append(ret, resp.MakeBulkData(v.toJson()))
+func sortedSlots(slots map[int]*clusterNode) []int {
+	ret := make([]int, 0, len(slots))
+	for slot := range slots {
+		ret = append(ret, slot)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+// clusterSlotsNode 返回 cluster slots 中单个节点的信息：ip、端口与节点 id
+func clusterSlotsNode(n *clusterNode) resp.RedisData {
+	ip, port := clusterNodeAddr(n)
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte(ip)),
+		resp.MakeIntData(int64(port)),
+		resp.MakeBulkData([]byte(n.id)),
+	})
+}
+
+// clusterSlots 返回每一段连续的槽以及负责的节点，第一个节点为主节点，其余为在线的从节点
+func clusterSlots(s *Server, _ [][]byte) resp.RedisData {
+
+	ranges := s.clusterStatus.slotRanges()
+
+	ret := make([]resp.RedisData, 0, len(ranges))
+	for _, r := range ranges {
+		item := []resp.RedisData{
+			resp.MakeIntData(int64(r.start)),
+			resp.MakeIntData(int64(r.end)),
+			clusterSlotsNode(r.master),
+		}
+		for _, slave := range r.master.slaves {
+			if slave.alive {
+				item = append(item, clusterSlotsNode(slave))
+			}
+		}
+		ret = append(ret, resp.MakeArrayData(item))
+	}
+	return resp.MakeArrayData(ret)
+}
+
+// clusterShardsNode 返回 cluster shards 中单个节点的信息
+func clusterShardsNode(s *Server, n *clusterNode) resp.RedisData {
+
+	ip, port := clusterNodeAddr(n)
+
+	role := "master"
+	if n.slaveOf != nil && !n.isMaster() {
+		role = "replica"
+	}
+	health := "online"
+	if !n.alive {
+		health = "failed"
+	}
+	offset := int64(0)
+	if n == s.clusterStatus.self {
+		offset = int64(s.offset)
+	}
+
+	return resp.MakeMapData([]resp.RedisData{
+		resp.MakeBulkData([]byte("id")), resp.MakeBulkData([]byte(n.id)),
+		resp.MakeBulkData([]byte("port")), resp.MakeIntData(int64(port)),
+		resp.MakeBulkData([]byte("ip")), resp.MakeBulkData([]byte(ip)),
+		resp.MakeBulkData([]byte("endpoint")), resp.MakeBulkData([]byte(ip)),
+		resp.MakeBulkData([]byte("role")), resp.MakeBulkData([]byte(role)),
+		resp.MakeBulkData([]byte("replication-offset")), resp.MakeIntData(offset),
+		resp.MakeBulkData([]byte("health")), resp.MakeBulkData([]byte(health)),
+	})
+}
+
+// clusterShards 返回集群中每一个 shard 负责的槽以及包含的节点
+func clusterShards(s *Server, _ [][]byte) resp.RedisData {
+
+	c := &s.clusterStatus
+
+	// 按照负责的槽的顺序排列 shard，不负责任何槽的主节点排在最后
+	masters := make([]*clusterNode, 0)
+	slots := make(map[*clusterNode][]resp.RedisData)
+
+	for _, r := range c.slotRanges() {
+		if _, exist := slots[r.master]; !exist {
+			masters = append(masters, r.master)
+		}
+		slots[r.master] = append(slots[r.master], resp.MakeIntData(int64(r.start)), resp.MakeIntData(int64(r.end)))
+	}
+	for _, n := range sortedClusterNodes(c) {
+		if _, exist := slots[n]; !exist && n.alive && (n.slaveOf == nil || n.isMaster()) {
+			masters = append(masters, n)
+			slots[n] = []resp.RedisData{}
+		}
+	}
+
+	ret := make([]resp.RedisData, 0, len(masters))
+	for _, master := range masters {
+		nodes := []resp.RedisData{clusterShardsNode(s, master)}
+		for _, slave := range master.slaves {
+			nodes = append(nodes, clusterShardsNode(s, slave))
+		}
+		ret = append(ret, resp.MakeMapData([]resp.RedisData{
+			resp.MakeBulkData([]byte("slots")), resp.MakeArrayData(slots[master]),
+			resp.MakeBulkData([]byte("nodes")), resp.MakeArrayData(nodes),
+		}))
 	}
 	return resp.MakeArrayData(ret)
 }
@@ -83,7 +317,7 @@ func clusterCountKeysInSlot(s *Server, cmd [][]byte) resp.RedisData {
 
 	slotSeq, err := strconv.Atoi(string(cmd[2]))
 
-	if err != nil || slotSeq < 0 || slotSeq > slotNum {
+	if err != nil || slotSeq < 0 || slotSeq >= slotNum {
 		return resp.MakeErrorData("ERR slot is not an integer or out of range")
 	}
 
@@ -104,7 +338,7 @@ func clusterGetKeysInSlot(s *Server, cmd [][]byte) resp.RedisData {
 
 	slotSeq, err := strconv.Atoi(string(cmd[2]))
 
-	if err != nil || slotSeq < 0 || slotSeq > slotNum {
+	if err != nil || slotSeq < 0 || slotSeq >= slotNum {
 		return resp.MakeErrorData("ERR slot is not an integer or out of range")
 	}
 
@@ -113,12 +347,13 @@ func clusterGetKeysInSlot(s *Server, cmd [][]byte) resp.RedisData {
 	if slotOwner != s.clusterStatus.self {
 		return resp.MakeErrorData(fmt.Sprintf("MOVED %d %s", slotSeq, slotOwner.name))
 	}
-	count := 1 << 32
+	// 不指定数量时返回槽中所有的键
+	count := s.dbs[0].SlotCount(slotSeq)
 	if len(cmd) == 4 {
-		count, err = strconv.Atoi(string(cmd[2]))
+		count, err = strconv.Atoi(string(cmd[3]))
 
-		if err != nil || slotSeq < 0 {
-			return resp.MakeErrorData("ERR count is not an integer or out of range")
+		if err != nil || count < 0 {
+			return resp.MakeErrorData("ERR Invalid number of keys")
 		}
 	}
 
@@ -131,10 +366,194 @@ func clusterGetKeysInSlot(s *Server, cmd [][]byte) resp.RedisData {
 	return resp.MakeArrayData(ret)
 }
 
+// clusterPublish 处理其他节点转发的 publish 命令，只在本地发布，不会再次转发
+func clusterPublish(s *Server, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) != 4 {
+		return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", (cmd)[1]))
+	}
+
+	return resp.MakeIntData(int64(s.Chs.PublishMessage(string(cmd[2]), cmd[3])))
+}
+
+// clusterSetSlot 修改槽的迁移状态，命令格式： cluster setslot slot importing|migrating|node node-name 或者
+// cluster setslot slot stable。槽的迁移需要先在目标节点上设置 importing，再在源节点上设置 migrating，
+// 源节点会在后台逐键迁移槽中的数据，迁移完成后自动向集群宣布槽的新负责节点
+func clusterSetSlot(s *Server, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) < 4 {
+		return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", (cmd)[1]))
+	}
+
+	slot, err := strconv.Atoi(string(cmd[2]))
+	if err != nil || slot < 0 || slot >= slotNum {
+		return resp.MakeErrorData("ERR Invalid or out of range slot")
+	}
+
+	c := &s.clusterStatus
+	action := strings.ToLower(string(cmd[3]))
+
+	if action == "stable" {
+		if len(cmd) != 4 {
+			return resp.MakeErrorData("ERR syntax error")
+		}
+		delete(c.importing, slot)
+		delete(c.migrating, slot)
+		return resp.MakeStringData("OK")
+	}
+
+	if len(cmd) != 5 || (action != "importing" && action != "migrating" && action != "node") {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	if !c.self.isMaster() {
+		return resp.MakeErrorData("ERR Please use SETSLOT only with masters.")
+	}
+
+	node, exist := c.nodes[string(cmd[4])]
+	if !exist {
+		return resp.MakeErrorData("ERR I don't know about node " + string(cmd[4]))
+	}
+
+	switch action {
+	case "importing":
+
+		if c.slots[slot] == c.self {
+			return resp.MakeErrorData(fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot))
+		}
+		if node == c.self || !node.isMaster() {
+			return resp.MakeErrorData("ERR Target node is not a master")
+		}
+		c.importing[slot] = node
+
+	case "migrating":
+
+		if c.slots[slot] != c.self {
+			return resp.MakeErrorData(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot))
+		}
+		if node == c.self || !node.isMaster() {
+			return resp.MakeErrorData("ERR Target node is not a master")
+		}
+		c.migrating[slot] = node
+
+	case "node":
+
+		if !node.isMaster() {
+			return resp.MakeErrorData("ERR Target node is not a master")
+		}
+		if node != c.self && c.slots[slot] == c.self && s.dbs[0].SlotCount(slot) > 0 {
+			return resp.MakeErrorData(fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot))
+		}
+		c.setSlotNode(slot, node)
+	}
+
+	return resp.MakeStringData("OK")
+}
+
+// clusterFailover 在从节点上发起手动故障转移，命令格式： cluster failover [force|takeover]。
+// 默认模式下主节点会暂停写入，从节点追上主节点的复制 offset 后发起选举；force 模式不与主节点握手，
+// 可以在主节点下线时使用；takeover 模式不进行选举，直接成为 shard 的主节点。故障转移在后台异步完成
+func clusterFailover(s *Server, cmd [][]byte) resp.RedisData {
+
+	c := &s.clusterStatus
+
+	mode := failoverDefault
+	if len(cmd) == 3 {
+		switch strings.ToLower(string(cmd[2])) {
+		case "force":
+			mode = failoverForce
+		case "takeover":
+			mode = failoverTakeover
+		default:
+			return resp.MakeErrorData("ERR syntax error")
+		}
+	} else if len(cmd) > 3 {
+		return resp.MakeErrorData("ERR syntax error")
+	}
+
+	if c.self.isMaster() {
+		return resp.MakeErrorData("ERR You should send CLUSTER FAILOVER to a replica")
+	}
+
+	if mode == failoverDefault && (c.state != ClusterOK || !s.masterAlive) {
+		return resp.MakeErrorData("ERR Master is down or failed, please use CLUSTER FAILOVER FORCE")
+	}
+
+	if err := c.startManualFailover(mode); err != nil {
+		return resp.MakeErrorData("ERR Master is down or failed, please use CLUSTER FAILOVER FORCE")
+	}
+
+	return resp.MakeStringData("OK")
+}
+
+// clusterMFStart 是手动故障转移中从节点发送给主节点的内部命令，命令格式： cluster mfstart timeout。
+// 主节点暂停写入 timeout 毫秒，并返回当前的复制 offset
+func clusterMFStart(s *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) != 3 {
+		return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd[1]))
+	}
+
+	// 暂停写入会影响所有客户端，只接受来自从节点或集群中其他节点的请求
+	if !s.isClusterPeer(cli) {
+		return resp.MakeErrorData("ERR MFSTART can only be sent by a replica or a cluster node")
+	}
+
+	if !s.clusterStatus.self.isMaster() {
+		return resp.MakeErrorData("ERR MFSTART can only be sent to a master")
+	}
+
+	timeout, err := strconv.ParseInt(string(cmd[2]), 10, 64)
+	if err != nil || timeout <= 0 {
+		return resp.MakeErrorData("ERR timeout is not an integer or out of range")
+	}
+
+	// 只暂停写入，已经处于暂停状态时只会延长暂停时间
+	if !s.paused() {
+		s.pauseAll = false
+	}
+	if end := global.Now.Add(time.Duration(timeout) * time.Millisecond); end.After(s.pauseEnd) {
+		s.pauseEnd = end
+	}
+
+	return resp.MakeIntData(int64(s.offset))
+}
+
 /* ---------------------------------------------------------------------------
 * utils 函数
 * ------------------------------------------------------------------------- */
 
+// isClusterPeer 判断客户端是否来自当前节点的从节点或者集群中的其他节点，根据连接的对端地址进行判断
+func (s *Server) isClusterPeer(cli *Client) bool {
+
+	if cli == nil || cli.cnn == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(cli.cnn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+
+	for slave := range s.onLineSlaves {
+		if slave.cnn == nil {
+			continue
+		}
+		if h, _, err := net.SplitHostPort(slave.cnn.RemoteAddr().String()); err == nil && h == host {
+			return true
+		}
+	}
+
+	for name, node := range s.clusterStatus.nodes {
+		if node == s.clusterStatus.self {
+			continue
+		}
+		if h, _, err := net.SplitHostPort(name); err == nil && h == host {
+			return true
+		}
+	}
+	return false
+}
+
 // checkCommandRunnableInCluster 判断在当前的集群状态中是否允许该命令执行
 func checkCommandRunnableInCluster(s *Server, cli *Client, cmd [][]byte) (allowed bool, err resp.RedisData) {
 
@@ -156,26 +575,79 @@ func checkCommandRunnableInCluster(s *Server, cli *Client, cmd [][]byte) (allowe
 		return false, resp.MakeErrorData(fmt.Sprintf("ERR %s is not permitted in cluster", string(cmd[0])))
 	}
 
-	moved, err := checkKeyNeedsMoved(s, cmd)
+	moved, err := checkKeyNeedsMoved(s, cli, cmd)
 
 	return !moved, err
 }
 
-// checkKeyNeedsMoved 用来判断命令是否需要迁移到其他实例上
-func checkKeyNeedsMoved(s *Server, cmd [][]byte) (needMove bool, err resp.RedisData) {
+// clusterKeyIndexTable 记录第一个参数不是键的数据库命令中键所在的位置，0 代表命令不包含键
+var clusterKeyIndexTable = map[string]int{
+	"scan": 0, "xgroup": 2, "object": 2,
+}
+
+// checkKeyNeedsMoved 用来判断命令是否需要迁移到其他实例上，服务器命令需要在命令内部自行判断。
+// 不由当前节点负责的键返回 MOVED 错误；正在迁出的槽中已经不存在的键返回 ASK 错误，
+// 客户端需要在目标节点上先执行 ASKING 再执行命令
+func checkKeyNeedsMoved(s *Server, cli *Client, cmd [][]byte) (needMove bool, err resp.RedisData) {
 
 	command := strings.ToLower(string(cmd[0]))
 
-	// 首先判断命令是否是数据库命令
-	if ok := global.IsDatabaseCommand(command); !ok {
-		if len(cmd) > 1 {
-			if moved, slot, peer := s.isKeyNeedMove(string(cmd[1])); moved {
-				return true, resp.MakeErrorData(fmt.Sprintf("MOVED %d %s", slot, peer.name))
-			}
+	// 首先判断命令是否是数据库命令，主节点传播的命令不需要重定向
+	if ok := global.IsDatabaseCommand(command); !ok || cli == s.Master {
+		return false, nil
+	}
+
+	index, exist := clusterKeyIndexTable[command]
+	if !exist {
+		index = 1
+	}
+
+	if index <= 0 || len(cmd) <= index {
+		return false, nil
+	}
+
+	key := string(cmd[index])
+	slot := s.getSlot(key)
+	owner := s.clusterStatus.slots[slot]
+
+	if owner == nil {
+		return true, resp.MakeErrorData(fmt.Sprintf("CLUSTERDOWN Hash slot %d not served", slot))
+	}
+
+	if owner == s.clusterStatus.self {
+		// 正在迁出的槽中不存在的键可能已经被迁移到目标节点
+		if target, migrating := s.clusterStatus.migrating[slot]; migrating && !s.dbs[0].ExistKey(key) {
+			return true, resp.MakeErrorData(fmt.Sprintf("ASK %d %s", slot, target.name))
+		}
+		return false, nil
+	}
+
+	// 正在迁入的槽只接受 ASKING 之后的命令，以及迁移时使用的 restore-asking
+	if _, importing := s.clusterStatus.importing[slot]; importing && (cli.asking || command == "restore-asking") {
+		return false, nil
+	}
+
+	return true, resp.MakeErrorData(fmt.Sprintf("MOVED %d %s", slot, owner.name))
+}
+
+// checkShardChannels 判断分片频道是否属于同一个槽，并且该槽由当前节点负责
+func checkShardChannels(s *Server, channels [][]byte) resp.RedisData {
+
+	if s.clusterStatus.state == ClusterNone {
+		return nil
+	}
+
+	slot := s.getSlot(string(channels[0]))
+	for _, channel := range channels[1:] {
+		if s.getSlot(string(channel)) != slot {
+			return resp.MakeErrorData("CROSSSLOT Keys in request don't hash to the same slot")
 		}
 	}
 
-	return false, nil
+	if moved, slot, peer := s.isKeyNeedMove(string(channels[0])); moved {
+		return resp.MakeErrorData(fmt.Sprintf("MOVED %d %s", slot, peer.name))
+	}
+	return nil
 }
 
 func checkAllKeysLocal(s *Server, keys [][]byte, num int) bool {
@@ -1,8 +1,12 @@
 package server
 
 import (
+	"fmt"
+	"github.com/tangrc99/MemTable/config"
 	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
 	"strconv"
+	"strings"
 )
 
 func ping(_ *Server, _ *Client, cmd [][]byte) resp.RedisData {
@@ -65,9 +69,90 @@ func monitor(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
 	return resp.MakeStringData("OK")
 }
 
+// hello 用于协商协议版本，语法为 HELLO [protover [AUTH username password] [SETNAME clientname]]
+func hello(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "hello", 1)
+	if !ok {
+		return e
+	}
+
+	protocol := cli.protocol
+	var user, password, name []byte
+	var setName bool
+
+	if len(cmd) > 1 {
+		ver, err := strconv.Atoi(string(cmd[1]))
+		if err != nil {
+			return resp.MakeErrorData("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != resp.RESP2 && ver != resp.RESP3 {
+			return resp.MakeErrorData("NOPROTO unsupported protocol version")
+		}
+		protocol = ver
+	}
+
+	// 先检查所有的选项，避免执行一部分后出错
+	for i := 2; i < len(cmd); i++ {
+		switch option := strings.ToLower(string(cmd[i])); {
+		case option == "auth" && i+2 < len(cmd):
+			user, password = cmd[i+1], cmd[i+2]
+			i += 2
+		case option == "setname" && i+1 < len(cmd):
+			name, setName = cmd[i+1], true
+			i++
+		default:
+			return resp.MakeErrorData(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", cmd[i]))
+		}
+	}
+
+	if user != nil {
+		ret := auth(server, cli, [][]byte{[]byte("auth"), user, password})
+		if _, isErr := ret.(*resp.ErrorData); isErr {
+			return ret
+		}
+	}
+
+	if !cli.auth && cli.user.HasPassword() {
+		return resp.MakeErrorData("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client " +
+			"and select the RESP protocol version at the same time")
+	}
+
+	if setName {
+		if err := checkClientName(name); err != nil {
+			return err
+		}
+		cli.name = string(name)
+	}
+
+	cli.protocol = protocol
+
+	mode := "standalone"
+	if config.Conf.ClusterEnable {
+		mode = "cluster"
+	}
+
+	role := "master"
+	if server.role == Slave {
+		role = "slave"
+	}
+
+	return resp.MakeMapData([]resp.RedisData{
+		resp.MakeBulkData([]byte("server")), resp.MakeBulkData([]byte("memtable")),
+		resp.MakeBulkData([]byte("version")), resp.MakeBulkData([]byte(global.Version)),
+		resp.MakeBulkData([]byte("proto")), resp.MakeIntData(int64(cli.protocol)),
+		resp.MakeBulkData([]byte("id")), resp.MakeIntData(cli.seq),
+		resp.MakeBulkData([]byte("mode")), resp.MakeBulkData([]byte(mode)),
+		resp.MakeBulkData([]byte("role")), resp.MakeBulkData([]byte(role)),
+		resp.MakeBulkData([]byte("modules")), resp.MakeEmptyArrayData(),
+	})
+}
+
 func registerConnectionCommands() {
 	RegisterCommand("ping", ping, RD)
 	RegisterCommand("quit", quit, RD)
 	RegisterCommand("select", selectDB, RD)
 	RegisterCommand("monitor", monitor, RD)
+	RegisterCommand("hello", hello, RD)
 }
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/tangrc99/MemTable/config"
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// memoryUsage 是 MEMORY USAGE key [SAMPLES count] 的实现。由于每一个值都记录了精确的内存占用，SAMPLES 选项只做检查
+func memoryUsage(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) != 3 && len(cmd) != 5 {
+		return resp.MakeErrorData("ERR wrong number of arguments for 'memory usage' command")
+	}
+
+	if len(cmd) == 5 {
+		if strings.ToLower(string(cmd[3])) != "samples" {
+			return resp.MakeErrorData("ERR syntax error")
+		}
+		if samples, err := strconv.Atoi(string(cmd[4])); err != nil || samples < 0 {
+			return resp.MakeErrorData("ERR value is not an integer or out of range")
+		}
+	}
+
+	usage := server.dbs[cli.dbSeq].MemoryUsage(string(cmd[2]))
+	if usage < 0 {
+		return resp.MakeNullData()
+	}
+
+	return resp.MakeIntData(usage)
+}
+
+// memoryStats 是 MEMORY STATS 的实现，返回服务器各个部分的内存占用情况
+func memoryStats(server *Server) resp.RedisData {
+
+	keys, dataset := int64(0), int64(0)
+	dbStats := make([]resp.RedisData, 0)
+
+	for i, d := range server.dbs {
+		keys += int64(d.Size())
+		dataset += d.Cost()
+
+		if d.Size() == 0 {
+			continue
+		}
+		dbStats = append(dbStats, resp.MakeBulkData([]byte(fmt.Sprintf("db.%d", i))), resp.MakeMapData([]resp.RedisData{
+			resp.MakeBulkData([]byte("keys")), resp.MakeIntData(int64(d.Size())),
+			resp.MakeBulkData([]byte("expires")), resp.MakeIntData(int64(d.TTLSize())),
+			resp.MakeBulkData([]byte("cost")), resp.MakeIntData(d.Cost()),
+		}))
+	}
+
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = dataset / keys
+	}
+
+	stats := []resp.RedisData{
+		resp.MakeBulkData([]byte("total.allocated")), resp.MakeIntData(server.cost),
+		resp.MakeBulkData([]byte("maxmemory")), resp.MakeIntData(int64(config.Conf.MaxMemory)),
+		resp.MakeBulkData([]byte("clients.normal")), resp.MakeIntData(server.clis.Cost()),
+		resp.MakeBulkData([]byte("slowlog")), resp.MakeIntData(server.slowlog.Cost()),
+		resp.MakeBulkData([]byte("replication.backlog")), resp.MakeIntData(global.RsBackLogCap),
+		resp.MakeBulkData([]byte("dataset.bytes")), resp.MakeIntData(dataset),
+		resp.MakeBulkData([]byte("keys.count")), resp.MakeIntData(keys),
+		resp.MakeBulkData([]byte("keys.bytes-per-key")), resp.MakeIntData(bytesPerKey),
+	}
+
+	return resp.MakeMapData(append(stats, dbStats...))
+}
+
+// memory 用于查看内存的使用情况，命令格式： memory usage|stats [argument ...]
+func memory(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, "memory", 2)
+	if !ok {
+		return e
+	}
+
+	subcommand := strings.ToLower(string(cmd[1]))
+
+	switch {
+	case subcommand == "usage":
+		return memoryUsage(server, cli, cmd)
+
+	case subcommand == "stats" && len(cmd) == 2:
+		return memoryStats(server)
+	}
+
+	return resp.MakeErrorData(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try MEMORY HELP.", cmd[1]))
+}
+
+// debugObject 返回键值对的内部信息，与 OBJECT 命令不同，该命令以单个字符串的形式返回
+func debugObject(database *db.DataBase, key string) resp.RedisData {
+
+	value, ok := database.PeekKey(key)
+	if !ok {
+		return resp.MakeErrorData("ERR no such key")
+	}
+
+	access := fmt.Sprintf("lru_seconds_idle:%d", database.KeyIdleTime(key))
+	if database.EvictPolicy() == db.EvictLFU {
+		access = fmt.Sprintf("lfu_freq:%d", database.KeyFrequency(key))
+	}
+
+	return resp.MakeStringData(fmt.Sprintf("refcount:1 encoding:%s cost:%d %s",
+		db.ObjectEncoding(value), database.MemoryUsage(key), access))
+}
+
+// debugReload 将所有数据库序列化为 aof 格式后清空，再重新加载，用于检查持久化能否完整地还原数据集
+func (s *Server) debugReload() error {
+
+	snapshot := &bytes.Buffer{}
+	for i, dataBase := range s.dbs {
+		if err := dataBase.RewriteAOF(snapshot, i); err != nil {
+			return err
+		}
+	}
+
+	for _, dataBase := range s.dbs {
+		dataBase.ReviseNotifyAll()
+		dataBase.Clear()
+	}
+
+	s.loadAOF(snapshot)
+
+	return nil
+}
+
+// debugCommand 用于诊断服务器的问题，命令格式： debug sleep|object|reload|htstats|jmap [argument ...]
+func debugCommand(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, "debug", 2)
+	if !ok {
+		return e
+	}
+
+	subcommand := strings.ToLower(string(cmd[1]))
+
+	switch {
+	case subcommand == "sleep" && len(cmd) == 3:
+
+		seconds, err := strconv.ParseFloat(string(cmd[2]), 64)
+		if err != nil || seconds < 0 {
+			return resp.MakeErrorData("ERR value is not a valid float")
+		}
+		// 阻塞事件循环，用于模拟慢命令
+		time.Sleep(time.Duration(seconds * float64(time.Second)))
+		return resp.MakeStringData("OK")
+
+	case subcommand == "object" && len(cmd) == 3:
+
+		return debugObject(server.dbs[cli.dbSeq], string(cmd[2]))
+
+	case subcommand == "reload" && len(cmd) == 2:
+
+		if err := server.debugReload(); err != nil {
+			return resp.MakeErrorData("ERR Error trying to reload the dataset: " + err.Error())
+		}
+		return resp.MakeStringData("OK")
+
+	case subcommand == "htstats" && len(cmd) == 3:
+
+		dbSeq, err := strconv.Atoi(string(cmd[2]))
+		if err != nil {
+			return resp.MakeErrorData("ERR value is not an integer or out of range")
+		}
+		if dbSeq < 0 || dbSeq >= server.dbNum {
+			return resp.MakeErrorData("ERR Out of range database")
+		}
+		return resp.MakeBulkData([]byte(server.dbs[dbSeq].HashTableStats()))
+
+	case subcommand == "jmap" && len(cmd) == 2:
+
+		// 输出所有非空数据库的分片统计信息
+		b := strings.Builder{}
+		for i, dataBase := range server.dbs {
+			if dataBase.Size() == 0 {
+				continue
+			}
+			b.WriteString(fmt.Sprintf("# db%d\n", i))
+			b.WriteString(dataBase.HashTableStats())
+		}
+		return resp.MakeBulkData([]byte(b.String()))
+	}
+
+	return resp.MakeErrorData(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try DEBUG HELP.", cmd[1]))
+}
+
+func registerDebugCommands() {
+	RegisterCommand("memory", memory, RD)
+	RegisterCommand("debug", debugCommand, RD)
+}
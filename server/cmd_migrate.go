@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrateDefaultTimeout 是 MIGRATE 命令中 timeout 不大于 0 时使用的超时时间
+const migrateDefaultTimeout = time.Second
+
+// migrateOptions 记录了 MIGRATE 命令的参数
+type migrateOptions struct {
+	addr    string
+	dbSeq   int
+	timeout time.Duration
+	copy    bool     // 是否保留本地的键
+	replace bool     // 是否覆盖目标实例中已经存在的键
+	auth    [][]byte // 目标实例的认证参数
+	asking  bool     // 是否使用 restore-asking 写入键，用于集群中的槽迁移
+	keys    [][]byte
+	deletes [][]byte // 需要在目标实例中删除的键，用于集群中的槽迁移
+}
+
+// parseMigrateOptions 解析 MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password]
+// [AUTH2 username password] [KEYS key [key ...]]
+func parseMigrateOptions(cmd [][]byte) (*migrateOptions, resp.RedisData) {
+
+	port, err := strconv.Atoi(string(cmd[2]))
+	if err != nil {
+		return nil, resp.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	dbSeq, err := strconv.Atoi(string(cmd[4]))
+	if err != nil || dbSeq < 0 {
+		return nil, resp.MakeErrorData("ERR value is not an integer or out of range")
+	}
+	timeout, err := strconv.ParseInt(string(cmd[5]), 10, 64)
+	if err != nil {
+		return nil, resp.MakeErrorData("ERR value is not an integer or out of range")
+	}
+
+	opts := &migrateOptions{
+		addr:    net.JoinHostPort(string(cmd[1]), strconv.Itoa(port)),
+		dbSeq:   dbSeq,
+		timeout: time.Duration(timeout) * time.Millisecond,
+	}
+	if timeout <= 0 {
+		opts.timeout = migrateDefaultTimeout
+	}
+
+	for i := 6; i < len(cmd); i++ {
+		switch strings.ToLower(string(cmd[i])) {
+		case "copy":
+			opts.copy = true
+		case "replace":
+			opts.replace = true
+		case "auth":
+			if i+1 >= len(cmd) {
+				return nil, resp.MakeErrorData("ERR syntax error")
+			}
+			opts.auth = [][]byte{cmd[i+1]}
+			i++
+		case "auth2":
+			if i+2 >= len(cmd) {
+				return nil, resp.MakeErrorData("ERR syntax error")
+			}
+			opts.auth = [][]byte{cmd[i+1], cmd[i+2]}
+			i += 2
+		case "keys":
+			if len(cmd[3]) != 0 {
+				return nil, resp.MakeErrorData("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			opts.keys = cmd[i+1:]
+			i = len(cmd)
+		default:
+			return nil, resp.MakeErrorData("ERR syntax error")
+		}
+	}
+
+	if opts.keys == nil {
+		opts.keys = cmd[3:4]
+	}
+
+	return opts, nil
+}
+
+// migrateBatch 是一次发送到目标实例的迁移请求。请求需要在事件循环中生成，发送只会访问 migrateBatch 本身，可以在后台协程中进行
+type migrateBatch struct {
+	addr    string
+	timeout time.Duration
+	request *bytes.Buffer
+	header  int      // 键之前的命令数量
+	deletes [][]byte // 请求中在目标实例删除的键
+	keys    [][]byte // 请求中的键，与 restore 命令一一对应
+	failed  [][]byte // 无法序列化而被跳过的键
+	dumpErr error    // 第一个无法序列化的键对应的错误
+
+	// 发送的结果
+	headerDone bool           // 键之前的命令是否全部执行成功
+	done       []bool         // 目标实例是否成功写入了对应的键
+	err        resp.RedisData // 连接或目标实例返回的错误
+}
+
+// migrateRequest 将需要发送到目标实例的命令写入到请求中，不存在的键会被跳过，无法序列化的键会被记录在 failed 中
+func migrateRequest(base *db.DataBase, opts *migrateOptions) *migrateBatch {
+
+	batch := &migrateBatch{
+		addr:    opts.addr,
+		timeout: opts.timeout,
+		request: &bytes.Buffer{},
+		deletes: opts.deletes,
+		keys:    make([][]byte, 0, len(opts.keys)),
+	}
+	buf := batch.request
+
+	if opts.auth != nil {
+		buf.Write(resp.PlainDataToResp(append([][]byte{[]byte("auth")}, opts.auth...)).ToBytes())
+		batch.header++
+	}
+
+	// 集群中迁移槽时，目标节点尚未负责该槽，需要使用 restore-asking 跳过重定向检查；
+	// 集群中只能使用 0 号数据库，也不允许执行 select
+	restoreName := []byte("restore")
+	if opts.asking {
+		restoreName = []byte("restore-asking")
+	} else {
+		buf.Write(resp.PlainDataToResp([][]byte{[]byte("select"), []byte(strconv.Itoa(opts.dbSeq))}).ToBytes())
+		batch.header++
+	}
+
+	// 删除命令同样需要跳过重定向检查
+	for _, key := range opts.deletes {
+		buf.Write(resp.PlainDataToResp([][]byte{[]byte("asking")}).ToBytes())
+		buf.Write(resp.PlainDataToResp([][]byte{[]byte("del"), key}).ToBytes())
+		batch.header += 2
+	}
+
+	for _, key := range opts.keys {
+
+		value, ok := base.GetKey(string(key))
+		if !ok {
+			continue
+		}
+
+		payload, err := db.DumpValue(value)
+		if err != nil {
+			if batch.dumpErr == nil {
+				batch.dumpErr = err
+			}
+			batch.failed = append(batch.failed, key)
+			continue
+		}
+
+		// 使用剩余的存活时间，已经到期但尚未删除的键至少保留 1 毫秒
+		ttl := int64(0)
+		if expireAt := base.GetExpireAt(string(key)); expireAt > 0 {
+			ttl = expireAt - global.Now.UnixMilli()
+			if ttl < 1 {
+				ttl = 1
+			}
+		}
+
+		restore := [][]byte{restoreName, key, []byte(strconv.FormatInt(ttl, 10)), payload}
+		if opts.replace {
+			restore = append(restore, []byte("replace"))
+		}
+		buf.Write(resp.PlainDataToResp(restore).ToBytes())
+
+		batch.keys = append(batch.keys, key)
+	}
+
+	batch.done = make([]bool, len(batch.keys))
+
+	return batch
+}
+
+// send 将请求发送到目标实例，并阻塞到目标实例回复或超时，结果记录在 batch 中
+func (batch *migrateBatch) send() {
+
+	conn, err := net.DialTimeout("tcp", batch.addr, batch.timeout)
+	if err != nil {
+		batch.err = resp.MakeErrorData("IOERR error or timeout connecting to the client")
+		return
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(batch.timeout))
+	if _, err = batch.request.WriteTo(conn); err != nil {
+		batch.err = resp.MakeErrorData("IOERR error or timeout writing to target instance")
+		return
+	}
+
+	parser := resp.NewParser(conn)
+
+	// 需要额外读取 auth、select 以及删除命令的回复
+	replies := len(batch.keys) + batch.header
+
+	for i := 0; i < replies; i++ {
+
+		_ = conn.SetDeadline(time.Now().Add(batch.timeout))
+		parsed := parser.Parse()
+		if parsed.Err != nil {
+			batch.err = resp.MakeErrorData("IOERR error or timeout reading from target instance")
+			return
+		}
+
+		// 前面的回复属于 auth、select 以及删除命令
+		k := i - batch.header
+
+		if reply, isErr := parsed.Data.(*resp.ErrorData); isErr {
+			if batch.err == nil {
+				batch.err = resp.MakeErrorData("ERR Target instance replied with error: " + reply.Error())
+			}
+			// auth 或 select 失败时，后续的键可能没有写入到正确的数据库中，不能删除本地的键
+			if k < 0 {
+				return
+			}
+			continue
+		}
+
+		if k == -1 {
+			batch.headerDone = true
+		} else if k >= 0 {
+			batch.done[k] = true
+		}
+	}
+
+	if batch.header == 0 {
+		batch.headerDone = true
+	}
+}
+
+// migrated 返回目标实例成功写入的键
+func (batch *migrateBatch) migrated() [][]byte {
+	migrated := make([][]byte, 0, len(batch.keys))
+	for i, key := range batch.keys {
+		if batch.done[i] {
+			migrated = append(migrated, key)
+		}
+	}
+	return migrated
+}
+
+// migrateKeys 将 base 中的键发送到目标实例，并阻塞到目标实例回复或超时，返回目标实例成功写入的键。
+// 无法序列化的键会被跳过，其余的键仍然会被迁移。如果所有的键都不存在，将返回空的列表以及 nil
+func migrateKeys(base *db.DataBase, opts *migrateOptions) ([][]byte, resp.RedisData) {
+
+	batch := migrateRequest(base, opts)
+
+	if len(batch.keys) > 0 {
+		batch.send()
+	}
+
+	e := batch.err
+	if e == nil && batch.dumpErr != nil {
+		e = resp.MakeErrorData(batch.dumpErr.Error())
+	}
+
+	return batch.migrated(), e
+}
+
+// removeMigratedKeys 删除已经迁移到其他实例的键，并向 aof 与从节点传播删除命令，而不是重新执行迁移
+func (s *Server) removeMigratedKeys(cli *Client, keys [][]byte) {
+
+	if len(keys) == 0 {
+		return
+	}
+
+	base := s.dbs[cli.dbSeq]
+	for _, key := range keys {
+		base.DeleteKey(string(key))
+		base.NotifyKeyspaceEvent(db.NotifyGeneric, "del", string(key))
+	}
+	s.propagateLater(cli, append([][]byte{[]byte("del")}, keys...))
+}
+
+// migrate 将键原子地迁移到目标实例中，命令会直接连接目标实例并阻塞到迁移完成或超时。
+// 目标实例成功创建的键会在本地删除，除非使用了 COPY 选项
+func migrate(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, "migrate", 6)
+	if !ok {
+		return e
+	}
+
+	opts, e := parseMigrateOptions(cmd)
+	if e != nil {
+		return e
+	}
+	opts.asking = server.clusterStatus.state != ClusterNone
+
+	if !opts.copy && server.role == Slave && cli != server.Master {
+		return resp.MakeErrorData("ERR READONLY You can't write against a read only slave")
+	}
+
+	migrated, e := migrateKeys(server.dbs[cli.dbSeq], opts)
+	if migrated != nil && len(migrated) == 0 && e == nil {
+		return resp.MakeStringData("NOKEY")
+	}
+
+	if !opts.copy {
+		server.removeMigratedKeys(cli, migrated)
+	}
+
+	if e != nil {
+		return e
+	}
+
+	return resp.MakeStringData("OK")
+}
+
+func registerMigrateCommands() {
+	RegisterCommand("migrate", migrate, RD)
+}
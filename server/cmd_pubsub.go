@@ -1,10 +1,11 @@
 package server
 
 import (
-	"github.com/tangrc99/MemTable/db/structure"
+	"fmt"
+	"github.com/tangrc99/MemTable/config"
 	"github.com/tangrc99/MemTable/resp"
-	"github.com/tangrc99/MemTable/server/global"
-	"strconv"
+	"sort"
+	"strings"
 )
 
 func publish(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
@@ -14,12 +15,13 @@ func publish(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
 		return e
 	}
 
-	msg := make([]resp.RedisData, 3)
-	msg[0] = resp.MakeBulkData([]byte("message"))
-	msg[1] = resp.MakeBulkData(cmd[1])
-	msg[2] = resp.MakeBulkData(cmd[2])
+	// 消息以 RESP2 格式编码，RESP3 客户端在发送时会转换为 push 类型
+	notified := server.Chs.PublishMessage(string(cmd[1]), cmd[2])
 
-	notified := server.Chs.Publish(string(cmd[1]), resp.MakeArrayData(msg).ToBytes())
+	// 集群模式下可以将消息广播到其他节点，返回值只包括本地的订阅者数量
+	if config.Conf.ClusterPublishBroadcast {
+		server.broadcastPublish(cmd[1], cmd[2])
+	}
 
 	return resp.MakeIntData(int64(notified))
 }
@@ -39,7 +41,7 @@ func subscribe(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
 		res[i*3+1] = resp.MakeBulkData([]byte("subscribe"))
 		res[i*3+2] = resp.MakeBulkData(channel)
 	}
-	return resp.MakeArrayData(res)
+	return resp.MakePushData(res)
 }
 
 func unsubscribe(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
@@ -55,95 +57,209 @@ func unsubscribe(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
 	res[1] = resp.MakeBulkData([]byte("unsubscribe"))
 	res[2] = resp.MakeBulkData(cmd[1])
 
-	return resp.MakeArrayData(res)
+	return resp.MakePushData(res)
 }
 
-func bLPop(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+// subscriptionReply 生成订阅类命令对单个频道或模式的确认消息，格式为 [kind, channel, count]
+func subscriptionReply(kind string, channel resp.RedisData, subscribed int) resp.RedisData {
+	return resp.MakePushData([]resp.RedisData{
+		resp.MakeBulkData([]byte(kind)),
+		channel,
+		resp.MakeIntData(int64(subscribed)),
+	})
+}
 
-	dataBase := server.dbs[cli.dbSeq]
+// psubscribe 订阅模式，每个模式会单独回复一条确认消息
+func psubscribe(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "psubscribe", 2)
+	if !ok {
+		return e
+	}
+
+	res := make([]resp.RedisData, len(cmd)-1)
 
-	e, ok := CheckCommandAndLength(cmd, "blpop", 3)
+	for i, pattern := range cmd[1:] {
+		subscribed := cli.PSubscribe(server.Chs, string(pattern))
+		res[i] = subscriptionReply("psubscribe", resp.MakeBulkData(pattern), subscribed)
+	}
+	return resp.MakeMultiData(res)
+}
+
+// punsubscribe 取消模式订阅，不指定模式时取消所有的模式订阅
+func punsubscribe(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "punsubscribe", 1)
 	if !ok {
 		return e
 	}
 
-	for i := 1; i < len(cmd)-1; i++ {
-		value, ok := dataBase.GetKey(string(cmd[i]))
-		if !ok {
-			continue
+	patterns := make([]string, 0, len(cmd)-1)
+	for _, pattern := range cmd[1:] {
+		patterns = append(patterns, string(pattern))
+	}
+	if len(patterns) == 0 {
+		for pattern := range cli.patterns {
+			patterns = append(patterns, pattern)
 		}
-		// 如果可以取出，则直接取出
-		listVal, ok := value.(*structure.List)
-		if !ok {
-			return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+		sort.Strings(patterns)
+	}
+
+	// 没有订阅任何模式时也需要回复一次
+	if len(patterns) == 0 {
+		return subscriptionReply("punsubscribe", resp.MakeNullData(), cli.subscriptions())
+	}
+
+	res := make([]resp.RedisData, len(patterns))
+
+	for i, pattern := range patterns {
+		subscribed := cli.PUnSubscribe(server.Chs, pattern)
+		res[i] = subscriptionReply("punsubscribe", resp.MakeBulkData([]byte(pattern)), subscribed)
+	}
+	return resp.MakeMultiData(res)
+}
+
+// pubsub 用于查看订阅发布系统的状态，命令格式： pubsub channels|shardchannels [pattern] | numsub|shardnumsub [channel ...] | numpat
+func pubsub(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "pubsub", 2)
+	if !ok {
+		return e
+	}
+
+	subcommand := strings.ToLower(string(cmd[1]))
+
+	switch subcommand {
+	case "channels", "shardchannels":
+
+		if len(cmd) > 3 {
+			return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for 'pubsub %s' command", subcommand))
 		}
-		if !listVal.Empty() {
-			v, _ := listVal.PopFront().(structure.Slice)
-			return resp.MakeBulkData(v)
+
+		pattern := ""
+		if len(cmd) == 3 {
+			pattern = string(cmd[2])
 		}
-	}
 
-	timeout, w := strconv.Atoi(string(cmd[len(cmd)-1]))
-	if w != nil {
-		return resp.MakeErrorData("ERR timeout is not an integer or out of range")
+		chs := server.Chs
+		if subcommand == "shardchannels" {
+			chs = server.ShardChs
+		}
+
+		channels := chs.ActiveChannels(pattern)
+		res := make([]resp.RedisData, len(channels))
+		for i, channel := range channels {
+			res[i] = resp.MakeBulkData([]byte(channel))
+		}
+		return resp.MakeArrayData(res)
+
+	case "numsub", "shardnumsub":
+
+		chs := server.Chs
+		if subcommand == "shardnumsub" {
+			chs = server.ShardChs
+		}
+
+		res := make([]resp.RedisData, 0, (len(cmd)-2)*2)
+		for _, channel := range cmd[2:] {
+			res = append(res, resp.MakeBulkData(channel), resp.MakeIntData(int64(chs.NumSub(string(channel)))))
+		}
+		return resp.MakeArrayData(res)
+
+	case "numpat":
+
+		if len(cmd) != 2 {
+			return resp.MakeErrorData("ERR wrong number of arguments for 'pubsub numpat' command")
+		}
+		return resp.MakeIntData(int64(server.Chs.NumPat()))
 	}
-	deadline := global.Now.Unix() + int64(timeout)
-	if timeout == 0 {
-		deadline = -1
+
+	return resp.MakeErrorData(fmt.Sprintf("ERR unknown subcommand '%s' of pubsub", subcommand))
+}
+
+// spublish 发布消息到分片频道，集群模式下频道所在的槽必须由当前节点负责
+func spublish(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "spublish", 3)
+	if !ok {
+		return e
 	}
-	for i := 1; i < len(cmd)-1; i++ {
-		dataBase.RegisterBlocked(string(cmd[i]), cli.id, cli.msg, deadline)
+
+	if e = checkShardChannels(server, cmd[1:2]); e != nil {
+		return e
 	}
 
-	cli.blocked = true
-	return nil
+	return resp.MakeIntData(int64(server.ShardChs.PublishShardMessage(string(cmd[1]), cmd[2])))
 }
 
-func bRPop(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+// ssubscribe 订阅分片频道，集群模式下所有频道必须位于当前节点负责的同一个槽中。每个频道会单独回复一条确认消息
+func ssubscribe(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "ssubscribe", 2)
+	if !ok {
+		return e
+	}
+
+	if e = checkShardChannels(server, cmd[1:]); e != nil {
+		return e
+	}
+
+	res := make([]resp.RedisData, len(cmd)-1)
 
-	dataBase := server.dbs[cli.dbSeq]
+	for i, channel := range cmd[1:] {
+		subscribed := cli.SSubscribe(server.ShardChs, string(channel))
+		res[i] = subscriptionReply("ssubscribe", resp.MakeBulkData(channel), subscribed)
+	}
+	return resp.MakeMultiData(res)
+}
 
-	e, ok := CheckCommandAndLength(cmd, "brpop", 3)
+// sunsubscribe 取消分片频道的订阅，不指定频道时取消所有的分片频道订阅
+func sunsubscribe(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "sunsubscribe", 1)
 	if !ok {
 		return e
 	}
 
-	for i := 1; i < len(cmd)-1; i++ {
-		value, ok := dataBase.GetKey(string(cmd[i]))
-		if !ok {
-			continue
-		}
-		// 如果可以取出，则直接取出
-		listVal, ok := value.(*structure.List)
-		if !ok {
-			return resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-		if !listVal.Empty() {
-			v, _ := listVal.PopBack().(structure.Slice)
-			return resp.MakeBulkData(v)
+	if len(cmd) > 1 {
+		if e = checkShardChannels(server, cmd[1:]); e != nil {
+			return e
 		}
 	}
 
-	timeout, w := strconv.Atoi(string(cmd[len(cmd)-1]))
-	if w != nil {
-		return resp.MakeErrorData("ERR timeout is not an integer or out of range")
+	channels := make([]string, 0, len(cmd)-1)
+	for _, channel := range cmd[1:] {
+		channels = append(channels, string(channel))
 	}
-	deadline := global.Now.Unix() + int64(timeout)
-	if timeout == 0 {
-		deadline = -1
+	if len(channels) == 0 {
+		for channel := range cli.shardChs {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
 	}
-	for i := 1; i < len(cmd)-1; i++ {
-		dataBase.RegisterBlocked(string(cmd[i]), cli.id, cli.msg, deadline)
+
+	// 没有订阅任何分片频道时也需要回复一次
+	if len(channels) == 0 {
+		return subscriptionReply("sunsubscribe", resp.MakeNullData(), 0)
 	}
 
-	cli.blocked = true
-	return nil
+	res := make([]resp.RedisData, len(channels))
+
+	for i, channel := range channels {
+		subscribed := cli.SUnSubscribe(server.ShardChs, channel)
+		res[i] = subscriptionReply("sunsubscribe", resp.MakeBulkData([]byte(channel)), subscribed)
+	}
+	return resp.MakeMultiData(res)
 }
 
 func registerPubSubCommands() {
 	RegisterCommand("publish", publish, RD)
 	RegisterCommand("subscribe", subscribe, RD)
 	RegisterCommand("unsubscribe", unsubscribe, RD)
-	RegisterCommand("blpop", bLPop, RD)
-	RegisterCommand("brpop", bRPop, RD)
+	RegisterCommand("psubscribe", psubscribe, RD)
+	RegisterCommand("punsubscribe", punsubscribe, RD)
+	RegisterCommand("pubsub", pubsub, RD)
+	RegisterCommand("spublish", spublish, RD)
+	RegisterCommand("ssubscribe", ssubscribe, RD)
+	RegisterCommand("sunsubscribe", sunsubscribe, RD)
 }
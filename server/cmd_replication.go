@@ -21,12 +21,11 @@ func syncCMD(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
 	server.registerSlave(cli)
 	server.StartEvictionNotification()
 
-	// 后台执行 bgsave 后将文件发送给对方
+	// 后台执行 bgsave 后将文件发送给对方，快照需要在事件循环中生成
+	offset := server.rdbForReplica()
 
 	go func() {
 
-		offset := server.rdbForReplica()
-
 		server.waitForRDBFinished()
 
 		rdbFile, err := os.Open("dump.rdb")
@@ -79,11 +78,12 @@ func psync(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
 	server.StartEvictionNotification()
 
 	// 检查对方的序列号以及 replOffset
-	if replID != server.runID || replOffset < int64(server.minOffset()) {
+	if !server.canContinue(replID, replOffset) {
+
+		offset := server.rdbForReplica()
 
 		go func() {
 
-			offset := server.rdbForReplica()
 			server.waitForRDBFinished()
 
 			rdbFile, err := os.Open(path.Join(server.dir, server.rdbFile))
@@ -161,8 +161,19 @@ func replconf(_ *Server, cli *Client, cmd [][]byte) resp.RedisData {
 			if err != nil {
 				return resp.MakeErrorData("ERR value is not an integer or out of range")
 			}
-			cli.offset = uint64(offset)
-			return resp.MakePlainData("")
+			// 确认的 offset 不能覆盖 backlog 的发送位置，否则已经发送的数据会被重复发送
+			cli.ackOffset = uint64(offset)
+			// 从节点在复制流中读取主节点发送的数据，不能回复任何数据
+			return nil
+
+		case "listening-port":
+
+			port, err := strconv.Atoi(value)
+			if err != nil || port <= 0 || port > 65535 {
+				return resp.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			cli.listeningPort = port
+			return nil
 		}
 	}
 	return resp.MakeStringData("OK")
@@ -178,7 +189,9 @@ func slaveof(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
 	if strings.ToLower(string(cmd[1])) == "no" && strings.ToLower(string(cmd[2])) == "one" {
 		// slaveof no one
 
-		server.slaveToStandAlone()
+		if server.role == Slave {
+			server.slaveToStandAlone()
+		}
 		return resp.MakeStringData("OK")
 	}
 
@@ -187,8 +200,20 @@ func slaveof(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
 	// 创建一个客户端并且连接到对方
 	url := string(cmd[1]) + ":" + string(cmd[2])
 
+	if server.role == Slave && server.masterAlive && server.Master.cnn.RemoteAddr().String() == url {
+		return resp.MakeStringData("OK Already connected to specified master")
+	}
+
 	server.tl.AddTimeEvent(NewSingleTimeEvent(func() {
 
+		// 断开当前的主从复制关系，再连接到新的主节点
+		switch server.role {
+		case Slave:
+			server.slaveToStandAlone()
+		case Master:
+			server.masterToStandAlone()
+		}
+
 		ok := server.sendSyncToMaster(url)
 		if !ok {
 			logger.Error("syncToDisk: Failed")
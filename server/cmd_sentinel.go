@@ -0,0 +1,271 @@
+package server
+
+import (
+	"fmt"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// sentinelCommandTable 是哨兵模式下允许执行的命令
+var sentinelCommandTable = map[string]struct{}{
+	"ping": {}, "info": {}, "sentinel": {}, "auth": {}, "hello": {}, "client": {}, "quit": {}, "shutdown": {},
+	"subscribe": {}, "unsubscribe": {}, "psubscribe": {}, "punsubscribe": {}, "publish": {},
+}
+
+func sentinel(s *Server, _ *Client, cmd [][]byte) resp.RedisData {
+
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "sentinel", 2)
+	if !ok {
+		return e
+	}
+
+	if s.sentinel == nil {
+		return resp.MakeErrorData("ERR This instance is not running in sentinel mode")
+	}
+
+	switch strings.ToLower(string(cmd[1])) {
+
+	case "masters":
+		return sentinelMasters(s.sentinel, cmd)
+
+	case "master":
+		return sentinelMasterInfo(s.sentinel, cmd)
+
+	case "replicas", "slaves":
+		return sentinelReplicas(s.sentinel, cmd)
+
+	case "sentinels":
+		return sentinelSentinels(s.sentinel, cmd)
+
+	case "get-master-addr-by-name":
+		return sentinelGetMasterAddr(s.sentinel, cmd)
+
+	case "is-master-down-by-addr":
+		return sentinelIsMasterDown(s.sentinel, cmd)
+
+	case "myid":
+		return resp.MakeBulkData([]byte(s.sentinel.myID))
+	}
+
+	return resp.MakeErrorData(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try SENTINEL HELP.", cmd[1]))
+}
+
+// sentinelMasterByName 查找 cmd[2] 对应的主节点，找不到时返回错误
+func sentinelMasterByName(st *sentinelState, cmd [][]byte) (*sentinelMaster, resp.RedisData) {
+
+	if len(cmd) != 3 {
+		return nil, resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd[1]))
+	}
+
+	m, ok := st.masters[string(cmd[2])]
+	if !ok {
+		return nil, resp.MakeErrorData("ERR No such master with that name")
+	}
+	return m, nil
+}
+
+func sentinelMasters(st *sentinelState, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) != 2 {
+		return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd[1]))
+	}
+
+	names := st.masterNames()
+	masters := make([]resp.RedisData, len(names))
+	for i, name := range names {
+		masters[i] = sentinelInstanceInfo(st.masters[name].master)
+	}
+	return resp.MakeArrayData(masters)
+}
+
+func sentinelMasterInfo(st *sentinelState, cmd [][]byte) resp.RedisData {
+
+	m, err := sentinelMasterByName(st, cmd)
+	if err != nil {
+		return err
+	}
+	return sentinelInstanceInfo(m.master)
+}
+
+func sentinelReplicas(st *sentinelState, cmd [][]byte) resp.RedisData {
+
+	m, err := sentinelMasterByName(st, cmd)
+	if err != nil {
+		return err
+	}
+	return sentinelInstanceList(m.replicas)
+}
+
+func sentinelSentinels(st *sentinelState, cmd [][]byte) resp.RedisData {
+
+	m, err := sentinelMasterByName(st, cmd)
+	if err != nil {
+		return err
+	}
+	return sentinelInstanceList(m.sentinels)
+}
+
+// sentinelInstanceList 按照地址顺序返回实例的信息
+func sentinelInstanceList(instances map[string]*sentinelInstance) resp.RedisData {
+
+	sorted := make([]*sentinelInstance, 0, len(instances))
+	for _, inst := range instances {
+		sorted = append(sorted, inst)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].addr < sorted[j].addr
+	})
+
+	list := make([]resp.RedisData, len(sorted))
+	for i, inst := range sorted {
+		list[i] = sentinelInstanceInfo(inst)
+	}
+	return resp.MakeArrayData(list)
+}
+
+// sentinelInstanceInfo 返回由字段名与值组成的数组，格式与 redis 相同
+func sentinelInstanceInfo(inst *sentinelInstance) resp.RedisData {
+
+	m := inst.master
+	host, port, _ := net.SplitHostPort(inst.addr)
+
+	name, flags := inst.addr, "slave"
+	switch inst.kind {
+	case sentinelMasterInstance:
+		name, flags = m.name, "master"
+	case sentinelPeerInstance:
+		name, flags = inst.runID, "sentinel"
+	}
+	if inst.sdown {
+		flags += ",s_down"
+	}
+	if inst.kind == sentinelMasterInstance && m.odown {
+		flags += ",o_down"
+	}
+	if inst.kind == sentinelMasterInstance && m.failoverState != sentinelFailoverNone {
+		flags += ",failover_in_progress"
+	}
+	if inst == m.promoted {
+		flags += ",promoted"
+	}
+
+	fields := []string{
+		"name", name,
+		"ip", host,
+		"port", port,
+		"runid", inst.runID,
+		"flags", flags,
+		"link-pending-commands", strconv.Itoa(len(inst.pending)),
+		"last-ok-ping-reply", strconv.FormatInt(global.Now.Sub(inst.lastAvail).Milliseconds(), 10),
+		"down-after-milliseconds", strconv.FormatInt(m.downAfter.Milliseconds(), 10),
+	}
+
+	switch inst.kind {
+	case sentinelMasterInstance:
+		fields = append(fields,
+			"role-reported", inst.role,
+			"config-epoch", strconv.FormatUint(m.configEpoch, 10),
+			"num-slaves", strconv.Itoa(len(m.replicas)),
+			"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+			"quorum", strconv.Itoa(m.quorum),
+			"failover-timeout", strconv.FormatInt(m.failoverTimeout.Milliseconds(), 10),
+		)
+
+	case sentinelReplicaInstance:
+		mHost, mPort, _ := net.SplitHostPort(inst.masterAddr)
+		linkStatus := "err"
+		if inst.masterLinkUp {
+			linkStatus = "ok"
+		}
+		fields = append(fields,
+			"role-reported", inst.role,
+			"master-link-status", linkStatus,
+			"master-host", mHost,
+			"master-port", mPort,
+			"slave-repl-offset", strconv.FormatUint(inst.offset, 10),
+		)
+
+	case sentinelPeerInstance:
+		fields = append(fields,
+			"voted-leader", inst.leader,
+			"voted-leader-epoch", strconv.FormatUint(inst.leaderEpoch, 10),
+		)
+	}
+
+	data := make([]resp.RedisData, len(fields))
+	for i, field := range fields {
+		data[i] = resp.MakeBulkData([]byte(field))
+	}
+	return resp.MakeArrayData(data)
+}
+
+// sentinelGetMasterAddr 返回主节点当前的地址，客户端通过该命令得知故障转移后的新主节点
+func sentinelGetMasterAddr(st *sentinelState, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) != 3 {
+		return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd[1]))
+	}
+
+	m, ok := st.masters[string(cmd[2])]
+	if !ok {
+		return resp.MakeNullData()
+	}
+
+	host, port, _ := net.SplitHostPort(m.master.addr)
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte(host)),
+		resp.MakeBulkData([]byte(port)),
+	})
+}
+
+// sentinelIsMasterDown 是 SENTINEL is-master-down-by-addr <ip> <port> <current-epoch> <runid> 的实现，
+// runid 不为 * 时会在 current-epoch 纪元中为 runid 投票
+func sentinelIsMasterDown(st *sentinelState, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) != 6 {
+		return resp.MakeErrorData(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd[1]))
+	}
+
+	epoch, err := strconv.ParseUint(string(cmd[4]), 10, 64)
+	if err != nil {
+		return resp.MakeErrorData("ERR value is not an integer or out of range")
+	}
+
+	addr := net.JoinHostPort(string(cmd[2]), string(cmd[3]))
+	runID := string(cmd[5])
+
+	down, leader, leaderEpoch := int64(0), "*", uint64(0)
+
+	for _, name := range st.masterNames() {
+		m := st.masters[name]
+		if m.master.addr != addr {
+			continue
+		}
+		if m.master.sdown {
+			down = 1
+		}
+		if runID != "*" {
+			leader, leaderEpoch = st.voteLeader(m, epoch, runID)
+		}
+		break
+	}
+
+	if leader == "" {
+		leader = "*"
+	}
+
+	return resp.MakeArrayData([]resp.RedisData{
+		resp.MakeIntData(down),
+		resp.MakeBulkData([]byte(leader)),
+		resp.MakeIntData(int64(leaderEpoch)),
+	})
+}
+
+func registerSentinelCommand() {
+	RegisterCommand("sentinel", sentinel, RD)
+}
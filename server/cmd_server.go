@@ -2,7 +2,7 @@ package server
 
 import (
 	"fmt"
-	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/config"
 	"github.com/tangrc99/MemTable/resp"
 	"os"
 	"path"
@@ -40,11 +40,35 @@ func bgsave(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
 
 	}
 
-	server.BGRDB()
+	if !server.BGRDB() {
+		return resp.MakeErrorData("ERR Background save already in progress")
+	}
 
 	return resp.MakeStringData("Background saving started")
 }
 
+func bgRewriteAOF(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "bgrewriteaof", 1)
+	if !ok {
+		return e
+	}
+
+	if len(cmd) != 1 {
+		return resp.MakeErrorData("ERR wrong number of arguments for 'bgrewriteaof' command")
+	}
+
+	if server.aofRewriting {
+		return resp.MakeErrorData("ERR Background append only file rewriting already in progress")
+	}
+
+	if !server.BGRewriteAOF() {
+		return resp.MakeErrorData("ERR Background append only file rewriting failed")
+	}
+
+	return resp.MakeStringData("Background append only file rewriting started")
+}
+
 func shutdown(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
 	// 进行输入类型检查
 	e, ok := CheckCommandAndLength(cmd, "shutdown", 1)
@@ -72,7 +96,7 @@ func flushdb(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
 
 	//TODO: 异步操作
 	server.dbs[cli.dbSeq].ReviseNotifyAll()
-	server.dbs[cli.dbSeq] = db.NewDataBase(slotNum)
+	server.dbs[cli.dbSeq].Clear()
 
 	return resp.MakeStringData("OK")
 }
@@ -86,7 +110,7 @@ func flushall(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
 
 	for i := 0; i < server.dbNum; i++ {
 		server.dbs[i].ReviseNotifyAll()
-		server.dbs[i] = db.NewDataBase(slotNum)
+		server.dbs[i].Clear()
 	}
 
 	return resp.MakeStringData("OK")
@@ -167,7 +191,72 @@ func info(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
 		section = string(cmd[1])
 	}
 
-	return resp.MakeStringData(server.Information(section))
+	return resp.MakeBulkData([]byte(server.Information(section)))
+}
+
+// configSet 是 CONFIG SET parameter value [parameter value ...] 的实现，所有参数都合法时才会生效
+func configSet(server *Server, cmd [][]byte) resp.RedisData {
+
+	if len(cmd) < 4 || len(cmd)%2 != 0 {
+		return resp.MakeErrorData("ERR wrong number of arguments for 'config set' command")
+	}
+
+	cfg := config.Conf
+	fields := make([]string, 0, len(cmd)/2-1)
+
+	for i := 2; i < len(cmd); i += 2 {
+		field, err := cfg.Set(string(cmd[i]), string(cmd[i+1]))
+		if err != nil {
+			return resp.MakeErrorData("ERR CONFIG SET failed - " + err.Error())
+		}
+		fields = append(fields, field)
+	}
+
+	config.Conf = cfg
+	server.reloadConfig(fields)
+
+	return resp.MakeStringData("OK")
+}
+
+// configCommand 用于查看以及修改服务器的配置，命令格式： config get|set|rewrite|resetstat [argument ...]
+func configCommand(server *Server, _ *Client, cmd [][]byte) resp.RedisData {
+
+	e, ok := CheckCommandAndLength(cmd, "config", 2)
+	if !ok {
+		return e
+	}
+
+	subcommand := strings.ToLower(string(cmd[1]))
+
+	switch {
+	case subcommand == "get" && len(cmd) >= 3:
+
+		res := make([]resp.RedisData, 0)
+		for _, pattern := range cmd[2:] {
+			for _, v := range config.Conf.Get(string(pattern)) {
+				res = append(res, resp.MakeBulkData([]byte(v)))
+			}
+		}
+		return resp.MakeArrayData(res)
+
+	case subcommand == "set":
+
+		return configSet(server, cmd)
+
+	case subcommand == "rewrite" && len(cmd) == 2:
+
+		if err := config.Conf.Rewrite(); err != nil {
+			return resp.MakeErrorData("ERR Rewriting config file: " + err.Error())
+		}
+		return resp.MakeStringData("OK")
+
+	case subcommand == "resetstat" && len(cmd) == 2:
+
+		server.sts.resetStat()
+		return resp.MakeStringData("OK")
+	}
+
+	return resp.MakeErrorData(fmt.Sprintf("ERR unknown subcommand or wrong number of arguments for '%s'. Try CONFIG HELP.", cmd[1]))
 }
 
 func registerServerCommand() {
@@ -177,6 +266,8 @@ func registerServerCommand() {
 	RegisterCommand("dbsize", dbsize, RD)
 	RegisterCommand("save", save, RD)
 	RegisterCommand("bgsave", bgsave, RD)
+	RegisterCommand("bgrewriteaof", bgRewriteAOF, RD)
 	RegisterCommand("slowlog", slowlog, RD)
 	RegisterCommand("info", info, RD)
+	RegisterCommand("config", configCommand, RD)
 }
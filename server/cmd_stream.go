@@ -0,0 +1,409 @@
+package server
+
+import (
+	"fmt"
+	"github.com/tangrc99/MemTable/db"
+	dbcmd "github.com/tangrc99/MemTable/db/cmd"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
+	"strings"
+)
+
+// streamReadArgs 是 XREAD 和 XREADGROUP 命令的参数
+type streamReadArgs struct {
+	group    string // 消费组，XREAD 为空
+	consumer string // 消费者，XREAD 为空
+	count    int    // 每个 stream 最多返回的消息数量，0 代表不限制
+	block    int64  // 阻塞的毫秒数，-1 代表不阻塞，0 代表永久阻塞
+	noAck    bool   // 读取的消息是否不需要确认
+	keys     [][]byte
+	ids      [][]byte
+}
+
+// parseStreamReadArgs 解析 XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+// 以及 XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func parseStreamReadArgs(cmd [][]byte, withGroup bool) (*streamReadArgs, resp.RedisData) {
+
+	args := &streamReadArgs{block: -1}
+
+	i := 1
+	for ; i < len(cmd); i++ {
+
+		option := strings.ToLower(string(cmd[i]))
+
+		if option == "streams" {
+			break
+		}
+
+		if option == "noack" && withGroup {
+			args.noAck = true
+			continue
+		}
+
+		if option == "group" && withGroup && i+2 < len(cmd) {
+			args.group, args.consumer = string(cmd[i+1]), string(cmd[i+2])
+			i += 2
+			continue
+		}
+
+		if i+1 >= len(cmd) {
+			return nil, resp.MakeErrorData("ERR syntax error")
+		}
+
+		switch option {
+		case "count":
+			count, err := strconv.Atoi(string(cmd[i+1]))
+			if err != nil {
+				return nil, resp.MakeErrorData("ERR value is not an integer or out of range")
+			}
+			if count > 0 {
+				args.count = count
+			}
+		case "block":
+			block, err := strconv.ParseInt(string(cmd[i+1]), 10, 64)
+			if err != nil {
+				return nil, resp.MakeErrorData("ERR timeout is not an integer or out of range")
+			}
+			if block < 0 {
+				return nil, resp.MakeErrorData("ERR timeout is negative")
+			}
+			args.block = block
+		default:
+			return nil, resp.MakeErrorData("ERR syntax error")
+		}
+		i++
+	}
+
+	if withGroup && args.group == "" {
+		return nil, resp.MakeErrorData("ERR Missing GROUP option for XREADGROUP")
+	}
+
+	// STREAMS 之后的参数数量必须为偶数
+	if i >= len(cmd) || (len(cmd)-i-1) == 0 || (len(cmd)-i-1)%2 != 0 {
+		return nil, resp.MakeErrorData("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.")
+	}
+	rest := cmd[i+1:]
+
+	args.keys, args.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+
+	return args, nil
+}
+
+// getStreamForRead 获取 key 对应的 stream，如果 key 不存在将返回 nil
+func getStreamForRead(dataBase *db.DataBase, key []byte) (*structure.Stream, resp.RedisData) {
+
+	value, ok := dataBase.GetKey(string(key))
+	if !ok {
+		return nil, nil
+	}
+
+	stream, ok := value.(*structure.Stream)
+	if !ok {
+		return nil, resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	return stream, nil
+}
+
+// makeStreamReadResult 生成 XREAD 系列命令的回包，RESP3 客户端将收到以 key 为键的 map
+func makeStreamReadResult(cli *Client, keys [][]byte, results []resp.RedisData) resp.RedisData {
+
+	if len(keys) == 0 {
+		return resp.MakeNullData()
+	}
+
+	if cli.protocol == resp.RESP3 {
+		pairs := make([]resp.RedisData, 0, len(keys)*2)
+		for i := range keys {
+			pairs = append(pairs, resp.MakeBulkData(keys[i]), results[i])
+		}
+		return resp.MakeMapData(pairs)
+	}
+
+	res := make([]resp.RedisData, len(keys))
+	for i := range keys {
+		res[i] = resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData(keys[i]), results[i]})
+	}
+	return resp.MakeArrayData(res)
+}
+
+// streamWaiter 是阻塞在 stream 上的 XREAD 或 XREADGROUP 命令
+type streamWaiter struct {
+	server   *Server
+	cli      *Client
+	dataBase *db.DataBase
+	args     *streamReadArgs
+	after    map[string]structure.StreamID // XREAD 命令中每个 stream 已读取的位置
+	done     bool
+}
+
+// finish 解除客户端的阻塞状态，数据库会在 Serve 返回 true 或者超时后取消客户端在所有键上的阻塞
+func (w *streamWaiter) finish(res resp.RedisData) {
+
+	w.done = true
+
+	if w.cli.status == EXIT || w.cli.status == ERROR {
+		return
+	}
+
+	w.cli.blocked = false
+	w.cli.res <- &res
+}
+
+func (w *streamWaiter) Serve(key string) bool {
+
+	if w.done {
+		return true
+	}
+
+	if w.cli.status == EXIT || w.cli.status == ERROR {
+		w.finish(nil)
+		return true
+	}
+
+	stream, err := getStreamForRead(w.dataBase, []byte(key))
+	if err != nil {
+		w.finish(err)
+		return true
+	}
+	if stream == nil {
+		return false
+	}
+
+	var entries []*structure.StreamEntry
+
+	if w.args.group == "" {
+		start, ok := w.after[key].Incr()
+		if !ok {
+			return false
+		}
+		entries = stream.Range(start, structure.MaxStreamID, w.args.count)
+
+	} else {
+		group, ok := stream.Group(w.args.group)
+		if !ok {
+			w.finish(resp.MakeErrorData(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, w.args.group)))
+			return true
+		}
+		oldCost := stream.Cost()
+		entries = group.ReadNew(w.args.consumer, w.args.count, w.args.noAck, global.Now.UnixMilli())
+		// 没有读取到消息时不能通知修改，否则会重复唤醒阻塞在该键上的客户端
+		if len(entries) > 0 || stream.Cost() != oldCost {
+			w.dataBase.ReviseNotify(key, oldCost, stream.Cost())
+		}
+	}
+
+	if len(entries) == 0 {
+		return false
+	}
+
+	if w.args.group != "" {
+		// 消费组的状态发生了变化，需要在当前命令之后传播等价的非阻塞命令
+		w.server.propagateLater(w.cli, streamReadGroupCommand(w.args, key))
+	}
+
+	w.finish(makeStreamReadResult(w.cli, [][]byte{[]byte(key)}, []resp.RedisData{dbcmd.MakeStreamEntries(entries)}))
+
+	return true
+}
+
+func (w *streamWaiter) Timeout() {
+
+	if w.done {
+		return
+	}
+
+	w.finish(resp.MakeNullData())
+}
+
+// streamReadGroupCommand 生成只读取 key 中新消息的非阻塞 XREADGROUP 命令
+func streamReadGroupCommand(args *streamReadArgs, key string) [][]byte {
+
+	cmd := [][]byte{[]byte("xreadgroup"), []byte("group"), []byte(args.group), []byte(args.consumer)}
+	if args.count > 0 {
+		cmd = append(cmd, []byte("count"), []byte(strconv.Itoa(args.count)))
+	}
+	if args.noAck {
+		cmd = append(cmd, []byte("noack"))
+	}
+
+	return append(cmd, []byte("streams"), []byte(key), []byte(">"))
+}
+
+// blockOnStreams 将客户端阻塞在 args 中的所有键上
+func blockOnStreams(server *Server, cli *Client, args *streamReadArgs, after map[string]structure.StreamID) resp.RedisData {
+
+	// 事务与脚本中的命令不能阻塞
+	if server.blockingDenied(cli) {
+		return resp.MakeNullData()
+	}
+
+	dataBase := server.dbs[cli.dbSeq]
+
+	deadline := int64(-1)
+	if args.block > 0 {
+		deadline = global.Now.UnixMilli() + args.block
+	}
+
+	keys := make([]string, len(args.keys))
+	for i, key := range args.keys {
+		keys[i] = string(key)
+	}
+
+	w := &streamWaiter{server: server, cli: cli, dataBase: dataBase, args: args, after: after}
+	dataBase.RegisterWaiter(keys, cli.id, w, deadline)
+
+	cli.blocked = true
+	return nil
+}
+
+func xRead(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "xread", 4)
+	if !ok {
+		return e
+	}
+
+	args, err := parseStreamReadArgs(cmd, false)
+	if err != nil {
+		return err
+	}
+
+	dataBase := server.dbs[cli.dbSeq]
+
+	// 先解析所有 ID，$ 代表 stream 中最后添加的 ID
+	after := make(map[string]structure.StreamID, len(args.keys))
+	streams := make([]*structure.Stream, len(args.keys))
+
+	for i, key := range args.keys {
+
+		stream, err := getStreamForRead(dataBase, key)
+		if err != nil {
+			return err
+		}
+		streams[i] = stream
+
+		if string(args.ids[i]) == "$" {
+			if stream != nil {
+				after[string(key)] = stream.LastID()
+			} else {
+				after[string(key)] = structure.MinStreamID
+			}
+			continue
+		}
+
+		id, e := structure.ParseStreamID(string(args.ids[i]), 0)
+		if e != nil {
+			return resp.MakeErrorData(e.Error())
+		}
+		after[string(key)] = id
+	}
+
+	keys := make([][]byte, 0)
+	results := make([]resp.RedisData, 0)
+
+	for i, key := range args.keys {
+		if streams[i] == nil {
+			continue
+		}
+		start, ok := after[string(key)].Incr()
+		if !ok {
+			continue
+		}
+		if entries := streams[i].Range(start, structure.MaxStreamID, args.count); len(entries) > 0 {
+			keys = append(keys, key)
+			results = append(results, dbcmd.MakeStreamEntries(entries))
+		}
+	}
+
+	if len(keys) == 0 && args.block >= 0 {
+		return blockOnStreams(server, cli, args, after)
+	}
+
+	return makeStreamReadResult(cli, keys, results)
+}
+
+func xReadGroup(server *Server, cli *Client, cmd [][]byte) resp.RedisData {
+	// 进行输入类型检查
+	e, ok := CheckCommandAndLength(cmd, "xreadgroup", 7)
+	if !ok {
+		return e
+	}
+
+	args, err := parseStreamReadArgs(cmd, true)
+	if err != nil {
+		return err
+	}
+
+	dataBase := server.dbs[cli.dbSeq]
+
+	// 先检查所有的消费组与 ID，避免读取到一半时出错
+	groups := make([]*structure.StreamGroup, len(args.keys))
+	streams := make([]*structure.Stream, len(args.keys))
+	history := make([]*structure.StreamID, len(args.keys))
+	onlyNew := true
+
+	for i, key := range args.keys {
+
+		stream, err := getStreamForRead(dataBase, key)
+		if err != nil {
+			return err
+		}
+
+		var group *structure.StreamGroup
+		if stream != nil {
+			group, _ = stream.Group(args.group)
+		}
+		if group == nil {
+			return resp.MakeErrorData(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, args.group))
+		}
+		streams[i], groups[i] = stream, group
+
+		if string(args.ids[i]) == ">" {
+			continue
+		}
+
+		id, e := structure.ParseStreamID(string(args.ids[i]), 0)
+		if e != nil {
+			return resp.MakeErrorData(e.Error())
+		}
+		history[i] = &id
+		onlyNew = false
+	}
+
+	now := global.Now.UnixMilli()
+	keys := make([][]byte, 0)
+	results := make([]resp.RedisData, 0)
+
+	for i, key := range args.keys {
+
+		oldCost := streams[i].Cost()
+
+		// 读取历史消息时即使没有消息也需要返回对应的 key
+		if history[i] != nil {
+			entries := groups[i].ReadHistory(args.consumer, *history[i], args.count, now)
+			keys = append(keys, key)
+			results = append(results, dbcmd.MakeStreamEntries(entries))
+
+		} else if entries := groups[i].ReadNew(args.consumer, args.count, args.noAck, now); len(entries) > 0 {
+			keys = append(keys, key)
+			results = append(results, dbcmd.MakeStreamEntries(entries))
+		}
+
+		dataBase.ReviseNotify(string(key), oldCost, streams[i].Cost())
+	}
+
+	// 只有读取新消息时才会阻塞
+	if len(keys) == 0 && onlyNew && args.block >= 0 {
+		return blockOnStreams(server, cli, args, nil)
+	}
+
+	return makeStreamReadResult(cli, keys, results)
+}
+
+func registerStreamCommands() {
+	RegisterCommand("xread", xRead, RD)
+	RegisterCommand("xreadgroup", xReadGroup, WR)
+}
@@ -1,10 +1,21 @@
 package server
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/config"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/logger"
 	"github.com/tangrc99/MemTable/resp"
 	"github.com/tangrc99/MemTable/server/global"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCmdConn(t *testing.T) {
@@ -49,6 +60,61 @@ func TestCmdConn(t *testing.T) {
 				assert.Equal(t, 2, cli.dbSeq)
 			},
 		},
+
+		{[][]byte{[]byte("hello"), []byte("ff")},
+			resp.MakeErrorData("ERR Protocol version is not an integer or out of range"),
+			func() {},
+		},
+
+		{[][]byte{[]byte("hello"), []byte("4")},
+			resp.MakeErrorData("NOPROTO unsupported protocol version"),
+			func() {
+				assert.Equal(t, resp.RESP2, cli.protocol)
+			},
+		},
+
+		{[][]byte{[]byte("hello"), []byte("3"), []byte("setname")},
+			resp.MakeErrorData("ERR Syntax error in HELLO option 'setname'"),
+			func() {
+				assert.Equal(t, resp.RESP2, cli.protocol)
+			},
+		},
+
+		{[][]byte{[]byte("hello"), []byte("3"), []byte("setname"), []byte("cli")},
+			resp.MakeMapData([]resp.RedisData{
+				resp.MakeBulkData([]byte("server")), resp.MakeBulkData([]byte("memtable")),
+				resp.MakeBulkData([]byte("version")), resp.MakeBulkData([]byte(global.Version)),
+				resp.MakeBulkData([]byte("proto")), resp.MakeIntData(3),
+				resp.MakeBulkData([]byte("id")), resp.MakeIntData(cli.seq),
+				resp.MakeBulkData([]byte("mode")), resp.MakeBulkData([]byte("standalone")),
+				resp.MakeBulkData([]byte("role")), resp.MakeBulkData([]byte("master")),
+				resp.MakeBulkData([]byte("modules")), resp.MakeEmptyArrayData(),
+			}),
+			func() {
+				assert.Equal(t, resp.RESP3, cli.protocol)
+				assert.Equal(t, "cli", cli.name)
+				assert.Equal(t, []byte(">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$3\r\nmsg\r\n"),
+					cli.encodeMessage([]byte("*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$3\r\nmsg\r\n")))
+			},
+		},
+
+		{[][]byte{[]byte("hello"), []byte("2")},
+			resp.MakeMapData([]resp.RedisData{
+				resp.MakeBulkData([]byte("server")), resp.MakeBulkData([]byte("memtable")),
+				resp.MakeBulkData([]byte("version")), resp.MakeBulkData([]byte(global.Version)),
+				resp.MakeBulkData([]byte("proto")), resp.MakeIntData(2),
+				resp.MakeBulkData([]byte("id")), resp.MakeIntData(cli.seq),
+				resp.MakeBulkData([]byte("mode")), resp.MakeBulkData([]byte("standalone")),
+				resp.MakeBulkData([]byte("role")), resp.MakeBulkData([]byte("master")),
+				resp.MakeBulkData([]byte("modules")), resp.MakeEmptyArrayData(),
+			}),
+			func() {
+				assert.Equal(t, resp.RESP2, cli.protocol)
+				// hello 返回的 id 与 client id 相同
+				cmd, _ := global.FindCommand("client")
+				assert.Equal(t, resp.MakeIntData(cli.seq), cmd.Function().(Command)(s, cli, [][]byte{[]byte("client"), []byte("id")}))
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -79,7 +145,7 @@ func TestCmdPubSub(t *testing.T) {
 		},
 
 		{[][]byte{[]byte("subscribe"), []byte("ch1")},
-			resp.MakeArrayData([]resp.RedisData{
+			resp.MakePushData([]resp.RedisData{
 				resp.MakeIntData(1),
 				resp.MakeBulkData([]byte("subscribe")),
 				resp.MakeBulkData([]byte("ch1")),
@@ -93,7 +159,7 @@ func TestCmdPubSub(t *testing.T) {
 		},
 
 		{[][]byte{[]byte("unsubscribe"), []byte("ch1")},
-			resp.MakeArrayData([]resp.RedisData{
+			resp.MakePushData([]resp.RedisData{
 				resp.MakeIntData(0),
 				resp.MakeBulkData([]byte("unsubscribe")),
 				resp.MakeBulkData([]byte("ch1")),
@@ -118,6 +184,193 @@ func TestCmdPubSub(t *testing.T) {
 	}
 }
 
+func TestCmdPatternPubSub(t *testing.T) {
+	s := NewServer()
+	cli := NewFakeClient()
+	cli1 := NewFakeClient()
+
+	// 每个模式单独回复一条 [kind, pattern, count] 格式的确认消息
+	confirm := func(kind string, pattern resp.RedisData, count int64) resp.RedisData {
+		return resp.MakePushData([]resp.RedisData{resp.MakeBulkData([]byte(kind)), pattern, resp.MakeIntData(count)})
+	}
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+		client   *Client
+	}{
+		{"psubscribe news.* *tech", resp.MakeMultiData([]resp.RedisData{
+			confirm("psubscribe", resp.MakeBulkData([]byte("news.*")), 1),
+			confirm("psubscribe", resp.MakeBulkData([]byte("*tech")), 2),
+		}), cli1},
+		{"subscribe news.tech", resp.MakePushData([]resp.RedisData{
+			resp.MakeIntData(3), resp.MakeBulkData([]byte("subscribe")), resp.MakeBulkData([]byte("news.tech")),
+		}), cli1},
+		{"publish news.tech msg", resp.MakeIntData(3), cli},
+		{"publish news.art msg", resp.MakeIntData(1), cli},
+		{"publish sport msg", resp.MakeIntData(0), cli},
+		{"pubsub numpat", resp.MakeIntData(2), cli},
+		{"pubsub channels", resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte("news.tech"))}), cli},
+		{"pubsub channels sport*", resp.MakeArrayData([]resp.RedisData{}), cli},
+		{"pubsub numsub news.tech sport", resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte("news.tech")), resp.MakeIntData(1),
+			resp.MakeBulkData([]byte("sport")), resp.MakeIntData(0),
+		}), cli},
+		{"pubsub none", resp.MakeErrorData("ERR unknown subcommand 'none' of pubsub"), cli},
+		{"punsubscribe news.*", resp.MakeMultiData([]resp.RedisData{
+			confirm("punsubscribe", resp.MakeBulkData([]byte("news.*")), 2),
+		}), cli1},
+		{"punsubscribe", resp.MakeMultiData([]resp.RedisData{
+			confirm("punsubscribe", resp.MakeBulkData([]byte("*tech")), 1),
+		}), cli1},
+		{"punsubscribe", confirm("punsubscribe", resp.MakeNullData(), 1), cli1},
+		{"pubsub numpat", resp.MakeIntData(0), cli},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		cmd, exist := global.FindCommand(string(input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(Command)
+
+		ret := c(s, test.client, input)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+
+	// 模式订阅者收到的是 pmessage
+	assert.Len(t, cli1.msg, 4)
+
+	// RESP2 客户端会依次收到每个模式的确认消息
+	ret := psubscribe(s, cli, [][]byte{[]byte("psubscribe"), []byte("a*"), []byte("b*")})
+	assert.Equal(t, []byte("*3\r\n$10\r\npsubscribe\r\n$2\r\na*\r\n:1\r\n*3\r\n$10\r\npsubscribe\r\n$2\r\nb*\r\n:2\r\n"),
+		cli.encode(ret))
+}
+
+func TestCmdShardPubSub(t *testing.T) {
+	s := NewServer()
+	cli := NewFakeClient()
+	cli1 := NewFakeClient()
+
+	// 每个频道单独回复一条 [kind, channel, count] 格式的确认消息
+	confirm := func(kind string, channel resp.RedisData, count int64) resp.RedisData {
+		return resp.MakePushData([]resp.RedisData{resp.MakeBulkData([]byte(kind)), channel, resp.MakeIntData(count)})
+	}
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+		client   *Client
+	}{
+		{"ssubscribe ch1 ch2", resp.MakeMultiData([]resp.RedisData{
+			confirm("ssubscribe", resp.MakeBulkData([]byte("ch1")), 1),
+			confirm("ssubscribe", resp.MakeBulkData([]byte("ch2")), 2),
+		}), cli1},
+		{"psubscribe ch*", resp.MakeMultiData([]resp.RedisData{
+			confirm("psubscribe", resp.MakeBulkData([]byte("ch*")), 1),
+		}), cli1},
+		// 分片频道与普通频道相互独立
+		{"spublish ch1 msg", resp.MakeIntData(1), cli},
+		{"publish ch1 msg", resp.MakeIntData(1), cli},
+		{"pubsub shardchannels", resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte("ch1")), resp.MakeBulkData([]byte("ch2")),
+		}), cli},
+		{"pubsub channels", resp.MakeArrayData([]resp.RedisData{}), cli},
+		{"pubsub shardnumsub ch1 ch3", resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte("ch1")), resp.MakeIntData(1),
+			resp.MakeBulkData([]byte("ch3")), resp.MakeIntData(0),
+		}), cli},
+		{"sunsubscribe ch1", resp.MakeMultiData([]resp.RedisData{
+			confirm("sunsubscribe", resp.MakeBulkData([]byte("ch1")), 1),
+		}), cli1},
+		{"sunsubscribe", resp.MakeMultiData([]resp.RedisData{
+			confirm("sunsubscribe", resp.MakeBulkData([]byte("ch2")), 0),
+		}), cli1},
+		{"sunsubscribe", confirm("sunsubscribe", resp.MakeNullData(), 0), cli1},
+		{"spublish ch1 msg", resp.MakeIntData(0), cli},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		cmd, exist := global.FindCommand(string(input[0]))
+		assert.True(t, exist)
+		c := cmd.Function().(Command)
+
+		ret := c(s, test.client, input)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("smessage")),
+		resp.MakeBulkData([]byte("ch1")),
+		resp.MakeBulkData([]byte("msg")),
+	}).ToBytes(), <-cli1.msg)
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("pmessage")),
+		resp.MakeBulkData([]byte("ch*")),
+		resp.MakeBulkData([]byte("ch1")),
+		resp.MakeBulkData([]byte("msg")),
+	}).ToBytes(), <-cli1.msg)
+}
+
+func TestCmdShardPubSubInCluster(t *testing.T) {
+	_ = logger.Init("", "", logger.WARNING)
+	s := NewServer()
+	cli := NewFakeClient()
+
+	// 构造一个 ch2 所在的槽由其他节点负责的集群
+	s.clusterStatus.state = ClusterOK
+	s.clusterStatus.self = newSelfNode("127.0.0.1:6380")
+	other := newSelfNode("127.0.0.1:6381")
+	s.clusterStatus.slots = make([]*clusterNode, slotNum)
+	for i := range s.clusterStatus.slots {
+		s.clusterStatus.slots[i] = s.clusterStatus.self
+	}
+	slot := s.getSlot("ch2")
+	s.clusterStatus.slots[slot] = other
+	assert.NotEqual(t, slot, s.getSlot("ch1"))
+
+	moved := resp.MakeErrorData(fmt.Sprintf("MOVED %d 127.0.0.1:6381", slot))
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+	}{
+		{"ssubscribe ch1", resp.MakeMultiData([]resp.RedisData{resp.MakePushData([]resp.RedisData{
+			resp.MakeBulkData([]byte("ssubscribe")), resp.MakeBulkData([]byte("ch1")), resp.MakeIntData(1),
+		})})},
+		{"ssubscribe ch2", moved},
+		{"spublish ch2 msg", moved},
+		{"sunsubscribe ch2", moved},
+		{"ssubscribe ch1 ch2", resp.MakeErrorData("CROSSSLOT Keys in request don't hash to the same slot")},
+		{"spublish ch1 msg", resp.MakeIntData(1)},
+		// publish 不受槽的限制
+		{"publish ch2 msg", resp.MakeIntData(0)},
+		{"cluster publish ch2 msg", resp.MakeIntData(0)},
+		// 数据库命令根据键所在的槽进行重定向
+		{"get ch2", moved},
+		{"get ch1", resp.MakeStringData("nil")},
+		{"scan 0", resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte("0")), resp.MakeArrayData([]resp.RedisData{})})},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		ret, _ := ExecCommand(s, cli, input, nil)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+}
+
 func TestCmdTX(t *testing.T) {
 
 	s := NewServer()
@@ -220,3 +473,1143 @@ func TestCmdTX(t *testing.T) {
 	}
 
 }
+
+func TestCmdTXErrors(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	s := NewServer()
+	cli := NewFakeClient()
+
+	tests := []struct {
+		input    string
+		expected resp.RedisData
+	}{
+		// 入队时出现错误，整个事务被放弃
+		{"multi", resp.MakeStringData("OK")},
+		{"set k1 v", resp.MakeStringData("QUEUED")},
+		{"get", resp.MakeErrorData("ERR wrong number of arguments for 'get' command")},
+		{"exec", resp.MakeErrorData("EXECABORT Transaction discarded because of previous errors.")},
+		{"exists k1", resp.MakeIntData(0)},
+
+		{"multi", resp.MakeStringData("OK")},
+		{"none k1", resp.MakeErrorData("error: unsupported command")},
+		{"set k1 v", resp.MakeStringData("QUEUED")},
+		{"exec", resp.MakeErrorData("EXECABORT Transaction discarded because of previous errors.")},
+		{"exists k1", resp.MakeIntData(0)},
+
+		// discard 之后状态被重置
+		{"multi", resp.MakeStringData("OK")},
+		{"get", resp.MakeErrorData("ERR wrong number of arguments for 'get' command")},
+		{"discard", resp.MakeStringData("OK")},
+		{"multi", resp.MakeStringData("OK")},
+		{"exec", resp.MakeEmptyArrayData()},
+
+		// 执行时出现错误，其他命令仍然会执行
+		{"multi", resp.MakeStringData("OK")},
+		{"set k1 v", resp.MakeStringData("QUEUED")},
+		{"lpush k1 a", resp.MakeStringData("QUEUED")},
+		{"set k2 v", resp.MakeStringData("QUEUED")},
+		{"exec", resp.MakeArrayData([]resp.RedisData{
+			resp.MakeStringData("OK"),
+			resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value"),
+			resp.MakeStringData("OK"),
+		})},
+		{"exists k1 k2", resp.MakeIntData(2)},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		ret, _ := ExecCommand(s, cli, input, nil)
+		assert.Equal(t, test.expected, ret, test.input)
+	}
+}
+
+func TestCmdStream(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	s := NewServer()
+	cli := NewFakeClient()
+	cli1 := NewFakeClient()
+	cli2 := NewFakeClient()
+
+	exec := func(c *Client, input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(s, c, cmd, nil)
+		return ret
+	}
+	bulk := func(s string) resp.RedisData {
+		return resp.MakeBulkData([]byte(s))
+	}
+	entries := func(key string, ids ...string) resp.RedisData {
+		data := make([]resp.RedisData, len(ids))
+		for i, id := range ids {
+			data[i] = resp.MakeArrayData([]resp.RedisData{bulk(id), resp.MakeArrayData([]resp.RedisData{bulk("f"), bulk("v")})})
+		}
+		return resp.MakeArrayData([]resp.RedisData{resp.MakeArrayData([]resp.RedisData{bulk(key), resp.MakeArrayData(data)})})
+	}
+
+	// 非阻塞读取
+	assert.Equal(t, resp.MakeNullData(), exec(cli, "xread STREAMS s 0"))
+	assert.Equal(t, bulk("1-1"), exec(cli, "xadd s 1-1 f v"))
+	assert.Equal(t, bulk("2-1"), exec(cli, "xadd s 2-1 f v"))
+	assert.Equal(t, entries("s", "1-1"), exec(cli, "xread COUNT 1 STREAMS s 0"))
+	assert.Equal(t, entries("s", "2-1"), exec(cli, "xread STREAMS none s 0 1-1"))
+	assert.Equal(t, resp.MakeErrorData("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."),
+		exec(cli, "xread STREAMS s t 0"))
+
+	// 阻塞读取，$ 代表只读取阻塞之后写入的消息
+	assert.Nil(t, exec(cli1, "xread BLOCK 0 STREAMS none s $ $"))
+	assert.True(t, cli1.blocked)
+	assert.Equal(t, bulk("3-1"), exec(cli, "xadd s 3-1 f v"))
+	assert.False(t, cli1.blocked)
+	assert.Equal(t, entries("s", "3-1"), *<-cli1.res)
+
+	// 阻塞超时
+	assert.Nil(t, exec(cli1, "xread BLOCK 1 STREAMS s $"))
+	time.Sleep(2 * time.Millisecond)
+	global.UpdateGlobalClock()
+	s.dbs[0].CleanTimeoutBlocked()
+	assert.False(t, cli1.blocked)
+	assert.Equal(t, resp.MakeNullData(), *<-cli1.res)
+
+	// 消费组
+	assert.Equal(t, resp.MakeStringData("OK"), exec(cli, "xgroup create s g $"))
+	assert.Equal(t, resp.MakeErrorData("NOGROUP No such key 's' or consumer group 'none' in XREADGROUP with GROUP option"),
+		exec(cli, "xreadgroup GROUP none c STREAMS s >"))
+	assert.Equal(t, resp.MakeNullData(), exec(cli, "xreadgroup GROUP g c STREAMS s >"))
+	assert.Nil(t, exec(cli1, "xreadgroup GROUP g c1 BLOCK 0 STREAMS s >"))
+	assert.Nil(t, exec(cli2, "xreadgroup GROUP g c2 BLOCK 0 STREAMS s >"))
+
+	// 先阻塞的客户端先被唤醒，新消息只会投递给一个消费者
+	assert.Equal(t, bulk("4-1"), exec(cli, "xadd s 4-1 f v"))
+	assert.False(t, cli1.blocked)
+	assert.True(t, cli2.blocked)
+	assert.Equal(t, entries("s", "4-1"), *<-cli1.res)
+	assert.Len(t, s.deferred, 1)
+	assert.Equal(t, "xreadgroup group g c1 streams s >", string(bytes.Join(s.deferred[0].cmd, []byte(" "))))
+	s.propagateDeferred()
+	assert.Empty(t, s.deferred)
+
+	// 读取历史消息不会阻塞
+	assert.Equal(t, entries("s", "4-1"), exec(cli1, "xreadgroup GROUP g c1 BLOCK 0 STREAMS s 0"))
+	assert.Equal(t, resp.MakeIntData(1), exec(cli1, "xack s g 4-1"))
+	assert.Equal(t, entries("s"), exec(cli1, "xreadgroup GROUP g c1 STREAMS s 0"))
+
+	// RESP3 客户端收到 map 类型的回包
+	cli.protocol = resp.RESP3
+	ret := exec(cli, "xread STREAMS s 3-1")
+	_, ok := ret.(*resp.MapData)
+	assert.True(t, ok)
+}
+
+func TestCmdBlocking(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	s := NewServer()
+	cli := NewFakeClient()
+	cli1 := NewFakeClient()
+	cli2 := NewFakeClient()
+
+	exec := func(c *Client, input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(s, c, cmd, nil)
+		return ret
+	}
+	array := func(values ...resp.RedisData) resp.RedisData {
+		return resp.MakeArrayData(values)
+	}
+	bulk := func(s string) resp.RedisData {
+		return resp.MakeBulkData([]byte(s))
+	}
+	// propagated 返回并清空需要传播的命令
+	propagated := func() []string {
+		res := make([]string, len(s.deferred))
+		for i, event := range s.deferred {
+			res[i] = string(bytes.Join(event.cmd, []byte(" ")))
+		}
+		s.deferred = s.deferred[:0]
+		return res
+	}
+
+	// 超时参数
+	assert.Equal(t, resp.MakeErrorData("ERR timeout is negative"), exec(cli, "blpop l -1"))
+	assert.Equal(t, resp.MakeErrorData("ERR timeout is not a float or out of range"), exec(cli, "blpop l abc"))
+
+	// 可以立即取出时不会阻塞，传播等价的非阻塞命令
+	assert.Equal(t, resp.MakeIntData(2), exec(cli, "rpush l a b"))
+	assert.Equal(t, array(bulk("l"), bulk("a")), exec(cli, "blpop none l 0"))
+	assert.Equal(t, array(bulk("l"), bulk("b")), exec(cli, "brpop l 0.5"))
+	assert.Equal(t, []string{"lpop l", "rpop l"}, propagated())
+
+	// 先阻塞的客户端先被唤醒
+	assert.Nil(t, exec(cli1, "blpop none l 0"))
+	assert.Nil(t, exec(cli2, "brpop l 0"))
+	assert.True(t, cli1.blocked)
+	assert.Equal(t, resp.MakeIntData(3), exec(cli, "rpush l a b c"))
+	assert.False(t, cli1.blocked)
+	assert.False(t, cli2.blocked)
+	assert.Equal(t, array(bulk("l"), bulk("a")), *<-cli1.res)
+	assert.Equal(t, array(bulk("l"), bulk("c")), *<-cli2.res)
+	assert.Equal(t, []string{"lpop l", "rpop l"}, propagated())
+	assert.False(t, s.dbs[0].IsBlocked(cli1.id))
+
+	// 被唤醒的 blmove 写入的键可以继续唤醒其他客户端
+	assert.Nil(t, exec(cli1, "blpop dst 0"))
+	assert.Nil(t, exec(cli2, "brpoplpush src dst 0"))
+	assert.Equal(t, resp.MakeIntData(1), exec(cli, "lpush src x"))
+	assert.Equal(t, bulk("x"), *<-cli2.res)
+	assert.Equal(t, array(bulk("dst"), bulk("x")), *<-cli1.res)
+	assert.Equal(t, []string{"lmove src dst RIGHT LEFT", "lpop dst"}, propagated())
+	assert.Equal(t, bulk("b"), exec(cli, "blmove l dst LEFT RIGHT 0"))
+	assert.Equal(t, []string{"lmove l dst LEFT RIGHT"}, propagated())
+
+	// 有序集合
+	assert.Nil(t, exec(cli1, "bzpopmin z 0"))
+	assert.Nil(t, exec(cli2, "bzmpop 0 2 z z2 MAX COUNT 2"))
+	assert.Equal(t, resp.MakeIntData(3), exec(cli, "zadd z2 1 a 2 b 3 c"))
+	assert.True(t, cli1.blocked)
+	assert.Equal(t, array(bulk("z2"), array(array(bulk("c"), resp.MakeDoubleData(3)), array(bulk("b"), resp.MakeDoubleData(2)))), *<-cli2.res)
+	assert.Equal(t, resp.MakeIntData(1), exec(cli, "zadd z 5 d"))
+	assert.Equal(t, array(bulk("z"), bulk("d"), resp.MakeDoubleData(5)), *<-cli1.res)
+	assert.Equal(t, []string{"zpopmax z2 2", "zpopmin z"}, propagated())
+	assert.Equal(t, array(bulk("z2"), bulk("a"), resp.MakeDoubleData(1)), exec(cli, "bzpopmax none z2 0"))
+	assert.Equal(t, array(bulk("dst"), array(bulk("b"))), exec(cli, "blmpop 0 1 dst LEFT COUNT 3"))
+	assert.Equal(t, []string{"zpopmax z2", "lpop dst"}, propagated())
+
+	// 不足一秒的超时
+	assert.Nil(t, exec(cli1, "blmpop 0.01 1 none LEFT"))
+	time.Sleep(15 * time.Millisecond)
+	global.UpdateGlobalClock()
+	s.dbs[0].CleanTimeoutBlocked()
+	assert.False(t, cli1.blocked)
+	assert.Equal(t, resp.MakeNullData(), *<-cli1.res)
+	assert.Equal(t, resp.MakeIntData(1), exec(cli, "lpush none v"))
+	assert.Empty(t, propagated())
+
+	// 事务中的阻塞命令不会阻塞，取出的元素作为事务的一部分传播
+	assert.Equal(t, resp.MakeStringData("OK"), exec(cli, "multi"))
+	exec(cli, "blpop empty 0")
+	exec(cli, "blpop none 0")
+	assert.Equal(t, array(resp.MakeNullData(), array(bulk("none"), bulk("v"))), exec(cli, "exec"))
+	assert.False(t, cli.blocked)
+	assert.Empty(t, s.deferred)
+
+	// 类型错误
+	assert.Equal(t, resp.MakeStringData("OK"), exec(cli, "set str v"))
+	assert.Equal(t, resp.MakeErrorData("WRONGTYPE Operation against a key holding the wrong kind of value"), exec(cli, "bzpopmin str 0"))
+}
+
+func TestCmdClient(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	s := NewServer()
+	cli := NewFakeClient()
+	cli1 := NewFakeClient()
+	cli2 := NewFakeClient()
+	for _, c := range []*Client{cli, cli1, cli2} {
+		s.clis.AddClientIfNotExist(c)
+	}
+
+	exec := func(c *Client, input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(s, c, cmd, nil)
+		return ret
+	}
+	// handle 模拟事件循环处理客户端的命令
+	handle := func(c *Client, input string) {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		s.handleEvent(&Event{cmd: cmd, cli: c})
+	}
+	seq := func(c *Client) string {
+		return strconv.FormatInt(c.seq, 10)
+	}
+
+	assert.Equal(t, resp.MakeIntData(cli.seq), exec(cli, "client id"))
+	assert.Equal(t, resp.MakeNullData(), exec(cli, "client getname"))
+	assert.Equal(t, resp.MakeStringData("OK"), exec(cli, "client setname conn1"))
+	assert.Equal(t, resp.MakeBulkData([]byte("conn1")), exec(cli, "client getname"))
+	assert.Equal(t, resp.MakeErrorData("ERR Client names cannot contain spaces, newlines or special characters."),
+		exec(cli, "client setname a\nb"))
+	assert.Equal(t, resp.MakeErrorData("ERR unknown subcommand or wrong number of arguments for 'none'. Try CLIENT HELP."),
+		exec(cli, "client none"))
+
+	// list 与 info
+	info := string(exec(cli, "client info").ByteData())
+	assert.True(t, strings.HasPrefix(info, "id="+seq(cli)+" "))
+	assert.Contains(t, info, " name=conn1 ")
+	assert.Contains(t, info, " flags=N ")
+	assert.Contains(t, info, " multi=-1 ")
+
+	list := string(exec(cli, "client list").ByteData())
+	assert.Equal(t, 3, strings.Count(list, "\n"))
+	assert.True(t, strings.HasPrefix(list, "id="+seq(cli)+" "))
+
+	exec(cli1, "subscribe ch")
+	list = string(exec(cli, "client list TYPE pubsub").ByteData())
+	assert.True(t, strings.HasPrefix(list, "id="+seq(cli1)+" "))
+	assert.Contains(t, list, " sub=1 ")
+	list = string(exec(cli, "client list ID "+seq(cli2)+" "+seq(cli)).ByteData())
+	assert.Equal(t, 2, strings.Count(list, "\n"))
+	assert.Equal(t, resp.MakeErrorData("ERR Unknown client type 'none'"), exec(cli, "client list TYPE none"))
+
+	assert.Equal(t, resp.MakeStringData("OK"), exec(cli2, "client no-evict on"))
+	assert.False(t, cli2.IsRemovable())
+	assert.Contains(t, string(exec(cli2, "client info").ByteData()), " flags=e ")
+
+	// unblock
+	assert.Nil(t, exec(cli2, "blpop l 0"))
+	assert.Equal(t, resp.MakeIntData(1), exec(cli, "client unblock "+seq(cli2)+" error"))
+	assert.Equal(t, resp.MakeErrorData("UNBLOCKED client unblocked via CLIENT UNBLOCK"), *<-cli2.res)
+	assert.False(t, cli2.blocked)
+	assert.Nil(t, exec(cli2, "blpop l 0"))
+	assert.Equal(t, resp.MakeIntData(1), exec(cli, "client unblock "+seq(cli2)))
+	assert.Equal(t, resp.MakeNullData(), *<-cli2.res)
+	assert.Equal(t, resp.MakeIntData(0), exec(cli, "client unblock "+seq(cli2)))
+
+	// reply
+	handle(cli2, "client reply skip")
+	handle(cli2, "ping")
+	assert.Len(t, cli2.res, 0)
+	handle(cli2, "client reply off")
+	handle(cli2, "ping")
+	assert.Len(t, cli2.res, 0)
+	handle(cli2, "client reply on")
+	handle(cli2, "ping")
+	assert.Equal(t, resp.MakeStringData("OK"), *<-cli2.res)
+	assert.Equal(t, resp.MakeStringData("pong"), *<-cli2.res)
+
+	// pause 期间的写命令以及同一个客户端之后的命令会被延后执行
+	assert.Equal(t, resp.MakeStringData("OK"), exec(cli, "client pause 10000 WRITE"))
+	handle(cli2, "set k v")
+	handle(cli2, "get k")
+	handle(cli, "get k")
+	assert.Len(t, s.postponed, 2)
+	assert.Len(t, cli2.res, 0)
+	assert.Equal(t, resp.MakeStringData("nil"), *<-cli.res)
+	s.resumePostponed()
+	assert.Len(t, s.postponed, 2)
+
+	assert.Equal(t, resp.MakeStringData("OK"), exec(cli, "client unpause"))
+	s.resumePostponed()
+	assert.Empty(t, s.postponed)
+	assert.Equal(t, resp.MakeStringData("OK"), *<-cli2.res)
+	assert.Equal(t, resp.MakeBulkData([]byte("v")), *<-cli2.res)
+
+	assert.Equal(t, resp.MakeErrorData("ERR timeout is negative"), exec(cli, "client pause -1"))
+	assert.Equal(t, resp.MakeErrorData("ERR syntax error"), exec(cli, "client pause 10 none"))
+
+	// kill
+	assert.Equal(t, resp.MakeErrorData("ERR client-id should be greater than 0"), exec(cli, "client kill id none"))
+	assert.Equal(t, resp.MakeIntData(0), exec(cli, "client kill id "+seq(cli)))
+	assert.Equal(t, resp.MakeIntData(1), exec(cli, "client kill id "+seq(cli2)))
+	assert.Equal(t, EXIT, cli2.status)
+	assert.Equal(t, resp.MakeIntData(1), exec(cli, "client kill type pubsub skipme yes"))
+	assert.Equal(t, 1, s.clis.Size())
+	assert.Equal(t, resp.MakeErrorData("ERR No such client"), exec(cli, "client kill 127.0.0.1:1"))
+}
+
+func TestCmdConfig(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	conf := config.Conf
+	t.Cleanup(func() {
+		config.Conf = conf
+	})
+
+	s := NewServer()
+	cli := NewFakeClient()
+	s.clis.AddClientIfNotExist(cli)
+
+	exec := func(input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(s, cli, cmd, nil)
+		return ret
+	}
+
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("slowlog-log-slower-than")), resp.MakeBulkData([]byte(strconv.FormatInt(conf.SlowLogSlowerThan, 10))),
+		resp.MakeBulkData([]byte("slowlog-max-len")), resp.MakeBulkData([]byte(strconv.Itoa(conf.SlowLogMaxLen))),
+	}), exec("config get slowlog-*"))
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{}), exec("config get none"))
+
+	// 多个参数同时修改
+	assert.Equal(t, resp.MakeStringData("OK"), exec("config set maxmemory 1024 eviction lru slowlog-max-len 2 timeout -1"))
+	assert.Equal(t, uint64(1024), config.Conf.MaxMemory)
+	assert.Equal(t, uint64(1024), s.sts.maxMemory)
+	assert.Equal(t, -1, s.cliTimeout)
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("eviction")), resp.MakeBulkData([]byte("lru")),
+	}), exec("config get EVICTION"))
+
+	for i := 0; i < 3; i++ {
+		s.slowlog.appendEntry([][]byte{[]byte("get"), []byte("k")}, 1)
+	}
+	assert.Equal(t, int64(2), s.slowlog.Len())
+
+	// 任意一个参数不合法时，所有参数都不会生效
+	assert.Equal(t, resp.MakeErrorData("ERR CONFIG SET failed - Option 'port' can't be set at runtime"),
+		exec("config set maxmemory 2048 port 6379"))
+	assert.Equal(t, uint64(1024), config.Conf.MaxMemory)
+	assert.Equal(t, resp.MakeErrorData("ERR CONFIG SET failed - Invalid argument 'random' for option 'eviction' - "+
+		"eviction should be one of no, lru, lfu"), exec("config set eviction random"))
+	assert.Equal(t, resp.MakeErrorData("ERR wrong number of arguments for 'config set' command"),
+		exec("config set maxmemory"))
+
+	// resetstat
+	assert.Contains(t, s.Information("stats"), "total_commands_processed:0\n")
+	s.sts.totalCommands = 10
+	assert.Equal(t, resp.MakeStringData("OK"), exec("config resetstat"))
+	assert.Equal(t, int64(0), s.sts.totalCommands)
+
+	// rewrite
+	config.Conf.ConfFile = ""
+	assert.Equal(t, resp.MakeErrorData("ERR Rewriting config file: The server is running without a config file"),
+		exec("config rewrite"))
+	config.Conf.ConfFile = path.Join(t.TempDir(), "test.conf")
+	assert.Equal(t, resp.MakeStringData("OK"), exec("config rewrite"))
+	content, err := os.ReadFile(config.Conf.ConfFile)
+	assert.Nil(t, err)
+	assert.Contains(t, string(content), "maxmemory 1024\n")
+
+	assert.Equal(t, resp.MakeErrorData("ERR unknown subcommand or wrong number of arguments for 'none'. Try CONFIG HELP."),
+		exec("config none"))
+}
+
+func TestCmdTracking(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	s := NewServer()
+	cli := NewFakeClient()
+	cli1 := NewFakeClient()
+	cli2 := NewFakeClient()
+	for _, c := range []*Client{cli, cli1, cli2} {
+		s.clis.AddClientIfNotExist(c)
+	}
+
+	exec := func(c *Client, input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(s, c, cmd, nil)
+		return ret
+	}
+	invalidate := func(key string) []byte {
+		keys := resp.RedisData(resp.MakeNullData())
+		if key != "" {
+			keys = resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte(key))})
+		}
+		return resp.MakePushData([]resp.RedisData{resp.MakeBulkData([]byte("invalidate")), keys}).ToBytes()
+	}
+	ok := resp.MakeStringData("OK")
+
+	assert.Equal(t, resp.MakeErrorData("ERR Client tracking without REDIRECT requires RESP3, use HELLO 3 first"),
+		exec(cli, "client tracking on"))
+	assert.Equal(t, resp.MakeIntData(-1), exec(cli, "client getredir"))
+
+	// 默认模式
+	exec(cli, "hello 3")
+	assert.Equal(t, ok, exec(cli, "client tracking on"))
+	assert.Equal(t, resp.MakeIntData(0), exec(cli, "client getredir"))
+	assert.Contains(t, string(exec(cli, "client info").ByteData()), " flags=t ")
+	exec(cli1, "set k1 v1")
+	exec(cli1, "set k2 v2")
+	exec(cli, "get k1")
+	exec(cli, "mget k2 k3")
+	assert.Len(t, cli.msg, 0)
+
+	exec(cli1, "set k1 v")
+	assert.Equal(t, invalidate("k1"), <-cli.msg)
+	// 失效通知只发送一次
+	exec(cli1, "set k1 v")
+	assert.Len(t, cli.msg, 0)
+	exec(cli1, "del k2")
+	assert.Equal(t, invalidate("k2"), <-cli.msg)
+
+	// 写命令中读取的键不会被追踪
+	exec(cli, "incr n")
+	exec(cli1, "incr n")
+	assert.Len(t, cli.msg, 0)
+
+	exec(cli, "get k1")
+	exec(cli1, "flushall")
+	assert.Equal(t, invalidate(""), <-cli.msg)
+
+	assert.Equal(t, resp.MakeErrorData("ERR You can't switch BCAST mode on/off before disabling tracking for this client, "+
+		"and then re-enabling it with a different mode."), exec(cli, "client tracking on bcast"))
+	assert.Equal(t, ok, exec(cli, "client tracking off"))
+	exec(cli, "get k1")
+	exec(cli1, "set k1 v")
+	assert.Len(t, cli.msg, 0)
+
+	// 广播模式
+	assert.Equal(t, resp.MakeErrorData("ERR PREFIX option requires BCAST mode to be enabled"),
+		exec(cli, "client tracking on prefix user:"))
+	assert.Equal(t, ok, exec(cli, "client tracking on bcast prefix user: prefix item:"))
+	exec(cli1, "set user:1 v")
+	exec(cli1, "set other v")
+	exec(cli1, "set item:1 v")
+	assert.Equal(t, invalidate("user:1"), <-cli.msg)
+	assert.Equal(t, invalidate("item:1"), <-cli.msg)
+	assert.Len(t, cli.msg, 0)
+	assert.Equal(t, ok, exec(cli, "client tracking off"))
+	exec(cli1, "set user:1 v")
+	assert.Len(t, cli.msg, 0)
+
+	// RESP2 客户端通过订阅 __redis__:invalidate 频道接收重定向的通知
+	assert.Equal(t, resp.MakeErrorData("ERR The client ID you want redirect to does not exist"),
+		exec(cli, "client tracking on redirect 100000"))
+	exec(cli2, "subscribe __redis__:invalidate")
+	assert.Equal(t, ok, exec(cli1, "client tracking on bcast redirect "+strconv.FormatInt(cli2.seq, 10)))
+	assert.Equal(t, resp.MakeIntData(cli2.seq), exec(cli1, "client getredir"))
+	exec(cli, "set k v")
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("message")), resp.MakeBulkData([]byte("__redis__:invalidate")),
+		resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte("k"))}),
+	}).ToBytes(), <-cli2.msg)
+
+	// 客户端断开后广播前缀会被移除
+	s.shutdownClient(cli1)
+	exec(cli, "set k v")
+	assert.Len(t, cli2.msg, 0)
+}
+
+func TestCmdIntrospection(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	s := NewServer()
+	cli := NewFakeClient()
+	s.clis.AddClientIfNotExist(cli)
+
+	exec := func(input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(s, cli, cmd, nil)
+		return ret
+	}
+	bulk := func(s string) resp.RedisData {
+		return resp.MakeBulkData([]byte(s))
+	}
+
+	exec("set n 100")
+	exec("set s hello")
+	exec("rpush l a b c")
+	exec("select 1")
+	exec("hset h f v")
+	exec("select 0")
+
+	// object
+	assert.Equal(t, bulk("int"), exec("object encoding n"))
+	assert.Equal(t, bulk("embstr"), exec("object encoding s"))
+	assert.Equal(t, bulk("linkedlist"), exec("object encoding l"))
+	assert.Equal(t, resp.MakeNullData(), exec("object encoding none"))
+	assert.Equal(t, resp.MakeIntData(1), exec("object refcount s"))
+	assert.Equal(t, resp.MakeIntData(0), exec("object idletime s"))
+	assert.Equal(t, resp.MakeErrorData("ERR An LFU maxmemory policy is not selected, access frequency not tracked."),
+		exec("object freq s"))
+	assert.Equal(t, resp.MakeErrorData("ERR unknown subcommand or wrong number of arguments for 'none'. Try OBJECT HELP."),
+		exec("object none s"))
+
+	// memory
+	assert.Equal(t, resp.MakeIntData(int64(len("s")+8+len("hello"))), exec("memory usage s"))
+	assert.Equal(t, resp.MakeIntData(int64(len("s")+8+len("hello"))), exec("memory usage s SAMPLES 5"))
+	assert.Equal(t, resp.MakeErrorData("ERR syntax error"), exec("memory usage s count 5"))
+	assert.Equal(t, resp.MakeNullData(), exec("memory usage none"))
+
+	stats, ok := exec("memory stats").(*resp.MapData)
+	assert.True(t, ok)
+	assert.Contains(t, stats.Data(), bulk("keys.count"))
+	assert.Contains(t, stats.Data(), resp.MakeIntData(4))
+	assert.Contains(t, stats.Data(), bulk("db.1"))
+	assert.NotContains(t, stats.Data(), bulk("db.2"))
+
+	// debug
+	assert.Equal(t, resp.MakeStringData(fmt.Sprintf("refcount:1 encoding:embstr cost:%d lru_seconds_idle:0", len("s")+8+len("hello"))),
+		exec("debug object s"))
+	assert.Equal(t, resp.MakeErrorData("ERR no such key"), exec("debug object none"))
+	assert.Equal(t, resp.MakeStringData("OK"), exec("debug sleep 0.01"))
+	assert.Equal(t, resp.MakeErrorData("ERR Out of range database"), exec("debug htstats 100"))
+
+	htStats, ok := exec("debug htstats 0").(*resp.BulkData)
+	assert.True(t, ok)
+	assert.Contains(t, string(htStats.Data()), "keys: 3")
+	jmap, ok := exec("debug jmap").(*resp.BulkData)
+	assert.True(t, ok)
+	assert.Contains(t, string(jmap.Data()), "# db1")
+
+	assert.Equal(t, resp.MakeIntData(1), exec("expire s 100"))
+	assert.Equal(t, resp.MakeStringData("OK"), exec("debug reload"))
+	assert.Equal(t, bulk("100"), exec("get n"))
+	assert.Equal(t, resp.MakeIntData(100), exec("ttl s"))
+	assert.Equal(t, resp.MakeIntData(3), exec("llen l"))
+	exec("select 1")
+	assert.Equal(t, bulk("v"), exec("hget h f"))
+	exec("select 0")
+}
+
+func TestCmdMigrate(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	s := NewServer()
+	cli := NewFakeClient()
+
+	// 目标实例在后台协程中逐条执行收到的命令
+	target := NewServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			c := NewFakeClient()
+			parser := resp.NewParser(conn)
+			for {
+				parsed := parser.Parse()
+				if parsed.Err != nil {
+					break
+				}
+				ret, _ := ExecCommand(target, c, parsed.Data.(*resp.ArrayData).ToCommand(), nil)
+				_, _ = conn.Write(ret.ToBytes())
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	exec := func(input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(s, cli, cmd, nil)
+		return ret
+	}
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	prefix := "migrate " + host + " " + port + " "
+
+	exec("set k1 v1")
+	exec("set k2 v2")
+	exec("rpush l a b")
+	exec("pexpire l 100000")
+
+	assert.Equal(t, resp.MakeStringData("NOKEY"), exec(prefix+"none 0 1000"))
+	assert.Equal(t, resp.MakeErrorData("ERR syntax error"), exec(prefix+"k1 0 1000 none"))
+
+	assert.Equal(t, resp.MakeStringData("OK"), exec(prefix+"k1 1 1000 copy"))
+	assert.Equal(t, resp.MakeBulkData([]byte("v1")), exec("get k1"))
+	assert.Empty(t, s.deferred)
+
+	assert.Equal(t, resp.MakeErrorData("ERR Target instance replied with error: BUSYKEY Target key name already exists."),
+		exec(prefix+"k1 1 1000"))
+	assert.Equal(t, resp.MakeBulkData([]byte("v1")), exec("get k1"))
+
+	assert.Equal(t, resp.MakeStringData("OK"), exec(prefix+" 1 1000 replace keys k1 k2 l none"))
+	assert.Equal(t, resp.MakeIntData(0), exec("exists k1 k2 l"))
+	assert.Len(t, s.deferred, 1)
+	assert.Equal(t, "del k1 k2 l", string(bytes.Join(s.deferred[0].cmd, []byte(" "))))
+
+	base := target.dbs[1]
+	value, ok := base.GetKey("k2")
+	assert.True(t, ok)
+	assert.Equal(t, structure.Slice("v2"), value)
+	assert.Greater(t, base.GetTTL("l"), int64(0))
+	assert.Equal(t, int64(-1), base.GetTTL("k1"))
+
+	exec("set k1 v1")
+	assert.Equal(t, resp.MakeErrorData("IOERR error or timeout connecting to the client"),
+		exec("migrate 127.0.0.1 1 k1 0 100"))
+}
+
+// slotWatcher 只记录宣布的槽变更，其余方法不会在测试中被调用
+type slotWatcher struct {
+	clusterWatcher
+	announced map[int]string
+	epochs    map[string]uint64
+}
+
+func (w *slotWatcher) slotAnnounce(slot int, node string) {
+	w.announced[slot] = node
+}
+
+func (w *slotWatcher) configEpoch(master string) uint64 {
+	return w.epochs[master]
+}
+
+// makeTestCluster 构造一个所有槽都由 self 负责的集群，other 作为另一个 shard 的主节点
+func makeTestCluster(s *Server, self, other string) *slotWatcher {
+	watcher := &slotWatcher{announced: make(map[int]string)}
+	c := &s.clusterStatus
+	c.server = s
+	c.state = ClusterOK
+	c.self = newSelfNode(self)
+	c.nodes = map[string]*clusterNode{self: c.self, other: newSelfNode(other)}
+	c.downNodes = make(map[string]*clusterNode)
+	c.slots = make([]*clusterNode, slotNum)
+	for i := range c.slots {
+		c.slots[i] = c.self
+	}
+	c.importing = make(map[int]*clusterNode)
+	c.migrating = make(map[int]*clusterNode)
+	c.migrations = make(map[int]*slotMigration)
+	c.migrated = make(chan *slotMigration, slotMigrateResults)
+	c.watcher = watcher
+	return watcher
+}
+
+// waitSlotMigrations 进行一轮槽迁移，并等待后台的发送全部完成
+func waitSlotMigrations(s *Server) {
+	c := &s.clusterStatus
+	c.migrateSlots()
+	running := 0
+	for _, m := range c.migrations {
+		if m.running {
+			running++
+		}
+	}
+	for ; running > 0; running-- {
+		c.finishSlotMigration(<-c.migrated)
+	}
+}
+
+func TestCmdClusterSlotMigration(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	// 目标节点在后台协程中逐条执行收到的命令
+	target := NewServer()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			c := NewFakeClient()
+			parser := resp.NewParser(conn)
+			for {
+				parsed := parser.Parse()
+				if parsed.Err != nil {
+					break
+				}
+				ret, _ := ExecCommand(target, c, parsed.Data.(*resp.ArrayData).ToCommand(), nil)
+				_, _ = conn.Write(ret.ToBytes())
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	source := "127.0.0.1:6380"
+	dest := listener.Addr().String()
+
+	s := NewServer()
+	cli := NewFakeClient()
+	watcher := makeTestCluster(s, source, dest)
+
+	// 目标节点的视图中，所有槽都由源节点负责
+	makeTestCluster(target, dest, source)
+	for i := range target.clusterStatus.slots {
+		target.clusterStatus.slots[i] = target.clusterStatus.nodes[source]
+	}
+
+	exec := func(server *Server, c *Client, input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(server, c, cmd, nil)
+		return ret
+	}
+
+	slot := s.getSlot("k1")
+	assert.Equal(t, slot, s.getSlot("{k1}a"))
+	setSlot := fmt.Sprintf("cluster setslot %d ", slot)
+
+	exec(s, cli, "set k1 v1")
+	exec(s, cli, "set {k1}a v2")
+	exec(s, cli, "set {k1}b v")
+
+	// 参数检查
+	assert.Equal(t, resp.MakeErrorData("ERR Invalid or out of range slot"), exec(s, cli, "cluster setslot 99999 stable"))
+	assert.Equal(t, resp.MakeErrorData("ERR syntax error"), exec(s, cli, setSlot+"moving "+dest))
+	assert.Equal(t, resp.MakeErrorData("ERR I don't know about node 127.0.0.1:1"), exec(s, cli, setSlot+"migrating 127.0.0.1:1"))
+	assert.Equal(t, resp.MakeErrorData(fmt.Sprintf("ERR I'm already the owner of hash slot %d", slot)),
+		exec(s, cli, setSlot+"importing "+dest))
+	assert.Equal(t, resp.MakeErrorData(fmt.Sprintf("ERR I'm not the owner of hash slot %d", slot)),
+		exec(target, NewFakeClient(), setSlot+"migrating "+source))
+
+	// 目标节点在设置 importing 之前，只会返回 MOVED
+	targetCli := NewFakeClient()
+	moved := resp.MakeErrorData(fmt.Sprintf("MOVED %d %s", slot, source))
+	assert.Equal(t, moved, exec(target, targetCli, "get k1"))
+
+	assert.Equal(t, resp.MakeStringData("OK"), exec(target, targetCli, setSlot+"importing "+source))
+	assert.Equal(t, resp.MakeStringData("OK"), exec(s, cli, setSlot+"migrating "+dest))
+
+	// 源节点上存在的键正常访问，不存在的键返回 ASK
+	ask := resp.MakeErrorData(fmt.Sprintf("ASK %d %s", slot, dest))
+	assert.Equal(t, resp.MakeBulkData([]byte("v1")), exec(s, cli, "get k1"))
+	assert.Equal(t, ask, exec(s, cli, "get {k1}none"))
+	assert.Equal(t, ask, exec(s, cli, "set {k1}none v"))
+
+	// 目标节点只接受 ASKING 之后的一条命令
+	assert.Equal(t, moved, exec(target, targetCli, "get k1"))
+	assert.Equal(t, resp.MakeStringData("OK"), exec(target, targetCli, "asking"))
+	assert.Equal(t, resp.MakeStringData("nil"), exec(target, targetCli, "get k1"))
+	assert.Equal(t, moved, exec(target, targetCli, "get k1"))
+
+	// 槽中还有键时不能直接转交
+	assert.Equal(t, resp.MakeErrorData(fmt.Sprintf("ERR Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)),
+		exec(s, cli, setSlot+"node "+dest))
+
+	// 后台迁移键，发送期间被修改或删除的键不会在本地删除
+	s.migrateSlots()
+	assert.True(t, s.clusterStatus.migrations[slot].running)
+	exec(s, cli, "set {k1}a v3")
+	exec(s, cli, "del {k1}b")
+	s.clusterStatus.finishSlotMigration(<-s.clusterStatus.migrated)
+
+	// 删除操作需要被传播
+	assert.False(t, s.dbs[0].ExistKey("k1"))
+	assert.Equal(t, ask, exec(s, cli, "get k1"))
+	assert.Empty(t, s.deferred)
+	assert.True(t, s.dbs[0].ExistKey("{k1}a"))
+	assert.True(t, target.dbs[0].ExistKey("{k1}b"))
+
+	// 被修改的键重新迁移，被删除的键在目标节点中删除
+	waitSlotMigrations(s)
+	assert.False(t, s.dbs[0].ExistKey("{k1}a"))
+	assert.False(t, target.dbs[0].ExistKey("{k1}b"))
+
+	value, ok := target.dbs[0].GetKey("{k1}a")
+	assert.True(t, ok)
+	assert.Equal(t, structure.Slice("v3"), value)
+
+	// 槽为空后宣布新的负责节点
+	waitSlotMigrations(s)
+	assert.Equal(t, dest, watcher.announced[slot])
+	assert.Equal(t, resp.MakeErrorData(fmt.Sprintf("MOVED %d %s", slot, dest)), exec(s, cli, "get k1"))
+	assert.Empty(t, s.clusterStatus.migrating)
+
+	// 其他节点通过集群消息更新视图
+	target.clusterStatus.handleClusterChangeMessage(&clusterChangeMessage{EType: MSlotMoved, Slot: slot, Content: dest})
+	assert.Empty(t, target.clusterStatus.importing)
+	assert.Equal(t, resp.MakeBulkData([]byte("v1")), exec(target, targetCli, "get k1"))
+
+	// stable 清除迁移状态
+	other := s.getSlot("k2")
+	assert.Equal(t, resp.MakeStringData("OK"), exec(s, cli, fmt.Sprintf("cluster setslot %d migrating %s", other, dest)))
+	assert.Equal(t, resp.MakeStringData("OK"), exec(s, cli, fmt.Sprintf("cluster setslot %d stable", other)))
+	assert.Empty(t, s.clusterStatus.migrating)
+	assert.Equal(t, resp.MakeStringData("nil"), exec(s, cli, "get k2"))
+}
+
+func TestCmdClusterTopology(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	s := NewServer()
+	cli := NewFakeClient()
+
+	self, other, replica := "127.0.0.1:6380", "127.0.0.1:6381", "127.0.0.1:6382"
+	watcher := makeTestCluster(s, self, other)
+	watcher.epochs = map[string]uint64{self: 1, other: 3}
+
+	c := &s.clusterStatus
+	otherNode := c.nodes[other]
+	replicaNode := newSelfNode(replica)
+	replicaNode.slaveOfNode(otherNode)
+	c.nodes[replica] = replicaNode
+	for i := slotNum / 2; i < slotNum; i++ {
+		c.slots[i] = otherNode
+	}
+	c.migrating[100] = otherNode
+
+	exec := func(input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(s, cli, cmd, nil)
+		return ret
+	}
+
+	id := func(name string) string {
+		return clusterNodeId(name)
+	}
+	slotsNode := func(ip string, port int64, name string) resp.RedisData {
+		return resp.MakeArrayData([]resp.RedisData{
+			resp.MakeBulkData([]byte(ip)), resp.MakeIntData(port), resp.MakeBulkData([]byte(id(name))),
+		})
+	}
+
+	// 与 redis 相同的键槽映射
+	assert.Equal(t, resp.MakeIntData(12739), exec("cluster keyslot 123456789"))
+	assert.Equal(t, resp.MakeIntData(int64(s.getSlot("user1000"))), exec("cluster keyslot {user1000}.following"))
+
+	assert.Equal(t, resp.MakeBulkData([]byte(id(self))), exec("cluster myid"))
+	assert.Equal(t, 40, len(id(self)))
+
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeArrayData([]resp.RedisData{
+			resp.MakeIntData(0), resp.MakeIntData(8191), slotsNode("127.0.0.1", 6380, self),
+		}),
+		resp.MakeArrayData([]resp.RedisData{
+			resp.MakeIntData(8192), resp.MakeIntData(16383), slotsNode("127.0.0.1", 6381, other), slotsNode("127.0.0.1", 6382, replica),
+		}),
+	}), exec("cluster slots"))
+
+	// 从节点输出所属主节点的配置纪元
+	expected := fmt.Sprintf("%s 127.0.0.1:6380@16380 myself,master - 0 0 1 connected 0-8191 [100->-%s]\n", id(self), id(other)) +
+		fmt.Sprintf("%s 127.0.0.1:6381@16381 master - 0 0 3 connected 8192-16383\n", id(other)) +
+		fmt.Sprintf("%s 127.0.0.1:6382@16382 slave %s 0 0 3 connected\n", id(replica), id(other))
+	assert.Equal(t, resp.MakeBulkData([]byte(expected)), exec("cluster nodes"))
+
+	shards, ok := exec("cluster shards").(*resp.ArrayData)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(shards.Data()))
+	assert.Equal(t, resp.MakeMapData([]resp.RedisData{
+		resp.MakeBulkData([]byte("slots")), resp.MakeArrayData([]resp.RedisData{resp.MakeIntData(8192), resp.MakeIntData(16383)}),
+		resp.MakeBulkData([]byte("nodes")), resp.MakeArrayData([]resp.RedisData{
+			resp.MakeMapData([]resp.RedisData{
+				resp.MakeBulkData([]byte("id")), resp.MakeBulkData([]byte(id(other))),
+				resp.MakeBulkData([]byte("port")), resp.MakeIntData(6381),
+				resp.MakeBulkData([]byte("ip")), resp.MakeBulkData([]byte("127.0.0.1")),
+				resp.MakeBulkData([]byte("endpoint")), resp.MakeBulkData([]byte("127.0.0.1")),
+				resp.MakeBulkData([]byte("role")), resp.MakeBulkData([]byte("master")),
+				resp.MakeBulkData([]byte("replication-offset")), resp.MakeIntData(0),
+				resp.MakeBulkData([]byte("health")), resp.MakeBulkData([]byte("online")),
+			}),
+			resp.MakeMapData([]resp.RedisData{
+				resp.MakeBulkData([]byte("id")), resp.MakeBulkData([]byte(id(replica))),
+				resp.MakeBulkData([]byte("port")), resp.MakeIntData(6382),
+				resp.MakeBulkData([]byte("ip")), resp.MakeBulkData([]byte("127.0.0.1")),
+				resp.MakeBulkData([]byte("endpoint")), resp.MakeBulkData([]byte("127.0.0.1")),
+				resp.MakeBulkData([]byte("role")), resp.MakeBulkData([]byte("replica")),
+				resp.MakeBulkData([]byte("replication-offset")), resp.MakeIntData(0),
+				resp.MakeBulkData([]byte("health")), resp.MakeBulkData([]byte("online")),
+			}),
+		}),
+	}), shards.Data()[1])
+
+	exec("set {b}1 v")
+	exec("set {b}2 v")
+	slot := strconv.Itoa(s.getSlot("b"))
+	assert.Equal(t, resp.MakeIntData(2), exec("cluster countkeysinslot "+slot))
+	keys, ok := exec("cluster getkeysinslot " + slot + " 1").(*resp.ArrayData)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(keys.Data()))
+	keys, ok = exec("cluster getkeysinslot " + slot).(*resp.ArrayData)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(keys.Data()))
+
+	assert.Equal(t, resp.MakeErrorData("ERR unknown subcommand or wrong number of arguments for 'none'. Try CLUSTER HELP."), exec("cluster none"))
+}
+
+// failoverWatcher 记录手动故障转移的请求，并且总是成功
+type failoverWatcher struct {
+	slotWatcher
+	takeover []bool
+}
+
+func (w *failoverWatcher) manualFailover(takeover bool) bool {
+	w.takeover = append(w.takeover, takeover)
+	return true
+}
+
+func TestCmdClusterFailover(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	exec := func(server *Server, input string) resp.RedisData {
+		cmd := make([][]byte, 0)
+		for _, arg := range strings.Split(input, " ") {
+			cmd = append(cmd, []byte(arg))
+		}
+		ret, _ := ExecCommand(server, NewFakeClient(), cmd, nil)
+		return ret
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	master := listener.Addr().String()
+	replica := "127.0.0.1:6381"
+	other := "127.0.0.1:6382"
+
+	// 主节点暂停写入，并返回自身的复制 offset
+	m := NewServer()
+	makeTestCluster(m, master, replica)
+	m.clusterStatus.nodes[replica].slaveOfNode(m.clusterStatus.self)
+	m.standAloneToMaster()
+	m.offset = 300
+
+	// 只接受来自从节点或集群节点的请求，fake 客户端没有对端地址
+	assert.Equal(t, resp.MakeErrorData("ERR MFSTART can only be sent by a replica or a cluster node"), exec(m, "cluster mfstart 10000"))
+	assert.False(t, m.paused())
+
+	dialed, err := net.Dial("tcp", master)
+	assert.Nil(t, err)
+	accepted, err := listener.Accept()
+	assert.Nil(t, err)
+	peer := NewClient(accepted)
+	execPeer := func(input string) resp.RedisData {
+		ret, _ := ExecCommand(m, peer, bytes.Split([]byte(input), []byte(" ")), nil)
+		return ret
+	}
+	peer.auth = true
+	assert.Equal(t, resp.MakeErrorData("ERR timeout is not an integer or out of range"), execPeer("cluster mfstart abc"))
+	assert.Equal(t, resp.MakeIntData(300), execPeer("cluster mfstart 10000"))
+	assert.True(t, m.paused())
+	m.pauseEnd = time.Time{}
+	_ = dialed.Close()
+	_ = accepted.Close()
+
+	assert.Equal(t, resp.MakeErrorData("ERR You should send CLUSTER FAILOVER to a replica"), exec(m, "cluster failover"))
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			c := NewClient(conn)
+			c.auth = true
+			for {
+				parsed := c.parser.Parse()
+				if parsed.Err != nil {
+					break
+				}
+				ret, _ := ExecCommand(m, c, parsed.Data.(*resp.ArrayData).ToCommand(), nil)
+				_, _ = conn.Write(ret.ToBytes())
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	// 从节点视图中，other 是另一个 shard 的主节点，同样拥有一个从节点
+	s := NewServer()
+	makeTestCluster(s, replica, master)
+	c := &s.clusterStatus
+	watcher := &failoverWatcher{}
+	c.watcher = watcher
+
+	masterNode := c.nodes[master]
+	c.self.slaveOfNode(masterNode)
+	otherNode, otherReplica := newSelfNode(other), newSelfNode("127.0.0.1:6383")
+	otherReplica.slaveOfNode(otherNode)
+	c.nodes[other], c.nodes[otherReplica.name] = otherNode, otherReplica
+	for i := slotNum / 2; i < slotNum; i++ {
+		c.slots[i] = otherNode
+	}
+	c.slots[0] = masterNode
+
+	s.role = Slave
+	s.runID = "r1"
+	s.offset = 100
+
+	assert.Equal(t, resp.MakeErrorData("ERR syntax error"), exec(s, "cluster failover now"))
+	assert.Equal(t, resp.MakeErrorData("ERR Master is down or failed, please use CLUSTER FAILOVER FORCE"), exec(s, "cluster failover"))
+
+	// 默认模式下需要等待复制追上主节点
+	s.masterAlive = true
+	assert.Equal(t, resp.MakeStringData("OK"), exec(s, "cluster failover"))
+	assert.Equal(t, uint64(300), c.failover.offset)
+
+	c.handleManualFailover()
+	assert.Equal(t, 0, len(watcher.takeover))
+	assert.False(t, c.self.isMaster())
+
+	s.offset = 300
+	c.handleManualFailover()
+	assert.Equal(t, []bool{false}, watcher.takeover)
+
+	// 提升为主节点后保留复制 offset，旧主节点成为自身的从节点，并接管旧主节点负责的槽
+	assert.True(t, c.self.isMaster())
+	assert.Equal(t, c.self, masterNode.slaveOf)
+	assert.Equal(t, c.self, c.slots[0])
+	assert.Equal(t, Master, s.Role())
+	assert.Equal(t, "r1", s.prevRunID)
+	assert.Equal(t, uint64(300), s.offset)
+	assert.True(t, s.canContinue("r1", 300))
+	assert.Equal(t, resp.MakeErrorData("ERR You should send CLUSTER FAILOVER to a replica"), exec(s, "cluster failover"))
+
+	// 其他 shard 的主节点下线，从节点的视图被保留，直到新的主节点当选
+	c.handleClusterChangeMessage(&clusterChangeMessage{EType: MNodeDown, Shard: 1, Content: other})
+	assert.Equal(t, ClusterOK, c.state)
+	assert.Equal(t, []*clusterNode{otherReplica}, otherNode.slaves)
+	assert.Equal(t, otherNode, c.slots[slotNum-1])
+
+	c.handleClusterChangeMessage(&clusterChangeMessage{EType: MNewLeader, Shard: 1, Content: otherReplica.name})
+	assert.True(t, otherReplica.isMaster())
+	assert.Equal(t, otherReplica, otherNode.slaveOf)
+	assert.Equal(t, otherReplica, c.slots[slotNum-1])
+	assert.Equal(t, otherReplica, c.slotMaster(slotNum/2))
+
+	// 旧主节点重新上线后成为新主节点的从节点
+	c.config = clusterConfig{ShardNum: 2, Shards: [][]string{{master, replica}, {other, otherReplica.name}}}
+	c.handleClusterChangeMessage(&clusterChangeMessage{EType: MNodeUp, Shard: 1, Content: other})
+	assert.Equal(t, otherReplica, otherNode.slaveOf)
+	assert.Equal(t, []*clusterNode{otherNode}, otherReplica.slaves)
+
+	// 主节点下线时，从节点通过 takeover 直接成为主节点
+	r := NewServer()
+	makeTestCluster(r, otherReplica.name, other)
+	rc := &r.clusterStatus
+	rc.watcher = &failoverWatcher{}
+	rc.self.slaveOfNode(rc.nodes[other])
+	r.role = Slave
+
+	rc.handleClusterChangeMessage(&clusterChangeMessage{EType: MNodeDown, Shard: 1, Content: other})
+	assert.Equal(t, ClusterDown, rc.state)
+
+	assert.Equal(t, resp.MakeStringData("OK"), exec(r, "cluster failover takeover"))
+	rc.handleClusterEvents()
+	assert.Equal(t, []bool{true}, rc.watcher.(*failoverWatcher).takeover)
+	assert.Equal(t, ClusterOK, rc.state)
+	assert.True(t, rc.self.isMaster())
+	assert.Equal(t, Master, r.Role())
+}
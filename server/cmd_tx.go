@@ -3,7 +3,6 @@ package server
 import (
 	"fmt"
 	"github.com/tangrc99/MemTable/resp"
-	"strconv"
 )
 
 func multi(_ *Server, cli *Client, cmd [][]byte) resp.RedisData {
@@ -34,8 +33,7 @@ func execTX(server *Server, cli *Client, cmds [][]byte) resp.RedisData {
 	}
 
 	defer func() {
-		cli.inTx = false
-		cli.tx = make([][][]byte, 0)
+		cli.ResetTX()
 
 		for dbSeq, keys := range cli.watched {
 			for _, key := range keys {
@@ -45,6 +43,10 @@ func execTX(server *Server, cli *Client, cmds [][]byte) resp.RedisData {
 		cli.ClearWatchers()
 	}()
 
+	if cli.txDirty {
+		return resp.MakeErrorData("EXECABORT Transaction discarded because of previous errors.")
+	}
+
 	if cli.revised {
 
 		return resp.MakeStringData("nil")
@@ -53,31 +55,40 @@ func execTX(server *Server, cli *Client, cmds [][]byte) resp.RedisData {
 	cli.inTx = false
 
 	reses := make([]resp.RedisData, len(cli.tx))
+	block := newTxPropagation()
 
+	// 与 redis 一致，执行期间出错的命令不会影响事务中的其他命令
 	for i, c := range cli.tx {
 
+		dbSeq := cli.dbSeq
+		deferred := len(server.deferred)
+
 		// 执行服务命令
 		res, isWriteCommand := ExecCommand(server, cli, c, nil)
+		reses[i] = res
 
-		// 写命令需要完成aof持久化
-		if isWriteCommand && server.aof != nil {
-
-			if cli.dbSeq != 0 {
-				// 多数据库场景需要加入数据库选择语句
-				dbStr := strconv.Itoa(cli.dbSeq)
-				server.aof.append([]byte(fmt.Sprintf("*2\r\n$6\r\nselect\r\n$%d\r\n%s\r\n", len(dbStr), dbStr)))
+		// 写命令需要完成aof持久化以及主从复制
+		if isWriteCommand && fmt.Sprintf("%T", res) != "*resp.ErrorData" {
+			if rewritten := deterministicCommand(server.dbs[dbSeq], c); rewritten != nil {
+				// 依赖执行时刻的命令需要改写为确定的命令
+				block.append(dbSeq, resp.PlainDataToResp(rewritten).ToBytes())
+			} else if i < len(cli.txRaw) && len(cli.txRaw[i]) > 0 && cli.txRaw[i][0] == '*' {
+				block.append(dbSeq, cli.txRaw[i])
+			} else {
+				// inline 格式的命令需要转换为 RESP 数组
+				block.append(dbSeq, resp.PlainDataToResp(c).ToBytes())
 			}
-			server.aof.append(cli.txRaw[i])
 		}
 
-		reses[i] = res
-
-		if fmt.Sprintf("%T", res) == "*resp.ErrorData" {
-
-			return resp.MakeArrayData(reses[0 : i+1])
+		// 阻塞命令立即完成时会记录等价的非阻塞命令，这些命令属于事务的一部分
+		for _, event := range server.deferred[deferred:] {
+			block.append(event.cli.dbSeq, event.raw)
 		}
+		server.deferred = server.deferred[:deferred]
 	}
 
+	server.propagateTransaction(block)
+
 	return resp.MakeArrayData(reses)
 }
 
@@ -115,8 +126,7 @@ func discard(_ *Server, cli *Client, cmd [][]byte) resp.RedisData {
 		return resp.MakeErrorData("ERR DISCARD without MULTI")
 	}
 
-	cli.inTx = false
-	cli.tx = make([][][]byte, 0)
+	cli.ResetTX()
 	cli.watched = make(map[int][]string)
 	cli.revised = false
 	return resp.MakeStringData("OK")
@@ -0,0 +1,65 @@
+package global
+
+// commandArity 记录命令的参数数量，规则与 redis 一致：正数代表参数数量固定，负数代表参数数量的最小值，参数数量包括命令名本身。
+// 命令函数内部同样会检查参数，该表用于在事务入队时提前发现参数错误
+var commandArity = map[string]int{
+	// 键空间
+	"del": -2, "exists": -2, "keys": 2, "scan": -2, "ttl": 2, "pttl": 2, "expiretime": 2, "pexpiretime": 2,
+	"persist": 2, "expire": -3, "expireat": -3, "pexpire": -3, "pexpireat": -3, "rename": 3, "type": 2,
+	"randomkey": 1, "object": -2, "dump": 2, "restore": -4,
+	"restore-asking": -4,
+
+	// 字符串
+	"set": -3, "setnx": 3, "setex": 4, "psetex": 4, "get": 2, "getset": 3, "getex": -2, "getdel": 2,
+	"strlen": 2, "getrange": 4, "setrange": 4, "mget": -2, "mset": -3, "incr": 2, "incrby": 3, "decr": 2,
+	"decrby": 3, "append": 3, "setbit": 4, "getbit": 3, "bitcount": -2, "bitpos": -3,
+	"pfadd": -2, "pfcount": -2, "pfmerge": -2,
+
+	// 列表
+	"llen": 2, "lpush": -3, "lpop": -2, "rpush": -3, "rpop": -2, "lindex": 3, "lpos": -3, "lset": 4,
+	"lrem": 4, "lrange": 4, "ltrim": 4, "lmove": 5, "lmpop": -4, "blpop": -3, "brpop": -3,
+	"blmove": 6, "brpoplpush": 4, "blmpop": -5,
+
+	// 哈希
+	"hset": -4, "hget": 3, "hexists": 3, "hdel": -3, "hmset": -4, "hmget": -3, "hgetall": 2, "hkeys": 2,
+	"hvals": 2, "hincrby": 4, "hlen": 2, "hstrlen": 3, "hrandfield": -2, "hscan": -3,
+
+	// 集合
+	"sadd": -3, "scard": 2, "sismember": 3, "srem": -3, "smembers": 2, "spop": -2, "srandmember": -2,
+	"smove": 4, "sscan": -3, "sdiff": -2, "sdiffstore": -3, "sinter": -2, "sinterstore": -3, "sunion": -2,
+	"sunionstore": -3,
+
+	// 有序集合
+	"zadd": -4, "zcount": 4, "zcard": 2, "zrem": -3, "zincrby": 4, "zscore": 3, "zrank": -3, "zrevrank": -3,
+	"zremrangebyscore": 4, "zremrangebyrank": 4, "zrange": -4, "zrevrange": -4, "zrangebyscore": -4,
+	"zrevrangebyscore": -4, "zscan": -3, "zpopmin": -2, "zpopmax": -2, "zmpop": -4, "bzpopmin": -3,
+	"bzpopmax": -3, "bzmpop": -5,
+	"geoadd": -5, "geopos": -2, "geodist": -4, "geohash": -2, "geosearch": -7, "geosearchstore": -8,
+
+	// 流
+	"xadd": -5, "xrange": -4, "xrevrange": -4, "xlen": 2, "xtrim": -4, "xdel": -3, "xsetid": -3,
+	"xgroup": -2, "xack": -4, "xpending": -3, "xclaim": -6, "xread": -4, "xreadgroup": -7,
+
+	// 发布订阅
+	"publish": 3, "subscribe": -2, "unsubscribe": -1, "psubscribe": -2, "punsubscribe": -1, "pubsub": -2,
+	"spublish": 3, "ssubscribe": -2, "sunsubscribe": -1,
+
+	// 服务器
+	"auth": -2, "acl": -2, "cluster": -2, "sentinel": -2, "ping": -1, "quit": -1, "select": 2, "monitor": 1, "hello": -1,
+	"sync": 1, "psync": -3, "replconf": -1, "slaveof": 3, "eval": -3, "script": -2, "shutdown": -1,
+	"flushdb": -1, "flushall": -1, "dbsize": 1, "save": 1, "bgsave": -1, "bgrewriteaof": 1, "slowlog": -2,
+	"info": -1, "client": -2, "config": -2, "multi": 1, "exec": 1, "discard": 1, "watch": -2,
+	"memory": -2, "debug": -2, "migrate": -6, "asking": 1,
+}
+
+// CheckArity 判断命令的参数数量是否合法，argc 包括命令名本身，未记录参数数量的命令总是合法的
+func CheckArity(name string, argc int) bool {
+	arity, exist := commandArity[name]
+	if !exist {
+		return true
+	}
+	if arity < 0 {
+		return argc >= -arity
+	}
+	return argc == arity
+}
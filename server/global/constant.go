@@ -14,10 +14,13 @@ const (
 	TECleanClients = 10 * time.Second
 	TEExpireKey    = time.Second
 	TEAOF          = time.Second
+	TEAOFRewrite   = time.Second
 	TEBgSave       = 5 * time.Second
 	TEUpdateStatus = time.Second
 	TEReplica      = 200 * time.Millisecond
 	TECluster      = 200 * time.Millisecond
+	TESentinel     = 100 * time.Millisecond
+	TEBlocked      = 10 * time.Millisecond
 )
 
 const (
@@ -2,6 +2,9 @@ package global
 
 import "time"
 
+// Version 是服务端的版本号，由 main 包在启动时设置，用于 hello 等命令的回复
+var Version = "unknown"
+
 func init() {
 	Now = time.Now()
 }
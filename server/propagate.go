@@ -0,0 +1,151 @@
+package server
+
+import (
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
+	"strconv"
+	"strings"
+)
+
+// deterministicCommand 将执行结果依赖于执行时刻的写命令改写为确定的命令，用于 aof 持久化以及主从复制。
+// 使用相对过期时间的命令会被改写为使用绝对过期时间，如果直接传播原命令，重放时键的过期时间会被延后；
+// XADD 中自动生成的 ID 会被替换为实际写入的 ID；XREADGROUP 中的 BLOCK 参数会被移除。
+// 过期时间与 ID 从数据库中读取，以保证与主节点完全一致。如果命令不需要改写，将返回 nil
+func deterministicCommand(base *db.DataBase, cmd [][]byte) [][]byte {
+
+	if len(cmd) < 3 {
+		return nil
+	}
+
+	key := cmd[1]
+
+	expireAt := func() ([]byte, bool) {
+		tp := base.GetExpireAt(string(key))
+		if tp < 0 {
+			return nil, false
+		}
+		return []byte(strconv.FormatInt(tp, 10)), true
+	}
+
+	switch strings.ToLower(string(cmd[0])) {
+
+	case "expire", "pexpire", "expireat":
+
+		if tp, ok := expireAt(); ok {
+			return [][]byte{[]byte("pexpireat"), key, tp}
+		}
+
+	case "setex", "psetex":
+
+		if len(cmd) != 4 {
+			return nil
+		}
+		if tp, ok := expireAt(); ok {
+			return [][]byte{[]byte("set"), key, cmd[3], []byte("pxat"), tp}
+		}
+
+	case "set":
+
+		for i := 3; i < len(cmd); i++ {
+			switch strings.ToLower(string(cmd[i])) {
+			case "ex", "px", "exat":
+				tp, ok := expireAt()
+				if !ok || i+1 >= len(cmd) {
+					return nil
+				}
+				rewritten := make([][]byte, 0, len(cmd))
+				rewritten = append(rewritten, cmd[:i]...)
+				rewritten = append(rewritten, []byte("pxat"), tp)
+				return append(rewritten, cmd[i+2:]...)
+			}
+		}
+
+	case "getex":
+
+		if strings.ToLower(string(cmd[2])) == "persist" {
+			return [][]byte{[]byte("persist"), key}
+		}
+		if tp, ok := expireAt(); ok {
+			return [][]byte{[]byte("pexpireat"), key, tp}
+		}
+
+	case "restore", "restore-asking":
+
+		if len(cmd) < 4 || string(cmd[2]) == "0" {
+			return nil
+		}
+		for _, opt := range cmd[4:] {
+			if strings.ToLower(string(opt)) == "absttl" {
+				return nil
+			}
+		}
+		if tp, ok := expireAt(); ok {
+			rewritten := make([][]byte, 0, len(cmd)+1)
+			rewritten = append(rewritten, cmd[0], key, tp)
+			rewritten = append(rewritten, cmd[3:]...)
+			return append(rewritten, []byte("absttl"))
+		}
+
+	case "xadd":
+
+		return deterministicXAdd(base, cmd)
+
+	case "xreadgroup":
+
+		for i := 1; i+1 < len(cmd); i++ {
+			switch strings.ToLower(string(cmd[i])) {
+			case "streams":
+				return nil
+			case "block":
+				rewritten := make([][]byte, 0, len(cmd)-2)
+				rewritten = append(rewritten, cmd[:i]...)
+				return append(rewritten, cmd[i+2:]...)
+			}
+		}
+	}
+
+	return nil
+}
+
+// deterministicXAdd 将 XADD 命令中包含 * 的 ID 替换为 stream 中最后写入的 ID
+func deterministicXAdd(base *db.DataBase, cmd [][]byte) [][]byte {
+
+	// 跳过 NOMKSTREAM 与裁剪参数，找到 ID 的位置
+	i := 2
+	for i < len(cmd) {
+		switch strings.ToLower(string(cmd[i])) {
+		case "nomkstream":
+			i++
+			continue
+		case "maxlen", "minid":
+			i += 2
+			if i < len(cmd) && (string(cmd[i-1]) == "=" || string(cmd[i-1]) == "~") {
+				i++
+			}
+			if i+1 < len(cmd) && strings.ToLower(string(cmd[i])) == "limit" {
+				i += 2
+			}
+			continue
+		}
+		break
+	}
+
+	if i >= len(cmd) || !strings.Contains(string(cmd[i]), "*") {
+		return nil
+	}
+
+	value, ok := base.GetKey(string(cmd[1]))
+	if !ok {
+		return nil
+	}
+	stream, ok := value.(*structure.Stream)
+	if !ok {
+		return nil
+	}
+
+	rewritten := make([][]byte, len(cmd))
+	copy(rewritten, cmd)
+	rewritten[i] = []byte(stream.LastID().String())
+
+	return rewritten
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/db"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/server/global"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDeterministicCommand(t *testing.T) {
+
+	global.UpdateGlobalClock()
+
+	base := db.NewDataBase(1)
+	base.SetKeyWithTTL("k", structure.Slice("v"), global.Now.UnixMilli()+10000)
+	base.SetKey("persist", structure.Slice("v"))
+
+	stream := structure.NewStream()
+	stream.Add(structure.StreamID{Ms: 5, Seq: 3}, [][]byte{[]byte("f"), []byte("v")})
+	base.SetKey("s", stream)
+
+	tp := strconv.FormatInt(global.Now.UnixMilli()+10000, 10)
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"expire k 10", "pexpireat k " + tp},
+		{"pexpire k 10000", "pexpireat k " + tp},
+		{"expireat k 10", "pexpireat k " + tp},
+		{"setex k 10 v", "set k v pxat " + tp},
+		{"psetex k 10000 v", "set k v pxat " + tp},
+		{"set k v nx EX 10 get", "set k v nx pxat " + tp + " get"},
+		{"set k v px 10000", "set k v pxat " + tp},
+		{"getex k ex 10", "pexpireat k " + tp},
+		{"getex k persist", "persist k"},
+		{"set k v", ""},
+		{"set k v pxat 10", ""},
+		{"expire persist 10", ""},
+		{"expire none 10", ""},
+		{"del k", ""},
+		{"restore k 10000 payload replace", "restore k " + tp + " payload replace absttl"},
+		{"restore k 0 payload", ""},
+		{"restore k 10 payload ABSTTL", ""},
+		{"restore-asking k 10000 payload", "restore-asking k " + tp + " payload absttl"},
+		{"xadd s * f v", "xadd s 5-3 f v"},
+		{"xadd s 5-* f v", "xadd s 5-3 f v"},
+		{"xadd s NOMKSTREAM MAXLEN ~ 10 LIMIT 5 * f v", "xadd s NOMKSTREAM MAXLEN ~ 10 LIMIT 5 5-3 f v"},
+		{"xadd s minid 1 * f v", "xadd s minid 1 5-3 f v"},
+		{"xadd s 5-3 f v", ""},
+		{"xadd none * f v", ""},
+		{"xreadgroup GROUP g c COUNT 1 BLOCK 0 STREAMS s >", "xreadgroup GROUP g c COUNT 1 STREAMS s >"},
+		{"xreadgroup GROUP g c STREAMS block >", ""},
+	}
+
+	for _, test := range tests {
+		input := make([][]byte, 0)
+		for _, arg := range strings.Split(test.input, " ") {
+			input = append(input, []byte(arg))
+		}
+
+		ret := deterministicCommand(base, input)
+
+		if test.expected == "" {
+			assert.Nil(t, ret, test.input)
+			continue
+		}
+		args := make([]string, len(ret))
+		for i := range ret {
+			args[i] = string(ret[i])
+		}
+		assert.Equal(t, test.expected, strings.Join(args, " "), test.input)
+	}
+}
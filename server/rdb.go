@@ -1,13 +1,16 @@
 package server
 
 import (
-	"github.com/hdt3213/rdb/encoder"
+	"bufio"
+	"fmt"
+	"github.com/tangrc99/MemTable/db"
 	"github.com/tangrc99/MemTable/logger"
+	"github.com/tangrc99/MemTable/server/global"
 	"io"
 	"os"
-	"os/exec"
 	"path"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -20,6 +23,9 @@ type RDBStatus struct {
 	rdbLock       sync.Mutex // 禁止 rdb 重入锁
 	rdbFileStatus int
 	rdbWaitNum    int
+	rdbSnapshot   *snapshotJob // 正在事件循环中生成的 bgsave 快照
+	rdbDirty      int          // bgsave 开始时的脏数据计数
+	rdbDone       chan error   // 后台协程完成写入后通知事件循环
 }
 
 func (s *Server) RDB(file string) bool {
@@ -39,110 +45,149 @@ func (s *Server) RDB(file string) bool {
 	}
 
 	defer rdbFile.Close()
-	enc := encoder.NewEncoder(rdbFile).EnableCompress()
-	err = enc.WriteHeader()
-	if err != nil {
-		logger.Error("RDB: write RDB Header Failed", err.Error())
+
+	if err = s.encodeRDB(rdbFile); err != nil {
 		return false
 	}
-	auxMap := map[string]string{
+
+	_ = os.Rename(file+".tmp", file)
+
+	s.dirty = 0
+	s.checkPoint = global.Now.Unix()
+
+	return true
+}
+
+// encodeRDB 将所有数据库以 rdb 格式写入到 writer 中，该函数必须在事件循环中调用
+func (s *Server) encodeRDB(writer io.Writer) error {
+
+	rdbWriter := db.NewRDBWriter()
+
+	var snapErr error
+	s.beginSnapshot(rdbWriter, func(err error) {
+		snapErr = err
+	}).complete()
+
+	if snapErr != nil {
+		logger.Error("RDB: write RDB DB Content Failed", snapErr.Error())
+		return snapErr
+	}
+
+	if err := rdbWriter.Save(writer, s.rdbAux()); err != nil {
+		logger.Error("RDB: write RDB Failed", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// rdbAux 返回 rdb 文件中需要写入的辅助字段
+func (s *Server) rdbAux() map[string]string {
+	logger.Info("rdb runid", s.runID)
+	return map[string]string{
 		"redis-ver":    "4.0.6",
 		"redis-bits":   "64",
 		"aof-preamble": "0",
 		"repl-id":      s.runID,
 		"repl-offset":  strconv.FormatUint(s.offset, 10),
 	}
-	logger.Info("rdb runid", s.runID)
+}
 
-	for k, v := range auxMap {
-		err = enc.WriteAux(k, v)
-		if err != nil {
-			logger.Error("RDB: write RDB Aux Failed", err.Error())
-			return false
-		}
+// BGRDB 会在后台生成 rdb 文件，如果已经有 rdb 正在生成，将返回 false。
+// 数据库快照会在事件循环中增量地序列化到内存中，快照是开始时刻的一致视图，硬盘写入则由后台协程完成。
+// 该函数必须在事件循环中调用。
+func (s *Server) BGRDB() bool {
+
+	if !s.rdbLock.TryLock() {
+		logger.Warning("RDB: Try Do RDB When Another RDB Process Executing")
+		return false
 	}
 
-	for index, db := range s.dbs {
+	// 快照对应的辅助字段以及复制偏移量
+	aux := s.rdbAux()
+	s.rdbOffset = s.offset
+	s.rdbDirty = s.dirty
+	s.rdbDone = make(chan error, 1)
 
-		if db.Size() == 0 {
-			continue
-		}
+	file := path.Join(s.dir, s.rdbFile)
+	rdbWriter := db.NewRDBWriter()
+
+	s.rdbSnapshot = s.beginSnapshot(rdbWriter, func(err error) {
+
+		s.rdbSnapshot = nil
 
-		err = enc.WriteDBHeader(uint(index), uint64(db.Size()), uint64(db.TTLSize()))
-		if err != nil {
-			logger.Error("RDB: write RDB DB Header Failed", err.Error())
-			return false
-		}
-		err = db.Encode(enc)
 		if err != nil {
-			logger.Error("RDB: write RDB DB Content Failed", err.Error())
-			return false
+			s.rdbLock.Unlock()
+			logger.Error("BGSave Failed", err.Error())
+			return
 		}
-	}
 
-	err = enc.WriteEnd()
-	if err != nil {
-		logger.Error("RDB: write RDB End Failed", err.Error())
-		return false
-	}
+		go func() {
 
-	_ = os.Rename(file+".tmp", file)
+			defer s.rdbLock.Unlock()
+
+			// 需要在释放锁之前通知，保证等待锁的事件循环能够取得结果
+			s.rdbDone <- writeRDBFile(file, rdbWriter, aux)
+		}()
+	})
 
 	return true
 }
 
-// BGRDB 必须借助于 AOF 才能够实现，具体过程是复制一份 aof，然后开启一个 fake server 进行持久化
-func (s *Server) BGRDB() bool {
-
-	if !s.aofEnabled {
-		logger.Error("Can't start bgsave if aof is not enabled")
-		return false
-	}
+// finishRDB 在事件循环中处理 bgsave 的结果，只有写入成功时才会扣除快照包含的脏数据并更新检查点
+func (s *Server) finishRDB(err error) {
 
-	// 复制 aof
-	file1, err := os.Open(path.Join(s.dir, s.aofFile))
-	if err != nil {
-		logger.Error("AOF Rewrite: Can't Load AOF File")
-		return false
-	}
-	file2, err := os.OpenFile(path.Join(s.dir, s.aofFile+".tmp"), os.O_WRONLY|os.O_CREATE, os.ModePerm)
 	if err != nil {
-		logger.Error("AOF Rewrite: Can't Create Temporary AOF File")
-		return false
+		logger.Error("BGSave Failed", err.Error())
+		return
 	}
 
-	defer file1.Close()
-	defer file2.Close()
-
-	_, err = io.Copy(file2, file1)
-	if err != nil {
-		logger.Error("AOF Rewrite: AOF Copy Error")
-		return false
-	}
+	s.dirty -= s.rdbDirty
+	s.checkPoint = global.Now.Unix()
 
-	// aof 启动一个 server
-	ws := NewServer()
-	ws.runID = s.runID
-	ws.offset = s.offset
-	ws.rdbOffset = s.rdbOffset
+	logger.Info("BGSave Finished")
+}
 
-	go func() {
+// checkRDB 检查后台的 rdb 文件写入是否完成
+func (s *Server) checkRDB() {
+	select {
+	case err := <-s.rdbDone:
+		s.finishRDB(err)
+	default:
+	}
+}
 
-		ws.recoverFromAOF(path.Join(s.dir, s.aofFile+".tmp"))
-		// server 进行恢复后，保存 rdb
-		ok := ws.RDB(path.Join(s.dir, s.rdbFile))
+// writeRDBFile 将快照写入临时文件并刷盘，随后原子地替换 file
+func writeRDBFile(file string, rdbWriter *db.RDBWriter, aux map[string]string) error {
 
-		logger.Info("BGSave Finished")
+	rdbFile, err := os.Create(file + ".tmp")
+	if err != nil {
+		return err
+	}
 
-		if !ok {
-			logger.Error("BGSave Failed")
+	buffered := bufio.NewWriter(rdbFile)
+	if err = rdbWriter.Save(buffered, aux); err == nil {
+		if err = buffered.Flush(); err == nil {
+			err = rdbFile.Sync()
 		}
+	}
+	_ = rdbFile.Close()
 
-		// 清理文件
-		_ = os.Remove(s.aofFile + ".tmp")
+	if err != nil {
+		_ = os.Remove(file + ".tmp")
+		return err
+	}
 
-	}()
-	return true
+	return os.Rename(file+".tmp", file)
+}
+
+// completeRDB 在事件循环中完成正在生成的 rdb 快照并等待文件写入完成，用于不能继续等待事件循环的场景
+func (s *Server) completeRDB() {
+	if s.rdbSnapshot != nil {
+		s.rdbSnapshot.complete()
+	}
+	s.waitForRDBFinished()
+	s.checkRDB()
 }
 
 func (s *Server) waitForRDBFinished() {
@@ -151,32 +196,90 @@ func (s *Server) waitForRDBFinished() {
 
 }
 
+// recoverFromRDB 将 rdb 文件中的数据直接加载到数据库中。开启 aof 时，旧的 aof 文件已经不能代表当前的数据集，
+// 加载完成后会使用当前的数据集重写 aof 文件
 func (s *Server) recoverFromRDB(aofFile, rdbFile string) {
 
-	_, err := os.Stat("rdb")
-	if err != nil {
-		logger.Error("Executable 'rdb' not exists")
-		logger.Error("Recover from RDB file needs third-party 'github.com/hdt3213/rdb'")
+	if err := s.loadRDBFile(rdbFile); err != nil {
+		logger.Error("Load RDB:", err.Error())
 		return
 	}
 
-	arg := []string{"-c", "protocol", "-f", aofFile, rdbFile}
-	cmd := exec.Command("rdb", arg...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		logger.Error("Read RDB:", err.Error())
+	if !s.aofEnabled {
 		return
 	}
 
-	if cmd.ProcessState.ExitCode() != 0 {
-		logger.Error("Load RDB:", string(output))
-		return
+	if err := s.rewriteAOFFile(aofFile); err != nil {
+		logger.Error("Load RDB: Rewrite AOF Failed,", err.Error())
 	}
+}
 
-	s.recoverFromAOF(aofFile)
+// loadRDBFile 将 rdb 文件中的键值对写入到数据库中，rdb 格式不支持的键值对通过辅助字段中的 aof 命令恢复
+func (s *Server) loadRDBFile(rdbFile string) error {
 
-	if !s.aofEnabled {
-		// 删除 aof 文件
-		_ = os.Remove(aofFile)
+	reader, err := os.Open(rdbFile)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return db.LoadRDB(bufio.NewReader(reader), func(dbSeq int, key string, value db.Object, expireAt int64) error {
+
+		if dbSeq >= len(s.dbs) {
+			return fmt.Errorf("DB index %d is out of range", dbSeq)
+		}
+		if expireAt > 0 {
+			s.dbs[dbSeq].SetKeyWithTTL(key, value, expireAt)
+		} else {
+			s.dbs[dbSeq].SetKey(key, value)
+		}
+		return nil
+
+	}, func(commands string) error {
+		s.loadAOF(strings.NewReader(commands))
+		return nil
+	})
+}
+
+// rewriteAOFFile 在事件循环中使用当前的数据集重写 aof 文件，正在进行的后台重写会先完成。
+// aof 缓冲区会切换到新的文件上，之后追加的命令不会写入到被替换的旧文件中
+func (s *Server) rewriteAOFFile(filename string) error {
+
+	if s.aofRewriting {
+		s.completeSnapshots()
+		s.finishAOFRewrite(<-s.aofRewriteDone)
+	}
+
+	tmp := path.Join(s.dir, fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	buffered := bufio.NewWriter(file)
+	for i, dataBase := range s.dbs {
+		if err = dataBase.RewriteAOF(buffered, i); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+
+	if err == nil {
+		if s.aof != nil {
+			if err = s.aof.swapFile(file, filename); err == nil {
+				s.aofBaseSize = s.aof.size
+			}
+		} else if err = file.Sync(); err == nil {
+			err = os.Rename(tmp, filename)
+			_ = file.Close()
+		}
+	}
+
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(tmp)
 	}
+	return err
 }
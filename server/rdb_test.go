@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/db/structure"
+	"github.com/tangrc99/MemTable/logger"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+// TestBGRDB 测试未开启 aof 时能否生成 rdb 文件，快照是开始生成时刻的一致视图，不包含开始生成后写入的数据
+func TestBGRDB(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	s := NewServer()
+	s.dir = t.TempDir()
+	s.aofEnabled = false
+
+	cli := NewFakeClient()
+
+	exec := func(args ...string) {
+		cmd := make([][]byte, len(args))
+		for i := range args {
+			cmd[i] = []byte(args[i])
+		}
+		_, _ = ExecCommand(s, cli, cmd, nil)
+	}
+
+	exec("set", "str", "v")
+	exec("rpush", "list", "a", "b")
+	exec("sadd", "set", "a", "b")
+	exec("zadd", "zset", "1", "a")
+	exec("hset", "hash", "f", "v")
+	exec("pfadd", "hll", "a", "b")
+	exec("set", "ttl", "v")
+	exec("expire", "ttl", "100")
+	exec("bf.add", "bloom", "a")
+	for i := 0; i < 1000; i++ {
+		exec("set", "key"+strconv.Itoa(i), "v")
+	}
+	s.dirty = 8
+
+	s.checkPoint = 0
+	assert.True(t, s.BGRDB())
+
+	// 写入完成之前不会重置脏数据计数
+	assert.Equal(t, 8, s.dirty)
+	assert.Equal(t, int64(0), s.checkPoint)
+
+	// 快照在事件循环中增量生成，生成过程中的修改不应该出现在 rdb 文件中
+	s.handleSnapshots()
+	assert.NotNil(t, s.rdbSnapshot)
+	exec("set", "after", "v")
+	exec("set", "str", "changed")
+	exec("rpush", "list", "c")
+	exec("del", "hash")
+	exec("bf.add", "bloom", "b")
+	for i := 0; i < 1000; i++ {
+		exec("del", "key"+strconv.Itoa(i))
+	}
+	s.dirty += 5
+
+	for s.rdbSnapshot != nil {
+		s.handleSnapshots()
+	}
+	s.completeRDB()
+
+	// 快照开始之后的修改仍然是脏数据
+	assert.Equal(t, 5, s.dirty)
+	assert.Equal(t, global.Now.Unix(), s.checkPoint)
+
+	rdbFile := path.Join(s.dir, s.rdbFile)
+
+	keys := make(map[string]string)
+	var hll, str []byte
+	var list [][]byte
+	err := core.NewDecoder(bytes.NewReader(readFile(t, rdbFile))).Parse(func(object model.RedisObject) bool {
+		keys[object.GetKey()] = object.GetType()
+		switch object.GetKey() {
+		case "hll":
+			hll = object.(*model.StringObject).Value
+		case "str":
+			str = object.(*model.StringObject).Value
+		case "list":
+			list = object.(*model.ListObject).Values
+		}
+		return true
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1007, len(keys))
+	for key, typ := range map[string]string{
+		"str":  model.StringType,
+		"list": model.ListType,
+		"set":  model.SetType,
+		"zset": model.ZSetType,
+		"hash": model.HashType,
+		"hll":  model.StringType,
+		"ttl":  model.StringType,
+		"key0": model.StringType,
+	} {
+		assert.Equal(t, typ, keys[key], key)
+	}
+	assert.Equal(t, []byte("v"), str)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, list)
+
+	// HyperLogLog 使用 redis 兼容的字符串编码
+	value, _ := s.dbs[0].GetKey("hll")
+	assert.Equal(t, []byte(value.(structure.Slice)), hll)
+
+	// bloom filter 通过辅助字段保存
+	recovered := NewServer()
+	recovered.aofEnabled = false
+	recovered.recoverFromRDB(path.Join(s.dir, s.aofFile), rdbFile)
+
+	assert.Equal(t, 1008, recovered.dbs[0].Size())
+	assert.False(t, recovered.dbs[0].ExistKey("after"))
+	value, ok := recovered.dbs[0].GetKey("bloom")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value.(*structure.Bloom).Items())
+}
+
+// TestRDBRecover 测试 rdb 文件能否恢复出相同的数据，rdb 格式不支持的 stream 通过辅助字段保存
+func TestRDBRecover(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	s := NewServer()
+	s.dir = t.TempDir()
+	s.aofEnabled = false
+
+	cli := NewFakeClient()
+
+	exec := func(args ...string) {
+		cmd := make([][]byte, len(args))
+		for i := range args {
+			cmd[i] = []byte(args[i])
+		}
+		_, _ = ExecCommand(s, cli, cmd, nil)
+	}
+
+	exec("set", "str", "v")
+	exec("rpush", "list", "a", "b")
+	exec("hset", "hash", "f", "v")
+	// 字段较多的 hash 会扩容为多个分片
+	for i := 0; i < 100; i++ {
+		exec("hset", "bighash", "f"+strconv.Itoa(i), "v")
+	}
+	exec("xadd", "stream", "1-1", "f", "v")
+	exec("xadd", "stream", "2-1", "f", "v")
+	exec("xgroup", "create", "stream", "g", "0")
+	exec("xreadgroup", "group", "g", "c1", "count", "1", "streams", "stream", ">")
+	exec("xadd", "ttlstream", "1-1", "f", "v")
+	exec("expire", "ttlstream", "100")
+	exec("select", "1")
+	exec("xadd", "db1", "1-1", "f", "v")
+	exec("select", "0")
+
+	rdbFile := path.Join(s.dir, s.rdbFile)
+	assert.True(t, s.RDB(rdbFile))
+
+	// 辅助字段对于其他程序是透明的，rdb 文件仍然可以被正常解析
+	keys := make(map[string]string)
+	err := core.NewDecoder(bytes.NewReader(readFile(t, rdbFile))).Parse(func(object model.RedisObject) bool {
+		keys[object.GetKey()] = object.GetType()
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"str": model.StringType, "list": model.ListType, "hash": model.HashType, "bighash": model.HashType,
+	}, keys)
+
+	recovered := NewServer()
+	recovered.aofEnabled = false
+	recovered.recoverFromRDB(path.Join(s.dir, s.aofFile), rdbFile)
+
+	for i := range s.dbs {
+		assert.Equal(t, s.dbs[i].Size(), recovered.dbs[i].Size())
+		assert.Equal(t, s.dbs[i].TTLSize(), recovered.dbs[i].TTLSize())
+	}
+	assert.True(t, recovered.dbs[1].ExistKey("db1"))
+
+	value, ok := recovered.dbs[0].GetKey("bighash")
+	assert.True(t, ok)
+	assert.Equal(t, 100, value.(*structure.Dict).Size())
+
+	value, ok = recovered.dbs[0].GetKey("stream")
+	assert.True(t, ok)
+	stream := value.(*structure.Stream)
+	assert.Equal(t, 2, stream.Len())
+	group, ok := stream.Group("g")
+	assert.True(t, ok)
+	assert.Equal(t, 1, group.PendingSize())
+
+	_, err = os.Stat(path.Join(s.dir, s.aofFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func readFile(t *testing.T, name string) []byte {
+	content, err := os.ReadFile(name)
+	assert.Nil(t, err)
+	return content
+}
+
+// TestRDBRecoverWithAOF 测试开启 aof 时，从 rdb 恢复后 aof 文件被重写为当前的数据集，之后的写入不会丢失
+func TestRDBRecoverWithAOF(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+
+	s := NewServer()
+	s.dir = t.TempDir()
+	s.aofEnabled = false
+
+	cli := NewFakeClient()
+	exec := func(s *Server, args ...string) {
+		cmd := make([][]byte, len(args))
+		for i := range args {
+			cmd[i] = []byte(args[i])
+		}
+		raw := resp.PlainDataToResp(cmd).ToBytes()
+		_, isWrite := ExecCommand(s, cli, cmd, raw)
+		if isWrite {
+			s.appendAOF(&Event{cmd: cmd, raw: raw, cli: cli})
+		}
+	}
+
+	exec(s, "set", "str", "v")
+	exec(s, "xadd", "stream", "1-1", "f", "v")
+	rdbFile := path.Join(s.dir, s.rdbFile)
+	assert.True(t, s.RDB(rdbFile))
+
+	// 从节点已经打开了旧的 aof 文件
+	replica := NewServer()
+	replica.dir = s.dir
+	replica.aofEnabled = true
+	aofFile := path.Join(replica.dir, replica.aofFile)
+	replica.aof = newAOFBuffer(aofFile)
+	exec(replica, "set", "stale", "v")
+
+	replica.recoverFromRDB(aofFile, rdbFile)
+	assert.True(t, replica.dbs[0].ExistKey("str"))
+	assert.True(t, replica.dbs[0].ExistKey("stream"))
+
+	exec(replica, "set", "after", "v")
+	replica.aof.quit()
+
+	recovered := NewServer()
+	recovered.recoverFromAOF(aofFile)
+	for _, key := range []string{"str", "stream", "stale", "after"} {
+		assert.True(t, recovered.dbs[0].ExistKey(key), key)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"github.com/tangrc99/MemTable/config"
+	"github.com/tangrc99/MemTable/db"
 	"github.com/tangrc99/MemTable/logger"
 	"github.com/tangrc99/MemTable/server/acl"
 	"net"
@@ -77,8 +78,11 @@ func (s *Server) reloadConfig(fields []string) {
 			logger.Infof("Listen tls-url change to %s", s.tlsUrl)
 			go s.acceptLoop(s.tlsListener)
 
-		case "LogDir", "LogLevel":
-			err := logger.ChangeConfig(config.Conf.LogDir, "bin.log", logger.StringToLogLevel(config.Conf.Dir))
+		case "LogLevel":
+			logger.SetLevel(logger.StringToLogLevel(config.Conf.LogLevel))
+
+		case "LogDir":
+			err := logger.ChangeConfig(config.Conf.LogDir, "bin.log", logger.StringToLogLevel(config.Conf.LogLevel))
 			if err != nil {
 				logger.Errorf("Err change config %s", err.Error())
 			}
@@ -98,14 +102,17 @@ func (s *Server) reloadConfig(fields []string) {
 			s.maxClients = config.Conf.MaxClients
 
 		case "MaxMemory":
-			// nothing to do
+			s.sts.maxMemory = config.Conf.MaxMemory
 
 		case "AppendFsync":
-			// nothing to do
+			if s.aof != nil {
+				s.aof.setFsync(config.Conf.AppendFsync)
+			}
 
 		case "AppendOnly":
 			if !s.aofEnabled {
 				s.aof = newAOFBuffer(config.Conf.Dir + "appendonly.aof")
+				s.aof.setFsync(config.Conf.AppendFsync)
 			}
 			s.aofEnabled = config.Conf.AppendOnly
 
@@ -119,16 +126,36 @@ func (s *Server) reloadConfig(fields []string) {
 		case "ClusterName":
 			logger.Error("Thermal renew 'cluster_name' is not allowed")
 
+		case "ClusterBus", "ClusterConfigFile", "ClusterNodeTimeout", "Sentinel", "SentinelMasters":
+			logger.Errorf("Thermal renew '%s' is not allowed", fields[i])
+
 		case "Eviction":
-			logger.Error("Thermal renew 'eviction' is not allowed")
+			policy, ok := db.ParseEvictPolicy(config.Conf.Eviction)
+			if !ok {
+				logger.Errorf("Err change config invalid eviction %s", config.Conf.Eviction)
+				continue
+			}
+			for _, dataBase := range s.dbs {
+				dataBase.SetEvictPolicy(policy)
+			}
 
 		case "SlowLogMaxLen":
-			logger.Error("Thermal renew 'slowlog-max-len' is not allowed")
+			s.slowlog.resize(config.Conf.SlowLogMaxLen)
 
 		case "SlowLogSlowerThan":
 
 		case "ACLFile":
 			s.acl = acl.NewAccessControlList(config.Conf.ACLFile)
+
+		case "NotifyKeyspaceEvents":
+			flags, err := db.ParseKeyspaceEvents(config.Conf.NotifyKeyspaceEvents)
+			if err != nil {
+				logger.Errorf("Err change config %s", err.Error())
+				continue
+			}
+			for _, dataBase := range s.dbs {
+				dataBase.SetKeyspaceEvents(flags)
+			}
 		default:
 			logger.Errorf("Thermal renew update unknown field %s", fields[i])
 		}
@@ -6,6 +6,7 @@ import (
 	"github.com/tangrc99/MemTable/server/global"
 	"github.com/tangrc99/MemTable/utils/rand_str"
 	"github.com/tangrc99/MemTable/utils/ring_buffer"
+	"net"
 	"strconv"
 )
 
@@ -21,6 +22,8 @@ type ReplicaStatus struct {
 	offset     uint64
 	rdbOffset  uint64 // 生成 rdb 时的 offset
 	runID      string // 集群 id
+	prevRunID  string // 故障转移前主节点的复制 id，旧主节点的从节点可以使用该 id 继续增量复制
+	prevOffset uint64 // 故障转移时的 offset，只有不超过该 offset 的从节点可以使用 prevRunID 继续增量复制
 	backLog    ring_buffer.RingBuffer
 	idleTicker uint64 // 记录空闲时间的逻辑时钟
 	// Master 需要的
@@ -48,11 +51,6 @@ func (s *ReplicaStatus) updateReplicaStatus(event *Event) {
 
 		s.idleTicker++
 		s.appendBackLog(event)
-
-	case Slave:
-		if event.cli == s.Master {
-			s.offset += uint64(len(event.raw))
-		}
 	}
 }
 
@@ -124,6 +122,51 @@ func (s *ReplicaStatus) standAloneToMaster() {
 
 }
 
+// slaveToMaster 在故障转移中将从节点提升为主节点。与 standAloneToMaster 不同，offset 会被保留，
+// 旧的复制 id 会被记录为 prevRunID，同一个 shard 中的其他从节点可以通过 psync 继续增量复制
+func (s *ReplicaStatus) slaveToMaster() {
+	if s.Master != nil {
+		_ = s.Master.cnn.Close()
+		s.Master = nil
+	}
+	s.masterAlive = false
+
+	s.role = Master
+	s.prevRunID = s.runID
+	s.prevOffset = s.offset
+	s.runID = rand_str.RandHexString(40)
+	s.backLog.Init(global.RsBackLogCap)
+	s.backLog.Reset(s.offset)
+	s.capacity = global.RsBackLogCap
+	s.rdbOffset = 0
+	s.onLineSlaves = make(map[*Client]struct{})
+	s.offLineSlaves = make(map[*Client]struct{})
+	s.initSlaves = make(map[*Client]struct{})
+
+	logger.Info("Node is promoted to Master, offset:", s.offset)
+}
+
+// masterToStandAlone 断开与所有从节点的连接，用于主节点在故障转移后被降级
+func (s *ReplicaStatus) masterToStandAlone() {
+	s.role = StandAlone
+	for _, slaves := range []map[*Client]struct{}{s.initSlaves, s.onLineSlaves, s.offLineSlaves} {
+		for cli := range slaves {
+			_ = cli.cnn.Close()
+		}
+	}
+	s.onLineSlaves = make(map[*Client]struct{})
+	s.offLineSlaves = make(map[*Client]struct{})
+	s.initSlaves = make(map[*Client]struct{})
+}
+
+// canContinue 判断从节点是否可以从 offset 处继续增量复制
+func (s *ReplicaStatus) canContinue(replID string, offset int64) bool {
+	if offset < int64(s.minOffset()) || offset > int64(s.offset) {
+		return false
+	}
+	return replID == s.runID || (s.prevRunID != "" && replID == s.prevRunID && offset <= int64(s.prevOffset))
+}
+
 func (s *ReplicaStatus) sendBackLog() {
 
 	if s.role != Master {
@@ -209,15 +252,12 @@ func (s *Server) rdbForReplica() uint64 {
 		return s.rdbOffset
 	}
 
-	ok := s.BGRDB()
-
-	// 如果正在复制，需要等待
-	if !ok {
-		s.waitForRDBFinished()
+	// 如果正在生成的 rdb 已经无法使用，需要等待完成后重新生成
+	if !s.BGRDB() {
+		s.completeRDB()
+		s.BGRDB()
 	}
 
-	s.rdbOffset = s.offset
-
 	return s.rdbOffset
 
 }
@@ -244,10 +284,25 @@ func (s *ReplicaStatus) sendOffsetToMaster() {
 	_, _ = s.Master.cnn.Write([]byte(replconfCMD))
 }
 
+// sendListeningPortToMaster 告知主节点自身的服务端口，主节点在 info replication 中展示该端口，使得哨兵可以发现从节点
+func (s *Server) sendListeningPortToMaster() {
+
+	_, port, err := net.SplitHostPort(s.url)
+	if err != nil || s.Master == nil {
+		return
+	}
+
+	replconfCMD := fmt.Sprintf("*3\r\n$8\r\nreplconf\r\n$14\r\nlistening-port\r\n$%d\r\n%s\r\n", len(port), port)
+
+	_, _ = s.Master.cnn.Write([]byte(replconfCMD))
+}
+
 func (s *ReplicaStatus) slaveToStandAlone() {
 	s.role = StandAlone
 	s.masterAlive = false
-	_ = s.Master.cnn.Close()
+	if s.Master != nil {
+		_ = s.Master.cnn.Close()
+	}
 	s.Master = nil
 }
 
@@ -259,8 +314,10 @@ const (
 )
 
 type SlaveStatus struct {
-	slaveStatus int
-	offset      uint64
+	slaveStatus   int
+	offset        uint64 // 主节点向从节点发送 backlog 的位置
+	ackOffset     uint64 // 从节点通过 replconf ack 确认的 offset
+	listeningPort int    // 从节点的服务端口，由 replconf listening-port 告知
 }
 
 func (s *Server) StartEvictionNotification() {
@@ -293,7 +350,9 @@ func (s *Server) handleEvictionNotification() {
 				s.appendBackLogRaw([]byte(oplog))
 
 				// AOF 文件的过期同样也是使用这种方式来完成的
-				s.aof.append([]byte(oplog))
+				if s.aofEnabled && s.aof != nil {
+					s.aof.append([]byte(oplog))
+				}
 
 			default:
 				finished = true
@@ -1,9 +1,11 @@
 package server
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/tangrc99/MemTable/logger"
 	"github.com/tangrc99/MemTable/resp"
+	"io"
 	"net"
 	"os"
 	"path"
@@ -97,6 +99,7 @@ func (s *Server) sendSyncToMaster(url string) bool {
 				if err != nil {
 					logger.Error("syncToDisk: write RDBFile Failed", err.Error())
 				}
+				parsedPos += rdbSize
 
 				// 这里需要设置 repl-id 和 repl-offset 吗
 				break
@@ -115,10 +118,12 @@ func (s *Server) sendSyncToMaster(url string) bool {
 
 	//fixme
 	s.standAloneToSlave(client, s.runID, s.offset)
+	s.sendListeningPortToMaster()
 	// 关闭所有删除通知
 	s.StopEvictionNotification()
 
-	go s.waitMasterNotification(client)
+	// 与握手回复一同到达的命令流需要交给解析器继续处理
+	go s.waitMasterNotification(client, handshakeBuff[parsedPos:rdLen])
 
 	return true
 }
@@ -149,7 +154,6 @@ func (s *Server) sendPSyncToMaster(url string) bool {
 		PING = iota
 		PSYNC
 		FULLSYNC
-		CONTINUE
 	)
 	shakeStatus := PING
 
@@ -206,9 +210,20 @@ func (s *Server) sendPSyncToMaster(url string) bool {
 					parsedPos = parsedPos + 11
 					shakeStatus = FULLSYNC
 
-				} else if rdLen >= parsedPos+9 && strings.ToUpper(string(handshakeBuff[parsedPos+1:parsedPos+19])) == "CONTINUE" {
-					parsedPos = parsedPos + 9
-					shakeStatus = CONTINUE
+				} else if rdLen >= parsedPos+9 && strings.ToUpper(string(handshakeBuff[parsedPos+1:parsedPos+9])) == "CONTINUE" {
+
+					// +CONTINUE <replid>\r\n，主节点的复制 id 可能在故障转移后发生变化
+					end := bytes.Index(handshakeBuff[parsedPos:rdLen], []byte("\r\n"))
+					if end < 0 {
+						continue
+					}
+					if replID := strings.TrimSpace(string(handshakeBuff[parsedPos+9 : parsedPos+end])); replID != "" {
+						s.runID = replID
+					}
+					logger.Info("PSync: Continue Replication From Offset", s.offset)
+					parsedPos += end + 2
+					break
+
 				} else if rdLen >= parsedPos+11 {
 					logger.Error("PSync: Master Don't Understand PSync With Wrong Reply", string(handshakeBuff[parsedPos:rdLen]))
 					return false
@@ -221,12 +236,12 @@ func (s *Server) sendPSyncToMaster(url string) bool {
 
 		} else if shakeStatus == FULLSYNC {
 
-			if parsedPos == ' ' {
+			if handshakeBuff[parsedPos] == ' ' {
 				parsedPos++
 			}
 			s.runID = string(handshakeBuff[parsedPos : parsedPos+40])
 
-			parsedPos += 42
+			parsedPos += 41
 
 			replOffsetStr := ""
 			for handshakeBuff[parsedPos] != '\r' {
@@ -268,31 +283,12 @@ func (s *Server) sendPSyncToMaster(url string) bool {
 				// 从 rdb 中恢复
 				s.recoverFromRDB(path.Join(s.dir, s.aofFile), path.Join(s.dir, "received.rdb"))
 				_ = os.Rename(path.Join(s.dir, "received.rdb"), path.Join(s.dir, s.rdbFile))
+				parsedPos += rdbSize
 
 				// 这里需要设置 repl-id 和 repl-offset 吗
 				break
 			}
 
-		} else if shakeStatus == CONTINUE {
-			if parsedPos == ' ' {
-				parsedPos++
-			}
-			s.runID = string(handshakeBuff[parsedPos : parsedPos+40])
-
-			parsedPos += 42
-
-			replOffsetStr := ""
-			for handshakeBuff[parsedPos] != '\r' {
-				replOffsetStr += string(handshakeBuff[parsedPos])
-				parsedPos++
-			}
-			replOffset, err := strconv.Atoi(replOffsetStr)
-			if err != nil {
-				logger.Error("PSync: Invalid ReplOffset", replOffsetStr)
-				return false
-			}
-			s.offset = uint64(replOffset)
-			parsedPos += 2
 		}
 
 	}
@@ -302,17 +298,20 @@ func (s *Server) sendPSyncToMaster(url string) bool {
 
 	//fixme
 	s.standAloneToSlave(client, s.runID, s.offset)
+	s.sendListeningPortToMaster()
 	s.StopEvictionNotification()
 
-	go s.waitMasterNotification(client)
+	// 与握手回复一同到达的命令流需要交给解析器继续处理
+	go s.waitMasterNotification(client, handshakeBuff[parsedPos:rdLen])
 
 	return true
 }
 
-func (s *Server) waitMasterNotification(client *Client) {
+// waitMasterNotification 接收并执行主节点传播的命令，pending 是握手阶段已经读取但尚未解析的数据
+func (s *Server) waitMasterNotification(client *Client, pending []byte) {
 	logger.Info("Replica: syncToDisk Finished with success")
 
-	parser := resp.NewParser(client.cnn) // 这里会阻塞等待有数据到达
+	parser := resp.NewParser(io.MultiReader(bytes.NewReader(pending), client.cnn)) // 这里会阻塞等待有数据到达
 	running := true
 
 	for running && !s.quit {
@@ -545,6 +545,9 @@ func formatErrorFromLuaEnv(errMsg string) string {
 // resp -> lua
 func respDataToLua(data resp.RedisData) lua.LValue {
 
+	// 脚本环境中使用 RESP2 协议
+	data = resp.ToRESP2(data)
+
 	switch fmt.Sprintf("%T", data) {
 
 	case "*resp.StringData":
@@ -0,0 +1,1010 @@
+package server
+
+import (
+	"fmt"
+	"github.com/tangrc99/MemTable/config"
+	"github.com/tangrc99/MemTable/logger"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"github.com/tangrc99/MemTable/utils/rand_str"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 哨兵的各项周期，与 redis 相同
+const (
+	sentinelPingPeriod         = time.Second
+	sentinelInfoPeriod         = 10 * time.Second
+	sentinelHelloPeriod        = 2 * time.Second
+	sentinelAskPeriod          = time.Second
+	sentinelReplyTimeout       = time.Second      // 单个请求的超时时间
+	sentinelReplyValidity      = 5 * time.Second  // 其他哨兵的下线判断的有效时间
+	sentinelReconfPeriod       = 10 * time.Second // 两次纠正从节点配置的最小间隔
+	sentinelMaxElectionTimeout = 10 * time.Second
+	sentinelMaxDesync          = time.Second // 故障转移开始时间的随机偏移，避免多个哨兵同时发起选举
+)
+
+// sentinelHelloChannel 是哨兵之间交换信息的频道
+const sentinelHelloChannel = "__sentinel__:hello"
+
+// 哨兵监控的实例类型
+const (
+	sentinelMasterInstance = iota
+	sentinelReplicaInstance
+	sentinelPeerInstance
+)
+
+// 故障转移的状态
+const (
+	sentinelFailoverNone          = iota
+	sentinelFailoverWaitStart     // 等待当选为领导者
+	sentinelFailoverWaitPromotion // 等待被选中的从节点成为主节点
+)
+
+// 哨兵向实例发送的请求类型，同一类型的请求在队列中最多只有一个
+const (
+	sentinelReqPing = iota
+	sentinelReqInfo
+	sentinelReqHello
+	sentinelReqAskMaster
+	sentinelReqSlaveOf
+)
+
+type sentinelRequest struct {
+	kind int
+	args []string
+}
+
+// sentinelReply 是请求的结果，由执行请求的协程传递回事件循环
+type sentinelReply struct {
+	instance *sentinelInstance
+	kind     int
+	data     resp.RedisData
+	err      error
+}
+
+// sentinelInstance 是哨兵监控的一个实例，可以是主节点、从节点或者其他哨兵
+type sentinelInstance struct {
+	kind   int
+	addr   string
+	master *sentinelMaster
+
+	// 命令连接，同一时间一个实例最多只有一个正在执行的请求，mu 只用于在关闭时打断正在执行的请求
+	mu      sync.Mutex
+	conn    net.Conn
+	parser  *resp.Parser
+	closed  bool
+	busy    bool
+	pending []sentinelRequest
+	removed bool
+	quit    chan struct{} // 用于关闭订阅协程
+
+	lastAvail   time.Time // 上一次收到有效回复的时间
+	lastPing    time.Time
+	pingSent    time.Time // 最早一个尚未收到回复的 ping 的发送时间，收到回复后清空
+	lastInfo    time.Time
+	lastHello   time.Time
+	lastAsk     time.Time
+	infoRefresh time.Time // 上一次收到 info 回复的时间
+	sdown       bool
+	reconfTime  time.Time // 上一次纠正从节点配置的时间
+
+	// info replication 中获取的信息
+	role         string
+	masterAddr   string
+	masterLinkUp bool
+	offset       uint64
+
+	// 其他哨兵的信息
+	runID       string
+	masterDown  bool      // 该哨兵是否认为主节点下线
+	downReply   time.Time // 上一次收到 is-master-down-by-addr 回复的时间
+	leader      string    // 该哨兵投票的领导者
+	leaderEpoch uint64
+}
+
+// sentinelMaster 是哨兵监控的一个主节点，以及它的从节点和监控它的其他哨兵
+type sentinelMaster struct {
+	name            string
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+	configEpoch     uint64
+
+	master    *sentinelInstance
+	replicas  map[string]*sentinelInstance // 以地址为键
+	sentinels map[string]*sentinelInstance // 以 runid 为键
+
+	odown bool
+
+	// 本哨兵在选举中投出的选票
+	leader      string
+	leaderEpoch uint64
+
+	failoverState int
+	failoverEpoch uint64
+	failoverStart time.Time // 投票给其他哨兵后会被推迟，在此之后的两倍 failover-timeout 内不会发起故障转移
+	promoted      *sentinelInstance
+}
+
+// sentinelState 是哨兵模式的状态，除了执行请求以及订阅的协程外，所有状态只在事件循环中访问
+type sentinelState struct {
+	server       *Server
+	myID         string
+	addr         string
+	currentEpoch uint64
+	masters      map[string]*sentinelMaster
+
+	replies chan sentinelReply
+	hellos  chan string
+	quit    chan struct{}
+	wg      sync.WaitGroup // 等待所有协程退出
+}
+
+func newSentinelState(s *Server, masters []config.SentinelMaster) *sentinelState {
+
+	st := &sentinelState{
+		server:  s,
+		myID:    rand_str.RandHexString(40),
+		addr:    s.url,
+		masters: make(map[string]*sentinelMaster, len(masters)),
+		replies: make(chan sentinelReply, 1024),
+		hellos:  make(chan string, 1024),
+		quit:    make(chan struct{}),
+	}
+
+	for _, cfg := range masters {
+		m := &sentinelMaster{
+			name:            cfg.Name,
+			quorum:          cfg.Quorum,
+			downAfter:       time.Duration(cfg.DownAfter) * time.Millisecond,
+			failoverTimeout: time.Duration(cfg.FailoverTimeout) * time.Millisecond,
+			replicas:        make(map[string]*sentinelInstance),
+			sentinels:       make(map[string]*sentinelInstance),
+		}
+		m.master = st.newInstance(m, sentinelMasterInstance, net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port)))
+		st.masters[cfg.Name] = m
+	}
+
+	logger.Infof("Sentinel: running in sentinel mode, id %s", st.myID)
+	return st
+}
+
+// newInstance 创建一个被监控的实例，主节点以及从节点需要订阅 hello 频道来发现其他哨兵
+func (st *sentinelState) newInstance(m *sentinelMaster, kind int, addr string) *sentinelInstance {
+
+	inst := &sentinelInstance{
+		kind:      kind,
+		addr:      addr,
+		master:    m,
+		lastAvail: time.Now(),
+	}
+
+	if kind != sentinelPeerInstance {
+		inst.quit = make(chan struct{})
+		st.wg.Add(1)
+		go func() {
+			defer st.wg.Done()
+			st.subscribeHello(addr, inst.quit)
+		}()
+	}
+	return inst
+}
+
+// stop 关闭所有的连接，并等待所有协程退出
+func (st *sentinelState) stop() {
+	close(st.quit)
+	for _, m := range st.masters {
+		for _, inst := range m.instances() {
+			inst.close()
+		}
+	}
+	st.wg.Wait()
+}
+
+// instances 返回主节点、从节点以及其他哨兵
+func (m *sentinelMaster) instances() []*sentinelInstance {
+	instances := make([]*sentinelInstance, 0, 1+len(m.replicas)+len(m.sentinels))
+	instances = append(instances, m.master)
+	for _, inst := range m.replicas {
+		instances = append(instances, inst)
+	}
+	for _, inst := range m.sentinels {
+		instances = append(instances, inst)
+	}
+	return instances
+}
+
+func (inst *sentinelInstance) close() {
+	if inst.removed {
+		return
+	}
+	inst.removed = true
+	if inst.quit != nil {
+		close(inst.quit)
+	}
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	inst.closed = true
+	if inst.conn != nil {
+		_ = inst.conn.Close()
+	}
+}
+
+// describe 返回事件中实例的描述，格式与 redis 相同
+func (inst *sentinelInstance) describe() string {
+
+	host, port, _ := net.SplitHostPort(inst.addr)
+
+	if inst.kind == sentinelMasterInstance {
+		return fmt.Sprintf("master %s %s %s", inst.master.name, host, port)
+	}
+
+	mHost, mPort, _ := net.SplitHostPort(inst.master.master.addr)
+	if inst.kind == sentinelReplicaInstance {
+		return fmt.Sprintf("slave %s %s %s @ %s %s %s", inst.addr, host, port, inst.master.name, mHost, mPort)
+	}
+	return fmt.Sprintf("sentinel %s %s %s @ %s %s %s", inst.runID, host, port, inst.master.name, mHost, mPort)
+}
+
+// event 记录哨兵事件，并且发布到与事件类型同名的频道，客户端可以通过订阅 +switch-master 得知主节点的切换
+func (st *sentinelState) event(eventType string, msg string) {
+	logger.Infof("Sentinel: %s %s", eventType, msg)
+	st.server.Chs.PublishMessage(eventType, []byte(msg))
+}
+
+/* ---------------------------------------------------------------------------
+* 网络请求
+* ------------------------------------------------------------------------- */
+
+// enqueue 将请求加入实例的队列，队列中已经存在同类请求时只更新参数
+func (inst *sentinelInstance) enqueue(kind int, args ...string) {
+	for i := range inst.pending {
+		if inst.pending[i].kind == kind {
+			inst.pending[i].args = args
+			return
+		}
+	}
+	inst.pending = append(inst.pending, sentinelRequest{kind: kind, args: args})
+}
+
+// dispatch 在新的协程中执行实例队列中的下一个请求
+func (st *sentinelState) dispatch(inst *sentinelInstance) {
+
+	if inst.busy || inst.removed || len(inst.pending) == 0 {
+		return
+	}
+
+	req := inst.pending[0]
+	inst.pending = inst.pending[1:]
+	inst.busy = true
+
+	st.wg.Add(1)
+	go func() {
+		defer st.wg.Done()
+		data, err := inst.roundTrip(req.args)
+		select {
+		case st.replies <- sentinelReply{instance: inst, kind: req.kind, data: data, err: err}:
+		case <-st.quit:
+		}
+	}()
+}
+
+// roundTrip 向实例发送命令并等待回复，连接出错后会在下一次请求时重新建立
+func (inst *sentinelInstance) roundTrip(args []string) (resp.RedisData, error) {
+
+	conn, parser, err := inst.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	_ = conn.SetDeadline(time.Now().Add(sentinelReplyTimeout))
+
+	if _, err = conn.Write(sentinelCommand(args...)); err == nil {
+		parsed := parser.Parse()
+		if parsed.Err == nil && parsed.Data != nil {
+			return parsed.Data, nil
+		}
+		err = parsed.Err
+		if err == nil {
+			err = fmt.Errorf("connection to %s aborted", inst.addr)
+		}
+	}
+
+	inst.disconnect()
+	return nil, err
+}
+
+func (inst *sentinelInstance) connect() (net.Conn, *resp.Parser, error) {
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+
+	if inst.closed {
+		return nil, nil, fmt.Errorf("connection to %s closed", inst.addr)
+	}
+
+	if inst.conn == nil {
+		conn, err := net.DialTimeout("tcp", inst.addr, sentinelReplyTimeout)
+		if err != nil {
+			return nil, nil, err
+		}
+		inst.conn = conn
+		inst.parser = resp.NewParser(conn)
+	}
+	return inst.conn, inst.parser, nil
+}
+
+func (inst *sentinelInstance) disconnect() {
+
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+
+	if inst.conn != nil {
+		_ = inst.conn.Close()
+		inst.conn = nil
+		inst.parser = nil
+	}
+}
+
+func sentinelCommand(args ...string) []byte {
+	data := make([]resp.RedisData, len(args))
+	for i, arg := range args {
+		data[i] = resp.MakeBulkData([]byte(arg))
+	}
+	return resp.MakeArrayData(data).ToBytes()
+}
+
+// subscribeHello 订阅实例的 hello 频道，连接断开后会重新订阅，直到 quit 被关闭
+func (st *sentinelState) subscribeHello(addr string, quit chan struct{}) {
+	for {
+		conn, err := net.DialTimeout("tcp", addr, sentinelReplyTimeout)
+		if err == nil {
+			st.readHello(conn, quit)
+		}
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(sentinelPingPeriod):
+		}
+	}
+}
+
+func (st *sentinelState) readHello(conn net.Conn, quit chan struct{}) {
+
+	// quit 被关闭时需要关闭连接，使阻塞的读取返回
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-quit:
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	if _, err := conn.Write(sentinelCommand("subscribe", sentinelHelloChannel)); err != nil {
+		return
+	}
+
+	parser := resp.NewParser(conn)
+	for {
+		parsed := parser.Parse()
+		if parsed.Err != nil || parsed.Abort {
+			return
+		}
+
+		msg, ok := parsed.Data.(*resp.ArrayData)
+		if !ok || len(msg.Data()) != 3 || string(msg.Data()[0].ByteData()) != "message" {
+			continue
+		}
+
+		select {
+		case st.hellos <- string(msg.Data()[2].ByteData()):
+		case <-quit:
+			return
+		}
+	}
+}
+
+/* ---------------------------------------------------------------------------
+* 定时任务
+* ------------------------------------------------------------------------- */
+
+// cron 是哨兵的时间事件，处理异步请求的结果并推进每个主节点的状态
+func (st *sentinelState) cron() {
+
+	st.processReplies()
+
+	now := global.Now
+	for _, m := range st.masters {
+		st.monitorMaster(m, now)
+	}
+}
+
+func (st *sentinelState) processReplies() {
+	for {
+		select {
+		case reply := <-st.replies:
+			reply.instance.busy = false
+			if reply.instance.removed {
+				continue
+			}
+			st.handleReply(reply)
+
+		case hello := <-st.hellos:
+			st.processHello(hello)
+
+		default:
+			return
+		}
+	}
+}
+
+func (st *sentinelState) monitorMaster(m *sentinelMaster, now time.Time) {
+
+	for _, inst := range m.instances() {
+		st.sendPeriodicCommands(inst, now)
+		st.checkSubjectivelyDown(inst, now)
+	}
+
+	st.checkObjectivelyDown(m, now)
+
+	if m.master.sdown {
+		st.askMasterState(m, now)
+	}
+
+	switch m.failoverState {
+	case sentinelFailoverNone:
+		st.startFailoverIfNeeded(m, now)
+	case sentinelFailoverWaitStart:
+		st.waitElection(m, now)
+	case sentinelFailoverWaitPromotion:
+		if now.Sub(m.failoverStart) > m.failoverTimeout {
+			st.abortFailover(m, "-failover-abort-timeout")
+		}
+	}
+
+	for _, inst := range m.instances() {
+		st.dispatch(inst)
+	}
+}
+
+// sendPeriodicCommands 定期向实例发送 ping、info 以及 hello 消息
+func (st *sentinelState) sendPeriodicCommands(inst *sentinelInstance, now time.Time) {
+
+	m := inst.master
+
+	pingPeriod := sentinelPingPeriod
+	if m.downAfter < pingPeriod {
+		pingPeriod = m.downAfter
+	}
+	if now.Sub(inst.lastPing) >= pingPeriod {
+		inst.lastPing = now
+		if inst.pingSent.IsZero() {
+			inst.pingSent = now
+		}
+		inst.enqueue(sentinelReqPing, "ping")
+	}
+
+	if inst.kind == sentinelPeerInstance {
+		return
+	}
+
+	// 主节点下线或者正在进行故障转移时，需要更频繁地获取从节点的信息
+	infoPeriod := sentinelInfoPeriod
+	if inst.kind == sentinelReplicaInstance && (m.master.sdown || m.failoverState != sentinelFailoverNone) {
+		infoPeriod = sentinelPingPeriod
+	}
+	if now.Sub(inst.lastInfo) >= infoPeriod {
+		inst.lastInfo = now
+		inst.enqueue(sentinelReqInfo, "info", "replication")
+	}
+
+	if now.Sub(inst.lastHello) >= sentinelHelloPeriod {
+		inst.lastHello = now
+		inst.enqueue(sentinelReqHello, "publish", sentinelHelloChannel, st.helloPayload(m))
+	}
+}
+
+// helloPayload 的格式与 redis 相同：ip,port,runid,current_epoch,master_name,master_ip,master_port,master_config_epoch
+func (st *sentinelState) helloPayload(m *sentinelMaster) string {
+	host, port, _ := net.SplitHostPort(st.addr)
+	mHost, mPort, _ := net.SplitHostPort(m.master.addr)
+	return fmt.Sprintf("%s,%s,%s,%d,%s,%s,%s,%d", host, port, st.myID, st.currentEpoch, m.name, mHost, mPort, m.configEpoch)
+}
+
+func (st *sentinelState) handleReply(reply sentinelReply) {
+
+	inst := reply.instance
+
+	if reply.err != nil {
+		logger.Debugf("Sentinel: request to %s failed: %s", inst.addr, reply.err.Error())
+		return
+	}
+
+	if e, ok := reply.data.(*resp.ErrorData); ok {
+		// 正在载入数据的实例仍然是可用的
+		if reply.kind == sentinelReqPing && (strings.HasPrefix(e.Error(), "LOADING") || strings.HasPrefix(e.Error(), "MASTERDOWN")) {
+			inst.lastAvail = global.Now
+			inst.pingSent = time.Time{}
+		}
+		logger.Debugf("Sentinel: %s replied error: %s", inst.addr, e.Error())
+		return
+	}
+
+	switch reply.kind {
+	case sentinelReqPing:
+		inst.lastAvail = global.Now
+		inst.pingSent = time.Time{}
+	case sentinelReqInfo:
+		st.refreshInfo(inst, string(reply.data.ByteData()))
+	case sentinelReqAskMaster:
+		handleMasterDownReply(inst, reply.data)
+	}
+}
+
+// refreshInfo 根据 info replication 的结果更新实例的角色以及复制信息，主节点的 info 中包含了它的从节点
+func (st *sentinelState) refreshInfo(inst *sentinelInstance, info string) {
+
+	m := inst.master
+	inst.infoRefresh = global.Now
+
+	fields := make(map[string]string)
+	var replicas []string
+
+	for _, line := range strings.Split(info, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+		fields[key] = value
+
+		// slave0:ip=127.0.0.1,port=6381,state=online,offset=100
+		if _, err := strconv.Atoi(strings.TrimPrefix(key, "slave")); err == nil && strings.HasPrefix(key, "slave") {
+			var host, port string
+			for _, kv := range strings.Split(value, ",") {
+				if strings.HasPrefix(kv, "ip=") {
+					host = strings.TrimPrefix(kv, "ip=")
+				} else if strings.HasPrefix(kv, "port=") {
+					port = strings.TrimPrefix(kv, "port=")
+				}
+			}
+			if host != "" && port != "" {
+				replicas = append(replicas, net.JoinHostPort(host, port))
+			}
+		}
+	}
+
+	inst.role = fields["role"]
+	inst.masterAddr = ""
+	inst.masterLinkUp = false
+	if inst.role == "slave" {
+		inst.masterAddr = net.JoinHostPort(fields["master_host"], fields["master_port"])
+		inst.masterLinkUp = fields["master_link_status"] == "up"
+		inst.offset, _ = strconv.ParseUint(fields["slave_repl_offset"], 10, 64)
+	}
+
+	if inst == m.master {
+		for _, addr := range replicas {
+			if _, exist := m.replicas[addr]; exist || addr == m.master.addr {
+				continue
+			}
+			replica := st.newInstance(m, sentinelReplicaInstance, addr)
+			m.replicas[addr] = replica
+			st.event("+slave", replica.describe())
+		}
+		return
+	}
+
+	if inst.kind != sentinelReplicaInstance {
+		return
+	}
+
+	// 被选中的从节点不再是从节点时，故障转移完成
+	if m.failoverState == sentinelFailoverWaitPromotion && inst == m.promoted && inst.role != "slave" {
+		st.finishFailover(m)
+		return
+	}
+
+	st.checkReplicaConfig(inst)
+}
+
+// checkReplicaConfig 纠正没有复制当前主节点的从节点，例如故障转移后重新上线的旧主节点
+func (st *sentinelState) checkReplicaConfig(inst *sentinelInstance) {
+
+	m := inst.master
+	if m.failoverState != sentinelFailoverNone || m.master.sdown || inst.sdown {
+		return
+	}
+	if inst.role == "slave" && inst.masterAddr == m.master.addr {
+		return
+	}
+	if global.Now.Sub(inst.reconfTime) < sentinelReconfPeriod {
+		return
+	}
+	inst.reconfTime = global.Now
+
+	host, port, _ := net.SplitHostPort(m.master.addr)
+	inst.enqueue(sentinelReqSlaveOf, "slaveof", host, port)
+
+	if inst.role == "slave" {
+		st.event("+fix-slave-config", inst.describe())
+	} else {
+		st.event("+convert-to-slave", inst.describe())
+	}
+}
+
+// handleMasterDownReply 处理 sentinel is-master-down-by-addr 的回复：[down_state, leader_runid, leader_epoch]
+func handleMasterDownReply(peer *sentinelInstance, data resp.RedisData) {
+
+	reply, ok := data.(*resp.ArrayData)
+	if !ok || len(reply.Data()) != 3 {
+		return
+	}
+	down, ok1 := reply.Data()[0].(*resp.IntData)
+	epoch, ok2 := reply.Data()[2].(*resp.IntData)
+	if !ok1 || !ok2 {
+		return
+	}
+
+	peer.downReply = global.Now
+	peer.masterDown = down.Data() == 1
+	if leader := string(reply.Data()[1].ByteData()); leader != "*" {
+		peer.leader = leader
+		peer.leaderEpoch = uint64(epoch.Data())
+	}
+}
+
+// processHello 处理其他哨兵发布的 hello 消息，用于发现哨兵以及同步更新的主节点配置
+func (st *sentinelState) processHello(hello string) {
+
+	parts := strings.Split(hello, ",")
+	if len(parts) != 8 || parts[2] == st.myID {
+		return
+	}
+
+	m, ok := st.masters[parts[4]]
+	if !ok {
+		return
+	}
+
+	currentEpoch, err1 := strconv.ParseUint(parts[3], 10, 64)
+	configEpoch, err2 := strconv.ParseUint(parts[7], 10, 64)
+	if err1 != nil || err2 != nil {
+		return
+	}
+
+	if currentEpoch > st.currentEpoch {
+		st.currentEpoch = currentEpoch
+		st.event("+new-epoch", strconv.FormatUint(currentEpoch, 10))
+	}
+
+	runID := parts[2]
+	addr := net.JoinHostPort(parts[0], parts[1])
+
+	if _, exist := m.sentinels[runID]; !exist {
+		// 哨兵重启后会使用新的 runid，需要删除相同地址的旧记录
+		for id, peer := range m.sentinels {
+			if peer.addr == addr {
+				st.event("-dup-sentinel", peer.describe())
+				peer.close()
+				delete(m.sentinels, id)
+			}
+		}
+		peer := st.newInstance(m, sentinelPeerInstance, addr)
+		peer.runID = runID
+		m.sentinels[runID] = peer
+		st.event("+sentinel", peer.describe())
+	}
+
+	// 更大的配置纪元意味着其他哨兵完成了故障转移
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if masterAddr := net.JoinHostPort(parts[5], parts[6]); masterAddr != m.master.addr {
+			st.switchMaster(m, masterAddr)
+		}
+	}
+}
+
+/* ---------------------------------------------------------------------------
+* 下线判断以及故障转移
+* ------------------------------------------------------------------------- */
+
+// checkSubjectivelyDown 实例的 ping 超过 down-after-milliseconds 没有有效回复时被判定为主观下线，
+// 与 redis 相同，计时从最早一个未回复的 ping 发出时开始，而不是从上一次收到回复时开始
+func (st *sentinelState) checkSubjectivelyDown(inst *sentinelInstance, now time.Time) {
+
+	down := !inst.pingSent.IsZero() && now.Sub(inst.pingSent) > inst.master.downAfter
+
+	if down && !inst.sdown {
+		inst.sdown = true
+		st.event("+sdown", inst.describe())
+	} else if !down && inst.sdown {
+		inst.sdown = false
+		st.event("-sdown", inst.describe())
+	}
+}
+
+// checkObjectivelyDown 主节点主观下线，并且认为主节点下线的哨兵数量达到 quorum 时，主节点被判定为客观下线
+func (st *sentinelState) checkObjectivelyDown(m *sentinelMaster, now time.Time) {
+
+	votes := 0
+	if m.master.sdown {
+		votes = 1
+		for _, peer := range m.sentinels {
+			if peer.masterDown && now.Sub(peer.downReply) < sentinelReplyValidity {
+				votes++
+			}
+		}
+	}
+
+	odown := m.master.sdown && votes >= m.quorum
+
+	if odown && !m.odown {
+		m.odown = true
+		st.event("+odown", fmt.Sprintf("%s #quorum %d/%d", m.master.describe(), votes, m.quorum))
+	} else if !odown && m.odown {
+		m.odown = false
+		st.event("-odown", m.master.describe())
+	}
+}
+
+// askMasterState 询问其他哨兵是否认为主节点下线，等待选举时同时请求其他哨兵投票
+func (st *sentinelState) askMasterState(m *sentinelMaster, now time.Time) {
+
+	host, port, _ := net.SplitHostPort(m.master.addr)
+	epoch := strconv.FormatUint(st.currentEpoch, 10)
+
+	runID := "*"
+	if m.failoverState == sentinelFailoverWaitStart {
+		runID = st.myID
+	}
+
+	for _, peer := range m.sentinels {
+		if peer.sdown || now.Sub(peer.lastAsk) < sentinelAskPeriod {
+			continue
+		}
+		peer.lastAsk = now
+		peer.enqueue(sentinelReqAskMaster, "sentinel", "is-master-down-by-addr", host, port, epoch, runID)
+	}
+}
+
+// voteLeader 在 epoch 纪元中为 runID 投票，每个纪元只会投出一票，返回本哨兵当前的选票
+func (st *sentinelState) voteLeader(m *sentinelMaster, epoch uint64, runID string) (string, uint64) {
+
+	if epoch > st.currentEpoch {
+		st.currentEpoch = epoch
+		st.event("+new-epoch", strconv.FormatUint(epoch, 10))
+	}
+
+	if m.leaderEpoch < epoch && st.currentEpoch <= epoch {
+		m.leader = runID
+		m.leaderEpoch = st.currentEpoch
+		st.event("+vote-for-leader", fmt.Sprintf("%s %d", runID, m.leaderEpoch))
+
+		// 投票给其他哨兵后，一段时间内不会发起故障转移
+		if runID != st.myID {
+			m.failoverStart = global.Now.Add(time.Duration(rand.Int63n(int64(sentinelMaxDesync))))
+		}
+	}
+
+	return m.leader, m.leaderEpoch
+}
+
+// electedLeader 统计当前故障转移纪元中的选票，得票数同时达到多数以及 quorum 的哨兵成为领导者
+func (st *sentinelState) electedLeader(m *sentinelMaster) string {
+
+	votes := make(map[string]int)
+	for _, peer := range m.sentinels {
+		if peer.leader != "" && peer.leaderEpoch == m.failoverEpoch {
+			votes[peer.leader]++
+		}
+	}
+	if m.leader != "" && m.leaderEpoch == m.failoverEpoch {
+		votes[m.leader]++
+	}
+
+	needed := (len(m.sentinels)+1)/2 + 1
+	if m.quorum > needed {
+		needed = m.quorum
+	}
+
+	for leader, n := range votes {
+		if n >= needed {
+			return leader
+		}
+	}
+	return ""
+}
+
+// startFailoverIfNeeded 主节点客观下线后开始故障转移，两次故障转移之间至少间隔两倍的 failover-timeout
+func (st *sentinelState) startFailoverIfNeeded(m *sentinelMaster, now time.Time) {
+
+	if !m.odown {
+		return
+	}
+	if !m.failoverStart.IsZero() && now.Sub(m.failoverStart) < 2*m.failoverTimeout {
+		return
+	}
+
+	st.currentEpoch++
+	m.failoverState = sentinelFailoverWaitStart
+	m.failoverEpoch = st.currentEpoch
+	// 与 redis 相同，加入随机偏移，避免选举失败后多个哨兵再次同时发起选举
+	m.failoverStart = now.Add(time.Duration(rand.Int63n(int64(sentinelMaxDesync))))
+	m.promoted = nil
+
+	st.event("+new-epoch", strconv.FormatUint(st.currentEpoch, 10))
+	st.event("+try-failover", m.master.describe())
+
+	st.voteLeader(m, st.currentEpoch, st.myID)
+}
+
+// waitElection 等待选举结果，当选为领导者后选出新的主节点
+func (st *sentinelState) waitElection(m *sentinelMaster, now time.Time) {
+
+	if !m.odown {
+		st.abortFailover(m, "-failover-abort-master-up")
+		return
+	}
+
+	if st.electedLeader(m) != st.myID {
+		timeout := m.failoverTimeout
+		if timeout > sentinelMaxElectionTimeout {
+			timeout = sentinelMaxElectionTimeout
+		}
+		if now.Sub(m.failoverStart) > timeout {
+			st.abortFailover(m, "-failover-abort-not-elected")
+		}
+		return
+	}
+
+	st.event("+elected-leader", m.master.describe())
+
+	replica := m.selectReplica(now)
+	if replica == nil {
+		st.abortFailover(m, "-failover-abort-no-good-slave")
+		return
+	}
+
+	st.event("+selected-slave", replica.describe())
+
+	replica.enqueue(sentinelReqSlaveOf, "slaveof", "no", "one")
+	m.promoted = replica
+	m.failoverState = sentinelFailoverWaitPromotion
+
+	st.event("+failover-state-wait-promotion", replica.describe())
+}
+
+// selectReplica 选出可以提升为主节点的从节点：排除下线以及信息过期的从节点后，选择复制 offset 最大的一个，
+// offset 相同时按照地址排序保证结果确定
+func (m *sentinelMaster) selectReplica(now time.Time) *sentinelInstance {
+
+	infoValidity := 3 * sentinelInfoPeriod
+	if m.master.sdown {
+		infoValidity = 5 * sentinelPingPeriod
+	}
+
+	candidates := make([]*sentinelInstance, 0, len(m.replicas))
+	for _, replica := range m.replicas {
+		if replica.sdown || replica.role != "slave" ||
+			now.Sub(replica.lastAvail) > 5*sentinelPingPeriod || now.Sub(replica.infoRefresh) > infoValidity {
+			continue
+		}
+		candidates = append(candidates, replica)
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].offset != candidates[j].offset {
+			return candidates[i].offset > candidates[j].offset
+		}
+		return candidates[i].addr < candidates[j].addr
+	})
+	return candidates[0]
+}
+
+func (st *sentinelState) abortFailover(m *sentinelMaster, reason string) {
+	st.event(reason, m.master.describe())
+	m.failoverState = sentinelFailoverNone
+	m.promoted = nil
+}
+
+// finishFailover 被选中的从节点成为主节点后，让其他从节点复制新的主节点并切换主节点的配置
+func (st *sentinelState) finishFailover(m *sentinelMaster) {
+
+	promoted := m.promoted
+	st.event("+promoted-slave", promoted.describe())
+
+	host, port, _ := net.SplitHostPort(promoted.addr)
+	for _, replica := range m.replicas {
+		if replica == promoted || replica.sdown {
+			continue
+		}
+		replica.enqueue(sentinelReqSlaveOf, "slaveof", host, port)
+		replica.reconfTime = global.Now
+		st.event("+slave-reconf-sent", replica.describe())
+	}
+
+	m.configEpoch = m.failoverEpoch
+	st.switchMaster(m, promoted.addr)
+}
+
+// switchMaster 将主节点切换为 addr，旧的主节点成为从节点，它重新上线后会被纠正为新主节点的从节点
+func (st *sentinelState) switchMaster(m *sentinelMaster, addr string) {
+
+	old := m.master
+
+	master, exist := m.replicas[addr]
+	if exist {
+		delete(m.replicas, addr)
+	} else {
+		master = st.newInstance(m, sentinelMasterInstance, addr)
+	}
+
+	master.kind = sentinelMasterInstance
+	old.kind = sentinelReplicaInstance
+	old.reconfTime = time.Time{}
+	m.replicas[old.addr] = old
+	m.master = master
+
+	m.odown = false
+	m.failoverState = sentinelFailoverNone
+	m.promoted = nil
+	for _, peer := range m.sentinels {
+		peer.masterDown = false
+	}
+
+	// 立即发送 hello，使其他哨兵尽快得知新的配置
+	for _, inst := range m.instances() {
+		inst.lastHello = time.Time{}
+	}
+
+	oldHost, oldPort, _ := net.SplitHostPort(old.addr)
+	host, port, _ := net.SplitHostPort(addr)
+	st.event("+switch-master", fmt.Sprintf("%s %s %s %s %s", m.name, oldHost, oldPort, host, port))
+}
+
+// status 返回主节点在 info 中显示的状态
+func (m *sentinelMaster) status() string {
+	if m.odown {
+		return "odown"
+	} else if m.master.sdown {
+		return "sdown"
+	}
+	return "ok"
+}
+
+// information 生成 info 命令中的 sentinel 部分
+func (st *sentinelState) information(b *strings.Builder) {
+
+	names := st.masterNames()
+
+	b.WriteString("# Sentinel\n")
+	b.WriteString(fmt.Sprintf("sentinel_masters:%d\n", len(names)))
+	for i, name := range names {
+		m := st.masters[name]
+		b.WriteString(fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\n",
+			i, name, m.status(), m.master.addr, len(m.replicas), len(m.sentinels)+1))
+	}
+}
+
+func (st *sentinelState) masterNames() []string {
+	names := make([]string, 0, len(st.masters))
+	for name := range st.masters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,354 @@
+package server
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tangrc99/MemTable/config"
+	"github.com/tangrc99/MemTable/logger"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeInstance 模拟被哨兵监控的实例，只处理哨兵会发送的命令
+type fakeInstance struct {
+	listener net.Listener
+	addr     string
+
+	mu       sync.Mutex
+	role     string
+	master   string
+	offset   int
+	replicas []string
+	slaveOf  []string // 收到的 slaveof 命令
+	conns    []net.Conn
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+func newFakeInstance(t *testing.T) *fakeInstance {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	f := &fakeInstance{listener: l, addr: l.Addr().String(), role: "master"}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			if f.stopped {
+				f.mu.Unlock()
+				_ = conn.Close()
+				return
+			}
+			f.conns = append(f.conns, conn)
+			f.wg.Add(1)
+			f.mu.Unlock()
+			go func() {
+				defer f.wg.Done()
+				f.serve(conn)
+			}()
+		}
+	}()
+	return f
+}
+
+func (f *fakeInstance) serve(conn net.Conn) {
+	parser := resp.NewParser(conn)
+	for {
+		parsed := parser.Parse()
+		if parsed.Err != nil || parsed.Abort {
+			return
+		}
+		cmd := parsed.Data.(*resp.ArrayData).ToCommand()
+
+		var reply resp.RedisData
+		switch strings.ToLower(string(cmd[0])) {
+		case "ping":
+			reply = resp.MakeStringData("PONG")
+		case "info":
+			reply = resp.MakeBulkData([]byte(f.info()))
+		case "publish":
+			reply = resp.MakeIntData(0)
+		case "subscribe":
+			reply = resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData([]byte("subscribe")),
+				resp.MakeBulkData(cmd[1]), resp.MakeIntData(1)})
+		case "slaveof":
+			f.mu.Lock()
+			target := net.JoinHostPort(string(cmd[1]), string(cmd[2]))
+			if strings.ToLower(target) == "no:one" {
+				target = "no one"
+				f.role, f.master = "master", ""
+			} else {
+				f.role, f.master = "slave", target
+			}
+			f.slaveOf = append(f.slaveOf, target)
+			f.mu.Unlock()
+			reply = resp.MakeStringData("OK")
+		default:
+			reply = resp.MakeErrorData("ERR unknown command")
+		}
+		_, _ = conn.Write(reply.ToBytes())
+	}
+}
+
+func (f *fakeInstance) info() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	b := strings.Builder{}
+	b.WriteString(fmt.Sprintf("# Replication\nrole:%s\n", f.role))
+	if f.role == "slave" {
+		host, port, _ := net.SplitHostPort(f.master)
+		b.WriteString(fmt.Sprintf("master_host:%s\nmaster_port:%s\nmaster_link_status:up\nslave_repl_offset:%d\n", host, port, f.offset))
+	}
+	for i, replica := range f.replicas {
+		host, port, _ := net.SplitHostPort(replica)
+		b.WriteString(fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,offset=0\n", i, host, port))
+	}
+	return b.String()
+}
+
+func (f *fakeInstance) replicaOf(master *fakeInstance, offset int) {
+	f.mu.Lock()
+	f.role, f.master, f.offset = "slave", master.addr, offset
+	f.mu.Unlock()
+	master.mu.Lock()
+	master.replicas = append(master.replicas, f.addr)
+	master.mu.Unlock()
+}
+
+func (f *fakeInstance) slaveOfCommands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.slaveOf...)
+}
+
+// stop 模拟实例下线，等待所有协程退出
+func (f *fakeInstance) stop() {
+	_ = f.listener.Close()
+	f.mu.Lock()
+	f.stopped = true
+	for _, conn := range f.conns {
+		_ = conn.Close()
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+}
+
+// runSentinelUntil 在当前协程中驱动哨兵的时间事件，直到条件满足
+func runSentinelUntil(t *testing.T, st *sentinelState, cond func() bool, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		global.UpdateGlobalClock()
+		st.cron()
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("sentinel condition not satisfied before timeout")
+}
+
+func newTestSentinel(masters ...config.SentinelMaster) (*Server, *sentinelState) {
+	s := NewServer()
+	s.url = "127.0.0.1:26379"
+	s.sentinel = newSentinelState(s, masters)
+	return s, s.sentinel
+}
+
+func TestSentinelFailover(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	master := newFakeInstance(t)
+	replica1 := newFakeInstance(t)
+	replica2 := newFakeInstance(t)
+	defer replica1.stop()
+	defer replica2.stop()
+
+	replica1.replicaOf(master, 100)
+	replica2.replicaOf(master, 200)
+
+	host, port, _ := net.SplitHostPort(master.addr)
+	var p int
+	_, _ = fmt.Sscanf(port, "%d", &p)
+
+	s, st := newTestSentinel(config.SentinelMaster{Name: "mymaster", Host: host, Port: p, Quorum: 1,
+		DownAfter: 200, FailoverTimeout: 1000})
+	defer st.stop()
+
+	m := st.masters["mymaster"]
+	cli := NewFakeClient()
+	cli.Subscribe(s.Chs, "+switch-master")
+
+	// 通过主节点的 info 发现从节点
+	runSentinelUntil(t, st, func() bool {
+		return len(m.replicas) == 2 && m.replicas[replica2.addr].role == "slave"
+	}, 3*time.Second)
+	assert.Equal(t, "ok", m.status())
+
+	// 主节点下线后，offset 最大的从节点被提升为主节点，另一个从节点转而复制新的主节点
+	master.stop()
+
+	runSentinelUntil(t, st, func() bool {
+		return m.master.addr == replica2.addr
+	}, 5*time.Second)
+
+	assert.Equal(t, []string{"no one"}, replica2.slaveOfCommands())
+	assert.Equal(t, uint64(1), m.configEpoch)
+	assert.Contains(t, m.replicas, master.addr)
+
+	runSentinelUntil(t, st, func() bool {
+		return len(replica1.slaveOfCommands()) > 0
+	}, 3*time.Second)
+	assert.Equal(t, []string{replica2.addr}, replica1.slaveOfCommands())
+
+	newHost, newPort, _ := net.SplitHostPort(replica2.addr)
+	ret, _ := ExecCommand(s, cli, [][]byte{[]byte("sentinel"), []byte("get-master-addr-by-name"), []byte("mymaster")}, nil)
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte(newHost)), resp.MakeBulkData([]byte(newPort)),
+	}), ret)
+
+	// 订阅的客户端会收到主节点切换的通知
+	oldHost, oldPort, _ := net.SplitHostPort(master.addr)
+	select {
+	case msg := <-cli.msg:
+		assert.Contains(t, string(msg), fmt.Sprintf("mymaster %s %s %s %s", oldHost, oldPort, newHost, newPort))
+	default:
+		t.Fatal("+switch-master not published")
+	}
+}
+
+func TestSentinelVoteLeader(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	_, st := newTestSentinel(config.SentinelMaster{Name: "mymaster", Host: "127.0.0.1", Port: 1, Quorum: 2,
+		DownAfter: 1000, FailoverTimeout: 1000})
+	defer st.stop()
+
+	m := st.masters["mymaster"]
+
+	// 每个纪元只会投出一票
+	leader, epoch := st.voteLeader(m, 1, "a")
+	assert.Equal(t, "a", leader)
+	assert.Equal(t, uint64(1), epoch)
+	leader, epoch = st.voteLeader(m, 1, "b")
+	assert.Equal(t, "a", leader)
+	assert.Equal(t, uint64(1), epoch)
+	assert.Equal(t, uint64(1), st.currentEpoch)
+
+	// 更大的纪元可以重新投票
+	leader, epoch = st.voteLeader(m, 2, "b")
+	assert.Equal(t, "b", leader)
+	assert.Equal(t, uint64(2), epoch)
+
+	// 通过 hello 发现其他哨兵，得票数需要同时达到多数以及 quorum
+	st.processHello("127.0.0.1,26380,peer1,2,mymaster,127.0.0.1,1,0")
+	st.processHello("127.0.0.1,26381,peer2,2,mymaster,127.0.0.1,1,0")
+	assert.Len(t, m.sentinels, 2)
+
+	m.failoverEpoch = 3
+	st.voteLeader(m, 3, st.myID)
+	assert.Equal(t, "", st.electedLeader(m))
+
+	handleMasterDownReply(m.sentinels["peer1"], resp.MakeArrayData([]resp.RedisData{
+		resp.MakeIntData(1), resp.MakeBulkData([]byte(st.myID)), resp.MakeIntData(3),
+	}))
+	assert.True(t, m.sentinels["peer1"].masterDown)
+	assert.Equal(t, st.myID, st.electedLeader(m))
+
+	// 相同地址的哨兵重启后会替换旧的记录，更大的配置纪元会切换主节点
+	st.processHello("127.0.0.1,26381,peer3,3,mymaster,127.0.0.1,2,3")
+	assert.Len(t, m.sentinels, 2)
+	assert.Contains(t, m.sentinels, "peer3")
+	assert.Equal(t, "127.0.0.1:2", m.master.addr)
+	assert.Equal(t, uint64(3), m.configEpoch)
+	assert.Contains(t, m.replicas, "127.0.0.1:1")
+}
+
+func TestSentinelSelectReplica(t *testing.T) {
+
+	global.UpdateGlobalClock()
+	now := global.Now
+
+	m := &sentinelMaster{master: &sentinelInstance{sdown: true}, replicas: make(map[string]*sentinelInstance)}
+	add := func(addr string, offset uint64, sdown bool) {
+		m.replicas[addr] = &sentinelInstance{addr: addr, role: "slave", offset: offset, sdown: sdown,
+			lastAvail: now, infoRefresh: now}
+	}
+
+	assert.Nil(t, m.selectReplica(now))
+
+	add("127.0.0.1:3", 100, false)
+	add("127.0.0.1:2", 100, false)
+	add("127.0.0.1:1", 300, true)
+	assert.Equal(t, "127.0.0.1:2", m.selectReplica(now).addr)
+
+	add("127.0.0.1:4", 200, false)
+	assert.Equal(t, "127.0.0.1:4", m.selectReplica(now).addr)
+
+	// 信息过期的从节点不会被选中
+	m.replicas["127.0.0.1:4"].infoRefresh = now.Add(-time.Minute)
+	assert.Equal(t, "127.0.0.1:2", m.selectReplica(now).addr)
+}
+
+func TestCmdSentinel(t *testing.T) {
+
+	_ = logger.Init("", "", logger.WARNING)
+	global.UpdateGlobalClock()
+
+	s, st := newTestSentinel(config.SentinelMaster{Name: "mymaster", Host: "127.0.0.1", Port: 1, Quorum: 1,
+		DownAfter: 1000, FailoverTimeout: 1000})
+	defer st.stop()
+	cli := NewFakeClient()
+
+	exec := func(args ...string) resp.RedisData {
+		cmd := make([][]byte, len(args))
+		for i, arg := range args {
+			cmd[i] = []byte(arg)
+		}
+		ret, _ := ExecCommand(s, cli, cmd, nil)
+		return ret
+	}
+
+	// 哨兵模式下不能执行数据库命令
+	assert.Equal(t, resp.MakeErrorData("ERR unknown command 'set'"), exec("set", "k", "v"))
+	assert.Equal(t, resp.MakeStringData("pong"), exec("ping"))
+
+	assert.Equal(t, resp.MakeBulkData([]byte(st.myID)), exec("sentinel", "myid"))
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeBulkData([]byte("127.0.0.1")), resp.MakeBulkData([]byte("1")),
+	}), exec("sentinel", "get-master-addr-by-name", "mymaster"))
+	assert.Equal(t, resp.MakeNullData(), exec("sentinel", "get-master-addr-by-name", "other"))
+	assert.Equal(t, resp.MakeErrorData("ERR No such master with that name"), exec("sentinel", "master", "other"))
+
+	info := exec("sentinel", "master", "mymaster").(*resp.ArrayData).ToCommand()
+	assert.Equal(t, "name", string(info[0]))
+	assert.Equal(t, "mymaster", string(info[1]))
+	assert.Equal(t, "flags", string(info[8]))
+	assert.Equal(t, "master", string(info[9]))
+	assert.Len(t, exec("sentinel", "masters").(*resp.ArrayData).Data(), 1)
+	assert.Equal(t, resp.MakeEmptyArrayData(), exec("sentinel", "replicas", "mymaster"))
+
+	// 主节点没有下线，但是仍然会为请求的哨兵投票
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeIntData(0), resp.MakeBulkData([]byte("peer")), resp.MakeIntData(5),
+	}), exec("sentinel", "is-master-down-by-addr", "127.0.0.1", "1", "5", "peer"))
+	assert.Equal(t, resp.MakeArrayData([]resp.RedisData{
+		resp.MakeIntData(0), resp.MakeBulkData([]byte("*")), resp.MakeIntData(0),
+	}), exec("sentinel", "is-master-down-by-addr", "127.0.0.1", "2", "5", "*"))
+
+	assert.Contains(t, s.Information("sentinel"), "master0:name=mymaster,status=ok,address=127.0.0.1:1,slaves=0,sentinels=1\n")
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"github.com/gofrs/uuid"
 	"github.com/tangrc99/MemTable/config"
 	"github.com/tangrc99/MemTable/db"
 	"github.com/tangrc99/MemTable/logger"
@@ -16,6 +17,7 @@ import (
 	"os/signal"
 	"path"
 	"regexp"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -31,9 +33,11 @@ type Server struct {
 	dir         string       // 工作目录
 
 	// 数据库部分
-	dbs          []*db.DataBase // 多个可以用于切换的数据库
-	Chs          *db.Channels   // 订阅发布频道
-	dbNum        int            //数据库数量
+	dbs          []*db.DataBase    // 多个可以用于切换的数据库
+	Chs          *db.Channels      // 订阅发布频道
+	ShardChs     *db.Channels      // 分片订阅发布频道，与 Chs 的命名空间相互独立
+	tracking     *db.TrackingTable // 客户端缓存追踪，所有数据库共享
+	dbNum        int               //数据库数量
 	evictChannel []chan string
 
 	// 客户端部分
@@ -56,6 +60,19 @@ type Server struct {
 	aofFile    string     // aof 文件名
 	aof        *aofBuffer // aof 缓冲区
 	aofEnabled bool       // 是否开启 aof
+	deferred   []*Event   // 需要在当前命令之后传播的命令
+	execDepth  int        // ExecCommand 的嵌套层数，事务以及脚本中执行的命令层数大于 1
+	loading    bool       // 是否正在从 aof 文件中恢复数据
+
+	// 客户端暂停
+	pauseEnd  time.Time // 暂停的结束时间
+	pauseAll  bool      // 是否暂停所有命令，否则只暂停写命令
+	postponed []*Event  // 暂停期间被延后执行的命令
+
+	// aof 重写
+	aofRewriteStatus
+
+	snapshots []*snapshotJob // 正在事件循环中增量生成的快照
 
 	full bool // 表示已经写满
 	cost int64
@@ -75,31 +92,46 @@ type Server struct {
 	// 集群
 	clusterStatus
 
+	// 哨兵，只有以哨兵模式运行时不为空
+	sentinel *sentinelState
+
 	msgPool sync.Pool
 
 	acl *acl.ACL
 }
 
+// dbInitialShards 是数据库存储键值对的初始分片数量，分片数量会随着键值对数量增长
+const dbInitialShards = 16
+
 func NewServer() *Server {
+	chs := db.NewChannels()
+
+	keyspaceEvents, err := db.ParseKeyspaceEvents(config.Conf.NotifyKeyspaceEvents)
+	if err != nil {
+		logger.Errorf("Invalid notify-keyspace-events '%s', keyspace notification disabled", config.Conf.NotifyKeyspaceEvents)
+	}
+
+	policy, ok := db.ParseEvictPolicy(config.Conf.Eviction)
+	if !ok {
+		logger.Errorf("Invalid eviction '%s', eviction disabled", config.Conf.Eviction)
+	}
+
+	tracking := db.NewTrackingTable()
+
 	// 配置数据库
 	d := make([]*db.DataBase, config.Conf.DataBases)
 
 	for i := 0; i < config.Conf.DataBases; i++ {
-		switch config.Conf.Eviction {
-		case "no":
-			d[i] = db.NewDataBase(slotNum, db.WithEviction(db.NoEviction))
-		case "lru":
-			d[i] = db.NewDataBase(slotNum, db.WithEviction(db.EvictLRU))
-		case "lfu":
-			d[i] = db.NewDataBase(slotNum, db.WithEviction(db.EvictLFU))
-
-		}
+		notification := db.WithKeyspaceNotification(i, chs, keyspaceEvents)
+		d[i] = db.NewDataBase(dbInitialShards, db.WithEviction(policy), notification, db.WithTracking(tracking))
 	}
 
 	s := &Server{
 		dbs:        d,
 		dbNum:      config.Conf.DataBases,
-		Chs:        db.NewChannels(),
+		Chs:        chs,
+		ShardChs:   db.NewChannels(),
+		tracking:   tracking,
 		clis:       NewClientList(),
 		tl:         NewTimeEventList(),
 		events:     make(chan *Event, 10000),
@@ -118,6 +150,8 @@ func NewServer() *Server {
 		acl:        acl.NewAccessControlList(config.Conf.ACLFile),
 	}
 
+	tracking.SetInvalidator(s.sendInvalidation)
+
 	// check the port
 	if config.Conf.Port != 0 {
 		s.url = fmt.Sprintf("%s:%d", config.Conf.Host, config.Conf.Port)
@@ -143,6 +177,10 @@ func NewServer() *Server {
 		s.initCluster(s)
 	}
 
+	if config.Conf.Sentinel {
+		s.sentinel = newSentinelState(s, config.Conf.SentinelMasters)
+	}
+
 	return s
 }
 
@@ -151,6 +189,8 @@ func (s *Server) InitModules() {
 	if config.Conf.AppendOnly {
 		logger.Debug("Config: AppendOnly Enabled")
 		s.aof = newAOFBuffer(config.Conf.Dir + "appendonly.aof")
+		s.aof.setFsync(config.Conf.AppendFsync)
+		s.aofBaseSize = s.aof.size
 	}
 
 	if config.Conf.GoPool {
@@ -246,7 +286,7 @@ func (s *Server) handleRead(conn net.Conn) {
 		case r := <-client.res:
 
 			// 将主线程的返回值写入到 socket 中
-			_, err := conn.Write((*r).ToBytes())
+			_, err := conn.Write(client.encode(*r))
 			if err != nil {
 				logger.Warningf("Client %s write error: %s", conn.RemoteAddr().String(), err.Error())
 				running = false
@@ -254,7 +294,7 @@ func (s *Server) handleRead(conn net.Conn) {
 			}
 
 		case msg := <-client.msg:
-			_, err := conn.Write(msg)
+			_, err := conn.Write(client.encodeMessage(msg))
 			if err != nil {
 				logger.Warningf("Client %s write error: %s", conn.RemoteAddr().String(), err.Error())
 				running = false
@@ -282,7 +322,7 @@ sendFinish:
 		case r := <-client.res:
 
 			// 将主线程的返回值写入到 socket 中
-			_, err := conn.Write((*r).ToBytes())
+			_, err := conn.Write(client.encode(*r))
 
 			if err != nil {
 				logger.Warning("Client", client.id, "write Error")
@@ -310,6 +350,9 @@ func (s *Server) eventLoop() {
 		// 每一次循环都更新一次全局时钟
 		global.UpdateGlobalClock()
 
+		// 暂停结束后执行被延后的命令
+		s.resumePostponed()
+
 		select {
 
 		case e := <-config.ConfWatcher.Notification():
@@ -328,65 +371,7 @@ func (s *Server) eventLoop() {
 
 		case event := <-s.events:
 
-			startTs := global.RealTime()
-
-			cli := event.cli
-			logger.Debug("EventLoop: New Event From Client", cli.id.String())
-
-			// 底层发生异常，需要关闭客户端，或者客户端已经关闭了，那么就不处理请求了
-			if cli.status == ERROR || cli.status == EXIT {
-				// 释放客户端资源
-				s.shutdownClient(cli)
-				continue
-			}
-
-			// 用于判断是否为新连接
-			if s.clis.AddClientIfNotExist(cli) {
-				logger.Debug("EventLoop: New Client", cli.id.String())
-			}
-
-			// 更新时间戳
-			cli.UpdateTimestamp(global.Now)
-
-			// monitor
-			s.monitors.NotifyAll(event)
-
-			// 执行命令
-			res, isWriteCommand := ExecCommand(s, cli, event.cmd, event.raw)
-
-			endTs := global.RealTime()
-
-			// slow log
-			if config.Conf.SlowLogSlowerThan >= 0 {
-				// this is a slow command
-				if d := endTs.Sub(startTs).Microseconds(); d >= config.Conf.SlowLogSlowerThan {
-					s.slowlog.appendEntry(event.cmd, d)
-				}
-			}
-
-			if res == nil {
-				continue
-			}
-
-			// 只有写命令需要完成aof持久化
-			if isWriteCommand && fmt.Sprintf("%T", res) != "*resp.ErrorData" {
-
-				if event.pipelined {
-					event.raw = resp.PlainDataToResp(event.cmd).ToBytes()
-				}
-
-				s.appendAOF(event)
-				s.updateReplicaStatus(event)
-				s.dirty++
-			}
-
-			// 非阻塞状态的客户端写入回包
-			if !cli.blocked {
-				cli.res <- &res
-			}
-
-			// 归还
-			ePool.putEvent(event)
+			s.handleEvent(event)
 
 		default:
 
@@ -397,6 +382,8 @@ func (s *Server) eventLoop() {
 			//}
 		}
 		s.handleEvictionNotification()
+		s.handleSlowSubscribers()
+		s.handleSnapshots()
 
 	}
 
@@ -414,8 +401,12 @@ func (s *Server) eventLoop() {
 		_ = s.uListener.Close()
 	}
 
-	// 进行数据持久化
-	s.saveData()
+	// 进行数据持久化，哨兵不保存任何数据
+	if s.sentinel != nil {
+		s.sentinel.stop()
+	} else {
+		s.saveData()
+	}
 
 	// 关闭所有的客户端协程
 	for s.clis.Size() != 0 {
@@ -428,6 +419,115 @@ func (s *Server) eventLoop() {
 	s.quitFlag <- struct{}{}
 }
 
+// handleSlowSubscribers 断开发布消息时缓冲区已满的订阅者
+func (s *Server) handleSlowSubscribers() {
+	for _, chs := range []*db.Channels{s.Chs, s.ShardChs} {
+		for _, owner := range chs.SlowSubscribers() {
+			id, err := uuid.FromString(owner)
+			if err != nil {
+				continue
+			}
+			if cli := s.findClient(id); cli != nil {
+				cli.closeSlowConsumer()
+			}
+		}
+	}
+}
+
+// handleEvent 执行客户端的一条命令，并完成持久化、主从复制以及回包
+func (s *Server) handleEvent(event *Event) {
+
+	startTs := global.RealTime()
+
+	cli := event.cli
+	logger.Debug("EventLoop: New Event From Client", cli.id.String())
+
+	// 底层发生异常，需要关闭客户端，或者客户端已经关闭了，那么就不处理请求了
+	if cli.status == ERROR || cli.status == EXIT {
+		// 释放客户端资源
+		s.shutdownClient(cli)
+		return
+	}
+
+	// 用于判断是否为新连接
+	if s.clis.AddClientIfNotExist(cli) {
+		logger.Debug("EventLoop: New Client", cli.id.String())
+		s.sts.totalConnections++
+	}
+
+	// 暂停期间的命令需要延后执行
+	if s.postponeIfPaused(event) {
+		return
+	}
+
+	// 更新时间戳
+	cli.UpdateTimestamp(global.Now)
+	if len(event.cmd) > 0 {
+		cli.lastCmd = strings.ToLower(string(event.cmd[0]))
+	}
+
+	// monitor
+	s.monitors.NotifyAll(event)
+
+	// CLIENT REPLY SKIP 只对下一条命令生效
+	skipReply := cli.replySkip
+	cli.replySkip = false
+
+	// 执行命令
+	res, isWriteCommand := ExecCommand(s, cli, event.cmd, event.raw)
+	s.sts.totalCommands++
+
+	// 从节点需要统计主节点发送的所有数据，包括 select 等非写命令，保证 offset 与主节点的 backlog 一致
+	if s.role == Slave && cli == s.Master {
+		s.offset += uint64(len(event.raw))
+	}
+
+	endTs := global.RealTime()
+
+	// slow log
+	if config.Conf.SlowLogSlowerThan >= 0 {
+		// this is a slow command
+		if d := endTs.Sub(startTs).Microseconds(); d >= config.Conf.SlowLogSlowerThan {
+			s.slowlog.appendEntry(event.cmd, d)
+		}
+	}
+
+	if res == nil {
+		return
+	}
+
+	// 只有写命令需要完成aof持久化
+	if isWriteCommand && fmt.Sprintf("%T", res) != "*resp.ErrorData" {
+
+		if cmd := deterministicCommand(s.dbs[cli.dbSeq], event.cmd); cmd != nil {
+			// 依赖执行时刻的命令需要改写为确定的命令
+			event.raw = resp.PlainDataToResp(cmd).ToBytes()
+		} else if event.pipelined {
+			event.raw = resp.PlainDataToResp(event.cmd).ToBytes()
+		}
+
+		s.appendAOF(event)
+		s.updateReplicaStatus(event)
+		s.dirty++
+	}
+
+	// 被当前命令唤醒的客户端可能产生了需要传播的写操作
+	s.propagateDeferred()
+
+	// appendfsync 为 always 时每一条写命令都需要刷盘
+	if isWriteCommand && s.aofEnabled && config.Conf.AppendFsync == "always" {
+		s.aof.flush()
+	}
+
+	// 非阻塞状态的客户端写入回包
+	if !cli.blocked && !cli.replyOff && !skipReply {
+		cli.res <- &res
+	}
+
+	// 归还
+	ePool.putEvent(event)
+}
+
 // acceptLoop 运行 Acceptor
 func (s *Server) acceptLoop(listener net.Listener) {
 
@@ -458,6 +558,13 @@ func (s *Server) shutdownClient(cli *Client) {
 	// 释放客户端资源
 	logger.Debug("EventLoop: Remove Closed Client", cli.id.String())
 	cli.UnSubscribeAll(s.Chs)
+	cli.SUnSubscribeAll(s.ShardChs)
+	if cli.blocked {
+		for _, dataBase := range s.dbs {
+			dataBase.UnregisterBlocked(cli.id)
+		}
+	}
+	s.disableTracking(cli)
 	s.clis.RemoveClient(cli)
 	if cli.monitored {
 		s.monitors.RemoveMonitor(cli)
@@ -488,11 +595,24 @@ func (s *Server) initTimeEvents() {
 			// 抽样 20 个，如果有 5 个过期，则再次删除
 			for dataBase.CleanExpiredKeys(20) >= 5 {
 			}
+			// 推进渐进式 rehash，避免长时间没有写入的数据库一直保留旧分片
+			dataBase.ActiveRehash(100)
 		}
 
 	}, time.Now().Add(global.TEExpireKey).Unix(), global.TEExpireKey,
 	))
 
+	// 阻塞客户端超时检查
+	s.tl.AddTimeEvent(NewPeriodTimeEvent(func() {
+		logger.Debug("TimeEvent: Clean Timeout Blocked Clients")
+
+		for _, dataBase := range s.dbs {
+			dataBase.CleanTimeoutBlocked()
+		}
+
+	}, time.Now().Add(global.TEBlocked).Unix(), global.TEBlocked,
+	))
+
 	// AOF 刷盘
 	s.tl.AddTimeEvent(NewPeriodTimeEvent(func() {
 		logger.Debug("TimeEvent: AOF FLUSH")
@@ -505,11 +625,22 @@ func (s *Server) initTimeEvents() {
 	}, time.Now().Add(global.TEAOF).Unix(), global.TEAOF,
 	))
 
+	// AOF 重写检查
+	s.tl.AddTimeEvent(NewPeriodTimeEvent(func() {
+		logger.Debug("TimeEvent: AOF Rewrite Check")
+
+		s.checkAOFRewrite()
+
+	}, time.Now().Add(global.TEAOFRewrite).Unix(), global.TEAOFRewrite,
+	))
+
 	// bgsave 持久化 trigger
 	s.tl.AddTimeEvent(NewPeriodTimeEvent(func() {
 		logger.Debug("TimeEvent: RDB Check")
 
-		if !s.aofEnabled && (s.dirty > 100 || global.Now.Unix()-s.checkPoint > 10) {
+		s.checkRDB()
+
+		if !s.aofEnabled && (s.dirty > 100 || (s.dirty > 0 && global.Now.Unix()-s.checkPoint > 10)) {
 			s.BGRDB()
 		}
 
@@ -542,6 +673,17 @@ func (s *Server) initTimeEvents() {
 
 	}, time.Now().Add(global.TECluster).Unix(), global.TECluster,
 	))
+
+	// 哨兵相关操作
+	if s.sentinel != nil {
+		s.tl.AddTimeEvent(NewPeriodTimeEvent(func() {
+			logger.Debug("TimeEvent: Sentinel")
+
+			s.sentinel.cron()
+
+		}, time.Now().Add(global.TESentinel).Unix(), global.TESentinel,
+		))
+	}
 }
 
 func (s *Server) Start() {
@@ -614,7 +756,7 @@ func (s *Server) Start() {
 		go s.acceptLoop(s.uListener)
 	}
 
-	q := make(chan os.Signal)
+	q := make(chan os.Signal, 1)
 
 	signal.Notify(q, syscall.SIGINT, syscall.SIGTERM) // 接受软中断信号并且传递到 channel
 
@@ -647,10 +789,19 @@ func (s *Server) saveData() {
 	// 优先使用 aof 进行存储
 	if s.aofEnabled && s.aof != nil {
 
+		// 等待正在进行的重写完成，防止重写期间的命令丢失
+		if s.aofRewriting {
+			s.completeSnapshots()
+			s.finishAOFRewrite(<-s.aofRewriteDone)
+		}
+
 		s.aof.quit()
 
 	} else {
 
+		// 等待正在进行的 bgsave 完成
+		s.completeRDB()
+
 		ok := s.RDB(path.Join(s.dir, s.rdbFile))
 		if !ok {
 			logger.Error("quit: Generate RDB File Failed")
@@ -730,7 +881,7 @@ func (s *Server) handleReadWithoutGoroutine(conn net.Conn) {
 		r := <-client.res
 
 		// 将主线程的返回值写入到 socket 中
-		_, err := conn.Write((*r).ToBytes())
+		_, err := conn.Write(client.encode(*r))
 
 		if err != nil {
 			logger.Warning("Client", client.id, "write Error")
@@ -85,6 +85,11 @@ func (sl *slowLog) clear() {
 	sl.nid = 0
 }
 
+// resize 修改慢查询日志的最大记录数，超出的旧记录会被删除
+func (sl *slowLog) resize(max int) {
+	sl.cl.SetMax(max)
+}
+
 func (sl *slowLog) Cost() int64 {
 	return sl.cl.Cost() + 16
 }
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"github.com/tangrc99/MemTable/db"
+)
+
+// snapshotStepKeys 是每一次事件循环中一个快照最多写入的键数量
+const snapshotStepKeys = 128
+
+// snapshotJob 代表全部数据库在某一时刻的快照。快照在事件循环中增量地生成，被访问的键会在访问前写入快照，
+// 因此 BGSAVE 与 AOF 重写不需要在事件循环中一次性序列化全部数据，也能得到一致的快照
+type snapshotJob struct {
+	snaps []*db.Snapshot
+	next  int             // 当前正在遍历的数据库
+	done  func(err error) // 快照结束后在事件循环中调用，err 不为 nil 代表快照生成失败
+	ended bool
+}
+
+// beginSnapshot 为全部数据库开始生成快照，键值对通过 writer 序列化，快照结束后会调用 done
+func (s *Server) beginSnapshot(writer db.SnapshotWriter, done func(err error)) *snapshotJob {
+
+	job := &snapshotJob{
+		snaps: make([]*db.Snapshot, len(s.dbs)),
+		done:  done,
+	}
+	// 所有数据库的快照必须同时开始
+	for i, dataBase := range s.dbs {
+		job.snaps[i] = dataBase.BeginSnapshot(i, writer)
+	}
+
+	s.snapshots = append(s.snapshots, job)
+	return job
+}
+
+// step 将最多 count 个键写入快照，返回快照是否已经结束
+func (job *snapshotJob) step(count int) bool {
+
+	if job.ended {
+		return true
+	}
+
+	for job.next < len(job.snaps) {
+
+		snap := job.snaps[job.next]
+		if !snap.Step(count) {
+			return false
+		}
+		if err := snap.Err(); err != nil {
+			job.end(err)
+			return true
+		}
+		job.next++
+	}
+
+	job.end(nil)
+	return true
+}
+
+// complete 在当前调用中完成快照，用于需要立即得到结果的场景
+func (job *snapshotJob) complete() {
+	for !job.step(1 << 16) {
+	}
+}
+
+// end 结束快照，出现错误时其他数据库的快照会被放弃
+func (job *snapshotJob) end(err error) {
+	job.ended = true
+	for _, snap := range job.snaps {
+		snap.Abort()
+	}
+	job.done(err)
+}
+
+// handleSnapshots 推进所有正在生成的快照，并移除已经结束的快照
+func (s *Server) handleSnapshots() {
+
+	n := len(s.snapshots)
+	if n == 0 {
+		return
+	}
+
+	jobs := make([]*snapshotJob, 0, n)
+	for _, job := range s.snapshots[:n] {
+		if !job.step(snapshotStepKeys) {
+			jobs = append(jobs, job)
+		}
+	}
+	// 结束回调中可能开始新的快照
+	s.snapshots = append(jobs, s.snapshots[n:]...)
+}
+
+// completeSnapshots 完成所有正在生成的快照
+func (s *Server) completeSnapshots() {
+	for len(s.snapshots) > 0 {
+		job := s.snapshots[0]
+		s.snapshots = s.snapshots[1:]
+		job.complete()
+	}
+}
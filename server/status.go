@@ -5,6 +5,7 @@ import (
 	"github.com/tangrc99/MemTable/config"
 	"github.com/tangrc99/MemTable/server/global"
 	"github.com/tangrc99/MemTable/utils/sys_status"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -36,6 +37,10 @@ type Status struct {
 	backlogSize     uint64
 	backlogOffset   int
 
+	// Stats Section
+	totalConnections int64 // 接收的连接总数
+	totalCommands    int64 // 执行的命令总数
+
 	// Keyspace
 
 	sys_status.SysStatus
@@ -61,6 +66,12 @@ func NewStatus() *Status {
 	return s
 }
 
+// resetStat 重置统计计数器，用于 CONFIG RESETSTAT
+func (sts *Status) resetStat() {
+	sts.totalConnections = 0
+	sts.totalCommands = 0
+}
+
 // UpdateStatus 更新服务器的状态
 func (s *Server) UpdateStatus() {
 	sts := s.sts
@@ -122,6 +133,16 @@ func (s *Server) Information(section string) string {
 
 	}
 
+	if section == "" || section == "stats" {
+		// 与上一 section 保持空格
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("# Stats\n")
+		b.WriteString(fmt.Sprintf("total_connections_received:%d\n", s.sts.totalConnections))
+		b.WriteString(fmt.Sprintf("total_commands_processed:%d\n", s.sts.totalCommands))
+	}
+
 	if section == "" || section == "system" {
 		// 与上一 section 保持空格
 		if b.Len() > 0 {
@@ -143,23 +164,52 @@ func (s *Server) Information(section string) string {
 		}
 		b.WriteString("# Replication\n")
 		switch s.Role() {
-		case StandAlone:
-			b.WriteString("role: standalone")
-		case Master:
-			b.WriteString("role: master")
+		case StandAlone, Master:
+			// 没有从节点的主节点与 Redis 一致，同样报告为 master
+			b.WriteString("role:master\n")
 		case Slave:
-			b.WriteString("role: slave")
-
+			b.WriteString("role:slave\n")
+			if s.Master != nil {
+				host, port, _ := net.SplitHostPort(s.Master.cnn.RemoteAddr().String())
+				b.WriteString(fmt.Sprintf("master_host:%s\n", host))
+				b.WriteString(fmt.Sprintf("master_port:%s\n", port))
+			}
+			if s.masterAlive {
+				b.WriteString("master_link_status:up\n")
+			} else {
+				b.WriteString("master_link_status:down\n")
+			}
+			b.WriteString(fmt.Sprintf("slave_repl_offset:%d\n", s.offset))
+		}
+		b.WriteString(fmt.Sprintf("connected_slaves:%d\n", len(s.onLineSlaves)))
+		// 只有告知了服务端口的从节点可以被哨兵发现
+		i := 0
+		for cli := range s.onLineSlaves {
+			if cli.listeningPort == 0 {
+				continue
+			}
+			host, _, _ := net.SplitHostPort(cli.cnn.RemoteAddr().String())
+			b.WriteString(fmt.Sprintf("slave%d:ip=%s,port=%d,state=online,offset=%d\n", i, host, cli.listeningPort, cli.ackOffset))
+			i++
 		}
-		b.WriteString(fmt.Sprintf("connected_slaves: %d", len(s.onLineSlaves)))
-		b.WriteString(fmt.Sprintf("backlog_size: %d", s.backLog.Capacity()))
+		b.WriteString(fmt.Sprintf("master_replid:%s\n", s.runID))
+		b.WriteString(fmt.Sprintf("master_repl_offset:%d\n", s.offset))
+		b.WriteString(fmt.Sprintf("backlog_size:%d\n", s.backLog.Capacity()))
 		if s.Role() == StandAlone {
-			b.WriteString("backlog_offset: -1")
+			b.WriteString("backlog_offset:-1\n")
 		} else {
-			b.WriteString(fmt.Sprintf("backlog_offset: %d", s.backLog.LowWaterLevel()))
+			b.WriteString(fmt.Sprintf("backlog_offset:%d\n", s.backLog.LowWaterLevel()))
 		}
 
 	}
 
+	if s.sentinel != nil && (section == "" || section == "sentinel") {
+		// 与上一 section 保持空格
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		s.sentinel.information(&b)
+	}
+
 	return b.String()
 }
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"github.com/gofrs/uuid"
+	"github.com/tangrc99/MemTable/resp"
+	"github.com/tangrc99/MemTable/server/global"
+)
+
+// trackingChannel 是 RESP2 客户端接收失效通知的频道，需要配合 REDIRECT 选项使用
+const trackingChannel = "__redis__:invalidate"
+
+// trackingReader 返回执行命令 c 时需要记录读取键的客户端，只有默认模式下的只读命令需要记录
+func (cli *Client) trackingReader(c global.Command) uuid.UUID {
+	if !cli.tracking || cli.trackingBcast || c.IsWriteCommand() {
+		return uuid.Nil
+	}
+	return cli.id
+}
+
+// findClient 根据 uuid 查找客户端
+func (s *Server) findClient(id uuid.UUID) *Client {
+	node, exist := s.clis.UUIDSet[id]
+	if !exist {
+		return nil
+	}
+	cli, _ := node.Value.(*Client)
+	return cli
+}
+
+// pushMessage 向客户端发送一条服务端主动推送的消息，缓冲区已满时断开客户端的连接
+func pushMessage(cli *Client, msg []resp.RedisData) {
+	cli.initSubscription()
+	select {
+	case cli.msg <- cli.encode(resp.MakePushData(msg)):
+	default:
+		cli.closeSlowConsumer()
+	}
+}
+
+// sendInvalidation 向开启追踪的客户端 id 发送 key 的失效通知，key 为 nil 代表需要清空所有缓存。
+// RESP3 客户端以 push 类型接收通知，RESP2 客户端需要订阅 __redis__:invalidate 频道
+func (s *Server) sendInvalidation(id uuid.UUID, key []byte) {
+
+	cli := s.findClient(id)
+	if cli == nil || !cli.tracking {
+		return
+	}
+
+	var keys resp.RedisData = resp.MakeNullData()
+	if key != nil {
+		keys = resp.MakeArrayData([]resp.RedisData{resp.MakeBulkData(key)})
+	}
+
+	target := cli
+	if cli.trackingRedirect != nil {
+		target = cli.trackingRedirect
+		if target.status == EXIT || target.status == ERROR {
+			// 接收者已经断开连接，需要通知客户端
+			if cli.protocol == resp.RESP3 {
+				pushMessage(cli, []resp.RedisData{
+					resp.MakeBulkData([]byte("tracking-redir-broken")), resp.MakeIntData(target.seq),
+				})
+			}
+			return
+		}
+	}
+
+	if target.protocol == resp.RESP3 {
+		pushMessage(target, []resp.RedisData{resp.MakeBulkData([]byte("invalidate")), keys})
+		return
+	}
+
+	if _, subscribed := target.chs[trackingChannel]; subscribed {
+		pushMessage(target, []resp.RedisData{
+			resp.MakeBulkData([]byte("message")), resp.MakeBulkData([]byte(trackingChannel)), keys,
+		})
+	}
+}
+
+// disableTracking 关闭客户端的追踪，广播模式下注册的前缀会被移除
+func (s *Server) disableTracking(cli *Client) {
+	if !cli.tracking {
+		return
+	}
+	s.tracking.Untrack(cli.id, cli.trackingPrefixes)
+	cli.tracking = false
+	cli.trackingBcast = false
+	cli.trackingPrefixes = nil
+	cli.trackingRedirect = nil
+}
@@ -0,0 +1,12 @@
+package utils
+
+import "hash/crc64"
+
+// crc64JonesTable 是 redis 使用的 crc-64-jones 多项式对应的反转表
+var crc64JonesTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// CRC64 计算与 redis 兼容的 crc-64-jones 校验和，用于 DUMP 与 RESTORE 的负载校验。
+// redis 的实现不会对初始值与结果取反，所以需要抵消标准库中的取反操作
+func CRC64(crc uint64, data []byte) uint64 {
+	return ^crc64.Update(^crc, crc64JonesTable, data)
+}
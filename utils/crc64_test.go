@@ -0,0 +1,14 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCRC64(t *testing.T) {
+	// redis 源码中 crc64 的测试向量
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), CRC64(0, []byte("123456789")))
+
+	// 分段计算的结果与整体计算一致
+	assert.Equal(t, CRC64(0, []byte("123456789")), CRC64(CRC64(0, []byte("1234")), []byte("56789")))
+}
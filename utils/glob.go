@@ -0,0 +1,94 @@
+package utils
+
+// GlobMatch 判断 str 是否匹配 glob 风格的 pattern，语法与 redis 保持一致：
+// '*' 匹配任意长度的字符串，'?' 匹配单个字符，'[abc]'、'[^a]'、'[a-z]' 匹配字符集合，'\' 用于转义
+func GlobMatch(pattern, str string) bool {
+	return globMatch([]byte(pattern), []byte(str))
+}
+
+func globMatch(pattern, str []byte) bool {
+
+	for len(pattern) > 0 {
+
+		switch pattern[0] {
+		case '*':
+			// 合并连续的 '*'
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(str); i++ {
+				if globMatch(pattern[1:], str[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+			str = str[1:]
+
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == str[0] {
+						match = true
+					}
+				} else if len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']' {
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					if str[0] >= start && str[0] <= end {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == str[0] {
+					match = true
+				}
+				pattern = pattern[1:]
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+			if len(pattern) == 0 {
+				// 缺少 ']' 时视为匹配到结尾
+				return len(str) == 0
+			}
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+			str = str[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(str) == 0
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGlobMatch(t *testing.T) {
+
+	tests := []struct {
+		pattern  string
+		str      string
+		expected bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello world", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{"user:*:name", "user:1000:name", true},
+		{"user:*:name", "user:1000:age", false},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"a**b", "axxb", true},
+		{"", "", true},
+		{"", "a", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, GlobMatch(test.pattern, test.str), test.pattern+" "+test.str)
+	}
+}
@@ -38,12 +38,11 @@ var crc16tab = [256]uint32{0x0000, 0x1021, 0x2042, 0x3063, 0x4084, 0x50a5, 0x60c
 // HashKey 使用和 redis 中相同的算法，该算法只会计算第一个 {} 之间的 crc16 值
 func HashKey(key string) int {
 
-	start := strings.Index(key, "{")
-	end := strings.Index(key, "}")
-
-	// there is a prefix
-	if start >= 0 && end-start > 1 {
-		key = key[start+1 : end]
+	// there is a prefix，与 redis 相同，只查找第一个 { 之后的第一个 }
+	if start := strings.Index(key, "{"); start >= 0 {
+		if end := strings.Index(key[start+1:], "}"); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
 	}
 
 	counter := 0
@@ -21,3 +21,22 @@ func TestHashKeyPrefix(t *testing.T) {
 		t.Errorf("prefix hash: hash3 %d == hash4 %d", hash3, hash4)
 	}
 }
+
+func TestHashKeyRedisCompatible(t *testing.T) {
+
+	// redis 中 crc16 的测试向量
+	if crc := HashKey("123456789"); crc != 0x31c3 {
+		t.Errorf("crc16: %x != 31c3", crc)
+	}
+
+	// } 出现在 { 之前时，需要查找 { 之后的 }
+	if HashKey("a}b{c}d") != HashKey("c") {
+		t.Errorf("prefix hash: a}b{c}d should hash as c")
+	}
+	if HashKey("{a}{b}") != HashKey("a") {
+		t.Errorf("prefix hash: {a}{b} should hash as a")
+	}
+	if HashKey("foo{}{bar}") == HashKey("bar") {
+		t.Errorf("prefix hash: foo{}{bar} should hash as the whole key")
+	}
+}
@@ -4,6 +4,7 @@ package ring_buffer
 type RingBuffer struct {
 	buffer   []byte
 	offset   uint64
+	start    uint64 // 缓冲区中第一个字节的序列号，小于该序列号的内容不在缓冲区中
 	capacity uint64
 }
 
@@ -20,14 +21,25 @@ func (b *RingBuffer) Init(capacity uint64) {
 	b.capacity = capacity
 	b.buffer = make([]byte, capacity, capacity)
 	b.offset = 0
+	b.start = 0
+}
+
+// Reset 清空缓冲区中的内容，之后写入的内容从 offset 开始编号
+func (b *RingBuffer) Reset(offset uint64) {
+	b.offset = offset
+	b.start = offset
 }
 
 // LowWaterLevel 返回环形缓冲区中保留的最小序列号
 func (b *RingBuffer) LowWaterLevel() uint64 {
-	if b.offset < b.capacity {
-		return 0
+	low := uint64(0)
+	if b.offset >= b.capacity {
+		low = b.offset - b.capacity
+	}
+	if low < b.start {
+		return b.start
 	}
-	return b.offset - b.capacity
+	return low
 }
 
 // HighWaterLevel 返回环形缓冲区中保留的最大序列号
@@ -56,3 +56,23 @@ func TestRingBufferLargerThanCap(t *testing.T) {
 	assert.Equal(t, []byte("1111222222222333"), bytes)
 
 }
+
+func TestRingBufferReset(t *testing.T) {
+	r := RingBuffer{}
+	r.Init(8)
+	r.Append([]byte("1111"))
+
+	// 重置后之前的内容不可读，序列号从 offset 继续增长
+	r.Reset(100)
+	assert.Equal(t, uint64(100), r.LowWaterLevel())
+	assert.Equal(t, uint64(100), r.HighWaterLevel())
+	assert.Equal(t, []byte{}, r.ReadSince(100))
+
+	assert.Equal(t, uint64(103), r.Append([]byte("abc")))
+	assert.Equal(t, uint64(100), r.LowWaterLevel())
+	assert.Equal(t, []byte("bc"), r.ReadSince(101))
+
+	r.Append([]byte("defghi"))
+	assert.Equal(t, uint64(101), r.LowWaterLevel())
+	assert.Equal(t, []byte("bcdefghi"), r.ReadSince(r.LowWaterLevel()))
+}